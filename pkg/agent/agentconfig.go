@@ -0,0 +1,293 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/tunnel"
+)
+
+const statusTimeout = 5 * time.Second
+
+// agentConfigSource gets config of agent from its AgentConfig through API and writes it to config
+// files, so it's loaded and cached the same way as files of configmap volume. The result of
+// applying tunnels config is reported in status of the AgentConfig
+type agentConfigSource struct {
+	key    client.ObjectKey
+	cache  cache.Cache
+	client client.Client
+	log    logr.Logger
+
+	// generation is the generation of spec written to files last time
+	generation int64
+}
+
+func newAgentConfigSource(namespace, name string, log logr.Logger) (*agentConfigSource, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err = apis.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	// the edge node may be offline when agent starts, API is not accessed until the cache is started
+	mapper, err := apiutil.NewDynamicRESTMapper(restConfig, apiutil.WithLazyDiscovery)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cache.New(restConfig, cache.Options{Scheme: scheme, Mapper: mapper, Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := client.New(restConfig, client.Options{Scheme: scheme, Mapper: mapper})
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentConfigSource{
+		key:    client.ObjectKey{Namespace: namespace, Name: name},
+		cache:  c,
+		client: cli,
+		log:    log,
+	}, nil
+}
+
+// start starts the cache and waits until it's synced, onChange is called when spec of the config is changed.
+// It can be retried if the informer can't be got, e.g. API can't be reached to discover AgentConfig
+func (s *agentConfigSource) start(ctx context.Context, onChange func()) error {
+	informer, err := s.cache.GetInformer(ctx, &apis.AgentConfig{})
+	if err != nil {
+		return err
+	}
+
+	handle := func(obj interface{}) {
+		if cfg, ok := obj.(*apis.AgentConfig); ok && cfg.Name == s.key.Name {
+			onChange()
+		}
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// status updates made by agent itself don't change generation
+			if oldObj.(*apis.AgentConfig).Generation != newObj.(*apis.AgentConfig).Generation {
+				handle(newObj)
+			}
+		},
+	})
+
+	go func() {
+		if err := s.cache.Start(ctx); err != nil {
+			s.log.Error(err, "failed to start cache of agent config")
+		}
+	}()
+
+	if !s.cache.WaitForCacheSync(ctx) {
+		return fmt.Errorf("failed to sync cache of agent config")
+	}
+
+	return nil
+}
+
+// writeFiles writes tunnels and services config in spec to their files, CA bundle is written to caFile
+// if it's provided. It returns true if any file is changed
+func (s *agentConfigSource) writeFiles(tunnelsFile, servicesFile, caFile string) (bool, error) {
+	var cfg apis.AgentConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return false, err
+	}
+
+	files := map[string]string{
+		tunnelsFile:  cfg.Spec.Tunnels,
+		servicesFile: cfg.Spec.Services,
+	}
+	if caFile != "" && cfg.Spec.CABundle != "" {
+		files[caFile] = cfg.Spec.CABundle
+	}
+
+	changed := false
+	for filename, content := range files {
+		if old, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(old, []byte(content)) {
+			continue
+		}
+
+		if err := writeFile(filename, []byte(content), 0644); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	atomic.StoreInt64(&s.generation, cfg.Generation)
+	return changed, nil
+}
+
+// report records the result of applying the config written last time, status is updated
+// only if the result is changed, so agents won't update status every sync period
+func (s *agentConfigSource) report(err error) error {
+	// nothing is written yet, e.g. the cache is not synced
+	generation := atomic.LoadInt64(&s.generation)
+	if generation == 0 {
+		return nil
+	}
+
+	var cfg apis.AgentConfig
+	if getErr := s.cache.Get(context.Background(), s.key, &cfg); getErr != nil {
+		return getErr
+	}
+
+	// the config is changed since it's written, it will be reported after next sync
+	if cfg.Generation != generation {
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               apis.AgentConfigConditionSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            "config is applied",
+		ObservedGeneration: cfg.Generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SyncFailed"
+		condition.Message = err.Error()
+	}
+
+	old := meta.FindStatusCondition(cfg.Status.Conditions, condition.Type)
+	if old != nil && old.Status == condition.Status && old.Message == condition.Message &&
+		old.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+
+	if err == nil {
+		now := metav1.Now()
+		cfg.Status.ObservedGeneration = cfg.Generation
+		cfg.Status.LastSyncTime = &now
+	}
+	meta.SetStatusCondition(&cfg.Status.Conditions, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	return s.client.Status().Update(ctx, &cfg)
+}
+
+// reportProbes puts results of probing peers in status of the AgentConfig, the last results are replaced
+func (s *agentConfigSource) reportProbes(probes []apis.PeerProbe) error {
+	var cfg apis.AgentConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return err
+	}
+
+	cfg.Status.Probes = probes
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	return s.client.Status().Update(ctx, &cfg)
+}
+
+// reportTunnelHistory puts summaries of tunnel history in status of the AgentConfig and sets condition
+// Flapping by flapping peers, status is updated only if they are changed
+func (s *agentConfigSource) reportTunnelHistory(summaries []apis.TunnelSummary) error {
+	var cfg apis.AgentConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return err
+	}
+
+	if len(summaries) == 0 {
+		summaries = nil
+	}
+	condition := tunnel.FlappingCondition(apis.AgentConfigConditionFlapping, summaries)
+
+	old := meta.FindStatusCondition(cfg.Status.Conditions, condition.Type)
+	if old != nil && old.Status == condition.Status && old.Message == condition.Message &&
+		equality.Semantic.DeepEqual(cfg.Status.TunnelHistory, summaries) {
+		return nil
+	}
+
+	cfg.Status.TunnelHistory = summaries
+	meta.SetStatusCondition(&cfg.Status.Conditions, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	return s.client.Status().Update(ctx, &cfg)
+}
+
+// watchAgentConfig writes config files whenever AgentConfig is changed, it returns after the
+// cache of AgentConfig is synced, changes after that are handled in background
+func (m *Manager) watchAgentConfig() error {
+	return m.configSource.start(context.Background(), func() {
+		changed, err := m.configSource.writeFiles(m.TunnelsConfPath, m.ServicesConfPath, m.agentConfigCAFile())
+		if err != nil {
+			m.log.Error(err, "failed to write config files of agent config")
+			return
+		}
+
+		if changed {
+			m.log.V(3).Info("agent config is changed, start to sync")
+			m.notify()
+		}
+	})
+}
+
+// agentConfigCAFile returns where CA bundle of agent config is written, it's only needed by certificate bootstrap
+func (m *Manager) agentConfigCAFile() string {
+	if !m.CertBootstrap {
+		return ""
+	}
+	return m.BootstrapCAFile
+}
+
+// reportTo wraps a sync task, its result is reported in status of agent config. Nothing is
+// reported while the edge node is offline
+func (m *Manager) reportTo(fn func() error) func() error {
+	if m.configSource == nil {
+		return fn
+	}
+
+	return func() error {
+		err := fn()
+		if !m.isOffline() {
+			if reportErr := m.configSource.report(err); reportErr != nil {
+				m.log.Error(reportErr, "failed to report status of agent config")
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const (
+	TableMangle           = "mangle"
+	ChainFabEdgeBandwidth = "FABEDGE-BANDWIDTH"
+
+	// bandwidth rules are told apart by these bits of packet mark, the mark is kept
+	// when packets are encrypted, so ESP packets are classified by it too
+	bandwidthMarkMask  = 0xff0000
+	bandwidthMarkShift = 16
+	maxBandwidthRules  = 0xff
+)
+
+// ensureBandwidthRules caps traffic to CIDRs of bandwidth rules. Packets are marked by the index
+// of their rule in mangle table, then put in a HTB class of the rule on the tunnel interface,
+// which is the xfrm interface if it's used, otherwise the interface of default route.
+// Traffic which matches no rules is not shaped
+func (m *Manager) ensureBandwidthRules(conf netconf.NetworkConf) error {
+	rules := conf.BandwidthRules
+	if len(rules) > maxBandwidthRules {
+		m.log.Info("too many bandwidth rules, the rest are ignored", "rules", len(rules), "max", maxBandwidthRules)
+		rules = rules[:maxBandwidthRules]
+	}
+
+	// rules may be left by last run of agent, so they are removed even if none is applied
+	if len(rules) == 0 {
+		return m.removeBandwidthRules()
+	}
+
+	iface, err := m.getBandwidthInterface()
+	if err != nil {
+		m.log.Error(err, "failed to get interface of bandwidth rules")
+		return err
+	}
+
+	exists, err := m.ipt.Exists(TableMangle, ChainPostRouting, "-j", ChainFabEdgeBandwidth)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableMangle, "chain", ChainPostRouting)
+		return err
+	}
+
+	if exists && iface == m.bandwidthInterface && reflect.DeepEqual(rules, m.bandwidthRules) {
+		return nil
+	}
+
+	m.log.V(3).Info("update bandwidth rules", "interface", iface, "rules", len(rules))
+	if m.bandwidthInterface != "" && m.bandwidthInterface != iface {
+		if err = deleteBandwidthQdisc(m.bandwidthInterface); err != nil {
+			m.log.Error(err, "failed to delete qdisc", "interface", m.bandwidthInterface)
+		}
+	}
+
+	if err = m.ipt.ClearChain(TableMangle, ChainFabEdgeBandwidth); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableMangle, "chain", ChainFabEdgeBandwidth)
+		return err
+	}
+
+	for i, rule := range rules {
+		mark := fmt.Sprintf("%#x/%#x", bandwidthMark(i), bandwidthMarkMask)
+		for _, cidr := range rule.CIDRs {
+			if err = m.ipt.Append(TableMangle, ChainFabEdgeBandwidth, "-d", cidr, "-j", "MARK", "--set-xmark", mark); err != nil {
+				m.log.Error(err, "failed to append rule", "table", TableMangle, "chain", ChainFabEdgeBandwidth, "rule", strings.Join([]string{"-d", cidr, "-j", "MARK", "--set-xmark", mark}, " "))
+				return err
+			}
+		}
+	}
+
+	if err = m.ipt.AppendUnique(TableMangle, ChainPostRouting, "-j", ChainFabEdgeBandwidth); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableMangle, "chain", ChainPostRouting, "rule", fmt.Sprintf("-j %s", ChainFabEdgeBandwidth))
+		return err
+	}
+
+	if err = setBandwidthQdisc(iface, rules); err != nil {
+		m.log.Error(err, "failed to set qdisc", "interface", iface)
+		return err
+	}
+
+	m.bandwidthInterface = iface
+	m.bandwidthRules = rules
+	return nil
+}
+
+func (m *Manager) getBandwidthInterface() (string, error) {
+	if m.UseXFRM {
+		return m.XFRMInterfaceName, nil
+	}
+
+	// ESP packets go out by the WAN interface
+	return m.getFirewallInterface()
+}
+
+// removeBandwidthRules removes iptables rules and qdisc of bandwidth rules
+func (m *Manager) removeBandwidthRules() error {
+	if err := m.deleteRuleIfExists(TableMangle, ChainPostRouting, "-j", ChainFabEdgeBandwidth); err != nil {
+		return err
+	}
+
+	exists, err := m.ipt.ChainExists(TableMangle, ChainFabEdgeBandwidth)
+	if err != nil {
+		m.log.Error(err, "failed to check chain", "table", TableMangle, "chain", ChainFabEdgeBandwidth)
+		return err
+	}
+
+	// nothing is left if neither the chain nor the interface is known
+	if !exists && m.bandwidthInterface == "" {
+		return nil
+	}
+
+	if exists {
+		if err = m.ipt.ClearAndDeleteChain(TableMangle, ChainFabEdgeBandwidth); err != nil {
+			m.log.Error(err, "failed to delete chain", "table", TableMangle, "chain", ChainFabEdgeBandwidth)
+			return err
+		}
+	}
+
+	iface := m.bandwidthInterface
+	if iface == "" {
+		if iface, err = m.getBandwidthInterface(); err != nil {
+			m.log.Error(err, "failed to get interface of bandwidth rules")
+			return err
+		}
+	}
+
+	if err = deleteBandwidthQdisc(iface); err != nil {
+		m.log.Error(err, "failed to delete qdisc", "interface", iface)
+		return err
+	}
+
+	m.bandwidthInterface = ""
+	m.bandwidthRules = nil
+	return nil
+}
+
+// setBandwidthQdisc replaces the root qdisc of iface with a HTB qdisc which has a class for each rule,
+// packets are put in classes by their marks
+func setBandwidthQdisc(iface string, rules []netconf.BandwidthRule) error {
+	if err := deleteBandwidthQdisc(iface); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return err
+	}
+	index := link.Attrs().Index
+	root := netlink.MakeHandle(1, 0)
+
+	qdisc := netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: index,
+		Handle:    root,
+		Parent:    netlink.HANDLE_ROOT,
+	})
+	if err = netlink.QdiscReplace(qdisc); err != nil {
+		return err
+	}
+
+	for i, rule := range rules {
+		classID := netlink.MakeHandle(1, uint16(i+1))
+		class := netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: index,
+			Parent:    root,
+			Handle:    classID,
+		}, netlink.HtbClassAttrs{
+			Rate: uint64(rule.Rate),
+			Ceil: uint64(rule.Rate),
+		})
+		if err = netlink.ClassReplace(class); err != nil {
+			return err
+		}
+
+		filter := &netlink.Fw{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    root,
+				Handle:    bandwidthMark(i),
+				Priority:  1,
+				Protocol:  unix.ETH_P_ALL,
+			},
+			ClassId: classID,
+			Mask:    bandwidthMarkMask,
+		}
+		if err = netlink.FilterReplace(filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteBandwidthQdisc deletes the root qdisc made by setBandwidthQdisc, classes and
+// filters are deleted with it and the default qdisc of iface is back
+func deleteBandwidthQdisc(iface string) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent == netlink.HANDLE_ROOT && attrs.Handle == netlink.MakeHandle(1, 0) && qdisc.Type() == "htb" {
+			return netlink.QdiscDel(qdisc)
+		}
+	}
+
+	return nil
+}
+
+func bandwidthMark(index int) uint32 {
+	return uint32(index+1) << bandwidthMarkShift
+}
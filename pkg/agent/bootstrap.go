@@ -0,0 +1,201 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+// bootstrapCertFile is where the bootstrapped certificate is saved, operator passes it by --local-cert
+var bootstrapCertFile = filepath.Join(ipsecCertsDir, "tls.crt")
+
+// bootstrapCert gets a certificate from API server of operator by the service account token of agent pod
+// if there is no valid certificate or less than a third of its validity period remains. A new key is
+// generated for each certificate. The key, the certificate and CA certificates are saved in /etc/ipsec.d
+// which is an emptyDir volume shared with strongswan, so no TLS secret is needed. The certificate and
+// the key are in different directories and can't be replaced together, the certificate is saved first
+// and the key last, a certificate which doesn't match the key is bootstrapped again
+func (m *Manager) bootstrapCert() error {
+	caPEM, err := ioutil.ReadFile(m.BootstrapCAFile)
+	if err != nil {
+		return err
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no CA cert is found in %s", m.BootstrapCAFile)
+	}
+
+	caChanged := !bytes.Equal(caPEM, m.bootstrapCAPEM)
+	if caChanged {
+		if err = writeFile(ipsecCAFile, caPEM, 0644); err != nil {
+			return err
+		}
+	}
+
+	if m.isBootstrapCertValid(certPool) {
+		if caChanged {
+			m.bootstrapCAPEM = caPEM
+			m.log.V(3).Info("CA certificates are changed")
+			m.notify()
+		}
+		return nil
+	}
+
+	conf, err := m.loadNetworkConf()
+	if err != nil {
+		return err
+	}
+
+	keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
+		CommonName: conf.Name,
+		URIs:       certutil.URIsOfID(conf.ID),
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := ioutil.ReadFile(m.BootstrapTokenFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := fclient.BootstrapAgentCert(m.APIServerAddress, string(bytes.TrimSpace(token)), csr, certPool)
+	if err != nil {
+		return err
+	}
+
+	// if the agent stops between them, the new certificate doesn't match the old key,
+	// which is found by loadBootstrapKeyPair
+	if err = writeFile(bootstrapCertFile, cert.PEM, 0644); err != nil {
+		return err
+	}
+
+	if err = writeFile(ipsecKeyFile, certutil.EncodePrivateKeyPEM(keyDER), 0600); err != nil {
+		return err
+	}
+
+	m.bootstrapCAPEM = caPEM
+	m.log.V(3).Info("certificate is bootstrapped", "notAfter", cert.Raw.NotAfter)
+	m.notify()
+
+	return nil
+}
+
+// isBootstrapCertValid checks if the saved certificate matches the saved key, is issued by CAs
+// in certPool and doesn't need renewal
+func (m *Manager) isBootstrapCertValid(certPool *x509.CertPool) bool {
+	cert, _, err := loadBootstrapKeyPair()
+	if err != nil {
+		m.log.V(3).Info("no valid certificate is bootstrapped, bootstrap again", "reason", err.Error())
+		return false
+	}
+
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots:     certPool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		m.log.V(3).Info("certificate is not issued by trusted CAs, bootstrap again", "reason", err.Error())
+		return false
+	}
+
+	return !needsRenewal(cert, time.Now())
+}
+
+// loadBootstrapCredentials loads the bootstrapped key and CA certificates into strongswan, they are
+// loaded every time because they are lost when strongswan restarts. The key isn't loaded if it
+// doesn't match the certificate, e.g. the agent stopped while bootstrapping
+func (m *Manager) loadBootstrapCredentials() error {
+	_, keyPEM, err := loadBootstrapKeyPair()
+	if err != nil {
+		return err
+	}
+
+	caPEM, err := ioutil.ReadFile(ipsecCAFile)
+	if err != nil {
+		return err
+	}
+
+	return m.tm.LoadCredentials(keyPEM, caPEM)
+}
+
+// loadBootstrapKeyPair reads the bootstrapped certificate and key, an error is returned if either
+// of them is missing or they don't match
+func loadBootstrapKeyPair() (*x509.Certificate, []byte, error) {
+	certPEM, err := ioutil.ReadFile(bootstrapCertFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("certificate is not bootstrapped yet")
+		}
+		return nil, nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(ipsecKeyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("certificate is not bootstrapped yet")
+		}
+		return nil, nil, err
+	}
+
+	certDER, err := certutil.DecodePEM(certPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := certutil.DecodePEM(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := certutil.ParsePrivateKey(keyDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !certutil.IsKeyOfCert(key, cert) {
+		return nil, nil, fmt.Errorf("bootstrapped certificate doesn't match key, it will be bootstrapped again")
+	}
+
+	return cert, keyPEM, nil
+}
+
+// writeFile writes content to a temporary file and renames it to filename, so filename is never half written
+func writeFile(filename string, content []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	tmpFile := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, content, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, filename)
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+// files of agent's TLS secret which are mounted to agent pod by operator
+const (
+	ipsecCertsDir = "/etc/ipsec.d/certs"
+	ipsecKeyFile  = "/etc/ipsec.d/private/tls.key"
+	ipsecCAFile   = "/etc/ipsec.d/cacerts/ca-bundle.crt"
+)
+
+// renewCert renews the certificate of agent by API server of operator when less than a third of
+// its validity period remains, the key is kept. API server saves the new certificate in TLS secret
+// and kubelet updates the certificate file later, then tunnels are reloaded with it.
+// If the certificate is bootstrapped, it's renewed by bootstrapCert
+func (m *Manager) renewCert() error {
+	if m.CertBootstrap {
+		return m.bootstrapCert()
+	}
+
+	if len(m.LocalCerts) == 0 {
+		return nil
+	}
+
+	certDER, err := certutil.DecodePEM(m.readLocalCerts())
+	if err != nil {
+		return err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return err
+	}
+
+	if m.renewedCert != nil && m.renewedCert.NotAfter.After(cert.NotAfter) {
+		cert = m.renewedCert
+	}
+
+	if !needsRenewal(cert, time.Now()) {
+		return nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(ipsecKeyFile)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := certutil.DecodePEM(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	key, err := certutil.ParsePrivateKey(keyDER)
+	if err != nil {
+		return err
+	}
+
+	caPEM, err := ioutil.ReadFile(ipsecCAFile)
+	if err != nil {
+		return err
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no CA cert is found in %s", ipsecCAFile)
+	}
+
+	csr, err := certutil.NewCertRequestFromKey(key, certutil.Request{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		URIs:         cert.URIs,
+	})
+	if err != nil {
+		return err
+	}
+
+	clientCert := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+	}
+	newCert, err := fclient.RenewAgentCert(m.APIServerAddress, csr, clientCert, certPool)
+	if err != nil {
+		return err
+	}
+
+	m.renewedCert = newCert.Raw
+	m.log.V(3).Info("certificate is renewed", "notAfter", newCert.Raw.NotAfter)
+
+	return nil
+}
+
+// readLocalCerts returns the content of local certificate files, nil is returned if any of them can't be read
+func (m *Manager) readLocalCerts() []byte {
+	var certsPEM []byte
+	for _, filename := range m.LocalCerts {
+		if !strings.HasPrefix(filename, "/") {
+			filename = filepath.Join(ipsecCertsDir, filename)
+		}
+
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil
+		}
+		certsPEM = append(certsPEM, content...)
+	}
+
+	return certsPEM
+}
+
+// needsRenewal checks if less than a third of validity period of cert remains
+func needsRenewal(cert *x509.Certificate, now time.Time) bool {
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < validity/3
+}
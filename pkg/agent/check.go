@@ -0,0 +1,69 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/util/probe"
+)
+
+const (
+	checkRoleServer = "server"
+	checkRoleClient = "client"
+	checkRoleProber = "prober"
+
+	terminationLogPath = "/dev/termination-log"
+)
+
+// runConnectivityCheck runs agent as a probe pod of a connectivity check. A server echoes
+// tests until it's terminated, a client tests targets and writes results to the termination
+// log, where operator reads them. A prober does both continuously, see runProber
+func runConnectivityCheck(cfg *Config) error {
+	switch cfg.CheckRole {
+	case checkRoleServer:
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+
+		return probe.Serve(ctx, cfg.CheckPort)
+	case checkRoleClient:
+		targets, err := probe.ParseTargets(cfg.CheckTargets)
+		if err != nil {
+			return err
+		}
+
+		results := probe.CheckAll(targets, checkProtocols(cfg), cfg.CheckPort, cfg.CheckTimeout)
+		return ioutil.WriteFile(terminationLogPath, []byte(probe.FormatCheckResults(results)), 0644)
+	case checkRoleProber:
+		return runProber(cfg)
+	default:
+		return fmt.Errorf("unknown check role: %s", cfg.CheckRole)
+	}
+}
+
+func checkProtocols(cfg *Config) []apis.CheckProtocol {
+	protocols := make([]apis.CheckProtocol, 0, len(cfg.CheckProtocols))
+	for _, protocol := range cfg.CheckProtocols {
+		protocols = append(protocols, apis.CheckProtocol(strings.ToUpper(protocol)))
+	}
+	return protocols
+}
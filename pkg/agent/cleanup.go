@@ -0,0 +1,275 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// cleanup removes network settings made by agent on the host, including iptables rules,
+// ipset, routes, interfaces and cni config. It's used when fabedge is uninstalled.
+func (m *Manager) cleanup() error {
+	m.log.V(3).Info("remove iptables rules")
+	if err := m.deleteRuleIfExists(TableFilter, ChainForward, "-j", ChainFabEdgeForward); err != nil {
+		return err
+	}
+	if err := m.ipt.ClearAndDeleteChain(TableFilter, ChainFabEdgeForward); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableFilter, "chain", ChainFabEdgeForward)
+		return err
+	}
+
+	if err := m.removeFirewallRules(); err != nil {
+		return err
+	}
+
+	if err := m.removeBandwidthRules(); err != nil {
+		return err
+	}
+
+	if err := m.removeLocalIngressRules(); err != nil {
+		return err
+	}
+
+	if err := m.deleteRuleIfExists(TableNat, ChainPostRouting, "-m", "mark", "--mark", MarkNodePortMasquerade, "-j", ChainMasquerade); err != nil {
+		return err
+	}
+
+	if err := m.deleteRuleIfExists(TableNat, ChainPostRouting, "-j", ChainFabEdgeNatOutgoing); err != nil {
+		return err
+	}
+	if err := m.ipt.ClearAndDeleteChain(TableNat, ChainFabEdgeNatOutgoing); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableNat, "chain", ChainFabEdgeNatOutgoing)
+		return err
+	}
+
+	m.log.V(3).Info("remove ipset", "ipset", IPSetFabEdgePeerCIDR)
+	if err := m.ipset.DestroyIPSet(IPSetFabEdgePeerCIDR); err != nil {
+		m.log.Error(err, "failed to destroy ipset", "ipset", IPSetFabEdgePeerCIDR)
+		return err
+	}
+
+	m.log.V(3).Info("remove routes in strongswan table")
+	if err := delAllRoutes(); err != nil {
+		m.log.Error(err, "failed to delete routes")
+		return err
+	}
+
+	m.log.V(3).Info("remove xfrm policies and states of tunnels")
+	if err := m.removeXfrmPolicies(); err != nil {
+		return err
+	}
+
+	if m.EnableProxy {
+		m.log.V(3).Info("remove virtual servers and dummy interface", "dummyInterface", m.DummyInterfaceName)
+		if err := m.ipvs.Flush(); err != nil {
+			m.log.Error(err, "failed to flush virtual servers")
+			return err
+		}
+
+		if err := m.removeNodePortRules(); err != nil {
+			return err
+		}
+
+		if err := m.netLink.DeleteDummyDevice(m.DummyInterfaceName); err != nil {
+			m.log.Error(err, "failed to delete dummy interface", "dummyInterface", m.DummyInterfaceName)
+			return err
+		}
+	}
+
+	if m.UseXFRM {
+		m.log.V(3).Info("remove xfrm interface", "xfrmInterface", m.XFRMInterfaceName)
+		if err := m.netLink.DeleteXfrmInterface(m.XFRMInterfaceName); err != nil {
+			m.log.Error(err, "failed to delete xfrm interface", "xfrmInterface", m.XFRMInterfaceName)
+			return err
+		}
+	}
+
+	if m.EnableIPAM {
+		if err := m.removeHostPortRules(); err != nil {
+			return err
+		}
+
+		if err := m.cleanupCNI(); err != nil {
+			return err
+		}
+	}
+
+	// cached config has addresses and subnets of peers, it's useless after cleanup.
+	// The cache dir itself may be a mount point, only files in it are removed
+	if m.CacheDir != "" {
+		m.log.V(3).Info("remove cached config", "cacheDir", m.CacheDir)
+		files, err := filepath.Glob(filepath.Join(m.CacheDir, "*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err = os.RemoveAll(file); err != nil {
+				m.log.Error(err, "failed to remove cached config", "file", file)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeXfrmPolicies deletes IPsec policies and states of tunnels. They are removed by charon when strongswan
+// stops normally, but stay in kernel if it's killed. With xfrm interface, they are found by if_id, otherwise
+// by subnets of peers in tunnels config, which may be loaded from cache. Nothing is removed if neither works
+func (m *Manager) removeXfrmPolicies() error {
+	var matchPolicy func(policy netlink.XfrmPolicy) bool
+	if m.UseXFRM {
+		matchPolicy = func(policy netlink.XfrmPolicy) bool {
+			return policy.Ifid == int(m.XFRMInterfaceID)
+		}
+	} else {
+		conf, err := m.loadNetworkConf()
+		if err != nil {
+			m.log.V(3).Info("tunnels config is not available, skip removing xfrm policies", "error", err.Error())
+			return nil
+		}
+
+		peerSubnets := sets.NewString()
+		for _, peer := range conf.Peers {
+			for _, subnet := range append(append([]string{}, peer.Subnets...), peer.NodeSubnets...) {
+				if ipNet := parseSubnet(subnet); ipNet != nil {
+					peerSubnets.Insert(ipNet.String())
+				}
+			}
+		}
+
+		matchPolicy = func(policy netlink.XfrmPolicy) bool {
+			return (policy.Dst != nil && peerSubnets.Has(policy.Dst.String())) ||
+				(policy.Src != nil && peerSubnets.Has(policy.Src.String()))
+		}
+	}
+
+	policies, err := netlink.XfrmPolicyList(netlink.FAMILY_ALL)
+	if err != nil {
+		m.log.Error(err, "failed to list xfrm policies")
+		return err
+	}
+
+	// states are found by tunnel addresses in templates of policies
+	tunnels := sets.NewString()
+	for i := range policies {
+		policy := policies[i]
+		if !matchPolicy(policy) {
+			continue
+		}
+
+		for _, tmpl := range policy.Tmpls {
+			tunnels.Insert(fmt.Sprintf("%s-%s", tmpl.Src, tmpl.Dst))
+		}
+
+		if err = netlink.XfrmPolicyDel(&policy); err != nil {
+			m.log.Error(err, "failed to delete xfrm policy", "policy", policy.String())
+			return err
+		}
+	}
+
+	states, err := netlink.XfrmStateList(netlink.FAMILY_ALL)
+	if err != nil {
+		m.log.Error(err, "failed to list xfrm states")
+		return err
+	}
+
+	for i := range states {
+		state := states[i]
+		matched := tunnels.Has(fmt.Sprintf("%s-%s", state.Src, state.Dst))
+		if m.UseXFRM {
+			matched = matched || state.Ifid == int(m.XFRMInterfaceID)
+		}
+		if !matched {
+			continue
+		}
+
+		if err = netlink.XfrmStateDel(&state); err != nil {
+			m.log.Error(err, "failed to delete xfrm state", "src", state.Src, "dst", state.Dst, "spi", state.Spi)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseSubnet parses a CIDR or an IP, an IP is taken as a host subnet
+func parseSubnet(subnet string) *net.IPNet {
+	if !strings.Contains(subnet, "/") {
+		ip := net.ParseIP(subnet)
+		if ip == nil {
+			return nil
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil
+	}
+	return ipNet
+}
+
+func (m *Manager) deleteRuleIfExists(table, chain string, rulespec ...string) error {
+	exists, err := m.ipt.Exists(table, chain, rulespec...)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", table, "chain", chain, "rule", rulespec)
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	if err = m.ipt.Delete(table, chain, rulespec...); err != nil {
+		m.log.Error(err, "failed to delete rule", "table", table, "chain", chain, "rule", rulespec)
+	}
+	return err
+}
+
+// cleanupCNI removes cni config file and bridge created for fabedge network
+func (m *Manager) cleanupCNI() error {
+	filename := filepath.Join(m.CNI.ConfDir, fmt.Sprintf("%s.conflist", m.CNI.NetworkName))
+	m.log.V(3).Info("remove cni config file", "file", filename)
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		m.log.Error(err, "failed to delete cni config file", "file", filename)
+		return err
+	}
+
+	link, err := netlink.LinkByName(m.CNI.BridgeName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		m.log.Error(err, "failed to get bridge", "bridge", m.CNI.BridgeName)
+		return err
+	}
+
+	m.log.V(3).Info("remove bridge", "bridge", m.CNI.BridgeName)
+	if err = netlink.LinkDel(link); err != nil {
+		m.log.Error(err, "failed to delete bridge", "bridge", m.CNI.BridgeName)
+	}
+	return err
+}
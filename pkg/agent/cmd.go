@@ -15,12 +15,12 @@
 package agent
 
 import (
+	"context"
 	"os"
 
 	"github.com/fsnotify/fsnotify"
 	flag "github.com/spf13/pflag"
 	"k8s.io/klog/v2"
-	"k8s.io/klog/v2/klogr"
 
 	"github.com/fabedge/fabedge/pkg/common/about"
 	logutil "github.com/fabedge/fabedge/pkg/util/log"
@@ -34,13 +34,28 @@ func Execute() error {
 
 	about.AddFlags(fs)
 	logutil.AddFlags(fs)
+	logutil.AddFormatFlag(fs)
 	cfg.AddFlags(fs)
 
 	flag.Parse()
 
 	about.DisplayAndExitIfRequested()
 
-	log := klogr.New().WithName("manager")
+	if err := logutil.Setup(logutil.KeyNode, cfg.NodeName); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	log := logutil.New("manager")
+	// probe pods don't manage network, nothing else is needed
+	if cfg.CheckRole != "" {
+		if err := runConnectivityCheck(cfg); err != nil {
+			log.Error(err, "failed to run connectivity check", "role", cfg.CheckRole)
+			return err
+		}
+		return nil
+	}
+
 	if err := cfg.Validate(); err != nil {
 		log.Error(err, "validation failed")
 		return err
@@ -52,6 +67,16 @@ func Execute() error {
 		return err
 	}
 
+	if cfg.Cleanup {
+		if err = manager.cleanup(); err != nil {
+			log.Error(err, "failed to clean up network settings")
+			return err
+		}
+
+		log.Info("network settings are cleaned up")
+		return nil
+	}
+
 	if manager.EnableIPAM {
 		if err := os.MkdirAll(cfg.CNI.ConfDir, 0777); err != nil {
 			log.Error(err, "failed to create cni conf dir")
@@ -59,8 +84,51 @@ func Execute() error {
 		}
 	}
 
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+			log.Error(err, "failed to create cache dir")
+			return err
+		}
+	}
+
+	if manager.kubeClient != nil && (cfg.CacheDir != "" || manager.localClient != nil) {
+		go manager.watchCloud()
+	}
+
 	go manager.start()
 
+	if manager.configSource != nil {
+		go retryForever(context.Background(), manager.watchAgentConfig, func(n uint, err error) {
+			log.Error(err, "failed to watch agent config", "retryNum", n)
+		})
+	}
+
+	if manager.configSource != nil && cfg.ProbeInterval > 0 {
+		go manager.probePeers()
+	}
+
+	if manager.tunnelHistory != nil {
+		go manager.watchTunnelEvents()
+		go manager.reportTunnelHistory()
+	}
+
+	if cfg.MetricsBindAddress != "0" && cfg.MetricsBindAddress != "" {
+		go retryForever(context.Background(), manager.serveMetrics, func(n uint, err error) {
+			log.Error(err, "failed to serve metrics", "retryNum", n)
+		})
+	}
+
+	if manager.dnsForwarder != nil {
+		go retryForever(context.Background(), manager.serveDNS, func(n uint, err error) {
+			log.Error(err, "failed to serve DNS", "retryNum", n)
+		})
+	}
+
+	// config files are written by agent itself, it notifies manager when they are changed
+	if manager.configSource != nil {
+		select {}
+	}
+
 	err = watchFiles(cfg.TunnelsConfPath, cfg.ServicesConfPath, func(event fsnotify.Event) {
 		log.V(5).Info("tunnels or services config may change", "file", event.Name, "event", event.Op.String())
 		manager.notify()
@@ -68,6 +136,10 @@ func Execute() error {
 
 	if err != nil {
 		log.Error(err, "failed to watch tunnelsconf", "file", cfg.TunnelsConfPath)
+		// config files may be missing when the node is offline, cached config is still synced every sync period
+		if cfg.CacheDir != "" {
+			select {}
+		}
 	}
 	return err
 }
@@ -16,16 +16,22 @@ package agent
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	debpkg "github.com/bep/debounce"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/spf13/pflag"
-	"k8s.io/klog/v2/klogr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/utils/exec"
 
+	"github.com/fabedge/fabedge/pkg/tunnel"
 	"github.com/fabedge/fabedge/pkg/tunnel/strongswan"
+	"github.com/fabedge/fabedge/pkg/util/fips"
 	"github.com/fabedge/fabedge/pkg/util/ipset"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
 	"github.com/fabedge/fabedge/third_party/ipvs"
 )
 
@@ -38,6 +44,7 @@ type CNI struct {
 
 type Config struct {
 	LocalCerts       []string
+	CRLFile          string
 	SyncPeriod       time.Duration
 	DebounceDuration time.Duration
 	TunnelsConfPath  string
@@ -50,12 +57,111 @@ type Config struct {
 	XFRMInterfaceName string
 	XFRMInterfaceID   uint
 
+	// SubnetsPerChildSA is the max number of subnets in traffic selectors of a child SA,
+	// 0 means all subnets of a peer are put in one child SA
+	SubnetsPerChildSA int
+
 	EnableIPAM        bool
 	EnableHairpinMode bool
 	NetworkPluginMTU  int
 	CNI               CNI
 
 	EnableProxy bool
+
+	NodeName string
+	// NodeCondition is the type of node condition which reflects whether tunnels to
+	// connector are established, if it's empty, agent won't manage any node condition
+	NodeCondition string
+	// RelayTimeout is how long tunnels to edge peers can be down while tunnels to connector are
+	// established before agent registers its node for relay by connector, 0 means it never does
+	RelayTimeout time.Duration
+	// AgentConfigNamespace is the namespace of the AgentConfig named after NodeName, if it's provided,
+	// tunnels and services config are got through API instead of configmap volume, see agentConfigSource
+	AgentConfigNamespace string
+	// ProbeInterval is how often peers are pinged through tunnels, results are reported in status
+	// of AgentConfig. Each peer is pinged ProbeCount times, a ping not replied in ProbeTimeout is lost
+	ProbeInterval time.Duration
+	ProbeCount    int
+	ProbeTimeout  time.Duration
+	// TunnelHistorySize is the max number of up, down and rekey events kept for each peer, 0 means
+	// events are not recorded. Peers whose tunnels go down more than FlapThreshold times in an hour
+	// are flapping, summaries of the history are reported in status of AgentConfig
+	TunnelHistorySize int
+	FlapThreshold     int
+	// MetaServerAddress is the address of MetaServer of KubeEdge on the node, agent accesses API
+	// through it when kube-apiserver can't be reached, empty means it's not used
+	MetaServerAddress string
+	// LiteAPIServerAddress is the address of lite-apiserver of SuperEdge on the node, it's used
+	// the same way as MetaServerAddress
+	LiteAPIServerAddress string
+
+	// APIServerAddress is the address of operator's API server, agent renews its certificate
+	// there before it expires. Empty means the certificate is renewed by operator
+	APIServerAddress string
+	// CertBootstrap makes agent get its certificate from APIServerAddress by the service account token
+	// in BootstrapTokenFile, the API server is verified by CAs in BootstrapCAFile. The key and the
+	// certificate are kept in an emptyDir volume instead of a TLS secret, see Manager.bootstrapCert
+	CertBootstrap      bool
+	BootstrapTokenFile string
+	BootstrapCAFile    string
+
+	// EnableFirewall makes agent keep a minimal firewall on FirewallInterface, only IKE and ESP
+	// from peers and FirewallAllowedPorts are accepted, see Manager.ensureFirewallRules
+	EnableFirewall bool
+	// FirewallInterface is the WAN interface, empty means the interface of default route
+	FirewallInterface    string
+	FirewallAllowedPorts []string
+
+	// FIPSMode restricts IPsec proposals, certificates and TLS to FIPS approved algorithms
+	FIPSMode bool
+
+	// Cleanup makes agent remove network settings it made on the host and exit
+	Cleanup bool
+
+	// CheckRole makes agent run as a probe pod of a connectivity check instead of managing network,
+	// a server echoes tests on CheckPort, a client tests CheckTargets, which are like node=address,
+	// and writes results to the termination log, see runConnectivityCheck
+	CheckRole      string
+	CheckPort      int
+	CheckTargets   []string
+	CheckProtocols []string
+	CheckTimeout   time.Duration
+	// CheckInterval is how often a prober tests targets in CheckTargetsFile, events of violations
+	// are recorded on pod CheckPodName in CheckPodNamespace, they are not recorded if it's empty
+	CheckInterval     time.Duration
+	CheckTargetsFile  string
+	CheckPodName      string
+	CheckPodNamespace string
+
+	// ClearConntrack makes agent delete conntrack entries of peer subnets whose routes or NAT rules are changed
+	ClearConntrack bool
+
+	// CacheDir keeps the last valid tunnels and services config, they are used when the config files
+	// can't be loaded, e.g. the edge node is offline. Empty means nothing is cached
+	CacheDir string
+
+	// DNSListenAddress is where agent serves DNS for edge pods, queries are forwarded to DNSUpstreams
+	// through tunnels, answers are cached and still served within DNSStaleTTL after they expire if
+	// upstreams can't be reached. Empty means DNS is not served
+	DNSListenAddress string
+	DNSUpstreams     []string
+	DNSStaleTTL      time.Duration
+
+	// IPVSTCPTimeout, IPVSTCPFinTimeout and IPVSUDPTimeout are timeouts of IPVS connections, 0 keeps the value of system
+	IPVSTCPTimeout    time.Duration
+	IPVSTCPFinTimeout time.Duration
+	IPVSUDPTimeout    time.Duration
+	// IPVSGracefulTermination is the longest time a removed TCP real server is kept with weight 0
+	// until its connections are gone, 0 means real servers are deleted at once
+	IPVSGracefulTermination time.Duration
+
+	// MetricsBindAddress is the address to serve /metrics and /healthz, 0 means they are not served
+	MetricsBindAddress string
+
+	// ResourceMode is normal or low, see applyResourceMode
+	ResourceMode string
+	// DNSCacheSize is the max number of answers cached by DNS forwarder, it's decided by ResourceMode
+	DNSCacheSize int
 }
 
 func (cfg *Config) AddFlags(fs *pflag.FlagSet) {
@@ -63,6 +169,7 @@ func (cfg *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&cfg.ServicesConfPath, "services-conf", "/etc/fabedge/services.yaml", "The file that records information about services and endpointslices")
 
 	fs.StringSliceVar(&cfg.LocalCerts, "local-cert", []string{"edgecert.pem"}, "The path to cert files, comma separated. If it's a relative path, the cert file should be put under /etc/ipsec.d/certs")
+	fs.StringVar(&cfg.CRLFile, "crl-file", "", "The file which contains CRLs in PEM, peers whose certificates are revoked by them can't establish tunnels with this node")
 	fs.DurationVar(&cfg.DebounceDuration, "debounce", time.Second, "The debounce delay to avoid too much network reconfiguring")
 	fs.DurationVar(&cfg.SyncPeriod, "sync-period", 30*time.Second, "The period to synchronize network configuration")
 
@@ -80,8 +187,50 @@ func (cfg *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&cfg.UseXFRM, "use-xfrm", false, "use xfrm when OS has this feature")
 	fs.StringVar(&cfg.XFRMInterfaceName, "xfrm-interface-name", "ipsec42", "the name of xfrm interface")
 	fs.UintVar(&cfg.XFRMInterfaceID, "xfrm-interface-id", 42, "the id of xfrm interface")
+	fs.IntVar(&cfg.SubnetsPerChildSA, "subnets-per-child-sa", 0, "The max number of subnets in traffic selectors of a child SA, if it's positive, subnets of a peer are split into multiple child SAs. 0 means no splitting")
 
 	fs.BoolVar(&cfg.EnableProxy, "enable-proxy", true, "Enable the proxy feature")
+
+	fs.StringVar(&cfg.NodeName, "node-name", "", "The name of the node where agent is running")
+	fs.StringVar(&cfg.NodeCondition, "node-condition", "", "The type of node condition which reflects whether tunnels to connector are established, e.g. NetworkUnavailable or FabEdgeTunnelReady. Leave it empty to disable it")
+	fs.DurationVar(&cfg.RelayTimeout, "relay-timeout", 0, "How long tunnels to edge peers can be down while tunnels to connector are established, after that agent annotates its node with fabedge.io/relay-only=true, then traffic to edge peers is relayed by connector. It's checked every sync-period. 0 means it's disabled")
+	fs.StringVar(&cfg.MetaServerAddress, "metaserver-address", "", "The address of MetaServer of KubeEdge on the node, e.g. http://127.0.0.1:10550, agent accesses API through it when kube-apiserver can't be reached. Empty means it's not used")
+	fs.StringVar(&cfg.LiteAPIServerAddress, "lite-apiserver-address", "", "The address of lite-apiserver of SuperEdge on the node, e.g. https://127.0.0.1:51003, agent accesses API through it when kube-apiserver can't be reached. Empty means it's not used")
+	fs.DurationVar(&cfg.ProbeInterval, "probe-interval", 0, "How often peers are pinged through tunnels by their node addresses, round-trip time and loss are reported in status of AgentConfig, so agent-config-namespace is required. 0 means it's disabled")
+	fs.IntVar(&cfg.ProbeCount, "probe-count", 5, "The number of pings sent to each peer in a probe")
+	fs.DurationVar(&cfg.ProbeTimeout, "probe-timeout", time.Second, "How long to wait for the reply of a ping, a ping not replied in time is lost")
+	fs.IntVar(&cfg.TunnelHistorySize, "tunnel-history-size", 50, "The max number of up, down and rekey events of tunnels kept for each peer, they are served at /tunnel-events of metrics server and summarized in status of AgentConfig if agent-config-namespace is provided. 0 means events are not recorded")
+	fs.IntVar(&cfg.FlapThreshold, "flap-threshold", 5, "A tunnel which goes down more than this many times in an hour is flapping, agent sets condition Flapping of its AgentConfig")
+	fs.StringVar(&cfg.AgentConfigNamespace, "agent-config-namespace", "", "The namespace of AgentConfig named after node-name, if it's provided, tunnels and services config are got through API and written to tunnels-conf and services-conf, the result is reported in its status")
+	fs.StringVar(&cfg.APIServerAddress, "api-server-address", "", "The address of operator's API server where agent renews its certificate in place when less than a third of its validity period remains, e.g. https://10.0.0.1:30303. Leave it empty to let operator reissue the certificate")
+	fs.BoolVar(&cfg.CertBootstrap, "cert-bootstrap", false, "Get the certificate from api-server-address by the service account token of agent pod and keep it in /etc/ipsec.d, no TLS secret is needed. The certificate is renewed the same way")
+	fs.StringVar(&cfg.BootstrapTokenFile, "bootstrap-token-file", "/var/run/secrets/fabedge/token", "The projected service account token which is used to bootstrap the certificate")
+	fs.StringVar(&cfg.BootstrapCAFile, "bootstrap-ca-file", "/etc/fabedge/ca-bundle.crt", "The CA certificates which are used to verify API server when the certificate is bootstrapped, they are trusted by tunnels too")
+	fs.BoolVar(&cfg.EnableFirewall, "enable-firewall", false, "Keep a minimal firewall on the WAN interface of the host: IKE and ESP from peers in tunnels config, replies of connections made by the host and allowed ports are accepted, everything else coming from the interface is dropped. Only IPv4 is guarded")
+	fs.StringVar(&cfg.FirewallInterface, "firewall-interface", "", "The WAN interface guarded by firewall, leave it empty to use the interface of default route")
+	fs.StringSliceVar(&cfg.FirewallAllowedPorts, "firewall-allowed-ports", []string{"10250"}, "The TCP ports which are accepted by firewall, comma separated, e.g. 22,10250. 10250 is the port of kubelet")
+	fs.BoolVar(&cfg.FIPSMode, "fips-mode", fips.BuildEnabled(), "Restrict IPsec proposals, certificates and TLS to FIPS approved algorithms, agent refuses to start if its certificate is not compliant. It's always on if agent is built with tag fips")
+	fs.BoolVar(&cfg.ClearConntrack, "clear-conntrack", true, "Delete conntrack entries of peer subnets whose routes or outbound NAT rules are changed, so existing flows don't go the old way")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", "", "The directory on the host where the last valid tunnels and services config are kept, agent uses them when the config files can't be loaded, e.g. it restarts while the edge node is offline. If kube-apiserver can be reached by agent, tasks which need cloud are skipped while it's unreachable and everything is synced again when it's back. Empty means offline autonomy is disabled")
+	fs.StringVar(&cfg.DNSListenAddress, "dns-listen-address", "", "The address where agent serves DNS for edge pods, e.g. 169.254.20.10:53, the IP is bound to the dummy interface. Queries are forwarded to dns-upstreams through tunnels. Leave it empty to disable it")
+	fs.StringSliceVar(&cfg.DNSUpstreams, "dns-upstreams", nil, "Addresses of cluster DNS which queries are forwarded to, e.g. 10.96.0.10:53, they are tried one by one")
+	fs.DurationVar(&cfg.DNSStaleTTL, "dns-stale-ttl", time.Hour, "How long an expired answer is still served when dns-upstreams can't be reached, e.g. the edge node is offline")
+	fs.DurationVar(&cfg.IPVSTCPTimeout, "ipvs-tcp-timeout", 0, "The timeout of idle IPVS TCP connections, e.g. 900s. 0 keeps the value of system")
+	fs.DurationVar(&cfg.IPVSTCPFinTimeout, "ipvs-tcpfin-timeout", 0, "The timeout of IPVS TCP connections after receiving a FIN packet, e.g. 120s. 0 keeps the value of system")
+	fs.DurationVar(&cfg.IPVSUDPTimeout, "ipvs-udp-timeout", 0, "The timeout of IPVS UDP packets, e.g. 300s. 0 keeps the value of system")
+	fs.DurationVar(&cfg.IPVSGracefulTermination, "ipvs-graceful-termination", 0, "The longest time a TCP real server removed from a service is kept with weight 0 until its connections are gone, it's checked every sync-period. 0 means real servers are deleted at once")
+	fs.StringVar(&cfg.MetricsBindAddress, "metrics-bind-address", "0", "The address on which /metrics and /healthz are served, e.g. :30307. 0 means they are not served")
+	fs.StringVar(&cfg.ResourceMode, "resource-mode", ResourceModeNormal, "normal or low. In low mode, sync-period is at least 5m, debounce is at least 5s, conntrack clearing and ipvs graceful termination are disabled, fewer DNS answers are cached and memory is collected more often. It's for constrained edge hardware")
+	fs.BoolVar(&cfg.Cleanup, "cleanup", false, "Remove iptables rules, ipset, routes and interfaces made by agent on the host, then exit. It's used when fabedge is uninstalled")
+	fs.StringVar(&cfg.CheckRole, "check-role", "", "server, client or prober. Run as a probe pod of a connectivity check or as a prober instead of managing network, it's used by operator")
+	fs.IntVar(&cfg.CheckPort, "check-port", 8790, "The TCP and UDP port which probe pods of a connectivity check listen on")
+	fs.StringSliceVar(&cfg.CheckTargets, "check-targets", nil, "The targets a client probe pod tests, e.g. edge1=10.233.64.5,edge2=10.233.65.8")
+	fs.StringSliceVar(&cfg.CheckProtocols, "check-protocols", []string{"TCP", "UDP", "ICMP"}, "The protocols a client probe pod tests with")
+	fs.DurationVar(&cfg.CheckTimeout, "check-timeout", 3*time.Second, "How long a client probe pod waits for the reply of a test")
+	fs.DurationVar(&cfg.CheckInterval, "check-interval", 30*time.Second, "How often a prober tests its targets")
+	fs.StringVar(&cfg.CheckTargetsFile, "check-targets-file", "", "The file of targets a prober tests, one target like edge1=10.233.64.5 a line. It's read again before each round of tests")
+	fs.StringVar(&cfg.CheckPodName, "check-pod-name", "", "The name of the prober pod, events of unreachable targets are recorded on it. Empty means no events are recorded")
+	fs.StringVar(&cfg.CheckPodNamespace, "check-pod-namespace", "", "The namespace of the prober pod")
 }
 
 func (cfg *Config) Validate() error {
@@ -93,6 +242,90 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("the least sync period value is 1 second")
 	}
 
+	if cfg.ResourceMode != ResourceModeNormal && cfg.ResourceMode != ResourceModeLow {
+		return fmt.Errorf("unknown resource mode: %s", cfg.ResourceMode)
+	}
+
+	if cfg.SubnetsPerChildSA < 0 {
+		return fmt.Errorf("subnets per child SA can not be negative")
+	}
+
+	if cfg.IPVSTCPTimeout < 0 || cfg.IPVSTCPFinTimeout < 0 || cfg.IPVSUDPTimeout < 0 || cfg.IPVSGracefulTermination < 0 {
+		return fmt.Errorf("ipvs timeouts and graceful termination can not be negative")
+	}
+
+	for _, port := range cfg.FirewallAllowedPorts {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid firewall allowed port: %s", port)
+		}
+	}
+
+	if fips.BuildEnabled() && !cfg.FIPSMode {
+		return fmt.Errorf("fips mode can not be disabled, agent is built with tag fips")
+	}
+
+	if cfg.CertBootstrap && cfg.APIServerAddress == "" {
+		return fmt.Errorf("api server address is required to bootstrap certificate")
+	}
+
+	if cfg.NodeCondition != "" && cfg.NodeName == "" {
+		return fmt.Errorf("node name is required to manage node condition")
+	}
+
+	if cfg.RelayTimeout < 0 {
+		return fmt.Errorf("relay timeout can not be negative")
+	}
+
+	if cfg.RelayTimeout > 0 && cfg.NodeName == "" {
+		return fmt.Errorf("node name is required to register for relay")
+	}
+
+	if cfg.MetaServerAddress != "" && cfg.LiteAPIServerAddress != "" {
+		return fmt.Errorf("metaserver address and lite-apiserver address can not be used together")
+	}
+
+	if cfg.AgentConfigNamespace != "" && cfg.NodeName == "" {
+		return fmt.Errorf("node name is required to get agent config")
+	}
+
+	if cfg.ProbeInterval < 0 {
+		return fmt.Errorf("probe interval can not be negative")
+	}
+
+	if cfg.ProbeInterval > 0 {
+		if cfg.AgentConfigNamespace == "" {
+			return fmt.Errorf("agent config namespace is required to report probes")
+		}
+
+		if cfg.ProbeCount <= 0 || cfg.ProbeTimeout <= 0 {
+			return fmt.Errorf("probe count and probe timeout should be positive")
+		}
+	}
+
+	if cfg.TunnelHistorySize < 0 || cfg.FlapThreshold < 0 {
+		return fmt.Errorf("tunnel history size and flap threshold can not be negative")
+	}
+
+	if cfg.DNSListenAddress != "" {
+		if host, _, err := net.SplitHostPort(cfg.DNSListenAddress); err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid dns listen address: %s", cfg.DNSListenAddress)
+		}
+
+		if len(cfg.DNSUpstreams) == 0 {
+			return fmt.Errorf("dns upstreams are required to serve DNS")
+		}
+		for i, upstream := range cfg.DNSUpstreams {
+			// port 53 is used if it's not provided
+			if net.ParseIP(upstream) != nil {
+				upstream = net.JoinHostPort(upstream, "53")
+				cfg.DNSUpstreams[i] = upstream
+			}
+			if _, _, err := net.SplitHostPort(upstream); err != nil {
+				return fmt.Errorf("invalid dns upstream: %s", upstream)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -105,8 +338,15 @@ func (cfg Config) Manager() (*Manager, error) {
 	}
 
 	cfg.MASQOutgoing = cfg.EnableIPAM && cfg.MASQOutgoing
+	cfg.applyResourceMode()
 
-	var opts strongswan.Options
+	opts := strongswan.Options{
+		strongswan.SubnetsPerChildSA(cfg.SubnetsPerChildSA),
+	}
+	if cfg.FIPSMode {
+		fips.Enable()
+		opts = append(opts, strongswan.Proposals(fips.IKEProposals, fips.ESPProposals))
+	}
 	if cfg.UseXFRM {
 		supportXFRM, err := ipvs.SupportXfrmInterface(kernelHandler)
 		if err != nil {
@@ -128,11 +368,32 @@ func (cfg Config) Manager() (*Manager, error) {
 		return nil, err
 	}
 
+	var kubeClient, localClient kubernetes.Interface
+	if cfg.NodeCondition != "" || cfg.RelayTimeout > 0 {
+		kubeConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		kubeClient, err = kubernetes.NewForConfig(kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// MetaServer and lite-apiserver are only fallbacks of kube-apiserver
+		if localConfig := cfg.localAPIConfig(kubeConfig); localConfig != nil {
+			localClient, err = kubernetes.NewForConfig(localConfig)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	m := &Manager{
 		Config: cfg,
 		tm:     tm,
 		ipt:    ipt,
-		log:    klogr.New().WithName("manager"),
+		log:    logutil.New("manager"),
 
 		events:   make(chan struct{}),
 		debounce: debpkg.New(cfg.DebounceDuration),
@@ -140,6 +401,33 @@ func (cfg Config) Manager() (*Manager, error) {
 		netLink: ipvs.NewNetLinkHandle(false),
 		ipvs:    ipvs.New(exec.New()),
 		ipset:   ipset.New(),
+
+		kubeClient:  kubeClient,
+		localClient: localClient,
+
+		terminatingRealServers: make(map[string]time.Time),
+	}
+
+	if cfg.TunnelHistorySize > 0 {
+		m.tunnelHistory = tunnel.NewHistory(cfg.TunnelHistorySize)
+	}
+
+	if cfg.DNSListenAddress != "" {
+		m.dnsForwarder = newDNSForwarder(cfg.DNSUpstreams, cfg.DNSStaleTTL, cfg.DNSCacheSize, m.log.WithName("dns"))
+	}
+
+	if cfg.AgentConfigNamespace != "" && !cfg.Cleanup {
+		m.configSource, err = newAgentConfigSource(cfg.AgentConfigNamespace, cfg.NodeName, m.log.WithName("agentconfig"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a bootstrapped certificate doesn't exist yet, its key is generated by agent
+	if cfg.FIPSMode && !cfg.Cleanup && !cfg.CertBootstrap {
+		if err = fips.ValidateCertsPEM(m.readLocalCerts()); err != nil {
+			return nil, err
+		}
 	}
 
 	return m, nil
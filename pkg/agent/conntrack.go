@@ -0,0 +1,39 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/fabedge/fabedge/pkg/util/conntrack"
+)
+
+// clearConntrack deletes conntrack entries of subnets which are changed since last time, existing flows
+// keep their NAT and state, they would still go the old way after routes or NAT rules are changed.
+// It never fails, a failure is retried when subnets are synced next time
+func (m *Manager) clearConntrack(cleaner *conntrack.Cleaner, subnets sets.String, reason string) {
+	if !m.ClearConntrack {
+		return
+	}
+
+	changed, deleted, err := cleaner.Update(subnets)
+	if err != nil {
+		m.log.Error(err, "failed to delete conntrack entries", "subnets", changed)
+		return
+	}
+	if len(changed) > 0 {
+		m.log.V(3).Info("conntrack entries are deleted", "reason", reason, "subnets", changed, "deleted", deleted)
+	}
+}
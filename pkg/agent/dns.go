@@ -0,0 +1,266 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsTimeout = 2 * time.Second
+	// dnsNegativeTTL is how long an answer without any record is cached
+	dnsNegativeTTL = 5 * time.Second
+	// dnsStaleReplyTTL is the TTL of records in stale answers, clients ask again soon
+	dnsStaleReplyTTL = 30
+	// dnsCacheSize is the default max number of cached answers
+	dnsCacheSize = 10000
+)
+
+// dnsForwarder serves DNS on edge node, queries are forwarded to cluster DNS through tunnels and
+// answers are cached. An expired answer is still served within staleTTL if upstreams can't be
+// reached, so edge pods keep resolving names they used before while the edge node is offline
+type dnsForwarder struct {
+	upstreams []string
+	staleTTL  time.Duration
+	cacheSize int
+	log       logr.Logger
+
+	mux   sync.Mutex
+	cache map[string]*dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+func newDNSForwarder(upstreams []string, staleTTL time.Duration, cacheSize int, log logr.Logger) *dnsForwarder {
+	return &dnsForwarder{
+		upstreams: upstreams,
+		staleTTL:  staleTTL,
+		cacheSize: cacheSize,
+		log:       log,
+		cache:     make(map[string]*dnsCacheEntry),
+	}
+}
+
+func (f *dnsForwarder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if len(req.Question) != 1 {
+		_ = w.WriteMsg(new(dns.Msg).SetRcode(req, dns.RcodeFormatError))
+		return
+	}
+
+	key := dnsCacheKey(req.Question[0])
+	if resp := f.lookup(key, req, false); resp != nil {
+		DNSQueriesTotal.WithLabelValues(dnsResultCached).Inc()
+		_ = w.WriteMsg(resp)
+		return
+	}
+
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+
+	resp, err := f.forward(req, network)
+	if err == nil {
+		f.store(key, resp)
+		DNSQueriesTotal.WithLabelValues(dnsResultForwarded).Inc()
+		_ = w.WriteMsg(resp)
+		return
+	}
+
+	if stale := f.lookup(key, req, true); stale != nil {
+		f.log.V(3).Info("upstreams are unreachable, reply with a stale answer", "name", req.Question[0].Name, "error", err)
+		DNSQueriesTotal.WithLabelValues(dnsResultStale).Inc()
+		_ = w.WriteMsg(stale)
+		return
+	}
+
+	f.log.V(3).Info("failed to resolve", "name", req.Question[0].Name, "error", err)
+	DNSQueriesTotal.WithLabelValues(dnsResultFailed).Inc()
+	_ = w.WriteMsg(new(dns.Msg).SetRcode(req, dns.RcodeServerFailure))
+}
+
+// forward asks upstreams one by one until one of them answers
+func (f *dnsForwarder) forward(req *dns.Msg, network string) (*dns.Msg, error) {
+	client := &dns.Client{Net: network, Timeout: dnsTimeout}
+
+	var lastErr error
+	for _, upstream := range f.upstreams {
+		resp, _, err := client.Exchange(req, upstream)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode == dns.RcodeServerFailure {
+			lastErr = fmt.Errorf("%s failed to resolve %s", upstream, req.Question[0].Name)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// lookup returns a copy of the cached answer as a reply of req, an expired one is returned only if stale is true
+func (f *dnsForwarder) lookup(key string, req *dns.Msg, stale bool) *dns.Msg {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	entry, ok := f.cache[key]
+	if !ok {
+		return nil
+	}
+
+	remaining := time.Until(entry.expires)
+	ttl := uint32(remaining / time.Second)
+	if remaining <= 0 {
+		if !stale || -remaining > f.staleTTL {
+			return nil
+		}
+		ttl = dnsStaleReplyTTL
+	}
+
+	resp := entry.msg.Copy()
+	resp.Id = req.Id
+	resp.Question = req.Question
+	for _, rr := range dnsRecords(resp) {
+		rr.Header().Ttl = ttl
+	}
+
+	return resp
+}
+
+// store caches resp for the least TTL of its records, truncated answers and failures are not cached
+func (f *dnsForwarder) store(key string, resp *dns.Msg) {
+	if resp.Truncated || (resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return
+	}
+
+	ttl := dnsNegativeTTL
+	for i, rr := range dnsRecords(resp) {
+		t := time.Duration(rr.Header().Ttl) * time.Second
+		if i == 0 || t < ttl {
+			ttl = t
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if len(f.cache) >= f.cacheSize {
+		f.evict()
+	}
+	f.cache[key] = &dnsCacheEntry{
+		msg:     resp.Copy(),
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// evict removes answers which can't be served even as stale ones, if the cache is still full, some random ones are removed
+func (f *dnsForwarder) evict() {
+	now := time.Now()
+	for key, entry := range f.cache {
+		if now.Sub(entry.expires) > f.staleTTL {
+			delete(f.cache, key)
+		}
+	}
+
+	for key := range f.cache {
+		if len(f.cache) < f.cacheSize {
+			break
+		}
+		delete(f.cache, key)
+	}
+}
+
+// dnsRecords returns records in all sections of msg except OPT pseudo records
+func dnsRecords(msg *dns.Msg) []dns.RR {
+	var records []dns.RR
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				records = append(records, rr)
+			}
+		}
+	}
+	return records
+}
+
+func dnsCacheKey(q dns.Question) string {
+	return fmt.Sprintf("%s/%d/%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+}
+
+// serveDNS binds the address of DNSListenAddress to the dummy interface and serves DNS on it by UDP
+// and TCP, it returns when either of them stops
+func (m *Manager) serveDNS() error {
+	host, _, err := net.SplitHostPort(m.DNSListenAddress)
+	if err != nil {
+		return err
+	}
+
+	if _, err = m.netLink.EnsureDummyDevice(m.DummyInterfaceName); err != nil {
+		return err
+	}
+	if _, err = m.netLink.EnsureAddressBind(host, m.DummyInterfaceName); err != nil {
+		return err
+	}
+
+	udpConn, err := net.ListenPacket("udp", m.DNSListenAddress)
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	tcpListener, err := net.Listen("tcp", m.DNSListenAddress)
+	if err != nil {
+		return err
+	}
+	defer tcpListener.Close()
+
+	// the cache is kept by the forwarder of manager, so it survives restarts of servers
+	servers := []*dns.Server{
+		{PacketConn: udpConn, Handler: m.dnsForwarder},
+		{Listener: tcpListener, Handler: m.dnsForwarder},
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *dns.Server) {
+			errCh <- server.ActivateAndServe()
+		}(server)
+	}
+	m.log.Info("serve DNS", "address", m.DNSListenAddress, "upstreams", m.DNSUpstreams)
+
+	// closing the listeners makes the other server return too
+	err = <-errCh
+	udpConn.Close()
+	tcpListener.Close()
+	<-errCh
+
+	return err
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	ChainInput              = "INPUT"
+	ChainFabEdgeFirewall    = "FABEDGE-FIREWALL"
+	ChainFabEdgeFirewallNew = "FABEDGE-FIREWALL-NEW"
+)
+
+// ensureFirewallRules keeps a minimal firewall on the WAN interface of the host: IKE and ESP
+// from peers in tunnels config, decrypted IPsec traffic, replies of connections made by
+// the host and allowed TCP ports are accepted, everything else coming from the interface
+// is dropped. Rules are built in a new chain which replaces the old one at last, so the
+// host is never left open while rules are changed
+func (m *Manager) ensureFirewallRules(conf netconf.NetworkConf) error {
+	iface, err := m.getFirewallInterface()
+	if err != nil {
+		m.log.Error(err, "failed to get interface of firewall")
+		return err
+	}
+
+	rules := m.buildFirewallRules(iface, conf)
+
+	exists, err := m.ipt.Exists(TableFilter, ChainInput, "-j", ChainFabEdgeFirewall)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableFilter, "chain", ChainInput)
+		return err
+	}
+
+	if exists && reflect.DeepEqual(rules, m.firewallRules) {
+		return nil
+	}
+
+	m.log.V(3).Info("update firewall rules", "interface", iface, "rules", len(rules))
+	// the new chain may be left by last failed update
+	if err = m.deleteRuleIfExists(TableFilter, ChainInput, "-j", ChainFabEdgeFirewallNew); err != nil {
+		return err
+	}
+
+	if err = m.ipt.ClearChain(TableFilter, ChainFabEdgeFirewallNew); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableFilter, "chain", ChainFabEdgeFirewallNew)
+		return err
+	}
+
+	for _, rule := range rules {
+		if err = m.ipt.Append(TableFilter, ChainFabEdgeFirewallNew, rule...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableFilter, "chain", ChainFabEdgeFirewallNew, "rule", strings.Join(rule, " "))
+			return err
+		}
+	}
+
+	// the new chain takes effect before the old one is removed
+	if err = m.ipt.Insert(TableFilter, ChainInput, 1, "-j", ChainFabEdgeFirewallNew); err != nil {
+		m.log.Error(err, "failed to insert rule", "table", TableFilter, "chain", ChainInput, "rule", "-j "+ChainFabEdgeFirewallNew)
+		return err
+	}
+
+	if err = m.removeFirewallChain(ChainFabEdgeFirewall); err != nil {
+		return err
+	}
+
+	if err = m.ipt.RenameChain(TableFilter, ChainFabEdgeFirewallNew, ChainFabEdgeFirewall); err != nil {
+		m.log.Error(err, "failed to rename chain", "table", TableFilter, "chain", ChainFabEdgeFirewallNew)
+		return err
+	}
+
+	m.firewallRules = rules
+	return nil
+}
+
+func (m *Manager) buildFirewallRules(iface string, conf netconf.NetworkConf) [][]string {
+	rules := [][]string{
+		// other interfaces, e.g. the loopback and interfaces of pods, are not guarded
+		{"!", "-i", iface, "-j", "RETURN"},
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		// traffic decrypted from tunnels is protected by IPsec already
+		{"-m", "policy", "--dir", "in", "--pol", "ipsec", "-j", "ACCEPT"},
+	}
+
+	for _, ip := range m.getPeerIPs(conf).List() {
+		rules = append(rules,
+			[]string{"-s", ip, "-p", "udp", "-m", "multiport", "--dports", "500,4500", "-j", "ACCEPT"},
+			[]string{"-s", ip, "-p", "esp", "-j", "ACCEPT"},
+		)
+	}
+
+	for _, port := range m.FirewallAllowedPorts {
+		rules = append(rules, []string{"-p", "tcp", "--dport", port, "-j", "ACCEPT"})
+	}
+
+	return append(rules, []string{"-j", "DROP"})
+}
+
+// getPeerIPs returns IPv4 addresses of all peers, DNS names are resolved
+func (m *Manager) getPeerIPs(conf netconf.NetworkConf) sets.String {
+	ips := sets.NewString()
+	for _, peer := range conf.Peers {
+		for _, address := range peer.PublicAddresses {
+			if ip := net.ParseIP(address); ip != nil {
+				if ip.To4() != nil {
+					ips.Insert(ip.String())
+				}
+				continue
+			}
+
+			resolved, err := net.LookupIP(address)
+			if err != nil {
+				m.log.Error(err, "failed to resolve public address of peer", logutil.KeyEndpoint, peer.Name, "address", address)
+				continue
+			}
+
+			for _, ip := range resolved {
+				if ip.To4() != nil {
+					ips.Insert(ip.String())
+				}
+			}
+		}
+	}
+
+	return ips
+}
+
+// getFirewallInterface returns FirewallInterface or the interface of default route if it's not provided
+func (m *Manager) getFirewallInterface() (string, error) {
+	if m.FirewallInterface != "" {
+		return m.FirewallInterface, nil
+	}
+
+	routes, err := netlink.RouteGet(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		return "", err
+	}
+	if len(routes) == 0 {
+		return "", fmt.Errorf("no default route is found")
+	}
+
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return "", err
+	}
+
+	return link.Attrs().Name, nil
+}
+
+// removeFirewallChain removes chain and the rule which jumps to it from INPUT chain
+func (m *Manager) removeFirewallChain(chain string) error {
+	if err := m.deleteRuleIfExists(TableFilter, ChainInput, "-j", chain); err != nil {
+		return err
+	}
+
+	exists, err := m.ipt.ChainExists(TableFilter, chain)
+	if err != nil {
+		m.log.Error(err, "failed to check chain", "table", TableFilter, "chain", chain)
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	if err = m.ipt.ClearAndDeleteChain(TableFilter, chain); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableFilter, "chain", chain)
+	}
+	return err
+}
+
+// removeFirewallRules removes firewall made by agent, it's called when firewall is disabled
+func (m *Manager) removeFirewallRules() error {
+	if err := m.removeFirewallChain(ChainFabEdgeFirewallNew); err != nil {
+		return err
+	}
+
+	if err := m.removeFirewallChain(ChainFabEdgeFirewall); err != nil {
+		return err
+	}
+
+	m.firewallRules = nil
+	return nil
+}
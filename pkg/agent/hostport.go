@@ -0,0 +1,115 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const (
+	ChainOutput          = "OUTPUT"
+	ChainFabEdgeHostPort = "FABEDGE-HOST-PORT"
+)
+
+// ensureHostPortRules maps host ports of edge pods in tunnels config to pods by DNAT, like the
+// portmap plugin does, so host ports work even if the container runtime doesn't call portmap
+func (m *Manager) ensureHostPortRules(conf netconf.NetworkConf) error {
+	rules := buildHostPortRules(conf.HostPorts)
+
+	exists, err := m.ipt.Exists(TableNat, ChainPreRouting, hostPortJumpRule()...)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableNat, "chain", ChainPreRouting)
+		return err
+	}
+
+	if exists && reflect.DeepEqual(rules, m.hostPortRules) {
+		return nil
+	}
+
+	m.log.V(3).Info("update host port rules", "rules", len(rules))
+	if err = m.ipt.ClearChain(TableNat, ChainFabEdgeHostPort); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableNat, "chain", ChainFabEdgeHostPort)
+		return err
+	}
+
+	for _, rule := range rules {
+		if err = m.ipt.Append(TableNat, ChainFabEdgeHostPort, rule...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeHostPort, "rule", strings.Join(rule, " "))
+			return err
+		}
+	}
+
+	// requests from outside come by PREROUTING, those from the node itself come by OUTPUT
+	for _, chain := range []string{ChainPreRouting, ChainOutput} {
+		if err = m.ipt.AppendUnique(TableNat, chain, hostPortJumpRule()...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", chain, "rule", strings.Join(hostPortJumpRule(), " "))
+			return err
+		}
+	}
+
+	m.hostPortRules = rules
+	return nil
+}
+
+func buildHostPortRules(hostPorts []netconf.HostPort) [][]string {
+	var rules [][]string
+	for _, hp := range hostPorts {
+		rule := []string{"-p", strings.ToLower(string(hp.Protocol))}
+		if hp.HostIP != "" && hp.HostIP != "0.0.0.0" {
+			rule = append(rule, "-d", hp.HostIP)
+		}
+		rule = append(rule,
+			"--dport", fmt.Sprint(hp.HostPort),
+			"-j", "DNAT", "--to-destination", net.JoinHostPort(hp.PodIP, fmt.Sprint(hp.ContainerPort)),
+		)
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func hostPortJumpRule() []string {
+	return []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", ChainFabEdgeHostPort}
+}
+
+// removeHostPortRules removes iptables rules of host ports, it's used when fabedge is uninstalled
+func (m *Manager) removeHostPortRules() error {
+	for _, chain := range []string{ChainPreRouting, ChainOutput} {
+		if err := m.deleteRuleIfExists(TableNat, chain, hostPortJumpRule()...); err != nil {
+			return err
+		}
+	}
+
+	exists, err := m.ipt.ChainExists(TableNat, ChainFabEdgeHostPort)
+	if err != nil {
+		m.log.Error(err, "failed to check chain", "table", TableNat, "chain", ChainFabEdgeHostPort)
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	if err = m.ipt.ClearAndDeleteChain(TableNat, ChainFabEdgeHostPort); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableNat, "chain", ChainFabEdgeHostPort)
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fabedge/fabedge/third_party/ipvs"
+)
+
+// configureIPVSTimeouts sets timeouts of IPVS connections, a timeout of 0 keeps the value of system
+func (m *Manager) configureIPVSTimeouts() error {
+	if m.IPVSTCPTimeout == 0 && m.IPVSTCPFinTimeout == 0 && m.IPVSUDPTimeout == 0 {
+		return nil
+	}
+
+	return m.ipvs.ConfigureTimeouts(m.IPVSTCPTimeout, m.IPVSTCPFinTimeout, m.IPVSUDPTimeout)
+}
+
+// deleteRealServer deletes a real server which is gone from services config. If IPVSGracefulTermination
+// is set, a TCP real server with connections is kept with weight 0 instead, so no new connections go to
+// it, and it's deleted by a later sync when its connections are gone or the graceful period has passed
+func (m *Manager) deleteRealServer(vs *ipvs.VirtualServer, rs *ipvs.RealServer) error {
+	if m.IPVSGracefulTermination <= 0 || !ipvs.IsRsGracefulTerminationNeeded(vs.Protocol) {
+		return m.ipvs.DeleteRealServer(vs, rs)
+	}
+
+	m.terminatingMux.Lock()
+	defer m.terminatingMux.Unlock()
+
+	key := terminatingKey(vs, rs)
+	deadline, terminating := m.terminatingRealServers[key]
+	hasConnections := rs.ActiveConn+rs.InactiveConn > 0
+
+	if terminating && hasConnections && time.Now().Before(deadline) {
+		return nil
+	}
+
+	if !terminating && hasConnections {
+		weightless := *rs
+		weightless.Weight = 0
+		if err := m.ipvs.UpdateRealServer(vs, &weightless); err != nil {
+			return err
+		}
+
+		m.terminatingRealServers[key] = time.Now().Add(m.IPVSGracefulTermination)
+		m.log.V(3).Info("real server is terminating", "virtualServer", vs.String(), "realServer", rs.String())
+		return nil
+	}
+
+	delete(m.terminatingRealServers, key)
+	return m.ipvs.DeleteRealServer(vs, rs)
+}
+
+// restoreRealServer puts a terminating real server back to work if it's in services config again
+func (m *Manager) restoreRealServer(vs *ipvs.VirtualServer, old, rs *ipvs.RealServer) error {
+	if old.Weight == rs.Weight {
+		return nil
+	}
+
+	m.terminatingMux.Lock()
+	delete(m.terminatingRealServers, terminatingKey(vs, rs))
+	m.terminatingMux.Unlock()
+
+	return m.ipvs.UpdateRealServer(vs, rs)
+}
+
+// forgetTerminatingRealServers removes terminating real servers of a deleted virtual server
+func (m *Manager) forgetTerminatingRealServers(vs *ipvs.VirtualServer) {
+	m.terminatingMux.Lock()
+	defer m.terminatingMux.Unlock()
+
+	prefix := vs.String() + "/"
+	for key := range m.terminatingRealServers {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.terminatingRealServers, key)
+		}
+	}
+}
+
+func terminatingKey(vs *ipvs.VirtualServer, rs *ipvs.RealServer) string {
+	return vs.String() + "/" + rs.String()
+}
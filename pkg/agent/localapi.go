@@ -0,0 +1,90 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// localAPIConfig returns the config to access API on the node, it's nil if no local API is provided.
+// MetaServer of KubeEdge needs no credentials, lite-apiserver of SuperEdge forwards requests
+// with the service account token of agent
+func (cfg Config) localAPIConfig(kubeConfig *rest.Config) *rest.Config {
+	switch {
+	case cfg.MetaServerAddress != "":
+		return &rest.Config{Host: cfg.MetaServerAddress}
+	case cfg.LiteAPIServerAddress != "":
+		config := rest.CopyConfig(kubeConfig)
+		config.Host = cfg.LiteAPIServerAddress
+		// lite-apiserver is only reached by loopback address, its certificate is not issued by cluster CA
+		config.TLSClientConfig = rest.TLSClientConfig{Insecure: true}
+		return config
+	default:
+		return nil
+	}
+}
+
+func (cfg Config) localAPIAddress() string {
+	if cfg.MetaServerAddress != "" {
+		return cfg.MetaServerAddress
+	}
+	return cfg.LiteAPIServerAddress
+}
+
+// getKubeClient returns the client of local API if kube-apiserver can't be reached but local API
+// can, otherwise the client of kube-apiserver. MetaServer of edgecore and lite-apiserver serve API
+// from their local stores and pass writes to cloud by their own channels, so node condition and
+// relay registration still work when the edge node can't reach kube-apiserver directly
+func (m *Manager) getKubeClient() kubernetes.Interface {
+	if atomic.LoadInt32(&m.viaLocalAPI) == 1 {
+		return m.localClient
+	}
+	return m.kubeClient
+}
+
+// switchToLocalAPIIfNeeded decides which client is used according to apiErr, the result of
+// probing kube-apiserver, it returns an error only if neither of them can be reached
+func (m *Manager) switchToLocalAPIIfNeeded(apiErr error) error {
+	err := apiErr
+	viaLocalAPI := int32(0)
+	if apiErr != nil {
+		if err = m.probeLocalAPI(); err == nil {
+			viaLocalAPI = 1
+		}
+	}
+
+	if atomic.SwapInt32(&m.viaLocalAPI, viaLocalAPI) != viaLocalAPI {
+		if viaLocalAPI == 1 {
+			m.log.Error(apiErr, "kube-apiserver is unreachable, access API through local API", "address", m.localAPIAddress())
+		} else if err == nil {
+			m.log.Info("kube-apiserver is reachable again, stop using local API")
+		}
+	}
+
+	return err
+}
+
+func (m *Manager) probeLocalAPI() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cloudProbeTimeout)
+	defer cancel()
+
+	_, err := m.localClient.CoreV1().Nodes().Get(ctx, m.NodeName, metav1.GetOptions{})
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const ChainFabEdgeLocalIngress = "FABEDGE-LOCAL-INGRESS"
+
+// ensureLocalIngressRules maps local ingress ports on the LAN interface to services by DNAT, so devices
+// of the edge site reach services through tunnels. Those requests are masqueraded, or replies from
+// endpoints in cloud can't find their way back to the devices
+func (m *Manager) ensureLocalIngressRules(conf netconf.NetworkConf) error {
+	iface := conf.LocalIngressInterface
+	if iface == "" {
+		return m.removeLocalIngressRules()
+	}
+
+	rules := buildLocalIngressRules(conf.LocalIngresses)
+
+	exists, err := m.ipt.Exists(TableNat, ChainPreRouting, localIngressJumpRule(iface)...)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableNat, "chain", ChainPreRouting)
+		return err
+	}
+
+	if exists && reflect.DeepEqual(rules, m.localIngressRules) {
+		return nil
+	}
+
+	// the interface may be changed, jump rules of other interfaces are removed
+	if !exists {
+		if err = m.deleteLocalIngressJumpRules(iface); err != nil {
+			return err
+		}
+	}
+
+	m.log.V(3).Info("update local ingress rules", "interface", iface, "rules", len(rules))
+	if err = m.ipt.ClearChain(TableNat, ChainFabEdgeLocalIngress); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableNat, "chain", ChainFabEdgeLocalIngress)
+		return err
+	}
+
+	for _, rule := range rules {
+		if err = m.ipt.Append(TableNat, ChainFabEdgeLocalIngress, rule...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeLocalIngress, "rule", strings.Join(rule, " "))
+			return err
+		}
+	}
+
+	if err = m.ipt.AppendUnique(TableNat, ChainPreRouting, localIngressJumpRule(iface)...); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPreRouting, "rule", strings.Join(localIngressJumpRule(iface), " "))
+		return err
+	}
+
+	if err = m.ipt.AppendUnique(TableNat, ChainPostRouting, "-m", "mark", "--mark", MarkNodePortMasquerade, "-j", ChainMasquerade); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPostRouting, "rule", fmt.Sprintf("-m mark --mark %s -j %s", MarkNodePortMasquerade, ChainMasquerade))
+		return err
+	}
+
+	m.localIngressRules = rules
+	return nil
+}
+
+func buildLocalIngressRules(ingresses []netconf.LocalIngress) [][]string {
+	var rules [][]string
+	for _, ingress := range ingresses {
+		match := []string{
+			"-p", strings.ToLower(string(ingress.Protocol)),
+			"--dport", fmt.Sprint(ingress.Port),
+			"-m", "addrtype", "--dst-type", "LOCAL",
+		}
+
+		rules = append(rules,
+			append(append([]string{}, match...), "-j", "MARK", "--set-xmark", MarkNodePortMasquerade),
+			append(append([]string{}, match...), "-j", "DNAT", "--to-destination", net.JoinHostPort(ingress.ServiceIP, fmt.Sprint(ingress.ServicePort))),
+		)
+	}
+
+	return rules
+}
+
+func localIngressJumpRule(iface string) []string {
+	return []string{"-i", iface, "-j", ChainFabEdgeLocalIngress}
+}
+
+// deleteLocalIngressJumpRules deletes rules in PREROUTING which jump to local ingress chain
+// except the one of the interface kept
+func (m *Manager) deleteLocalIngressJumpRules(keptInterface string) error {
+	rules, err := m.ipt.List(TableNat, ChainPreRouting)
+	if err != nil {
+		m.log.Error(err, "failed to list rules", "table", TableNat, "chain", ChainPreRouting)
+		return err
+	}
+
+	for _, rule := range rules {
+		// a jump rule is listed like: -A PREROUTING -i eth1 -j FABEDGE-LOCAL-INGRESS
+		fields := strings.Fields(rule)
+		if len(fields) != 6 || fields[2] != "-i" || fields[5] != ChainFabEdgeLocalIngress || fields[3] == keptInterface {
+			continue
+		}
+
+		if err = m.deleteRuleIfExists(TableNat, ChainPreRouting, fields[2:]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeLocalIngressRules removes iptables rules of local ingress, it's used when local ingress is
+// disabled or fabedge is uninstalled. The masquerade rule is shared with node ports, it's kept here
+func (m *Manager) removeLocalIngressRules() error {
+	exists, err := m.ipt.ChainExists(TableNat, ChainFabEdgeLocalIngress)
+	if err != nil {
+		m.log.Error(err, "failed to check chain", "table", TableNat, "chain", ChainFabEdgeLocalIngress)
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	if err = m.deleteLocalIngressJumpRules(""); err != nil {
+		return err
+	}
+
+	if err = m.ipt.ClearAndDeleteChain(TableNat, ChainFabEdgeLocalIngress); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableNat, "chain", ChainFabEdgeLocalIngress)
+		return err
+	}
+
+	m.localIngressRules = nil
+	return nil
+}
@@ -15,22 +15,28 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/common/netconf"
 	"github.com/fabedge/fabedge/pkg/tunnel"
+	"github.com/fabedge/fabedge/pkg/util/conntrack"
 	"github.com/fabedge/fabedge/pkg/util/ipset"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
 	"github.com/fabedge/fabedge/third_party/ipvs"
 )
 
@@ -57,6 +63,60 @@ type Manager struct {
 
 	events   chan struct{}
 	debounce func(func())
+
+	kubeClient kubernetes.Interface
+	// configSource is nil if config files are provided by configmap volume
+	configSource *agentConfigSource
+
+	// loadedCertsPEM are local certificates which tunnels are loaded with
+	loadedCertsPEM []byte
+	// renewedCert is the last certificate renewed by API server, it may not reach
+	// the certificate file yet, because secret volume is updated by kubelet lazily
+	renewedCert *x509.Certificate
+	// bootstrapCAPEM are CA certificates which the bootstrapped certificate is verified with
+	bootstrapCAPEM []byte
+	// firewallRules are rules in firewall chain which are applied last time
+	firewallRules [][]string
+
+	// routeConntrack and natConntrack delete flows of peer subnets whose routes or outbound NAT rules are changed
+	routeConntrack conntrack.Cleaner
+	natConntrack   conntrack.Cleaner
+
+	// offline is 1 when kube-apiserver can't be reached, tasks which need cloud are skipped
+	offline int32
+	// localClient accesses API on the node by MetaServer of KubeEdge or lite-apiserver of SuperEdge,
+	// it's nil if neither is provided. viaLocalAPI is 1 when kube-apiserver can't be reached but
+	// local API can, see getKubeClient
+	localClient kubernetes.Interface
+	viaLocalAPI int32
+
+	// dnsForwarder is nil if DNSListenAddress is empty
+	dnsForwarder *dnsForwarder
+
+	// tunnelHistory is nil if TunnelHistorySize is 0
+	tunnelHistory *tunnel.History
+
+	// terminatingRealServers are real servers kept with weight 0 until their connections are gone,
+	// values are deadlines to delete them, see deleteRealServer
+	terminatingMux         sync.Mutex
+	terminatingRealServers map[string]time.Time
+
+	// nodePortRules are rules in node port chain which are applied last time
+	nodePortRules [][]string
+	// hostPortRules are rules in host port chain which are applied last time
+	hostPortRules [][]string
+	// localIngressRules are rules in local ingress chain which are applied last time
+	localIngressRules [][]string
+	// outboundRules are rules in outbound NAT chain which are applied last time
+	outboundRules [][]string
+
+	// bandwidthRules are bandwidth rules applied on bandwidthInterface last time
+	bandwidthInterface string
+	bandwidthRules     []netconf.BandwidthRule
+
+	// peersDownSince are edge peers whose tunnels are down, values are when they were found down
+	relayMux       sync.Mutex
+	peersDownSince map[string]time.Time
 }
 
 func (m *Manager) start() {
@@ -83,15 +143,33 @@ func (m *Manager) start() {
 			})
 		}
 
-		go retryForever(ctx, m.mainNetwork, func(n uint, err error) {
+		go retryForever(ctx, recordSync(taskNetwork, m.reportTo(m.mainNetwork)), func(n uint, err error) {
 			m.log.Error(err, "failed to configure network", "retryNum", n)
 		})
 
 		if m.EnableProxy {
-			go retryForever(ctx, m.syncLoadBalanceRules, func(n uint, err error) {
+			go retryForever(ctx, recordSync(taskLoadBalance, m.syncLoadBalanceRules), func(n uint, err error) {
 				m.log.Error(err, "failed to sync load balance rules", "retryNum", n)
 			})
 		}
+
+		if m.NodeCondition != "" {
+			go retryForever(ctx, m.whenOnline(m.syncNodeCondition), func(n uint, err error) {
+				m.log.Error(err, "failed to sync node condition", "retryNum", n)
+			})
+		}
+
+		if m.RelayTimeout > 0 {
+			go retryForever(ctx, m.whenOnline(m.syncRelayRegistration), func(n uint, err error) {
+				m.log.Error(err, "failed to sync relay registration", "retryNum", n)
+			})
+		}
+
+		if m.APIServerAddress != "" {
+			go retryForever(ctx, m.whenOnline(m.renewCert), func(n uint, err error) {
+				m.log.Error(err, "failed to renew certificate", "retryNum", n)
+			})
+		}
 	}
 }
 
@@ -103,35 +181,97 @@ func (m *Manager) notify() {
 
 func (m *Manager) mainNetwork() error {
 	m.log.V(3).Info("load network config")
-	conf, err := netconf.LoadNetworkConf(m.TunnelsConfPath)
+	conf, err := m.loadNetworkConf()
 	if err != nil {
 		return err
 	}
 
+	if m.CRLFile != "" {
+		m.log.V(3).Info("load CRL")
+		if err := tunnel.LoadCRLFile(m.tm, m.CRLFile); err != nil {
+			m.log.Error(err, "failed to load CRL", "file", m.CRLFile)
+		}
+	}
+
+	if m.CertBootstrap {
+		m.log.V(3).Info("load bootstrapped credentials")
+		if err := m.loadBootstrapCredentials(); err != nil {
+			return err
+		}
+	}
+
 	m.log.V(3).Info("synchronize tunnels")
 	if err := m.ensureConnections(conf); err != nil {
 		return err
 	}
+	m.recordTunnelStates(conf)
 
 	if m.EnableIPAM {
 		m.log.V(3).Info("generate cni config file")
 		if err := m.generateCNIConfig(conf); err != nil {
 			return err
 		}
+
+		m.log.V(3).Info("keep host port rules")
+		if err := m.ensureHostPortRules(conf); err != nil {
+			SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+			return err
+		}
 	}
 
 	m.log.V(3).Info("keep iptables rules")
 	if err := m.ensureIPTablesRules(conf); err != nil {
+		SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+		return err
+	}
+
+	m.log.V(3).Info("keep local ingress rules")
+	if err := m.ensureLocalIngressRules(conf); err != nil {
+		SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+		return err
+	}
+
+	if m.EnableFirewall {
+		m.log.V(3).Info("keep firewall rules")
+		if err := m.ensureFirewallRules(conf); err != nil {
+			SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+			return err
+		}
+	} else if err := m.removeFirewallRules(); err != nil {
+		SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
 		return err
 	}
 
 	m.log.V(3).Info("maintain dummy/xfrm interface and routes")
-	return m.ensureInterfacesAndRoutes(conf)
+	if err := m.ensureInterfacesAndRoutes(conf); err != nil {
+		return err
+	}
+
+	m.log.V(3).Info("keep bandwidth rules")
+	if err := m.ensureBandwidthRules(conf); err != nil {
+		SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+		return err
+	}
+
+	routed := sets.NewString()
+	for _, peer := range conf.Peers {
+		routed.Insert(peer.Subnets...)
+	}
+	m.clearConntrack(&m.routeConntrack, routed, "routes")
+
+	return nil
 }
 
 func (m *Manager) ensureConnections(conf netconf.NetworkConf) error {
 	newNames := sets.NewString()
 
+	// connections are loaded again when certificates are renewed, so they are used without restarting strongswan
+	certsPEM := m.readLocalCerts()
+	certsChanged := m.loadedCertsPEM != nil && !bytes.Equal(certsPEM, m.loadedCertsPEM)
+	if certsChanged {
+		m.log.V(3).Info("local certificates are changed, reload tunnels")
+	}
+
 	for _, peer := range conf.Peers {
 		newNames.Insert(peer.Name)
 
@@ -151,17 +291,23 @@ func (m *Manager) ensureConnections(conf netconf.NetworkConf) error {
 			RemoteType:        peer.Type,
 		}
 
-		m.log.V(5).Info("try to add tunnel", "name", peer.Name, "peer", peer)
+		m.log.V(5).Info("try to add tunnel", logutil.KeyEndpoint, peer.Name, "peer", peer)
 		if err := m.tm.LoadConn(conn); err != nil {
-			m.log.Error(err, "failed to add tunnel", "tunnel", conn)
+			m.log.Error(err, "failed to add tunnel", logutil.KeyEndpoint, peer.Name, "tunnel", conn)
 			continue
 		}
 
-		m.log.V(5).Info("try to initiate tunnel", "name", peer.Name)
+		if certsChanged {
+			if err := m.tm.ReloadConn(conn); err != nil {
+				m.log.Error(err, "failed to reload tunnel", logutil.KeyEndpoint, peer.Name, "tunnel", conn)
+			}
+		}
+
+		m.log.V(5).Info("try to initiate tunnel", logutil.KeyEndpoint, peer.Name)
 		// this may lead to duplicate child sa in strongswan since sometimes two agents try to initiate
 		// the same connection on each side at the same time
 		if err := m.tm.InitiateConn(peer.Name); err != nil {
-			m.log.Error(err, "failed to initiate tunnel", "tunnel", conn)
+			m.log.Error(err, "failed to initiate tunnel", logutil.KeyEndpoint, peer.Name, "tunnel", conn)
 		}
 	}
 
@@ -171,6 +317,8 @@ func (m *Manager) ensureConnections(conf netconf.NetworkConf) error {
 		return err
 	}
 
+	m.loadedCertsPEM = certsPEM
+
 	m.log.V(5).Info("clean useless tunnels")
 	for _, name := range oldNames {
 		if newNames.Has(name) {
@@ -178,7 +326,7 @@ func (m *Manager) ensureConnections(conf netconf.NetworkConf) error {
 		}
 
 		if err := m.tm.UnloadConn(name); err != nil {
-			m.log.Error(err, "failed to unload tunnel", "name", name)
+			m.log.Error(err, "failed to unload tunnel", logutil.KeyEndpoint, name)
 		}
 	}
 
@@ -210,48 +358,9 @@ func (m *Manager) ensureIPTablesRules(conf netconf.NetworkConf) error {
 			return err
 		}
 
-		if err := m.configureOutboundRules(subnet); err != nil {
-			return err
-		}
 	}
 
-	return nil
-}
-
-// outbound NAT from pods to outside the cluster
-func (m *Manager) configureOutboundRules(subnet string) error {
-	if err := m.ipt.ClearChain(TableNat, ChainFabEdgeNatOutgoing); err != nil {
-		m.log.Error(err, "failed to check or add rule", "table", TableNat, "chain", ChainFabEdgeNatOutgoing)
-		return err
-	}
-
-	if m.MASQOutgoing {
-		m.log.V(3).Info("configure outgoing NAT iptables rules")
-
-		ensureRule := m.ipt.AppendUnique
-		if err := ensureRule(TableNat, ChainFabEdgeNatOutgoing, "-s", subnet, "-m", "set", "--match-set", IPSetFabEdgePeerCIDR, "dst", "-j", "RETURN"); err != nil {
-			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeNatOutgoing, "rule", fmt.Sprintf("-s %s -m set --match-set %s dst -j RETURN", subnet, IPSetFabEdgePeerCIDR))
-			return err
-		}
-
-		ensureRule = m.ipt.AppendUnique
-		if err := ensureRule(TableNat, ChainFabEdgeNatOutgoing, "-s", subnet, "-d", subnet, "-j", "RETURN"); err != nil {
-			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeNatOutgoing, "rule", fmt.Sprintf("-s %s -d %s -j RETURN", subnet, subnet))
-			return err
-		}
-
-		if err := ensureRule(TableNat, ChainFabEdgeNatOutgoing, "-s", subnet, "-j", ChainMasquerade); err != nil {
-			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeNatOutgoing, "rule", fmt.Sprintf("-s %s -j %s", subnet, ChainMasquerade))
-			return err
-		}
-
-		if err := ensureRule(TableNat, ChainPostRouting, "-j", ChainFabEdgeNatOutgoing); err != nil {
-			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPostRouting, "rule", fmt.Sprintf("-j %s", ChainFabEdgeNatOutgoing))
-			return err
-		}
-	}
-
-	return nil
+	return m.configureOutboundRules(conf)
 }
 
 func (m *Manager) ensureChain(table, chain string) error {
@@ -397,24 +506,45 @@ func (m *Manager) syncLoadBalanceRules() error {
 	// sync service clusterIP bound to kube-ipvs0
 	// sync ipvs
 	m.log.V(3).Info("load services config file")
-	conf, err := loadServiceConf(m.ServicesConfPath)
+	conf, err := m.loadServiceConf()
 	if err != nil {
 		m.log.Error(err, "failed to load services config")
 		return err
 	}
 
+	nodeIPs, err := m.getNodeIPs()
+	if err != nil {
+		m.log.Error(err, "failed to get addresses of node")
+		return err
+	}
+
 	m.log.V(3).Info("binding cluster ips to dummy interface")
-	servers := toServers(conf)
+	servers := toServers(conf, nodeIPs)
 	if err = m.syncServiceClusterIPBind(servers); err != nil {
 		return err
 	}
 
+	if err = m.syncNodePortRules(conf); err != nil {
+		SyncErrorsTotal.WithLabelValues(taskIPTables).Inc()
+		return err
+	}
+
+	if err = m.configureIPVSTimeouts(); err != nil {
+		m.log.Error(err, "failed to configure ipvs timeouts")
+		return err
+	}
+
 	m.log.V(3).Info("synchronize ipvs rules")
-	return m.syncVirtualServer(servers)
+	if err = m.syncVirtualServer(servers); err != nil {
+		return err
+	}
+
+	recordProxyRules(servers)
+	return nil
 }
 
 func (m *Manager) getConnectorSubnets() (subnets []string, err error) {
-	conf, err := netconf.LoadNetworkConf(m.TunnelsConfPath)
+	conf, err := m.loadNetworkConf()
 	if err != nil {
 		return nil, err
 	}
@@ -440,8 +570,16 @@ func (m *Manager) syncServiceClusterIPBind(servers []server) error {
 	boundedAddresses := sets.NewString(addresses...)
 	allServiceAddresses := sets.NewString()
 	for _, s := range servers {
+		if s.nodePort {
+			continue
+		}
 		allServiceAddresses.Insert(s.virtualServer.Address.String())
 	}
+	// the address of DNS forwarder is bound to the same interface
+	if m.DNSListenAddress != "" {
+		host, _, _ := net.SplitHostPort(m.DNSListenAddress)
+		boundedAddresses.Delete(host)
+	}
 
 	for addr := range allServiceAddresses.Difference(boundedAddresses) {
 		if _, err = m.netLink.EnsureAddressBind(addr, m.DummyInterfaceName); err != nil {
@@ -502,11 +640,20 @@ func (m *Manager) syncVirtualServer(servers []server) error {
 			m.log.Error(err, "failed to delete virtual server", "virtualServer", vs)
 			return err
 		}
+		m.forgetTerminatingRealServers(oldVirtualServerMap[vs])
 	}
 
 	virtualServersToUpdate := allVirtualServerSet.Intersection(oldVirtualServerSet)
 	for vs := range virtualServersToUpdate {
 		virtualServer := allVirtualServerMap[vs]
+		// scheduler, session affinity and its timeout may be changed
+		if !virtualServer.Equal(oldVirtualServerMap[vs]) {
+			if err := m.ipvs.UpdateVirtualServer(virtualServer); err != nil {
+				m.log.Error(err, "failed to update virtual server", "virtualServer", vs)
+				return err
+			}
+		}
+
 		realServers := allVirtualServers[vs].realServers
 		if err := m.updateRealServers(virtualServer, realServers); err != nil {
 			return err
@@ -546,12 +693,20 @@ func (m *Manager) updateRealServers(virtualServer *ipvs.VirtualServer, realServe
 
 	realServersToDel := oldRealServerSet.Difference(allRealServerSet)
 	for rs := range realServersToDel {
-		if err := m.ipvs.DeleteRealServer(virtualServer, oldRealServerMap[rs]); err != nil {
+		if err := m.deleteRealServer(virtualServer, oldRealServerMap[rs]); err != nil {
 			m.log.Error(err, "failed to delete real server", "realServer", rs)
 			return err
 		}
 	}
 
+	realServersToKeep := allRealServerSet.Intersection(oldRealServerSet)
+	for rs := range realServersToKeep {
+		if err := m.restoreRealServer(virtualServer, oldRealServerMap[rs], allRealServerMap[rs]); err != nil {
+			m.log.Error(err, "failed to restore real server", "realServer", rs)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -571,11 +726,18 @@ func (m *Manager) syncIPSetPeerCIDR() error {
 		return err
 	}
 
-	return m.ipset.SyncIPSetEntries(ipsetObj, allPeerCIDRs, oldPeerCIDRs, ipset.HashNet)
+	if err = m.ipset.SyncIPSetEntries(ipsetObj, allPeerCIDRs, oldPeerCIDRs, ipset.HashNet); err != nil {
+		return err
+	}
+
+	// traffic to peer CIDRs is not masqueraded by outbound NAT rules
+	m.clearConntrack(&m.natConntrack, allPeerCIDRs, "outbound NAT rules")
+
+	return nil
 }
 
 func (m *Manager) getAllPeerCIDRs() (sets.String, error) {
-	conf, err := netconf.LoadNetworkConf(m.TunnelsConfPath)
+	conf, err := m.loadNetworkConf()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,96 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+// configureOutboundRules makes outbound NAT from pods to outside the cluster. Traffic to peers and
+// non-masquerade CIDRs keeps its source addresses, so devices there can reach edge pods back.
+// If masquerade CIDRs are provided, only traffic to them is masqueraded, otherwise traffic to
+// anywhere else is masqueraded
+func (m *Manager) configureOutboundRules(conf netconf.NetworkConf) error {
+	if !m.MASQOutgoing {
+		if err := m.ipt.ClearChain(TableNat, ChainFabEdgeNatOutgoing); err != nil {
+			m.log.Error(err, "failed to clear chain", "table", TableNat, "chain", ChainFabEdgeNatOutgoing)
+			return err
+		}
+		m.outboundRules = nil
+		return nil
+	}
+
+	rules := buildOutboundRules(conf)
+
+	exists, err := m.ipt.Exists(TableNat, ChainPostRouting, "-j", ChainFabEdgeNatOutgoing)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableNat, "chain", ChainPostRouting)
+		return err
+	}
+
+	if exists && reflect.DeepEqual(rules, m.outboundRules) {
+		return nil
+	}
+
+	m.log.V(3).Info("configure outgoing NAT iptables rules", "rules", len(rules))
+	if err = m.ipt.ClearChain(TableNat, ChainFabEdgeNatOutgoing); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableNat, "chain", ChainFabEdgeNatOutgoing)
+		return err
+	}
+
+	for _, rule := range rules {
+		if err = m.ipt.Append(TableNat, ChainFabEdgeNatOutgoing, rule...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeNatOutgoing, "rule", strings.Join(rule, " "))
+			return err
+		}
+	}
+
+	if err = m.ipt.AppendUnique(TableNat, ChainPostRouting, "-j", ChainFabEdgeNatOutgoing); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPostRouting, "rule", fmt.Sprintf("-j %s", ChainFabEdgeNatOutgoing))
+		return err
+	}
+
+	m.outboundRules = rules
+	return nil
+}
+
+func buildOutboundRules(conf netconf.NetworkConf) [][]string {
+	var rules [][]string
+	for _, subnet := range conf.Subnets {
+		rules = append(rules,
+			[]string{"-s", subnet, "-m", "set", "--match-set", IPSetFabEdgePeerCIDR, "dst", "-j", "RETURN"},
+			[]string{"-s", subnet, "-d", subnet, "-j", "RETURN"},
+		)
+
+		for _, cidr := range conf.NonMasqueradeCIDRs {
+			rules = append(rules, []string{"-s", subnet, "-d", cidr, "-j", "RETURN"})
+		}
+
+		if len(conf.MasqueradeCIDRs) == 0 {
+			rules = append(rules, []string{"-s", subnet, "-j", ChainMasquerade})
+			continue
+		}
+
+		for _, cidr := range conf.MasqueradeCIDRs {
+			rules = append(rules, []string{"-s", subnet, "-d", cidr, "-j", ChainMasquerade})
+		}
+	}
+
+	return rules
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	tunnelStateEstablished = "established"
+	tunnelStateDown        = "down"
+
+	taskNetwork     = "network"
+	taskIPTables    = "iptables"
+	taskLoadBalance = "loadbalance"
+
+	dnsResultCached    = "cached"
+	dnsResultForwarded = "forwarded"
+	dnsResultStale     = "stale"
+	dnsResultFailed    = "failed"
+)
+
+var (
+	Tunnels = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "tunnels",
+		Help:      "Number of tunnels in tunnels config, partitioned by state",
+	}, []string{"state"})
+
+	SyncErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "sync_errors_total",
+		Help:      "Number of failed sync tasks, partitioned by task: network, iptables or loadbalance",
+	}, []string{"task"})
+
+	LastSyncTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "last_sync_timestamp_seconds",
+		Help:      "Unix time when a sync task succeeded last time, partitioned by task: network or loadbalance",
+	}, []string{"task"})
+
+	ProxyVirtualServers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "proxy_virtual_servers",
+		Help:      "Number of IPVS virtual servers programmed by proxy",
+	})
+
+	ProxyRealServers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "proxy_real_servers",
+		Help:      "Number of IPVS real servers programmed by proxy",
+	})
+
+	DNSQueriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "agent",
+		Name:      "dns_queries_total",
+		Help:      "Number of DNS queries served by agent, partitioned by result: cached, forwarded, stale or failed",
+	}, []string{"result"})
+
+	ProberTargetReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "prober",
+		Name:      "target_reachable",
+		Help:      "Whether a target passed the last test of prober, partitioned by target, kind (pod or service) and protocol",
+	}, []string{"target", "kind", "protocol"})
+
+	ProberTargetRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "prober",
+		Name:      "target_rtt_seconds",
+		Help:      "Round-trip time of the last passed test of prober, partitioned by target, kind (pod or service) and protocol",
+	}, []string{"target", "kind", "protocol"})
+
+	ProberViolationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "prober",
+		Name:      "violations_total",
+		Help:      "Number of failed tests of prober, partitioned by target, kind (pod or service) and protocol",
+	}, []string{"target", "kind", "protocol"})
+)
+
+func init() {
+	prometheus.MustRegister(Tunnels, SyncErrorsTotal, LastSyncTimestamp, ProxyVirtualServers, ProxyRealServers, DNSQueriesTotal)
+	prometheus.MustRegister(ProberTargetReachable, ProberTargetRTT, ProberViolationsTotal)
+}
+
+// recordSync wraps a sync task, its errors and the time of its last success are recorded
+func recordSync(task string, fn func() error) func() error {
+	return func() error {
+		if err := fn(); err != nil {
+			SyncErrorsTotal.WithLabelValues(task).Inc()
+			return err
+		}
+
+		LastSyncTimestamp.WithLabelValues(task).SetToCurrentTime()
+		return nil
+	}
+}
+
+// recordTunnelStates counts tunnels of peers by whether any child SA is established
+func (m *Manager) recordTunnelStates(conf netconf.NetworkConf) {
+	Tunnels.Reset()
+	Tunnels.WithLabelValues(tunnelStateEstablished).Set(0)
+	Tunnels.WithLabelValues(tunnelStateDown).Set(0)
+
+	for _, peer := range conf.Peers {
+		state := tunnelStateDown
+		if established, err := m.tm.IsConnEstablished(peer.Name); err != nil {
+			m.log.V(3).Info("failed to get state of tunnel", logutil.KeyEndpoint, peer.Name, "error", err)
+		} else if established {
+			state = tunnelStateEstablished
+		}
+
+		Tunnels.WithLabelValues(state).Inc()
+	}
+}
+
+func recordProxyRules(servers []server) {
+	realServers := 0
+	for _, s := range servers {
+		realServers += len(s.realServers)
+	}
+
+	ProxyVirtualServers.Set(float64(len(servers)))
+	ProxyRealServers.Set(float64(realServers))
+}
+
+// checkHealth returns error if strongswan can't be reached
+func (m *Manager) checkHealth() error {
+	_, err := m.tm.IsActive()
+	return err
+}
+
+// serveMetrics serves /metrics and /healthz, agent is healthy if strongswan can be reached.
+// Tunnel events are served at /tunnel-events if tunnel history is enabled
+func (m *Manager) serveMetrics() error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if m.tunnelHistory != nil {
+		mux.Handle("/tunnel-events", m.tunnelHistory)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := m.checkHealth(); err != nil {
+			http.Error(w, fmt.Sprintf("strongswan is unreachable: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{
+		Addr:         m.MetricsBindAddress,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	m.log.Info("serve metrics", "address", m.MetricsBindAddress)
+	return server.ListenAndServe()
+}
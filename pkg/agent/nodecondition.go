@@ -0,0 +1,121 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	ReasonTunnelEstablished = "FabEdgeTunnelEstablished"
+	ReasonTunnelDown        = "FabEdgeTunnelDown"
+)
+
+// syncNodeCondition reports whether tunnels to connector are established by node condition.
+// If the condition type is NetworkUnavailable, its status is reversed, so node lifecycle
+// controller will taint this node and new workloads won't be scheduled here.
+func (m *Manager) syncNodeCondition() error {
+	conf, err := m.loadNetworkConf()
+	if err != nil {
+		return err
+	}
+
+	established, message := m.areConnectorTunnelsEstablished(conf)
+
+	status, reason := corev1.ConditionTrue, ReasonTunnelEstablished
+	if !established {
+		status, reason = corev1.ConditionFalse, ReasonTunnelDown
+	}
+
+	conditionType := corev1.NodeConditionType(m.NodeCondition)
+	if conditionType == corev1.NodeNetworkUnavailable {
+		if status == corev1.ConditionTrue {
+			status = corev1.ConditionFalse
+		} else {
+			status = corev1.ConditionTrue
+		}
+	}
+
+	ctx := context.Background()
+	node, err := m.getKubeClient().CoreV1().Nodes().Get(ctx, m.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == status && condition.Reason == reason {
+			return nil
+		}
+	}
+
+	now := metav1.Now()
+	condition := corev1.NodeCondition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	m.log.V(3).Info("update node condition", "type", conditionType, "status", status, "reason", reason)
+	_, err = m.getKubeClient().CoreV1().Nodes().Patch(ctx, m.NodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	return err
+}
+
+func (m *Manager) areConnectorTunnelsEstablished(conf netconf.NetworkConf) (bool, string) {
+	found := false
+	for _, peer := range conf.Peers {
+		if peer.Type != apis.Connector {
+			continue
+		}
+		found = true
+
+		established, err := m.tm.IsConnEstablished(peer.Name)
+		if err != nil {
+			m.log.Error(err, "failed to check tunnel state", logutil.KeyEndpoint, peer.Name)
+			return false, fmt.Sprintf("failed to check tunnel to %s", peer.Name)
+		}
+
+		if !established {
+			return false, fmt.Sprintf("tunnel to %s is not established", peer.Name)
+		}
+	}
+
+	if !found {
+		return false, "no connector is found in tunnels configuration"
+	}
+
+	return true, "tunnels to connector are established"
+}
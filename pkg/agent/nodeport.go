@@ -0,0 +1,151 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const (
+	ChainPreRouting      = "PREROUTING"
+	ChainFabEdgeNodePort = "FABEDGE-NODE-PORT"
+	// MarkNodePortMasquerade is different from the mark of kube-proxy, so they won't disturb each other
+	MarkNodePortMasquerade = "0x8000/0x8000"
+
+	ipvsConntrackFile = "/proc/sys/net/ipv4/vs/conntrack"
+)
+
+// getNodeIPs returns IPv4 addresses of the node which node ports are served on, addresses of the
+// dummy interface and loopback addresses are excluded
+func (m *Manager) getNodeIPs() ([]string, error) {
+	addresses, err := m.netLink.GetLocalAddresses("", m.DummyInterfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, address := range addresses.List() {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil || ip.IsLoopback() {
+			continue
+		}
+		ips = append(ips, address)
+	}
+
+	return ips, nil
+}
+
+// syncNodePortRules marks requests to node ports of services whose external traffic policy is Cluster,
+// those requests are masqueraded, so replies from endpoints on other nodes come back through this node.
+// Requests to node ports of services whose external traffic policy is Local keep their source addresses
+func (m *Manager) syncNodePortRules(conf netconf.VirtualServers) error {
+	rules := buildNodePortRules(conf)
+
+	exists, err := m.ipt.Exists(TableNat, ChainPreRouting, "-j", ChainFabEdgeNodePort)
+	if err != nil {
+		m.log.Error(err, "failed to check rule", "table", TableNat, "chain", ChainPreRouting)
+		return err
+	}
+
+	if exists && reflect.DeepEqual(rules, m.nodePortRules) {
+		return nil
+	}
+
+	if len(rules) > 0 {
+		if err = ioutil.WriteFile(ipvsConntrackFile, []byte("1"), 0644); err != nil {
+			m.log.Error(err, "failed to enable conntrack of ipvs", "file", ipvsConntrackFile)
+			return err
+		}
+	}
+
+	m.log.V(3).Info("update node port rules", "rules", len(rules))
+	if err = m.ipt.ClearChain(TableNat, ChainFabEdgeNodePort); err != nil {
+		m.log.Error(err, "failed to clear chain", "table", TableNat, "chain", ChainFabEdgeNodePort)
+		return err
+	}
+
+	for _, rule := range rules {
+		if err = m.ipt.Append(TableNat, ChainFabEdgeNodePort, rule...); err != nil {
+			m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainFabEdgeNodePort, "rule", strings.Join(rule, " "))
+			return err
+		}
+	}
+
+	ensureRule := m.ipt.AppendUnique
+	if err = ensureRule(TableNat, ChainPreRouting, "-j", ChainFabEdgeNodePort); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPreRouting, "rule", fmt.Sprintf("-j %s", ChainFabEdgeNodePort))
+		return err
+	}
+
+	if err = ensureRule(TableNat, ChainPostRouting, "-m", "mark", "--mark", MarkNodePortMasquerade, "-j", ChainMasquerade); err != nil {
+		m.log.Error(err, "failed to append rule", "table", TableNat, "chain", ChainPostRouting, "rule", fmt.Sprintf("-m mark --mark %s -j %s", MarkNodePortMasquerade, ChainMasquerade))
+		return err
+	}
+
+	m.nodePortRules = rules
+	return nil
+}
+
+func buildNodePortRules(conf netconf.VirtualServers) [][]string {
+	var rules [][]string
+	for _, vs := range conf {
+		if vs.NodePort == 0 || vs.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+			continue
+		}
+
+		rules = append(rules, []string{
+			"-p", strings.ToLower(string(vs.Protocol)),
+			"--dport", fmt.Sprint(vs.NodePort),
+			"-m", "addrtype", "--dst-type", "LOCAL",
+			"-j", "MARK", "--set-xmark", MarkNodePortMasquerade,
+		})
+	}
+
+	return rules
+}
+
+// removeNodePortRules removes iptables rules of node ports, it's used when fabedge is uninstalled
+func (m *Manager) removeNodePortRules() error {
+	if err := m.deleteRuleIfExists(TableNat, ChainPreRouting, "-j", ChainFabEdgeNodePort); err != nil {
+		return err
+	}
+
+	if err := m.deleteRuleIfExists(TableNat, ChainPostRouting, "-m", "mark", "--mark", MarkNodePortMasquerade, "-j", ChainMasquerade); err != nil {
+		return err
+	}
+
+	exists, err := m.ipt.ChainExists(TableNat, ChainFabEdgeNodePort)
+	if err != nil {
+		m.log.Error(err, "failed to check chain", "table", TableNat, "chain", ChainFabEdgeNodePort)
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	if err = m.ipt.ClearAndDeleteChain(TableNat, ChainFabEdgeNodePort); err != nil {
+		m.log.Error(err, "failed to delete chain", "table", TableNat, "chain", ChainFabEdgeNodePort)
+	}
+	return err
+}
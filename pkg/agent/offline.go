@@ -0,0 +1,164 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const cloudProbeTimeout = 5 * time.Second
+
+// loadNetworkConf loads tunnels config, see loadConfFile
+func (m *Manager) loadNetworkConf() (netconf.NetworkConf, error) {
+	var conf netconf.NetworkConf
+	err := m.loadConfFile(m.TunnelsConfPath, func(data []byte) error {
+		conf = netconf.NetworkConf{}
+		return yaml.Unmarshal(data, &conf)
+	})
+	return conf, err
+}
+
+// loadServiceConf loads services config, see loadConfFile
+func (m *Manager) loadServiceConf() (netconf.VirtualServers, error) {
+	var conf netconf.VirtualServers
+	err := m.loadConfFile(m.ServicesConfPath, func(data []byte) error {
+		conf = nil
+		return yaml.Unmarshal(data, &conf)
+	})
+	return conf, err
+}
+
+// loadConfFile reads path and parses it. If CacheDir is set, a copy of the file is kept there after
+// it's parsed, the copy is parsed instead when the file can't be read or parsed, e.g. agent restarts
+// while the edge node is cut off from cloud and the configmap volume can't be refreshed
+func (m *Manager) loadConfFile(path string, parse func(data []byte) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err = parse(data); err == nil {
+			m.saveCache(path, data)
+			return nil
+		}
+	}
+
+	if m.CacheDir == "" {
+		return err
+	}
+
+	cached, cacheErr := ioutil.ReadFile(m.cachePath(path))
+	if cacheErr != nil || parse(cached) != nil {
+		return err
+	}
+
+	m.log.Error(err, "failed to load config, the cached one is used", "file", path, "cache", m.cachePath(path))
+	return nil
+}
+
+func (m *Manager) cachePath(path string) string {
+	return filepath.Join(m.CacheDir, filepath.Base(path))
+}
+
+// saveCache writes data to the cache of path if it's changed, it's renamed in place, so readers never see a partial file
+func (m *Manager) saveCache(path string, data []byte) {
+	if m.CacheDir == "" {
+		return
+	}
+
+	cachePath := m.cachePath(path)
+	if cached, err := ioutil.ReadFile(cachePath); err == nil && bytes.Equal(cached, data) {
+		return
+	}
+
+	tmpFile, err := ioutil.TempFile(m.CacheDir, filepath.Base(path))
+	if err != nil {
+		m.log.Error(err, "failed to cache config", "file", path)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), cachePath)
+	}
+
+	if err != nil {
+		m.log.Error(err, "failed to cache config", "file", path)
+		return
+	}
+	m.log.V(3).Info("config is cached", "file", path, "cache", cachePath)
+}
+
+func (m *Manager) isOffline() bool {
+	return atomic.LoadInt32(&m.offline) == 1
+}
+
+// whenOnline returns a function which calls fn only if cloud is reachable, so tasks which
+// need cloud are not retried while the edge node is offline
+func (m *Manager) whenOnline(fn func() error) func() error {
+	return func() error {
+		if m.isOffline() {
+			return nil
+		}
+		return fn()
+	}
+}
+
+// watchCloud checks whether kube-apiserver is reachable every SyncPeriod. Agent keeps running with
+// the last known config while it's unreachable, everything is synced again when it's back.
+// If local API is provided, cloud is taken as reachable as long as local API is
+func (m *Manager) watchCloud() {
+	tick := time.NewTicker(m.SyncPeriod)
+	defer tick.Stop()
+
+	for ; ; <-tick.C {
+		err := m.probeCloud()
+		if m.localClient != nil {
+			err = m.switchToLocalAPIIfNeeded(err)
+		}
+
+		offline := err != nil
+		if offline == m.isOffline() {
+			continue
+		}
+
+		if offline {
+			atomic.StoreInt32(&m.offline, 1)
+			m.log.Error(err, "cloud is unreachable, keep running with the last known config")
+		} else {
+			atomic.StoreInt32(&m.offline, 0)
+			m.log.Info("cloud is reachable again, sync everything")
+			m.notify()
+		}
+	}
+}
+
+func (m *Manager) probeCloud() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cloudProbeTimeout)
+	defer cancel()
+
+	return m.kubeClient.Discovery().RESTClient().Get().AbsPath("/healthz").Do(ctx).Error()
+}
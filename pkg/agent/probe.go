@@ -0,0 +1,64 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"time"
+
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+	"github.com/fabedge/fabedge/pkg/util/probe"
+)
+
+// probePeers pings peers in tunnels config through tunnels every ProbeInterval and reports
+// round-trip time and loss in status of agent config. Nothing is reported while the edge node is offline
+func (m *Manager) probePeers() {
+	tick := time.NewTicker(m.ProbeInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		if err := m.probeAndReport(); err != nil {
+			m.log.Error(err, "failed to report probes of peers")
+		}
+	}
+}
+
+func (m *Manager) probeAndReport() error {
+	conf, err := m.loadNetworkConf()
+	if err != nil {
+		return err
+	}
+
+	targets := make([]probe.Target, 0, len(conf.Peers))
+	for _, peer := range conf.Peers {
+		if target, ok := probe.TargetOf(peer.Name, peer.NodeSubnets); ok {
+			targets = append(targets, target)
+		}
+	}
+
+	results := probe.ProbeAll(targets, m.ProbeCount, m.ProbeTimeout)
+	for _, r := range results {
+		if r.Err != nil {
+			m.log.Error(r.Err, "failed to ping peer", logutil.KeyEndpoint, r.Name, "address", r.Address)
+		} else {
+			m.log.V(5).Info("peer is probed", logutil.KeyEndpoint, r.Name, "rtt", r.RTT, "loss", r.Loss())
+		}
+	}
+
+	if m.isOffline() {
+		return nil
+	}
+
+	return m.configSource.reportProbes(probe.ToPeerProbes(results, time.Now()))
+}
@@ -0,0 +1,249 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+	"github.com/fabedge/fabedge/pkg/util/probe"
+)
+
+const (
+	reasonTargetUnreachable = "TargetUnreachable"
+	reasonTargetReachable   = "TargetReachable"
+
+	targetKindPod     = "pod"
+	targetKindService = "service"
+	// servicePrefix marks targets which are cluster IPs of services
+	servicePrefix = "service/"
+)
+
+// prober tests targets of a node continuously, results are exported as metrics and
+// changes of reachability are recorded as events on the prober pod
+type prober struct {
+	*Config
+
+	protocols []apis.CheckProtocol
+	recorder  record.EventRecorder
+	pod       *corev1.ObjectReference
+	log       logr.Logger
+
+	// unreachable holds tests which failed last round, keys are like edge2/TCP
+	unreachable map[string]bool
+}
+
+// runProber runs agent as a prober of the prober DaemonSet made by operator, it echoes tests of
+// other probers like a server and tests targets in CheckTargetsFile every CheckInterval
+func runProber(cfg *Config) error {
+	if cfg.CheckInterval <= 0 || cfg.CheckTargetsFile == "" {
+		return fmt.Errorf("check interval must be positive and check targets file is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	p := &prober{
+		Config:      cfg,
+		protocols:   checkProtocols(cfg),
+		log:         logutil.New("prober"),
+		unreachable: make(map[string]bool),
+	}
+
+	if cfg.CheckPodName != "" {
+		if err := p.setupRecorder(); err != nil {
+			// metrics are still useful without events
+			p.log.Error(err, "failed to set up event recorder, events won't be recorded")
+		}
+	}
+
+	if cfg.MetricsBindAddress != "0" && cfg.MetricsBindAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			})
+
+			server := &http.Server{Addr: cfg.MetricsBindAddress, Handler: mux}
+			if err := server.ListenAndServe(); err != nil {
+				p.log.Error(err, "failed to serve metrics")
+			}
+		}()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- probe.Serve(ctx, cfg.CheckPort)
+	}()
+
+	tick := time.NewTicker(cfg.CheckInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			p.probe()
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *prober) setupRecorder() error {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(p.CheckPodNamespace)})
+
+	p.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "fabedge-prober", Host: p.NodeName})
+	p.pod = &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       p.CheckPodName,
+		Namespace:  p.CheckPodNamespace,
+	}
+
+	return nil
+}
+
+// probe tests each target with each protocol, ICMP is not tested against services
+// because cluster IPs are not supposed to answer it
+func (p *prober) probe() {
+	targets, err := p.loadTargets()
+	if err != nil {
+		p.log.Error(err, "failed to load targets", "file", p.CheckTargetsFile)
+		return
+	}
+
+	var results []probe.CheckResult
+	for _, target := range targets {
+		for _, protocol := range p.protocols {
+			if protocol == apis.CheckProtocolICMP && targetKind(target.Name) == targetKindService {
+				continue
+			}
+			results = append(results, probe.CheckResult{Target: target, Protocol: protocol})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(r *probe.CheckResult) {
+			defer wg.Done()
+			*r = probe.Check(r.Target, r.Protocol, p.CheckPort, p.CheckTimeout)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	// removed targets shouldn't be reported any more
+	ProberTargetReachable.Reset()
+	ProberTargetRTT.Reset()
+
+	tested := make(map[string]bool, len(results))
+	for _, r := range results {
+		key := r.Name + "/" + string(r.Protocol)
+		tested[key] = true
+
+		name, kind, protocol := targetName(r.Name), targetKind(r.Name), string(r.Protocol)
+		if r.Err == nil {
+			ProberTargetReachable.WithLabelValues(name, kind, protocol).Set(1)
+			ProberTargetRTT.WithLabelValues(name, kind, protocol).Set(r.RTT.Seconds())
+
+			if p.unreachable[key] {
+				delete(p.unreachable, key)
+				p.recordEvent(corev1.EventTypeNormal, reasonTargetReachable, "%s %s (%s) is reachable by %s again", kind, name, r.Address, protocol)
+			}
+			continue
+		}
+
+		ProberTargetReachable.WithLabelValues(name, kind, protocol).Set(0)
+		ProberViolationsTotal.WithLabelValues(name, kind, protocol).Inc()
+		p.log.V(3).Info("target is unreachable", "target", r.Name, "address", r.Address, "protocol", protocol, "error", r.Err)
+
+		if !p.unreachable[key] {
+			p.unreachable[key] = true
+			p.recordEvent(corev1.EventTypeWarning, reasonTargetUnreachable, "%s %s (%s) is unreachable by %s: %s", kind, name, r.Address, protocol, r.Err)
+		}
+	}
+
+	for key := range p.unreachable {
+		if !tested[key] {
+			delete(p.unreachable, key)
+		}
+	}
+}
+
+// loadTargets reads targets from CheckTargetsFile, no targets are tested if it doesn't exist,
+// e.g. operator hasn't put targets of this node in configmap yet
+func (p *prober) loadTargets() ([]probe.Target, error) {
+	data, err := ioutil.ReadFile(p.CheckTargetsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			p.log.V(3).Info("targets file doesn't exist", "file", p.CheckTargetsFile)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return probe.ParseTargets(strings.Split(string(data), "\n"))
+}
+
+func (p *prober) recordEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	if p.recorder == nil {
+		return
+	}
+	p.recorder.Eventf(p.pod, eventType, reason, messageFmt, args...)
+}
+
+func targetKind(name string) string {
+	if strings.HasPrefix(name, servicePrefix) {
+		return targetKindService
+	}
+	return targetKindPod
+}
+
+func targetName(name string) string {
+	return strings.TrimPrefix(name, servicePrefix)
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+// syncRelayRegistration registers the node for relay by connector if tunnels to some edge peers
+// have been down for RelayTimeout while tunnels to connector are established, e.g. both ends are
+// behind NAT which NAT traversal of IKE can't get through. Once the node is registered, operator
+// marks its endpoint as relay-only and no tunnels between it and edge peers are configured,
+// traffic between them goes through connector instead. The registration is never withdrawn by
+// agent, remove the annotation to try direct tunnels again
+func (m *Manager) syncRelayRegistration() error {
+	conf, err := m.loadNetworkConf()
+	if err != nil {
+		return err
+	}
+
+	m.relayMux.Lock()
+	defer m.relayMux.Unlock()
+
+	if conf.RelayOnly {
+		m.peersDownSince = nil
+		return nil
+	}
+
+	// relay doesn't help if connector can't be reached either
+	if established, _ := m.areConnectorTunnelsEstablished(conf); !established {
+		m.peersDownSince = nil
+		return nil
+	}
+
+	now := time.Now()
+	downSince := make(map[string]time.Time)
+	var failedPeers []string
+	for _, peer := range conf.Peers {
+		if peer.Type != apis.EdgeNode {
+			continue
+		}
+
+		established, err := m.tm.IsConnEstablished(peer.Name)
+		if err != nil {
+			return err
+		}
+
+		if established {
+			continue
+		}
+
+		since, ok := m.peersDownSince[peer.Name]
+		if !ok {
+			since = now
+		}
+		downSince[peer.Name] = since
+
+		if now.Sub(since) >= m.RelayTimeout {
+			failedPeers = append(failedPeers, peer.Name)
+		}
+	}
+	m.peersDownSince = downSince
+
+	if len(failedPeers) == 0 {
+		return nil
+	}
+
+	m.log.Info("tunnels to edge peers can't be established, register for relay by connector", "peers", failedPeers)
+	return m.registerRelay()
+}
+
+// registerRelay annotates the node as relay-only
+func (m *Manager) registerRelay() error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				constants.KeyRelayOnly: "true",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.getKubeClient().CoreV1().Nodes().Patch(context.Background(), m.NodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+const (
+	ResourceModeNormal = "normal"
+	// ResourceModeLow is for constrained edge hardware, agent syncs less often, optional
+	// subsystems are disabled and memory is collected more aggressively
+	ResourceModeLow = "low"
+
+	lowResourceSyncPeriod   = 5 * time.Minute
+	lowResourceDebounce     = 5 * time.Second
+	lowResourceDNSCacheSize = 1000
+	// lowResourceGCPercent makes heap grow by half of live data before GC runs, instead of the whole
+	lowResourceGCPercent = 50
+)
+
+// applyResourceMode adjusts settings by resource mode. In low mode, sync period and debounce
+// are lengthened if they are shorter, conntrack clearing and graceful termination of real servers
+// are disabled and DNS cache is capped to fewer answers
+func (cfg *Config) applyResourceMode() {
+	cfg.DNSCacheSize = dnsCacheSize
+	if cfg.ResourceMode != ResourceModeLow {
+		return
+	}
+
+	if cfg.SyncPeriod < lowResourceSyncPeriod {
+		cfg.SyncPeriod = lowResourceSyncPeriod
+	}
+	if cfg.DebounceDuration < lowResourceDebounce {
+		cfg.DebounceDuration = lowResourceDebounce
+	}
+
+	cfg.ClearConntrack = false
+	cfg.IPVSGracefulTermination = 0
+	cfg.DNSCacheSize = lowResourceDNSCacheSize
+
+	debug.SetGCPercent(lowResourceGCPercent)
+}
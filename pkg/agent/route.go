@@ -60,6 +60,25 @@ func delStaleRoutes(conf netconf.NetworkConf) error {
 	return err
 }
 
+// delAllRoutes removes all routes in strongswan table
+func delAllRoutes() error {
+	var routeFilter = &netlink.Route{
+		Table: TableStrongswan,
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, routeFilter, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return err
+	}
+
+	for i := range routes {
+		if err = netlink.RouteDel(&routes[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func IsActive(dst *net.IPNet, conf netconf.NetworkConf) (bool, error) {
 	for _, peer := range conf.Peers {
 		for _, subnet := range peer.Subnets {
@@ -15,10 +15,8 @@
 package agent
 
 import (
-	"io/ioutil"
 	"net"
 
-	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
 
 	"github.com/fabedge/fabedge/pkg/common/netconf"
@@ -28,29 +26,41 @@ import (
 type server struct {
 	virtualServer *ipvs.VirtualServer
 	realServers   []*ipvs.RealServer
+	// nodePort is true if the virtual server is on an address of the node, such
+	// address must not be bound to the dummy interface
+	nodePort bool
 }
 
-func loadServiceConf(path string) (netconf.VirtualServers, error) {
-	var conf netconf.VirtualServers
-
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return conf, err
-	}
-
-	return conf, yaml.Unmarshal(data, &conf)
-}
-
-func toServers(vssConf netconf.VirtualServers) []server {
+// toServers makes servers from services config, a virtual server is made for each node
+// address if a service has node port
+func toServers(vssConf netconf.VirtualServers, nodeIPs []string) []server {
 	servers := []server{}
 	for _, vsConf := range vssConf {
-		server := server{
+		var realServers []*ipvs.RealServer
+		for _, rsConf := range vsConf.RealServers {
+			realServers = append(realServers, toRealServer(rsConf))
+		}
+
+		servers = append(servers, server{
 			virtualServer: toVirtualServer(vsConf),
+			realServers:   realServers,
+		})
+
+		if vsConf.NodePort == 0 {
+			continue
 		}
-		for _, rsConf := range vsConf.RealServers {
-			server.realServers = append(server.realServers, toRealServer(rsConf))
+
+		for _, ip := range nodeIPs {
+			vs := toVirtualServer(vsConf)
+			vs.Address = net.ParseIP(ip)
+			vs.Port = uint16(vsConf.NodePort)
+
+			servers = append(servers, server{
+				virtualServer: vs,
+				realServers:   realServers,
+				nodePort:      true,
+			})
 		}
-		servers = append(servers, server)
 	}
 	return servers
 }
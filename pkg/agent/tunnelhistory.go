@@ -0,0 +1,79 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabedge/fabedge/pkg/tunnel"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	// tunnelHistoryReportInterval is how often tunnel history is summarized and reported
+	tunnelHistoryReportInterval = time.Minute
+	// resubscribeInterval is how long to wait before watching tunnel events again after watching fails
+	resubscribeInterval = 10 * time.Second
+)
+
+// watchTunnelEvents records up, down and rekey events of tunnels in tunnel history,
+// events are watched again if watching fails, e.g. strongswan is restarted
+func (m *Manager) watchTunnelEvents() {
+	for {
+		err := m.tm.WatchEvents(context.Background(), func(event tunnel.Event) {
+			m.log.V(5).Info("tunnel event", logutil.KeyEndpoint, event.Name, "type", event.Type)
+			m.tunnelHistory.Record(event)
+		})
+		m.log.Error(err, "failed to watch tunnel events")
+
+		time.Sleep(resubscribeInterval)
+	}
+}
+
+// reportTunnelHistory summarizes tunnel history of peers in tunnels config every minute and
+// reports summaries in status of agent config. Nothing is reported while the edge node is offline
+func (m *Manager) reportTunnelHistory() {
+	tick := time.NewTicker(tunnelHistoryReportInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		if err := m.summarizeAndReport(); err != nil {
+			m.log.Error(err, "failed to report tunnel history")
+		}
+	}
+}
+
+func (m *Manager) summarizeAndReport() error {
+	conf, err := m.loadNetworkConf()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(conf.Peers))
+	for _, peer := range conf.Peers {
+		names = append(names, peer.Name)
+	}
+
+	// events of removed peers are useless
+	m.tunnelHistory.Retain(names)
+	summaries := m.tunnelHistory.Summarize(names, time.Now(), m.FlapThreshold)
+
+	if m.configSource == nil || m.isOffline() {
+		return nil
+	}
+
+	return m.configSource.reportTunnelHistory(summaries)
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AgentConfigConditionSynced is the condition type which tells if the agent
+// has applied the latest generation of its config
+const AgentConfigConditionSynced = "Synced"
+
+// AgentConfigConditionFlapping is the condition type which tells if any tunnel of the agent
+// went down more times than the flap threshold in the last hour, flapping peers are put in its message
+const AgentConfigConditionFlapping = "Flapping"
+
+// AgentConfigSpec is the config of an agent made by operator, its fields are the
+// same as files of agent configmap, so agents load them the same way
+type AgentConfigSpec struct {
+	// Tunnels is the tunnels config of the agent in yaml
+	Tunnels string `json:"tunnels,omitempty"`
+	// Services is the load balance rules of the agent in yaml, it's kept by proxy controller
+	Services string `json:"services,omitempty"`
+	// CABundle is CA certificates in PEM, it's only provided when agents bootstrap their certificates
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+type AgentConfigStatus struct {
+	// ObservedGeneration is the generation of spec which the agent applied last time
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is when the agent applied ObservedGeneration
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Conditions tell whether the latest generation is applied, errors are put in their messages
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Probes are results of pinging peers last time, they are reported if probing is enabled
+	Probes []PeerProbe `json:"probes,omitempty"`
+	// TunnelHistory summarizes tunnel events of peers in the last hour, it's reported if tunnel history is enabled
+	TunnelHistory []TunnelSummary `json:"tunnelHistory,omitempty"`
+}
+
+// AgentConfig is the config of the agent on an edge node made by operator, it has the same
+// name as the node. The agent reports whether it's applied in status
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="Whether agent applied the latest config"
+// +kubebuilder:printcolumn:name="Flapping",type="string",JSONPath=".status.conditions[?(@.type==\"Flapping\")].status",description="Whether any tunnel of agent is flapping"
+// +kubebuilder:printcolumn:name="Last-Sync",type="date",JSONPath=".status.lastSyncTime",description="When agent applied config last time"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long an agent config is created"
+type AgentConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentConfigSpec   `json:"spec,omitempty"`
+	Status AgentConfigStatus `json:"status,omitempty"`
+}
+
+// AgentConfigList contains a list of agent configs
+// +kubebuilder:object:root=true
+type AgentConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AgentConfig `json:"items"`
+}
@@ -22,6 +22,9 @@ type Endpoint struct {
 	NodeSubnets []string `yaml:"nodeSubnets,omitempty" json:"nodeSubnets,omitempty"`
 	// Type of endpoints: Connector or EdgeNode
 	Type EndpointType `yaml:"type,omitempty" json:"type,omitempty"`
+	// RelayOnly means the edge node can't establish tunnels with other edge nodes,
+	// traffic between them is relayed by connector
+	RelayOnly bool `yaml:"relayOnly,omitempty" json:"relayOnly,omitempty"`
 }
 
 type ClusterSpec struct {
@@ -31,15 +34,41 @@ type ClusterSpec struct {
 	EndPoints []Endpoint `json:"endPoints,omitempty"`
 }
 
+// ClusterConditionReady is the condition type which tells if a cluster is reporting heartbeat
+// and has endpoints exported
+const ClusterConditionReady = ConditionReady
+
+type ClusterStatus struct {
+	// LastSeen is the last time when the member cluster reported its heartbeat
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+	// LastExportTime is the last time when the cluster exported changes of its endpoints
+	LastExportTime *metav1.Time `json:"lastExportTime,omitempty"`
+	// EndpointCount is the number of endpoints exported by the cluster
+	EndpointCount int32 `json:"endpointCount,omitempty"`
+	// ConnectorPublicAddresses are public addresses of connectors of the cluster
+	ConnectorPublicAddresses []string `json:"connectorPublicAddresses,omitempty"`
+	// OperatorVersion is the version of operator running in the cluster
+	OperatorVersion string             `json:"operatorVersion,omitempty"`
+	Conditions      []metav1.Condition `json:"conditions,omitempty"`
+}
+
 // Cluster is used to represent a cluster's endpoints of connector and edge nodes
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Whether the cluster is reporting heartbeat and has endpoints"
+// +kubebuilder:printcolumn:name="Endpoints",type="integer",JSONPath=".status.endpointCount",description="The number of endpoints exported by the cluster"
+// +kubebuilder:printcolumn:name="Connectors",type="string",JSONPath=".status.connectorPublicAddresses",description="Public addresses of connectors",priority=1
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.operatorVersion",description="The version of operator running in the cluster"
+// +kubebuilder:printcolumn:name="Last-Export",type="date",JSONPath=".status.lastExportTime",description="When the cluster exported changes of endpoints last time",priority=1
+// +kubebuilder:printcolumn:name="Last-Seen",type="date",JSONPath=".status.lastSeen",description="When the cluster reported its heartbeat last time"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a community is created"
 type Cluster struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec ClusterSpec `json:"spec,omitempty"`
+	Spec   ClusterSpec   `json:"spec,omitempty"`
+	Status ClusterStatus `json:"status,omitempty"`
 }
 
 // ClusterList contains a list of clusters
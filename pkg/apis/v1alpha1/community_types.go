@@ -15,24 +15,84 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type CommunitySpec struct {
 	Members []string `json:"members,omitempty"`
+	// DSCP is set on traffic which connector sends to members through tunnels, so WAN QoS
+	// policies can prioritize it. 0 means traffic is not marked
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	DSCP int32 `json:"dscp,omitempty"`
+	// EgressBandwidth caps traffic which agents of members send to other members through
+	// tunnels, e.g. 10M is 10 megabits per second. Empty means no cap
+	EgressBandwidth *resource.Quantity `json:"egressBandwidth,omitempty"`
+}
+
+// PeerProbe is the result of pinging a peer through its tunnel, it's reported by agents and connectors
+type PeerProbe struct {
+	// Peer is the endpoint name of the peer
+	Peer string `json:"peer"`
+	// Address is the node address of the peer which is pinged
+	Address string `json:"address,omitempty"`
+	// RTT is the average round-trip time of replied pings, it's empty if nothing is replied
+	RTT *metav1.Duration `json:"rtt,omitempty"`
+	// Loss is the percentage of pings which are not replied
+	Loss      int32       `json:"loss"`
+	ProbeTime metav1.Time `json:"probeTime"`
+}
+
+// TunnelSummary summarizes up, down and rekey events of the tunnel to a peer in the last hour,
+// it's reported by agents and connectors
+type TunnelSummary struct {
+	// Peer is the endpoint name of the peer
+	Peer string `json:"peer"`
+	// Up tells whether the tunnel went up in its last up or down event
+	Up bool `json:"up"`
+	// LastTransitionTime is when the tunnel went up or down last time
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Flaps is the number of times the tunnel went down in the last hour
+	Flaps int32 `json:"flaps"`
+	// Rekeys is the number of times SAs of the tunnel were rekeyed in the last hour
+	Rekeys int32 `json:"rekeys"`
+	// Flapping is true if Flaps exceeds the flap threshold of the reporter
+	Flapping bool `json:"flapping,omitempty"`
+}
+
+// Connectivity is the quality of the tunnel from an endpoint to another one measured by the former
+type Connectivity struct {
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	RTT       *metav1.Duration `json:"rtt,omitempty"`
+	Loss      int32            `json:"loss"`
+	ProbeTime metav1.Time      `json:"probeTime"`
+}
+
+type CommunityStatus struct {
+	// Connectivity is the matrix of probes reported by members and connectors to members,
+	// it's aggregated by operator from status of AgentConfigs and ConnectorConfigs
+	Connectivity []Connectivity `json:"connectivity,omitempty"`
+	// Conditions tell whether endpoints of members are synced, tunnels between them are
+	// established and the community is ready as a whole
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // Community is used to manage a communication unit, it's members
 // should be edge nodes
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Whether endpoints of members are synced and tunnels between them are established"
 // +kubebuilder:printcolumn:name="Members",type="string",JSONPath=".spec.members",description="community members"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a community is created"
 type Community struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec CommunitySpec `json:"spec,omitempty"`
+	Spec   CommunitySpec   `json:"spec,omitempty"`
+	Status CommunityStatus `json:"status,omitempty"`
 }
 
 // CommunityList contains a list of Community
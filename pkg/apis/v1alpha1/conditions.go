@@ -0,0 +1,28 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+// Standard condition types shared by fabedge resources, external automation
+// can gate on them, e.g. kubectl wait --for=condition=Ready community/beijing
+const (
+	// ConditionReady tells if a resource is healthy as a whole
+	ConditionReady = "Ready"
+	// ConditionEndpointsSynced tells if endpoints of a resource are saved in the store of operator
+	ConditionEndpointsSynced = "EndpointsSynced"
+	// ConditionCertificateValid tells if certificates made by operator are within their validity period
+	ConditionCertificateValid = "CertificateValid"
+	// ConditionTunnelsEstablished tells if tunnels between endpoints of a resource are up
+	ConditionTunnelsEstablished = "TunnelsEstablished"
+)
@@ -0,0 +1,99 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=TCP;UDP;ICMP
+type CheckProtocol string
+
+const (
+	CheckProtocolTCP  CheckProtocol = "TCP"
+	CheckProtocolUDP  CheckProtocol = "UDP"
+	CheckProtocolICMP CheckProtocol = "ICMP"
+)
+
+type ConnectivityCheckPhase string
+
+const (
+	// ConnectivityCheckRunning means probe pods are started or tests are running
+	ConnectivityCheckRunning ConnectivityCheckPhase = "Running"
+	// ConnectivityCheckCompleted means results are reported and probe pods are removed
+	ConnectivityCheckCompleted ConnectivityCheckPhase = "Completed"
+)
+
+type ConnectivityCheckSpec struct {
+	// Nodes are where probe pods run, each node tests all the others, so both directions
+	// of every pair are checked. The number of nodes is limited since results of a node
+	// are reported by the termination message of its probe pod
+	// +kubebuilder:validation:MinItems=2
+	// +kubebuilder:validation:MaxItems=20
+	Nodes []string `json:"nodes"`
+	// Protocols are what to test with, all of them are used if it's empty
+	Protocols []CheckProtocol `json:"protocols,omitempty"`
+	// Port is the TCP and UDP port which probe pods listen on, default is 8790
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+	// Timeout is how long to wait for the reply of a test, default is 3s
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// CheckResult is the result of testing a node from another one with a protocol
+type CheckResult struct {
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Protocol  CheckProtocol `json:"protocol"`
+	Succeeded bool          `json:"succeeded"`
+	// RTT is the round-trip time of the test, it's empty if the test fails
+	RTT *metav1.Duration `json:"rtt,omitempty"`
+	// Message tells why the test fails
+	Message string `json:"message,omitempty"`
+}
+
+type ConnectivityCheckStatus struct {
+	Phase          ConnectivityCheckPhase `json:"phase,omitempty"`
+	StartTime      *metav1.Time           `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time           `json:"completionTime,omitempty"`
+	// Message summarizes results when the check is completed
+	Message string        `json:"message,omitempty"`
+	Results []CheckResult `json:"results,omitempty"`
+}
+
+// ConnectivityCheck makes operator launch short-lived probe pods on nodes and test
+// connectivity between each pair of them, results are reported in status
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="phase of check"
+// +kubebuilder:printcolumn:name="Message",type="string",JSONPath=".status.message",description="summary of results"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a check is created"
+type ConnectivityCheck struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ConnectivityCheckSpec   `json:"spec,omitempty"`
+	Status ConnectivityCheckStatus `json:"status,omitempty"`
+}
+
+// ConnectivityCheckList contains a list of connectivity checks
+// +kubebuilder:object:root=true
+type ConnectivityCheckList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ConnectivityCheck `json:"items"`
+}
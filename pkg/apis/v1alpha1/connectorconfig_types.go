@@ -0,0 +1,146 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConnectorConfigConditionSynced is the condition type which tells if the connector
+// has applied the latest generation of its config
+const ConnectorConfigConditionSynced = "Synced"
+
+// ConnectorConfigConditionFlapping is the condition type which tells if any tunnel of the connector
+// went down more times than the flap threshold in the last hour, flapping peers are put in its message
+const ConnectorConfigConditionFlapping = "Flapping"
+
+type ConnectorConfigSpec struct {
+	// Endpoint is the endpoint of the connector
+	Endpoint Endpoint `json:"endpoint"`
+	// Peers are endpoints which the connector establishes tunnels with
+	Peers []Endpoint `json:"peers,omitempty"`
+	// DSCPRules are made from communities with DSCP
+	DSCPRules []DSCPRule `json:"dscpRules,omitempty"`
+}
+
+// DSCPRule makes connector set DSCP on traffic to CIDRs through tunnels
+type DSCPRule struct {
+	// Name tells where the rule comes from, e.g. the name of a community
+	Name  string   `json:"name,omitempty"`
+	DSCP  int32    `json:"dscp"`
+	CIDRs []string `json:"cidrs,omitempty"`
+}
+
+type ConnectorConfigStatus struct {
+	// ObservedGeneration is the generation of spec which the connector applied last time
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the last time when the connector applied its config
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Tunnels is the number of tunnels loaded by the connector
+	Tunnels    int32              `json:"tunnels,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Probes are results of pinging peers last time, they are reported if probing is enabled
+	Probes []PeerProbe `json:"probes,omitempty"`
+	// TunnelHistory summarizes tunnel events of peers in the last hour, it's reported if tunnel history is enabled
+	TunnelHistory []TunnelSummary `json:"tunnelHistory,omitempty"`
+}
+
+// ConnectorConfig is the tunnels config of a connector made by operator, connector reports
+// whether it's applied in status
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Peers",type="integer",JSONPath=".status.tunnels",description="The number of tunnels loaded by connector"
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="Whether connector applied the latest config"
+// +kubebuilder:printcolumn:name="Flapping",type="string",JSONPath=".status.conditions[?(@.type==\"Flapping\")].status",description="Whether any tunnel of connector is flapping"
+// +kubebuilder:printcolumn:name="Last-Sync",type="date",JSONPath=".status.lastSyncTime",description="When connector applied config last time"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a connector config is created"
+type ConnectorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ConnectorConfigSpec   `json:"spec,omitempty"`
+	Status ConnectorConfigStatus `json:"status,omitempty"`
+}
+
+// ConnectorConfigList contains a list of connector configs
+// +kubebuilder:object:root=true
+type ConnectorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ConnectorConfig `json:"items"`
+}
+
+// Validate checks if spec can be applied by connector: every endpoint has a name, an ID and
+// valid subnets, names of peers are unique and DSCP rules are valid
+func (spec ConnectorConfigSpec) Validate() error {
+	if err := validateEndpoint(spec.Endpoint); err != nil {
+		return fmt.Errorf("endpoint: %w", err)
+	}
+
+	names := make(map[string]bool, len(spec.Peers))
+	for _, peer := range spec.Peers {
+		if err := validateEndpoint(peer); err != nil {
+			return fmt.Errorf("peer %s: %w", peer.Name, err)
+		}
+
+		if names[peer.Name] {
+			return fmt.Errorf("peer %s is duplicated", peer.Name)
+		}
+		names[peer.Name] = true
+	}
+
+	for _, rule := range spec.DSCPRules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("dscp rule %s: %w", rule.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (rule DSCPRule) Validate() error {
+	if rule.DSCP < 0 || rule.DSCP > 63 {
+		return fmt.Errorf("dscp should be in [0, 63]")
+	}
+
+	for _, cidr := range rule.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR: %s", cidr)
+		}
+	}
+
+	return nil
+}
+
+func validateEndpoint(ep Endpoint) error {
+	if ep.Name == "" || ep.ID == "" {
+		return fmt.Errorf("name and id are required")
+	}
+
+	for _, subnet := range append(append([]string{}, ep.Subnets...), ep.NodeSubnets...) {
+		if net.ParseIP(subnet) != nil {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("invalid subnet: %s", subnet)
+		}
+	}
+
+	return nil
+}
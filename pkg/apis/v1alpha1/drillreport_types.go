@@ -0,0 +1,60 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type DrillType string
+
+const (
+	// DrillConnectorSwitchover deletes the running connector pods and waits for a connector to be ready again
+	DrillConnectorSwitchover DrillType = "ConnectorSwitchover"
+)
+
+type DrillReportSpec struct {
+	Type DrillType `json:"type"`
+	// Targets are the names of pods withdrawn in the drill
+	Targets    []string     `json:"targets,omitempty"`
+	StartTime  metav1.Time  `json:"startTime"`
+	FinishTime *metav1.Time `json:"finishTime,omitempty"`
+	// RecoveryDuration is the duration from targets are withdrawn to the service is recovered
+	RecoveryDuration *metav1.Duration `json:"recoveryDuration,omitempty"`
+	Succeeded        bool             `json:"succeeded"`
+	Message          string           `json:"message,omitempty"`
+}
+
+// DrillReport records the result of a failover drill
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Type",type="string",JSONPath=".spec.type",description="drill type"
+// +kubebuilder:printcolumn:name="Succeeded",type="boolean",JSONPath=".spec.succeeded",description="whether service is recovered"
+// +kubebuilder:printcolumn:name="Recovery",type="string",JSONPath=".spec.recoveryDuration",description="recovery duration"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a report is created"
+type DrillReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DrillReportSpec `json:"spec,omitempty"`
+}
+
+// DrillReportList contains a list of drill reports
+// +kubebuilder:object:root=true
+type DrillReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DrillReport `json:"items"`
+}
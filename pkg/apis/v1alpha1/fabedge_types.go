@@ -0,0 +1,94 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// FabEdgeConditionApplied tells whether the spec is valid and applied
+	FabEdgeConditionApplied = "Applied"
+	// FabEdgeConditionRestartRequired tells whether some changes only take effect after operator restarts
+	FabEdgeConditionRestartRequired = "RestartRequired"
+	// FabEdgeConditionCertificatesExpiring tells whether some certificates made by operator are within renewal window
+	FabEdgeConditionCertificatesExpiring = "CertificatesExpiring"
+)
+
+type FabEdgeConnectorSpec struct {
+	// PublicAddresses should be accessible for every edge node, takes IPs and DNS names
+	PublicAddresses []string `json:"publicAddresses,omitempty"`
+	// Subnets are mostly the CIDRs to assign pod IP and service ClusterIP
+	Subnets []string `json:"subnets,omitempty"`
+	// Labels are used to find connector pods
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type FabEdgeAgentSpec struct {
+	Image             string `json:"image,omitempty"`
+	StrongswanImage   string `json:"strongswanImage,omitempty"`
+	ImagePullPolicy   string `json:"imagePullPolicy,omitempty"`
+	LogLevel          *int   `json:"logLevel,omitempty"`
+	UseXfrm           *bool  `json:"useXfrm,omitempty"`
+	MasqOutgoing      *bool  `json:"masqOutgoing,omitempty"`
+	EnableProxy       *bool  `json:"enableProxy,omitempty"`
+	DetectKubeProxy   *bool  `json:"detectKubeProxy,omitempty"`
+	EnableHairpinMode *bool  `json:"enableHairpinMode,omitempty"`
+	NetworkPluginMTU  *int   `json:"networkPluginMTU,omitempty"`
+	NodeCondition     string `json:"nodeCondition,omitempty"`
+	SubnetsPerChildSA *int   `json:"subnetsPerChildSA,omitempty"`
+}
+
+// FabEdgeSpec holds configurations of operator, a field which is not set
+// takes the value of corresponding argument of operator
+type FabEdgeSpec struct {
+	EdgeLabels       map[string]string `json:"edgeLabels,omitempty"`
+	EdgePodCIDR      string            `json:"edgePodCIDR,omitempty"`
+	CNIType          string            `json:"cniType,omitempty"`
+	EndpointIDFormat string            `json:"endpointIDFormat,omitempty"`
+	// CertValidPeriod is the validity period of certificates in days
+	CertValidPeriod  *int64 `json:"certValidPeriod,omitempty"`
+	CertOrganization string `json:"certOrganization,omitempty"`
+
+	Connector FabEdgeConnectorSpec `json:"connector,omitempty"`
+	Agent     FabEdgeAgentSpec     `json:"agent,omitempty"`
+}
+
+type FabEdgeStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// FabEdge holds configurations of operator, only the one whose name is specified
+// by operator is used
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="How long a FabEdge is created"
+type FabEdge struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FabEdgeSpec   `json:"spec,omitempty"`
+	Status FabEdgeStatus `json:"status,omitempty"`
+}
+
+// FabEdgeList contains a list of FabEdge
+// +kubebuilder:object:root=true
+type FabEdgeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FabEdge `json:"items"`
+}
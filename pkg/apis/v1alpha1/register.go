@@ -35,9 +35,19 @@ func init() {
 	// generated functions takes place in the generated files. The separation
 	// makes the code compile even when the generated files are missing.
 	SchemeBuilder.Register(
+		&AgentConfig{},
+		&AgentConfigList{},
 		&Community{},
 		&CommunityList{},
 		&Cluster{},
 		&ClusterList{},
+		&ConnectivityCheck{},
+		&ConnectivityCheckList{},
+		&ConnectorConfig{},
+		&ConnectorConfigList{},
+		&DrillReport{},
+		&DrillReportList{},
+		&FabEdge{},
+		&FabEdgeList{},
 	)
 }
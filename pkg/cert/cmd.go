@@ -22,6 +22,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
@@ -41,6 +42,7 @@ func NewCertCommand() *cobra.Command {
 	var saveOptions = &SaveOptions{}
 	var certOptions = &CertOptions{}
 	var verifyOptions = &VerifyOptions{}
+	var keySignerCommand string
 
 	caCmd := &cobra.Command{
 		Use:   "ca [CommonName]",
@@ -57,6 +59,9 @@ fabedge-cert gen ca --ca-secret=ca --namespace=default
 
 # Create a self-signed CA and save to files only
 fabedge-cert gen ca --save-to-file --save-to-secret=false
+
+# Create a self-signed CA whose key is kept in KMS or HSM, only CA cert is saved
+fabedge-cert gen ca --key-signer-command="/usr/local/bin/kms-signer --key=fabedge-ca"
 `,
 		Args:    cobra.MaximumNArgs(1),
 		PreRunE: doValidations(certOptions.Validate),
@@ -66,8 +71,22 @@ fabedge-cert gen ca --save-to-file --save-to-secret=false
 				name = args[0]
 			}
 
-			cfg := certOptions.AsConfig(name, true, nil)
-			certDER, keyDER, err := certutil.NewSelfSignedCA(cfg)
+			var (
+				cfg     = certOptions.AsConfig(name, true, nil)
+				certDER []byte
+				keyDER  []byte
+				err     error
+			)
+			if keySignerCommand != "" {
+				caKey, err := certutil.NewExternalSigner(strings.Fields(keySignerCommand))
+				if err != nil {
+					exit("failed to create external signer: %s", err)
+				}
+
+				certDER, err = certutil.NewSelfSignedCAWithSigner(cfg, caKey)
+			} else {
+				certDER, keyDER, err = certutil.NewSelfSignedCA(cfg)
+			}
 			if err != nil {
 				exit("failed to generate CA cert/key: %s", err)
 			}
@@ -110,7 +129,11 @@ fabedge-cert gen edge --save-to-file --save-to-secret=false
 
 				usages := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
 				cfg := certOptions.AsConfig(commonName, false, usages)
-				certDER, keyDER, err = certutil.NewCertFromCA2(caDER, caKeyDER, cfg)
+				if keySignerCommand != "" {
+					certDER, keyDER, err = newCertByExternalSigner(caDER, keySignerCommand, cfg)
+				} else {
+					certDER, keyDER, err = certutil.NewCertFromCA2(caDER, caKeyDER, cfg)
+				}
 				if err != nil {
 					exit("failed to create cert/key from ca: %s", err)
 				}
@@ -242,6 +265,7 @@ fabedge-cert verify --secret edge --ca-secret=fabedge-ca --namespace=fabedge
 	saveOptions.AddFlags(genCmd.PersistentFlags())
 	certOptions.AddFlags(genCmd.PersistentFlags())
 	verifyOptions.AddFlags(verifyCmd.Flags())
+	genCmd.PersistentFlags().StringVar(&keySignerCommand, "key-signer-command", "", "The command and its arguments to sign by CA key kept in KMS or HSM. If it's provided, only CA cert is saved when creating CA and CA key is not needed when creating cert")
 	globalOptions.AddFlags(rootCmd.PersistentFlags())
 
 	genCmd.AddCommand(caCmd)
@@ -287,16 +311,21 @@ func createKubeClient() client.Client {
 	return cli
 }
 
+// saveCAToSecret saves CA cert and key to secret, key is not saved if it's nil, e.g. it's kept in KMS or HSM
 func saveCAToSecret(name, namespace string, caDER, keyDER []byte) {
+	data := map[string][]byte{
+		secretutil.KeyCACert: certutil.EncodeCertPEM(caDER),
+	}
+	if keyDER != nil {
+		data[secretutil.KeyCAKey] = certutil.EncodePrivateKeyPEM(keyDER)
+	}
+
 	createSecretIfNotExist(&corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
 		},
-		Data: map[string][]byte{
-			secretutil.KeyCACert: certutil.EncodeCertPEM(caDER),
-			secretutil.KeyCAKey:  certutil.EncodePrivateKeyPEM(keyDER),
-		},
+		Data: data,
 	})
 }
 
@@ -347,7 +376,19 @@ func createSecretIfNotExist(secret *corev1.Secret) {
 
 func getCA(globalOptions *GlobalOptions) (datader []byte, keyder []byte) {
 	if globalOptions.CAIsFromSecret() {
-		return getCertAndKeyFromSecret(globalOptions.SecretKey(), secretutil.KeyCACert, secretutil.KeyCAKey)
+		var secret corev1.Secret
+		err := createKubeClient().Get(context.TODO(), globalOptions.SecretKey(), &secret)
+		if err != nil {
+			exit("failed to get secret: %s", err)
+		}
+
+		// the new CA signs certificates once CA rotation reaches Reissuing phase
+		certPEM, keyPEM := secretutil.GetSigningCA(secret)
+		if len(keyPEM) == 0 {
+			// CA key is kept in KMS or HSM
+			return decodePEM(certPEM), nil
+		}
+		return decodePEM(certPEM), decodePEM(keyPEM)
 	}
 
 	datader, err := certutil.ReadPEMFileAndDecode(globalOptions.CACert)
@@ -373,6 +414,20 @@ func getCertAndKeyFromSecret(key client.ObjectKey, certName, keyName string) (ce
 	return decodePEM(secret.Data[certName]), decodePEM(secret.Data[keyName])
 }
 
+func newCertByExternalSigner(caDER []byte, keySignerCommand string, cfg certutil.Config) ([]byte, []byte, error) {
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caKey, err := certutil.NewExternalSigner(strings.Fields(keySignerCommand))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certutil.NewCertFromCA(caCert, caKey, cfg)
+}
+
 func decodePEM(data []byte) []byte {
 	block, _ := pem.Decode(data)
 	if block == nil {
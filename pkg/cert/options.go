@@ -45,7 +45,7 @@ func (opts *GlobalOptions) AddFlags(fs *flag.FlagSet) {
 	fs.StringVar(&opts.CAKey, "ca-key", "", "The CA cert key filename, provide it if you prefer file")
 
 	fs.BoolVar(&opts.Remote, "remote", false, "Generate or verify certificates remotely")
-	fs.StringVar(&opts.APIServerAddress, "api-server-address", "", "The address of host cluster's API server")
+	fs.StringVar(&opts.APIServerAddress, "api-server-address", "", "The address of host cluster's API server, multiple addresses can be provided separated by comma")
 	fs.StringVar(&opts.Token, "token", "", "Authentication token, not necessary when verifying certificate")
 }
 
@@ -78,6 +78,7 @@ type CertOptions struct {
 	ValidityPeriod int64
 	IPs            []string
 	DNSNames       []string
+	KeyType        string
 }
 
 func (opts *CertOptions) AddFlags(fs *flag.FlagSet) {
@@ -85,6 +86,7 @@ func (opts *CertOptions) AddFlags(fs *flag.FlagSet) {
 	fs.Int64Var(&opts.ValidityPeriod, "validity-period", 365, "validity period for your cert, unit: day")
 	fs.StringSliceVar(&opts.IPs, "ips", nil, "The ip addresses for your cert, e.g. 2.2.2.2,10.10.10.10")
 	fs.StringSliceVar(&opts.DNSNames, "dns-names", nil, "The dns names for your cert, e.g. fabedge.io,yourdomain.com")
+	fs.StringVar(&opts.KeyType, "key-type", string(certutil.KeyTypeRSA), "The algorithm of private key: rsa, ecdsa or ed25519")
 }
 
 func (opts *CertOptions) AsConfig(cn string, isCA bool, usages []x509.ExtKeyUsage) certutil.Config {
@@ -96,6 +98,7 @@ func (opts *CertOptions) AsConfig(cn string, isCA bool, usages []x509.ExtKeyUsag
 		DNSNames:       opts.DNSNames,
 		ValidityPeriod: timeutil.Days(opts.ValidityPeriod),
 		Usages:         usages,
+		KeyType:        certutil.KeyType(opts.KeyType),
 	}
 }
 
@@ -105,6 +108,7 @@ func (opts *CertOptions) AsRequest(cn string) certutil.Request {
 		Organization: opts.Organization,
 		IPs:          opts.GetIPs(),
 		DNSNames:     opts.DNSNames,
+		KeyType:      certutil.KeyType(opts.KeyType),
 	}
 }
 
@@ -115,7 +119,8 @@ func (opts *CertOptions) Validate() error {
 		}
 	}
 
-	return nil
+	_, err := certutil.ParseKeyType(opts.KeyType)
+	return err
 }
 
 func (opts *CertOptions) GetIPs() (ips []net.IP) {
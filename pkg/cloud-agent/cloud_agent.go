@@ -11,20 +11,34 @@ import (
 	routeUtil "github.com/fabedge/fabedge/pkg/util/route"
 	flag "github.com/spf13/pflag"
 	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	"net"
 	"time"
 )
 
+const (
+	discoveryMemberlist = "memberlist"
+	discoveryConfigMap  = "configmap"
+)
+
 var (
-	initMembers []string
-	debounced   = debounce.New(time.Second * 10)
-	addedRoutes = map[string][]netlink.Route{}
+	memberlistConfig memberlist.Config
+	debounced        = debounce.New(time.Second * 10)
+	addedRoutes      = map[string][]netlink.Route{}
+
+	discovery         string
+	namespace         string
+	prefixesConfigMap string
 )
 
 func init() {
 	logutil.AddFlags(flag.CommandLine)
-	flag.StringSliceVar(&initMembers, "connector-node-addresses", []string{}, "internal ip address of all connector nodes")
+	flag.StringSliceVar(&memberlistConfig.InitMembers, "connector-node-addresses", []string{}, "internal ip address of all connector nodes")
+	flag.StringVar(&discovery, "discovery", discoveryMemberlist, "how prefixes are got from connectors, memberlist or configmap, it must be the same as connectors")
+	flag.StringVar(&namespace, "namespace", "fabedge", "The namespace of the configmap where connectors publish prefixes")
+	flag.StringVar(&prefixesConfigMap, "prefixes-configmap", "fabedge-connector-prefixes", "The name of configmap where connectors publish prefixes in configmap mode")
+	memberlistConfig.AddFlags(flag.CommandLine)
 }
 
 func getRouteTmpl(prefix string) (netlink.Route, error) {
@@ -114,26 +128,42 @@ func addAndSaveRoutes(cp routing.ConnectorPrefixes) error {
 		routes = append(routes, rt)
 	}
 
+	// delete routes to prefixes which are removed
+	kept := sets.NewString()
+	for _, r := range routes {
+		kept.Insert(r.Dst.String())
+	}
+	for _, r := range addedRoutes[cp.NodeName] {
+		if kept.Has(r.Dst.String()) {
+			continue
+		}
+		if err := netlink.RouteDel(&r); err != nil && !routeUtil.NoSuchProcessError(err) {
+			klog.Errorf("failed to delete route:%+v with error:%s", r, err)
+		}
+	}
+
 	addedRoutes[cp.NodeName] = routes
 	klog.V(5).Infof("routes are synced:%+v", routes)
 
 	return nil
 }
 
+// msgHandler saves prefixes at once and acknowledges them, routes are synced later, so a burst of messages makes only one sync
 func msgHandler(b []byte) {
-	debounced(func() {
-		var cp routing.ConnectorPrefixes
-		if err := json.Unmarshal(b, &cp); err != nil {
-			klog.Errorf("failed to unmarshal message:%s", err)
-		}
-		klog.V(5).Infof("get connector message:%+v", cp)
+	var cp routing.ConnectorPrefixes
+	if err := json.Unmarshal(b, &cp); err != nil {
+		klog.Errorf("failed to unmarshal message:%s", err)
+		return
+	}
+	klog.V(5).Infof("get connector message:%+v", cp)
 
-		if err := addAndSaveRoutes(cp); err != nil {
-			klog.Errorf("failed to add route:%s", err)
-		}
-		klog.V(5).Infof("routes are added and saved")
+	seq := updatePrefixes(cp)
+	// messages of old connectors have no kind, they don't need acks
+	if cp.Kind != "" {
+		go sendAck(cp.NodeName, seq)
+	}
 
-	})
+	debounced(syncAllPrefixes)
 }
 
 func delAllSavedRoutesByNode(name string) {
@@ -149,11 +179,15 @@ func delAllSavedRoutesByNode(name string) {
 	}
 }
 
-func nodeLeaveHandler(name string) {
-	debounced(func() {
-		klog.V(5).Infof("node %s leave, to delete all routes via it", name)
-		delAllSavedRoutesByNode(name)
-	})
+func memberEventHandler(event memberlist.MemberEvent) {
+	klog.V(5).Infof("member %s(%s, role: %s) event: %s", event.Name, event.Addr, event.Meta.Role, event.Reason)
+	if event.Reason != memberlist.ReasonLeave {
+		return
+	}
+
+	klog.V(5).Infof("node %s leave, to delete all routes via it", event.Name)
+	removePrefixes(event.Name)
+	debounced(syncAllPrefixes)
 }
 
 func Execute() {
@@ -161,10 +195,22 @@ func Execute() {
 
 	about.DisplayVersion()
 
-	if len(initMembers) < 1 {
+	if discovery == discoveryConfigMap {
+		if err := watchPrefixesConfigMap(namespace, prefixesConfigMap); err != nil {
+			klog.Exit(err)
+		}
+		select {}
+	}
+
+	if len(memberlistConfig.InitMembers) < 1 {
 		klog.Exit("at least one connector node address is needed")
 	}
-	mc, err := memberlist.New(initMembers, msgHandler, nodeLeaveHandler)
+
+	memberlistConfig.Role = memberlist.RoleCloudAgent
+	memberlistConfig.MsgHandler = msgHandler
+	memberlistConfig.EventHandler = memberEventHandler
+	var err error
+	mc, err = memberlist.New(memberlistConfig)
 	if err != nil {
 		klog.Exit(err)
 	}
@@ -0,0 +1,86 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud_agent
+
+import (
+	"encoding/json"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// watchPrefixesConfigMap watches the configmap where each connector publishes its prefixes with
+// its node name as the key. It's used instead of memberlist when memberlist ports are blocked
+func watchPrefixesConfigMap(namespace, name string) error {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "configmaps", namespace,
+		fields.OneTermEqualSelector("metadata.name", name))
+
+	onChange := func(obj interface{}) {
+		var data map[string]string
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			data = cm.Data
+		}
+
+		// the whole configmap is handled every time, so only the latest change matters
+		replacePrefixes(parsePrefixes(data))
+		debounced(syncAllPrefixes)
+	}
+
+	_, controller := cache.NewInformer(lw, &corev1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(_, newObj interface{}) {
+			onChange(newObj)
+		},
+		DeleteFunc: func(interface{}) {
+			onChange(nil)
+		},
+	})
+	go controller.Run(make(chan struct{}))
+
+	klog.Infof("watch prefixes in configmap %s/%s", namespace, name)
+	return nil
+}
+
+// parsePrefixes parses prefixes of each connector in data, the key is the node name of connector
+func parsePrefixes(data map[string]string) map[string]routing.ConnectorPrefixes {
+	prefixes := make(map[string]routing.ConnectorPrefixes, len(data))
+	for name, value := range data {
+		var cp routing.ConnectorPrefixes
+		if err := json.Unmarshal([]byte(value), &cp); err != nil {
+			klog.Errorf("failed to unmarshal prefixes of node %s:%s", name, err)
+			continue
+		}
+		klog.V(5).Infof("get connector prefixes:%+v", cp)
+
+		prefixes[name] = cp
+	}
+
+	return prefixes
+}
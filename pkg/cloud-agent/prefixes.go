@@ -0,0 +1,131 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud_agent
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	"github.com/fabedge/fabedge/pkg/util/memberlist"
+	routeUtil "github.com/fabedge/fabedge/pkg/util/route"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// mc is used to acknowledge prefixes, it's nil in configmap mode
+	mc *memberlist.Client
+
+	prefixesMux sync.Mutex
+	// connectorPrefixes are full prefixes of connectors, the key is the node name of connector
+	connectorPrefixes = map[string]routing.ConnectorPrefixes{}
+)
+
+// updatePrefixes saves prefixes in msg and returns the seq of prefixes of the connector.
+// A delta is dropped if prefixes it's based on are missing, then the connector finds out
+// by the ack and sends full prefixes again
+func updatePrefixes(msg routing.ConnectorPrefixes) uint64 {
+	prefixesMux.Lock()
+	defer prefixesMux.Unlock()
+
+	if msg.Kind != routing.MessageDelta {
+		connectorPrefixes[msg.NodeName] = msg
+		return msg.Seq
+	}
+
+	cur, ok := connectorPrefixes[msg.NodeName]
+	if !ok || cur.Seq < msg.BaseSeq {
+		klog.V(3).Infof("drop delta of seq %d from %s, its base seq is %d, but seq %d is saved", msg.Seq, msg.NodeName, msg.BaseSeq, cur.Seq)
+		return cur.Seq
+	}
+	// the delta is already applied, it's a duplicate or it comes after full prefixes which are resent
+	if cur.Seq >= msg.Seq {
+		return cur.Seq
+	}
+
+	prefixes := sets.NewString(cur.RemotePrefixes...)
+	prefixes.Insert(msg.AddedPrefixes...)
+	prefixes.Delete(msg.RemovedPrefixes...)
+
+	cur.LocalPrefixes = msg.LocalPrefixes
+	cur.RemotePrefixes = prefixes.List()
+	cur.Seq = msg.Seq
+	connectorPrefixes[msg.NodeName] = cur
+
+	return cur.Seq
+}
+
+func removePrefixes(name string) {
+	prefixesMux.Lock()
+	defer prefixesMux.Unlock()
+
+	delete(connectorPrefixes, name)
+}
+
+func replacePrefixes(prefixes map[string]routing.ConnectorPrefixes) {
+	prefixesMux.Lock()
+	defer prefixesMux.Unlock()
+
+	connectorPrefixes = prefixes
+}
+
+// syncAllPrefixes adds routes via connectors whose prefixes are saved and deletes routes via others
+func syncAllPrefixes() {
+	prefixesMux.Lock()
+	all := make([]routing.ConnectorPrefixes, 0, len(connectorPrefixes))
+	names := sets.NewString()
+	for name, cp := range connectorPrefixes {
+		all = append(all, cp)
+		names.Insert(name)
+	}
+	prefixesMux.Unlock()
+
+	for name := range addedRoutes {
+		if !names.Has(name) {
+			klog.V(5).Infof("prefixes of node %s are gone, to delete all routes via it", name)
+			delAllSavedRoutesByNode(name)
+		}
+	}
+
+	for _, cp := range all {
+		if err := addAndSaveRoutes(cp); err != nil {
+			klog.Errorf("failed to add route:%s", err)
+		}
+	}
+	klog.V(5).Infof("routes are added and saved")
+}
+
+// sendAck tells the connector which seq of its prefixes this agent has
+func sendAck(connector string, seq uint64) {
+	// messages may come while joining memberlist, the connector resends prefixes if they are not acknowledged
+	if mc == nil {
+		return
+	}
+
+	b, err := json.Marshal(routing.PrefixesAck{
+		Kind:     routing.MessageAck,
+		NodeName: routeUtil.GetNodeName(),
+		Seq:      seq,
+	})
+	if err != nil {
+		klog.Errorf("failed to marshal ack:%s", err)
+		return
+	}
+
+	if err = mc.SendReliable(connector, b); err != nil {
+		klog.Errorf("failed to send ack to %s:%s", connector, err)
+	}
+}
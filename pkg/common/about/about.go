@@ -39,6 +39,11 @@ func DisplayAndExitIfRequested() {
 	}
 }
 
+// Version returns the semantic version of current program
+func Version() string {
+	return version
+}
+
 func DisplayVersion() {
 	fmt.Printf("Version: %s\nBuildTime: %s\nGitCommit: %s\n", version, buildTime, gitCommit)
 }
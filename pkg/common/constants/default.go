@@ -21,12 +21,62 @@ const (
 	KeyNode                = "fabedge.io/node"
 	KeyNodePublicAddresses = "fabedge.io/node-public-addresses"
 	KeyPodHash             = "fabedge.io/pod-spec-hash"
+	KeyConnector           = "fabedge.io/connector"
+	KeyKubeProxy           = "fabedge.io/kube-proxy"
+	KeyProxyStatus         = "fabedge.io/proxy-status"
+	KeyAutoCommunity       = "fabedge.io/auto-community"
+	KeyCommunity           = "fabedge.io/community"
+	KeyCluster             = "fabedge.io/cluster"
+	KeySuspended           = "fabedge.io/suspended"
+	KeyAgentResourceMode   = "fabedge.io/agent-resource-mode"
+	KeyMasqueradeCIDRs     = "fabedge.io/masquerade-cidrs"
+	KeyNonMasqueradeCIDRs  = "fabedge.io/non-masquerade-cidrs"
+	KeyRelayOnly           = "fabedge.io/relay-only"
 	AppAgent               = "fabedge-agent"
+	AppAgentCleanup        = "fabedge-agent-cleanup"
 	AppOperator            = "fabedge-operator"
 
+	// AppConnectivityCheck is the app of probe pods of connectivity checks, KeyConnectivityCheck is
+	// the label of the UID of their check and KeyCheckRole tells if they are servers or clients
+	AppConnectivityCheck = "fabedge-connectivity-check"
+	KeyConnectivityCheck = "fabedge.io/connectivity-check"
+	KeyCheckRole         = "fabedge.io/check-role"
+
+	// AppProber is the app of pods, service and configmap of the prober DaemonSet
+	AppProber = "fabedge-prober"
+
+	// KeyPublicAddressesFromPool marks public addresses of a node which are copied from its NodePool
+	KeyPublicAddressesFromPool = "fabedge.io/public-addresses-from-pool"
+	// KeyOpenYurtNodePool is the label of OpenYurt which tells the NodePool of a node
+	KeyOpenYurtNodePool = "apps.openyurt.io/nodepool"
+	// KeyOpenYurtPoolName is the label of OpenYurt which tells the NodePool of a pod of YurtAppSet
+	KeyOpenYurtPoolName = "apps.openyurt.io/pool-name"
+	// KeyLocalIngressPorts is the annotation of a service which exposes it on the LAN interface of
+	// edge nodes, e.g. 8080:80 maps port 8080 of edge nodes to service port 80
+	KeyLocalIngressPorts = "fabedge.io/local-ingress-ports"
+	// KeyLocalIngressInterface is the annotation of an edge node which overrides its LAN interface
+	// where local ingress ports are exposed
+	KeyLocalIngressInterface = "fabedge.io/local-ingress-interface"
+	// KeyDecommission is the annotation of an edge node which makes operator remove agent and
+	// network settings made by agent from the node, operator changes it to DecommissionDone after that
+	KeyDecommission  = "fabedge.io/decommission"
+	DecommissionDone = "done"
+	// KeyCertSerialNumbers is the annotation of a member cluster which records serial numbers of
+	// its client certificates, they are revoked when the cluster is deregistered
+	KeyCertSerialNumbers = "fabedge.io/cert-serial-numbers"
+
 	ConnectorConfigFileName = "tunnels.yaml"
 	ConnectorConfigName     = "connector-config"
 	ConnectorTLSName        = "connector-tls"
+
+	// CloudAgentTLSName is the TLS secret of cloud agents for memberlist TLS
+	CloudAgentTLSName = "cloud-agent-tls"
+	// MemberlistPeersName is the configmap of identities allowed to join memberlist
+	MemberlistPeersName     = "memberlist-peers"
+	MemberlistPeersFileName = "peers"
+
+	// FieldManager is the field manager used when operator applies objects
+	FieldManager = "fabedge"
 )
 
 const (
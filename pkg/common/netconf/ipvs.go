@@ -29,6 +29,10 @@ type VirtualServer struct {
 	SessionAffinity     corev1.ServiceAffinity `yaml:"sessionAffinity,omitempty"`
 	StickyMaxAgeSeconds int32                  `yaml:"stickyMaxAgeSeconds,omitempty"`
 
+	// NodePort is served on addresses of the edge node if it's not zero
+	NodePort              int32                                   `yaml:"nodePort,omitempty"`
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `yaml:"externalTrafficPolicy,omitempty"`
+
 	RealServers RealServers `yaml:"realServers,omitempty"`
 }
 
@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 
 	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 )
@@ -25,6 +26,50 @@ import (
 type NetworkConf struct {
 	apis.Endpoint `yaml:"-,inline"`
 	Peers         []apis.Endpoint `yaml:"peers,omitempty" json:"peers,omitempty"`
+	// DSCPRules is only used by connector
+	DSCPRules []apis.DSCPRule `yaml:"dscpRules,omitempty" json:"dscpRules,omitempty"`
+	// HostPorts is only used by agent, they are host ports of pods on the edge node
+	HostPorts []HostPort `yaml:"hostPorts,omitempty" json:"hostPorts,omitempty"`
+	// MasqueradeCIDRs and NonMasqueradeCIDRs are only used by agent when it masquerades outgoing traffic
+	// of pods, traffic to NonMasqueradeCIDRs is never masqueraded, if MasqueradeCIDRs is empty,
+	// traffic to anywhere outside the cluster is masqueraded, otherwise only traffic to MasqueradeCIDRs
+	MasqueradeCIDRs    []string `yaml:"masqueradeCIDRs,omitempty" json:"masqueradeCIDRs,omitempty"`
+	NonMasqueradeCIDRs []string `yaml:"nonMasqueradeCIDRs,omitempty" json:"nonMasqueradeCIDRs,omitempty"`
+	// BandwidthRules is only used by agent, they are made from communities with egress bandwidth
+	BandwidthRules []BandwidthRule `yaml:"bandwidthRules,omitempty" json:"bandwidthRules,omitempty"`
+	// LocalIngressInterface and LocalIngresses are only used by agent, requests from the LAN interface
+	// to local ingress ports are sent to their services, nothing is exposed if the interface is empty
+	LocalIngressInterface string         `yaml:"localIngressInterface,omitempty" json:"localIngressInterface,omitempty"`
+	LocalIngresses        []LocalIngress `yaml:"localIngresses,omitempty" json:"localIngresses,omitempty"`
+}
+
+// LocalIngress maps a port of the LAN interface of edge node to a service port
+type LocalIngress struct {
+	// Service is the namespaced name of the service, it's only for reading
+	Service     string          `yaml:"service,omitempty" json:"service,omitempty"`
+	Port        int32           `yaml:"port" json:"port"`
+	Protocol    corev1.Protocol `yaml:"protocol" json:"protocol"`
+	ServiceIP   string          `yaml:"serviceIP" json:"serviceIP"`
+	ServicePort int32           `yaml:"servicePort" json:"servicePort"`
+}
+
+// BandwidthRule caps traffic from the edge node to CIDRs through tunnels
+type BandwidthRule struct {
+	// Name tells where the rule comes from, e.g. the name of a community
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Rate is in bits per second
+	Rate  int64    `yaml:"rate" json:"rate"`
+	CIDRs []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty"`
+}
+
+// HostPort maps a port of the edge node to a port of pod
+type HostPort struct {
+	// HostIP is empty if the port is mapped on all addresses of the node
+	HostIP        string          `yaml:"hostIP,omitempty" json:"hostIP,omitempty"`
+	HostPort      int32           `yaml:"hostPort" json:"hostPort"`
+	Protocol      corev1.Protocol `yaml:"protocol" json:"protocol"`
+	PodIP         string          `yaml:"podIP" json:"podIP"`
+	ContainerPort int32           `yaml:"containerPort" json:"containerPort"`
 }
 
 func LoadNetworkConf(path string) (NetworkConf, error) {
@@ -0,0 +1,168 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+const (
+	directionTx = "tx"
+	directionRx = "rx"
+)
+
+// ensureAccountingIPTablesRules makes a rule for each subnet of each peer in each direction,
+// the rule only counts packets and returns. Rules are added and removed one by one instead
+// of rebuilding the chain, so counters of unchanged peers are kept. The chain is created
+// and emptied when connector starts
+func (m *Manager) ensureAccountingIPTablesRules() (err error) {
+	// traffic is counted before it's accepted by FABEDGE-FORWARD
+	exists, err := m.ipt.Exists(TableFilter, ChainForward, "-j", m.names.ChainAccounting)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err = m.ipt.Insert(TableFilter, ChainForward, 1, "-j", m.names.ChainAccounting); err != nil {
+			return err
+		}
+	}
+
+	expected := make(map[string][]string)
+	for _, c := range m.connections {
+		for _, subnet := range append(append([]string{}, c.RemoteSubnets...), c.RemoteNodeSubnets...) {
+			cidr := toIPv4CIDR(subnet)
+			if cidr == "" {
+				continue
+			}
+
+			// endpoint name is kept in comment, so counters can be told apart when they are collected
+			for _, rule := range [][]string{
+				{"-d", cidr, "-m", "comment", "--comment", c.Name, "-j", "RETURN"},
+				{"-s", cidr, "-m", "comment", "--comment", c.Name, "-j", "RETURN"},
+			} {
+				expected[strings.Join(rule, " ")] = rule
+			}
+		}
+	}
+
+	for key, rule := range m.accountingRules {
+		if _, ok := expected[key]; ok {
+			continue
+		}
+
+		if err = m.ipt.DeleteIfExists(TableFilter, m.names.ChainAccounting, rule...); err != nil {
+			return err
+		}
+		delete(m.accountingRules, key)
+	}
+
+	for key, rule := range expected {
+		if err = m.ipt.AppendUnique(TableFilter, m.names.ChainAccounting, rule...); err != nil {
+			return err
+		}
+		m.accountingRules[key] = rule
+	}
+
+	return nil
+}
+
+// toIPv4CIDR returns subnet as a CIDR, or empty if it's not IPv4, because rules are only made by iptables
+func toIPv4CIDR(subnet string) string {
+	if ip := net.ParseIP(subnet); ip != nil {
+		if ip.To4() == nil {
+			return ""
+		}
+		return subnet + "/32"
+	}
+
+	ip, _, err := net.ParseCIDR(subnet)
+	if err != nil || ip.To4() == nil {
+		return ""
+	}
+	return subnet
+}
+
+// trafficCollector reports counters of accounting rules when metrics are scraped, counters of
+// the same peer are summed. They start from zero again if connector restarts or rules are rebuilt
+type trafficCollector struct {
+	ipt         iptablesInterface
+	chain       string
+	bytesDesc   *prometheus.Desc
+	packetsDesc *prometheus.Desc
+}
+
+func newTrafficCollector(ipt iptablesInterface, chain string) *trafficCollector {
+	return &trafficCollector{
+		ipt:   ipt,
+		chain: chain,
+		bytesDesc: prometheus.NewDesc("fabedge_connector_peer_bytes_total",
+			"Bytes forwarded by connector to or from subnets of a peer, partitioned by peer and direction: tx is to the peer and rx is from it",
+			[]string{"peer", "direction"}, nil),
+		packetsDesc: prometheus.NewDesc("fabedge_connector_peer_packets_total",
+			"Packets forwarded by connector to or from subnets of a peer, partitioned by peer and direction: tx is to the peer and rx is from it",
+			[]string{"peer", "direction"}, nil),
+	}
+}
+
+func (c *trafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytesDesc
+	ch <- c.packetsDesc
+}
+
+func (c *trafficCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.ipt.StructuredStats(TableFilter, c.chain)
+	if err != nil {
+		klog.Errorf("failed to get stats of %s: %s", c.chain, err)
+		return
+	}
+
+	type key struct{ peer, direction string }
+	bytes, packets := make(map[key]uint64), make(map[key]uint64)
+	for _, stat := range stats {
+		peer := parseComment(stat.Options)
+		if peer == "" {
+			continue
+		}
+
+		direction := directionRx
+		if ones, _ := stat.Source.Mask.Size(); ones == 0 {
+			direction = directionTx
+		}
+
+		k := key{peer: peer, direction: direction}
+		bytes[k] += stat.Bytes
+		packets[k] += stat.Packets
+	}
+
+	for k, value := range bytes {
+		ch <- prometheus.MustNewConstMetric(c.bytesDesc, prometheus.CounterValue, float64(value), k.peer, k.direction)
+		ch <- prometheus.MustNewConstMetric(c.packetsDesc, prometheus.CounterValue, float64(packets[k]), k.peer, k.direction)
+	}
+}
+
+// parseComment gets comment from options of a rule listed by iptables, e.g. "/* edge1 */"
+func parseComment(options string) string {
+	start := strings.Index(options, "/* ")
+	end := strings.Index(options, " */")
+	if start < 0 || end < start+3 {
+		return ""
+	}
+
+	return options[start+3 : end]
+}
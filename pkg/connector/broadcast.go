@@ -0,0 +1,226 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	hashimemberlist "github.com/hashicorp/memberlist"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	"github.com/fabedge/fabedge/pkg/util/memberlist"
+)
+
+// prefixesBroadcaster broadcasts prefixes by memberlist with a seq, only changes are broadcast if
+// local prefixes are not changed. Cloud agents acknowledge the seq they have, full prefixes are
+// sent to agents which don't catch up in resendInterval, e.g. they missed a message or just joined
+type prefixesBroadcaster struct {
+	mc             prefixesSender
+	resendInterval time.Duration
+
+	mux sync.Mutex
+	seq uint64
+	// last is the full prefixes of seq, it's nil if nothing is broadcast
+	last        *routing.ConnectorPrefixes
+	broadcastAt time.Time
+	// acks are the latest seq acknowledged by cloud agents, resent is when full prefixes are sent to them
+	acks   map[string]uint64
+	resent map[string]time.Time
+}
+
+// prefixesSender sends prefixes to members, it's implemented by memberlist client
+type prefixesSender interface {
+	Broadcast(b []byte)
+	SendReliable(name string, b []byte) error
+	ListMembers() []*hashimemberlist.Node
+}
+
+func newPrefixesBroadcaster(resendInterval time.Duration) *prefixesBroadcaster {
+	return &prefixesBroadcaster{
+		resendInterval: resendInterval,
+		acks:           make(map[string]uint64),
+		resent:         make(map[string]time.Time),
+	}
+}
+
+// broadcast broadcasts cp if it's changed since last time, a delta is broadcast if only remote prefixes are changed
+func (b *prefixesBroadcaster) broadcast(cp *routing.ConnectorPrefixes) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	msg := routing.ConnectorPrefixes{
+		NodeName:       cp.NodeName,
+		LocalPrefixes:  cp.LocalPrefixes,
+		RemotePrefixes: cp.RemotePrefixes,
+		Kind:           routing.MessageFull,
+	}
+
+	if b.last != nil && b.last.NodeName == cp.NodeName &&
+		sets.NewString(b.last.LocalPrefixes...).Equal(sets.NewString(cp.LocalPrefixes...)) {
+		oldPrefixes, newPrefixes := sets.NewString(b.last.RemotePrefixes...), sets.NewString(cp.RemotePrefixes...)
+		if oldPrefixes.Equal(newPrefixes) {
+			return
+		}
+
+		msg.Kind = routing.MessageDelta
+		msg.RemotePrefixes = nil
+		msg.BaseSeq = b.seq
+		msg.AddedPrefixes = newPrefixes.Difference(oldPrefixes).List()
+		msg.RemovedPrefixes = oldPrefixes.Difference(newPrefixes).List()
+	}
+
+	b.seq++
+	msg.Seq = b.seq
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		klog.Errorf("failed to marshal prefixes:%s", err)
+		return
+	}
+	b.mc.Broadcast(data)
+	klog.V(5).Infof("prefixes are broadcast, kind: %s, seq: %d", msg.Kind, msg.Seq)
+
+	b.last = &routing.ConnectorPrefixes{
+		NodeName:       cp.NodeName,
+		LocalPrefixes:  cp.LocalPrefixes,
+		RemotePrefixes: cp.RemotePrefixes,
+		Kind:           routing.MessageFull,
+		Seq:            b.seq,
+	}
+	b.broadcastAt = time.Now()
+	PrefixesSeq.Set(float64(b.seq))
+}
+
+// handleMessage handles acks from cloud agents, other messages are ignored
+func (b *prefixesBroadcaster) handleMessage(data []byte) {
+	var ack routing.PrefixesAck
+	if err := json.Unmarshal(data, &ack); err != nil || ack.Kind != routing.MessageAck {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	// a seq larger than the latest one comes from the last run of connector, the agent knows nothing about this run
+	if ack.Seq > b.seq {
+		ack.Seq = 0
+	}
+	// acks may arrive out of order, an older one is ignored unless the agent has nothing, e.g. it restarted
+	if acked, ok := b.acks[ack.NodeName]; ok && ack.Seq != 0 && ack.Seq < acked {
+		return
+	}
+	b.acks[ack.NodeName] = ack.Seq
+	// the agent missed something, resend full prefixes to it next time without waiting
+	if ack.Seq < b.seq {
+		klog.V(3).Infof("cloud agent %s has prefixes of seq %d, the latest is %d", ack.NodeName, ack.Seq, b.seq)
+		delete(b.resent, ack.NodeName)
+	}
+}
+
+// resend sends full prefixes to cloud agents which don't catch up, they are sent without holding
+// the lock, otherwise acks handled by memberlist would be blocked
+func (b *prefixesBroadcaster) resend() {
+	data, targets := b.getResendTargets()
+
+	for _, name := range targets {
+		if err := b.mc.SendReliable(name, data); err != nil {
+			klog.Errorf("failed to resend prefixes to cloud agent %s: %s", name, err)
+			continue
+		}
+		PrefixesResendsTotal.Inc()
+		klog.V(3).Infof("prefixes are resent to cloud agent %s", name)
+	}
+}
+
+// getResendTargets returns full prefixes and cloud agents which they should be sent to, lag of agents is recorded too
+func (b *prefixesBroadcaster) getResendTargets() ([]byte, []string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	AgentPrefixesLag.Reset()
+	if b.last == nil {
+		return nil, nil
+	}
+
+	var targets []string
+	agents := sets.NewString()
+	for _, node := range b.mc.ListMembers() {
+		if memberlist.GetNodeMeta(node).Role != memberlist.RoleCloudAgent {
+			continue
+		}
+		agents.Insert(node.Name)
+
+		acked := b.acks[node.Name]
+		AgentPrefixesLag.WithLabelValues(node.Name).Set(float64(b.seq - acked))
+		if acked >= b.seq {
+			continue
+		}
+
+		// give agents time to acknowledge the latest broadcast
+		if time.Since(b.broadcastAt) < b.resendInterval || time.Since(b.resent[node.Name]) < b.resendInterval {
+			continue
+		}
+
+		b.resent[node.Name] = time.Now()
+		targets = append(targets, node.Name)
+		klog.V(3).Infof("cloud agent %s has prefixes of seq %d, resend prefixes of seq %d", node.Name, acked, b.seq)
+	}
+
+	// forget agents which left
+	for name := range b.acks {
+		if !agents.Has(name) {
+			delete(b.acks, name)
+		}
+	}
+	for name := range b.resent {
+		if !agents.Has(name) {
+			delete(b.resent, name)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(b.last)
+	if err != nil {
+		klog.Errorf("failed to marshal prefixes:%s", err)
+		return nil, nil
+	}
+
+	return data, targets
+}
+
+// run resends prefixes every resendInterval while isActive returns true, it returns when ctx is done
+func (b *prefixesBroadcaster) run(ctx context.Context, isActive func() bool) {
+	tick := time.NewTicker(b.resendInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if isActive() {
+				b.resend()
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	hashimemberlist "github.com/hashicorp/memberlist"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	"github.com/fabedge/fabedge/pkg/util/memberlist"
+)
+
+var _ = Describe("prefixesBroadcaster", func() {
+	const agentName = "cloud-agent-1"
+
+	var (
+		sender      *fakeSender
+		broadcaster *prefixesBroadcaster
+	)
+
+	// broadcastPrefixes broadcasts a full message of seq 1 and deltas of seq 2 and 3
+	broadcastPrefixes := func() {
+		broadcaster.broadcast(&routing.ConnectorPrefixes{NodeName: "connector", LocalPrefixes: []string{"10.0.0.0/24"}, RemotePrefixes: []string{"10.1.0.0/24"}})
+		broadcaster.broadcast(&routing.ConnectorPrefixes{NodeName: "connector", LocalPrefixes: []string{"10.0.0.0/24"}, RemotePrefixes: []string{"10.1.0.0/24", "10.2.0.0/24"}})
+		broadcaster.broadcast(&routing.ConnectorPrefixes{NodeName: "connector", LocalPrefixes: []string{"10.0.0.0/24"}, RemotePrefixes: []string{"10.2.0.0/24", "10.3.0.0/24"}})
+	}
+
+	ack := func(seq uint64) {
+		data, _ := json.Marshal(routing.PrefixesAck{Kind: routing.MessageAck, NodeName: agentName, Seq: seq})
+		broadcaster.handleMessage(data)
+	}
+
+	// resendNow resends prefixes as if resendInterval has passed since the last broadcast
+	resendNow := func() {
+		broadcaster.mux.Lock()
+		broadcaster.broadcastAt = time.Now().Add(-time.Hour)
+		broadcaster.mux.Unlock()
+
+		broadcaster.resend()
+	}
+
+	BeforeEach(func() {
+		sender = &fakeSender{members: []string{agentName}}
+		broadcaster = newPrefixesBroadcaster(time.Minute)
+		broadcaster.mc = sender
+
+		broadcastPrefixes()
+		Expect(sender.broadcastKinds()).Should(Equal([]string{routing.MessageFull, routing.MessageDelta, routing.MessageDelta}))
+	})
+
+	DescribeTable("resending full prefixes according to acks",
+		func(acks []uint64, resent bool) {
+			for _, seq := range acks {
+				ack(seq)
+			}
+			resendNow()
+
+			if !resent {
+				Expect(sender.sentTo(agentName)).Should(BeEmpty())
+				return
+			}
+
+			messages := sender.sentTo(agentName)
+			Expect(messages).Should(HaveLen(1))
+			Expect(messages[0].Kind).Should(Equal(routing.MessageFull))
+			Expect(messages[0].Seq).Should(Equal(uint64(3)))
+			Expect(messages[0].LocalPrefixes).Should(ConsistOf("10.0.0.0/24"))
+			Expect(messages[0].RemotePrefixes).Should(ConsistOf("10.2.0.0/24", "10.3.0.0/24"))
+		},
+		Entry("the latest seq is acknowledged", []uint64{3}, false),
+		Entry("an older ack arrives after the latest one", []uint64{3, 2}, false),
+		Entry("acks arrive in order", []uint64{1, 2, 3}, false),
+		Entry("no ack is received", nil, true),
+		Entry("the ack of the latest seq is missing", []uint64{1, 2}, true),
+		Entry("the agent restarted", []uint64{3, 0}, true),
+		Entry("the ack comes from the last run of connector", []uint64{10}, true),
+	)
+
+	It("should resend full prefixes at once after the agent reports a gap", func() {
+		resendNow()
+		Expect(sender.sentTo(agentName)).Should(HaveLen(1))
+
+		By("waiting for resendInterval before resending again")
+		resendNow()
+		Expect(sender.sentTo(agentName)).Should(HaveLen(1))
+
+		By("receiving an ack of the seq before the gap")
+		ack(1)
+		resendNow()
+		messages := sender.sentTo(agentName)
+		Expect(messages).Should(HaveLen(2))
+		Expect(messages[1].Kind).Should(Equal(routing.MessageFull))
+		Expect(messages[1].Seq).Should(Equal(uint64(3)))
+
+		By("catching up")
+		ack(3)
+		resendNow()
+		Expect(sender.sentTo(agentName)).Should(HaveLen(2))
+	})
+
+	It("should not resend prefixes before resendInterval passes since the last broadcast", func() {
+		broadcaster.resend()
+		Expect(sender.sentTo(agentName)).Should(BeEmpty())
+	})
+
+	It("should stop running when context is done", func() {
+		broadcaster.resendInterval = 10 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			broadcaster.run(ctx, func() bool { return true })
+		}()
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
+
+type fakeSender struct {
+	members []string
+
+	mux        sync.Mutex
+	broadcasts []routing.ConnectorPrefixes
+	sent       map[string][]routing.ConnectorPrefixes
+}
+
+func (s *fakeSender) Broadcast(b []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var msg routing.ConnectorPrefixes
+	_ = json.Unmarshal(b, &msg)
+	s.broadcasts = append(s.broadcasts, msg)
+}
+
+func (s *fakeSender) SendReliable(name string, b []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var msg routing.ConnectorPrefixes
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+
+	if s.sent == nil {
+		s.sent = make(map[string][]routing.ConnectorPrefixes)
+	}
+	s.sent[name] = append(s.sent[name], msg)
+	return nil
+}
+
+func (s *fakeSender) ListMembers() []*hashimemberlist.Node {
+	meta, _ := json.Marshal(memberlist.NodeMeta{Role: memberlist.RoleCloudAgent})
+
+	var nodes []*hashimemberlist.Node
+	for _, name := range s.members {
+		nodes = append(nodes, &hashimemberlist.Node{Name: name, Meta: meta})
+	}
+
+	return nodes
+}
+
+func (s *fakeSender) broadcastKinds() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var kinds []string
+	for _, msg := range s.broadcasts {
+		kinds = append(kinds, msg.Kind)
+	}
+
+	return kinds
+}
+
+func (s *fakeSender) sentTo(name string) []routing.ConnectorPrefixes {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.sent[name]
+}
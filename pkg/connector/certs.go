@@ -0,0 +1,91 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/util/fips"
+)
+
+// credentials are contents of files of connector's TLS secret
+type credentials struct {
+	cert []byte
+	key  []byte
+	ca   []byte
+}
+
+func (c credentials) equal(other credentials) bool {
+	return bytes.Equal(c.cert, other.cert) && bytes.Equal(c.key, other.key) && bytes.Equal(c.ca, other.ca)
+}
+
+func (m *Manager) readCredentials() (creds credentials, err error) {
+	if creds.cert, err = ioutil.ReadFile(m.CertFile); err != nil {
+		return creds, err
+	}
+	if creds.key, err = ioutil.ReadFile(m.KeyFile); err != nil {
+		return creds, err
+	}
+	if creds.ca, err = ioutil.ReadFile(m.CACertFile); err != nil {
+		return creds, err
+	}
+
+	return creds, nil
+}
+
+// syncCredentials loads the key and CA certificates into strongswan when files of TLS secret are
+// changed, e.g. they are rotated by operator and updated by kubelet, and tells if they are changed,
+// then connections should be reloaded, so new IKE SAs are authenticated by the new certificate.
+// Established SAs are kept, so tunnels are not dropped
+func (m *Manager) syncCredentials() (bool, error) {
+	creds, err := m.readCredentials()
+	if err != nil {
+		return false, err
+	}
+
+	// strongswan loads files of secret when it starts
+	if m.loadedCredentials == nil {
+		m.loadedCredentials = &creds
+		return false, nil
+	}
+
+	if creds.equal(*m.loadedCredentials) {
+		return false, nil
+	}
+
+	// the secret may be updated partly, the old ones are kept until the new ones match
+	if _, err = tls.X509KeyPair(creds.cert, creds.key); err != nil {
+		return false, fmt.Errorf("certificate doesn't match key: %w", err)
+	}
+
+	if m.FIPSMode {
+		if err = fips.ValidateCertsPEM(creds.cert); err != nil {
+			return false, err
+		}
+	}
+
+	if err = m.tm.LoadCredentials(creds.key, creds.ca); err != nil {
+		return false, err
+	}
+	m.loadedCredentials = &creds
+	klog.Info("credentials are changed and loaded, connections will be reloaded")
+
+	return true, nil
+}
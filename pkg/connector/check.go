@@ -0,0 +1,330 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	"github.com/fabedge/fabedge/pkg/util/fips"
+	"github.com/fabedge/fabedge/pkg/util/ipset"
+)
+
+// check validates arguments and tunnels config and prints connections, routes, ipsets and
+// iptables rules which connector would make from them. Nothing is changed on the host, strongswan
+// is not connected either, so it can be run anywhere the config can be read. An error is
+// returned if any problem is found
+func (c Config) check(out io.Writer) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	var problems []error
+	if c.FIPSMode {
+		certPEM, err := ioutil.ReadFile(c.CertFile)
+		if err != nil {
+			problems = append(problems, err)
+		} else if err = fips.ValidateCertsPEM(certPEM); err != nil {
+			problems = append(problems, fmt.Errorf("certificate is not FIPS compliant: %w", err))
+		}
+	}
+
+	if _, err := routing.GetRouter(c.CNIType); err != nil {
+		problems = append(problems, err)
+	}
+
+	staticDSCPRules, err := parseDSCPRules(c.DSCPRules)
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	nc, err := c.loadNetworkConfToCheck()
+	if err != nil {
+		return utilerrors.NewAggregate(append(problems, fmt.Errorf("failed to load tunnels config: %w", err)))
+	}
+	problems = append(problems, validateNetworkConf(nc)...)
+
+	recorder := newRuleRecorder()
+	m := &Manager{
+		Config:          c,
+		ipt:             recorder,
+		ipset:           ipset.New(),
+		names:           newNames(c.NamePrefix),
+		staticDSCPRules: staticDSCPRules,
+		accountingRules: make(map[string][]string),
+	}
+	m.setConnections(nc)
+
+	fmt.Fprintf(out, "router: %s\n", strings.ToLower(c.CNIType))
+
+	fmt.Fprintln(out, "\nconnections:")
+	for _, conn := range m.connections {
+		fmt.Fprintf(out, "  %s type=%s id=%s addresses=%s subnets=%s nodeSubnets=%s\n", conn.Name, conn.RemoteType, conn.RemoteID,
+			strings.Join(conn.RemoteAddress, ","), strings.Join(conn.RemoteSubnets, ","), strings.Join(conn.RemoteNodeSubnets, ","))
+	}
+
+	fmt.Fprintf(out, "\nroutes (table %d):\n", constants.TableStrongswan)
+	for _, conn := range m.connections {
+		for _, subnet := range conn.RemoteSubnets {
+			fmt.Fprintf(out, "  %s via <default gateway>\n", subnet)
+		}
+	}
+
+	fmt.Fprintln(out, "\nipsets:")
+	for _, set := range []struct {
+		name    string
+		entries sets.String
+	}{
+		{m.names.IPSetEdgeNodeCIDR, m.getAllEdgeNodeCIDRs()},
+		{m.names.IPSetCloudPodCIDR, m.getAllCloudPodCIDRs()},
+		{m.names.IPSetCloudNodeCIDR, m.getAllCloudNodeCIDRs()},
+		{m.names.IPSetEdgePodCIDR, m.getAllEdgePodCIDRs()},
+	} {
+		fmt.Fprintf(out, "  %s: %s\n", set.name, strings.Join(set.entries.List(), ","))
+	}
+
+	for _, ensure := range []func() error{
+		m.ensureForwardIPTablesRules,
+		m.ensureNatIPTablesRules,
+		m.ensureInputIPTablesRules,
+		m.ensureDSCPIPTablesRules,
+	} {
+		if err = ensure(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if m.TrafficAccounting {
+		if err = m.ensureAccountingIPTablesRules(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	fmt.Fprintln(out, "\niptables:")
+	for _, rule := range recorder.rules() {
+		fmt.Fprintf(out, "  %s\n", rule)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintln(out, "\nproblems:")
+		for _, problem := range problems {
+			fmt.Fprintf(out, "  %s\n", problem)
+		}
+	}
+
+	return utilerrors.NewAggregate(problems)
+}
+
+// loadNetworkConfToCheck loads network conf like loadNetworkConf, but the status of ConnectorConfig is not touched
+func (c Config) loadNetworkConfToCheck() (netconf.NetworkConf, error) {
+	if c.ConfigName == "" {
+		return netconf.LoadNetworkConf(c.TunnelConfigFile)
+	}
+
+	source, err := newConfigSource(c.Namespace, c.ConfigName)
+	if err != nil {
+		return netconf.NetworkConf{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	var cfg apis.ConnectorConfig
+	if err = source.client.Get(ctx, source.key, &cfg); err != nil {
+		return netconf.NetworkConf{}, err
+	}
+
+	if err = cfg.Spec.Validate(); err != nil {
+		return netconf.NetworkConf{}, fmt.Errorf("invalid connector config: %w", err)
+	}
+
+	return netconf.NetworkConf{
+		Endpoint:  cfg.Spec.Endpoint,
+		Peers:     cfg.Spec.Peers,
+		DSCPRules: cfg.Spec.DSCPRules,
+	}, nil
+}
+
+// validateNetworkConf returns problems which would make connections fail to load or routes and rules fail to be made
+func validateNetworkConf(nc netconf.NetworkConf) []error {
+	var problems []error
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if nc.ID == "" {
+		addProblem("id of connector is empty")
+	}
+	for _, subnet := range nc.Subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			addProblem("invalid subnet of connector: %s", subnet)
+		}
+	}
+	for _, subnet := range nc.NodeSubnets {
+		if !isIPOrCIDR(subnet) {
+			addProblem("invalid node subnet of connector: %s", subnet)
+		}
+	}
+
+	names := sets.NewString()
+	for _, peer := range nc.Peers {
+		if peer.Name == "" {
+			addProblem("name of peer %s is empty", peer.ID)
+			continue
+		}
+		if names.Has(peer.Name) {
+			addProblem("peer %s is duplicated", peer.Name)
+		}
+		names.Insert(peer.Name)
+
+		if peer.ID == "" {
+			addProblem("id of peer %s is empty", peer.Name)
+		}
+
+		if peer.Type != apis.EdgeNode && peer.Type != apis.Connector {
+			addProblem("invalid type of peer %s: %s", peer.Name, peer.Type)
+		}
+
+		for _, subnet := range peer.Subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				addProblem("invalid subnet of peer %s: %s", peer.Name, subnet)
+			}
+		}
+		for _, subnet := range peer.NodeSubnets {
+			if !isIPOrCIDR(subnet) {
+				addProblem("invalid node subnet of peer %s: %s", peer.Name, subnet)
+			}
+		}
+	}
+
+	for _, rule := range nc.DSCPRules {
+		if err := rule.Validate(); err != nil {
+			addProblem("invalid DSCP rule %s: %s", rule.Name, err)
+		}
+	}
+
+	return problems
+}
+
+func isIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// ruleRecorder records rules instead of making them, chains are considered empty at first
+type ruleRecorder struct {
+	// keys are "table chain" in the order chains are touched
+	keys   []string
+	chains map[string][]string
+}
+
+func newRuleRecorder() *ruleRecorder {
+	return &ruleRecorder{chains: make(map[string][]string)}
+}
+
+func (r *ruleRecorder) chain(table, chain string) string {
+	key := table + " " + chain
+	if _, ok := r.chains[key]; !ok {
+		r.keys = append(r.keys, key)
+		r.chains[key] = nil
+	}
+	return key
+}
+
+func (r *ruleRecorder) index(key string, rulespec []string) int {
+	rule := strings.Join(rulespec, " ")
+	for i, existing := range r.chains[key] {
+		if existing == rule {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *ruleRecorder) AppendUnique(table, chain string, rulespec ...string) error {
+	key := r.chain(table, chain)
+	if r.index(key, rulespec) < 0 {
+		r.chains[key] = append(r.chains[key], strings.Join(rulespec, " "))
+	}
+	return nil
+}
+
+func (r *ruleRecorder) ClearChain(table, chain string) error {
+	r.chains[r.chain(table, chain)] = nil
+	return nil
+}
+
+func (r *ruleRecorder) DeleteIfExists(table, chain string, rulespec ...string) error {
+	key := r.chain(table, chain)
+	if i := r.index(key, rulespec); i >= 0 {
+		r.chains[key] = append(r.chains[key][:i], r.chains[key][i+1:]...)
+	}
+	return nil
+}
+
+func (r *ruleRecorder) Exists(table, chain string, rulespec ...string) (bool, error) {
+	return r.index(r.chain(table, chain), rulespec) >= 0, nil
+}
+
+func (r *ruleRecorder) Insert(table, chain string, pos int, rulespec ...string) error {
+	key := r.chain(table, chain)
+	if pos < 1 || pos > len(r.chains[key])+1 {
+		return fmt.Errorf("invalid position %d of chain %s", pos, chain)
+	}
+
+	rules := append([]string{}, r.chains[key][:pos-1]...)
+	rules = append(rules, strings.Join(rulespec, " "))
+	r.chains[key] = append(rules, r.chains[key][pos-1:]...)
+	return nil
+}
+
+func (r *ruleRecorder) List(table, chain string) ([]string, error) {
+	key := r.chain(table, chain)
+	rules := []string{"-N " + chain}
+	for _, rule := range r.chains[key] {
+		rules = append(rules, fmt.Sprintf("-A %s %s", chain, rule))
+	}
+	return rules, nil
+}
+
+func (r *ruleRecorder) StructuredStats(table, chain string) ([]iptables.Stat, error) {
+	return nil, nil
+}
+
+// rules returns recorded rules in the format of iptables-save with table
+func (r *ruleRecorder) rules() []string {
+	var rules []string
+	for _, key := range r.keys {
+		parts := strings.SplitN(key, " ", 2)
+		for _, rule := range r.chains[key] {
+			rules = append(rules, fmt.Sprintf("-t %s -A %s %s", parts[0], parts[1], rule))
+		}
+	}
+	return rules
+}
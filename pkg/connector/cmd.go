@@ -15,20 +15,34 @@
 package connector
 
 import (
+	"fmt"
+	"os"
+
 	flag "github.com/spf13/pflag"
 	"k8s.io/klog/v2"
 
 	"github.com/fabedge/fabedge/pkg/common/about"
 	logutil "github.com/fabedge/fabedge/pkg/util/log"
+	routeUtil "github.com/fabedge/fabedge/pkg/util/route"
 )
 
 func Execute() {
 	defer klog.Flush()
 
+	// "connector dump" prints state of the running connector
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		if err := runDump(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fs := flag.CommandLine
 	cfg := &Config{}
 
 	logutil.AddFlags(fs)
+	logutil.AddFormatFlag(fs)
 	about.AddFlags(fs)
 	cfg.AddFlags(fs)
 
@@ -36,6 +50,18 @@ func Execute() {
 
 	about.DisplayAndExitIfRequested()
 
+	if err := logutil.Setup(logutil.KeyNode, routeUtil.GetNodeName()); err != nil {
+		klog.Fatalf("failed to set up logging: %s", err)
+	}
+
+	if cfg.Check {
+		if err := cfg.check(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	manger, err := cfg.Manager()
 	if err != nil {
 		klog.Fatalf("failed to create Manager: %s", err)
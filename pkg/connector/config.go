@@ -0,0 +1,237 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bep/debounce"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+	"github.com/fabedge/fabedge/pkg/tunnel"
+)
+
+const statusTimeout = 5 * time.Second
+
+// configSource gets tunnels config of connector from ConnectorConfig through API, the object
+// is updated atomically by operator, so there is no half synchronized file, and the result of
+// applying it is reported in its status
+type configSource struct {
+	key    client.ObjectKey
+	cache  cache.Cache
+	client client.Client
+
+	// generation is the generation of the config loaded last time
+	generation int64
+}
+
+func newConfigSource(namespace, name string) (*configSource, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err = apis.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	c, err := cache.New(restConfig, cache.Options{Scheme: scheme, Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	return &configSource{
+		key:    client.ObjectKey{Namespace: namespace, Name: name},
+		cache:  c,
+		client: cli,
+	}, nil
+}
+
+// start starts the cache and waits until it's synced, callbacks are called when spec of
+// the config is changed, they are debounced like file events
+func (s *configSource) start(ctx context.Context, debounceDuration time.Duration, callbacks ...func()) error {
+	informer, err := s.cache.GetInformer(ctx, &apis.ConnectorConfig{})
+	if err != nil {
+		return err
+	}
+
+	debounced := debounce.New(debounceDuration)
+	onChange := func(obj interface{}) {
+		cfg, ok := obj.(*apis.ConnectorConfig)
+		if !ok || cfg.Name != s.key.Name {
+			return
+		}
+
+		klog.Infof("connector config is changed, start to sync. generation: %d", cfg.Generation)
+		debounced(func() {
+			for _, c := range callbacks {
+				c()
+			}
+		})
+	}
+
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// status updates made by connector itself don't change generation
+			if oldObj.(*apis.ConnectorConfig).Generation != newObj.(*apis.ConnectorConfig).Generation {
+				onChange(newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			onChange(obj)
+		},
+	})
+
+	go func() {
+		if err := s.cache.Start(ctx); err != nil {
+			klog.Errorf("failed to start cache of connector config: %s", err)
+		}
+	}()
+
+	if !s.cache.WaitForCacheSync(ctx) {
+		return fmt.Errorf("failed to sync cache of connector config")
+	}
+
+	return nil
+}
+
+// load returns the network conf in the config, an invalid config is reported in status and not applied
+func (s *configSource) load() (netconf.NetworkConf, error) {
+	var cfg apis.ConnectorConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return netconf.NetworkConf{}, err
+	}
+
+	if err := cfg.Spec.Validate(); err != nil {
+		s.reportStatus(&cfg, 0, "Invalid", err)
+		return netconf.NetworkConf{}, fmt.Errorf("invalid connector config: %w", err)
+	}
+
+	s.generation = cfg.Generation
+	return netconf.NetworkConf{
+		Endpoint:  cfg.Spec.Endpoint,
+		Peers:     cfg.Spec.Peers,
+		DSCPRules: cfg.Spec.DSCPRules,
+	}, nil
+}
+
+// report records the result of applying the config loaded last time
+func (s *configSource) report(tunnels int, err error) {
+	var cfg apis.ConnectorConfig
+	if getErr := s.cache.Get(context.Background(), s.key, &cfg); getErr != nil {
+		klog.Errorf("failed to get connector config: %s", getErr)
+		return
+	}
+
+	// the config is changed since it's loaded, it will be reported after next sync
+	if cfg.Generation != s.generation {
+		return
+	}
+
+	s.reportStatus(&cfg, tunnels, "SyncFailed", err)
+}
+
+func (s *configSource) reportStatus(cfg *apis.ConnectorConfig, tunnels int, failedReason string, err error) {
+	condition := metav1.Condition{
+		Type:               apis.ConnectorConfigConditionSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            "config is applied",
+		ObservedGeneration: cfg.Generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = failedReason
+		condition.Message = err.Error()
+	} else {
+		now := metav1.Now()
+		cfg.Status.ObservedGeneration = cfg.Generation
+		cfg.Status.LastSyncTime = &now
+		cfg.Status.Tunnels = int32(tunnels)
+	}
+	meta.SetStatusCondition(&cfg.Status.Conditions, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	if err = s.client.Status().Update(ctx, cfg); err != nil {
+		klog.Errorf("failed to update status of connector config: %s", err)
+	}
+}
+
+// reportTunnelHistory puts summaries of tunnel history in status of the ConnectorConfig and sets condition
+// Flapping by flapping peers, status is updated only if they are changed
+func (s *configSource) reportTunnelHistory(summaries []apis.TunnelSummary) error {
+	var cfg apis.ConnectorConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return err
+	}
+
+	if len(summaries) == 0 {
+		summaries = nil
+	}
+	condition := tunnel.FlappingCondition(apis.ConnectorConfigConditionFlapping, summaries)
+
+	old := meta.FindStatusCondition(cfg.Status.Conditions, condition.Type)
+	if old != nil && old.Status == condition.Status && old.Message == condition.Message &&
+		equality.Semantic.DeepEqual(cfg.Status.TunnelHistory, summaries) {
+		return nil
+	}
+
+	cfg.Status.TunnelHistory = summaries
+	meta.SetStatusCondition(&cfg.Status.Conditions, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	return s.client.Status().Update(ctx, &cfg)
+}
+
+// reportProbes puts results of probing peers in status of the ConnectorConfig, the last results are replaced
+func (s *configSource) reportProbes(probes []apis.PeerProbe) error {
+	var cfg apis.ConnectorConfig
+	if err := s.cache.Get(context.Background(), s.key, &cfg); err != nil {
+		return err
+	}
+
+	cfg.Status.Probes = probes
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	return s.client.Status().Update(ctx, &cfg)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	routeUtil "github.com/fabedge/fabedge/pkg/util/route"
+)
+
+const (
+	// DiscoveryMemberlist makes connector gossip prefixes to cloud agents by memberlist
+	DiscoveryMemberlist = "memberlist"
+	// DiscoveryConfigMap makes connector publish prefixes in a configmap which cloud agents watch
+	DiscoveryConfigMap = "configmap"
+
+	publishTimeout = 5 * time.Second
+)
+
+// prefixesPublisher saves prefixes of connector in a configmap, the key is the node name of
+// connector, so connectors don't overwrite each other
+type prefixesPublisher struct {
+	namespace string
+	name      string
+	key       string
+	clientset kubernetes.Interface
+
+	mux sync.Mutex
+	// published is the value saved last time, it's not saved again if it's not changed
+	published string
+}
+
+func newPrefixesPublisher(namespace, name string) (*prefixesPublisher, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prefixesPublisher{
+		namespace: namespace,
+		name:      name,
+		key:       routeUtil.GetNodeName(),
+		clientset: clientset,
+	}, nil
+}
+
+func (p *prefixesPublisher) publish(cp *routing.ConnectorPrefixes) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	value := string(b)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if value == p.published {
+		return nil
+	}
+
+	if err = p.patch(&value); err != nil {
+		return err
+	}
+	p.published = value
+
+	return nil
+}
+
+// withdraw removes prefixes of this connector, so cloud agents stop routing traffic to it
+func (p *prefixesPublisher) withdraw() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if err := p.patch(nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	p.published = ""
+
+	return nil
+}
+
+// patch sets the value of key by a merge patch, a nil value removes the key. Connectors
+// patch their own keys, so there is no conflict between them
+func (p *prefixesPublisher) patch(value *string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"data": map[string]*string{p.key: value},
+	})
+	if err != nil {
+		return err
+	}
+
+	configMaps := p.clientset.CoreV1().ConfigMaps(p.namespace)
+	_, err = configMaps.Patch(ctx, p.name, types.MergePatchType, data, metav1.PatchOptions{})
+	if !errors.IsNotFound(err) || value == nil {
+		return err
+	}
+
+	_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: p.namespace,
+			Name:      p.name,
+		},
+		Data: map[string]string{p.key: *value},
+	}, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return fmt.Errorf("configmap %s is created by others, retry later", p.name)
+	}
+
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/util/conntrack"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	drainCheckInterval = time.Second
+	leaveTimeout       = 5 * time.Second
+)
+
+// drain makes cloud agents stop routing traffic to this connector, then waits until flows
+// with edge subnets are gone or DrainTimeout is reached. Tunnels, routes and rules are kept
+// meanwhile, so in-flight flows still work
+func (m *Manager) drain() {
+	if m.mc != nil {
+		// cloud agents forget prefixes of a connector which leaves
+		if err := m.mc.Leave(leaveTimeout); err != nil {
+			klog.Errorf("failed to leave memberlist: %s", err)
+		}
+	}
+
+	var subnets []string
+	m.syncer.exclusive(func() {
+		subnets = m.getAllEdgePodCIDRs().Union(m.getAllEdgeNodeCIDRs()).List()
+	})
+	deadline := time.Now().Add(m.DrainTimeout)
+	klog.Infof("drain flows of edge subnets, wait at most %s", m.DrainTimeout)
+	for {
+		count, err := conntrack.CountFlows(subnets)
+		if err != nil {
+			klog.Errorf("failed to count flows of edge subnets: %s", err)
+			return
+		}
+
+		if count == 0 {
+			klog.Info("flows of edge subnets are drained")
+			return
+		}
+
+		if time.Now().After(deadline) {
+			klog.Infof("%d flows of edge subnets are left after drain timeout", count)
+			return
+		}
+
+		klog.V(3).Infof("%d flows of edge subnets are left", count)
+		time.Sleep(drainCheckInterval)
+	}
+}
+
+// terminateTunnels unloads connections and deletes their SAs, peers are told by IKE delete
+// messages, so they don't keep sending traffic to tunnels until DPD finds them dead
+func (m *Manager) terminateTunnels() {
+	names, err := m.tm.ListConnNames()
+	if err != nil {
+		klog.Errorf("failed to list connections: %s", err)
+		return
+	}
+
+	for _, name := range names {
+		if err = m.tm.UnloadConn(name); err != nil {
+			klog.ErrorS(err, "failed to terminate connection", logutil.KeyEndpoint, name)
+		}
+	}
+	klog.Infof("%d connections are terminated", len(names))
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/connector/routing"
+	"github.com/fabedge/fabedge/pkg/util/ipset"
+)
+
+const defaultAdminSocket = "/var/run/fabedge/connector.sock"
+
+// Dump is the state of connector, desired state comes from connections and actual state
+// is read from strongswan and kernel, so differences between them can be seen at once
+type Dump struct {
+	Time        time.Time        `json:"time"`
+	Active      bool             `json:"active"`
+	Connections []ConnectionDump `json:"connections"`
+	Routes      DiffDump         `json:"routes"`
+	IPSets      []IPSetDump      `json:"ipsets"`
+	IPTables    []ChainDump      `json:"iptables"`
+	// Errors are errors met when state is read, the rest of dump is still useful
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ConnectionDump struct {
+	Name              string   `json:"name"`
+	RemoteType        string   `json:"remoteType"`
+	RemoteID          string   `json:"remoteID"`
+	RemoteAddress     []string `json:"remoteAddress,omitempty"`
+	RemoteSubnets     []string `json:"remoteSubnets,omitempty"`
+	RemoteNodeSubnets []string `json:"remoteNodeSubnets,omitempty"`
+	Loaded            bool     `json:"loaded"`
+	Established       bool     `json:"established"`
+}
+
+// DiffDump compares desired entries with actual entries
+type DiffDump struct {
+	Desired []string `json:"desired"`
+	Actual  []string `json:"actual"`
+	// Missing are desired but not actual, Unexpected are actual but not desired
+	Missing    []string `json:"missing,omitempty"`
+	Unexpected []string `json:"unexpected,omitempty"`
+}
+
+type IPSetDump struct {
+	Name string `json:"name"`
+	DiffDump
+}
+
+type ChainDump struct {
+	Table string   `json:"table"`
+	Chain string   `json:"chain"`
+	Rules []string `json:"rules"`
+}
+
+func newDiffDump(desired, actual sets.String) DiffDump {
+	return DiffDump{
+		Desired:    desired.List(),
+		Actual:     actual.List(),
+		Missing:    desired.Difference(actual).List(),
+		Unexpected: actual.Difference(desired).List(),
+	}
+}
+
+// dump reads state when no sync task is running, because tasks change connections
+func (m *Manager) dump() Dump {
+	var d Dump
+	m.syncer.exclusive(func() {
+		d = m.dumpState()
+	})
+	return d
+}
+
+func (m *Manager) dumpState() Dump {
+	d := Dump{
+		Time:   time.Now(),
+		Active: m.isActive(),
+	}
+	addError := func(format string, args ...interface{}) {
+		d.Errors = append(d.Errors, fmt.Sprintf(format, args...))
+	}
+
+	loadedNames, err := m.tm.ListConnNames()
+	if err != nil {
+		addError("failed to list connections: %s", err)
+	}
+	loaded := sets.NewString(loadedNames...)
+
+	desiredRoutes := sets.NewString()
+	for _, c := range m.connections {
+		cd := ConnectionDump{
+			Name:              c.Name,
+			RemoteType:        string(c.RemoteType),
+			RemoteID:          c.RemoteID,
+			RemoteAddress:     c.RemoteAddress,
+			RemoteSubnets:     c.RemoteSubnets,
+			RemoteNodeSubnets: c.RemoteNodeSubnets,
+			Loaded:            loaded.Has(c.Name),
+		}
+		if cd.Loaded {
+			if cd.Established, err = m.tm.IsConnEstablished(c.Name); err != nil {
+				addError("failed to get state of connection %s: %s", c.Name, err)
+			}
+		}
+		d.Connections = append(d.Connections, cd)
+
+		// routes are only added by the active instance when strongswan is running
+		if d.Active {
+			for _, subnet := range c.RemoteSubnets {
+				// only IPv4 routes are listed
+				if _, ipNet, err := net.ParseCIDR(subnet); err == nil && ipNet.IP.To4() != nil {
+					desiredRoutes.Insert(ipNet.String())
+				}
+			}
+		}
+	}
+
+	for _, name := range loaded.Difference(connNames).List() {
+		d.Connections = append(d.Connections, ConnectionDump{Name: name, Loaded: true})
+	}
+
+	actualRoutes, err := routing.GetRemotePrefixes()
+	if err != nil {
+		addError("failed to list routes: %s", err)
+	}
+	d.Routes = newDiffDump(desiredRoutes, sets.NewString(actualRoutes...))
+
+	for _, set := range []struct {
+		name    string
+		desired sets.String
+	}{
+		{m.names.IPSetEdgeNodeCIDR, m.getAllEdgeNodeCIDRs()},
+		{m.names.IPSetCloudPodCIDR, m.getAllCloudPodCIDRs()},
+		{m.names.IPSetCloudNodeCIDR, m.getAllCloudNodeCIDRs()},
+		{m.names.IPSetEdgePodCIDR, m.getAllEdgePodCIDRs()},
+	} {
+		actual, err := m.ipset.ListEntries(set.name, ipset.HashNet)
+		if err != nil {
+			addError("failed to list entries of ipset %s: %s", set.name, err)
+			actual = sets.NewString()
+		}
+		d.IPSets = append(d.IPSets, IPSetDump{Name: set.name, DiffDump: newDiffDump(set.desired, actual)})
+	}
+
+	chains := [][2]string{
+		{TableFilter, m.names.ChainInput},
+		{TableFilter, m.names.ChainForward},
+		{TableNat, m.names.ChainPostRouting},
+		{TableMangle, m.names.ChainDSCP},
+	}
+	if m.TrafficAccounting {
+		chains = append(chains, [2]string{TableFilter, m.names.ChainAccounting})
+	}
+	for _, chain := range chains {
+		rules, err := m.ipt.List(chain[0], chain[1])
+		if err != nil {
+			addError("failed to list rules of %s in table %s: %s", chain[1], chain[0], err)
+		}
+		d.IPTables = append(d.IPTables, ChainDump{Table: chain[0], Chain: chain[1], Rules: rules})
+	}
+
+	return d
+}
+
+// serveAdmin serves /dump on a unix socket, which is only reachable inside the connector pod
+func (m *Manager) serveAdmin() {
+	if err := os.MkdirAll(filepath.Dir(m.AdminSocket), 0755); err != nil {
+		klog.Errorf("failed to create directory of admin socket: %s", err)
+		return
+	}
+	// the socket may be left by the last run
+	if err := os.Remove(m.AdminSocket); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("failed to remove admin socket: %s", err)
+		return
+	}
+
+	listener, err := net.Listen("unix", m.AdminSocket)
+	if err != nil {
+		klog.Errorf("failed to listen on admin socket: %s", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dump", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.dump()); err != nil {
+			klog.Errorf("failed to write dump: %s", err)
+		}
+	})
+
+	klog.Infof("serve admin API on %s", m.AdminSocket)
+	if err = http.Serve(listener, mux); err != nil {
+		klog.Errorf("failed to serve admin API: %s", err)
+	}
+}
+
+// runDump implements "connector dump", it asks the running connector for its state and prints it
+func runDump(args []string) error {
+	fs := pflag.NewFlagSet("dump", pflag.ExitOnError)
+	socket := fs.String("admin-socket", defaultAdminSocket, "admin socket of the running connector")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout to get state, it may take a while if sync tasks are running")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", *socket)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://connector/dump")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get dump: %s: %s", resp.Status, body)
+	}
+
+	var d Dump
+	if err = json.Unmarshal(body, &d); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	return nil
+}
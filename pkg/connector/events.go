@@ -0,0 +1,145 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/tunnel"
+)
+
+const (
+	// resubscribeInterval is how long to wait before watching events again after watching fails
+	resubscribeInterval = 10 * time.Second
+	// tunnelHistoryReportInterval is how often tunnel history is summarized and reported
+	tunnelHistoryReportInterval = time.Minute
+)
+
+// watchTunnelEvents triggers syncing routes when SAs go up or down, because routes are only
+// kept when there are SAs. Tunnels are synced too when an SA goes down, so connections
+// with other connectors are initiated again. Events are recorded in tunnel history if it's enabled
+func (m *Manager) watchTunnelEvents() {
+	for {
+		err := m.tm.WatchEvents(context.Background(), func(event tunnel.Event) {
+			if m.tunnelHistory != nil {
+				m.tunnelHistory.Record(event)
+			}
+
+			switch event.Type {
+			case tunnel.EventUp:
+				m.syncer.trigger(taskRoutes, fmt.Sprintf("SA of %s is up", event.Name))
+			case tunnel.EventDown:
+				m.syncer.trigger(taskTunnels|taskRoutes, fmt.Sprintf("SA of %s is down", event.Name))
+			}
+		})
+		klog.Errorf("failed to watch tunnel events: %v", err)
+
+		// strongswan may be restarted, tunnels are loaded again once it comes back
+		time.Sleep(resubscribeInterval)
+		m.syncer.trigger(allTasks, "watching tunnel events again")
+	}
+}
+
+// reportTunnelHistory summarizes tunnel history of loaded connections every minute and reports
+// summaries in status of connector config if it's provided. Only the active instance reports, standby instances have no tunnels
+func (m *Manager) reportTunnelHistory() {
+	tick := time.NewTicker(tunnelHistoryReportInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		if !m.isActive() {
+			continue
+		}
+
+		var names []string
+		m.syncer.exclusive(func() {
+			for _, conn := range m.connections {
+				names = append(names, conn.Name)
+			}
+		})
+
+		// events of removed peers are useless
+		m.tunnelHistory.Retain(names)
+		summaries := m.tunnelHistory.Summarize(names, time.Now(), m.FlapThreshold)
+		if m.configSource == nil {
+			continue
+		}
+
+		if err := m.configSource.reportTunnelHistory(summaries); err != nil {
+			klog.Errorf("failed to report tunnel history: %s", err)
+		}
+	}
+}
+
+// watchRouteEvents triggers syncing routes when routes are deleted from table of strongswan,
+// or routes of other tables are changed, e.g. the default gateway is changed. Routes added
+// to table of strongswan are made by connector or strongswan itself, they are ignored
+func (m *Manager) watchRouteEvents() {
+	for {
+		ch, done := make(chan netlink.RouteUpdate), make(chan struct{})
+		if err := netlink.RouteSubscribe(ch, done); err != nil {
+			klog.Errorf("failed to watch route events: %s", err)
+			time.Sleep(resubscribeInterval)
+			continue
+		}
+
+		for update := range ch {
+			if update.Table == constants.TableStrongswan && update.Type == unix.RTM_NEWROUTE {
+				continue
+			}
+			m.syncer.trigger(taskRoutes, fmt.Sprintf("route %s is changed", update.Route))
+		}
+
+		// the channel is closed when subscription fails
+		close(done)
+		klog.Errorf("route subscription is closed, watch route events again")
+		time.Sleep(resubscribeInterval)
+	}
+}
+
+// watchLinkEvents triggers syncing routes when a link goes up or down, because routes through it are changed
+func (m *Manager) watchLinkEvents() {
+	operStates := make(map[int]netlink.LinkOperState)
+
+	for {
+		ch, done := make(chan netlink.LinkUpdate), make(chan struct{})
+		if err := netlink.LinkSubscribe(ch, done); err != nil {
+			klog.Errorf("failed to watch link events: %s", err)
+			time.Sleep(resubscribeInterval)
+			continue
+		}
+
+		for update := range ch {
+			attrs := update.Link.Attrs()
+			if state, ok := operStates[attrs.Index]; ok && state == attrs.OperState {
+				continue
+			}
+			operStates[attrs.Index] = attrs.OperState
+
+			m.syncer.trigger(taskRoutes, fmt.Sprintf("link %s is %s", attrs.Name, attrs.OperState))
+		}
+
+		close(done)
+		klog.Errorf("link subscription is closed, watch link events again")
+		time.Sleep(resubscribeInterval)
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+const (
+	roleActive  = "active"
+	roleStandby = "standby"
+
+	// garpCount is how many gratuitous ARP packets are sent when VIP is taken over
+	garpCount    = 3
+	garpInterval = 200 * time.Millisecond
+)
+
+// HAConfig configures active/standby mode. Connector instances elect a leader through a lease,
+// the leader is active: it holds VIP, initiates tunnels and routes traffic. Others are standby,
+// they keep tunnels config loaded, so they only need to take VIP and initiate tunnels to fail over
+type HAConfig struct {
+	Enabled       bool
+	LeaseName     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// VIP is the address in CIDR held by the active instance, it should be the public address
+	// of connector, it's not managed by connector if it's empty, e.g. it's managed by keepalived
+	VIP          string
+	VIPInterface string
+	// the active instance gives up leadership after HealthCheckThreshold continuous failures of health check
+	HealthCheckInterval  time.Duration
+	HealthCheckThreshold int
+	// StateFile is where the role of the instance is written, e.g. for track scripts of keepalived
+	StateFile string
+}
+
+func (c HAConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.LeaseName == "" {
+		return fmt.Errorf("lease name is required for active/standby mode")
+	}
+
+	if c.VIP != "" {
+		if _, err := netlink.ParseAddr(c.VIP); err != nil {
+			return fmt.Errorf("invalid VIP %s, it should be in CIDR: %w", c.VIP, err)
+		}
+
+		if c.VIPInterface == "" {
+			return fmt.Errorf("interface of VIP is required")
+		}
+	}
+
+	if c.HealthCheckInterval <= 0 || c.HealthCheckThreshold <= 0 {
+		return fmt.Errorf("health check interval and threshold should be positive")
+	}
+
+	return nil
+}
+
+// isActive tells if this instance should establish tunnels and route traffic
+func (m *Manager) isActive() bool {
+	return !m.HA.Enabled || atomic.LoadInt32(&m.leading) == 1
+}
+
+// localAddresses returns the addresses which tunnels to other connectors are initiated from,
+// the active instance uses VIP, because other connectors only accept connections from it
+func (m *Manager) localAddresses() []string {
+	if !m.HA.Enabled || m.HA.VIP == "" {
+		return nil
+	}
+
+	addr, _ := netlink.ParseAddr(m.HA.VIP)
+	return []string{addr.IP.String()}
+}
+
+func newLeaseLock(namespace, name string) (resourcelock.Interface, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// connector runs in host network, with pod anti-affinity hostname is unique among instances
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}, nil
+}
+
+// runLeaderElection runs for leadership until ctx is done. An instance runs for leadership only
+// when it's healthy, and it runs again as standby after it loses leadership
+func (m *Manager) runLeaderElection(ctx context.Context) {
+	defer close(m.electionDone)
+
+	// VIP may be left by a crashed instance
+	m.releaseVIP()
+	m.writeState(roleStandby)
+
+	for ctx.Err() == nil {
+		if !m.waitUntilHealthy(ctx) {
+			return
+		}
+
+		// leadership is released when leaderCtx is canceled, either connector is stopped or unhealthy
+		leaderCtx, cancel := context.WithCancel(ctx)
+		leaderelection.RunOrDie(leaderCtx, leaderelection.LeaderElectionConfig{
+			Lock:            m.leaseLock,
+			LeaseDuration:   m.HA.LeaseDuration,
+			RenewDeadline:   m.HA.RenewDeadline,
+			RetryPeriod:     m.HA.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            m.HA.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					m.onStartedLeading(ctx)
+					cancel()
+				},
+				OnStoppedLeading: m.onStoppedLeading,
+				OnNewLeader: func(identity string) {
+					klog.Infof("connector leader is %s", identity)
+				},
+			},
+		})
+		cancel()
+	}
+}
+
+func (m *Manager) onStartedLeading(ctx context.Context) {
+	klog.Info("become active")
+
+	atomic.StoreInt32(&m.leading, 1)
+	m.recordActive()
+	m.holdVIP()
+	m.writeState(roleActive)
+	m.syncer.trigger(allTasks, "becoming active")
+
+	m.checkHealthUntilFailed(ctx)
+}
+
+// onStoppedLeading releases VIP and terminates tunnels, tunnels config is loaded again as standby
+func (m *Manager) onStoppedLeading() {
+	// it's called even if leadership is never acquired
+	if !atomic.CompareAndSwapInt32(&m.leading, 1, 0) {
+		return
+	}
+	klog.Info("become standby")
+	m.recordActive()
+
+	m.releaseVIP()
+	m.writeState(roleStandby)
+	m.withdrawPrefixes()
+
+	names, err := m.tm.ListConnNames()
+	if err != nil {
+		klog.Errorf("failed to list connections: %s", err)
+	}
+	for _, name := range names {
+		if err = m.tm.UnloadConn(name); err != nil {
+			klog.ErrorS(err, "failed to unload connection", logutil.KeyEndpoint, name)
+		}
+	}
+
+	m.syncer.trigger(allTasks, "becoming standby")
+}
+
+// checkHealth checks if strongswan can be reached
+func (m *Manager) checkHealth() error {
+	_, err := m.tm.IsActive()
+	return err
+}
+
+func (m *Manager) waitUntilHealthy(ctx context.Context) bool {
+	for {
+		err := m.checkHealth()
+		if err == nil {
+			return true
+		}
+		klog.Errorf("connector is unhealthy, wait before running for leadership: %s", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(m.HA.HealthCheckInterval):
+		}
+	}
+}
+
+// checkHealthUntilFailed blocks until ctx is done or health check fails continuously
+func (m *Manager) checkHealthUntilFailed(ctx context.Context) {
+	tick := time.NewTicker(m.HA.HealthCheckInterval)
+	defer tick.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		if err := m.checkHealth(); err != nil {
+			failures++
+			klog.Errorf("health check failed %d times: %s", failures, err)
+		} else {
+			failures = 0
+		}
+
+		if failures >= m.HA.HealthCheckThreshold {
+			klog.Errorf("give up leadership because connector is unhealthy")
+			return
+		}
+	}
+}
+
+func (m *Manager) holdVIP() {
+	if m.HA.VIP == "" {
+		return
+	}
+
+	link, addr, err := m.getVIP()
+	if err != nil {
+		klog.Errorf("failed to get VIP: %s", err)
+		return
+	}
+
+	if err = netlink.AddrReplace(link, addr); err != nil {
+		klog.Errorf("failed to add VIP %s to %s: %s", m.HA.VIP, m.HA.VIPInterface, err)
+		return
+	}
+	klog.Infof("VIP %s is added to %s", m.HA.VIP, m.HA.VIPInterface)
+
+	// neighbors may still send packets to the previous active instance until their ARP caches expire
+	for i := 0; i < garpCount; i++ {
+		if err = sendGratuitousARP(link, addr.IP); err != nil {
+			klog.Errorf("failed to send gratuitous ARP of %s: %s", addr.IP, err)
+			return
+		}
+		time.Sleep(garpInterval)
+	}
+}
+
+func (m *Manager) releaseVIP() {
+	if m.HA.VIP == "" {
+		return
+	}
+
+	link, addr, err := m.getVIP()
+	if err != nil {
+		klog.Errorf("failed to get VIP: %s", err)
+		return
+	}
+
+	if err = netlink.AddrDel(link, addr); err != nil && err != unix.EADDRNOTAVAIL {
+		klog.Errorf("failed to remove VIP %s from %s: %s", m.HA.VIP, m.HA.VIPInterface, err)
+		return
+	}
+	klog.V(3).Infof("VIP %s is removed from %s", m.HA.VIP, m.HA.VIPInterface)
+}
+
+func (m *Manager) getVIP() (netlink.Link, *netlink.Addr, error) {
+	link, err := netlink.LinkByName(m.HA.VIPInterface)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr, err := netlink.ParseAddr(m.HA.VIP)
+	return link, addr, err
+}
+
+func (m *Manager) writeState(role string) {
+	if m.HA.StateFile == "" {
+		return
+	}
+
+	if err := ioutil.WriteFile(m.HA.StateFile, []byte(role+"\n"), 0644); err != nil {
+		klog.Errorf("failed to write state file: %s", err)
+	}
+}
+
+// sendGratuitousARP broadcasts an ARP request whose sender and target are both ip, so neighbors
+// update their ARP caches at once. IPv6 neighbors learn the change by neighbor discovery, so
+// nothing is sent for an IPv6 address
+func sendGratuitousARP(link netlink.Link, ip net.IP) error {
+	ip = ip.To4()
+	if ip == nil {
+		return nil
+	}
+
+	attrs := link.Attrs()
+	if len(attrs.HardwareAddr) != 6 {
+		return fmt.Errorf("%s has no ethernet address", attrs.Name)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:2], 1)             // hardware type: ethernet
+	binary.BigEndian.PutUint16(packet[2:4], unix.ETH_P_IP) // protocol type: IPv4
+	packet[4], packet[5] = 6, 4                            // length of hardware address and protocol address
+	binary.BigEndian.PutUint16(packet[6:8], 1)             // operation: request
+	copy(packet[8:14], attrs.HardwareAddr)
+	copy(packet[14:18], ip)
+	copy(packet[24:28], ip)
+
+	to := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  attrs.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	return unix.Sendto(fd, packet, 0, to)
+}
+
+func htons(i uint16) uint16 {
+	return i<<8 | i>>8
+}
@@ -15,10 +15,14 @@
 package connector
 
 import (
+	"fmt"
 	"github.com/fabedge/fabedge/pkg/tunnel"
 	"net"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/coreos/go-iptables/iptables"
 	"github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
@@ -26,52 +30,193 @@ import (
 )
 
 const (
-	TableFilter             = "filter"
-	TableNat                = "nat"
-	ChainInput              = "INPUT"
-	ChainForward            = "FORWARD"
-	ChainPostRouting        = "POSTROUTING"
-	ChainFabEdgeInput       = "FABEDGE-INPUT"
-	ChainFabEdgeForward     = "FABEDGE-FORWARD"
-	ChainFabEdgePostRouting = "FABEDGE-POSTROUTING"
-	IPSetEdgeNodeCIDR       = "FABEDGE-EDGE-NODE-CIDR"
-	IPSetCloudPodCIDR       = "FABEDGE-CLOUD-POD-CIDR"
-	IPSetCloudNodeCIDR      = "FABEDGE-CLOUD-NODE-CIDR"
-	IPSetEdgePodCIDR        = "FABEDGE-EDGE-POD-CIDR"
+	TableFilter      = "filter"
+	TableNat         = "nat"
+	TableMangle      = "mangle"
+	ChainInput       = "INPUT"
+	ChainForward     = "FORWARD"
+	ChainPostRouting = "POSTROUTING"
 )
 
+const (
+	// DefaultNamePrefix is the prefix of names of iptables chains and ipsets made by connector
+	DefaultNamePrefix = "FABEDGE"
+	// maxNamePrefixLength keeps the longest ipset name, e.g. FABEDGE-CLOUD-NODE-CIDR, within 31 characters
+	maxNamePrefixLength = 15
+)
+
+// iptablesInterface is what connector uses of iptables, rules are only recorded by ruleRecorder in check mode
+type iptablesInterface interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+	ClearChain(table, chain string) error
+	DeleteIfExists(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	Insert(table, chain string, pos int, rulespec ...string) error
+	List(table, chain string) ([]string, error)
+	StructuredStats(table, chain string) ([]iptables.Stat, error)
+}
+
+var namePrefixRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// names are names of iptables chains and ipsets made by connector, they all start with
+// the same prefix, so connectors of different instances don't clobber each other on one host
+type names struct {
+	ChainInput         string
+	ChainForward       string
+	ChainPostRouting   string
+	ChainDSCP          string
+	ChainAccounting    string
+	IPSetEdgeNodeCIDR  string
+	IPSetCloudPodCIDR  string
+	IPSetCloudNodeCIDR string
+	IPSetEdgePodCIDR   string
+}
+
+func newNames(prefix string) names {
+	return names{
+		ChainInput:         prefix + "-INPUT",
+		ChainForward:       prefix + "-FORWARD",
+		ChainPostRouting:   prefix + "-POSTROUTING",
+		ChainDSCP:          prefix + "-DSCP",
+		ChainAccounting:    prefix + "-ACCOUNTING",
+		IPSetEdgeNodeCIDR:  prefix + "-EDGE-NODE-CIDR",
+		IPSetCloudPodCIDR:  prefix + "-CLOUD-POD-CIDR",
+		IPSetCloudNodeCIDR: prefix + "-CLOUD-NODE-CIDR",
+		IPSetEdgePodCIDR:   prefix + "-EDGE-POD-CIDR",
+	}
+}
+
+func (c Config) validateNamePrefix() error {
+	if len(c.NamePrefix) > maxNamePrefixLength || !namePrefixRegexp.MatchString(c.NamePrefix) {
+		return fmt.Errorf("invalid name prefix: %q, it should be at most %d letters, digits, '-' or '_'", c.NamePrefix, maxNamePrefixLength)
+	}
+	return nil
+}
+
+const (
+	// SNATModeMasquerade makes traffic from edge to cloud nodes and from edge nodes to cloud pods masqueraded
+	SNATModeMasquerade = "masquerade"
+	// SNATModeNone keeps source IPs of all traffic from edge, routes back to edge are needed on cloud nodes
+	SNATModeNone = "none"
+)
+
+func (c Config) validateSNAT() error {
+	if c.SNATMode != SNATModeMasquerade && c.SNATMode != SNATModeNone {
+		return fmt.Errorf("invalid SNAT mode: %s", c.SNATMode)
+	}
+
+	for _, cidr := range c.SNATDestinations {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid SNAT destination: %s", cidr)
+		}
+	}
+
+	if c.SNATSource != "" && net.ParseIP(c.SNATSource).To4() == nil {
+		return fmt.Errorf("invalid SNAT source: %s", c.SNATSource)
+	}
+
+	return nil
+}
+
 func (m *Manager) clearFabedgeIptablesChains() error {
-	err := m.ipt.ClearChain(TableFilter, ChainFabEdgeInput)
+	err := m.ipt.ClearChain(TableFilter, m.names.ChainInput)
+	if err != nil {
+		return err
+	}
+	err = m.ipt.ClearChain(TableFilter, m.names.ChainForward)
+	if err != nil {
+		return err
+	}
+	err = m.ipt.ClearChain(TableNat, m.names.ChainPostRouting)
 	if err != nil {
 		return err
 	}
-	err = m.ipt.ClearChain(TableFilter, ChainFabEdgeForward)
+	err = m.ipt.ClearChain(TableMangle, m.names.ChainDSCP)
 	if err != nil {
 		return err
 	}
-	return m.ipt.ClearChain(TableNat, ChainFabEdgePostRouting)
+	if m.TrafficAccounting {
+		return m.ipt.ClearChain(TableFilter, m.names.ChainAccounting)
+	}
+	return nil
+}
+
+// parseDSCPRules parses rules like {"46": "10.10.0.0/16;10.11.0.0/16"}, the key is DSCP and the value is CIDRs
+func parseDSCPRules(rules map[string]string) ([]v1alpha1.DSCPRule, error) {
+	var result []v1alpha1.DSCPRule
+	for _, key := range sets.StringKeySet(rules).List() {
+		dscp, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DSCP: %s", key)
+		}
+
+		rule := v1alpha1.DSCPRule{
+			Name:  "argument",
+			DSCP:  int32(dscp),
+			CIDRs: strings.Split(rules[key], ";"),
+		}
+		if err = rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid DSCP rule %s=%s: %w", key, rules[key], err)
+		}
+
+		result = append(result, rule)
+	}
+
+	return result, nil
+}
+
+// ensureDSCPIPTablesRules sets DSCP on traffic forwarded to edge, ESP packets copy DSCP of
+// the traffic they carry, so WAN devices can classify tunneled traffic. Later rules override
+// earlier ones, so rules from arguments are added last
+func (m *Manager) ensureDSCPIPTablesRules() (err error) {
+	if err = m.ipt.ClearChain(TableMangle, m.names.ChainDSCP); err != nil {
+		return err
+	}
+
+	if err = m.ipt.AppendUnique(TableMangle, ChainForward, "-j", m.names.ChainDSCP); err != nil {
+		return err
+	}
+
+	for _, rule := range append(append([]v1alpha1.DSCPRule{}, m.dscpRules...), m.staticDSCPRules...) {
+		for _, cidr := range rule.CIDRs {
+			// rules are only made by iptables, IPv6 CIDRs are skipped
+			if ip, _, err := net.ParseCIDR(cidr); err != nil || ip.To4() == nil {
+				continue
+			}
+
+			if err = m.ipt.AppendUnique(TableMangle, m.names.ChainDSCP, "-d", cidr, "-j", "DSCP", "--set-dscp", strconv.Itoa(int(rule.DSCP))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 func (m *Manager) ensureForwardIPTablesRules() (err error) {
 	// ensure rules exist
-	if err = m.ipt.AppendUnique(TableFilter, ChainForward, "-j", ChainFabEdgeForward); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, ChainForward, "-j", m.names.ChainForward); err != nil {
 		return err
 	}
 
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeForward, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeForward, "-m", "set", "--match-set", IPSetCloudPodCIDR, "src", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "src", "-j", "ACCEPT"); err != nil {
+		return err
+	}
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeForward, "-m", "set", "--match-set", IPSetCloudPodCIDR, "dst", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "set", "--match-set", m.names.IPSetCloudNodeCIDR, "src", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeForward, "-m", "set", "--match-set", IPSetCloudNodeCIDR, "src", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "set", "--match-set", m.names.IPSetCloudNodeCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeForward, "-m", "set", "--match-set", IPSetCloudNodeCIDR, "dst", "-j", "ACCEPT"); err != nil {
+	// for edge-pod to edge-pod relayed by connector, see relayedSubnets
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainForward, "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 
@@ -79,68 +224,97 @@ func (m *Manager) ensureForwardIPTablesRules() (err error) {
 }
 
 func (m *Manager) ensureNatIPTablesRules() (err error) {
-	if err = m.ipt.ClearChain(TableNat, ChainFabEdgePostRouting); err != nil {
+	if err = m.ipt.ClearChain(TableNat, m.names.ChainPostRouting); err != nil {
 		return err
 	}
-	exists, err := m.ipt.Exists(TableNat, ChainPostRouting, "-j", ChainFabEdgePostRouting)
+	exists, err := m.ipt.Exists(TableNat, ChainPostRouting, "-j", m.names.ChainPostRouting)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
-		if err = m.ipt.Insert(TableNat, ChainPostRouting, 1, "-j", ChainFabEdgePostRouting); err != nil {
+		if err = m.ipt.Insert(TableNat, ChainPostRouting, 1, "-j", m.names.ChainPostRouting); err != nil {
 			return err
 		}
 	}
 
 	// for cloud-pod to edge-pod, not masquerade, in order to avoid flannel issue
-	if err = m.ipt.AppendUnique(TableNat, ChainFabEdgePostRouting, "-m", "set", "--match-set", IPSetCloudPodCIDR, "src", "-m", "set", "--match-set", IPSetEdgePodCIDR, "dst", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 
 	// for edge-pod to cloud-pod, not masquerade, in order to avoid flannel issue
-	if err = m.ipt.AppendUnique(TableNat, ChainFabEdgePostRouting, "-m", "set", "--match-set", IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", IPSetCloudPodCIDR, "dst", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "dst", "-j", "ACCEPT"); err != nil {
+		return err
+	}
+
+	// for edge-pod to edge-pod relayed by connector, not masquerade, edge pods see each other's IPs
+	if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 
 	// for cloud-pod to edge-node, not masquerade, in order to avoid flannel issue
-	if err = m.ipt.AppendUnique(TableNat, ChainFabEdgePostRouting, "-m", "set", "--match-set", IPSetCloudPodCIDR, "src", "-m", "set", "--match-set", IPSetEdgeNodeCIDR, "dst", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetEdgeNodeCIDR, "dst", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 
+	if m.SNATMode == SNATModeNone {
+		return nil
+	}
+
+	target := []string{"-j", "MASQUERADE"}
+	if m.SNATSource != "" {
+		target = []string{"-j", "SNAT", "--to-source", m.SNATSource}
+	}
+
+	if len(m.SNATDestinations) > 0 {
+		// only traffic from edge to selected destinations is masqueraded, others keep their source IPs
+		for _, cidr := range m.SNATDestinations {
+			for _, set := range []string{m.names.IPSetEdgePodCIDR, m.names.IPSetEdgeNodeCIDR} {
+				rule := append([]string{"-m", "set", "--match-set", set, "src", "-d", cidr}, target...)
+				if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, rule...); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
 	// for edge-pod to cloud-node, to masquerade it, in order to avoid rp_filter issue
-	if err = m.ipt.AppendUnique(TableNat, ChainFabEdgePostRouting, "-m", "set", "--match-set", IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", IPSetCloudNodeCIDR, "dst", "-j", "MASQUERADE"); err != nil {
+	rule := append([]string{"-m", "set", "--match-set", m.names.IPSetEdgePodCIDR, "src", "-m", "set", "--match-set", m.names.IPSetCloudNodeCIDR, "dst"}, target...)
+	if err = m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, rule...); err != nil {
 		return err
 	}
 
 	// for edge-node to cloud-pod, to masquerade it, or the return traffic will not come back to connector node.
-	return m.ipt.AppendUnique(TableNat, ChainFabEdgePostRouting, "-m", "set", "--match-set", IPSetEdgeNodeCIDR, "src", "-m", "set", "--match-set", IPSetCloudPodCIDR, "dst", "-j", "MASQUERADE")
-
+	rule = append([]string{"-m", "set", "--match-set", m.names.IPSetEdgeNodeCIDR, "src", "-m", "set", "--match-set", m.names.IPSetCloudPodCIDR, "dst"}, target...)
+	return m.ipt.AppendUnique(TableNat, m.names.ChainPostRouting, rule...)
 }
 
 func (m *Manager) ensureInputIPTablesRules() (err error) {
 	// ensure rules exist
-	if err = m.ipt.AppendUnique(TableFilter, ChainInput, "-j", ChainFabEdgeInput); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, ChainInput, "-j", m.names.ChainInput); err != nil {
 		return err
 	}
 
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeInput, "-p", "udp", "-m", "udp", "--dport", "500", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainInput, "-p", "udp", "-m", "udp", "--dport", "500", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeInput, "-p", "udp", "-m", "udp", "--dport", "4500", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainInput, "-p", "udp", "-m", "udp", "--dport", "4500", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeInput, "-p", "esp", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainInput, "-p", "esp", "-j", "ACCEPT"); err != nil {
 		return err
 	}
-	if err = m.ipt.AppendUnique(TableFilter, ChainFabEdgeInput, "-p", "ah", "-j", "ACCEPT"); err != nil {
+	if err = m.ipt.AppendUnique(TableFilter, m.names.ChainInput, "-p", "ah", "-j", "ACCEPT"); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (m *Manager) syncEdgeNodeCIDRSet() error {
-	ipsetObj, err := m.ipset.EnsureIPSet(IPSetEdgeNodeCIDR, ipset.HashNet)
+	ipsetObj, err := m.ipset.EnsureIPSet(m.names.IPSetEdgeNodeCIDR, ipset.HashNet)
 	if err != nil {
 		return err
 	}
@@ -185,11 +359,11 @@ func (m *Manager) getAllEdgeNodeCIDRs() sets.String {
 }
 
 func (m *Manager) getOldEdgeNodeCIDRs() (sets.String, error) {
-	return m.ipset.ListEntries(IPSetEdgeNodeCIDR, ipset.HashNet)
+	return m.ipset.ListEntries(m.names.IPSetEdgeNodeCIDR, ipset.HashNet)
 }
 
 func (m *Manager) syncCloudPodCIDRSet() error {
-	ipsetObj, err := m.ipset.EnsureIPSet(IPSetCloudPodCIDR, ipset.HashNet)
+	ipsetObj, err := m.ipset.EnsureIPSet(m.names.IPSetCloudPodCIDR, ipset.HashNet)
 	if err != nil {
 		return err
 	}
@@ -213,15 +387,15 @@ func (m *Manager) getAllCloudPodCIDRs() sets.String {
 }
 
 func (m *Manager) getOldCloudPodCIDRs() (sets.String, error) {
-	return m.ipset.ListEntries(IPSetCloudPodCIDR, ipset.HashNet)
+	return m.ipset.ListEntries(m.names.IPSetCloudPodCIDR, ipset.HashNet)
 }
 
 func (m *Manager) CleanSNatIPTablesRules() error {
-	return m.ipt.ClearChain(TableNat, ChainFabEdgePostRouting)
+	return m.ipt.ClearChain(TableNat, m.names.ChainPostRouting)
 }
 
 func (m *Manager) syncCloudNodeCIDRSet() error {
-	ipsetObj, err := m.ipset.EnsureIPSet(IPSetCloudNodeCIDR, ipset.HashNet)
+	ipsetObj, err := m.ipset.EnsureIPSet(m.names.IPSetCloudNodeCIDR, ipset.HashNet)
 	if err != nil {
 		return err
 	}
@@ -255,11 +429,11 @@ func (m *Manager) getAllCloudNodeCIDRs() sets.String {
 }
 
 func (m *Manager) getOldCloudNodeCIDRs() (sets.String, error) {
-	return m.ipset.ListEntries(IPSetCloudNodeCIDR, ipset.HashNet)
+	return m.ipset.ListEntries(m.names.IPSetCloudNodeCIDR, ipset.HashNet)
 }
 
 func (m *Manager) syncEdgePodCIDRSet() error {
-	ipsetObj, err := m.ipset.EnsureIPSet(IPSetEdgePodCIDR, ipset.HashNet)
+	ipsetObj, err := m.ipset.EnsureIPSet(m.names.IPSetEdgePodCIDR, ipset.HashNet)
 	if err != nil {
 		return err
 	}
@@ -283,5 +457,5 @@ func (m *Manager) getAllEdgePodCIDRs() sets.String {
 }
 
 func (m *Manager) getOldEdgePodCIDRs() (sets.String, error) {
-	return m.ipset.ListEntries(IPSetEdgePodCIDR, ipset.HashNet)
+	return m.ipset.ListEntries(m.names.IPSetEdgePodCIDR, ipset.HashNet)
 }
@@ -15,31 +15,80 @@
 package connector
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/fabedge/fabedge/pkg/util/memberlist"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/coreos/go-iptables/iptables"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2"
 
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/common/about"
 	"github.com/fabedge/fabedge/pkg/connector/routing"
 	"github.com/fabedge/fabedge/pkg/tunnel"
 	"github.com/fabedge/fabedge/pkg/tunnel/strongswan"
+	"github.com/fabedge/fabedge/pkg/util/conntrack"
+	"github.com/fabedge/fabedge/pkg/util/fips"
 	"github.com/fabedge/fabedge/pkg/util/ipset"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 )
 
 type Manager struct {
 	Config
 	tm          tunnel.Manager
-	ipt         *iptables.IPTables
+	ipt         iptablesInterface
 	connections []tunnel.ConnConfig
 	ipset       ipset.Interface
 	router      routing.Routing
 	mc          *memberlist.Client
+
+	// publisher is used instead of mc in configmap mode, one of them is nil,
+	// broadcaster broadcasts prefixes through mc
+	publisher   *prefixesPublisher
+	broadcaster *prefixesBroadcaster
+
+	// configSource is nil if config is read from TunnelConfigFile
+	configSource *configSource
+	syncer       *syncer
+
+	// tunnelHistory is nil if TunnelHistorySize is 0
+	tunnelHistory *tunnel.History
+
+	// dscpRules come from tunnels config, staticDSCPRules come from arguments and take precedence
+	dscpRules       []apis.DSCPRule
+	staticDSCPRules []apis.DSCPRule
+	// accountingRules are rules in FABEDGE-ACCOUNTING, the key is the joined rule
+	accountingRules map[string][]string
+
+	// names are names of iptables chains and ipsets, they start with NamePrefix
+	names names
+
+	// loadedCredentials are files of TLS secret which are loaded last time
+	loadedCredentials *credentials
+
+	// cloudCIDRs is nil unless local prefixes are discovered through kube-apiserver
+	cloudCIDRs *cloudCIDRDiscoverer
+
+	// routeConntrack and snatConntrack delete flows of subnets whose routes or SNAT rules are changed
+	routeConntrack conntrack.Cleaner
+	snatConntrack  conntrack.Cleaner
+
+	// leaseLock is nil if active/standby mode is disabled, leading is 1 when this instance is the leader
+	leaseLock    resourcelock.Interface
+	leading      int32
+	stopElection context.CancelFunc
+	electionDone chan struct{}
+
+	// stopBroadcast stops resending prefixes to cloud agents
+	stopBroadcast context.CancelFunc
 }
 
 type Config struct {
@@ -47,22 +96,191 @@ type Config struct {
 	DebounceDuration time.Duration
 	TunnelConfigFile string
 	CertFile         string
+	KeyFile          string
+	CACertFile       string
+	CRLFile          string
 	ViciSocket       string
 	CNIType          string
-	initMembers      []string
+	Memberlist       memberlist.Config
+	// SubnetsPerChildSA is the max number of subnets in traffic selectors of a child SA
+	SubnetsPerChildSA int
+	// FIPSMode restricts IPsec proposals and certificates to FIPS approved algorithms
+	FIPSMode bool
+
+	// ConfigName is the name of ConnectorConfig in Namespace, it's used instead of TunnelConfigFile if provided
+	ConfigName string
+	Namespace  string
+	// ProbeInterval is how often peers are pinged through tunnels, results are reported in status
+	// of ConnectorConfig. Each peer is pinged ProbeCount times, a ping not replied in ProbeTimeout is lost
+	ProbeInterval time.Duration
+	ProbeCount    int
+	ProbeTimeout  time.Duration
+	// TunnelHistorySize is the max number of up, down and rekey events kept for each peer, 0 means
+	// events are not recorded. Peers whose tunnels go down more than FlapThreshold times in an hour
+	// are flapping, summaries of the history are reported in status of ConnectorConfig
+	TunnelHistorySize int
+	FlapThreshold     int
+
+	HA HAConfig
+	// MetricsBindAddress is the address to serve /metrics and /healthz, 0 means they are not served
+	MetricsBindAddress string
+
+	// SNATMode is masquerade or none, SNATDestinations limits SNAT to traffic to these CIDRs, and
+	// SNATSource is the address which sources are translated to, empty means masquerading
+	SNATMode         string
+	SNATDestinations []string
+	SNATSource       string
+
+	// DSCPRules are provided by arguments, the key is DSCP and the value is CIDRs separated by semicolon
+	DSCPRules map[string]string
+
+	// TrafficAccounting makes connector count traffic with each peer by iptables rules, counters are exposed as metrics
+	TrafficAccounting bool
+
+	// AdminSocket is the unix socket which "connector dump" gets state from, empty means it's not served
+	AdminSocket string
+
+	// Discovery is how prefixes are sent to cloud agents, memberlist or configmap. PrefixesConfigMap
+	// is the name of configmap in Namespace where prefixes are published in configmap mode
+	Discovery         string
+	PrefixesConfigMap string
+	// PrefixesResendInterval is how long to wait for cloud agents to acknowledge prefixes before resending them
+	PrefixesResendInterval time.Duration
+
+	// ClearConntrack makes connector delete conntrack entries of subnets whose routes or SNAT rules are changed
+	ClearConntrack bool
+
+	// ViciSockets are sockets of strongswan instances which load connections to peers of
+	// some types, the key is the type, connections to other peers are loaded through ViciSocket
+	ViciSockets map[string]string
+
+	// NamePrefix is the prefix of names of iptables chains and ipsets, e.g. FABEDGE-FORWARD
+	NamePrefix string
+
+	// DrainTimeout is how long to wait for flows of edge subnets to finish when connector stops, 0 means
+	// not waiting. TerminateTunnelsOnShutdown makes connector delete SAs when it stops, so peers know at once
+	DrainTimeout               time.Duration
+	TerminateTunnelsOnShutdown bool
+
+	// Check makes connector validate config and print what it would make, then exit
+	Check bool
+
+	// LocalPrefixes are sources of local prefixes sent to cloud agents: cni, config and apiserver.
+	// EdgeLabels tell edge nodes from cloud nodes when pod CIDRs are discovered through kube-apiserver
+	LocalPrefixes []string
+	EdgeLabels    map[string]string
+
+	// TaskSyncPeriods override SyncPeriod of some tasks, the key is the task and the value is a duration.
+	// A failed task is retried after SyncRetryBackoff, which is doubled on each failure up to SyncMaxRetryBackoff
+	TaskSyncPeriods     map[string]string
+	SyncRetryBackoff    time.Duration
+	SyncMaxRetryBackoff time.Duration
 }
 
-func msgHandler(b []byte) {
+func memberEventHandler(event memberlist.MemberEvent) {
+	klog.V(5).Infof("member %s(%s, role: %s) event: %s", event.Name, event.Addr, event.Meta.Role, event.Reason)
 }
 
-func nodeLeveHandler(name string) {
+// validate checks arguments which can be checked without touching strongswan or the host
+func (c Config) validate() error {
+	if fips.BuildEnabled() && !c.FIPSMode {
+		return fmt.Errorf("fips mode can not be disabled, connector is built with tag fips")
+	}
+
+	if err := c.HA.validate(); err != nil {
+		return err
+	}
+
+	if err := c.validateSNAT(); err != nil {
+		return err
+	}
+
+	if err := c.validateNamePrefix(); err != nil {
+		return err
+	}
+
+	switch c.Discovery {
+	case DiscoveryMemberlist:
+		if c.PrefixesResendInterval <= 0 {
+			return fmt.Errorf("prefixes resend interval should be positive")
+		}
+	case DiscoveryConfigMap:
+	default:
+		return fmt.Errorf("invalid discovery: %s", c.Discovery)
+	}
+
+	if c.ProbeInterval < 0 {
+		return fmt.Errorf("probe interval can not be negative")
+	}
+	if c.ProbeInterval > 0 {
+		if c.ConfigName == "" {
+			return fmt.Errorf("connector config is required to report probes")
+		}
+		if c.ProbeCount <= 0 || c.ProbeTimeout <= 0 {
+			return fmt.Errorf("probe count and probe timeout should be positive")
+		}
+	}
+
+	if c.TunnelHistorySize < 0 || c.FlapThreshold < 0 {
+		return fmt.Errorf("tunnel history size and flap threshold can not be negative")
+	}
+
+	for key := range c.ViciSockets {
+		if peerType := apis.EndpointType(key); peerType != apis.EdgeNode && peerType != apis.Connector {
+			return fmt.Errorf("invalid peer type of vici socket: %s", key)
+		}
+	}
+
+	if _, err := c.taskPeriods(); err != nil {
+		return err
+	}
+	if c.SyncRetryBackoff <= 0 || c.SyncMaxRetryBackoff < c.SyncRetryBackoff {
+		return fmt.Errorf("sync retry backoff should be positive and not larger than max sync retry backoff")
+	}
+
+	// cloud agents route to connector the way they route to the first prefix, which comes from CNI
+	sources := sets.NewString(c.LocalPrefixes...)
+	if !sources.Has(LocalPrefixesCNI) {
+		return fmt.Errorf("local prefixes must include %s", LocalPrefixesCNI)
+	}
+	if invalid := sources.Difference(sets.NewString(LocalPrefixesCNI, LocalPrefixesConfig, LocalPrefixesAPIServer)); invalid.Len() > 0 {
+		return fmt.Errorf("invalid sources of local prefixes: %s", invalid.List())
+	}
+
+	return nil
 }
 
 func (c Config) Manager() (*Manager, error) {
-	tm, err := strongswan.New(
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	staticDSCPRules, err := parseDSCPRules(c.DSCPRules)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := strongswan.Options{
 		strongswan.SocketFile(c.ViciSocket),
 		strongswan.StartAction("none"),
-	)
+		strongswan.SubnetsPerChildSA(c.SubnetsPerChildSA),
+	}
+	if c.FIPSMode {
+		fips.Enable()
+
+		certPEM, err := ioutil.ReadFile(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = fips.ValidateCertsPEM(certPEM); err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, strongswan.Proposals(fips.IKEProposals, fips.ESPProposals))
+	}
+
+	tm, err := newTunnelManager(opts, c.ViciSockets)
 	if err != nil {
 		return nil, err
 	}
@@ -77,139 +295,306 @@ func (c Config) Manager() (*Manager, error) {
 		return nil, err
 	}
 
-	mc, err := memberlist.New(c.initMembers, msgHandler, nodeLeveHandler)
-	if err != nil {
-		return nil, err
+	var (
+		mc          *memberlist.Client
+		publisher   *prefixesPublisher
+		broadcaster *prefixesBroadcaster
+	)
+	switch c.Discovery {
+	case DiscoveryMemberlist:
+		broadcaster = newPrefixesBroadcaster(c.PrefixesResendInterval)
+		c.Memberlist.Role = memberlist.RoleConnector
+		c.Memberlist.MsgHandler = broadcaster.handleMessage
+		c.Memberlist.EventHandler = memberEventHandler
+		if mc, err = memberlist.New(c.Memberlist); err != nil {
+			return nil, err
+		}
+		broadcaster.mc = mc
+	case DiscoveryConfigMap:
+		if publisher, err = newPrefixesPublisher(c.Namespace, c.PrefixesConfigMap); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid discovery: %s", c.Discovery)
 	}
 
-	return &Manager{
-		Config: c,
-		tm:     tm,
-		ipt:    ipt,
-		ipset:  ipset.New(),
-		router: router,
-		mc:     mc,
-	}, nil
-}
+	var source *configSource
+	if c.ConfigName != "" {
+		if source, err = newConfigSource(c.Namespace, c.ConfigName); err != nil {
+			return nil, err
+		}
+	}
+
+	var history *tunnel.History
+	if c.TunnelHistorySize > 0 {
+		history = tunnel.NewHistory(c.TunnelHistorySize)
+	}
 
-func runTasks(interval time.Duration, handler ...func()) {
-	t := time.Tick(interval)
-	for {
-		for _, h := range handler {
-			h()
+	var lock resourcelock.Interface
+	if c.HA.Enabled {
+		if lock, err = newLeaseLock(c.Namespace, c.HA.LeaseName); err != nil {
+			return nil, err
 		}
-		<-t
 	}
+
+	var cloudCIDRs *cloudCIDRDiscoverer
+	if sets.NewString(c.LocalPrefixes...).Has(LocalPrefixesAPIServer) {
+		nodeutil.SetEdgeNodeLabels(c.EdgeLabels)
+		if cloudCIDRs, err = newCloudCIDRDiscoverer(c.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		Config:          c,
+		tm:              tm,
+		ipt:             ipt,
+		ipset:           ipset.New(),
+		router:          router,
+		mc:              mc,
+		publisher:       publisher,
+		broadcaster:     broadcaster,
+		configSource:    source,
+		tunnelHistory:   history,
+		leaseLock:       lock,
+		cloudCIDRs:      cloudCIDRs,
+		staticDSCPRules: staticDSCPRules,
+		accountingRules: make(map[string][]string),
+		names:           newNames(c.NamePrefix),
+	}, nil
 }
 
 func (m *Manager) Start() {
-	routeTaskFn := func() {
+	routeTaskFn := func() error {
+		// routes task runs whenever an SA goes up or down, so it's where tunnel states are counted
+		defer m.recordTunnelStates()
+
 		active, err := m.tm.IsActive()
 		if err != nil {
 			klog.Errorf("failed to get tunnel manager status: %s", err)
-			return
+			return err
 		}
-		if active {
+		// a standby instance may still have SAs established before it lost leadership
+		routed := sets.NewString()
+		if active && m.isActive() {
 			if err = m.router.SyncRoutes(m.connections); err != nil {
 				klog.Errorf("failed to sync routes: %s", err)
-				return
+				return err
+			}
+			for _, c := range m.connections {
+				routed.Insert(c.RemoteSubnets...)
 			}
 		} else {
 			if err = m.router.CleanRoutes(m.connections); err != nil {
 				klog.Errorf("failed to clean routes: %s", err)
-				return
+				return err
 			}
 		}
+		m.clearConntrack(&m.routeConntrack, routed, "routes")
 
 		klog.Info("routes are synced")
+		return nil
 	}
 
-	iptablesTaskFn := func() {
+	iptablesTaskFn := func() error {
+		var errs []error
+
 		if err := m.ensureForwardIPTablesRules(); err != nil {
 			klog.Errorf("error when to add iptables forward rules: %s", err)
+			errs = append(errs, err)
 		} else {
 			klog.Infof("iptables forward rules are added")
 		}
 
 		if err := m.ensureNatIPTablesRules(); err != nil {
 			klog.Errorf("error when to add iptables nat rules: %s", err)
+			errs = append(errs, err)
 		} else {
 			klog.Infof("iptables nat rules are added")
 		}
 
 		if err := m.ensureInputIPTablesRules(); err != nil {
 			klog.Errorf("error when to add iptables input rules: %s", err)
+			errs = append(errs, err)
 		} else {
 			klog.Infof("iptables input rules are added")
 		}
+
+		if err := m.ensureDSCPIPTablesRules(); err != nil {
+			klog.Errorf("error when to add iptables DSCP rules: %s", err)
+			errs = append(errs, err)
+		} else {
+			klog.Infof("iptables DSCP rules are added")
+		}
+
+		if m.TrafficAccounting {
+			if err := m.ensureAccountingIPTablesRules(); err != nil {
+				klog.Errorf("error when to add iptables accounting rules: %s", err)
+				errs = append(errs, err)
+			} else {
+				klog.Infof("iptables accounting rules are added")
+			}
+		}
+
+		return utilerrors.NewAggregate(errs)
 	}
 
 	// Connector broadcasts the active routing info to all cloud agents.
+	// A standby instance keeps silent, otherwise cloud agents route traffic to it.
 	broadcastToAgents := func() {
+		if !m.isActive() {
+			return
+		}
+
 		cp, err := m.router.GetConnectorPrefixes()
 		if err != nil {
 			klog.Errorf("failed to get connector prefixes:%s", err)
 			return
 		}
+		cp.LocalPrefixes = m.localPrefixes(cp.LocalPrefixes)
 		klog.V(5).Infof("get connector prefixes:%+v", cp)
 
 		if len(cp.RemotePrefixes) < 1 || len(cp.LocalPrefixes) < 1 {
 			return
 		}
 
-		b, err := json.Marshal(cp)
-		if err != nil {
-			klog.Errorf("failed to marshal prefixes:%s", err)
+		if m.publisher != nil {
+			if err = m.publisher.publish(cp); err != nil {
+				klog.Errorf("failed to publish prefixes:%s", err)
+			}
+			return
 		}
 
-		m.mc.Broadcast(b)
+		m.broadcaster.broadcast(cp)
 	}
 
-	tunnelTaskFn := func() {
-		if err := m.syncConnections(); err != nil {
+	tunnelTaskFn := func() error {
+		err := m.syncConnections()
+		if err != nil {
 			klog.Errorf("error when to sync tunnels: %s", err)
 		} else {
 			broadcastToAgents()
 			klog.Infof("tunnels are synced")
 		}
+
+		// only the active instance reports, status of standby instances is not interesting
+		if m.configSource != nil && m.isActive() {
+			m.configSource.report(len(m.connections), err)
+		}
+
+		return err
 	}
 
-	ipsetTaskFn := func() {
+	ipsetTaskFn := func() error {
+		var errs []error
+
 		if err := m.syncEdgeNodeCIDRSet(); err != nil {
-			klog.Errorf("error when to sync ipset %s: %s", IPSetEdgeNodeCIDR, err)
+			klog.Errorf("error when to sync ipset %s: %s", m.names.IPSetEdgeNodeCIDR, err)
+			errs = append(errs, err)
 		} else {
-			klog.Infof("ipset %s are synced", IPSetEdgeNodeCIDR)
+			klog.Infof("ipset %s are synced", m.names.IPSetEdgeNodeCIDR)
 		}
 
 		if err := m.syncCloudPodCIDRSet(); err != nil {
-			klog.Errorf("error when to sync ipset %s: %s", IPSetCloudPodCIDR, err)
+			klog.Errorf("error when to sync ipset %s: %s", m.names.IPSetCloudPodCIDR, err)
+			errs = append(errs, err)
 		} else {
-			klog.Infof("ipset %s are synced", IPSetCloudPodCIDR)
+			klog.Infof("ipset %s are synced", m.names.IPSetCloudPodCIDR)
 		}
 
 		if err := m.syncCloudNodeCIDRSet(); err != nil {
-			klog.Errorf("error when to sync ipset %s: %s", IPSetCloudNodeCIDR, err)
+			klog.Errorf("error when to sync ipset %s: %s", m.names.IPSetCloudNodeCIDR, err)
+			errs = append(errs, err)
 		} else {
-			klog.Infof("ipset %s are synced", IPSetCloudNodeCIDR)
+			klog.Infof("ipset %s are synced", m.names.IPSetCloudNodeCIDR)
 		}
 
 		if err := m.syncEdgePodCIDRSet(); err != nil {
-			klog.Errorf("error when to sync ipset %s: %s", IPSetEdgePodCIDR, err)
+			klog.Errorf("error when to sync ipset %s: %s", m.names.IPSetEdgePodCIDR, err)
+			errs = append(errs, err)
 		} else {
-			klog.Infof("ipset %s are synced", IPSetEdgePodCIDR)
+			klog.Infof("ipset %s are synced", m.names.IPSetEdgePodCIDR)
 		}
+
+		// SNAT rules match edge subnets by ipsets
+		if len(errs) == 0 {
+			m.clearConntrack(&m.snatConntrack, m.getAllEdgeNodeCIDRs().Union(m.getAllEdgePodCIDRs()), "SNAT rules")
+		}
+
+		return utilerrors.NewAggregate(errs)
 	}
-	tasks := []func(){tunnelTaskFn, routeTaskFn, ipsetTaskFn, iptablesTaskFn}
+	// periods are validated when manager is created
+	periods, _ := m.taskPeriods()
+	syncBackoff := backoff{initial: m.SyncRetryBackoff, max: m.SyncMaxRetryBackoff, jitter: 0.2}
+	m.syncer = newSyncer(m.DebounceDuration, periods, syncBackoff, map[syncTask]func() error{
+		taskTunnels:  tunnelTaskFn,
+		taskRoutes:   routeTaskFn,
+		taskIPSets:   ipsetTaskFn,
+		taskIPTables: iptablesTaskFn,
+	})
+	// tunnels and routes can't be synced when strongswan is unreachable, they back off until it's back
+	m.syncer.gate(taskTunnels|taskRoutes, m.checkHealth)
 
 	if err := m.clearFabedgeIptablesChains(); err != nil {
 		klog.Errorf("failed to clean iptables: %s", err)
 	}
 
-	// repeats regular tasks periodically
-	go runTasks(m.SyncPeriod, tasks...)
+	// sync ALL when config is changed
+	onConfigChange := func() {
+		m.syncer.trigger(allTasks, "config change")
+	}
+	if m.configSource != nil {
+		// config must be in cache before tasks run
+		if err := m.configSource.start(context.Background(), m.DebounceDuration, onConfigChange); err != nil {
+			klog.Fatalf("failed to watch connector config: %s", err)
+		}
+	} else {
+		go m.onConfigFileChange(m.TunnelConfigFile, onConfigChange)
+	}
+
+	// prefixes are broadcast after tunnels are synced, so cloud agents get discovered CIDRs
+	if m.cloudCIDRs != nil {
+		m.cloudCIDRs.start(m.DebounceDuration, func() {
+			m.syncer.trigger(taskTunnels, "cloud CIDRs change")
+		})
+	}
 
-	// sync ALL when config file changed
-	go m.onConfigFileChange(m.TunnelConfigFile, tasks...)
+	// credentials are loaded without restarting strongswan when TLS secret is changed
+	go m.onConfigFileChange(m.CertFile, func() {
+		m.syncer.trigger(taskTunnels, "certificate change")
+	})
+
+	// tasks are run when things they depend on are changed, and each of them is run every its period
+	go m.syncer.run()
+	if m.configSource != nil && m.ProbeInterval > 0 {
+		go m.probePeers()
+	}
+	go m.watchTunnelEvents()
+	if m.tunnelHistory != nil {
+		go m.reportTunnelHistory()
+	}
+	go m.watchRouteEvents()
+	go m.watchLinkEvents()
+	if m.broadcaster != nil {
+		var ctx context.Context
+		ctx, m.stopBroadcast = context.WithCancel(context.Background())
+		go m.broadcaster.run(ctx, m.isActive)
+	}
+
+	m.recordActive()
+	if m.MetricsBindAddress != "0" {
+		go m.serveMetrics()
+	}
+	if m.AdminSocket != "" {
+		go m.serveAdmin()
+	}
+
+	if m.leaseLock != nil {
+		var ctx context.Context
+		ctx, m.stopElection = context.WithCancel(context.Background())
+		m.electionDone = make(chan struct{})
+		go m.runLeaderElection(ctx)
+	}
 
 	about.DisplayVersion()
 	klog.Info("manager started")
@@ -224,6 +609,33 @@ func (m *Manager) Start() {
 }
 
 func (m *Manager) gracefulShutdown() {
+	// release leadership at once, so a standby instance takes over without waiting for lease expiration
+	if m.stopElection != nil {
+		m.stopElection()
+		select {
+		case <-m.electionDone:
+		case <-time.After(m.HA.RenewDeadline):
+			klog.Errorf("timeout to release leadership")
+		}
+	}
+
+	if m.stopBroadcast != nil {
+		m.stopBroadcast()
+	}
+	m.withdrawPrefixes()
+
+	// a standby instance has nothing to drain, its tunnels are unloaded when it stops leading
+	if m.DrainTimeout > 0 && m.isActive() {
+		m.drain()
+	}
+
+	// tasks would bring back what is cleaned below
+	m.syncer.stop()
+
+	if m.TerminateTunnelsOnShutdown && m.isActive() {
+		m.terminateTunnels()
+	}
+
 	err := m.router.CleanRoutes(m.connections)
 	if err != nil {
 		klog.Errorf("failed to clean routers: %s", err)
@@ -234,3 +646,54 @@ func (m *Manager) gracefulShutdown() {
 		klog.Errorf("failed to clean iptables: %s", err)
 	}
 }
+
+// withdrawPrefixes removes prefixes published in configmap, gossiped prefixes are forgotten
+// by cloud agents when connector leaves memberlist
+func (m *Manager) withdrawPrefixes() {
+	if m.publisher == nil {
+		return
+	}
+
+	if err := m.publisher.withdraw(); err != nil {
+		klog.Errorf("failed to withdraw prefixes: %s", err)
+	}
+}
+
+// clearConntrack deletes conntrack entries of subnets which are changed since last time, existing
+// flows keep their NAT and state, they would still go the old way after routes or SNAT rules are changed
+func (m *Manager) clearConntrack(cleaner *conntrack.Cleaner, subnets sets.String, reason string) {
+	if !m.ClearConntrack {
+		return
+	}
+
+	changed, deleted, err := cleaner.Update(subnets)
+	if err != nil {
+		klog.Errorf("failed to delete conntrack entries of %v: %s", changed, err)
+		return
+	}
+	if len(changed) > 0 {
+		klog.V(3).Infof("%d conntrack entries of %v are deleted because %s are changed", deleted, changed, reason)
+	}
+}
+
+// newTunnelManager makes a tunnel manager of the strongswan instance at ViciSocket, or a manager
+// which spreads connections among strongswan instances if sockets are provided for some peer types
+func newTunnelManager(opts strongswan.Options, sockets map[string]string) (tunnel.Manager, error) {
+	defaultManager, err := strongswan.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	managers := make(map[apis.EndpointType]tunnel.Manager)
+	for key, socket := range sockets {
+		peerType := apis.EndpointType(key)
+		// the later option takes precedence
+		manager, err := strongswan.New(append(opts[:len(opts):len(opts)], strongswan.SocketFile(socket))...)
+		if err != nil {
+			return nil, err
+		}
+		managers[peerType] = manager
+	}
+
+	return tunnel.NewPartitionedManager(defaultManager, managers), nil
+}
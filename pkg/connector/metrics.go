@@ -0,0 +1,194 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/util/memberlist"
+)
+
+const (
+	tunnelStateEstablished = "established"
+	tunnelStateDown        = "down"
+)
+
+var (
+	Tunnels = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "tunnels",
+		Help:      "Number of tunnels loaded by connector, partitioned by type of peer and state",
+	}, []string{"type", "state"})
+
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "sync_duration_seconds",
+		Help:      "Time taken by sync tasks, partitioned by task: tunnels, routes, ipsets or iptables",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"task"})
+
+	SyncErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "sync_errors_total",
+		Help:      "Number of failed sync tasks, partitioned by task: tunnels, routes, ipsets or iptables",
+	}, []string{"task"})
+
+	LastFullSyncTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "last_full_sync_timestamp_seconds",
+		Help:      "Unix time when a sync task ran and every task had succeeded in its last run",
+	})
+
+	SyncConsecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "sync_consecutive_failures",
+		Help:      "Number of continuous failures of sync tasks, partitioned by task, a failed task is retried with exponential backoff",
+	}, []string{"task"})
+
+	Active = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "active",
+		Help:      "Whether this instance is active, it's always 1 if active/standby mode is disabled",
+	})
+
+	PrefixesSeq = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "prefixes_seq",
+		Help:      "Seq of prefixes broadcast to cloud agents last time",
+	})
+
+	AgentPrefixesLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "agent_prefixes_lag",
+		Help:      "How many seqs of prefixes a cloud agent is behind, partitioned by cloud agent",
+	}, []string{"agent"})
+
+	PrefixesResendsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "connector",
+		Name:      "prefixes_resends_total",
+		Help:      "Number of times full prefixes are resent to cloud agents which don't catch up",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(Tunnels, SyncDuration, SyncErrorsTotal, LastFullSyncTimestamp, SyncConsecutiveFailures, Active,
+		PrefixesSeq, AgentPrefixesLag, PrefixesResendsTotal)
+}
+
+// membersCollector counts members of memberlist by role when metrics are scraped
+type membersCollector struct {
+	mc   *memberlist.Client
+	desc *prometheus.Desc
+}
+
+func newMembersCollector(mc *memberlist.Client) *membersCollector {
+	return &membersCollector{
+		mc: mc,
+		desc: prometheus.NewDesc("fabedge_connector_memberlist_members",
+			"Number of alive members in memberlist, including connector itself, partitioned by role",
+			[]string{"role"}, nil),
+	}
+}
+
+func (c *membersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *membersCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{
+		memberlist.RoleConnector:  0,
+		memberlist.RoleCloudAgent: 0,
+	}
+	for _, node := range c.mc.ListMembers() {
+		counts[memberlist.GetNodeMeta(node).Role]++
+	}
+
+	for role, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), role)
+	}
+}
+
+// recordTunnelStates counts loaded connections by peer type and whether any child SA is established
+func (m *Manager) recordTunnelStates() {
+	Tunnels.Reset()
+	for _, c := range m.connections {
+		state := tunnelStateDown
+		if established, err := m.tm.IsConnEstablished(c.Name); err != nil {
+			klog.V(3).Infof("failed to get state of connection %s: %s", c.Name, err)
+		} else if established {
+			state = tunnelStateEstablished
+		}
+
+		Tunnels.WithLabelValues(string(c.RemoteType), state).Inc()
+	}
+}
+
+func (m *Manager) recordActive() {
+	if m.isActive() {
+		Active.Set(1)
+	} else {
+		Active.Set(0)
+	}
+}
+
+// serveMetrics serves /metrics and /healthz, connector is healthy if strongswan can be reached.
+// Tunnel events are served at /tunnel-events if tunnel history is enabled
+func (m *Manager) serveMetrics() {
+	if m.mc != nil {
+		prometheus.MustRegister(newMembersCollector(m.mc))
+	}
+	if m.TrafficAccounting {
+		prometheus.MustRegister(newTrafficCollector(m.ipt, m.names.ChainAccounting))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if m.tunnelHistory != nil {
+		mux.Handle("/tunnel-events", m.tunnelHistory)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := m.checkHealth(); err != nil {
+			http.Error(w, fmt.Sprintf("strongswan is unreachable: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{
+		Addr:         m.MetricsBindAddress,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	klog.Infof("serve metrics on %s", m.MetricsBindAddress)
+	if err := server.ListenAndServe(); err != nil {
+		klog.Errorf("failed to serve metrics: %s", err)
+	}
+}
@@ -1,16 +1,64 @@
 package connector
 
 import (
-	"github.com/spf13/pflag"
 	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/fabedge/fabedge/pkg/util/fips"
 )
 
 func (c *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&c.TunnelConfigFile, "tunnel-config", "/etc/fabedge/tunnels.yaml", "tunnel config file")
+	fs.StringVar(&c.ConfigName, "connector-config", "", "The name of ConnectorConfig made by operator, if provided, tunnels config is got through API instead of tunnel-config file and the result of applying it is reported in its status")
+	fs.StringVar(&c.Namespace, "namespace", "fabedge", "The namespace of ConnectorConfig and the lease of leader election")
+	fs.DurationVar(&c.ProbeInterval, "probe-interval", 0, "how often peers are pinged through tunnels by their node addresses, round-trip time and loss are reported in status of ConnectorConfig, so connector-config is required. 0 means it's disabled")
+	fs.IntVar(&c.ProbeCount, "probe-count", 5, "the number of pings sent to each peer in a probe")
+	fs.DurationVar(&c.ProbeTimeout, "probe-timeout", time.Second, "how long to wait for the reply of a ping, a ping not replied in time is lost")
+	fs.IntVar(&c.TunnelHistorySize, "tunnel-history-size", 50, "the max number of up, down and rekey events of tunnels kept for each peer, they are served at /tunnel-events of metrics server and summarized in status of ConnectorConfig if connector-config is provided. 0 means events are not recorded")
+	fs.IntVar(&c.FlapThreshold, "flap-threshold", 5, "a tunnel which goes down more than this many times in an hour is flapping, connector sets condition Flapping of its ConnectorConfig")
 	fs.StringVar(&c.CertFile, "cert-file", "/etc/ipsec.d/certs/tls.crt", "TLS certificate file")
+	fs.StringVar(&c.KeyFile, "key-file", "/etc/ipsec.d/private/tls.key", "TLS key file, it's loaded into strongswan with CA certificates when the certificate is changed")
+	fs.StringVar(&c.CACertFile, "ca-cert-file", "/etc/ipsec.d/cacerts/ca-bundle.crt", "CA certificates file, it's loaded into strongswan with the key when the certificate is changed")
+	fs.StringVar(&c.CRLFile, "crl-file", "", "CRL file in PEM, peers whose certificates are revoked can't establish tunnels")
 	fs.StringVar(&c.ViciSocket, "vici-socket", "/var/run/charon.vici", "vici socket file")
 	fs.StringVar(&c.CNIType, "cni-type", "flannel", "CNI type used in cloud")
-	fs.DurationVar(&c.SyncPeriod, "sync-period", 5*time.Minute, "period to sync routes/rules")
-	fs.DurationVar(&c.DebounceDuration, "debounce-duration", 5*time.Second, "period to sync routes/rules")
-	fs.StringSliceVar(&c.initMembers, "connector-node-addresses", []string{}, "internal address of all connector nodes")
+	fs.DurationVar(&c.SyncPeriod, "sync-period", 30*time.Minute, "period to sync everything as a safety net, tunnels, routes, ipsets and iptables rules are synced when config, tunnels, routes or links are changed")
+	fs.StringToStringVar(&c.TaskSyncPeriods, "task-sync-periods", nil, "periods of some sync tasks instead of sync-period, e.g. routes=5m,iptables=10m. Tasks are tunnels, routes, ipsets and iptables")
+	fs.DurationVar(&c.SyncRetryBackoff, "sync-retry-backoff", time.Second, "how long to wait before retrying a failed sync task, it's doubled on each continuous failure")
+	fs.DurationVar(&c.SyncMaxRetryBackoff, "sync-max-retry-backoff", 5*time.Minute, "the max time to wait before retrying a failed sync task")
+	fs.DurationVar(&c.DebounceDuration, "debounce-duration", 5*time.Second, "how long to wait after a change before syncing, changes in this duration are synced together")
+	fs.StringSliceVar(&c.Memberlist.InitMembers, "connector-node-addresses", []string{}, "internal address of all connector nodes")
+	fs.IntVar(&c.SubnetsPerChildSA, "subnets-per-child-sa", 0, "max number of subnets in traffic selectors of a child SA, 0 means no splitting")
+	fs.BoolVar(&c.FIPSMode, "fips-mode", fips.BuildEnabled(), "restrict IPsec proposals and certificates to FIPS approved algorithms, it's always on if connector is built with tag fips")
+	fs.BoolVar(&c.HA.Enabled, "ha", false, "run in active/standby mode, instances elect a leader through a lease, only the leader holds VIP and establishes tunnels")
+	fs.StringVar(&c.HA.LeaseName, "ha-lease-name", "fabedge-connector", "The name of lease used by leader election")
+	fs.DurationVar(&c.HA.LeaseDuration, "ha-lease-duration", 6*time.Second, "how long a standby instance waits before taking over leadership which is not renewed")
+	fs.DurationVar(&c.HA.RenewDeadline, "ha-renew-deadline", 4*time.Second, "how long the leader keeps retrying to renew leadership before giving up")
+	fs.DurationVar(&c.HA.RetryPeriod, "ha-retry-period", time.Second, "how long to wait between tries of acquiring or renewing leadership")
+	fs.StringVar(&c.HA.VIP, "ha-vip", "", "virtual IP in CIDR held by the leader, e.g. 10.20.8.100/24, it should be the public address of connector. Leave it empty if VIP is managed by others, e.g. keepalived")
+	fs.StringVar(&c.HA.VIPInterface, "ha-vip-interface", "", "the interface which VIP is added to")
+	fs.DurationVar(&c.HA.HealthCheckInterval, "ha-health-check-interval", 2*time.Second, "interval to check if strongswan is reachable")
+	fs.IntVar(&c.HA.HealthCheckThreshold, "ha-health-check-threshold", 3, "the leader gives up leadership after this many continuous failures of health check")
+	fs.StringVar(&c.HA.StateFile, "ha-state-file", "", "file to write the role of this instance, active or standby, e.g. for track scripts of keepalived")
+	fs.StringVar(&c.MetricsBindAddress, "metrics-bind-address", "0", "The address on which /metrics and /healthz are served, e.g. :30306. 0 means they are not served")
+	fs.StringVar(&c.SNATMode, "snat-mode", SNATModeMasquerade, "masquerade or none. masquerade translates sources of traffic from edge nodes to cloud pods and from edge pods to cloud nodes, none keeps all sources, then cloud nodes need routes back to edge")
+	fs.StringSliceVar(&c.SNATDestinations, "snat-destinations", nil, "CIDRs, if provided, only traffic from edge to them is SNATed in masquerade mode, traffic to other destinations keeps its source")
+	fs.StringVar(&c.SNATSource, "snat-source", "", "IPv4 address which sources are translated to in masquerade mode, empty means the address of outgoing interface")
+	fs.StringToStringVar(&c.DSCPRules, "dscp-rules", nil, "DSCP set on traffic to edge through tunnels, CIDRs are separated by semicolon, e.g. 46=10.10.0.0/16;10.11.0.0/16,10=10.12.0.0/16. They take precedence over DSCP of communities")
+	fs.BoolVar(&c.TrafficAccounting, "traffic-accounting", false, "count bytes and packets forwarded to and from subnets of each peer by iptables rules, they are exposed as metrics")
+	fs.StringVar(&c.AdminSocket, "admin-socket", defaultAdminSocket, "unix socket which \"connector dump\" gets state from, empty means it's not served")
+	fs.StringVar(&c.Discovery, "discovery", DiscoveryMemberlist, "how prefixes are sent to cloud agents, memberlist or configmap. configmap is for clusters where memberlist ports are blocked, cloud agents must use the same one")
+	fs.StringVar(&c.PrefixesConfigMap, "prefixes-configmap", "fabedge-connector-prefixes", "The name of configmap in namespace where prefixes are published in configmap mode")
+	fs.DurationVar(&c.PrefixesResendInterval, "prefixes-resend-interval", 10*time.Second, "how long to wait for cloud agents to acknowledge prefixes broadcast by memberlist before sending full prefixes to them again")
+	fs.BoolVar(&c.ClearConntrack, "clear-conntrack", true, "delete conntrack entries of subnets whose routes or SNAT rules are changed, so existing flows don't go the old way")
+	fs.StringToStringVar(&c.ViciSockets, "vici-sockets", nil, "vici sockets of other strongswan instances by peer type, e.g. Connector=/var/run/sites/charon.vici, connections to peers of these types are loaded by those instances, others by vici-socket. Types are EdgeNode and Connector")
+	fs.StringVar(&c.NamePrefix, "name-prefix", DefaultNamePrefix, "prefix of names of iptables chains and ipsets, connectors of different fabedge instances on one host should use different ones")
+	fs.DurationVar(&c.DrainTimeout, "drain-timeout", 0, "when connector stops, how long to wait for flows of edge subnets to finish after cloud agents stop routing to it, before routes and SNAT rules are removed. 0 means not waiting")
+	fs.BoolVar(&c.TerminateTunnelsOnShutdown, "terminate-tunnels-on-shutdown", false, "delete SAs when connector stops, so peers know tunnels are closed at once instead of finding it by DPD")
+	fs.BoolVar(&c.Check, "check", false, "validate arguments and tunnels config, print connections, routes, ipsets and iptables rules which would be made, then exit. It exits with 1 if any problem is found")
+	fs.BoolVar(&c.Check, "dry-run", false, "the same as check")
+	fs.StringSliceVar(&c.LocalPrefixes, "local-prefixes", []string{LocalPrefixesCNI}, "sources of local prefixes sent to cloud agents: cni, config and apiserver. cni are found from routes made by CNI on connector node, config are subnets of connector in tunnels config, apiserver are pod CIDRs of cloud nodes and service CIDR found through kube-apiserver. cni is required")
+	fs.StringToStringVar(&c.EdgeLabels, "edge-labels", map[string]string{"node-role.kubernetes.io/edge": ""}, "Labels to filter edge nodes, pod CIDRs of other nodes are discovered in apiserver mode of local-prefixes, e.g. key2=,key3=value3")
+	c.Memberlist.AddFlags(fs)
 }
@@ -0,0 +1,234 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bep/debounce"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+)
+
+const (
+	// LocalPrefixesCNI are prefixes found from routes and addresses made by CNI on connector node
+	LocalPrefixesCNI = "cni"
+	// LocalPrefixesConfig are subnets of connector in tunnels config, e.g. connector-subnets of operator
+	LocalPrefixesConfig = "config"
+	// LocalPrefixesAPIServer are pod CIDRs of cloud nodes and the service CIDR found through kube-apiserver
+	LocalPrefixesAPIServer = "apiserver"
+
+	// serviceCIDRDetectPeriod is how often the service CIDR is detected, it may be expanded by admins
+	serviceCIDRDetectPeriod = 10 * time.Minute
+	// probeClusterIP is hardly in any service CIDR, kube-apiserver tells the valid range when it's refused
+	probeClusterIP = "1.1.1.1"
+)
+
+var serviceCIDRRegexp = regexp.MustCompile(`The range of valid IPs is ([0-9a-fA-F.:/]+)`)
+
+// localPrefixes merges local prefixes of sources in LocalPrefixes without duplicates. Prefixes
+// from CNI come first, because cloud agents route to connector the way they route to the first one
+func (m *Manager) localPrefixes(cniPrefixes []string) []string {
+	sources := sets.NewString(m.LocalPrefixes...)
+
+	var prefixes []string
+	added := sets.NewString()
+	add := func(list ...string) {
+		for _, prefix := range list {
+			if prefix == "" || added.Has(prefix) {
+				continue
+			}
+			added.Insert(prefix)
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	if sources.Has(LocalPrefixesCNI) {
+		add(cniPrefixes...)
+	}
+	if sources.Has(LocalPrefixesConfig) {
+		add(m.getAllCloudPodCIDRs().List()...)
+	}
+	if sources.Has(LocalPrefixesAPIServer) && m.cloudCIDRs != nil {
+		add(m.cloudCIDRs.list()...)
+	}
+
+	return prefixes
+}
+
+// cloudCIDRDiscoverer finds pod CIDRs of cloud nodes by watching nodes and the service CIDR by
+// a dry run of creating a service with an invalid cluster IP
+type cloudCIDRDiscoverer struct {
+	namespace string
+	clientset kubernetes.Interface
+	nodes     cache.Store
+
+	mux         sync.Mutex
+	podCIDRs    sets.String
+	serviceCIDR string
+}
+
+func newCloudCIDRDiscoverer(namespace string) (*cloudCIDRDiscoverer, error) {
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudCIDRDiscoverer{
+		namespace: namespace,
+		clientset: clientset,
+		podCIDRs:  sets.NewString(),
+	}, nil
+}
+
+// list returns discovered CIDRs, the service CIDR comes last
+func (d *cloudCIDRDiscoverer) list() []string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	cidrs := d.podCIDRs.List()
+	if d.serviceCIDR != "" {
+		cidrs = append(cidrs, d.serviceCIDR)
+	}
+	return cidrs
+}
+
+// start watches nodes and detects the service CIDR periodically, onChange is called when
+// discovered CIDRs are changed, changes in debounceDuration are merged
+func (d *cloudCIDRDiscoverer) start(debounceDuration time.Duration, onChange func()) {
+	debounced := debounce.New(debounceDuration)
+	notify := func() {
+		debounced(onChange)
+	}
+
+	lw := cache.NewListWatchFromClient(d.clientset.CoreV1().RESTClient(), "nodes", metav1.NamespaceAll, fields.Everything())
+	var controller cache.Controller
+	d.nodes, controller = cache.NewInformer(lw, &corev1.Node{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			d.updatePodCIDRs(notify)
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			d.updatePodCIDRs(notify)
+		},
+		DeleteFunc: func(interface{}) {
+			d.updatePodCIDRs(notify)
+		},
+	})
+	go controller.Run(make(chan struct{}))
+
+	go wait.Forever(func() {
+		d.updateServiceCIDR(notify)
+	}, serviceCIDRDetectPeriod)
+
+	klog.Info("discover pod CIDRs of cloud nodes and service CIDR through kube-apiserver")
+}
+
+// updatePodCIDRs collects pod CIDRs of all nodes except edge nodes in cache
+func (d *cloudCIDRDiscoverer) updatePodCIDRs(notify func()) {
+	podCIDRs := sets.NewString()
+	for _, obj := range d.nodes.List() {
+		node, ok := obj.(*corev1.Node)
+		if !ok || nodeutil.IsEdgeNode(*node) {
+			continue
+		}
+		podCIDRs.Insert(nodeutil.GetPodCIDRs(*node)...)
+	}
+
+	d.mux.Lock()
+	changed := !d.podCIDRs.Equal(podCIDRs)
+	d.podCIDRs = podCIDRs
+	d.mux.Unlock()
+
+	if changed {
+		klog.V(3).Infof("pod CIDRs of cloud nodes are changed: %s", podCIDRs.List())
+		notify()
+	}
+}
+
+func (d *cloudCIDRDiscoverer) updateServiceCIDR(notify func()) {
+	serviceCIDR, err := d.detectServiceCIDR()
+	if err != nil {
+		klog.Errorf("failed to detect service CIDR: %s", err)
+		return
+	}
+
+	d.mux.Lock()
+	changed := d.serviceCIDR != serviceCIDR
+	d.serviceCIDR = serviceCIDR
+	d.mux.Unlock()
+
+	if changed {
+		klog.V(3).Infof("service CIDR is changed: %s", serviceCIDR)
+		notify()
+	}
+}
+
+// detectServiceCIDR creates a service with probeClusterIP in dry run mode, kube-apiserver refuses it
+// and tells the range of valid IPs in the error. Nothing is created
+func (d *cloudCIDRDiscoverer) detectServiceCIDR() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "fabedge-service-cidr-probe",
+			Namespace: d.namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: probeClusterIP,
+			Ports: []corev1.ServicePort{
+				{Port: 443},
+			},
+		},
+	}
+	_, err := d.clientset.CoreV1().Services(d.namespace).Create(ctx, svc, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err == nil {
+		return "", fmt.Errorf("%s is in service CIDR", probeClusterIP)
+	}
+
+	return parseServiceCIDR(err.Error())
+}
+
+func parseServiceCIDR(message string) (string, error) {
+	match := serviceCIDRRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return "", fmt.Errorf("unexpected error: %s", message)
+	}
+
+	_, cidr, err := net.ParseCIDR(match[1])
+	if err != nil {
+		return "", err
+	}
+	return cidr.String(), nil
+}
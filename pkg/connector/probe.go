@@ -0,0 +1,58 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/fabedge/fabedge/pkg/util/probe"
+)
+
+// probePeers pings peers through tunnels every ProbeInterval and reports round-trip time and
+// loss in status of connector config. Only the active instance probes, standby instances have no tunnels
+func (m *Manager) probePeers() {
+	tick := time.NewTicker(m.ProbeInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		if !m.isActive() {
+			continue
+		}
+
+		var targets []probe.Target
+		m.syncer.exclusive(func() {
+			for _, conn := range m.connections {
+				if target, ok := probe.TargetOf(conn.Name, conn.RemoteNodeSubnets); ok {
+					targets = append(targets, target)
+				}
+			}
+		})
+
+		results := probe.ProbeAll(targets, m.ProbeCount, m.ProbeTimeout)
+		for _, r := range results {
+			if r.Err != nil {
+				klog.Errorf("failed to ping peer %s(%s): %s", r.Name, r.Address, r.Err)
+			} else {
+				klog.V(5).Infof("peer %s is probed, rtt: %s, loss: %d%%", r.Name, r.RTT, r.Loss())
+			}
+		}
+
+		if err := m.configSource.reportProbes(probe.ToPeerProbes(results, time.Now())); err != nil {
+			klog.Errorf("failed to report probes of peers: %s", err)
+		}
+	}
+}
@@ -24,10 +24,37 @@ import (
 	"strings"
 )
 
+const (
+	// MessageFull carries all prefixes of a connector
+	MessageFull = "full"
+	// MessageDelta carries changes of remote prefixes since BaseSeq
+	MessageDelta = "delta"
+	// MessageAck is sent by cloud agents to tell which seq they have
+	MessageAck = "ack"
+)
+
 type ConnectorPrefixes struct {
 	NodeName       string   `json:"name"`
 	LocalPrefixes  []string `json:"local-prefixes"`
 	RemotePrefixes []string `json:"remote-prefixes"`
+
+	// Kind is full or delta, it's empty in messages from old connectors, which are always full.
+	// Seq is increased whenever prefixes are changed, it starts from 1 when connector starts
+	Kind string `json:"kind,omitempty"`
+	Seq  uint64 `json:"seq,omitempty"`
+	// BaseSeq, AddedPrefixes and RemovedPrefixes are only for delta, a delta can only be applied to
+	// prefixes of BaseSeq. RemotePrefixes of delta is empty, so old cloud agents ignore it
+	BaseSeq         uint64   `json:"base-seq,omitempty"`
+	AddedPrefixes   []string `json:"added-prefixes,omitempty"`
+	RemovedPrefixes []string `json:"removed-prefixes,omitempty"`
+}
+
+// PrefixesAck is sent by a cloud agent to a connector after a message of it is handled,
+// Seq is the seq of prefixes the cloud agent has, it may be less than seq of the message
+type PrefixesAck struct {
+	Kind     string `json:"kind"`
+	NodeName string `json:"name"`
+	Seq      uint64 `json:"seq"`
 }
 
 type Routing interface {
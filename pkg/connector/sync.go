@@ -0,0 +1,328 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// syncTask is a kind of work which syncs a part of connector's state
+type syncTask int
+
+const (
+	taskTunnels syncTask = 1 << iota
+	taskRoutes
+	taskIPSets
+	taskIPTables
+
+	allTasks = taskTunnels | taskRoutes | taskIPSets | taskIPTables
+)
+
+var taskNames = map[syncTask]string{
+	taskTunnels:  "tunnels",
+	taskRoutes:   "routes",
+	taskIPSets:   "ipsets",
+	taskIPTables: "iptables",
+}
+
+// syncer runs tasks when they are triggered, triggers within debounceDuration are merged and
+// tasks are run one by one in a single goroutine, because they share connections. Each task is
+// triggered every its own period too, as a safety net for changes which come without events.
+// A failed task is retried with exponential backoff, it's not run again before the backoff
+// passes even if it's triggered, so a persistent failure doesn't make a busy loop
+type syncer struct {
+	handlers         map[syncTask]func() error
+	debounceDuration time.Duration
+	backoff          backoff
+	// healthCheck is called before gated tasks run, they are taken as failed without running if it fails
+	healthCheck func() error
+	gated       syncTask
+
+	mux     sync.Mutex
+	pending syncTask
+	states  map[syncTask]*taskState
+	wakeup  chan struct{}
+
+	// running is held while tasks run, so others can read what tasks write when they are not running
+	running sync.Mutex
+	// stopped is set when connector is shutting down, tasks are not run any more
+	stopped bool
+}
+
+// backoff is how long to wait before retrying a failed task, it's doubled on each failure up to max
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  float64
+}
+
+// duration returns the jittered backoff after failures continuous failures
+func (b backoff) duration(failures int) time.Duration {
+	d := b.initial
+	for i := 1; i < failures && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return wait.Jitter(d, b.jitter)
+}
+
+type taskState struct {
+	period time.Duration
+	// next is when the task is run periodically next time, zero means at once
+	next time.Time
+	// failures is the number of continuous failures, the task is not run before retryAt if it's positive
+	failures int
+	retryAt  time.Time
+	// succeeded is true if the task succeeded last time it ran
+	succeeded bool
+}
+
+func newSyncer(debounceDuration time.Duration, periods map[syncTask]time.Duration, b backoff, handlers map[syncTask]func() error) *syncer {
+	states := make(map[syncTask]*taskState, len(handlers))
+	for task := range handlers {
+		states[task] = &taskState{period: periods[task]}
+	}
+
+	return &syncer{
+		handlers:         handlers,
+		debounceDuration: debounceDuration,
+		backoff:          b,
+		states:           states,
+		wakeup:           make(chan struct{}, 1),
+	}
+}
+
+// gate makes tasks wait until healthCheck passes, e.g. tasks which can't succeed when strongswan is unreachable
+func (s *syncer) gate(tasks syncTask, healthCheck func() error) {
+	s.gated = tasks
+	s.healthCheck = healthCheck
+}
+
+// trigger marks tasks as pending, it never blocks
+func (s *syncer) trigger(tasks syncTask, reason string) {
+	klog.V(5).Infof("sync is triggered by %s", reason)
+
+	s.mux.Lock()
+	s.pending |= tasks
+	s.mux.Unlock()
+
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// run runs all tasks at once, then runs pending tasks whenever they are triggered, tasks
+// whose periods are due and failed tasks whose backoff passed
+func (s *syncer) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.wakeup:
+			// wait a while, so a burst of events makes only one run
+			time.Sleep(s.debounceDuration)
+		case <-timer.C:
+		}
+
+		s.runTasks(s.dueTasks())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.untilNextRun())
+	}
+}
+
+// dueTasks takes pending tasks which are not backing off, tasks whose periods are due become pending first
+func (s *syncer) dueTasks() syncTask {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	var tasks syncTask
+	for task, state := range s.states {
+		if !now.Before(state.next) {
+			s.pending |= task
+		}
+		if s.pending&task != 0 && !now.Before(state.retryAt) {
+			tasks |= task
+		}
+	}
+	s.pending &^= tasks
+
+	return tasks
+}
+
+// untilNextRun returns how long to wait until the next task is due, or a pending task stops backing off
+func (s *syncer) untilNextRun() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	next := time.Time{}
+	for task, state := range s.states {
+		at := state.next
+		if s.pending&task != 0 && state.retryAt.Before(at) {
+			at = state.retryAt
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	return time.Until(next)
+}
+
+// done records the result of a task, a failed one is pending again and retried after backoff
+func (s *syncer) done(task syncTask, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	state := s.states[task]
+	state.next = now.Add(state.period)
+	state.succeeded = err == nil
+
+	if err == nil {
+		state.failures = 0
+		state.retryAt = time.Time{}
+	} else {
+		state.failures++
+		delay := s.backoff.duration(state.failures)
+		state.retryAt = now.Add(delay)
+		s.pending |= task
+		klog.V(3).Infof("%s failed %d times, retry in %s", taskNames[task], state.failures, delay)
+	}
+	SyncConsecutiveFailures.WithLabelValues(taskNames[task]).Set(float64(state.failures))
+}
+
+// allSucceeded returns true if every task succeeded last time it ran
+func (s *syncer) allSucceeded() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, state := range s.states {
+		if !state.succeeded {
+			return false
+		}
+	}
+	return true
+}
+
+// exclusive calls fn when no task is running, tasks wait until fn returns
+func (s *syncer) exclusive(fn func()) {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	fn()
+}
+
+// stop waits for running tasks and makes tasks not run any more
+func (s *syncer) stop() {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	s.stopped = true
+}
+
+func (s *syncer) runTasks(tasks syncTask) {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	if s.stopped || tasks == 0 {
+		return
+	}
+
+	var unhealthy error
+	if tasks&s.gated != 0 && s.healthCheck != nil {
+		if unhealthy = s.healthCheck(); unhealthy != nil {
+			klog.Errorf("health check failed, tasks which depend on it are not run: %s", unhealthy)
+		}
+	}
+
+	// tunnels are synced first, other tasks depend on connections loaded by it
+	for _, task := range []syncTask{taskTunnels, taskRoutes, taskIPSets, taskIPTables} {
+		if tasks&task == 0 {
+			continue
+		}
+
+		if unhealthy != nil && s.gated&task != 0 {
+			SyncErrorsTotal.WithLabelValues(taskNames[task]).Inc()
+			s.done(task, unhealthy)
+			continue
+		}
+
+		start := time.Now()
+		err := s.handlers[task]()
+		duration := time.Since(start)
+
+		SyncDuration.WithLabelValues(taskNames[task]).Observe(duration.Seconds())
+		if err != nil {
+			SyncErrorsTotal.WithLabelValues(taskNames[task]).Inc()
+		}
+		s.done(task, err)
+		klog.V(5).Infof("%s are synced in %s", taskNames[task], duration)
+	}
+
+	if s.allSucceeded() {
+		LastFullSyncTimestamp.SetToCurrentTime()
+	}
+}
+
+// taskPeriods returns the period of each task, it's SyncPeriod unless it's set in TaskSyncPeriods
+func (c Config) taskPeriods() (map[syncTask]time.Duration, error) {
+	periods := make(map[syncTask]time.Duration, len(taskNames))
+	for task := range taskNames {
+		periods[task] = c.SyncPeriod
+	}
+
+	for name, value := range c.TaskSyncPeriods {
+		task, found := syncTask(0), false
+		for t, n := range taskNames {
+			if n == name {
+				task, found = t, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid sync task: %s", name)
+		}
+
+		period, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync period of %s: %w", name, err)
+		}
+		periods[task] = period
+	}
+
+	for task, period := range periods {
+		if period <= 0 {
+			return nil, fmt.Errorf("sync period of %s should be positive", taskNames[task])
+		}
+	}
+
+	return periods, nil
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/common/netconf"
 	"github.com/fabedge/fabedge/pkg/tunnel"
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -27,13 +28,29 @@ var (
 	connNames sets.String
 )
 
-func (m *Manager) readCfgFromFile() error {
-	nc, err := netconf.LoadNetworkConf(m.TunnelConfigFile)
+// loadNetworkConf gets network conf from ConnectorConfig if connector config is provided, otherwise from tunnel config file
+func (m *Manager) loadNetworkConf() (netconf.NetworkConf, error) {
+	if m.configSource != nil {
+		return m.configSource.load()
+	}
+
+	return netconf.LoadNetworkConf(m.TunnelConfigFile)
+}
+
+func (m *Manager) readCfg() error {
+	nc, err := m.loadNetworkConf()
 	if err != nil {
 		return err
 	}
 
+	m.setConnections(nc)
+	return nil
+}
+
+// setConnections makes connections to peers in nc
+func (m *Manager) setConnections(nc netconf.NetworkConf) {
 	m.connections = nil
+	m.dscpRules = nc.DSCPRules
 	connNames = sets.NewString()
 
 	for _, peer := range nc.Peers {
@@ -44,7 +61,7 @@ func (m *Manager) readCfgFromFile() error {
 			LocalID:          nc.ID,
 			LocalCerts:       []string{m.CertFile},
 			LocalAddress:     nc.PublicAddresses,
-			LocalSubnets:     nc.Subnets,
+			LocalSubnets:     append(append([]string{}, nc.Subnets...), relayedSubnets(nc.Peers, peer)...),
 			LocalNodeSubnets: nc.NodeSubnets,
 			LocalType:        nc.Type,
 
@@ -57,17 +74,26 @@ func (m *Manager) readCfgFromFile() error {
 		m.connections = append(m.connections, con)
 		connNames.Insert(con.Name)
 	}
-
-	return nil
 }
 
 func (m *Manager) syncConnections() error {
-	if err := m.readCfgFromFile(); err != nil {
+	if err := m.readCfg(); err != nil {
 		return err
 	}
 
 	klog.V(5).Infof("connections:%+v", m.connections)
 
+	if m.CRLFile != "" {
+		if err := tunnel.LoadCRLFile(m.tm, m.CRLFile); err != nil {
+			klog.Errorf("failed to load CRL: %s", err)
+		}
+	}
+
+	certsChanged, err := m.syncCredentials()
+	if err != nil {
+		klog.Errorf("failed to load credentials: %s", err)
+	}
+
 	oldNames, err := m.tm.ListConnNames()
 	if err != nil {
 		return err
@@ -79,6 +105,7 @@ func (m *Manager) syncConnections() error {
 			if err = m.tm.UnloadConn(name); err != nil {
 				return err
 			}
+			klog.InfoS("connection is unloaded", logutil.KeyEndpoint, name)
 		}
 	}
 
@@ -88,21 +115,62 @@ func (m *Manager) syncConnections() error {
 		case v1alpha1.EdgeNode:
 			c.LocalAddress = nil  // we do not care local ip address
 			c.RemoteAddress = nil // we just wait the connection from remote edge nodes
-			if err = m.tm.LoadConn(c); err != nil {
-				klog.Errorf("failed to load connection:%s", err)
+			if err = m.loadConn(c, certsChanged); err != nil {
+				klog.ErrorS(err, "failed to load connection", logutil.KeyEndpoint, c.Name)
 			}
 		case v1alpha1.Connector:
-			c.LocalAddress = nil // we do not care local ip address
-			if err = m.tm.LoadConn(c); err != nil {
-				klog.Errorf("failed to load connection:%s", err)
+			c.LocalAddress = m.localAddresses()
+			if err = m.loadConn(c, certsChanged); err != nil {
+				klog.ErrorS(err, "failed to load connection", logutil.KeyEndpoint, c.Name)
+			}
+			// a standby instance keeps connections loaded, they are initiated once it becomes active
+			if !m.isActive() {
+				continue
 			}
 			if err = m.tm.InitiateConn(c.Name); err != nil {
-				klog.Errorf("failed to initiate connection:%s", err)
+				klog.ErrorS(err, "failed to initiate connection", logutil.KeyEndpoint, c.Name)
 			}
 		default:
-			klog.Errorf("connection type:%s is not implemented", c.RemoteType)
+			klog.ErrorS(nil, "connection type is not implemented", logutil.KeyEndpoint, c.Name, "type", c.RemoteType)
 		}
 	}
 
 	return nil
 }
+
+// loadConn loads c, it's loaded again if reload is true, because an unchanged connection is not
+// loaded by LoadConn, even if local certificates are changed
+func (m *Manager) loadConn(c tunnel.ConnConfig, reload bool) error {
+	if err := m.tm.LoadConn(c); err != nil {
+		return err
+	}
+
+	if reload {
+		return m.tm.ReloadConn(c)
+	}
+
+	return nil
+}
+
+// relayedSubnets returns subnets of edge peers whose traffic to peer is relayed by connector, they are put
+// in local subnets of the tunnel to peer. If peer is relay-only, they are subnets of all other edge peers,
+// otherwise subnets of relay-only edge peers. IKE narrows them to those which the agent of peer proposes,
+// so only subnets of its community members are relayed
+func relayedSubnets(peers []v1alpha1.Endpoint, peer v1alpha1.Endpoint) []string {
+	if peer.Type != v1alpha1.EdgeNode {
+		return nil
+	}
+
+	var subnets []string
+	for _, ep := range peers {
+		if ep.Name == peer.Name || ep.Type != v1alpha1.EdgeNode {
+			continue
+		}
+
+		if peer.RelayOnly || ep.RelayOnly {
+			subnets = append(subnets, ep.Subnets...)
+		}
+	}
+
+	return subnets
+}
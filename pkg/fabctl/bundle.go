@@ -0,0 +1,435 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/about"
+)
+
+const redacted = "<redacted>"
+
+var (
+	privateKeyReg = regexp.MustCompile(`(?s)(-----BEGIN [A-Z ]*PRIVATE KEY-----).*?(-----END [A-Z ]*PRIVATE KEY-----)`)
+	// secretValueReg matches values of keys like token, password, secret or psk in yaml, json,
+	// flags and logs, keys of names and paths of secrets are excluded in redact
+	secretValueReg = regexp.MustCompile(`(?i)("?[\w.-]*(?:token|password|passwd|secret|psk)[\w.-]*"?[ \t]*[:=][ \t]*)("[^"]*"|[^\s,]+)`)
+	bearerReg      = regexp.MustCompile(`(?i)(bearer\s+)[\w.~+/-]+=*`)
+	jwtReg         = regexp.MustCompile(`eyJ[\w-]+\.eyJ[\w-]+\.[\w-]*`)
+	secretRefReg   = regexp.MustCompile(`(?i)(name|file|path|dir|ref)"?[ \t]*[:=][ \t]*$`)
+)
+
+type BundleOptions struct {
+	Nodes       []string
+	Since       time.Duration
+	Output      string
+	Concurrency int
+}
+
+func (opts *BundleOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringSliceVar(&opts.Nodes, "nodes", nil, "The edge nodes whose agents are collected, comma separated. Empty means all agents")
+	fs.DurationVar(&opts.Since, "since", time.Hour, "Collect logs newer than this duration")
+	fs.StringVarP(&opts.Output, "output", "o", "", "The archive to write, default is fabedge-bundle-<time>.tar.gz in current directory")
+	fs.IntVar(&opts.Concurrency, "concurrency", 5, "How many pods are collected concurrently")
+}
+
+func (opts *BundleOptions) Validate() error {
+	if opts.Since <= 0 {
+		return fmt.Errorf("since must be positive")
+	}
+
+	if opts.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+
+	return nil
+}
+
+func newBundleCommand(globalOptions *GlobalOptions) *cobra.Command {
+	var bundleOptions = &BundleOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Collect diagnostics into an archive for support cases",
+		Long:  "Collect logs, versions, rendered configs, iptables, ipset and route dumps and swanctl output from operator, connectors and agents, with fabedge resources, into a tar.gz archive. Private keys, tokens, passwords and other secrets are redacted, secrets are never collected",
+		Example: `# Collect diagnostics of connectors and agents on edge1 and edge2
+fabctl bundle --nodes=edge1,edge2
+
+# Collect logs of last 10 minutes of all agents
+fabctl bundle --since=10m -o /tmp/bundle.tar.gz
+`,
+		PreRunE: doValidations(bundleOptions.Validate),
+		Run: func(cmd *cobra.Command, args []string) {
+			b := &bundler{
+				BundleOptions: *bundleOptions,
+				global:        globalOptions,
+				client:        createKubeClient(),
+			}
+
+			now := time.Now()
+			output := bundleOptions.Output
+			if output == "" {
+				output = fmt.Sprintf("fabedge-bundle-%s.tar.gz", now.Format("20060102-150405"))
+			}
+
+			files := b.collect(context.Background())
+
+			file, err := os.Create(output)
+			if err != nil {
+				exit("failed to create archive: %s", err)
+			}
+			defer file.Close()
+
+			if err = writeBundle(file, strings.TrimSuffix(path.Base(output), ".tar.gz"), files, now); err != nil {
+				exit("failed to write archive: %s", err)
+			}
+
+			fmt.Printf("diagnostics of %d pods are written to %s\n", b.pods, output)
+		},
+	}
+	bundleOptions.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// bundleFile is a file in the bundle, its content is redacted when it's written
+type bundleFile struct {
+	Name    string
+	Content string
+}
+
+// bundleCommand is a command run in a container of a pod, its output is put in File
+type bundleCommand struct {
+	Container string
+	File      string
+	Command   []string
+}
+
+func networkCommands(container string) []bundleCommand {
+	return []bundleCommand{
+		{container, "iptables.txt", []string{"iptables-save"}},
+		{container, "ip6tables.txt", []string{"ip6tables-save"}},
+		{container, "ipset.txt", []string{"ipset", "list"}},
+		{container, "routes.txt", []string{"ip", "route", "show", "table", "all"}},
+		{container, "rules.txt", []string{"ip", "rule"}},
+		{container, "addresses.txt", []string{"ip", "address"}},
+	}
+}
+
+// strongswanCommands list SAs and connections, `ip xfrm state` is not run because it shows keys of SAs
+func strongswanCommands() []bundleCommand {
+	return []bundleCommand{
+		{containerStrongswan, "swanctl-version.txt", []string{"swanctl", "--version"}},
+		{containerStrongswan, "swanctl-sas.txt", []string{"swanctl", "--list-sas"}},
+		{containerStrongswan, "swanctl-conns.txt", []string{"swanctl", "--list-conns"}},
+		{containerStrongswan, "swanctl-certs.txt", []string{"swanctl", "--list-certs"}},
+	}
+}
+
+func componentCommands(container string) []bundleCommand {
+	switch container {
+	case containerAgent:
+		commands := []bundleCommand{
+			{containerAgent, "version.txt", []string{"fabedge-agent", "--version"}},
+			{containerAgent, "tunnels.yaml", []string{"cat", "/etc/fabedge/tunnels.yaml"}},
+			{containerAgent, "services.yaml", []string{"cat", "/etc/fabedge/services.yaml"}},
+		}
+		commands = append(commands, networkCommands(containerAgent)...)
+		return append(commands, strongswanCommands()...)
+	case containerConnector:
+		commands := []bundleCommand{
+			{containerConnector, "version.txt", []string{"connector", "--version"}},
+			{containerConnector, "tunnels.yaml", []string{"cat", "/etc/fabedge/tunnels.yaml"}},
+			// it fails if admin socket of connector is disabled
+			{containerConnector, "dump.json", []string{"connector", "dump"}},
+		}
+		commands = append(commands, networkCommands(containerConnector)...)
+		return append(commands, strongswanCommands()...)
+	default:
+		return []bundleCommand{
+			{containerOperator, "version.txt", []string{"fabedge-operator", "--version"}},
+		}
+	}
+}
+
+type bundler struct {
+	BundleOptions
+	global *GlobalOptions
+	client *kubeClient
+
+	mux   sync.Mutex
+	files []bundleFile
+	// errors are failures of collecting, they are put in errors.txt, the rest is still useful
+	errors []string
+	pods   int
+}
+
+func (b *bundler) add(name, content string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.files = append(b.files, bundleFile{Name: name, Content: content})
+}
+
+func (b *bundler) addError(format string, args ...interface{}) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+func (b *bundler) addJSON(name string, obj interface{}) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		b.addError("failed to marshal %s: %s", name, err)
+		return
+	}
+	b.add(name, string(data))
+}
+
+// collect gathers files of the bundle, failures are recorded in errors.txt instead of stopping collecting
+func (b *bundler) collect(ctx context.Context) []bundleFile {
+	b.add("fabctl-version.txt", fmt.Sprintf("Version: %s\n", about.Version()))
+	if info, err := b.client.Discovery().ServerVersion(); err != nil {
+		b.addError("failed to get version of kubernetes: %s", err)
+	} else {
+		b.add("kubernetes-version.txt", info.String()+"\n")
+	}
+
+	b.collectResources(ctx)
+
+	type component struct {
+		pod       corev1.Pod
+		container string
+	}
+	var components []component
+	addPods := func(pods []corev1.Pod, err error, container string) {
+		if err != nil {
+			b.addError("failed to list %s pods: %s", container, err)
+			return
+		}
+		for _, pod := range pods {
+			components = append(components, component{pod, container})
+		}
+	}
+
+	pods, err := b.client.listOperatorPods(ctx, b.global.Namespace)
+	addPods(pods, err, containerOperator)
+	pods, err = b.client.listPods(ctx, b.global.Namespace, b.global.ConnectorLabels)
+	addPods(pods, err, containerConnector)
+	pods, err = b.client.listAgentPods(ctx, b.global.Namespace)
+	addPods(filterPodsByNodes(pods, b.Nodes), err, containerAgent)
+
+	b.pods = len(components)
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, b.Concurrency)
+	for _, c := range components {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(c component) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			b.collectPod(ctx, c.pod, c.container)
+		}(c)
+	}
+	wg.Wait()
+
+	if len(b.errors) > 0 {
+		b.add("errors.txt", strings.Join(b.errors, "\n")+"\n")
+	}
+
+	sort.Slice(b.files, func(i, j int) bool {
+		return b.files[i].Name < b.files[j].Name
+	})
+	return b.files
+}
+
+// collectResources gathers fabedge resources and nodes of selected agents, secrets are never collected
+func (b *bundler) collectResources(ctx context.Context) {
+	lists := map[string]client.ObjectList{
+		"clusters.json":         &apis.ClusterList{},
+		"communities.json":      &apis.CommunityList{},
+		"agentconfigs.json":     &apis.AgentConfigList{},
+		"connectorconfigs.json": &apis.ConnectorConfigList{},
+	}
+	for name, list := range lists {
+		err := b.client.List(ctx, list, client.InNamespace(b.global.Namespace))
+		switch {
+		case meta.IsNoMatchError(err):
+			// the CRD is not installed
+		case err != nil:
+			b.addError("failed to list %s: %s", strings.TrimSuffix(name, ".json"), err)
+		default:
+			b.addJSON(path.Join("resources", name), list)
+		}
+	}
+
+	configMaps, err := b.client.CoreV1().ConfigMaps(b.global.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.addError("failed to list configmaps: %s", err)
+	} else {
+		b.addJSON("resources/configmaps.json", configMaps)
+	}
+
+	pods, err := b.client.CoreV1().Pods(b.global.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.addError("failed to list pods: %s", err)
+	} else {
+		b.addJSON("resources/pods.json", pods)
+	}
+
+	for _, nodeName := range b.Nodes {
+		node, err := b.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			b.addError("failed to get node %s: %s", nodeName, err)
+			continue
+		}
+		b.addJSON(path.Join("resources", "nodes", nodeName+".json"), node)
+	}
+}
+
+// collectPod gathers logs and outputs of commands of a pod into the directory named after the pod
+func (b *bundler) collectPod(ctx context.Context, pod corev1.Pod, container string) {
+	dir := path.Join(container, pod.Name)
+	if pod.Spec.NodeName != "" {
+		b.add(path.Join(dir, "node.txt"), pod.Spec.NodeName+"\n")
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		logs, err := b.client.logs(ctx, pod, status.Name, int64(b.Since.Seconds()))
+		if err != nil {
+			b.addError("failed to get logs of %s/%s: %s", pod.Name, status.Name, err)
+		} else {
+			b.add(path.Join(dir, status.Name+".log"), logs)
+		}
+
+		// logs before the last restart usually tell why it crashed
+		if status.RestartCount > 0 {
+			if logs, err = b.client.previousLogs(ctx, pod, status.Name); err == nil {
+				b.add(path.Join(dir, status.Name+"-previous.log"), logs)
+			}
+		}
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		b.addError("pod %s is %s, commands are not run", pod.Name, pod.Status.Phase)
+		return
+	}
+
+	containers := sets.NewString()
+	for _, c := range pod.Spec.Containers {
+		containers.Insert(c.Name)
+	}
+
+	for _, c := range componentCommands(container) {
+		if !containers.Has(c.Container) {
+			continue
+		}
+
+		output, err := b.client.exec(pod, c.Container, c.Command...)
+		if err != nil {
+			b.addError("failed to run %q in %s/%s: %s", strings.Join(c.Command, " "), pod.Name, c.Container, err)
+			continue
+		}
+		b.add(path.Join(dir, c.File), output)
+	}
+}
+
+func filterPodsByNodes(pods []corev1.Pod, nodes []string) []corev1.Pod {
+	if len(nodes) == 0 {
+		return pods
+	}
+
+	selected := sets.NewString(nodes...)
+	var result []corev1.Pod
+	for _, pod := range pods {
+		if selected.Has(pod.Spec.NodeName) {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
+
+// writeBundle writes files in a tar.gz archive under directory root, contents are redacted
+func writeBundle(w io.Writer, root string, files []bundleFile, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, file := range files {
+		content := []byte(redact(file.Content))
+		header := &tar.Header{
+			Name:    path.Join(root, file.Name),
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// redact replaces private keys, tokens, passwords and other secrets in content with <redacted>.
+// Names and paths of secrets, like secretName or bootstrap-token-file, are kept
+func redact(content string) string {
+	content = privateKeyReg.ReplaceAllString(content, "$1\n"+redacted+"\n$2")
+	content = secretValueReg.ReplaceAllStringFunc(content, func(match string) string {
+		groups := secretValueReg.FindStringSubmatch(match)
+		key, value := groups[1], groups[2]
+		if secretRefReg.MatchString(key) {
+			return match
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			return key + `"` + redacted + `"`
+		}
+		return key + redacted
+	})
+	content = bearerReg.ReplaceAllString(content, "${1}"+redacted)
+	return jwtReg.ReplaceAllString(content, redacted)
+}
@@ -0,0 +1,292 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+type CheckOptions struct {
+	Nodes     []string
+	Protocols []string
+	Port      int32
+	Timeout   time.Duration
+	Wait      time.Duration
+	Keep      bool
+}
+
+func (opts *CheckOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringSliceVar(&opts.Nodes, "nodes", nil, "The nodes to check, edge nodes and cloud nodes are both allowed, e.g. edge1,edge2,master")
+	fs.StringSliceVar(&opts.Protocols, "protocols", []string{"TCP", "UDP", "ICMP"}, "The protocols to test with")
+	fs.Int32Var(&opts.Port, "port", 8790, "The TCP and UDP port which probe pods listen on")
+	fs.DurationVar(&opts.Timeout, "timeout", 3*time.Second, "How long to wait for the reply of a test")
+	fs.DurationVar(&opts.Wait, "wait", 5*time.Minute, "How long to wait for the check to complete")
+	fs.BoolVar(&opts.Keep, "keep", false, "Keep the ConnectivityCheck after it's completed")
+}
+
+func (opts *CheckOptions) Validate() error {
+	if len(opts.Nodes) < 2 || len(opts.Nodes) > 20 {
+		return fmt.Errorf("2 to 20 nodes are required")
+	}
+
+	for _, protocol := range opts.Protocols {
+		switch apis.CheckProtocol(strings.ToUpper(protocol)) {
+		case apis.CheckProtocolTCP, apis.CheckProtocolUDP, apis.CheckProtocolICMP:
+		default:
+			return fmt.Errorf("unknown protocol: %s", protocol)
+		}
+	}
+
+	if opts.Timeout <= 0 || opts.Wait <= 0 {
+		return fmt.Errorf("timeout and wait must be positive")
+	}
+
+	return nil
+}
+
+func newCheckCommand(globalOptions *GlobalOptions) *cobra.Command {
+	var checkOptions = &CheckOptions{}
+
+	connectivityCmd := &cobra.Command{
+		Use:   "connectivity",
+		Short: "Test connectivity between nodes across tunnels",
+		Long:  "Create a ConnectivityCheck which makes operator launch probe pods on nodes and test each pair of them by TCP, UDP and ICMP, then print a pass/fail matrix with diagnostics of failures. Operator must be started with --enable-connectivity-check",
+		Example: `# Check connectivity between two edge nodes and a cloud node
+fabctl check connectivity --nodes=edge1,edge2,master
+
+# Only check by TCP
+fabctl check connectivity --nodes=edge1,master --protocols=tcp
+`,
+		PreRunE: doValidations(checkOptions.Validate),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli := createKubeClient()
+			ctx := context.Background()
+
+			check, err := runCheck(ctx, cli, checkOptions)
+			if err != nil {
+				exit("%s", err)
+			}
+
+			// diagnostics are best effort, fabric may not be loaded, e.g. no permission
+			fabric, err := loadFabric(ctx, cli, globalOptions.Namespace)
+			if err != nil {
+				fmt.Printf("diagnostics are not available: %s\n\n", err)
+			}
+
+			if failed := printCheck(os.Stdout, check, fabric); failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	checkOptions.AddFlags(connectivityCmd.Flags())
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the fabric",
+	}
+	cmd.AddCommand(connectivityCmd)
+
+	return cmd
+}
+
+// runCheck creates a ConnectivityCheck and waits for it to complete
+func runCheck(ctx context.Context, cli *kubeClient, opts *CheckOptions) (apis.ConnectivityCheck, error) {
+	check := apis.ConnectivityCheck{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "fabctl-",
+		},
+		Spec: apis.ConnectivityCheckSpec{
+			Nodes:   opts.Nodes,
+			Port:    opts.Port,
+			Timeout: &metav1.Duration{Duration: opts.Timeout},
+		},
+	}
+	for _, protocol := range opts.Protocols {
+		check.Spec.Protocols = append(check.Spec.Protocols, apis.CheckProtocol(strings.ToUpper(protocol)))
+	}
+
+	if err := cli.Create(ctx, &check); err != nil {
+		return check, fmt.Errorf("failed to create connectivity check: %s", err)
+	}
+	fmt.Printf("connectivity check %s is created, wait for it to complete\n\n", check.Name)
+
+	if !opts.Keep {
+		defer func() {
+			_ = cli.Delete(context.Background(), &check)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Wait)
+	defer cancel()
+
+	tick := time.NewTicker(2 * time.Second)
+	defer tick.Stop()
+
+	key := client.ObjectKey{Name: check.Name}
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return check, fmt.Errorf("connectivity check %s is not completed in %s, is operator started with --enable-connectivity-check?", check.Name, opts.Wait)
+		}
+
+		if err := cli.Get(ctx, key, &check); err != nil {
+			return check, fmt.Errorf("failed to get connectivity check: %s", err)
+		}
+
+		if check.Status.Phase == apis.ConnectivityCheckCompleted {
+			return check, nil
+		}
+	}
+}
+
+// printCheck prints a matrix of results whose rows are where tests are from, then failures
+// with diagnostics. It returns the number of failed tests
+func printCheck(w io.Writer, check apis.ConnectivityCheck, fabric Fabric) int {
+	type pair struct{ from, to string }
+	failedProtocols := make(map[pair][]string)
+	var failures []apis.CheckResult
+	for _, r := range check.Status.Results {
+		if !r.Succeeded {
+			key := pair{r.From, r.To}
+			failedProtocols[key] = append(failedProtocols[key], string(r.Protocol))
+			failures = append(failures, r)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FROM\\TO\t%s\n", strings.Join(check.Spec.Nodes, "\t"))
+	for _, from := range check.Spec.Nodes {
+		cells := []string{from}
+		for _, to := range check.Spec.Nodes {
+			switch protocols, failed := failedProtocols[pair{from, to}]; {
+			case from == to:
+				cells = append(cells, "-")
+			case failed:
+				cells = append(cells, fmt.Sprintf("FAIL(%s)", strings.Join(protocols, ",")))
+			default:
+				cells = append(cells, "PASS")
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	_ = tw.Flush()
+
+	if len(failures) > 0 {
+		fmt.Fprintln(w, "\nFailures:")
+		diagnosed := make(map[pair]bool)
+		for _, r := range failures {
+			fmt.Fprintf(w, "  %s -> %s %s: %s\n", r.From, r.To, r.Protocol, r.Message)
+
+			key := pair{r.From, r.To}
+			if diagnosed[key] {
+				continue
+			}
+			diagnosed[key] = true
+
+			for _, hint := range diagnose(fabric, r.From, r.To) {
+				fmt.Fprintf(w, "      hint: %s\n", hint)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%s\n", check.Status.Message)
+	return len(failures)
+}
+
+// diagnose tells what may be wrong with the path from a node to another by tunnels
+// configuration and status of their agents. Nodes without endpoints are taken as cloud
+// nodes, whose traffic to edge nodes goes through connector
+func diagnose(fabric Fabric, from, to string) []string {
+	if len(fabric.Endpoints) == 0 {
+		return nil
+	}
+
+	var hints []string
+
+	fromState, fromIsEdge := findEndpointOfNode(fabric, from)
+	toState, toIsEdge := findEndpointOfNode(fabric, to)
+
+	for _, state := range []EndpointState{fromState, toState} {
+		if state.Name != "" && state.Synced != "" && state.Synced != string(metav1.ConditionTrue) {
+			hints = append(hints, fmt.Sprintf("config of %s is not applied by its agent, Synced is %s", state.Name, state.Synced))
+		}
+	}
+
+	switch {
+	case fromIsEdge && toIsEdge:
+		if !hasPeer(fromState, toState.Name) {
+			hints = append(hints, fmt.Sprintf("%s has no tunnel to %s, put them in a community to connect them", fromState.Name, toState.Name))
+		}
+	case fromIsEdge:
+		if !hasPeerOfType(fromState, apis.Connector) {
+			hints = append(hints, fmt.Sprintf("%s has no tunnel to connector", fromState.Name))
+		}
+	case toIsEdge:
+		if !hasPeerOfType(toState, apis.Connector) {
+			hints = append(hints, fmt.Sprintf("%s has no tunnel to connector", toState.Name))
+		}
+	default:
+		hints = append(hints, "both nodes are cloud nodes, their traffic doesn't go through tunnels, check the CNI of the cluster")
+	}
+
+	if fromIsEdge && toIsEdge {
+		if probe, ok := findProbe(fromState.Probes, toState.Name); ok && probe.Loss == 100 {
+			hints = append(hints, fmt.Sprintf("pings from %s to %s through tunnel are all lost, the tunnel may be down, see `fabctl top`", fromState.Name, toState.Name))
+		}
+	}
+
+	return hints
+}
+
+func findEndpointOfNode(fabric Fabric, nodeName string) (EndpointState, bool) {
+	for _, state := range fabric.Endpoints {
+		if state.Node == nodeName {
+			return state, true
+		}
+	}
+	return EndpointState{}, false
+}
+
+func hasPeer(state EndpointState, name string) bool {
+	for _, peer := range state.Peers {
+		if peer.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPeerOfType(state EndpointState, typ apis.EndpointType) bool {
+	for _, peer := range state.Peers {
+		if peer.Type == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+func TestDiagnose(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fabric := newTestFabric()
+
+	g.Expect(diagnose(fabric, "edge1", "edge2")).To(BeEmpty())
+	g.Expect(diagnose(fabric, "master", "edge1")).To(BeEmpty())
+	g.Expect(diagnose(fabric, "master", "node1")).To(ConsistOf(
+		"both nodes are cloud nodes, their traffic doesn't go through tunnels, check the CNI of the cluster",
+	))
+	g.Expect(diagnose(Fabric{}, "edge1", "edge2")).To(BeNil())
+
+	fabric.Endpoints[2].Synced = "False"
+	fabric.Endpoints[2].Peers = nil
+	g.Expect(diagnose(fabric, "edge2", "edge1")).To(ConsistOf(
+		"config of fabedge.edge2 is not applied by its agent, Synced is False",
+		"fabedge.edge2 has no tunnel to fabedge.edge1, put them in a community to connect them",
+	))
+	g.Expect(diagnose(fabric, "master", "edge2")).To(ContainElement("fabedge.edge2 has no tunnel to connector"))
+}
+
+func TestPrintCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	check := apis.ConnectivityCheck{
+		Spec: apis.ConnectivityCheckSpec{
+			Nodes: []string{"edge1", "edge2", "master"},
+		},
+		Status: apis.ConnectivityCheckStatus{
+			Phase:   apis.ConnectivityCheckCompleted,
+			Message: "2 of 6 tests failed",
+			Results: []apis.CheckResult{
+				{From: "edge1", To: "edge2", Protocol: apis.CheckProtocolTCP, Succeeded: true},
+				{From: "edge1", To: "master", Protocol: apis.CheckProtocolTCP, Succeeded: true},
+				{From: "edge2", To: "edge1", Protocol: apis.CheckProtocolTCP, Message: "i/o timeout"},
+				{From: "edge2", To: "edge1", Protocol: apis.CheckProtocolUDP, Message: "i/o timeout"},
+				{From: "edge2", To: "master", Protocol: apis.CheckProtocolTCP, Succeeded: true},
+				{From: "master", To: "edge1", Protocol: apis.CheckProtocolTCP, Succeeded: true},
+			},
+		},
+	}
+
+	fabric := newTestFabric()
+	fabric.Endpoints[2].Peers = fabric.Endpoints[2].Peers[:1]
+
+	var buf bytes.Buffer
+	failed := printCheck(&buf, check, fabric)
+	g.Expect(failed).To(Equal(2))
+
+	lines := strings.Split(buf.String(), "\n")
+	g.Expect(strings.Fields(lines[0])).To(Equal([]string{"FROM\\TO", "edge1", "edge2", "master"}))
+	g.Expect(strings.Fields(lines[1])).To(Equal([]string{"edge1", "-", "PASS", "PASS"}))
+	g.Expect(strings.Fields(lines[2])).To(Equal([]string{"edge2", "FAIL(TCP,UDP)", "-", "PASS"}))
+	g.Expect(strings.Fields(lines[3])).To(Equal([]string{"master", "PASS", "PASS", "-"}))
+
+	output := buf.String()
+	g.Expect(output).To(ContainSubstring("edge2 -> edge1 TCP: i/o timeout"))
+	g.Expect(output).To(ContainSubstring("edge2 -> edge1 UDP: i/o timeout"))
+	g.Expect(strings.Count(output, "hint: fabedge.edge2 has no tunnel to fabedge.edge1")).To(Equal(1))
+	g.Expect(output).To(HaveSuffix("2 of 6 tests failed\n"))
+}
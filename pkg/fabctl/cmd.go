@@ -0,0 +1,88 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fabedge/fabedge/pkg/common/about"
+)
+
+func NewFabctlCommand() *cobra.Command {
+	var globalOptions = &GlobalOptions{}
+
+	versionCmd := &cobra.Command{
+		Use:   "version",
+		Short: "Display version information",
+		Run: func(cmd *cobra.Command, args []string) {
+			about.DisplayVersion()
+		},
+	}
+
+	var rootCmd = &cobra.Command{
+		Use:   commandName(),
+		Short: "A command line tool to inspect and operate fabedge",
+	}
+
+	globalOptions.AddFlags(rootCmd.PersistentFlags())
+
+	rootCmd.AddCommand(
+		newTopCommand(globalOptions),
+		newCommunityCommand(globalOptions),
+		newGetCommand(globalOptions),
+		newDescribeCommand(globalOptions),
+		newCheckCommand(globalOptions),
+		newBundleCommand(globalOptions),
+		newGraphCommand(globalOptions),
+		versionCmd,
+	)
+
+	rootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+
+	return rootCmd
+}
+
+// commandName returns "kubectl fabedge" if fabctl is installed as a kubectl plugin, kubectl
+// finds plugins by executable names like kubectl-fabedge
+func commandName() string {
+	name := filepath.Base(os.Args[0])
+	if strings.HasPrefix(name, "kubectl-") {
+		return "kubectl " + strings.TrimPrefix(name, "kubectl-")
+	}
+	return "fabctl"
+}
+
+func exit(format string, a ...interface{}) {
+	fmt.Printf(format+"\n", a...)
+	os.Exit(1)
+}
+
+func doValidations(validateFns ...func() error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		for _, validate := range validateFns {
+			if err := validate(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
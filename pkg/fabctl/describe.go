@@ -0,0 +1,97 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newDescribeCommand(globalOptions *GlobalOptions) *cobra.Command {
+	endpointCmd := &cobra.Command{
+		Use:   "endpoint ENDPOINT|NODE",
+		Short: "Show details of an agent or connector endpoint",
+		Long:  "Show the endpoint of an agent or connector, the communities it's in, whether it applied its config, and its peers with probe results",
+		Example: `# Describe the endpoint of node edge1
+fabctl describe endpoint edge1
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fabric := mustLoadFabric(globalOptions)
+			if err := describeEndpoint(os.Stdout, fabric, args[0]); err != nil {
+				exit("%s", err)
+			}
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "describe",
+		Short: "Show details of a resource of fabedge",
+	}
+	cmd.AddCommand(endpointCmd)
+
+	return cmd
+}
+
+func describeEndpoint(w io.Writer, fabric Fabric, name string) error {
+	state, ok := fabric.findEndpoint(name)
+	if !ok {
+		return fmt.Errorf("endpoint %s is not found", name)
+	}
+
+	node, synced := state.Node, state.Synced
+	if node == "" {
+		node = "<none>"
+	}
+	if synced == "" {
+		synced = "<unknown>"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Name:\t%s\n", state.Name)
+	fmt.Fprintf(tw, "ID:\t%s\n", state.ID)
+	fmt.Fprintf(tw, "Type:\t%s\n", state.Type)
+	fmt.Fprintf(tw, "Node:\t%s\n", node)
+	fmt.Fprintf(tw, "Public Addresses:\t%s\n", joinOrNone(state.PublicAddresses))
+	fmt.Fprintf(tw, "Subnets:\t%s\n", joinOrNone(state.Subnets))
+	fmt.Fprintf(tw, "Node Subnets:\t%s\n", joinOrNone(state.NodeSubnets))
+	fmt.Fprintf(tw, "Relay Only:\t%t\n", state.RelayOnly)
+	fmt.Fprintf(tw, "Synced:\t%s\n", synced)
+	fmt.Fprintf(tw, "Communities:\t%s\n", joinOrNone(fabric.communitiesOf(state.Name)))
+
+	if len(state.Peers) == 0 {
+		fmt.Fprintln(tw, "Peers:\t<none>")
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "Peers:")
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// peers are in another table, or their columns are aligned with fields above
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tPUBLIC-ADDRESSES\tSUBNETS\tNODE-SUBNETS\tRTT\tLOSS")
+	for _, peer := range state.Peers {
+		rtt, loss := formatProbe(state.Probes, peer.Name)
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\n", peer.Name, joinOrNone(peer.PublicAddresses),
+			joinOrNone(peer.Subnets), joinOrNone(peer.NodeSubnets), rtt, loss)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,235 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const agentConfigMapPrefix = "fabedge-agent-config-"
+
+// EndpointState is an agent or a connector with the tunnels configuration made by operator
+// and the status it reports
+type EndpointState struct {
+	netconf.NetworkConf
+	// Node is the node of an agent, it's empty for connector
+	Node string
+	// Synced is the status of Synced condition, it's empty if the config is a configmap
+	Synced string
+	Probes []apis.PeerProbe
+}
+
+// Fabric is what fabctl knows about the mesh: endpoints sorted by name and communities
+type Fabric struct {
+	Endpoints   []EndpointState
+	Communities []apis.Community
+}
+
+// loadFabric loads tunnels configuration from configmaps, AgentConfigs and ConnectorConfigs,
+// the latter two are preferred since they carry status. Missing CRDs are not errors, they are
+// not installed by older operators
+func loadFabric(ctx context.Context, cli *kubeClient, namespace string) (Fabric, error) {
+	var fabric Fabric
+
+	states := make(map[string]EndpointState)
+
+	configMaps, err := cli.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fabric, fmt.Errorf("failed to list configmaps: %s", err)
+	}
+	for _, cm := range configMaps.Items {
+		data, ok := cm.Data[tunnelsConfigKey]
+		if !ok {
+			continue
+		}
+
+		conf, err := parseNetworkConf(data)
+		if err != nil {
+			return fabric, fmt.Errorf("failed to parse tunnels configuration in configmap %s: %s", cm.Name, err)
+		}
+
+		state := EndpointState{NetworkConf: conf}
+		if strings.HasPrefix(cm.Name, agentConfigMapPrefix) {
+			state.Node = strings.TrimPrefix(cm.Name, agentConfigMapPrefix)
+		}
+		states[conf.Name] = state
+	}
+
+	var agentConfigs apis.AgentConfigList
+	err = cli.List(ctx, &agentConfigs, client.InNamespace(namespace))
+	if err != nil && !meta.IsNoMatchError(err) {
+		return fabric, fmt.Errorf("failed to list agent configs: %s", err)
+	}
+	for _, config := range agentConfigs.Items {
+		conf, err := parseNetworkConf(config.Spec.Tunnels)
+		if err != nil {
+			return fabric, fmt.Errorf("failed to parse tunnels configuration in agent config %s: %s", config.Name, err)
+		}
+		states[conf.Name] = EndpointState{
+			NetworkConf: conf,
+			Node:        config.Name,
+			Synced:      getSyncedStatus(config.Status.Conditions, apis.AgentConfigConditionSynced),
+			Probes:      config.Status.Probes,
+		}
+	}
+
+	var connectorConfigs apis.ConnectorConfigList
+	err = cli.List(ctx, &connectorConfigs, client.InNamespace(namespace))
+	if err != nil && !meta.IsNoMatchError(err) {
+		return fabric, fmt.Errorf("failed to list connector configs: %s", err)
+	}
+	for _, config := range connectorConfigs.Items {
+		conf := netconf.NetworkConf{
+			Endpoint:  config.Spec.Endpoint,
+			Peers:     config.Spec.Peers,
+			DSCPRules: config.Spec.DSCPRules,
+		}
+		states[conf.Name] = EndpointState{
+			NetworkConf: conf,
+			Synced:      getSyncedStatus(config.Status.Conditions, apis.ConnectorConfigConditionSynced),
+			Probes:      config.Status.Probes,
+		}
+	}
+
+	for _, state := range states {
+		fabric.Endpoints = append(fabric.Endpoints, state)
+	}
+	sort.Slice(fabric.Endpoints, func(i, j int) bool {
+		return fabric.Endpoints[i].Name < fabric.Endpoints[j].Name
+	})
+
+	var communities apis.CommunityList
+	if err = cli.List(ctx, &communities); err != nil {
+		return fabric, fmt.Errorf("failed to list communities: %s", err)
+	}
+	fabric.Communities = communities.Items
+	sort.Slice(fabric.Communities, func(i, j int) bool {
+		return fabric.Communities[i].Name < fabric.Communities[j].Name
+	})
+
+	return fabric, nil
+}
+
+func parseNetworkConf(data string) (netconf.NetworkConf, error) {
+	var conf netconf.NetworkConf
+	err := yaml.Unmarshal([]byte(data), &conf)
+	return conf, err
+}
+
+func getSyncedStatus(conditions []metav1.Condition, conditionType string) string {
+	condition := meta.FindStatusCondition(conditions, conditionType)
+	if condition == nil {
+		return string(metav1.ConditionUnknown)
+	}
+	return string(condition.Status)
+}
+
+// confs returns tunnels configuration of all endpoints
+func (f Fabric) confs() []netconf.NetworkConf {
+	confs := make([]netconf.NetworkConf, 0, len(f.Endpoints))
+	for _, state := range f.Endpoints {
+		confs = append(confs, state.NetworkConf)
+	}
+	return confs
+}
+
+// findEndpoint finds an endpoint by its name or the name of its node
+func (f Fabric) findEndpoint(name string) (EndpointState, bool) {
+	for _, state := range f.Endpoints {
+		if state.Name == name {
+			return state, true
+		}
+	}
+
+	for _, state := range f.Endpoints {
+		if state.Node != "" && state.Node == name {
+			return state, true
+		}
+	}
+
+	return EndpointState{}, false
+}
+
+// lookupEndpoint finds an endpoint by name, endpoints which only appear as peers of others,
+// e.g. edge nodes of other clusters, are also found
+func (f Fabric) lookupEndpoint(name string) (apis.Endpoint, bool) {
+	if state, ok := f.findEndpoint(name); ok {
+		return state.Endpoint, true
+	}
+
+	for _, state := range f.Endpoints {
+		for _, peer := range state.Peers {
+			if peer.Name == name {
+				return peer, true
+			}
+		}
+	}
+
+	return apis.Endpoint{}, false
+}
+
+// communitiesOf returns names of communities which the endpoint is in
+func (f Fabric) communitiesOf(name string) []string {
+	var names []string
+	for _, c := range f.Communities {
+		for _, member := range c.Spec.Members {
+			if member == name {
+				names = append(names, c.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+func findProbe(probes []apis.PeerProbe, peer string) (apis.PeerProbe, bool) {
+	for _, probe := range probes {
+		if probe.Peer == peer {
+			return probe, true
+		}
+	}
+	return apis.PeerProbe{}, false
+}
+
+func formatProbe(probes []apis.PeerProbe, peer string) (rtt string, loss string) {
+	probe, ok := findProbe(probes, peer)
+	if !ok {
+		return "-", "-"
+	}
+
+	rtt = "-"
+	if probe.RTT != nil {
+		rtt = probe.RTT.Duration.String()
+	}
+	return rtt, fmt.Sprintf("%d%%", probe.Loss)
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+func newTestFabric() Fabric {
+	connector := apis.Endpoint{Name: "fabedge.connector", Subnets: []string{"10.233.0.0/18"}, Type: apis.Connector}
+	edge1 := apis.Endpoint{Name: "fabedge.edge1", PublicAddresses: []string{"10.40.20.181"}, Subnets: []string{"2.2.1.0/24"}, Type: apis.EdgeNode}
+	edge2 := apis.Endpoint{Name: "fabedge.edge2", PublicAddresses: []string{"10.40.20.182"}, Subnets: []string{"2.2.2.0/24"}, Type: apis.EdgeNode}
+	remote := apis.Endpoint{Name: "beijing.edge1", PublicAddresses: []string{"10.50.20.181"}, Subnets: []string{"3.3.1.0/24"}, Type: apis.EdgeNode}
+
+	return Fabric{
+		Endpoints: []EndpointState{
+			{NetworkConf: netconf.NetworkConf{Endpoint: connector, Peers: []apis.Endpoint{edge1, edge2}}, Synced: "True"},
+			{
+				NetworkConf: netconf.NetworkConf{Endpoint: edge1, Peers: []apis.Endpoint{connector, edge2, remote}},
+				Node:        "edge1",
+				Synced:      "True",
+				Probes: []apis.PeerProbe{
+					{Peer: "fabedge.edge2", RTT: &metav1.Duration{Duration: 3 * time.Millisecond}, Loss: 0},
+					{Peer: "beijing.edge1", Loss: 100},
+				},
+			},
+			{NetworkConf: netconf.NetworkConf{Endpoint: edge2, Peers: []apis.Endpoint{connector, edge1}}, Node: "edge2"},
+		},
+		Communities: []apis.Community{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "edges"},
+				Spec:       apis.CommunitySpec{Members: []string{"fabedge.edge1", "fabedge.edge2", "beijing.edge1", "fabedge.edge3"}},
+			},
+		},
+	}
+}
+
+func TestFindEndpoint(t *testing.T) {
+	g := NewGomegaWithT(t)
+	fabric := newTestFabric()
+
+	state, ok := fabric.findEndpoint("fabedge.edge1")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(state.Node).To(Equal("edge1"))
+
+	state, ok = fabric.findEndpoint("edge2")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(state.Name).To(Equal("fabedge.edge2"))
+
+	_, ok = fabric.findEndpoint("beijing.edge1")
+	g.Expect(ok).To(BeFalse())
+
+	endpoint, ok := fabric.lookupEndpoint("beijing.edge1")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(endpoint.Subnets).To(ConsistOf("3.3.1.0/24"))
+}
+
+func TestPrintTunnels(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var buf bytes.Buffer
+	g.Expect(printTunnels(&buf, newTestFabric(), []string{"edge1"})).To(Succeed())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	g.Expect(lines).To(HaveLen(4))
+	g.Expect(strings.Fields(lines[1])).To(Equal([]string{"fabedge.edge1", "fabedge.connector", "<none>", "10.233.0.0/18", "-", "-"}))
+	g.Expect(strings.Fields(lines[2])).To(Equal([]string{"fabedge.edge1", "fabedge.edge2", "10.40.20.182", "2.2.2.0/24", "3ms", "0%"}))
+	g.Expect(strings.Fields(lines[3])).To(Equal([]string{"fabedge.edge1", "beijing.edge1", "10.50.20.181", "3.3.1.0/24", "-", "100%"}))
+
+	g.Expect(printTunnels(&buf, newTestFabric(), []string{"edge3"})).NotTo(Succeed())
+}
+
+func TestPrintResolvedCommunities(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var buf bytes.Buffer
+	printResolvedCommunities(&buf, newTestFabric())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	g.Expect(lines).To(HaveLen(5))
+	g.Expect(strings.Fields(lines[1])).To(Equal([]string{"edges", "fabedge.edge1", "edge1", "10.40.20.181", "2.2.1.0/24", "True"}))
+	g.Expect(strings.Fields(lines[2])).To(Equal([]string{"edges", "fabedge.edge2", "edge2", "10.40.20.182", "2.2.2.0/24", "-"}))
+	g.Expect(strings.Fields(lines[3])).To(Equal([]string{"edges", "beijing.edge1", "-", "10.50.20.181", "3.3.1.0/24", "-"}))
+	g.Expect(strings.Fields(lines[4])).To(Equal([]string{"edges", "fabedge.edge3", "<not", "found>", "-", "-", "-"}))
+}
+
+func TestDescribeEndpoint(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var buf bytes.Buffer
+	g.Expect(describeEndpoint(&buf, newTestFabric(), "edge1")).To(Succeed())
+
+	output := buf.String()
+	g.Expect(output).To(MatchRegexp(`Node:\s+edge1\n`))
+	g.Expect(output).To(MatchRegexp(`Communities:\s+edges\n`))
+	g.Expect(output).To(MatchRegexp(`fabedge.edge2\s+10.40.20.182\s+2.2.2.0/24\s+<none>\s+3ms\s+0%`))
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+type GetCommunitiesOptions struct {
+	Resolve bool
+}
+
+func (opts *GetCommunitiesOptions) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&opts.Resolve, "resolve", false, "Resolve members of communities to their nodes, addresses and subnets")
+}
+
+func newGetCommand(globalOptions *GlobalOptions) *cobra.Command {
+	tunnelsCmd := &cobra.Command{
+		Use:     "tunnels [ENDPOINT|NODE]...",
+		Aliases: []string{"tunnel"},
+		Short:   "List tunnels of agents and connectors",
+		Long:    "List tunnels of agents and connectors with peer addresses, routes and probe results, only tunnels of specified endpoints or nodes are listed if any",
+		Example: `# List all tunnels
+fabctl get tunnels
+
+# List tunnels of node edge1
+fabctl get tunnels edge1
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fabric := mustLoadFabric(globalOptions)
+			if err := printTunnels(os.Stdout, fabric, args); err != nil {
+				exit("%s", err)
+			}
+		},
+	}
+
+	var communitiesOptions = &GetCommunitiesOptions{}
+	communitiesCmd := &cobra.Command{
+		Use:     "communities",
+		Aliases: []string{"community"},
+		Short:   "List communities",
+		Example: `# List communities with their members resolved
+fabctl get communities --resolve
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fabric := mustLoadFabric(globalOptions)
+			if communitiesOptions.Resolve {
+				printResolvedCommunities(os.Stdout, fabric)
+			} else {
+				printCommunities(os.Stdout, fabric)
+			}
+		},
+	}
+	communitiesOptions.AddFlags(communitiesCmd.Flags())
+
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "Display one or many resources of fabedge",
+	}
+	cmd.AddCommand(tunnelsCmd, communitiesCmd)
+
+	return cmd
+}
+
+func mustLoadFabric(globalOptions *GlobalOptions) Fabric {
+	fabric, err := loadFabric(context.Background(), createKubeClient(), globalOptions.Namespace)
+	if err != nil {
+		exit("%s", err)
+	}
+	return fabric
+}
+
+// printTunnels prints tunnels of endpoints, if names are provided, only tunnels of endpoints
+// whose names or node names are in them are printed
+func printTunnels(w io.Writer, fabric Fabric, names []string) error {
+	states := fabric.Endpoints
+	if len(names) > 0 {
+		states = nil
+		for _, name := range names {
+			state, ok := fabric.findEndpoint(name)
+			if !ok {
+				return fmt.Errorf("endpoint %s is not found", name)
+			}
+			states = append(states, state)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "ENDPOINT\tPEER\tPEER-ADDRESSES\tROUTES\tRTT\tLOSS")
+	for _, state := range states {
+		for _, peer := range state.Peers {
+			routes := append(append([]string{}, peer.Subnets...), peer.NodeSubnets...)
+			rtt, loss := formatProbe(state.Probes, peer.Name)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", state.Name, peer.Name,
+				joinOrNone(peer.PublicAddresses), joinOrNone(routes), rtt, loss)
+		}
+	}
+
+	return nil
+}
+
+func printCommunities(w io.Writer, fabric Fabric) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "NAME\tREADY\tMEMBERS\tDSCP\tEGRESS-BANDWIDTH")
+	for _, c := range fabric.Communities {
+		bandwidth := "<none>"
+		if c.Spec.EgressBandwidth != nil {
+			bandwidth = c.Spec.EgressBandwidth.String()
+		}
+
+		ready := "<unknown>"
+		if condition := meta.FindStatusCondition(c.Status.Conditions, apis.ConditionReady); condition != nil {
+			ready = string(condition.Status)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", c.Name, ready, joinOrNone(c.Spec.Members), c.Spec.DSCP, bandwidth)
+	}
+}
+
+// printResolvedCommunities prints each member of communities with its node, addresses and subnets,
+// members which have no endpoints are marked, tunnels are never built with them
+func printResolvedCommunities(w io.Writer, fabric Fabric) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "COMMUNITY\tMEMBER\tNODE\tPUBLIC-ADDRESSES\tSUBNETS\tSYNCED")
+	for _, c := range fabric.Communities {
+		for _, member := range c.Spec.Members {
+			endpoint, ok := fabric.lookupEndpoint(member)
+			if !ok {
+				fmt.Fprintf(tw, "%s\t%s\t<not found>\t-\t-\t-\n", c.Name, member)
+				continue
+			}
+
+			// members of other clusters have no nodes or status here
+			node, synced := "-", "-"
+			if state, ok := fabric.findEndpoint(member); ok {
+				if state.Node != "" {
+					node = state.Node
+				}
+				if state.Synced != "" {
+					synced = state.Synced
+				}
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, member, node,
+				joinOrNone(endpoint.PublicAddresses), joinOrNone(endpoint.Subnets), synced)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+
+	"github.com/fabedge/fabedge/pkg/util/topology"
+)
+
+type GraphOptions struct {
+	Output string
+}
+
+func (opts *GraphOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringVarP(&opts.Output, "output", "o", topology.FormatDOT, "The format of the graph: dot or json")
+}
+
+func (opts *GraphOptions) Validate() error {
+	if opts.Output != topology.FormatDOT && opts.Output != topology.FormatJSON {
+		return fmt.Errorf("unknown output format: %s", opts.Output)
+	}
+
+	return nil
+}
+
+func newGraphCommand(globalOptions *GlobalOptions) *cobra.Command {
+	var graphOptions = &GraphOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Print the topology of fabric as a graph",
+		Long:  "Print clusters, connectors, edge nodes, communities and states of tunnels as a graph in DOT of Graphviz or in JSON, which can be visualized by the node graph panel of Grafana",
+		Example: `# Render the topology to an SVG file by Graphviz
+fabctl graph | dot -Tsvg -o fabric.svg
+
+# Print the topology in JSON
+fabctl graph -o json
+`,
+		PreRunE: doValidations(graphOptions.Validate),
+		Run: func(cmd *cobra.Command, args []string) {
+			loader := topology.Loader{
+				Namespace:        globalOptions.Namespace,
+				AgentConfigs:     true,
+				ConnectorConfigs: true,
+				Client:           createKubeClient().Client,
+			}
+
+			t, err := loader.Load(context.Background())
+			if err != nil {
+				exit("%s", err)
+			}
+
+			if err = t.Write(os.Stdout, graphOptions.Output); err != nil {
+				exit("%s", err)
+			}
+		},
+	}
+	graphOptions.AddFlags(cmd.Flags())
+
+	return cmd
+}
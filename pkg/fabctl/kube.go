@@ -0,0 +1,146 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+func init() {
+	_ = apis.AddToScheme(scheme.Scheme)
+}
+
+const (
+	containerAgent      = "agent"
+	containerConnector  = "connector"
+	containerOperator   = "operator"
+	containerStrongswan = "strongswan"
+)
+
+// kubeClient wraps clientset with rest config which is needed by exec, and
+// a controller-runtime client to access fabedge resources
+type kubeClient struct {
+	kubernetes.Interface
+	client.Client
+	config *rest.Config
+}
+
+func createKubeClient() *kubeClient {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		exit("not able to initiate kube client config: %s", err)
+	}
+
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		exit("not able to create kube client: %s", err)
+	}
+
+	cli, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		exit("not able to create kube client: %s", err)
+	}
+
+	return &kubeClient{
+		Interface: cs,
+		Client:    cli,
+		config:    cfg,
+	}
+}
+
+func (cli *kubeClient) listPods(ctx context.Context, namespace string, selector map[string]string) ([]corev1.Pod, error) {
+	podList, err := cli.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return podList.Items, nil
+}
+
+func (cli *kubeClient) listAgentPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	return cli.listPods(ctx, namespace, map[string]string{constants.KeyFabedgeAPP: constants.AppAgent})
+}
+
+func (cli *kubeClient) listOperatorPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	return cli.listPods(ctx, namespace, map[string]string{"app": constants.AppOperator})
+}
+
+// exec runs command in the container of pod and returns its stdout
+func (cli *kubeClient) exec(pod corev1.Pod, container string, command ...string) (string, error) {
+	req := cli.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(cli.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// logs returns logs of the container of pod in last sinceSeconds seconds
+func (cli *kubeClient) logs(ctx context.Context, pod corev1.Pod, container string, sinceSeconds int64) (string, error) {
+	data, err := cli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:    container,
+		SinceSeconds: &sinceSeconds,
+	}).DoRaw(ctx)
+
+	return string(data), err
+}
+
+// previousLogs returns logs of the previous instance of the container of pod, e.g. the one which crashed
+func (cli *kubeClient) previousLogs(ctx context.Context, pod corev1.Pod, container string) (string, error) {
+	data, err := cli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  true,
+	}).DoRaw(ctx)
+
+	return string(data), err
+}
@@ -0,0 +1,29 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+type GlobalOptions struct {
+	Namespace       string
+	ConnectorLabels map[string]string
+}
+
+func (opts *GlobalOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringVarP(&opts.Namespace, "namespace", "n", "fabedge", "The namespace where fabedge components are running")
+	fs.StringToStringVar(&opts.ConnectorLabels, "connector-labels", map[string]string{"app": "fabedge-connector"}, "The labels used to find connector pods")
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const tunnelsConfigKey = "tunnels.yaml"
+
+type PlanOptions struct {
+	File string
+}
+
+func (opts *PlanOptions) AddFlags(fs *flag.FlagSet) {
+	fs.StringVarP(&opts.File, "file", "f", "", "The file that contains the new community")
+}
+
+func (opts *PlanOptions) Validate() error {
+	if opts.File == "" {
+		return fmt.Errorf("a community file is required")
+	}
+
+	return nil
+}
+
+func newCommunityCommand(globalOptions *GlobalOptions) *cobra.Command {
+	var planOptions = &PlanOptions{}
+
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show how tunnels and routes will change if a community is applied",
+		Long:  "Compute the tunnels to be added or removed and the routes to be changed on each node if a community is applied, nothing is applied",
+		Example: `# Show changes caused by community in new.yaml
+fabctl community plan -f new.yaml
+`,
+		PreRunE: doValidations(planOptions.Validate),
+		Run: func(cmd *cobra.Command, args []string) {
+			community, err := loadCommunity(planOptions.File)
+			if err != nil {
+				exit("failed to load community: %s", err)
+			}
+
+			fabric := mustLoadFabric(globalOptions)
+			printPlan(os.Stdout, community, computePlan(fabric.confs(), fabric.Communities, community))
+		},
+	}
+	planOptions.AddFlags(planCmd.Flags())
+
+	cmd := &cobra.Command{
+		Use:   "community",
+		Short: "Operate communities",
+	}
+	cmd.AddCommand(planCmd)
+
+	return cmd
+}
+
+func loadCommunity(filename string) (community apis.Community, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return community, err
+	}
+	defer file.Close()
+
+	err = k8syaml.NewYAMLOrJSONDecoder(file, 4096).Decode(&community)
+	if err == nil && community.Name == "" {
+		err = fmt.Errorf("community name is required")
+	}
+
+	return community, err
+}
+
+// PeerChange describes a tunnel to be added or removed and the routes go through it
+type PeerChange struct {
+	Name   string
+	Routes []string
+	// Known is false if the endpoint of peer is not found, routes are unknown
+	Known bool
+}
+
+// NodePlan is the changes of an endpoint, which may be an edge node or a connector
+type NodePlan struct {
+	Endpoint string
+	Added    []PeerChange
+	Removed  []PeerChange
+}
+
+// computePlan computes changes of each endpoint if community is applied. Peers of an endpoint
+// are made up of connector and members of communities it's in, so only peers which come from
+// communities are affected.
+func computePlan(confs []netconf.NetworkConf, communities []apis.Community, community apis.Community) []NodePlan {
+	endpoints := make(map[string]apis.Endpoint)
+	for _, conf := range confs {
+		endpoints[conf.Name] = conf.Endpoint
+		for _, peer := range conf.Peers {
+			if _, ok := endpoints[peer.Name]; !ok {
+				endpoints[peer.Name] = peer
+			}
+		}
+	}
+
+	var oldMembers sets.String
+	for _, c := range communities {
+		if c.Name == community.Name {
+			oldMembers = sets.NewString(c.Spec.Members...)
+		}
+	}
+	newMembers := sets.NewString(community.Spec.Members...)
+
+	// peers from other communities are not affected by this community
+	otherPeers := func(name string) sets.String {
+		peers := sets.NewString()
+		for _, c := range communities {
+			members := sets.NewString(c.Spec.Members...)
+			if c.Name == community.Name || !members.Has(name) {
+				continue
+			}
+			peers = peers.Union(members)
+		}
+		return peers
+	}
+
+	newPeerChange := func(name string) PeerChange {
+		ep, ok := endpoints[name]
+		routes := append([]string{}, ep.Subnets...)
+		routes = append(routes, ep.NodeSubnets...)
+		return PeerChange{Name: name, Routes: routes, Known: ok}
+	}
+
+	var plans []NodePlan
+	for _, conf := range confs {
+		name := conf.Name
+		oldPeers, newPeers := sets.NewString(), sets.NewString()
+		if oldMembers.Has(name) {
+			oldPeers = oldMembers.Difference(sets.NewString(name))
+		}
+		if newMembers.Has(name) {
+			newPeers = newMembers.Difference(sets.NewString(name))
+		}
+
+		others := otherPeers(name)
+		plan := NodePlan{Endpoint: name}
+		for _, peer := range newPeers.Difference(oldPeers).Difference(others).List() {
+			plan.Added = append(plan.Added, newPeerChange(peer))
+		}
+		for _, peer := range oldPeers.Difference(newPeers).Difference(others).List() {
+			plan.Removed = append(plan.Removed, newPeerChange(peer))
+		}
+
+		if len(plan.Added) > 0 || len(plan.Removed) > 0 {
+			plans = append(plans, plan)
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Endpoint < plans[j].Endpoint
+	})
+
+	return plans
+}
+
+func printPlan(w io.Writer, community apis.Community, plans []NodePlan) {
+	if len(plans) == 0 {
+		fmt.Fprintf(w, "No changes. Applying community %s changes no tunnels.\n", community.Name)
+		return
+	}
+
+	printChange := func(sign string, change PeerChange) {
+		if !change.Known {
+			fmt.Fprintf(w, "  %s tunnel %s (endpoint not found, routes unknown)\n", sign, change.Name)
+			return
+		}
+		fmt.Fprintf(w, "  %s tunnel %s\n", sign, change.Name)
+		for _, route := range change.Routes {
+			fmt.Fprintf(w, "      %s route %s\n", sign, route)
+		}
+	}
+
+	added, removed := 0, 0
+	for _, plan := range plans {
+		fmt.Fprintf(w, "%s:\n", plan.Endpoint)
+		for _, change := range plan.Added {
+			printChange("+", change)
+		}
+		for _, change := range plan.Removed {
+			printChange("-", change)
+		}
+		added += len(plan.Added)
+		removed += len(plan.Removed)
+	}
+
+	fmt.Fprintf(w, "\nPlan: %d endpoints to change, %d tunnels to add, %d tunnels to remove.\n", len(plans), added, removed)
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+func TestComputePlan(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	newEndpoint := func(name, subnet string) apis.Endpoint {
+		return apis.Endpoint{Name: name, Subnets: []string{subnet}, NodeSubnets: []string{}}
+	}
+	newCommunity := func(name string, members ...string) apis.Community {
+		return apis.Community{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       apis.CommunitySpec{Members: members},
+		}
+	}
+
+	edge1 := newEndpoint("fabedge.edge1", "2.2.1.0/24")
+	edge2 := newEndpoint("fabedge.edge2", "2.2.2.0/24")
+	edge3 := newEndpoint("fabedge.edge3", "2.2.3.0/24")
+	confs := []netconf.NetworkConf{
+		{Endpoint: edge1, Peers: []apis.Endpoint{edge2}},
+		{Endpoint: edge2, Peers: []apis.Endpoint{edge1}},
+		{Endpoint: edge3},
+	}
+	communities := []apis.Community{
+		newCommunity("edges", "fabedge.edge1", "fabedge.edge2"),
+		newCommunity("others", "fabedge.edge1", "fabedge.edge3"),
+	}
+
+	plans := computePlan(confs, communities, newCommunity("edges", "fabedge.edge2", "fabedge.edge3", "fabedge.edge4"))
+	g.Expect(plans).To(Equal([]NodePlan{
+		{
+			Endpoint: "fabedge.edge1",
+			Removed:  []PeerChange{{Name: "fabedge.edge2", Routes: []string{"2.2.2.0/24"}, Known: true}},
+		},
+		{
+			Endpoint: "fabedge.edge2",
+			Added: []PeerChange{
+				{Name: "fabedge.edge3", Routes: []string{"2.2.3.0/24"}, Known: true},
+				{Name: "fabedge.edge4", Routes: []string{}, Known: false},
+			},
+			Removed: []PeerChange{{Name: "fabedge.edge1", Routes: []string{"2.2.1.0/24"}, Known: true}},
+		},
+		{
+			Endpoint: "fabedge.edge3",
+			Added: []PeerChange{
+				{Name: "fabedge.edge2", Routes: []string{"2.2.2.0/24"}, Known: true},
+				{Name: "fabedge.edge4", Routes: []string{}, Known: false},
+			},
+		},
+	}))
+
+	g.Expect(computePlan(confs, communities, newCommunity("edges", "fabedge.edge1", "fabedge.edge2"))).To(BeEmpty())
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ikeSAReg   = regexp.MustCompile(`^(\S+): #\d+, (\w+), IKEv\d`)
+	childSAReg = regexp.MustCompile(`^\s+(\S+): #\d+, reqid \d+, (\w+),`)
+	trafficReg = regexp.MustCompile(`^\s+(in|out)\s+\S+,\s+(\d+) bytes,\s+(\d+) packets`)
+)
+
+// PeerTraffic is the traffic statistics of an IKE SA, which is summed from its child SAs
+type PeerTraffic struct {
+	Name       string
+	State      string
+	ChildSAs   int
+	BytesIn    uint64
+	BytesOut   uint64
+	PacketsIn  uint64
+	PacketsOut uint64
+}
+
+// parseSAs parses output of `swanctl --list-sas` and returns traffic statistics of each peer
+func parseSAs(output string) []PeerTraffic {
+	var (
+		peers   []PeerTraffic
+		current *PeerTraffic
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if matches := ikeSAReg.FindStringSubmatch(line); matches != nil {
+			peers = append(peers, PeerTraffic{
+				Name:  matches[1],
+				State: matches[2],
+			})
+			current = &peers[len(peers)-1]
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if childSAReg.MatchString(line) {
+			current.ChildSAs++
+			continue
+		}
+
+		if matches := trafficReg.FindStringSubmatch(line); matches != nil {
+			bytes, _ := strconv.ParseUint(matches[2], 10, 64)
+			packets, _ := strconv.ParseUint(matches[3], 10, 64)
+
+			if matches[1] == "in" {
+				current.BytesIn += bytes
+				current.PacketsIn += packets
+			} else {
+				current.BytesOut += bytes
+				current.PacketsOut += packets
+			}
+		}
+	}
+
+	return peers
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseSAs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	output := `fabedge.connector: #3, ESTABLISHED, IKEv2, 1234abcd_i* 5678efgh_r
+  local  'C=CN, O=fabedge.io, CN=fabedge.edge1' @ 10.0.0.1[4500]
+  remote 'C=CN, O=fabedge.io, CN=fabedge.connector' @ 10.0.0.2[4500]
+  AES_CBC-128/HMAC_SHA2_256_128/PRF_HMAC_SHA2_256/MODP_3072
+  established 100s ago, rekeying in 13000s
+  fabedge.connector-p2p: #1, reqid 1, INSTALLED, TUNNEL-in-UDP, ESP:AES_GCM_16-128
+    installed 100s ago, rekeying in 3000s, expires in 3500s
+    in  c1234567,  1000 bytes,    10 packets,     1s ago
+    out c7654321,  2000 bytes,    20 packets,     0s ago
+    local  10.10.0.0/24
+    remote 10.1.0.0/24
+  fabedge.connector-n2p: #2, reqid 2, INSTALLED, TUNNEL-in-UDP, ESP:AES_GCM_16-128
+    installed 100s ago, rekeying in 3000s, expires in 3500s
+    in  c2234567,   500 bytes,     5 packets,     1s ago
+    out c8654321,     0 bytes,     0 packets
+    local  10.0.0.1/32
+    remote 10.1.0.0/24
+fabedge.edge2: #4, CONNECTING, IKEv2, 1234abcd_i* 00000000_r
+  local  'C=CN, O=fabedge.io, CN=fabedge.edge1' @ 10.0.0.1[4500]
+`
+
+	peers := parseSAs(output)
+	g.Expect(peers).To(Equal([]PeerTraffic{
+		{
+			Name:       "fabedge.connector",
+			State:      "ESTABLISHED",
+			ChildSAs:   2,
+			BytesIn:    1500,
+			BytesOut:   2000,
+			PacketsIn:  15,
+			PacketsOut: 20,
+		},
+		{
+			Name:  "fabedge.edge2",
+			State: "CONNECTING",
+		},
+	}))
+}
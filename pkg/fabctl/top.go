@@ -0,0 +1,303 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const clearScreen = "\033[H\033[2J"
+
+var klogErrorReg = regexp.MustCompile(`^E\d{4} `)
+
+type TopOptions struct {
+	Interval    time.Duration
+	Once        bool
+	Concurrency int
+	RecentLines int
+}
+
+func (opts *TopOptions) AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&opts.Interval, "interval", 5*time.Second, "The interval to refresh statistics")
+	fs.BoolVar(&opts.Once, "once", false, "Print statistics once and exit")
+	fs.IntVar(&opts.Concurrency, "concurrency", 10, "The number of pods to collect statistics from concurrently")
+	fs.IntVar(&opts.RecentLines, "recent-lines", 10, "The number of recent operator log lines to display")
+}
+
+func (opts *TopOptions) Validate() error {
+	if opts.Interval < time.Second {
+		return fmt.Errorf("the least interval is 1 second")
+	}
+
+	if opts.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+
+	return nil
+}
+
+func newTopCommand(globalOptions *GlobalOptions) *cobra.Command {
+	var topOptions = &TopOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Display a live view of tunnel throughput and activities of fabedge components",
+		Long:  "Display per-peer tunnel throughput computed from SA counters of agents and connectors, error counts of each component and recent logs of operator",
+		Example: `# Refresh statistics every 5 seconds
+fabctl top
+
+# Print statistics once
+fabctl top --once --interval=3s
+`,
+		PreRunE: doValidations(topOptions.Validate),
+		Run: func(cmd *cobra.Command, args []string) {
+			top := &topper{
+				TopOptions: *topOptions,
+				global:     globalOptions,
+				client:     createKubeClient(),
+				out:        os.Stdout,
+			}
+			top.run()
+		},
+	}
+
+	topOptions.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// componentStats is statistics of one fabedge pod
+type componentStats struct {
+	Pod       corev1.Pod
+	Container string
+	Peers     []PeerTraffic
+	Errors    int
+	Logs      []string
+	Err       error
+}
+
+type snapshot struct {
+	Time       time.Time
+	Components []componentStats
+}
+
+type topper struct {
+	TopOptions
+	global *GlobalOptions
+	client *kubeClient
+	out    io.Writer
+
+	// key is pod name + peer name
+	lastTraffic map[string]PeerTraffic
+	lastTime    time.Time
+}
+
+func (t *topper) run() {
+	if t.Once {
+		// throughput needs two samples
+		t.remember(t.collect())
+		time.Sleep(t.Interval)
+		t.render(t.collect())
+		return
+	}
+
+	for {
+		s := t.collect()
+		fmt.Fprint(t.out, clearScreen)
+		t.render(s)
+		t.remember(s)
+
+		time.Sleep(t.Interval)
+	}
+}
+
+func (t *topper) collect() snapshot {
+	ctx := context.Background()
+
+	var components []componentStats
+	addPods := func(pods []corev1.Pod, err error, container string) {
+		if err != nil {
+			exit("failed to list pods: %s", err)
+		}
+		for _, pod := range pods {
+			components = append(components, componentStats{Pod: pod, Container: container})
+		}
+	}
+
+	pods, err := t.client.listOperatorPods(ctx, t.global.Namespace)
+	addPods(pods, err, containerOperator)
+	pods, err = t.client.listPods(ctx, t.global.Namespace, t.global.ConnectorLabels)
+	addPods(pods, err, containerConnector)
+	pods, err = t.client.listAgentPods(ctx, t.global.Namespace)
+	addPods(pods, err, containerAgent)
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, t.Concurrency)
+	for i := range components {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(stats *componentStats) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			t.collectComponent(ctx, stats)
+		}(&components[i])
+	}
+	wg.Wait()
+
+	return snapshot{
+		Time:       time.Now(),
+		Components: components,
+	}
+}
+
+func (t *topper) collectComponent(ctx context.Context, stats *componentStats) {
+	if stats.Pod.Status.Phase != corev1.PodRunning {
+		return
+	}
+
+	if stats.Container != containerOperator {
+		output, err := t.client.exec(stats.Pod, containerStrongswan, "swanctl", "--list-sas")
+		if err != nil {
+			stats.Err = err
+		} else {
+			stats.Peers = parseSAs(output)
+		}
+	}
+
+	logs, err := t.client.logs(ctx, stats.Pod, stats.Container, int64(t.Interval.Seconds())+1)
+	if err != nil {
+		if stats.Err == nil {
+			stats.Err = err
+		}
+		return
+	}
+
+	for _, line := range strings.Split(logs, "\n") {
+		if line == "" {
+			continue
+		}
+		if klogErrorReg.MatchString(line) {
+			stats.Errors++
+		}
+		stats.Logs = append(stats.Logs, line)
+	}
+}
+
+func (t *topper) remember(s snapshot) {
+	t.lastTime = s.Time
+	t.lastTraffic = make(map[string]PeerTraffic)
+	for _, c := range s.Components {
+		for _, peer := range c.Peers {
+			t.lastTraffic[c.Pod.Name+"/"+peer.Name] = peer
+		}
+	}
+}
+
+func (t *topper) render(s snapshot) {
+	w := tabwriter.NewWriter(t.out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "fabctl top - %s, refresh every %s\n\n", s.Time.Format(time.RFC3339), t.Interval)
+
+	fmt.Fprintln(w, "COMPONENT\tPOD\tNODE\tPHASE\tRESTARTS\tERRORS\tNOTE")
+	for _, c := range s.Components {
+		note := ""
+		if c.Err != nil {
+			note = c.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", c.Container, c.Pod.Name, c.Pod.Spec.NodeName,
+			c.Pod.Status.Phase, getRestartCount(c.Pod), c.Errors, note)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "POD\tPEER\tSTATE\tCHILD-SAS\tIN/s\tOUT/s\tTOTAL-IN\tTOTAL-OUT")
+	elapsed := s.Time.Sub(t.lastTime).Seconds()
+	for _, c := range s.Components {
+		peers := c.Peers
+		sort.Slice(peers, func(i, j int) bool {
+			return peers[i].Name < peers[j].Name
+		})
+
+		for _, peer := range peers {
+			rateIn, rateOut := "-", "-"
+			if last, ok := t.lastTraffic[c.Pod.Name+"/"+peer.Name]; ok && elapsed > 0 {
+				rateIn = formatBytes(float64(subtract(peer.BytesIn, last.BytesIn)) / elapsed)
+				rateOut = formatBytes(float64(subtract(peer.BytesOut, last.BytesOut)) / elapsed)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", c.Pod.Name, peer.Name, peer.State, peer.ChildSAs,
+				rateIn, rateOut, formatBytes(float64(peer.BytesIn)), formatBytes(float64(peer.BytesOut)))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "RECENT OPERATOR LOGS")
+	for _, c := range s.Components {
+		if c.Container != containerOperator {
+			continue
+		}
+
+		logs := c.Logs
+		if len(logs) > t.RecentLines {
+			logs = logs[len(logs)-t.RecentLines:]
+		}
+		for _, line := range logs {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func getRestartCount(pod corev1.Pod) int32 {
+	var count int32
+	for _, status := range pod.Status.ContainerStatuses {
+		count += status.RestartCount
+	}
+	return count
+}
+
+// subtract returns 0 if counters are reset, e.g. SA is rekeyed
+func subtract(current, last uint64) uint64 {
+	if current < last {
+		return 0
+	}
+	return current - last
+}
+
+func formatBytes(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[i])
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+	"github.com/fabedge/fabedge/pkg/operator/certledger"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+// renewAgentCert signs a new certificate for the key of agent's client certificate, which must be
+// the current certificate in agent's TLS secret. The new certificate replaces the old one in TLS
+// secret, so agent pod doesn't have to be recreated and the key never leaves the edge node
+func (cfg Config) renewAgentCert(w http.ResponseWriter, r *http.Request) {
+	signed := false
+	defer func() {
+		result := CertSignResultFailed
+		if signed {
+			result = CertSignResultSigned
+		}
+		CertSignTotal.WithLabelValues(result).Inc()
+	}()
+
+	clientCert := r.TLS.PeerCertificates[0]
+
+	csrPEM, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+		return
+	}
+
+	csrDER, err := certutil.DecodePEM(csrPEM)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("invalid certificate request: %s", err))
+		return
+	}
+
+	// URIs are compared too, so an agent can't claim the SPIFFE ID of others
+	if csr.Subject.CommonName != clientCert.Subject.CommonName ||
+		!isSameURIs(csr.URIs, clientCert.URIs) ||
+		!isSamePublicKey(csr.PublicKey, clientCert.PublicKey) {
+		cfg.response(w, http.StatusForbidden, "certificate request must have the common name, URIs and key of client certificate")
+		return
+	}
+
+	secret, found, err := cfg.getAgentSecret(r.Context(), clientCert)
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !found {
+		cfg.response(w, http.StatusForbidden, "client certificate is not the current certificate of any agent")
+		return
+	}
+
+	certDER, err := traceSignCert(r.Context(), cfg.AgentCertManager, csrDER, certledger.PurposeAgentRenewal)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to sign certificate: %s", err))
+		return
+	}
+
+	certPEM := certutil.EncodeCertPEM(certDER)
+	secret.Data[corev1.TLSCertKey] = certPEM
+	if err = cfg.Client.Update(r.Context(), &secret); err != nil {
+		cfg.response(w, http.StatusInternalServerError, fmt.Sprintf("failed to save certificate: %s", err))
+		return
+	}
+
+	signed = true
+	certledger.Record(cfg.Ledger, cfg.Log, certDER, clientCert.Subject.CommonName, certledger.PurposeAgentRenewal)
+	cfg.Log.V(3).Info("certificate of agent is renewed", "node", secret.Labels[constants.KeyNode], "commonName", clientCert.Subject.CommonName)
+	w.Write(certPEM)
+}
+
+// bootstrapAgentCert signs a certificate for an agent which is authorized by the service account token
+// of its pod, the common name and URIs of the request must be the ones of the node where the pod runs.
+// The certificate and the key are kept by agent, so no TLS secret is needed
+func (cfg Config) bootstrapAgentCert(w http.ResponseWriter, r *http.Request) {
+	signed := false
+	defer func() {
+		result := CertSignResultFailed
+		if signed {
+			result = CertSignResultSigned
+		}
+		CertSignTotal.WithLabelValues(result).Inc()
+	}()
+
+	tokenString := r.Header.Get(HeaderAuthorization)
+	if len(tokenString) <= 7 {
+		cfg.response(w, http.StatusUnauthorized, "invalid authorization token")
+		return
+	}
+
+	// tokenString has a prefix "bearer " which is 7 chars long
+	nodeName, err := cfg.AgentTokens.Verify(r.Context(), tokenString[7:])
+	if err != nil {
+		cfg.response(w, http.StatusUnauthorized, fmt.Sprintf("invalid token: %s", err))
+		return
+	}
+
+	csrPEM, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+		return
+	}
+
+	csrDER, err := certutil.DecodePEM(csrPEM)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("invalid certificate request: %s", err))
+		return
+	}
+
+	var uris []*url.URL
+	if cfg.GetAgentEndpointID != nil {
+		uris = certutil.URIsOfID(cfg.GetAgentEndpointID(nodeName))
+	}
+
+	if csr.Subject.CommonName != cfg.GetAgentEndpointName(nodeName) || !isSameURIs(csr.URIs, uris) ||
+		len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 {
+		cfg.response(w, http.StatusForbidden, fmt.Sprintf("certificate request must have the common name and URIs of agent on node %s only", nodeName))
+		return
+	}
+
+	certDER, err := traceSignCert(r.Context(), cfg.AgentCertManager, csrDER, certledger.PurposeAgentBootstrap)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to sign certificate: %s", err))
+		return
+	}
+
+	signed = true
+	certledger.Record(cfg.Ledger, cfg.Log, certDER, "agent:"+nodeName, certledger.PurposeAgentBootstrap)
+	if cert, err := x509.ParseCertificate(certDER); err == nil {
+		cfg.audit(r, audit.ActionSignCert, "", "agent:"+nodeName, map[string]string{
+			"commonName":   cert.Subject.CommonName,
+			"serialNumber": cert.SerialNumber.String(),
+		})
+	}
+	cfg.Log.V(3).Info("certificate of agent is bootstrapped", "node", nodeName, "commonName", csr.Subject.CommonName)
+	w.Write(certutil.EncodeCertPEM(certDER))
+}
+
+// getAgentSecret finds the TLS secret of agent whose certificate is cert
+func (cfg Config) getAgentSecret(ctx context.Context, cert *x509.Certificate) (corev1.Secret, bool, error) {
+	var secrets corev1.SecretList
+	err := cfg.Client.List(ctx, &secrets,
+		client.InNamespace(cfg.AgentNamespace),
+		client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator},
+		client.HasLabels{constants.KeyNode},
+	)
+	if err != nil {
+		return corev1.Secret{}, false, err
+	}
+
+	for _, secret := range secrets.Items {
+		certDER, err := certutil.DecodePEM(secretutil.GetCert(secret))
+		if err != nil {
+			continue
+		}
+
+		if bytes.Equal(certDER, cert.Raw) {
+			return secret, true, nil
+		}
+	}
+
+	return corev1.Secret{}, false, nil
+}
+
+func isSamePublicKey(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func isSameURIs(a, b []*url.URL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,61 +1,162 @@
 package apiserver
 
 import (
-	"crypto/rsa"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-logr/logr"
 	"github.com/golang-jwt/jwt/v4"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+	"github.com/fabedge/fabedge/pkg/operator/certledger"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 )
 
 const (
 	URLGetCA                      = "/api/ca-cert"
+	URLGetCABundle                = "/api/ca-bundle"
 	URLSignCERT                   = "/api/sign-cert"
 	URLUpdateEndpoints            = "/api/endpoints"
 	URLGetEndpointsAndCommunities = "/api/endpoints-and-communities"
-
-	HeaderClusterName   = "X-FabEdge-Cluster"
-	HeaderAuthorization = "Authorization"
+	URLHeartbeat                  = "/api/heartbeat"
+	URLCluster                    = "/api/clusters/{cluster}"
+	URLClusterTokens              = "/api/clusters/{cluster}/tokens"
+	URLRotateClusterTokens        = "/api/clusters/{cluster}/tokens/rotate"
+	URLClusterToken               = "/api/clusters/{cluster}/tokens/{id}"
+	URLGetCRL                     = "/api/crl"
+	URLRevocations                = "/api/revocations"
+	URLRenewAgentCert             = "/api/agent-cert"
+	URLBootstrapAgentCert         = "/api/agent-cert/bootstrap"
+
+	HeaderClusterName = "X-FabEdge-Cluster"
+	// HeaderOperatorVersion carries the version of operator of member cluster in heartbeat requests
+	HeaderOperatorVersion = "X-FabEdge-Operator-Version"
+	HeaderAuthorization   = "Authorization"
+	HeaderETag            = "ETag"
+	HeaderIfNoneMatch     = "If-None-Match"
+
+	// QueryWatchTimeout is the query parameter to specify how long to wait for changes
+	// of endpoints and communities, e.g. 30s
+	QueryWatchTimeout = "watch-timeout"
+	// MaxWatchTimeout is the longest time to wait for changes of endpoints and communities
+	MaxWatchTimeout = time.Minute
+
+	// AdminCommonName is the common name of client certificate which is allowed to manage tokens
+	// and clusters
+	AdminCommonName = "fabedge-admin"
+	// ClientCommonNameSuffix is the suffix of common names of member clusters' client certificates
+	ClientCommonNameSuffix = ".fabedge-client"
 )
 
 type Config struct {
-	Addr        string
-	PublicKey   *rsa.PublicKey
+	Addr string
+	// PublicKey verifies tokens if Tokens is nil, the public key of CA cert is used if it's nil
+	PublicKey   crypto.PublicKey
 	CertManager certutil.Manager
 	Client      client.Client
 	Log         logr.Logger
 	Store       storepkg.Interface
+	// Tokens stores tokens of clusters, if it's nil, tokens are only verified
+	// by signature and token APIs are disabled
+	Tokens *tokenpkg.Manager
+	// CRL keeps revoked certificates, client certificates which are revoked are rejected.
+	// If it's nil, revocation APIs are disabled
+	CRL *crlpkg.Manager
+	// AgentCertManager signs certificates which agents renew in place, AgentNamespace is where
+	// TLS secrets of agents are. If it's nil, agents can't renew their certificates
+	AgentCertManager certutil.Manager
+	AgentNamespace   string
+	// AgentTokens verifies service account tokens of agent pods, agents get their certificates
+	// by them without TLS secrets. If it's nil, agents can't bootstrap their certificates
+	AgentTokens *tokenpkg.AgentVerifier
+	// GetAgentEndpointName and GetAgentEndpointID tell the common name and SPIFFE ID which
+	// the certificate of an agent must have, GetAgentEndpointID is nil if SPIFFE IDs are disabled
+	GetAgentEndpointName types.GetNameFunc
+	GetAgentEndpointID   types.GetIDFunc
+	// TokenValidPeriod is the default validity duration of minted tokens
+	TokenValidPeriod time.Duration
+	// ServiceAccountTokens verifies bound service account tokens of member clusters, which are
+	// accepted like tokens minted by API server. If it's nil, they are not accepted
+	ServiceAccountTokens *tokenpkg.ServiceAccountVerifier
+	// RateLimiter limits requests of each client, nil means no limit
+	RateLimiter *ClientRateLimiter
+	// Auditor records who signed certificates, updated endpoints and managed clusters,
+	// nil means auditing is disabled
+	Auditor audit.Recorder
+	// Ledger records certificates signed by API server, nil means they are not recorded
+	Ledger certledger.Ledger
+	// Notifier sends lifecycle events of member clusters, e.g. a cluster joins, nil means no events are sent
+	Notifier webhook.Notifier
+	// IsLeader tells if this replica is the leader. API server runs on every replica, but
+	// only the leader has endpoints and communities in Store, nil means it's always leader
+	IsLeader func() bool
+	// StreamPingInterval is the interval to send ping events in streams, 0 means DefaultStreamPingInterval
+	StreamPingInterval time.Duration
+	// StreamMaxAge is how long a stream lasts at most, 0 means DefaultStreamMaxAge
+	StreamMaxAge time.Duration
+}
+
+// statusError is an error with the HTTP status code to respond, gRPC server converts
+// the status code to a gRPC code. Other errors are responded as internal errors
+type statusError struct {
+	statusCode int
+	message    string
+}
+
+func newStatusError(statusCode int, format string, args ...interface{}) error {
+	return &statusError{
+		statusCode: statusCode,
+		message:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *statusError) Error() string {
+	return e.message
 }
 
 type EndpointsAndCommunity struct {
 	Communities map[string][]string `json:"communities,omitempty"`
 	Endpoints   []apis.Endpoint     `json:"endpoints,omitempty"`
+	// Continue is the token to get the next page of endpoints, empty means it's the last page
+	Continue string `json:"continue,omitempty"`
 }
 
 func New(cfg Config) (*http.Server, error) {
 	r := chi.NewRouter()
-	r.Use(middleware.Recoverer)
-	r.Get(URLGetCA, cfg.getCACert)
-	r.Post(URLSignCERT, cfg.signCert)
+	r.Use(cfg.logAccess, cfg.traceRequest, instrument, middleware.Recoverer, middleware.Compress(5, "application/json"))
+	if cfg.RateLimiter != nil {
+		r.Use(cfg.rateLimit)
+	}
+	r.Get(URLGetAPIVersions, cfg.getAPIVersions)
+	r.Get(URLGetOpenAPI, cfg.getOpenAPI)
 
-	r.Group(func(r chi.Router) {
-		r.Use(cfg.verifyCert)
-		r.Put(URLUpdateEndpoints, cfg.updateEndpoints)
-		r.Get(URLGetEndpointsAndCommunities, cfg.getEndpointsAndCommunity)
-	})
+	// unversioned URLs are kept for member clusters of old versions
+	cfg.addRoutes(r, "")
+	for _, version := range SupportedAPIVersions {
+		cfg.addRoutes(r, version)
+	}
 
 	return &http.Server{
 		Addr:    cfg.Addr,
@@ -63,6 +164,67 @@ func New(cfg Config) (*http.Server, error) {
 	}, nil
 }
 
+// addRoutes adds routes of API version, an empty version means unversioned URLs which are served as v1alpha1
+func (cfg Config) addRoutes(r chi.Router, version string) {
+	url := func(u string) string {
+		return VersionedURL(version, u)
+	}
+
+	apiVersion := version
+	if apiVersion == "" {
+		apiVersion = APIVersionV1alpha1
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(withAPIVersion(apiVersion))
+		r.Get(url(URLGetCA), cfg.getCACert)
+		r.Get(url(URLGetCABundle), cfg.getCABundle)
+		r.Post(url(URLSignCERT), cfg.signCert)
+		if cfg.CRL != nil {
+			r.Get(url(URLGetCRL), cfg.getCRL)
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(cfg.verifyCert, cfg.authorizeCluster)
+			r.Put(url(URLUpdateEndpoints), cfg.updateEndpoints)
+			r.Patch(url(URLUpdateEndpoints), cfg.patchEndpoints)
+			r.Get(url(URLGetEndpointsAndCommunities), cfg.getEndpointsAndCommunity)
+			r.Put(url(URLHeartbeat), cfg.heartbeat)
+			if apiVersion == APIVersionV1beta1 {
+				r.Get(url(URLStream), cfg.stream)
+			}
+		})
+
+		if cfg.AgentCertManager != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(cfg.verifyCert)
+				r.Post(url(URLRenewAgentCert), cfg.renewAgentCert)
+			})
+
+			if cfg.AgentTokens != nil {
+				r.Post(url(URLBootstrapAgentCert), cfg.bootstrapAgentCert)
+			}
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(cfg.verifyCert, cfg.verifyAdmin)
+			r.Delete(url(URLCluster), cfg.deregisterCluster)
+
+			if cfg.Tokens != nil {
+				r.Get(url(URLClusterTokens), cfg.listTokens)
+				r.Post(url(URLClusterTokens), cfg.mintToken)
+				r.Post(url(URLRotateClusterTokens), cfg.rotateTokens)
+				r.Delete(url(URLClusterToken), cfg.revokeToken)
+			}
+
+			if cfg.CRL != nil {
+				r.Get(url(URLRevocations), cfg.listRevocations)
+				r.Post(url(URLRevocations), cfg.revokeCert)
+			}
+		})
+	})
+}
+
 func (cfg Config) health(w http.ResponseWriter, r *http.Request) {
 	w.Write(cfg.CertManager.GetCACertPEM())
 }
@@ -71,65 +233,211 @@ func (cfg Config) getCACert(w http.ResponseWriter, r *http.Request) {
 	w.Write(cfg.CertManager.GetCACertPEM())
 }
 
+// getCABundle writes all trusted CA certs, they are more than the CA cert when CA is being rotated
+func (cfg Config) getCABundle(w http.ResponseWriter, r *http.Request) {
+	w.Write(cfg.CertManager.GetCABundlePEM())
+}
+
 func (cfg Config) signCert(w http.ResponseWriter, r *http.Request) {
 	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
-		if err := cfg.CertManager.VerifyCert(r.TLS.PeerCertificates[0], certutil.ExtKeyUsagesServerAndClient); err != nil {
-			cfg.Log.Error(err, "client certificate is invalid")
-			cfg.response(w, http.StatusUnauthorized, fmt.Sprintf("invalid certificate: %s", err))
+		if !cfg.checkClientCert(w, r) {
 			return
 		}
 
-		cfg.doSignCert(w, r)
+		// only member clusters renew their certificates here, certificates of agents and admin can't be used to sign any
+		clusterName, ok := getClusterFromCert(r)
+		if !ok {
+			cfg.response(w, http.StatusForbidden, "only member clusters are allowed to sign certificates")
+			return
+		}
+
+		cfg.doSignCert(w, r, r.TLS.PeerCertificates[0].Subject.CommonName, clusterName)
 		return
 	}
 
-	if err := cfg.verifyAuthorization(r); err != nil {
-		cfg.response(w, http.StatusUnauthorized, fmt.Sprintf("invalid token: %s", err))
+	clusterName, err := cfg.authorizeToken(r.Context(), r.Header.Get(HeaderAuthorization))
+	if err != nil {
+		cfg.responseError(w, err)
 		return
 	}
 
-	cfg.doSignCert(w, r)
+	if cfg.doSignCert(w, r, "token:"+clusterName, clusterName) {
+		cfg.notify(webhook.EventClusterJoined, clusterName, nil)
+	}
 }
 
-func (cfg Config) doSignCert(w http.ResponseWriter, r *http.Request) {
+// authorizeToken verifies tokenString which is the value of authorization header and returns
+// the cluster which the token is issued to, a suspended cluster can't use its tokens
+func (cfg Config) authorizeToken(ctx context.Context, tokenString string) (string, error) {
+	clusterName, err := cfg.verifyAuthorization(ctx, tokenString)
+	if err != nil {
+		return "", newStatusError(http.StatusUnauthorized, "invalid token: %s", err)
+	}
+
+	var cluster apis.Cluster
+	if err = cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster); err == nil && IsSuspended(cluster) {
+		return "", newStatusError(http.StatusForbidden, "cluster %s is suspended", clusterName)
+	}
+
+	return clusterName, nil
+}
+
+// doSignCert signs the CSR in request body, user and clusterName are who requests it and
+// which cluster the user belongs to. It returns true if the certificate is signed
+func (cfg Config) doSignCert(w http.ResponseWriter, r *http.Request, user, clusterName string) (signed bool) {
+	defer func() {
+		result := CertSignResultFailed
+		if signed {
+			result = CertSignResultSigned
+		}
+		CertSignTotal.WithLabelValues(result).Inc()
+	}()
+
 	csrPEM, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
-		return
+		return false
 	}
 
-	csr, err := certutil.DecodePEM(csrPEM)
+	csrDER, err := certutil.DecodePEM(csrPEM)
 	if err != nil {
 		cfg.response(w, http.StatusBadRequest, err.Error())
-		return
+		return false
 	}
 
-	certDER, err := cfg.CertManager.SignCert(csr)
-	certPEM := certutil.EncodeCertPEM(certDER)
+	certDER, err := cfg.signClusterCert(r.Context(), getSourceIP(r), csrDER, user, clusterName)
+	if err != nil {
+		cfg.responseError(w, err)
+		return false
+	}
 
-	w.Write(certPEM)
+	w.Write(certutil.EncodeCertPEM(certDER))
+	return true
 }
 
-func (cfg Config) verifyAuthorization(r *http.Request) error {
-	tokenString := r.Header.Get("authorization")
-	if tokenString == "" {
-		return fmt.Errorf("invalid authorization token")
+// signClusterCert signs csrDER for user of cluster clusterName and returns the certificate in DER.
+// The CSR must have the common name of the cluster's client certificate and no SANs, so a cluster
+// can't get a certificate of another cluster or admin. It's shared by HTTP and gRPC server
+func (cfg Config) signClusterCert(ctx context.Context, sourceIP string, csrDER []byte, user, clusterName string) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "invalid certificate request: %s", err)
+	}
+
+	if csr.Subject.CommonName == AdminCommonName {
+		return nil, newStatusError(http.StatusForbidden, "common name %s is reserved", AdminCommonName)
+	}
+
+	if csr.Subject.CommonName != clusterName+ClientCommonNameSuffix ||
+		len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.URIs) > 0 || len(csr.EmailAddresses) > 0 {
+		return nil, newStatusError(http.StatusForbidden, "certificate request must have the common name %s%s only", clusterName, ClientCommonNameSuffix)
+	}
+
+	certDER, err := traceSignCert(ctx, cfg.CertManager, csrDER, certledger.PurposeMemberCluster)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "failed to sign certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificate is not handed out if it can't be revoked when the cluster is deregistered
+	if err = cfg.recordClusterCert(ctx, clusterName, cert); err != nil {
+		if errors.IsNotFound(err) || errors.IsGone(err) {
+			return nil, newStatusError(http.StatusGone, "cluster %s is deregistered", clusterName)
+		}
+		return nil, err
+	}
+
+	cfg.recordAudit(ctx, sourceIP, audit.ActionSignCert, clusterName, user, map[string]string{
+		"commonName":   cert.Subject.CommonName,
+		"serialNumber": cert.SerialNumber.String(),
+	})
+
+	certledger.Record(cfg.Ledger, cfg.Log, certDER, user, certledger.PurposeMemberCluster)
+
+	return certDER, nil
+}
+
+// recordClusterCert records serial number of cert in annotation of cluster, cluster controller
+// revokes it when the cluster is deregistered
+func (cfg Config) recordClusterCert(ctx context.Context, clusterName string, cert *x509.Certificate) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cluster apis.Cluster
+		if err := cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster); err != nil {
+			return err
+		}
+
+		if cluster.DeletionTimestamp != nil {
+			return errors.NewGone(fmt.Sprintf("cluster %s is being deleted", clusterName))
+		}
+
+		types.RecordClusterCertificate(&cluster, cert)
+		return cfg.Client.Update(ctx, &cluster)
+	})
+}
+
+// verifyAuthorization verifies the token in request and returns the cluster which the token is issued to,
+// a token is either minted by API server or a bound service account token of a member cluster
+func (cfg Config) verifyAuthorization(ctx context.Context, tokenString string) (string, error) {
+	if len(tokenString) <= 7 {
+		return "", fmt.Errorf("invalid authorization token")
+	}
+
+	clusterName, err := cfg.verifyMintedToken(ctx, tokenString)
+	if err != nil && cfg.ServiceAccountTokens != nil {
+		// tokenString has a prefix "bearer " which is 7 chars long
+		if name, saErr := cfg.ServiceAccountTokens.Verify(ctx, tokenString[7:]); saErr == nil {
+			return name, nil
+		}
+	}
+
+	return clusterName, err
+}
+
+func (cfg Config) verifyMintedToken(ctx context.Context, tokenString string) (string, error) {
+	if cfg.Tokens != nil {
+		// tokenString has a prefix "bearer " which is 7 chars long
+		return cfg.Tokens.Verify(ctx, tokenString[7:])
 	}
 
 	var claims jwt.StandardClaims
 	// tokenString has a prefix "bearer " which is 7 chars long
 	token, err := jwt.ParseWithClaims(tokenString[7:], &claims, func(token *jwt.Token) (interface{}, error) {
+		// tokens are signed by the key of the old CA when CA is being rotated
+		if cfg.PublicKey != nil {
+			return cfg.PublicKey, nil
+		}
 		return cfg.CertManager.GetCACert().PublicKey, nil
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if !token.Valid {
-		return fmt.Errorf("invalid authorization token")
+		return "", fmt.Errorf("invalid authorization token")
 	}
 
-	return nil
+	// tokens of deregistered clusters are not stored, so they have to be checked here
+	var cluster apis.Cluster
+	err = cfg.Client.Get(ctx, client.ObjectKey{Name: claims.Subject}, &cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("cluster %s is deregistered", claims.Subject)
+		}
+		return "", err
+	}
+
+	if cluster.DeletionTimestamp != nil {
+		return "", fmt.Errorf("cluster %s is deregistered", claims.Subject)
+	}
+
+	return claims.Subject, nil
 }
 
 func (cfg Config) verifyCert(next http.Handler) http.Handler {
@@ -139,16 +447,86 @@ func (cfg Config) verifyCert(next http.Handler) http.Handler {
 			return
 		}
 
-		if err := cfg.CertManager.VerifyCert(r.TLS.PeerCertificates[0], certutil.ExtKeyUsagesServerAndClient); err != nil {
-			cfg.Log.Error(err, "client certificate is invalid")
-			cfg.response(w, http.StatusUnauthorized, fmt.Sprintf("invalid certificate: %s", err))
+		if cfg.checkClientCert(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// checkClientCert verifies the client certificate of request, if it's not valid, an error
+// response is written and false is returned
+func (cfg Config) checkClientCert(w http.ResponseWriter, r *http.Request) bool {
+	if err := cfg.verifyClientCert(r.Context(), r.TLS.PeerCertificates[0]); err != nil {
+		cfg.responseError(w, err)
+		return false
+	}
+
+	return true
+}
+
+// verifyClientCert verifies a client certificate. Certificates of a member cluster are denied
+// when the cluster is deregistered or suspended, they are put in CRL by cluster controller when
+// the cluster is deleted, so they are still revoked after the cluster is registered again
+func (cfg Config) verifyClientCert(ctx context.Context, cert *x509.Certificate) error {
+	if err := cfg.CertManager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient); err != nil {
+		cfg.Log.Error(err, "client certificate is invalid")
+		return newStatusError(http.StatusUnauthorized, "invalid certificate: %s", err)
+	}
+
+	if cfg.CRL != nil {
+		revoked, err := cfg.CRL.IsRevoked(ctx, cert.SerialNumber)
+		if err != nil {
+			return err
+		}
+
+		if revoked {
+			return newStatusError(http.StatusUnauthorized, "certificate is revoked")
+		}
+	}
+
+	clusterName, ok := getClusterOfCert(cert)
+	if !ok {
+		return nil
+	}
+
+	var cluster apis.Cluster
+	err := cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if errors.IsNotFound(err) || cluster.DeletionTimestamp != nil {
+		return newStatusError(http.StatusGone, "cluster %s is deregistered", clusterName)
+	}
+
+	if IsSuspended(cluster) {
+		return newStatusError(http.StatusForbidden, "cluster %s is suspended", clusterName)
+	}
+
+	return nil
+}
+
+// deregisterCluster deletes a member cluster, the cleanup is done by cluster controller
+func (cfg Config) deregisterCluster(w http.ResponseWriter, r *http.Request) {
+	clusterName := chi.URLParam(r, "cluster")
+
+	cluster := apis.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName},
+	}
+	if err := cfg.Client.Delete(r.Context(), &cluster); err != nil {
+		if errors.IsNotFound(err) {
+			cfg.response(w, http.StatusNotFound, fmt.Sprintf("unknown cluster %s", clusterName))
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	return http.HandlerFunc(fn)
+	cfg.audit(r, audit.ActionDeregister, clusterName, getClientID(r), nil)
+	w.WriteHeader(http.StatusAccepted)
 }
 
 func (cfg Config) updateEndpoints(w http.ResponseWriter, r *http.Request) {
@@ -164,8 +542,56 @@ func (cfg Config) updateEndpoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clusterName := cfg.getCluster(r)
+	if err = cfg.saveEndpoints(r.Context(), clusterName, endpoints); err != nil {
+		cfg.responseError(w, err)
+		return
+	}
+
+	cfg.audit(r, audit.ActionUpdateEndpoints, clusterName, getClientID(r), map[string]string{
+		"endpoints": strings.Join(getEndpointNames(endpoints), ","),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+	w.Write(nil)
+}
+
+// saveEndpoints replaces endpoints of cluster clusterName with endpoints, it's shared by HTTP and gRPC server
+func (cfg Config) saveEndpoints(ctx context.Context, clusterName string, endpoints []apis.Endpoint) error {
 	if len(endpoints) == 0 {
-		cfg.response(w, http.StatusBadRequest, "at least one endpoint is required")
+		return newStatusError(http.StatusBadRequest, "at least one endpoint is required")
+	}
+
+	var cluster apis.Cluster
+	err := cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return newStatusError(http.StatusNotFound, "unknown cluster %s", clusterName)
+		}
+		return err
+	}
+
+	cluster.Spec.EndPoints = endpoints
+	if err = cfg.Client.Update(ctx, &cluster); err != nil {
+		return err
+	}
+	cfg.recordExportTime(ctx, &cluster)
+
+	return nil
+}
+
+// patchEndpoints applies endpoints delta of requesting cluster, if the base revision of delta
+// doesn't match current endpoints, it responds 409 Conflict and the cluster should update all endpoints
+func (cfg Config) patchEndpoints(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to read request body: %s", err))
+		return
+	}
+
+	var delta EndpointsDelta
+	if err = json.Unmarshal(content, &delta); err != nil {
+		cfg.response(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -182,31 +608,130 @@ func (cfg Config) updateEndpoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if GetEndpointsRevision(cluster.Spec.EndPoints) != delta.BaseRevision {
+		cfg.response(w, http.StatusConflict, "base revision of endpoints doesn't match")
+		return
+	}
+
+	if delta.IsEmpty() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	endpoints := delta.Apply(cluster.Spec.EndPoints)
+	if len(endpoints) == 0 {
+		cfg.response(w, http.StatusBadRequest, "at least one endpoint is required")
+		return
+	}
+
 	cluster.Spec.EndPoints = endpoints
-	if err := cfg.Client.Update(r.Context(), &cluster); err != nil {
+	if err = cfg.Client.Update(r.Context(), &cluster); err != nil {
+		if errors.IsConflict(err) {
+			cfg.response(w, http.StatusConflict, err.Error())
+			return
+		}
+
 		cfg.response(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	cfg.recordExportTime(r.Context(), &cluster)
 
+	cfg.audit(r, audit.ActionPatchEndpoints, clusterName, getClientID(r), map[string]string{
+		"updated": strings.Join(getEndpointNames(delta.Updated), ","),
+		"deleted": strings.Join(delta.Deleted, ","),
+	})
 	w.WriteHeader(http.StatusNoContent)
-	w.Write(nil)
 }
 
+// getEndpointsAndCommunity responds endpoints and communities needed by requesting cluster with
+// an ETag. If the ETag in If-None-Match header is still current, it responds 304 Not Modified, or if
+// the watch-timeout query parameter is provided, it waits until they change or timeout.
+// If the limit query parameter is provided, endpoints are responded in pages, the next page is
+// requested with the continue token of previous page, which expires if anything changes
 func (cfg Config) getEndpointsAndCommunity(w http.ResponseWriter, r *http.Request) {
+	if cfg.IsLeader != nil && !cfg.IsLeader() {
+		cfg.response(w, http.StatusServiceUnavailable, "endpoints and communities are only served by leader")
+		return
+	}
+
 	clusterName := cfg.getCluster(r)
 
-	var cluster apis.Cluster
-	err := cfg.Client.Get(r.Context(), client.ObjectKey{Name: clusterName}, &cluster)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			cfg.response(w, http.StatusNotFound, fmt.Sprintf("unknown cluster %s", clusterName))
+	var watchTimeout time.Duration
+	if value := r.URL.Query().Get(QueryWatchTimeout); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			cfg.response(w, http.StatusBadRequest, fmt.Sprintf("invalid watch timeout: %s", value))
 			return
 		}
 
-		cfg.response(w, http.StatusInternalServerError, err.Error())
+		watchTimeout = d
+		if watchTimeout > MaxWatchTimeout {
+			watchTimeout = MaxWatchTimeout
+		}
+	}
+
+	p, err := getPagination(r)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), watchTimeout)
+	defer cancel()
+
+	for {
+		// get the channel before reading store, so no change will be missed
+		changed := cfg.Store.Changed()
+
+		var cluster apis.Cluster
+		err := cfg.Client.Get(r.Context(), client.ObjectKey{Name: clusterName}, &cluster)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				cfg.response(w, http.StatusNotFound, fmt.Sprintf("unknown cluster %s", clusterName))
+				return
+			}
+
+			cfg.response(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ea := cfg.getEndpointsAndCommunityOf(cluster)
+		etag := getETag(marshalEndpointsAndCommunity(r, ea))
+		if p.isContinue() && p.token.ETag != etag {
+			cfg.response(w, http.StatusConflict, "continue token is expired, endpoints and communities should be requested from the first page")
+			return
+		}
+
+		if p.isContinue() || etag != r.Header.Get(HeaderIfNoneMatch) {
+			w.Header().Add("Content-Type", "application/json")
+			w.Header().Set(HeaderETag, etag)
+			w.Write(marshalEndpointsAndCommunity(r, p.page(ea, etag)))
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			w.Header().Set(HeaderETag, etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+}
+
+// marshalEndpointsAndCommunity marshals ea in the API version of request
+func marshalEndpointsAndCommunity(r *http.Request, ea EndpointsAndCommunity) []byte {
+	var content []byte
+	if getAPIVersion(r) == APIVersionV1beta1 {
+		content, _ = json.Marshal(ToV1beta1(ea))
+	} else {
+		content, _ = json.Marshal(ea)
+	}
+
+	return content
+}
+
+func (cfg Config) getEndpointsAndCommunityOf(cluster apis.Cluster) EndpointsAndCommunity {
 	communitySet := make(map[string][]string)
 	endpointNameSet := sets.NewString()
 	for _, endpoint := range cluster.Spec.EndPoints {
@@ -221,22 +746,62 @@ func (cfg Config) getEndpointsAndCommunity(w http.ResponseWriter, r *http.Reques
 			communitySet[community.Name] = community.Members.List()
 			for _, name := range communitySet[community.Name] {
 				// skip endpoints which are from child cluster
-				if !strings.HasPrefix(name, clusterName) {
+				if !strings.HasPrefix(name, cluster.Name) {
 					endpointNameSet.Insert(name)
 				}
 			}
 		}
 	}
 
-	ea := EndpointsAndCommunity{
+	return EndpointsAndCommunity{
 		Endpoints:   cfg.Store.GetEndpoints(endpointNameSet.List()...),
 		Communities: communitySet,
 	}
+}
+
+// heartbeat records the time when the requesting cluster is seen
+func (cfg Config) heartbeat(w http.ResponseWriter, r *http.Request) {
+	clusterName := cfg.getCluster(r)
+
+	var cluster apis.Cluster
+	err := cfg.Client.Get(r.Context(), client.ObjectKey{Name: clusterName}, &cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			cfg.response(w, http.StatusNotFound, fmt.Sprintf("unknown cluster %s", clusterName))
+			return
+		}
+
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := metav1.Now()
+	cluster.Status.LastSeen = &now
+	if version := r.Header.Get(HeaderOperatorVersion); version != "" {
+		cluster.Status.OperatorVersion = version
+	}
+	if err := cfg.Client.Status().Update(r.Context(), &cluster); err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	content, _ := json.Marshal(&ea)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(content)
+// recordExportTime saves the time when cluster exported changes of endpoints, it's done after
+// endpoints are saved, so a failure is only logged. If it's the first time, EndpointsExported is sent
+func (cfg Config) recordExportTime(ctx context.Context, cluster *apis.Cluster) {
+	if cluster.Status.LastExportTime == nil {
+		cfg.notify(webhook.EventEndpointsExported, cluster.Name, map[string]string{
+			"endpoints": strings.Join(getEndpointNames(cluster.Spec.EndPoints), ","),
+		})
+	}
+
+	now := metav1.Now()
+	cluster.Status.LastExportTime = &now
+	if err := cfg.Client.Status().Update(ctx, cluster); err != nil {
+		cfg.Log.Error(err, "failed to record export time of cluster", "cluster", cluster.Name)
+	}
 }
 
 func (cfg Config) response(w http.ResponseWriter, statusCode int, msg string) {
@@ -247,6 +812,70 @@ func (cfg Config) response(w http.ResponseWriter, statusCode int, msg string) {
 	}
 }
 
+// responseError writes the status code and message of err if it's a statusError,
+// other errors are responded as internal server errors
+func (cfg Config) responseError(w http.ResponseWriter, err error) {
+	if se, ok := err.(*statusError); ok {
+		cfg.response(w, se.statusCode, se.message)
+		return
+	}
+
+	cfg.response(w, http.StatusInternalServerError, err.Error())
+}
+
+// getCluster returns the cluster authorized by authorizeCluster, or the cluster in header
+// for requests which are not authorized yet
 func (cfg Config) getCluster(r *http.Request) string {
+	if name, ok := r.Context().Value(clusterKey{}).(string); ok {
+		return name
+	}
 	return r.Header.Get(HeaderClusterName)
 }
+
+// audit saves an audit record if auditing is enabled, failures are only logged
+// because the action is already done
+func (cfg Config) audit(r *http.Request, action, clusterName, user string, detail map[string]string) {
+	cfg.recordAudit(r.Context(), getSourceIP(r), action, clusterName, user, detail)
+}
+
+func (cfg Config) recordAudit(ctx context.Context, sourceIP, action, clusterName, user string, detail map[string]string) {
+	if cfg.Auditor == nil {
+		return
+	}
+
+	err := cfg.Auditor.Record(ctx, audit.Record{
+		Action:   action,
+		Cluster:  clusterName,
+		User:     user,
+		SourceIP: sourceIP,
+		Detail:   detail,
+	})
+	if err != nil {
+		cfg.Log.Error(err, "failed to save audit record", "action", action, "cluster", clusterName, "user", user)
+	}
+}
+
+func (cfg Config) notify(eventType, clusterName string, detail map[string]string) {
+	if cfg.Notifier == nil {
+		return
+	}
+
+	cfg.Notifier.Notify(webhook.Event{
+		Type:    eventType,
+		Cluster: clusterName,
+		Detail:  detail,
+	})
+}
+
+func getEndpointNames(endpoints []apis.Endpoint) []string {
+	names := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		names = append(names, ep.Name)
+	}
+	return names
+}
+
+func getETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
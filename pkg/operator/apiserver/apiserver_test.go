@@ -2,12 +2,17 @@ package apiserver_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"time"
@@ -15,16 +20,24 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2/klogr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 	timeutil "github.com/fabedge/fabedge/pkg/util/time"
 )
 
@@ -131,7 +144,7 @@ var _ = Describe("APIServer", func() {
 	})
 
 	AfterEach(func() {
-		Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), &cluster))).Should(Succeed())
 		Expect(k8sClient.Delete(context.Background(), &community)).Should(Succeed())
 	})
 
@@ -150,7 +163,7 @@ var _ = Describe("APIServer", func() {
 
 		It("can sign cert for child cluster", func() {
 			keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
-				CommonName:   "test",
+				CommonName:   clusterName + apiserver.ClientCommonNameSuffix,
 				Organization: []string{"test"},
 			})
 			Expect(err).Should(BeNil())
@@ -174,94 +187,1285 @@ var _ = Describe("APIServer", func() {
 			Expect(err).Should(BeNil())
 
 			Expect(cert.IsCA).Should(BeFalse())
-			Expect(cert.Subject.CommonName).Should(Equal("test"))
+			Expect(cert.Subject.CommonName).Should(Equal(clusterName + apiserver.ClientCommonNameSuffix))
 			Expect(cert.Subject.Organization).Should(ConsistOf("test"))
 			Expect(cert.PublicKey).Should(Equal(privateKey.Public()))
 			Expect(certManager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
 		})
+
+		signCert := func(request certutil.Request) int {
+			_, csr, err := certutil.NewCertRequest(request)
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+clusterToken)
+			return executeRequest(req, server).Code
+		}
+
+		It("forbids signing cert of another cluster", func() {
+			Expect(signCert(certutil.Request{CommonName: "cluster2" + apiserver.ClientCommonNameSuffix})).Should(Equal(http.StatusForbidden))
+		})
+
+		It("forbids signing cert of admin", func() {
+			Expect(signCert(certutil.Request{CommonName: apiserver.AdminCommonName})).Should(Equal(http.StatusForbidden))
+		})
+
+		It("forbids signing cert with SANs", func() {
+			Expect(signCert(certutil.Request{
+				CommonName: clusterName + apiserver.ClientCommonNameSuffix,
+				DNSNames:   []string{"cluster2.connector"},
+			})).Should(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("With bound service account token", func() {
+		var (
+			issuerServer *httptest.Server
+			issuerKey    *rsa.PrivateKey
+			keysFetched  int
+		)
+
+		BeforeEach(func() {
+			var err error
+			issuerKey, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).Should(BeNil())
+
+			mux := http.NewServeMux()
+			issuerServer = httptest.NewServer(mux)
+			mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]string{
+					"issuer":   issuerServer.URL,
+					"jwks_uri": issuerServer.URL + "/keys",
+				})
+			})
+			keysFetched = 0
+			mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+				keysFetched++
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"keys": []map[string]string{{
+						"kid": "key1",
+						"kty": "RSA",
+						"n":   base64.RawURLEncoding.EncodeToString(issuerKey.N.Bytes()),
+						"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuerKey.E)).Bytes()),
+					}},
+				})
+			})
+
+			cluster.Annotations = map[string]string{tokenpkg.KeyServiceAccountIssuer: issuerServer.URL}
+			Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				CertManager: certManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+				ServiceAccountTokens: &tokenpkg.ServiceAccountVerifier{
+					Client: k8sClient,
+				},
+			})
+			Expect(err).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			issuerServer.Close()
+		})
+
+		newTokenOf := func(subject, audience, kid string, key *rsa.PrivateKey) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+				Issuer:    issuerServer.URL,
+				Subject:   subject,
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			})
+			token.Header["kid"] = kid
+
+			value, err := token.SignedString(key)
+			Expect(err).Should(BeNil())
+			return value
+		}
+
+		newToken := func(audience string, key *rsa.PrivateKey) string {
+			return newTokenOf(tokenpkg.DefaultServiceAccountSubject, audience, "key1", key)
+		}
+
+		signCert := func(token string) int {
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+token)
+			return executeRequest(req, server).Code
+		}
+
+		It("can sign cert with a token issued by the issuer of cluster", func() {
+			Expect(signCert(newToken(tokenpkg.DefaultServiceAccountAudience, issuerKey))).Should(Equal(http.StatusOK))
+		})
+
+		It("rejects tokens of other audiences or signed by other keys", func() {
+			Expect(signCert(newToken("kubernetes", issuerKey))).Should(Equal(http.StatusUnauthorized))
+			Expect(signCert(newToken(tokenpkg.DefaultServiceAccountAudience, privateKey))).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects tokens of other service accounts", func() {
+			token := newTokenOf("system:serviceaccount:default:fabedge-operator", tokenpkg.DefaultServiceAccountAudience, "key1", issuerKey)
+			Expect(signCert(token)).Should(Equal(http.StatusUnauthorized))
+
+			token = newTokenOf("system:serviceaccount:fabedge:default", tokenpkg.DefaultServiceAccountAudience, "key1", issuerKey)
+			Expect(signCert(token)).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects tokens of an issuer which is trusted by more than one cluster", func() {
+			cluster2 := apis.Cluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "cluster2",
+					Annotations: map[string]string{tokenpkg.KeyServiceAccountIssuer: issuerServer.URL},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), &cluster2)).Should(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(context.Background(), &cluster2)).Should(Succeed())
+			}()
+
+			Expect(signCert(newToken(tokenpkg.DefaultServiceAccountAudience, issuerKey))).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("doesn't fetch keys again for each token with an unknown kid", func() {
+			Expect(signCert(newToken(tokenpkg.DefaultServiceAccountAudience, issuerKey))).Should(Equal(http.StatusOK))
+			Expect(keysFetched).Should(Equal(1))
+
+			for i := 0; i < 3; i++ {
+				token := newTokenOf(tokenpkg.DefaultServiceAccountSubject, tokenpkg.DefaultServiceAccountAudience, "unknown", issuerKey)
+				Expect(signCert(token)).Should(Equal(http.StatusUnauthorized))
+			}
+			Expect(keysFetched).Should(Equal(1))
+
+			Expect(signCert(newToken(tokenpkg.DefaultServiceAccountAudience, issuerKey))).Should(Equal(http.StatusOK))
+			Expect(keysFetched).Should(Equal(1))
+		})
+	})
+
+	Context("When CA is being rotated", func() {
+		var rotatingCertManager certutil.Manager
+
+		BeforeEach(func() {
+			newCACertDER, newCAKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+				CommonName:     certutil.DefaultCAName,
+				Organization:   []string{certutil.DefaultOrganization},
+				ValidityPeriod: timeutil.Days(1),
+				IsCA:           true,
+			})
+			Expect(err).Should(BeNil())
+
+			rotatingCertManager, err = certutil.NewManger(newCACertDER, newCAKeyDER, timeutil.Days(1), certManager.GetCACert().Raw)
+			Expect(err).Should(BeNil())
+
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				PublicKey:   &privateKey.PublicKey,
+				CertManager: rotatingCertManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+			})
+			Expect(err).Should(BeNil())
+		})
+
+		It("can serve CA cert and CA bundle", func() {
+			resp := executeRequest(httptest.NewRequest(http.MethodGet, apiserver.URLGetCA, nil), server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Body.Bytes()).Should(Equal(rotatingCertManager.GetCACertPEM()))
+
+			resp = executeRequest(httptest.NewRequest(http.MethodGet, apiserver.URLGetCABundle, nil), server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Body.Bytes()).Should(Equal(rotatingCertManager.GetCABundlePEM()))
+
+			bundle, err := certutil.DecodeCertsPEM(resp.Body.Bytes())
+			Expect(err).Should(BeNil())
+			Expect(bundle).Should(HaveLen(2))
+		})
+
+		It("can sign cert by a token signed by the key of old CA", func() {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+				Subject: clusterName,
+			}).SignedString(privateKey)
+			Expect(err).Should(BeNil())
+
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+token)
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			certDER, err := certutil.DecodePEM(resp.Body.Bytes())
+			Expect(err).Should(BeNil())
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			Expect(rotatingCertManager.IsIssuedByCA(cert)).Should(BeTrue())
+		})
+	})
+
+	Context("With client certificate", func() {
+		var connectionState *tls.ConnectionState
+
+		BeforeEach(func() {
+			connectionState = newConnectionState(certManager, clusterName+apiserver.ClientCommonNameSuffix)
+		})
+
+		It("can get endpoints and communities needed for a cluster", func() {
+			req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			content, err := ioutil.ReadAll(resp.Body)
+			Expect(err).Should(BeNil())
+
+			var ea apiserver.EndpointsAndCommunity
+			Expect(json.Unmarshal(content, &ea)).Should(Succeed())
+			Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
+			Expect(ea.Communities[community.Name]).Should(ConsistOf(rootConnector.Name, childConnector.Name))
+		})
+
+		It("responds service unavailable for endpoints and communities if it's not leader", func() {
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				CertManager: certManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+				IsLeader:    func() bool { return false },
+			})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusServiceUnavailable))
+
+			req, _ = http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNoContent))
+		})
+
+		It("can serve endpoints and communities of each API version", func() {
+			req, _ := http.NewRequest("GET", apiserver.URLGetAPIVersions, nil)
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			var versions apiserver.APIVersions
+			Expect(json.Unmarshal(resp.Body.Bytes(), &versions)).Should(Succeed())
+			Expect(versions.Versions).Should(Equal(apiserver.SupportedAPIVersions))
+
+			By("requesting v1alpha1")
+			req, _ = http.NewRequest("GET", apiserver.VersionedURL(apiserver.APIVersionV1alpha1, apiserver.URLGetEndpointsAndCommunities), nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			resp = executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Header().Get(apiserver.HeaderAPIVersion)).Should(Equal(apiserver.APIVersionV1alpha1))
+
+			var ea apiserver.EndpointsAndCommunity
+			Expect(json.Unmarshal(resp.Body.Bytes(), &ea)).Should(Succeed())
+			Expect(ea.Communities[community.Name]).Should(ConsistOf(rootConnector.Name, childConnector.Name))
+
+			By("requesting v1beta1")
+			req, _ = http.NewRequest("GET", apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLGetEndpointsAndCommunities), nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			resp = executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Header().Get(apiserver.HeaderAPIVersion)).Should(Equal(apiserver.APIVersionV1beta1))
+
+			var eaV1beta1 apiserver.EndpointsAndCommunitiesV1beta1
+			Expect(json.Unmarshal(resp.Body.Bytes(), &eaV1beta1)).Should(Succeed())
+			Expect(eaV1beta1.Endpoints).Should(ConsistOf(rootConnector))
+			Expect(eaV1beta1.Communities).Should(HaveLen(1))
+			Expect(eaV1beta1.Communities[0].Name).Should(Equal(community.Name))
+			Expect(eaV1beta1.ToV1alpha1()).Should(Equal(ea))
+		})
+
+		It("can wait for changes of endpoints and communities needed for a cluster", func() {
+			newRequest := func(etag, watchTimeout string) *http.Request {
+				req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities+"?"+apiserver.QueryWatchTimeout+"="+watchTimeout, nil)
+				req.TLS = connectionState
+				req.Header.Add(apiserver.HeaderClusterName, clusterName)
+				req.Header.Add(apiserver.HeaderIfNoneMatch, etag)
+				return req
+			}
+
+			resp := executeRequest(newRequest("", "0s"), server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			etag := resp.Header().Get(apiserver.HeaderETag)
+			Expect(etag).ShouldNot(BeEmpty())
+
+			By("requesting with current etag")
+			resp = executeRequest(newRequest(etag, "0s"), server)
+			Expect(resp.Code).Should(Equal(http.StatusNotModified))
+
+			By("changing endpoints when waiting")
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				done <- executeRequest(newRequest(etag, "10s"), server)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			rootConnector.PublicAddresses = []string{"10.40.1.2"}
+			store.SaveEndpoint(rootConnector)
+
+			Eventually(done, time.Second).Should(Receive(&resp))
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Header().Get(apiserver.HeaderETag)).ShouldNot(Equal(etag))
+
+			var ea apiserver.EndpointsAndCommunity
+			Expect(json.Unmarshal(resp.Body.Bytes(), &ea)).Should(Succeed())
+			Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
+		})
+
+		It("can serve endpoints in pages", func() {
+			store.SaveCommunity(types.Community{
+				Name:    "mixed",
+				Members: sets.NewString(childConnector.Name, rootConnector.Name, rootEndpoint.Name),
+			})
+
+			newRequest := func(query string) *http.Request {
+				req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities+"?"+query, nil)
+				req.TLS = connectionState
+				req.Header.Add(apiserver.HeaderClusterName, clusterName)
+				return req
+			}
+
+			resp := executeRequest(newRequest(apiserver.QueryLimit+"=1"), server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			var ea apiserver.EndpointsAndCommunity
+			Expect(json.Unmarshal(resp.Body.Bytes(), &ea)).Should(Succeed())
+			Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
+			Expect(ea.Communities).Should(HaveLen(2))
+			Expect(ea.Continue).ShouldNot(BeEmpty())
+
+			By("requesting the next page")
+			continueToken := ea.Continue
+			resp = executeRequest(newRequest(apiserver.QueryLimit+"=1&"+apiserver.QueryContinue+"="+continueToken), server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			ea = apiserver.EndpointsAndCommunity{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &ea)).Should(Succeed())
+			Expect(ea.Endpoints).Should(ConsistOf(rootEndpoint))
+			Expect(ea.Communities).Should(BeEmpty())
+			Expect(ea.Continue).Should(BeEmpty())
+
+			By("requesting with an expired continue token")
+			rootEndpoint.PublicAddresses = []string{"10.30.1.2"}
+			store.SaveEndpoint(rootEndpoint)
+			resp = executeRequest(newRequest(apiserver.QueryLimit+"=1&"+apiserver.QueryContinue+"="+continueToken), server)
+			Expect(resp.Code).Should(Equal(http.StatusConflict))
+
+			By("requesting with an invalid limit")
+			resp = executeRequest(newRequest(apiserver.QueryLimit+"=0"), server)
+			Expect(resp.Code).Should(Equal(http.StatusBadRequest))
+		})
+
+		It("can compress endpoints and communities", func() {
+			req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			req.Header.Add("Accept-Encoding", "gzip")
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Header().Get("Content-Encoding")).Should(Equal("gzip"))
+
+			reader, err := gzip.NewReader(resp.Body)
+			Expect(err).Should(BeNil())
+			content, err := ioutil.ReadAll(reader)
+			Expect(err).Should(BeNil())
+
+			var ea apiserver.EndpointsAndCommunity
+			Expect(json.Unmarshal(content, &ea)).Should(Succeed())
+			Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
+		})
+
+		It("can push endpoints and communities through stream", func() {
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:               "localhost:8080",
+				CertManager:        certManager,
+				Client:             k8sClient,
+				Store:              store,
+				Log:                klogr.New(),
+				StreamPingInterval: 20 * time.Millisecond,
+			})
+			Expect(err).Should(BeNil())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequestWithContext(ctx, "GET", apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLStream), nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				done <- executeRequest(req, server)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			rootConnector.PublicAddresses = []string{"10.40.1.2"}
+			store.SaveEndpoint(rootConnector)
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+
+			var resp *httptest.ResponseRecorder
+			Eventually(done, time.Second).Should(Receive(&resp))
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+			Expect(resp.Header().Get("Content-Type")).Should(Equal(apiserver.ContentTypeStream))
+
+			var events []apiserver.StreamEvent
+			decoder := json.NewDecoder(resp.Body)
+			for decoder.More() {
+				var event apiserver.StreamEvent
+				Expect(decoder.Decode(&event)).Should(Succeed())
+				events = append(events, event)
+			}
+
+			var changes []apiserver.EndpointsAndCommunitiesV1beta1
+			pings := 0
+			for _, event := range events {
+				switch event.Type {
+				case apiserver.StreamEventEndpointsAndCommunities:
+					changes = append(changes, *event.EndpointsAndCommunities)
+				case apiserver.StreamEventPing:
+					pings++
+				}
+			}
+			Expect(changes).Should(HaveLen(2))
+			Expect(changes[0].Endpoints[0].PublicAddresses).Should(Equal([]string{"10.40.1.1"}))
+			Expect(changes[1].Endpoints).Should(ConsistOf(rootConnector))
+			Expect(pings).Should(BeNumerically(">", 0))
+		})
+
+		It("ends stream after its max age", func() {
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:         "localhost:8080",
+				CertManager:  certManager,
+				Client:       k8sClient,
+				Store:        store,
+				Log:          klogr.New(),
+				StreamMaxAge: 100 * time.Millisecond,
+			})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("GET", apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLStream), nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				done <- executeRequest(req, server)
+			}()
+
+			var resp *httptest.ResponseRecorder
+			Eventually(done, time.Second).Should(Receive(&resp))
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			var event apiserver.StreamEvent
+			Expect(json.NewDecoder(resp.Body).Decode(&event)).Should(Succeed())
+			Expect(event.Type).Should(Equal(apiserver.StreamEventEndpointsAndCommunities))
+		})
+
+		It("can update endpoints of requesting cluster", func() {
+			endpoints := []apis.Endpoint{
+				childConnector,
+			}
+
+			endpointsJson, err := json.Marshal(endpoints)
+			Expect(err).Should(BeNil())
+
+			reqBody := bytes.NewBuffer(endpointsJson)
+			req, _ := http.NewRequest("PUT", apiserver.URLUpdateEndpoints, reqBody)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusNoContent))
+
+			err = k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)
+			Expect(err).Should(BeNil())
+
+			Expect(cluster.Spec.EndPoints).Should(ConsistOf(childConnector))
+			Expect(cluster.Status.LastExportTime).ShouldNot(BeNil())
+		})
+
+		It("records requests and signed certificates in metrics", func() {
+			route := apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLSignCERT)
+			requests := testutil.ToFloat64(apiserver.RequestsTotal.WithLabelValues("POST", route, "200"))
+			signed := testutil.ToFloat64(apiserver.CertSignTotal.WithLabelValues(apiserver.CertSignResultSigned))
+			failed := testutil.ToFloat64(apiserver.CertSignTotal.WithLabelValues(apiserver.CertSignResultFailed))
+
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", route, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusOK))
+
+			req, _ = http.NewRequest("POST", route, bytes.NewBufferString("invalid csr"))
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusBadRequest))
+
+			Expect(testutil.ToFloat64(apiserver.RequestsTotal.WithLabelValues("POST", route, "200"))).Should(Equal(requests + 1))
+			Expect(testutil.ToFloat64(apiserver.CertSignTotal.WithLabelValues(apiserver.CertSignResultSigned))).Should(Equal(signed + 1))
+			Expect(testutil.ToFloat64(apiserver.CertSignTotal.WithLabelValues(apiserver.CertSignResultFailed))).Should(Equal(failed + 1))
+		})
+
+		It("can patch endpoints of requesting cluster", func() {
+			newRequest := func(delta apiserver.EndpointsDelta) *http.Request {
+				content, err := json.Marshal(delta)
+				Expect(err).Should(BeNil())
+
+				req, _ := http.NewRequest("PATCH", apiserver.URLUpdateEndpoints, bytes.NewBuffer(content))
+				req.TLS = connectionState
+				req.Header.Add(apiserver.HeaderClusterName, clusterName)
+				return req
+			}
+
+			newConnector := childConnector
+			newConnector.PublicAddresses = []string{"10.1.1.20"}
+			delta := apiserver.NewEndpointsDelta(cluster.Spec.EndPoints, []apis.Endpoint{newConnector})
+
+			resp := executeRequest(newRequest(delta), server)
+			Expect(resp.Code).Should(Equal(http.StatusNoContent))
+
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+			Expect(cluster.Spec.EndPoints).Should(ConsistOf(newConnector))
+
+			By("patching with an outdated base revision")
+			resp = executeRequest(newRequest(delta), server)
+			Expect(resp.Code).Should(Equal(http.StatusConflict))
+		})
+
+		It("can record heartbeat of requesting cluster", func() {
+			req, _ := http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			req.Header.Add(apiserver.HeaderOperatorVersion, "0.8.0")
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusNoContent))
+
+			err := k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)
+			Expect(err).Should(BeNil())
+
+			Expect(cluster.Status.LastSeen).ShouldNot(BeNil())
+			Expect(cluster.Status.LastSeen.Time).Should(BeTemporally("~", time.Now(), 5*time.Second))
+			Expect(cluster.Status.OperatorVersion).Should(Equal("0.8.0"))
+		})
+
+		It("response forbidden when a cluster reports heartbeat for another cluster", func() {
+			req, _ := http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, "unknown")
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusForbidden))
+		})
+
+		It("can sign cert for child cluster", func() {
+			_, csr, err := certutil.NewCertRequest(certutil.Request{
+				CommonName:   clusterName + apiserver.ClientCommonNameSuffix,
+				Organization: []string{"test"},
+			})
+			Expect(err).Should(BeNil())
+
+			reqBody := bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr))
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, reqBody)
+			req.TLS = connectionState
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			content, err := ioutil.ReadAll(resp.Body)
+			Expect(err).Should(BeNil())
+
+			certDER, err := certutil.DecodePEM(content)
+			Expect(err).Should(BeNil())
+
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			Expect(certManager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+		})
+	})
+
+	Context("With token manager", func() {
+		var (
+			tokens         *tokenpkg.Manager
+			newConnection  func(commonName string) *tls.ConnectionState
+			mintedTokenURL string
+		)
+
+		BeforeEach(func() {
+			tokens = &tokenpkg.Manager{
+				Namespace:  "default",
+				PrivateKey: privateKey,
+				Client:     k8sClient,
+			}
+
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:             "localhost:8080",
+				CertManager:      certManager,
+				Client:           k8sClient,
+				Store:            store,
+				Log:              klogr.New(),
+				Tokens:           tokens,
+				TokenValidPeriod: time.Hour,
+			})
+			Expect(err).Should(BeNil())
+
+			newConnection = func(commonName string) *tls.ConnectionState {
+				return newConnectionState(certManager, commonName)
+			}
+			mintedTokenURL = "/api/clusters/" + clusterName + "/tokens"
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.DeleteAllOf(context.Background(), &corev1.Secret{},
+				client.InNamespace("default"),
+				client.MatchingLabels{constants.KeyFabedgeAPP: tokenpkg.AppToken},
+			)).Should(Succeed())
+		})
+
+		mintToken := func(url string) tokenpkg.Token {
+			req, _ := http.NewRequest("POST", url, nil)
+			req.TLS = newConnection(apiserver.AdminCommonName)
+
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusCreated))
+
+			var token tokenpkg.Token
+			Expect(json.Unmarshal(resp.Body.Bytes(), &token)).Should(Succeed())
+			return token
+		}
+
+		signCert := func(token string) int {
+			_, csr, err := certutil.NewCertRequest(certutil.Request{
+				CommonName:   clusterName + apiserver.ClientCommonNameSuffix,
+				Organization: []string{"test"},
+			})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+token)
+
+			return executeRequest(req, server).Code
+		}
+
+		It("can mint a token which is stored hashed and can be used to sign cert", func() {
+			token := mintToken(mintedTokenURL)
+			Expect(token.ID).ShouldNot(BeEmpty())
+			Expect(token.Cluster).Should(Equal(clusterName))
+			Expect(token.ExpiresAt).Should(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+			var secrets corev1.SecretList
+			Expect(k8sClient.List(context.Background(), &secrets, client.MatchingLabels{constants.KeyFabedgeAPP: tokenpkg.AppToken})).Should(Succeed())
+			Expect(secrets.Items).Should(HaveLen(1))
+			Expect(string(secrets.Items[0].Data[tokenpkg.KeyHash])).ShouldNot(ContainSubstring(token.Value))
+
+			Expect(signCert(token.Value)).Should(Equal(http.StatusOK))
+		})
+
+		It("rejects tokens which are not stored", func() {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+				Id:      "unknown",
+				Subject: clusterName,
+			})
+			value, err := token.SignedString(privateKey)
+			Expect(err).Should(BeNil())
+
+			Expect(signCert(value)).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("can revoke a token", func() {
+			token := mintToken(mintedTokenURL)
+
+			req, _ := http.NewRequest("DELETE", mintedTokenURL+"/"+token.ID, nil)
+			req.TLS = newConnection(apiserver.AdminCommonName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNoContent))
+
+			Expect(signCert(token.Value)).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("can rotate tokens of a cluster", func() {
+			oldToken := mintToken(mintedTokenURL)
+			newToken := mintToken(mintedTokenURL + "/rotate?valid-period=2h")
+
+			Expect(signCert(oldToken.Value)).Should(Equal(http.StatusUnauthorized))
+			Expect(signCert(newToken.Value)).Should(Equal(http.StatusOK))
+
+			req, _ := http.NewRequest("GET", mintedTokenURL, nil)
+			req.TLS = newConnection(apiserver.AdminCommonName)
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			var tokens []tokenpkg.Token
+			Expect(json.Unmarshal(resp.Body.Bytes(), &tokens)).Should(Succeed())
+			Expect(tokens).Should(HaveLen(1))
+			Expect(tokens[0].ID).Should(Equal(newToken.ID))
+			Expect(tokens[0].Value).Should(BeEmpty())
+		})
+
+		It("only allows admin to manage tokens", func() {
+			req, _ := http.NewRequest("POST", mintedTokenURL, nil)
+			req.TLS = newConnection("cluster1.fabedge-client")
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusForbidden))
+
+			req, _ = http.NewRequest("POST", mintedTokenURL, nil)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("never signs admin certificate with a token of cluster", func() {
+			token := mintToken(mintedTokenURL)
+
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: apiserver.AdminCommonName})
+			Expect(err).Should(BeNil())
+
+			for _, version := range append([]string{""}, apiserver.SupportedAPIVersions...) {
+				req, _ := http.NewRequest("POST", apiserver.VersionedURL(version, apiserver.URLSignCERT), bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+				req.Header.Add("Authorization", "bearer "+token.Value)
+				Expect(executeRequest(req, server).Code).Should(Equal(http.StatusForbidden))
+			}
+		})
+
+		It("response not found when minting token for unknown cluster", func() {
+			req, _ := http.NewRequest("POST", "/api/clusters/unknown/tokens", nil)
+			req.TLS = newConnection(apiserver.AdminCommonName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNotFound))
+		})
 	})
 
-	Context("With client certificate", func() {
+	Context("With member cluster certificate", func() {
 		var connectionState *tls.ConnectionState
 
 		BeforeEach(func() {
-			certDER, _, err := certManager.NewCertKey(certutil.Config{
-				CommonName:     "client",
-				Organization:   []string{certutil.DefaultOrganization},
-				ValidityPeriod: time.Hour,
+			connectionState = newConnectionState(certManager, clusterName+apiserver.ClientCommonNameSuffix)
+		})
+
+		heartbeat := func(name string) int {
+			req, _ := http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, name)
+
+			return executeRequest(req, server).Code
+		}
+
+		It("can access APIs of the cluster which the certificate is issued to", func() {
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusNoContent))
+			Expect(heartbeat("cluster2")).Should(Equal(http.StatusForbidden))
+
+			By("omitting cluster in header")
+			Expect(heartbeat("")).Should(Equal(http.StatusNoContent))
+		})
+
+		It("forbids certificates which are not issued to member clusters to access APIs of clusters", func() {
+			connectionState = newConnectionState(certManager, "client")
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusForbidden))
+
+			connectionState = newConnectionState(certManager, apiserver.AdminCommonName)
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusForbidden))
+		})
+
+		It("forbids certificates which are not issued to member clusters to sign certs", func() {
+			signCert := func(commonName string) int {
+				_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: commonName})
+				Expect(err).Should(BeNil())
+
+				req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+				req.TLS = connectionState
+				return executeRequest(req, server).Code
+			}
+
+			By("signing cert of another cluster by member cluster")
+			Expect(signCert("cluster2" + apiserver.ClientCommonNameSuffix)).Should(Equal(http.StatusForbidden))
+			Expect(signCert(apiserver.AdminCommonName)).Should(Equal(http.StatusForbidden))
+
+			By("signing cert by agent or admin")
+			for _, commonName := range []string{"cluster1.edge1", apiserver.AdminCommonName} {
+				connectionState = newConnectionState(certManager, commonName)
+				Expect(signCert(commonName)).Should(Equal(http.StatusForbidden))
+				Expect(signCert(clusterName + apiserver.ClientCommonNameSuffix)).Should(Equal(http.StatusForbidden))
+			}
+		})
+
+		It("denies suspended clusters", func() {
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+			cluster.Annotations = map[string]string{constants.KeySuspended: "true"}
+			Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusForbidden))
+
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.TLS = connectionState
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusForbidden))
+
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+				Subject: clusterName,
 			})
+			clusterToken, err := token.SignedString(privateKey)
+			Expect(err).Should(BeNil())
+			req, _ = http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+clusterToken)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusForbidden))
+
+			By("removing cluster from deny-list")
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+			delete(cluster.Annotations, constants.KeySuspended)
+			Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusNoContent))
+		})
+
+		It("can be used to deregister a cluster by admin", func() {
+			By("deregistering by member cluster")
+			req, _ := http.NewRequest("DELETE", "/api/clusters/"+clusterName, nil)
+			req.TLS = connectionState
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusForbidden))
+
+			By("deregistering by admin")
+			req, _ = http.NewRequest("DELETE", "/api/clusters/"+clusterName, nil)
+			req.TLS = newConnectionState(certManager, apiserver.AdminCommonName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusAccepted))
+
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusGone))
+
+			req, _ = http.NewRequest("DELETE", "/api/clusters/"+clusterName, nil)
+			req.TLS = newConnectionState(certManager, apiserver.AdminCommonName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNotFound))
+		})
+
+		It("records serial numbers of signed certificates in cluster", func() {
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
 			Expect(err).Should(BeNil())
 
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.TLS = connectionState
+			resp := executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			certDER, err := certutil.DecodePEM(resp.Body.Bytes())
+			Expect(err).Should(BeNil())
 			cert, err := x509.ParseCertificate(certDER)
 			Expect(err).Should(BeNil())
 
-			connectionState = &tls.ConnectionState{
-				PeerCertificates: []*x509.Certificate{cert},
+			Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+			Expect(types.GetClusterCertSerialNumbers(cluster)).Should(ConsistOf(cert.SerialNumber))
+		})
+
+		It("accepts certificates which are not revoked after the cluster is registered again", func() {
+			Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusGone))
+
+			cluster = apis.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: clusterName},
 			}
+			Expect(k8sClient.Create(context.Background(), &cluster)).Should(Succeed())
+
+			// certificates are revoked by cluster controller when the cluster is deregistered,
+			// API server doesn't tell them by when they are issued
+			Expect(heartbeat(clusterName)).Should(Equal(http.StatusNoContent))
 		})
 
-		It("can get endpoints and communities needed for a cluster", func() {
-			req, _ := http.NewRequest("GET", apiserver.URLGetEndpointsAndCommunities, nil)
+		It("rejects token of a deregistered cluster", func() {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+				Subject: clusterName,
+			})
+			clusterToken, err := token.SignedString(privateKey)
+			Expect(err).Should(BeNil())
+
+			Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+clusterToken)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("With auditor and rate limiter", func() {
+		var (
+			auditor         audit.ConfigMapRecorder
+			connectionState *tls.ConnectionState
+		)
+
+		BeforeEach(func() {
+			auditor = audit.ConfigMapRecorder{
+				Namespace:  "default",
+				Client:     k8sClient,
+				Log:        klogr.New(),
+				MaxRecords: 2,
+			}
+
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				CertManager: certManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+				RateLimiter: apiserver.NewClientRateLimiter(0.1, 3),
+				Auditor:     auditor,
+			})
+			Expect(err).Should(BeNil())
+
+			connectionState = newConnectionState(certManager, clusterName+apiserver.ClientCommonNameSuffix)
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.DeleteAllOf(context.Background(), &corev1.ConfigMap{},
+				client.InNamespace("default"),
+				client.MatchingLabels{constants.KeyFabedgeAPP: audit.AppAudit},
+			)).Should(Succeed())
+		})
+
+		updateEndpoints := func() int {
+			endpointsJson, err := json.Marshal([]apis.Endpoint{childConnector})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("PUT", apiserver.URLUpdateEndpoints, bytes.NewBuffer(endpointsJson))
 			req.TLS = connectionState
 			req.Header.Add(apiserver.HeaderClusterName, clusterName)
 
-			resp := executeRequest(req, server)
-			Expect(resp.Code).Should(Equal(http.StatusOK))
+			return executeRequest(req, server).Code
+		}
 
-			content, err := ioutil.ReadAll(resp.Body)
+		It("can record who signed cert and updated endpoints", func() {
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
 			Expect(err).Should(BeNil())
 
-			var ea apiserver.EndpointsAndCommunity
-			Expect(json.Unmarshal(content, &ea)).Should(Succeed())
-			Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
-			Expect(ea.Communities[community.Name]).Should(ConsistOf(rootConnector.Name, childConnector.Name))
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, clusterName)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusOK))
+
+			Expect(updateEndpoints()).Should(Equal(http.StatusNoContent))
+
+			records, err := auditor.List(context.Background(), clusterName)
+			Expect(err).Should(BeNil())
+			Expect(records).Should(HaveLen(2))
+
+			Expect(records[0].Action).Should(Equal(audit.ActionSignCert))
+			Expect(records[0].User).Should(Equal(clusterName + apiserver.ClientCommonNameSuffix))
+			Expect(records[0].Detail["commonName"]).Should(Equal(clusterName + apiserver.ClientCommonNameSuffix))
+			Expect(records[0].Detail["serialNumber"]).ShouldNot(BeEmpty())
+
+			Expect(records[1].Action).Should(Equal(audit.ActionUpdateEndpoints))
+			Expect(records[1].Cluster).Should(Equal(clusterName))
+			Expect(records[1].Detail["endpoints"]).Should(Equal(childConnector.Name))
+
+			By("keeping only latest records")
+			Expect(updateEndpoints()).Should(Equal(http.StatusNoContent))
+			records, err = auditor.List(context.Background(), clusterName)
+			Expect(err).Should(BeNil())
+			Expect(records).Should(HaveLen(2))
+			Expect(records[0].Action).Should(Equal(audit.ActionUpdateEndpoints))
 		})
 
-		It("can update endpoints of requesting cluster", func() {
-			endpoints := []apis.Endpoint{
-				childConnector,
+		It("limits requests of each client", func() {
+			for i := 0; i < 3; i++ {
+				Expect(updateEndpoints()).Should(Equal(http.StatusNoContent))
 			}
+			Expect(updateEndpoints()).Should(Equal(http.StatusTooManyRequests))
 
-			endpointsJson, err := json.Marshal(endpoints)
+			connectionState = newConnectionState(certManager, "cluster2"+apiserver.ClientCommonNameSuffix)
+			req, _ := http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
+			req.TLS = connectionState
+			req.Header.Add(apiserver.HeaderClusterName, "cluster2")
+			Expect(executeRequest(req, server).Code).ShouldNot(Equal(http.StatusTooManyRequests))
+		})
+	})
+
+	Context("With notifier", func() {
+		var events chan webhook.Event
+
+		BeforeEach(func() {
+			events = make(chan webhook.Event, 10)
+
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				CertManager: certManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+				Notifier: notifierFunc(func(event webhook.Event) {
+					events <- event
+				}),
+			})
+			Expect(err).Should(BeNil())
+		})
+
+		It("notifies when a cluster joins with token and exports endpoints for the first time", func() {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+				Subject: clusterName,
+			})
+			clusterToken, err := token.SignedString(privateKey)
 			Expect(err).Should(BeNil())
 
-			reqBody := bytes.NewBuffer(endpointsJson)
-			req, _ := http.NewRequest("PUT", apiserver.URLUpdateEndpoints, reqBody)
+			_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr)))
+			req.Header.Add("Authorization", "bearer "+clusterToken)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusOK))
+
+			var event webhook.Event
+			Expect(events).Should(Receive(&event))
+			Expect(event.Type).Should(Equal(webhook.EventClusterJoined))
+			Expect(event.Cluster).Should(Equal(clusterName))
+
+			updateEndpoints := func() {
+				endpointsJson, err := json.Marshal([]apis.Endpoint{childConnector})
+				Expect(err).Should(BeNil())
+
+				req, _ := http.NewRequest("PUT", apiserver.URLUpdateEndpoints, bytes.NewBuffer(endpointsJson))
+				req.TLS = newConnectionState(certManager, clusterName+apiserver.ClientCommonNameSuffix)
+				req.Header.Add(apiserver.HeaderClusterName, clusterName)
+				Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNoContent))
+			}
+
+			updateEndpoints()
+			Expect(events).Should(Receive(&event))
+			Expect(event.Type).Should(Equal(webhook.EventEndpointsExported))
+			Expect(event.Detail["endpoints"]).Should(Equal(childConnector.Name))
+
+			By("exporting endpoints again")
+			updateEndpoints()
+			Expect(events).ShouldNot(Receive())
+		})
+	})
+
+	Context("With CRL", func() {
+		var (
+			caSecret        corev1.Secret
+			crlKey          client.ObjectKey
+			connectionState *tls.ConnectionState
+		)
+
+		BeforeEach(func() {
+			caSecret = corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "fabedge-ca",
+					Namespace: "default",
+				},
+				Data: map[string][]byte{
+					secretutil.KeyCACert: certManager.GetCACertPEM(),
+					secretutil.KeyCAKey:  certutil.EncodePrivateKeyPEM(x509.MarshalPKCS1PrivateKey(privateKey)),
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), &caSecret)).Should(Succeed())
+
+			crlKey = client.ObjectKey{Name: "fabedge-crl", Namespace: "default"}
+			var err error
+			server, err = apiserver.New(apiserver.Config{
+				Addr:        "localhost:8080",
+				CertManager: certManager,
+				Client:      k8sClient,
+				Store:       store,
+				Log:         klogr.New(),
+				CRL: &crlpkg.Manager{
+					SecretKey:   crlKey,
+					CASecretKey: client.ObjectKeyFromObject(&caSecret),
+					Client:      k8sClient,
+				},
+			})
+			Expect(err).Should(BeNil())
+
+			connectionState = newConnectionState(certManager, clusterName+apiserver.ClientCommonNameSuffix)
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(context.Background(), &caSecret)).Should(Succeed())
+			crlSecret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: crlKey.Name, Namespace: crlKey.Namespace}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), &crlSecret))).Should(Succeed())
+		})
+
+		revoke := func(commonName, serialNumber string) int {
+			body, _ := json.Marshal(apiserver.RevokeRequest{SerialNumber: serialNumber})
+			req, _ := http.NewRequest("POST", apiserver.URLRevocations, bytes.NewBuffer(body))
+			req.TLS = newConnectionState(certManager, commonName)
+
+			return executeRequest(req, server).Code
+		}
+
+		heartbeat := func() int {
+			req, _ := http.NewRequest("PUT", apiserver.URLHeartbeat, nil)
 			req.TLS = connectionState
 			req.Header.Add(apiserver.HeaderClusterName, clusterName)
 
+			return executeRequest(req, server).Code
+		}
+
+		It("can revoke a certificate which is rejected afterwards", func() {
+			Expect(heartbeat()).Should(Equal(http.StatusNoContent))
+
+			req, _ := http.NewRequest("GET", apiserver.URLGetCRL, nil)
+			Expect(executeRequest(req, server).Code).Should(Equal(http.StatusNotFound))
+
+			serialNumber := crlpkg.FormatSerialNumber(connectionState.PeerCertificates[0].SerialNumber)
+			Expect(revoke("client", serialNumber)).Should(Equal(http.StatusForbidden))
+			Expect(revoke(apiserver.AdminCommonName, serialNumber)).Should(Equal(http.StatusNoContent))
+			Expect(heartbeat()).Should(Equal(http.StatusUnauthorized))
+
+			By("listing revoked certificates")
+			req, _ = http.NewRequest("GET", apiserver.URLRevocations, nil)
+			req.TLS = newConnectionState(certManager, apiserver.AdminCommonName)
 			resp := executeRequest(req, server)
-			Expect(resp.Code).Should(Equal(http.StatusNoContent))
+			Expect(resp.Code).Should(Equal(http.StatusOK))
 
-			err = k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)
+			var revocations []crlpkg.Revocation
+			Expect(json.Unmarshal(resp.Body.Bytes(), &revocations)).Should(Succeed())
+			Expect(revocations).Should(HaveLen(1))
+			Expect(revocations[0].SerialNumber).Should(Equal(serialNumber))
+
+			By("getting CRL signed by CA")
+			req, _ = http.NewRequest("GET", apiserver.URLGetCRL, nil)
+			resp = executeRequest(req, server)
+			Expect(resp.Code).Should(Equal(http.StatusOK))
+
+			crls, err := certutil.DecodeCRLsPEM(resp.Body.Bytes())
 			Expect(err).Should(BeNil())
+			Expect(crls).Should(HaveLen(1))
+			Expect(certManager.GetCACert().CheckCRLSignature(crls[0])).Should(Succeed())
+			Expect(crls[0].TBSCertList.RevokedCertificates).Should(HaveLen(1))
+			Expect(crls[0].TBSCertList.RevokedCertificates[0].SerialNumber).Should(Equal(connectionState.PeerCertificates[0].SerialNumber))
 
-			Expect(cluster.Spec.EndPoints).Should(ConsistOf(childConnector))
+			By("revoking the same certificate again")
+			Expect(revoke(apiserver.AdminCommonName, serialNumber)).Should(Equal(http.StatusNoContent))
 		})
 
-		It("can sign cert for child cluster", func() {
-			_, csr, err := certutil.NewCertRequest(certutil.Request{
-				CommonName:   "test",
-				Organization: []string{"test"},
+		It("rejects invalid serial numbers", func() {
+			Expect(revoke(apiserver.AdminCommonName, "not-a-number")).Should(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("With agent certificate", func() {
+		var (
+			tlsSecret       corev1.Secret
+			agentKey        crypto.Signer
+			connectionState *tls.ConnectionState
+		)
+
+		BeforeEach(func() {
+			keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
+				CommonName:   "cluster1.edge1",
+				Organization: []string{certutil.DefaultOrganization},
 			})
 			Expect(err).Should(BeNil())
 
-			reqBody := bytes.NewBuffer(certutil.EncodeCertRequestPEM(csr))
-			req, _ := http.NewRequest("POST", apiserver.URLSignCERT, reqBody)
-			req.TLS = connectionState
+			agentKey, err = certutil.ParsePrivateKey(keyDER)
+			Expect(err).Should(BeNil())
 
-			resp := executeRequest(req, server)
+			certDER, err := certManager.SignCert(csr)
+			Expect(err).Should(BeNil())
+
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			connectionState = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+			tlsSecret = secretutil.TLSSecret().
+				Name("fabedge-agent-tls-edge1").
+				Namespace("default").
+				EncodeCert(certDER).
+				EncodeKey(keyDER).
+				CACertPEM(certManager.GetCACertPEM()).
+				Label(constants.KeyCreatedBy, constants.AppOperator).
+				Label(constants.KeyNode, "edge1").
+				Build()
+			Expect(k8sClient.Create(context.Background(), &tlsSecret)).Should(Succeed())
+
+			server, err = apiserver.New(apiserver.Config{
+				Addr:             "localhost:8080",
+				CertManager:      certManager,
+				Client:           k8sClient,
+				Store:            store,
+				Log:              klogr.New(),
+				AgentCertManager: certutil.WithValidPeriod(certManager, time.Hour),
+				AgentNamespace:   "default",
+			})
+			Expect(err).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(context.Background(), &tlsSecret)).Should(Succeed())
+		})
+
+		renew := func(state *tls.ConnectionState, key crypto.Signer, commonName string) *httptest.ResponseRecorder {
+			csr, err := certutil.NewCertRequestFromKey(key, certutil.Request{CommonName: commonName})
+			Expect(err).Should(BeNil())
+
+			req, _ := http.NewRequest(http.MethodPost, apiserver.URLRenewAgentCert, bytes.NewReader(certutil.EncodeCertRequestPEM(csr)))
+			req.TLS = state
+
+			return executeRequest(req, server)
+		}
+
+		It("can renew certificate of agent without changing its key", func() {
+			resp := renew(connectionState, agentKey, "cluster1.edge1")
 			Expect(resp.Code).Should(Equal(http.StatusOK))
 
-			content, err := ioutil.ReadAll(resp.Body)
+			certDER, err := certutil.DecodePEM(resp.Body.Bytes())
 			Expect(err).Should(BeNil())
 
-			certDER, err := certutil.DecodePEM(content)
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			Expect(cert.Subject.CommonName).Should(Equal("cluster1.edge1"))
+			Expect(cert.PublicKey).Should(Equal(agentKey.Public()))
+			Expect(cert.NotAfter.Sub(cert.NotBefore)).Should(BeNumerically("~", time.Hour, time.Second))
+
+			var secret corev1.Secret
+			Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(&tlsSecret), &secret)).Should(Succeed())
+			Expect(secretutil.GetCert(secret)).Should(Equal(resp.Body.Bytes()))
+			Expect(secret.Data[corev1.TLSPrivateKeyKey]).Should(Equal(tlsSecret.Data[corev1.TLSPrivateKeyKey]))
+
+			By("renewing with the replaced certificate")
+			resp = renew(connectionState, agentKey, "cluster1.edge1")
+			Expect(resp.Code).Should(Equal(http.StatusForbidden))
+		})
+
+		It("rejects certificate requests which don't match client certificate", func() {
+			otherKey, err := certutil.NewPrivateKey(certutil.KeyTypeECDSA, 0)
+			Expect(err).Should(BeNil())
+
+			Expect(renew(connectionState, otherKey, "cluster1.edge1").Code).Should(Equal(http.StatusForbidden))
+			Expect(renew(connectionState, agentKey, "cluster1.edge2").Code).Should(Equal(http.StatusForbidden))
+		})
+
+		It("rejects certificates which are not in TLS secrets of agents", func() {
+			csr, err := certutil.NewCertRequestFromKey(agentKey, certutil.Request{CommonName: "cluster1.edge1"})
+			Expect(err).Should(BeNil())
+
+			certDER, err := certManager.SignCert(csr)
 			Expect(err).Should(BeNil())
 
 			cert, err := x509.ParseCertificate(certDER)
 			Expect(err).Should(BeNil())
-			Expect(certManager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+
+			state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			Expect(renew(state, agentKey, "cluster1.edge1").Code).Should(Equal(http.StatusForbidden))
 		})
 	})
 
@@ -292,7 +1496,7 @@ var _ = Describe("APIServer", func() {
 
 		It("response unauthorized for signCert request", func() {
 			_, csr, err := certutil.NewCertRequest(certutil.Request{
-				CommonName:   "test",
+				CommonName:   clusterName + apiserver.ClientCommonNameSuffix,
 				Organization: []string{"test"},
 			})
 			Expect(err).Should(BeNil())
@@ -306,9 +1510,29 @@ var _ = Describe("APIServer", func() {
 	})
 })
 
+type notifierFunc func(event webhook.Event)
+
+func (fn notifierFunc) Notify(event webhook.Event) {
+	fn(event)
+}
+
 func executeRequest(req *http.Request, s *http.Server) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	s.Handler.ServeHTTP(rr, req)
 
 	return rr
 }
+
+func newConnectionState(certManager certutil.Manager, commonName string) *tls.ConnectionState {
+	certDER, _, err := certManager.NewCertKey(certutil.Config{
+		CommonName:     commonName,
+		Organization:   []string{certutil.DefaultOrganization},
+		ValidityPeriod: time.Hour,
+	})
+	Expect(err).Should(BeNil())
+
+	cert, err := x509.ParseCertificate(certDER)
+	Expect(err).Should(BeNil())
+
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+type clusterKey struct{}
+
+// authorizeCluster only allows member clusters to access their own resources, the cluster is
+// derived from the common name of client certificate and a different cluster in header is forbidden.
+// It must be used after verifyCert
+func (cfg Config) authorizeCluster(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		clusterName, ok := getClusterFromCert(r)
+		if !ok {
+			cfg.response(w, http.StatusForbidden, "only member clusters are allowed")
+			return
+		}
+
+		if name := r.Header.Get(HeaderClusterName); name != "" && name != clusterName {
+			cfg.response(w, http.StatusForbidden, fmt.Sprintf("certificate is not issued to cluster %s", name))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clusterKey{}, clusterName)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// getClusterFromCert returns the member cluster which the client certificate is issued to,
+// false is returned if the certificate doesn't belong to a member cluster
+func getClusterFromCert(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	return getClusterOfCert(r.TLS.PeerCertificates[0])
+}
+
+// getClusterOfCert returns the member cluster which cert is issued to, false is returned
+// if cert doesn't belong to a member cluster
+func getClusterOfCert(cert *x509.Certificate) (string, bool) {
+	commonName := cert.Subject.CommonName
+	if !strings.HasSuffix(commonName, ClientCommonNameSuffix) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(commonName, ClientCommonNameSuffix), true
+}
+
+// IsSuspended checks if a cluster is in the deny-list, which means requests from it are denied
+func IsSuspended(cluster apis.Cluster) bool {
+	return cluster.Annotations[constants.KeySuspended] == "true"
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+// EndpointsDelta is the changes of a cluster's endpoints since the endpoints
+// identified by BaseRevision
+type EndpointsDelta struct {
+	BaseRevision string `json:"baseRevision"`
+	// Updated contains endpoints which are added or changed
+	Updated []apis.Endpoint `json:"updated,omitempty"`
+	// Deleted contains names of endpoints which are deleted
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// GetEndpointsRevision returns a revision of endpoints which doesn't depend on their order
+func GetEndpointsRevision(endpoints []apis.Endpoint) string {
+	sorted := make([]apis.Endpoint, len(endpoints))
+	copy(sorted, endpoints)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	content, _ := json.Marshal(sorted)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+// NewEndpointsDelta computes changes from oldEndpoints to newEndpoints
+func NewEndpointsDelta(oldEndpoints, newEndpoints []apis.Endpoint) EndpointsDelta {
+	delta := EndpointsDelta{
+		BaseRevision: GetEndpointsRevision(oldEndpoints),
+	}
+
+	oldEndpointMap := make(map[string]apis.Endpoint, len(oldEndpoints))
+	for _, ep := range oldEndpoints {
+		oldEndpointMap[ep.Name] = ep
+	}
+
+	for _, ep := range newEndpoints {
+		oldEndpoint, ok := oldEndpointMap[ep.Name]
+		if !ok || !reflect.DeepEqual(oldEndpoint, ep) {
+			delta.Updated = append(delta.Updated, ep)
+		}
+		delete(oldEndpointMap, ep.Name)
+	}
+
+	for name := range oldEndpointMap {
+		delta.Deleted = append(delta.Deleted, name)
+	}
+	sort.Strings(delta.Deleted)
+
+	return delta
+}
+
+// IsEmpty returns true if there is no change in delta
+func (d EndpointsDelta) IsEmpty() bool {
+	return len(d.Updated) == 0 && len(d.Deleted) == 0
+}
+
+// Apply applies changes in delta to endpoints and returns the result, endpoints is not modified
+func (d EndpointsDelta) Apply(endpoints []apis.Endpoint) []apis.Endpoint {
+	updated := make(map[string]apis.Endpoint, len(d.Updated))
+	for _, ep := range d.Updated {
+		updated[ep.Name] = ep
+	}
+
+	deleted := make(map[string]bool, len(d.Deleted))
+	for _, name := range d.Deleted {
+		deleted[name] = true
+	}
+
+	result := make([]apis.Endpoint, 0, len(endpoints)+len(d.Updated))
+	for _, ep := range endpoints {
+		if deleted[ep.Name] {
+			continue
+		}
+
+		if newEndpoint, ok := updated[ep.Name]; ok {
+			ep = newEndpoint
+			delete(updated, ep.Name)
+		}
+		result = append(result, ep)
+	}
+
+	// keep the order of added endpoints
+	for _, ep := range d.Updated {
+		if _, ok := updated[ep.Name]; ok {
+			result = append(result, ep)
+		}
+	}
+
+	return result
+}
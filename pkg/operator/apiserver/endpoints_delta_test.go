@@ -0,0 +1,62 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+)
+
+var _ = Describe("EndpointsDelta", func() {
+	var e1, e2, e3 apis.Endpoint
+
+	BeforeEach(func() {
+		e1 = apis.Endpoint{Name: "cluster1.connector", PublicAddresses: []string{"10.1.1.1"}}
+		e2 = apis.Endpoint{Name: "cluster1.edge1", PublicAddresses: []string{"10.1.1.2"}}
+		e3 = apis.Endpoint{Name: "cluster1.edge2", PublicAddresses: []string{"10.1.1.3"}}
+	})
+
+	It("revision should not depend on order of endpoints", func() {
+		Expect(apiserver.GetEndpointsRevision([]apis.Endpoint{e1, e2})).
+			Should(Equal(apiserver.GetEndpointsRevision([]apis.Endpoint{e2, e1})))
+		Expect(apiserver.GetEndpointsRevision([]apis.Endpoint{e1, e2})).
+			ShouldNot(Equal(apiserver.GetEndpointsRevision([]apis.Endpoint{e1, e3})))
+	})
+
+	It("can compute changes and apply them", func() {
+		oldEndpoints := []apis.Endpoint{e1, e2}
+
+		newE1 := e1
+		newE1.PublicAddresses = []string{"10.1.1.10"}
+		newEndpoints := []apis.Endpoint{newE1, e3}
+
+		delta := apiserver.NewEndpointsDelta(oldEndpoints, newEndpoints)
+		Expect(delta.BaseRevision).Should(Equal(apiserver.GetEndpointsRevision(oldEndpoints)))
+		Expect(delta.Updated).Should(ConsistOf(newE1, e3))
+		Expect(delta.Deleted).Should(ConsistOf(e2.Name))
+		Expect(delta.IsEmpty()).Should(BeFalse())
+
+		Expect(delta.Apply(oldEndpoints)).Should(Equal(newEndpoints))
+		Expect(oldEndpoints).Should(Equal([]apis.Endpoint{e1, e2}))
+	})
+
+	It("should be empty if nothing changes", func() {
+		delta := apiserver.NewEndpointsDelta([]apis.Endpoint{e1, e2}, []apis.Endpoint{e2, e1})
+		Expect(delta.IsEmpty()).Should(BeTrue())
+	})
+})
@@ -0,0 +1,413 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver/proto"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
+)
+
+// MetadataAuthorization is the metadata key of token in gRPC requests, its value is
+// "bearer <token>" like the authorization header of HTTP requests
+const MetadataAuthorization = "authorization"
+
+// grpcServer serves the gRPC variant of API, see proto/operator.proto. It shares Config with
+// HTTP server, so certificates and tokens are verified the same way and the same store is served
+type grpcServer struct {
+	proto.UnimplementedOperatorServer
+
+	cfg Config
+}
+
+// NewGRPCServer creates a gRPC server of API, opts should have the credentials made of the same
+// TLS config as HTTP server, e.g. grpc.Creds(credentials.NewTLS(tlsConfig)). HTTP/2 pings are sent
+// every StreamPingInterval to keep NAT mappings of member clusters which are watching
+func NewGRPCServer(cfg Config, opts ...grpc.ServerOption) *grpc.Server {
+	pingInterval := cfg.StreamPingInterval
+	if pingInterval <= 0 {
+		pingInterval = DefaultStreamPingInterval
+	}
+
+	opts = append(opts,
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: pingInterval}),
+		grpc.UnaryInterceptor(cfg.interceptUnary),
+		grpc.StreamInterceptor(cfg.interceptStream),
+	)
+
+	server := grpc.NewServer(opts...)
+	proto.RegisterOperatorServer(server, &grpcServer{cfg: cfg})
+
+	return server
+}
+
+func (s *grpcServer) SignCert(ctx context.Context, req *proto.SignCertRequest) (resp *proto.SignCertResponse, err error) {
+	defer func() {
+		result := CertSignResultFailed
+		if err == nil {
+			result = CertSignResultSigned
+		}
+		CertSignTotal.WithLabelValues(result).Inc()
+	}()
+
+	cfg := s.cfg
+	var user, clusterName string
+	cert := getPeerCert(ctx)
+	if cert != nil {
+		if err = cfg.verifyClientCert(ctx, cert); err != nil {
+			return nil, toGRPCError(err)
+		}
+
+		// only member clusters renew their certificates here, certificates of agents and admin can't be used to sign any
+		var ok bool
+		if clusterName, ok = getClusterOfCert(cert); !ok {
+			return nil, status.Error(codes.PermissionDenied, "only member clusters are allowed to sign certificates")
+		}
+		user = cert.Subject.CommonName
+	} else {
+		if clusterName, err = cfg.authorizeToken(ctx, getMetadata(ctx, MetadataAuthorization)); err != nil {
+			return nil, toGRPCError(err)
+		}
+		user = "token:" + clusterName
+	}
+
+	var certDER []byte
+	if certDER, err = cfg.signClusterCert(ctx, getPeerIP(ctx), req.Csr, user, clusterName); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	if cert == nil {
+		cfg.notify(webhook.EventClusterJoined, clusterName, nil)
+	}
+
+	return &proto.SignCertResponse{Cert: certDER}, nil
+}
+
+func (s *grpcServer) UpdateEndpoints(ctx context.Context, req *proto.UpdateEndpointsRequest) (*proto.UpdateEndpointsResponse, error) {
+	cfg := s.cfg
+	clusterName, err := cfg.authenticateCluster(ctx, req.Cluster)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	endpoints := fromProtoEndpoints(req.Endpoints)
+	if err = cfg.saveEndpoints(ctx, clusterName, endpoints); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	cfg.recordAudit(ctx, getPeerIP(ctx), audit.ActionUpdateEndpoints, clusterName, getPeerCert(ctx).Subject.CommonName, map[string]string{
+		"endpoints": strings.Join(getEndpointNames(endpoints), ","),
+	})
+
+	return &proto.UpdateEndpointsResponse{}, nil
+}
+
+func (s *grpcServer) GetEndpointsAndCommunities(ctx context.Context, req *proto.GetEndpointsAndCommunitiesRequest) (*proto.EndpointsAndCommunities, error) {
+	cfg := s.cfg
+	if cfg.IsLeader != nil && !cfg.IsLeader() {
+		return nil, status.Error(codes.Unavailable, "endpoints and communities are only served by leader")
+	}
+
+	clusterName, err := cfg.authenticateCluster(ctx, req.Cluster)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	var cluster apis.Cluster
+	if err = cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "unknown cluster %s", clusterName)
+		}
+		return nil, toGRPCError(err)
+	}
+
+	return toProtoEndpointsAndCommunities(cfg.getEndpointsAndCommunityOf(cluster)), nil
+}
+
+// WatchEndpointsAndCommunities sends endpoints and communities needed by requesting cluster when the
+// stream starts and each time they change. The stream ends after StreamMaxAge or if the cluster can't
+// be found or is suspended, the member cluster will find out why when it calls again
+func (s *grpcServer) WatchEndpointsAndCommunities(req *proto.GetEndpointsAndCommunitiesRequest, stream proto.Operator_WatchEndpointsAndCommunitiesServer) error {
+	cfg := s.cfg
+	if cfg.IsLeader != nil && !cfg.IsLeader() {
+		return status.Error(codes.Unavailable, "endpoints and communities are only served by leader")
+	}
+
+	ctx := stream.Context()
+	clusterName, err := cfg.authenticateCluster(ctx, req.Cluster)
+	if err != nil {
+		return toGRPCError(err)
+	}
+
+	streamMaxAge := cfg.StreamMaxAge
+	if streamMaxAge <= 0 {
+		streamMaxAge = DefaultStreamMaxAge
+	}
+	maxAge := time.NewTimer(streamMaxAge)
+	defer maxAge.Stop()
+
+	var etag string
+	for {
+		// get the channel before reading store, so no change will be missed
+		changed := cfg.Store.Changed()
+
+		var cluster apis.Cluster
+		if err := cfg.Client.Get(ctx, client.ObjectKey{Name: clusterName}, &cluster); err != nil {
+			if errors.IsNotFound(err) {
+				return status.Errorf(codes.NotFound, "unknown cluster %s", clusterName)
+			}
+			return toGRPCError(err)
+		}
+
+		if cluster.DeletionTimestamp != nil || IsSuspended(cluster) {
+			return nil
+		}
+
+		ea := cfg.getEndpointsAndCommunityOf(cluster)
+		content, _ := json.Marshal(ea)
+		if newETag := getETag(content); newETag != etag {
+			if err := stream.Send(toProtoEndpointsAndCommunities(ea)); err != nil {
+				cfg.Log.V(5).Info("failed to send endpoints and communities", "cluster", clusterName, "error", err)
+				return err
+			}
+			etag = newETag
+		}
+
+		select {
+		case <-changed:
+		case <-maxAge.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// authenticateCluster verifies client certificate of ctx and returns the member cluster which it's
+// issued to, if requestedCluster is not empty, it must be the same cluster
+func (cfg Config) authenticateCluster(ctx context.Context, requestedCluster string) (string, error) {
+	cert := getPeerCert(ctx)
+	if cert == nil {
+		return "", newStatusError(http.StatusUnauthorized, "a client certificate is required")
+	}
+
+	if err := cfg.verifyClientCert(ctx, cert); err != nil {
+		return "", err
+	}
+
+	clusterName, ok := getClusterOfCert(cert)
+	if !ok {
+		return "", newStatusError(http.StatusForbidden, "only member clusters are allowed")
+	}
+
+	if requestedCluster != "" && requestedCluster != clusterName {
+		return "", newStatusError(http.StatusForbidden, "certificate is not issued to cluster %s", requestedCluster)
+	}
+
+	return clusterName, nil
+}
+
+// interceptUnary limits calls of each client if RateLimiter is set and writes an access log for each call
+func (cfg Config) interceptUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	if cfg.RateLimiter != nil && !cfg.RateLimiter.Allow(getPeerID(ctx)) {
+		err := status.Error(codes.ResourceExhausted, "too many requests")
+		cfg.logCall(ctx, info.FullMethod, err, start)
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+	cfg.logCall(ctx, info.FullMethod, err, start)
+	return resp, err
+}
+
+// interceptStream writes an access log for each stream, streams are not limited because they are long-lived
+func (cfg Config) interceptStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	cfg.logCall(ss.Context(), info.FullMethod, err, start)
+	return err
+}
+
+func (cfg Config) logCall(ctx context.Context, method string, err error, start time.Time) {
+	cfg.Log.Info("access", "method", method, "code", status.Code(err).String(),
+		"client", getPeerID(ctx), "sourceIP", getPeerIP(ctx), "duration", time.Since(start).String())
+}
+
+// toGRPCError converts err to a gRPC status error, the HTTP status code of statusError
+// is mapped to the closest gRPC code, other errors are internal errors
+func toGRPCError(err error) error {
+	se, ok := err.(*statusError)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code := codes.Unknown
+	switch se.statusCode {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+
+	return status.Error(code, se.message)
+}
+
+// getPeerCert returns the client certificate of a gRPC call, nil is returned if there is none
+func getPeerCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.PeerCertificates[0]
+}
+
+// getPeerID returns the common name of client certificate, or source IP if
+// no client certificate is provided
+func getPeerID(ctx context.Context) string {
+	if cert := getPeerCert(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+
+	return getPeerIP(ctx)
+}
+
+func getPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func getMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func toProtoEndpointsAndCommunities(ea EndpointsAndCommunity) *proto.EndpointsAndCommunities {
+	communities := make(map[string]*proto.Community, len(ea.Communities))
+	for name, members := range ea.Communities {
+		communities[name] = &proto.Community{Members: members}
+	}
+
+	return &proto.EndpointsAndCommunities{
+		Communities: communities,
+		Endpoints:   ToProtoEndpoints(ea.Endpoints),
+	}
+}
+
+// ToProtoEndpoints converts endpoints to their protobuf messages
+func ToProtoEndpoints(endpoints []apis.Endpoint) []*proto.Endpoint {
+	messages := make([]*proto.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		messages = append(messages, &proto.Endpoint{
+			Id:              ep.ID,
+			Name:            ep.Name,
+			PublicAddresses: ep.PublicAddresses,
+			Subnets:         ep.Subnets,
+			NodeSubnets:     ep.NodeSubnets,
+			Type:            string(ep.Type),
+			RelayOnly:       ep.RelayOnly,
+		})
+	}
+
+	return messages
+}
+
+// FromProtoEndpointsAndCommunities converts protobuf message of endpoints and communities to EndpointsAndCommunity
+func FromProtoEndpointsAndCommunities(message *proto.EndpointsAndCommunities) EndpointsAndCommunity {
+	var ea EndpointsAndCommunity
+	if len(message.Communities) > 0 {
+		ea.Communities = make(map[string][]string, len(message.Communities))
+		for name, community := range message.Communities {
+			ea.Communities[name] = community.GetMembers()
+		}
+	}
+	ea.Endpoints = fromProtoEndpoints(message.Endpoints)
+
+	return ea
+}
+
+func fromProtoEndpoints(messages []*proto.Endpoint) []apis.Endpoint {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	endpoints := make([]apis.Endpoint, 0, len(messages))
+	for _, m := range messages {
+		endpoints = append(endpoints, apis.Endpoint{
+			ID:              m.Id,
+			Name:            m.Name,
+			PublicAddresses: m.PublicAddresses,
+			Subnets:         m.Subnets,
+			NodeSubnets:     m.NodeSubnets,
+			Type:            apis.EndpointType(m.Type),
+			RelayOnly:       m.RelayOnly,
+		})
+	}
+
+	return endpoints
+}
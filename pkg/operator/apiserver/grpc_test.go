@@ -0,0 +1,339 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver/proto"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("GRPCServer", func() {
+	var (
+		store                         storepkg.Interface
+		certManager                   certutil.Manager
+		clusterName                   string
+		rootConnector                 apis.Endpoint
+		childEndpoint, childConnector apis.Endpoint
+		community                     apis.Community
+		cluster                       apis.Cluster
+		caKey                         interface{}
+		isLeader                      bool
+		server                        *grpc.Server
+		listener                      *bufconn.Listener
+		conns                         []*grpc.ClientConn
+	)
+
+	BeforeEach(func() {
+		clusterName = "cluster1"
+		isLeader = true
+		conns = nil
+
+		store = storepkg.NewStore()
+		rootConnector = apis.Endpoint{
+			ID:              "cluster2.connector",
+			Name:            "cluster2.connector",
+			PublicAddresses: []string{"10.40.1.1"},
+			Subnets:         []string{"2.5.0.0/16"},
+			NodeSubnets:     []string{"192.168.1.3/32"},
+			Type:            apis.Connector,
+		}
+		childEndpoint = apis.Endpoint{
+			ID:              "cluster1.edge1",
+			Name:            "cluster1.edge1",
+			PublicAddresses: []string{"10.1.1.1"},
+			Subnets:         []string{"2.2.1.1/24"},
+			NodeSubnets:     []string{"10.10.1.1/32"},
+			Type:            apis.EdgeNode,
+			RelayOnly:       true,
+		}
+		childConnector = apis.Endpoint{
+			ID:              "cluster1.connector",
+			Name:            "cluster1.connector",
+			PublicAddresses: []string{"10.1.1.2"},
+			Subnets:         []string{"2.2.1.65/24"},
+			NodeSubnets:     []string{"10.10.1.2/32"},
+			Type:            apis.Connector,
+		}
+		store.SaveEndpoint(rootConnector)
+		store.SaveEndpoint(childEndpoint)
+		store.SaveEndpoint(childConnector)
+
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		caKey, err = x509.ParsePKCS1PrivateKey(caKeyDER)
+		Expect(err).Should(BeNil())
+
+		certManager, err = certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		cluster = apis.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: clusterName,
+			},
+			Spec: apis.ClusterSpec{
+				EndPoints: []apis.Endpoint{childConnector, childEndpoint},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &cluster)).Should(Succeed())
+
+		community = apis.Community{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "connectors",
+			},
+			Spec: apis.CommunitySpec{
+				Members: []string{childConnector.Name, rootConnector.Name},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &community)).Should(Succeed())
+		store.SaveCommunity(types.Community{
+			Name:    community.Name,
+			Members: sets.NewString(community.Spec.Members...),
+		})
+
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(certManager.GetCACertPEM())
+		server = apiserver.NewGRPCServer(apiserver.Config{
+			CertManager:  certManager,
+			Client:       k8sClient,
+			Store:        store,
+			Log:          klogr.New(),
+			IsLeader:     func() bool { return isLeader },
+			StreamMaxAge: time.Minute,
+		}, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{newTLSCertificate(certManager, "localhost")},
+			ClientCAs:    certPool,
+			ClientAuth:   tls.RequestClientCert,
+		})))
+
+		listener = bufconn.Listen(1024 * 1024)
+		go func() {
+			_ = server.Serve(listener)
+		}()
+	})
+
+	AfterEach(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		server.Stop()
+
+		Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), &cluster))).Should(Succeed())
+		Expect(k8sClient.Delete(context.Background(), &community)).Should(Succeed())
+	})
+
+	// dial connects to gRPC server with a client certificate of commonName, no certificate is
+	// used if commonName is empty
+	dial := func(commonName string) proto.OperatorClient {
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(certManager.GetCACertPEM())
+
+		tlsConfig := &tls.Config{
+			RootCAs:    certPool,
+			ServerName: "localhost",
+		}
+		if commonName != "" {
+			tlsConfig.Certificates = []tls.Certificate{newTLSCertificate(certManager, commonName)}
+		}
+
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+				return listener.Dial()
+			}),
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		)
+		Expect(err).Should(BeNil())
+		conns = append(conns, conn)
+
+		return proto.NewOperatorClient(conn)
+	}
+
+	newCSR := func(commonName string) []byte {
+		_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: commonName})
+		Expect(err).Should(BeNil())
+		return csr
+	}
+
+	It("can sign cert for member cluster by token in metadata", func() {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{Subject: clusterName}).SignedString(caKey)
+		Expect(err).Should(BeNil())
+
+		cli := dial("")
+		ctx := metadata.AppendToOutgoingContext(context.Background(), apiserver.MetadataAuthorization, "bearer "+token)
+		resp, err := cli.SignCert(ctx, &proto.SignCertRequest{Csr: newCSR(clusterName + apiserver.ClientCommonNameSuffix)})
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(resp.Cert)
+		Expect(err).Should(BeNil())
+		Expect(cert.Subject.CommonName).Should(Equal(clusterName + apiserver.ClientCommonNameSuffix))
+		Expect(certManager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+
+		By("signing cert of another cluster")
+		_, err = cli.SignCert(ctx, &proto.SignCertRequest{Csr: newCSR("cluster2" + apiserver.ClientCommonNameSuffix)})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+
+		By("signing cert without token")
+		_, err = cli.SignCert(context.Background(), &proto.SignCertRequest{Csr: newCSR(clusterName + apiserver.ClientCommonNameSuffix)})
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+	})
+
+	It("can renew cert of member cluster by its client certificate", func() {
+		cli := dial(clusterName + apiserver.ClientCommonNameSuffix)
+		resp, err := cli.SignCert(context.Background(), &proto.SignCertRequest{Csr: newCSR(clusterName + apiserver.ClientCommonNameSuffix)})
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(resp.Cert)
+		Expect(err).Should(BeNil())
+		Expect(cert.Subject.CommonName).Should(Equal(clusterName + apiserver.ClientCommonNameSuffix))
+
+		By("signing cert by a certificate which is not issued to member clusters")
+		cli = dial(apiserver.AdminCommonName)
+		_, err = cli.SignCert(context.Background(), &proto.SignCertRequest{Csr: newCSR(clusterName + apiserver.ClientCommonNameSuffix)})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+	})
+
+	It("can get endpoints and communities needed for a cluster", func() {
+		cli := dial(clusterName + apiserver.ClientCommonNameSuffix)
+		resp, err := cli.GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(err).Should(BeNil())
+
+		ea := apiserver.FromProtoEndpointsAndCommunities(resp)
+		Expect(ea.Endpoints).Should(ConsistOf(rootConnector))
+		Expect(ea.Communities[community.Name]).Should(ConsistOf(rootConnector.Name, childConnector.Name))
+
+		By("requesting endpoints and communities of another cluster")
+		_, err = cli.GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{Cluster: "cluster2"})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+
+		By("requesting without client certificate")
+		_, err = dial("").GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+
+		By("requesting from a replica which is not leader")
+		isLeader = false
+		_, err = cli.GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(status.Code(err)).Should(Equal(codes.Unavailable))
+	})
+
+	It("can update endpoints of requesting cluster", func() {
+		cli := dial(clusterName + apiserver.ClientCommonNameSuffix)
+		_, err := cli.UpdateEndpoints(context.Background(), &proto.UpdateEndpointsRequest{
+			Cluster:   clusterName,
+			Endpoints: apiserver.ToProtoEndpoints([]apis.Endpoint{childEndpoint}),
+		})
+		Expect(err).Should(BeNil())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+		Expect(cluster.Spec.EndPoints).Should(ConsistOf(childEndpoint))
+		Expect(cluster.Status.LastExportTime).ShouldNot(BeNil())
+
+		By("updating endpoints without any endpoint")
+		_, err = cli.UpdateEndpoints(context.Background(), &proto.UpdateEndpointsRequest{})
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+
+		By("updating endpoints of another cluster")
+		_, err = cli.UpdateEndpoints(context.Background(), &proto.UpdateEndpointsRequest{
+			Cluster:   "cluster2",
+			Endpoints: apiserver.ToProtoEndpoints([]apis.Endpoint{rootConnector}),
+		})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+	})
+
+	It("can push endpoints and communities each time they change", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cli := dial(clusterName + apiserver.ClientCommonNameSuffix)
+		stream, err := cli.WatchEndpointsAndCommunities(ctx, &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(err).Should(BeNil())
+
+		resp, err := stream.Recv()
+		Expect(err).Should(BeNil())
+		Expect(apiserver.FromProtoEndpointsAndCommunities(resp).Endpoints).Should(ConsistOf(rootConnector))
+
+		By("changing endpoint of host cluster")
+		rootConnector.PublicAddresses = []string{"10.40.1.2"}
+		store.SaveEndpoint(rootConnector)
+
+		resp, err = stream.Recv()
+		Expect(err).Should(BeNil())
+		Expect(apiserver.FromProtoEndpointsAndCommunities(resp).Endpoints).Should(ConsistOf(rootConnector))
+	})
+
+	It("denies suspended and deregistered clusters", func() {
+		cli := dial(clusterName + apiserver.ClientCommonNameSuffix)
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: clusterName}, &cluster)).Should(Succeed())
+		cluster.Annotations = map[string]string{constants.KeySuspended: "true"}
+		Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+
+		_, err := cli.GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+
+		By("deregistering cluster")
+		Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+		_, err = cli.GetEndpointsAndCommunities(context.Background(), &proto.GetEndpointsAndCommunitiesRequest{})
+		Expect(status.Code(err)).Should(Equal(codes.NotFound))
+	})
+})
+
+func newTLSCertificate(certManager certutil.Manager, commonName string) tls.Certificate {
+	certDER, keyDER, err := certManager.NewCertKey(certutil.Config{
+		CommonName:     commonName,
+		Organization:   []string{certutil.DefaultOrganization},
+		DNSNames:       []string{commonName},
+		ValidityPeriod: time.Hour,
+	})
+	Expect(err).Should(BeNil())
+
+	key, err := certutil.ParsePrivateKey(keyDER)
+	Expect(err).Should(BeNil())
+
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+	}
+}
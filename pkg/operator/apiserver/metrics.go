@@ -0,0 +1,92 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	CertSignResultSigned = "signed"
+	CertSignResultFailed = "failed"
+)
+
+var (
+	// RequestsTotal counts requests by route pattern instead of path, so URLs with
+	// cluster names or token IDs don't make too many series
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "apiserver",
+		Name:      "requests_total",
+		Help:      "Number of requests handled by API server, partitioned by method, route and status code",
+	}, []string{"method", "route", "code"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fabedge",
+		Subsystem: "apiserver",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests handled by API server, streams and watch requests are not included",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	CertSignTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "apiserver",
+		Name:      "cert_sign_total",
+		Help:      "Number of certificate signing requests, partitioned by result",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(RequestsTotal, RequestDuration, CertSignTotal)
+}
+
+// instrument records count and latency of each request
+func instrument(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			route := "other"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			RequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			// streams and watch requests last long by design, their latencies mean nothing
+			if !strings.HasSuffix(route, "/stream") && r.URL.Query().Get(QueryWatchTimeout) == "" {
+				RequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
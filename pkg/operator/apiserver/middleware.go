@@ -0,0 +1,134 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/time/rate"
+)
+
+// ClientRateLimiter limits requests of each client, a client is identified by
+// the common name of its certificate or its IP address
+type ClientRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mux      sync.Mutex
+	limiters map[string]*clientLimiter
+	lastGC   time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// limiterIdleTimeout is how long a limiter of idle client is kept
+const limiterIdleTimeout = 10 * time.Minute
+
+// NewClientRateLimiter creates a limiter which allows qps requests per second and bursts
+// of at most burst requests for each client
+func NewClientRateLimiter(qps float64, burst int) *ClientRateLimiter {
+	return &ClientRateLimiter{
+		limit:    rate.Limit(qps),
+		burst:    burst,
+		limiters: make(map[string]*clientLimiter),
+		lastGC:   time.Now(),
+	}
+}
+
+// Allow reports whether a request from client may happen now
+func (l *ClientRateLimiter) Allow(client string) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastGC) > limiterIdleTimeout {
+		for key, cl := range l.limiters {
+			if now.Sub(cl.lastSeen) > limiterIdleTimeout {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastGC = now
+	}
+
+	cl, ok := l.limiters[client]
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[client] = cl
+	}
+	cl.lastSeen = now
+
+	return cl.limiter.AllowN(now, 1)
+}
+
+func (cfg Config) rateLimit(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.RateLimiter.Allow(getClientID(r)) {
+			w.Header().Set("Retry-After", "1")
+			cfg.response(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// logAccess writes a structured access log for each request
+func (cfg Config) logAccess(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			cfg.Log.Info("access", "method", r.Method, "path", r.URL.Path, "status", status,
+				"client", getClientID(r), "sourceIP", getSourceIP(r), "cluster", cfg.getCluster(r),
+				"bytes", ww.BytesWritten(), "duration", time.Since(start).String())
+		}()
+
+		next.ServeHTTP(ww, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// getClientID returns the common name of client certificate, or source IP if
+// no client certificate is provided
+func getClientID(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	return getSourceIP(r)
+}
+
+func getSourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// URLGetOpenAPI serves the OpenAPI document which describes APIs of v1beta1
+const URLGetOpenAPI = "/api/openapi.yaml"
+
+// OpenAPI is the OpenAPI document of API server, keep it updated when APIs change
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+func (cfg Config) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(OpenAPI)
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("OpenAPI", func() {
+	var server *http.Server
+
+	BeforeEach(func() {
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		certManager, err := certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		server, err = apiserver.New(apiserver.Config{
+			Addr:             "localhost:8080",
+			Client:           k8sClient,
+			Log:              klogr.New(),
+			Tokens:           &tokenpkg.Manager{Namespace: "default", Client: k8sClient},
+			CRL:              &crlpkg.Manager{Client: k8sClient},
+			AgentCertManager: certManager,
+			AgentTokens:      &tokenpkg.AgentVerifier{Client: k8sClient},
+		})
+		Expect(err).Should(BeNil())
+	})
+
+	It("can serve the OpenAPI document", func() {
+		req := httptest.NewRequest(http.MethodGet, apiserver.URLGetOpenAPI, nil)
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, req)
+
+		Expect(w.Code).Should(Equal(http.StatusOK))
+		Expect(w.Body.Bytes()).Should(Equal(apiserver.OpenAPI))
+	})
+
+	It("should describe every route of API server and nothing else", func() {
+		var doc struct {
+			Paths map[string]map[string]interface{} `yaml:"paths"`
+		}
+		Expect(yaml.Unmarshal(apiserver.OpenAPI, &doc)).Should(Succeed())
+
+		served := make(map[string]bool)
+		err := chi.Walk(server.Handler.(chi.Routes), func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			// all versions share the same paths, so only v1beta1 paths are documented
+			path := route
+			if route != apiserver.URLGetAPIVersions && route != apiserver.URLGetOpenAPI {
+				for _, version := range apiserver.SupportedAPIVersions {
+					path = strings.TrimPrefix(path, "/api/"+version)
+				}
+				path = apiserver.VersionedURL(apiserver.APIVersionV1beta1, strings.TrimPrefix(path, "/api"))
+			}
+
+			operations, ok := doc.Paths[path]
+			Expect(ok).Should(BeTrue(), "path %s is not documented", path)
+			Expect(operations).Should(HaveKey(strings.ToLower(method)), "%s %s is not documented", method, path)
+			served[strings.ToLower(method)+" "+path] = true
+			return nil
+		})
+		Expect(err).Should(BeNil())
+
+		for path, operations := range doc.Paths {
+			for method := range operations {
+				if method == "parameters" {
+					continue
+				}
+				Expect(served).Should(HaveKey(method+" "+path), "%s %s is documented but not served", method, path)
+			}
+		}
+	})
+})
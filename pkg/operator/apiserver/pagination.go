@@ -0,0 +1,132 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	// QueryLimit is the query parameter to specify the max number of endpoints in a response
+	QueryLimit = "limit"
+	// QueryContinue is the query parameter to get the next page of endpoints, its value
+	// is the continue token of previous page
+	QueryContinue = "continue"
+)
+
+// continueToken tells where the next page starts, ETag is the etag of all endpoints and
+// communities when the first page is served, the token is expired when it changes
+type continueToken struct {
+	ETag  string `json:"etag"`
+	After string `json:"after"`
+}
+
+func encodeContinueToken(token continueToken) string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContinueToken(value string) (token continueToken, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return token, err
+	}
+
+	if err = json.Unmarshal(data, &token); err != nil {
+		return token, err
+	}
+
+	if token.ETag == "" || token.After == "" {
+		return token, fmt.Errorf("incomplete continue token")
+	}
+
+	return token, nil
+}
+
+// pagination is how a request asks for a page of endpoints, zero limit means no pagination
+type pagination struct {
+	limit int
+	token continueToken
+}
+
+func (p pagination) isContinue() bool {
+	return p.token.ETag != ""
+}
+
+func getPagination(r *http.Request) (p pagination, err error) {
+	query := r.URL.Query()
+	if value := query.Get(QueryLimit); value != "" {
+		p.limit, err = strconv.Atoi(value)
+		if err != nil || p.limit < 1 {
+			return p, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+
+	if value := query.Get(QueryContinue); value != "" {
+		if p.limit == 0 {
+			return p, fmt.Errorf("limit is required with continue token")
+		}
+
+		p.token, err = decodeContinueToken(value)
+		if err != nil {
+			return p, fmt.Errorf("invalid continue token: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// page returns the page of ea which p asks for, etag is the etag of ea. Endpoints
+// are sorted by name and communities are only returned in the first page
+func (p pagination) page(ea EndpointsAndCommunity, etag string) EndpointsAndCommunity {
+	if p.limit == 0 {
+		return ea
+	}
+
+	endpoints := make([]apis.Endpoint, len(ea.Endpoints))
+	copy(endpoints, ea.Endpoints)
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Name < endpoints[j].Name
+	})
+
+	start := 0
+	if p.isContinue() {
+		ea.Communities = nil
+		start = sort.Search(len(endpoints), func(i int) bool {
+			return endpoints[i].Name > p.token.After
+		})
+	}
+
+	end := start + p.limit
+	if end >= len(endpoints) {
+		ea.Endpoints = endpoints[start:]
+		return ea
+	}
+
+	ea.Endpoints = endpoints[start:end]
+	ea.Continue = encodeContinueToken(continueToken{
+		ETag:  etag,
+		After: endpoints[end-1].Name,
+	})
+
+	return ea
+}
@@ -0,0 +1,241 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// OperatorClient is the client API for Operator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OperatorClient interface {
+	SignCert(ctx context.Context, in *SignCertRequest, opts ...grpc.CallOption) (*SignCertResponse, error)
+	UpdateEndpoints(ctx context.Context, in *UpdateEndpointsRequest, opts ...grpc.CallOption) (*UpdateEndpointsResponse, error)
+	GetEndpointsAndCommunities(ctx context.Context, in *GetEndpointsAndCommunitiesRequest, opts ...grpc.CallOption) (*EndpointsAndCommunities, error)
+	// WatchEndpointsAndCommunities sends current endpoints and communities of the cluster,
+	// then sends them again each time they change
+	WatchEndpointsAndCommunities(ctx context.Context, in *GetEndpointsAndCommunitiesRequest, opts ...grpc.CallOption) (Operator_WatchEndpointsAndCommunitiesClient, error)
+}
+
+type operatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOperatorClient(cc grpc.ClientConnInterface) OperatorClient {
+	return &operatorClient{cc}
+}
+
+func (c *operatorClient) SignCert(ctx context.Context, in *SignCertRequest, opts ...grpc.CallOption) (*SignCertResponse, error) {
+	out := new(SignCertResponse)
+	err := c.cc.Invoke(ctx, "/fabedge.operator.v1alpha1.Operator/SignCert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *operatorClient) UpdateEndpoints(ctx context.Context, in *UpdateEndpointsRequest, opts ...grpc.CallOption) (*UpdateEndpointsResponse, error) {
+	out := new(UpdateEndpointsResponse)
+	err := c.cc.Invoke(ctx, "/fabedge.operator.v1alpha1.Operator/UpdateEndpoints", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *operatorClient) GetEndpointsAndCommunities(ctx context.Context, in *GetEndpointsAndCommunitiesRequest, opts ...grpc.CallOption) (*EndpointsAndCommunities, error) {
+	out := new(EndpointsAndCommunities)
+	err := c.cc.Invoke(ctx, "/fabedge.operator.v1alpha1.Operator/GetEndpointsAndCommunities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *operatorClient) WatchEndpointsAndCommunities(ctx context.Context, in *GetEndpointsAndCommunitiesRequest, opts ...grpc.CallOption) (Operator_WatchEndpointsAndCommunitiesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Operator_ServiceDesc.Streams[0], "/fabedge.operator.v1alpha1.Operator/WatchEndpointsAndCommunities", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &operatorWatchEndpointsAndCommunitiesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Operator_WatchEndpointsAndCommunitiesClient interface {
+	Recv() (*EndpointsAndCommunities, error)
+	grpc.ClientStream
+}
+
+type operatorWatchEndpointsAndCommunitiesClient struct {
+	grpc.ClientStream
+}
+
+func (x *operatorWatchEndpointsAndCommunitiesClient) Recv() (*EndpointsAndCommunities, error) {
+	m := new(EndpointsAndCommunities)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OperatorServer is the server API for Operator service.
+// All implementations must embed UnimplementedOperatorServer
+// for forward compatibility
+type OperatorServer interface {
+	SignCert(context.Context, *SignCertRequest) (*SignCertResponse, error)
+	UpdateEndpoints(context.Context, *UpdateEndpointsRequest) (*UpdateEndpointsResponse, error)
+	GetEndpointsAndCommunities(context.Context, *GetEndpointsAndCommunitiesRequest) (*EndpointsAndCommunities, error)
+	// WatchEndpointsAndCommunities sends current endpoints and communities of the cluster,
+	// then sends them again each time they change
+	WatchEndpointsAndCommunities(*GetEndpointsAndCommunitiesRequest, Operator_WatchEndpointsAndCommunitiesServer) error
+	mustEmbedUnimplementedOperatorServer()
+}
+
+// UnimplementedOperatorServer must be embedded to have forward compatible implementations.
+type UnimplementedOperatorServer struct {
+}
+
+func (UnimplementedOperatorServer) SignCert(context.Context, *SignCertRequest) (*SignCertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SignCert not implemented")
+}
+func (UnimplementedOperatorServer) UpdateEndpoints(context.Context, *UpdateEndpointsRequest) (*UpdateEndpointsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateEndpoints not implemented")
+}
+func (UnimplementedOperatorServer) GetEndpointsAndCommunities(context.Context, *GetEndpointsAndCommunitiesRequest) (*EndpointsAndCommunities, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEndpointsAndCommunities not implemented")
+}
+func (UnimplementedOperatorServer) WatchEndpointsAndCommunities(*GetEndpointsAndCommunitiesRequest, Operator_WatchEndpointsAndCommunitiesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEndpointsAndCommunities not implemented")
+}
+func (UnimplementedOperatorServer) mustEmbedUnimplementedOperatorServer() {}
+
+// UnsafeOperatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OperatorServer will
+// result in compilation errors.
+type UnsafeOperatorServer interface {
+	mustEmbedUnimplementedOperatorServer()
+}
+
+func RegisterOperatorServer(s grpc.ServiceRegistrar, srv OperatorServer) {
+	s.RegisterService(&Operator_ServiceDesc, srv)
+}
+
+func _Operator_SignCert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignCertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OperatorServer).SignCert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fabedge.operator.v1alpha1.Operator/SignCert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OperatorServer).SignCert(ctx, req.(*SignCertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Operator_UpdateEndpoints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateEndpointsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OperatorServer).UpdateEndpoints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fabedge.operator.v1alpha1.Operator/UpdateEndpoints",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OperatorServer).UpdateEndpoints(ctx, req.(*UpdateEndpointsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Operator_GetEndpointsAndCommunities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEndpointsAndCommunitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OperatorServer).GetEndpointsAndCommunities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fabedge.operator.v1alpha1.Operator/GetEndpointsAndCommunities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OperatorServer).GetEndpointsAndCommunities(ctx, req.(*GetEndpointsAndCommunitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Operator_WatchEndpointsAndCommunities_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetEndpointsAndCommunitiesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OperatorServer).WatchEndpointsAndCommunities(m, &operatorWatchEndpointsAndCommunitiesServer{stream})
+}
+
+type Operator_WatchEndpointsAndCommunitiesServer interface {
+	Send(*EndpointsAndCommunities) error
+	grpc.ServerStream
+}
+
+type operatorWatchEndpointsAndCommunitiesServer struct {
+	grpc.ServerStream
+}
+
+func (x *operatorWatchEndpointsAndCommunitiesServer) Send(m *EndpointsAndCommunities) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Operator_ServiceDesc is the grpc.ServiceDesc for Operator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Operator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fabedge.operator.v1alpha1.Operator",
+	HandlerType: (*OperatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SignCert",
+			Handler:    _Operator_SignCert_Handler,
+		},
+		{
+			MethodName: "UpdateEndpoints",
+			Handler:    _Operator_UpdateEndpoints_Handler,
+		},
+		{
+			MethodName: "GetEndpointsAndCommunities",
+			Handler:    _Operator_GetEndpointsAndCommunities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEndpointsAndCommunities",
+			Handler:       _Operator_WatchEndpointsAndCommunities_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/operator/apiserver/proto/operator.proto",
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
+)
+
+// RevokeRequest is the body of request to revoke a certificate
+type RevokeRequest struct {
+	// SerialNumber is in hex, e.g. the output of "openssl x509 -noout -serial"
+	SerialNumber string `json:"serialNumber"`
+}
+
+// getCRL writes CRLs which list certificates revoked by administrator, member clusters sync them
+// to their agents and connectors
+func (cfg Config) getCRL(w http.ResponseWriter, r *http.Request) {
+	crlPEM, err := cfg.CRL.GetCRLPEM(r.Context())
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(crlPEM) == 0 {
+		cfg.response(w, http.StatusNotFound, "CRL is not made yet")
+		return
+	}
+
+	w.Write(crlPEM)
+}
+
+func (cfg Config) listRevocations(w http.ResponseWriter, r *http.Request) {
+	revocations, err := cfg.CRL.List(r.Context())
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.responseJSON(w, http.StatusOK, revocations)
+}
+
+// revokeCert adds a certificate to CRLs, agents, connectors and API server reject it afterwards
+func (cfg Config) revokeCert(w http.ResponseWriter, r *http.Request) {
+	var req RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		cfg.response(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request body: %s", err))
+		return
+	}
+
+	serialNumber, err := crlpkg.ParseSerialNumber(req.SerialNumber)
+	if err != nil {
+		cfg.response(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = cfg.CRL.Revoke(r.Context(), serialNumber); err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.Log.Info("certificate is revoked", "serialNumber", crlpkg.FormatSerialNumber(serialNumber), "user", getClientID(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	// URLStream keeps a stream to member cluster, through which endpoints and communities
+	// are pushed as soon as they change. It's only served in v1beta1
+	URLStream = "/api/stream"
+
+	// DefaultStreamPingInterval is the default interval to send ping events in streams, which
+	// keeps NAT mappings of member clusters and lets them find out broken streams
+	DefaultStreamPingInterval = 15 * time.Second
+	// DefaultStreamMaxAge is how long a stream lasts at most by default, member clusters connect again
+	// after it ends, so the renewed client certificates are used and streams are balanced among replicas
+	DefaultStreamMaxAge = 10 * time.Minute
+
+	StreamEventEndpointsAndCommunities = "EndpointsAndCommunities"
+	StreamEventPing                    = "Ping"
+
+	// ContentTypeStream is the content type of streams, each line is a StreamEvent in JSON
+	ContentTypeStream = "application/x-ndjson"
+)
+
+// StreamEvent is an event sent to member cluster through stream
+type StreamEvent struct {
+	Type                    string                          `json:"type"`
+	EndpointsAndCommunities *EndpointsAndCommunitiesV1beta1 `json:"endpointsAndCommunities,omitempty"`
+}
+
+// stream sends endpoints and communities needed by requesting cluster when the stream starts and
+// each time they change, and sends ping events periodically. The stream ends after StreamMaxAge or
+// if the cluster can't be found or is suspended, the member cluster will find out why when it
+// connects again
+func (cfg Config) stream(w http.ResponseWriter, r *http.Request) {
+	if cfg.IsLeader != nil && !cfg.IsLeader() {
+		cfg.response(w, http.StatusServiceUnavailable, "endpoints and communities are only served by leader")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		cfg.response(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	clusterName := cfg.getCluster(r)
+	w.Header().Set("Content-Type", ContentTypeStream)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	send := func(event StreamEvent) bool {
+		if err := encoder.Encode(event); err != nil {
+			cfg.Log.V(5).Info("failed to send stream event", "cluster", clusterName, "error", err)
+			return false
+		}
+
+		flusher.Flush()
+		return true
+	}
+
+	pingInterval := cfg.StreamPingInterval
+	if pingInterval <= 0 {
+		pingInterval = DefaultStreamPingInterval
+	}
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	streamMaxAge := cfg.StreamMaxAge
+	if streamMaxAge <= 0 {
+		streamMaxAge = DefaultStreamMaxAge
+	}
+	maxAge := time.NewTimer(streamMaxAge)
+	defer maxAge.Stop()
+
+	var etag string
+	for {
+		// get the channel before reading store, so no change will be missed
+		changed := cfg.Store.Changed()
+
+		var cluster apis.Cluster
+		if err := cfg.Client.Get(r.Context(), client.ObjectKey{Name: clusterName}, &cluster); err != nil {
+			return
+		}
+
+		if cluster.DeletionTimestamp != nil || IsSuspended(cluster) {
+			return
+		}
+
+		ea := ToV1beta1(cfg.getEndpointsAndCommunityOf(cluster))
+		content, _ := json.Marshal(ea)
+		if newETag := getETag(content); newETag != etag {
+			if !send(StreamEvent{Type: StreamEventEndpointsAndCommunities, EndpointsAndCommunities: &ea}) {
+				return
+			}
+			etag = newETag
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			if !send(StreamEvent{Type: StreamEventPing}) {
+				return
+			}
+		case <-maxAge.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
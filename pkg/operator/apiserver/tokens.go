@@ -0,0 +1,153 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+)
+
+// QueryValidPeriod is the query parameter to specify validity duration of a minted token, e.g. 24h
+const QueryValidPeriod = "valid-period"
+
+// verifyAdmin only allows requests with admin certificate, it must be used after verifyCert.
+// The admin common name is reserved, API server never signs it, so the admin certificate can
+// only be made offline with the CA key
+func (cfg Config) verifyAdmin(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS.PeerCertificates[0].Subject.CommonName != AdminCommonName {
+			cfg.response(w, http.StatusForbidden, "only admin is allowed to manage clusters, tokens and revocations")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (cfg Config) listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := cfg.Tokens.List(r.Context(), chi.URLParam(r, "cluster"))
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.responseJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg Config) mintToken(w http.ResponseWriter, r *http.Request) {
+	clusterName, validPeriod, ok := cfg.parseMintRequest(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := cfg.Tokens.Mint(r.Context(), clusterName, validPeriod)
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.audit(r, audit.ActionMintToken, clusterName, getClientID(r), map[string]string{"id": token.ID})
+
+	cfg.responseJSON(w, http.StatusCreated, token)
+}
+
+func (cfg Config) rotateTokens(w http.ResponseWriter, r *http.Request) {
+	clusterName, validPeriod, ok := cfg.parseMintRequest(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := cfg.Tokens.Rotate(r.Context(), clusterName, validPeriod)
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.audit(r, audit.ActionRotateTokens, clusterName, getClientID(r), map[string]string{"id": token.ID})
+
+	cfg.responseJSON(w, http.StatusCreated, token)
+}
+
+func (cfg Config) revokeToken(w http.ResponseWriter, r *http.Request) {
+	clusterName, id := chi.URLParam(r, "cluster"), chi.URLParam(r, "id")
+	err := cfg.Tokens.Revoke(r.Context(), clusterName, id)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			cfg.response(w, http.StatusNotFound, "token is not found")
+			return
+		}
+
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.audit(r, audit.ActionRevokeToken, clusterName, getClientID(r), map[string]string{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseMintRequest checks if the cluster exists and parses validity duration of token,
+// if anything is wrong, an error response is written and false is returned
+func (cfg Config) parseMintRequest(w http.ResponseWriter, r *http.Request) (string, time.Duration, bool) {
+	clusterName := chi.URLParam(r, "cluster")
+
+	validPeriod := cfg.TokenValidPeriod
+	if value := r.URL.Query().Get(QueryValidPeriod); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			cfg.response(w, http.StatusBadRequest, fmt.Sprintf("invalid valid period: %s", value))
+			return "", 0, false
+		}
+		validPeriod = d
+	}
+
+	var cluster apis.Cluster
+	err := cfg.Client.Get(r.Context(), client.ObjectKey{Name: clusterName}, &cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			cfg.response(w, http.StatusNotFound, fmt.Sprintf("unknown cluster %s", clusterName))
+			return "", 0, false
+		}
+
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return "", 0, false
+	}
+
+	return clusterName, validPeriod, true
+}
+
+func (cfg Config) responseJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		cfg.response(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err = w.Write(content); err != nil {
+		cfg.Log.Error(err, "failed to write http response")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+// traceRequest makes each request a span which continues the trace of member cluster,
+// spans are named by route pattern like metrics
+func (cfg Config) traceRequest(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("fabedge.client", getClientID(r)),
+		))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				span.SetName(r.Method + " " + rctx.RoutePattern())
+				span.SetAttributes(attribute.String("http.route", rctx.RoutePattern()))
+			}
+			if cluster := cfg.getCluster(r); cluster != "" {
+				span.SetAttributes(attribute.String("fabedge.cluster", cluster))
+			}
+			span.SetAttributes(attribute.Int("http.status_code", status))
+
+			var err error
+			if status >= http.StatusInternalServerError {
+				err = fmt.Errorf("%s", http.StatusText(status))
+			}
+			tracing.End(span, err)
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// traceSignCert signs csr in a span, signing may take long when CA key is kept in KMS or HSM
+func traceSignCert(ctx context.Context, manager certutil.Manager, csr []byte, purpose string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "SignCert", trace.WithAttributes(attribute.String("purpose", purpose)))
+
+	certDER, err := manager.SignCert(csr)
+	tracing.End(span, err)
+
+	return certDER, err
+}
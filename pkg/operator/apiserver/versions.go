@@ -0,0 +1,125 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	APIVersionV1alpha1 = "v1alpha1"
+	APIVersionV1beta1  = "v1beta1"
+
+	// URLGetAPIVersions is used to discover API versions supported by API server,
+	// API server of old versions doesn't have it and only supports unversioned URLs
+	URLGetAPIVersions = "/api/versions"
+	// HeaderAPIVersion tells which API version a response is of
+	HeaderAPIVersion = "X-FabEdge-API-Version"
+)
+
+// SupportedAPIVersions are API versions supported by API server, the preferred comes first.
+// Unversioned URLs are served as v1alpha1
+var SupportedAPIVersions = []string{APIVersionV1beta1, APIVersionV1alpha1}
+
+type APIVersions struct {
+	Versions []string `json:"versions"`
+}
+
+// Community is a community in v1beta1 API
+type Community struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// EndpointsAndCommunitiesV1beta1 is the v1beta1 form of EndpointsAndCommunity, communities are
+// objects instead of a map, so they can carry more fields without breaking old clients
+type EndpointsAndCommunitiesV1beta1 struct {
+	Communities []Community     `json:"communities,omitempty"`
+	Endpoints   []apis.Endpoint `json:"endpoints,omitempty"`
+	Continue    string          `json:"continue,omitempty"`
+}
+
+func ToV1beta1(ea EndpointsAndCommunity) EndpointsAndCommunitiesV1beta1 {
+	out := EndpointsAndCommunitiesV1beta1{
+		Endpoints: ea.Endpoints,
+		Continue:  ea.Continue,
+	}
+
+	for name, members := range ea.Communities {
+		out.Communities = append(out.Communities, Community{Name: name, Members: members})
+	}
+	sort.Slice(out.Communities, func(i, j int) bool {
+		return out.Communities[i].Name < out.Communities[j].Name
+	})
+
+	return out
+}
+
+func (ea EndpointsAndCommunitiesV1beta1) ToV1alpha1() EndpointsAndCommunity {
+	out := EndpointsAndCommunity{
+		Endpoints: ea.Endpoints,
+		Continue:  ea.Continue,
+	}
+
+	if len(ea.Communities) > 0 {
+		out.Communities = make(map[string][]string, len(ea.Communities))
+	}
+	for _, community := range ea.Communities {
+		out.Communities[community.Name] = community.Members
+	}
+
+	return out
+}
+
+// VersionedURL returns the URL of API version for an unversioned URL, e.g. /api/endpoints
+// becomes /api/v1beta1/endpoints. An empty version returns url itself
+func VersionedURL(version, url string) string {
+	if version == "" {
+		return url
+	}
+
+	return "/api/" + version + strings.TrimPrefix(url, "/api")
+}
+
+func (cfg Config) getAPIVersions(w http.ResponseWriter, r *http.Request) {
+	cfg.responseJSON(w, http.StatusOK, APIVersions{Versions: SupportedAPIVersions})
+}
+
+type apiVersionKey struct{}
+
+// withAPIVersion saves API version of routes into request context and responds it in header
+func withAPIVersion(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HeaderAPIVersion, version)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func getAPIVersion(r *http.Request) string {
+	version, _ := r.Context().Value(apiVersionKey{}).(string)
+	if version == "" {
+		return APIVersionV1alpha1
+	}
+	return version
+}
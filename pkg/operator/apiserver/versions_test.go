@@ -0,0 +1,53 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+)
+
+var _ = Describe("APIVersions", func() {
+	It("can convert endpoints and communities between v1alpha1 and v1beta1", func() {
+		ea := apiserver.EndpointsAndCommunity{
+			Communities: map[string][]string{
+				"mixed":      {"cluster1.connector", "cluster2.edge1"},
+				"connectors": {"cluster1.connector", "cluster2.connector"},
+			},
+			Endpoints: []apis.Endpoint{
+				{Name: "cluster2.connector", PublicAddresses: []string{"10.1.1.1"}},
+				{Name: "cluster2.edge1", PublicAddresses: []string{"10.1.1.2"}},
+			},
+		}
+
+		eaV1beta1 := apiserver.ToV1beta1(ea)
+		Expect(eaV1beta1.Endpoints).Should(Equal(ea.Endpoints))
+		Expect(eaV1beta1.Communities).Should(Equal([]apiserver.Community{
+			{Name: "connectors", Members: []string{"cluster1.connector", "cluster2.connector"}},
+			{Name: "mixed", Members: []string{"cluster1.connector", "cluster2.edge1"}},
+		}))
+
+		Expect(eaV1beta1.ToV1alpha1()).Should(Equal(ea))
+	})
+
+	It("can build versioned URLs", func() {
+		Expect(apiserver.VersionedURL("", apiserver.URLUpdateEndpoints)).Should(Equal("/api/endpoints"))
+		Expect(apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLUpdateEndpoints)).Should(Equal("/api/v1beta1/endpoints"))
+		Expect(apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLClusterToken)).Should(Equal("/api/v1beta1/clusters/{cluster}/tokens/{id}"))
+	})
+})
@@ -0,0 +1,176 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+const (
+	// AppAudit is the value of fabedge.io/app label of audit configmaps
+	AppAudit = "fabedge-audit"
+	// KeyRecords is the key of audit records in audit configmap
+	KeyRecords = "records"
+	// DefaultMaxRecords is how many records are kept for each cluster by default
+	DefaultMaxRecords = 500
+
+	configMapNamePrefix = "fabedge-audit-"
+)
+
+const (
+	ActionSignCert        = "sign-cert"
+	ActionUpdateEndpoints = "update-endpoints"
+	ActionPatchEndpoints  = "patch-endpoints"
+	ActionDeregister      = "deregister"
+	ActionMintToken       = "mint-token"
+	ActionRotateTokens    = "rotate-tokens"
+	ActionRevokeToken     = "revoke-token"
+)
+
+// Record tells who did what to which cluster
+type Record struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Cluster string    `json:"cluster"`
+	// User is the common name of client certificate, or token:<cluster> if a token is used
+	User     string `json:"user"`
+	SourceIP string `json:"sourceIP,omitempty"`
+	// Detail holds extra information of action, e.g. serial number of signed certificate
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+type Recorder interface {
+	Record(ctx context.Context, record Record) error
+	List(ctx context.Context, cluster string) ([]Record, error)
+}
+
+// ConfigMapRecorder logs audit records and keeps the latest records of each cluster
+// in a configmap, so they survive restarts of operator
+type ConfigMapRecorder struct {
+	Namespace string
+	Client    client.Client
+	Log       logr.Logger
+	// MaxRecords is how many records are kept for each cluster, older records are dropped
+	MaxRecords int
+}
+
+func (rc ConfigMapRecorder) Record(ctx context.Context, record Record) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	rc.Log.Info("audit", "action", record.Action, "cluster", record.Cluster,
+		"user", record.User, "sourceIP", record.SourceIP, "detail", record.Detail)
+
+	maxRecords := rc.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = DefaultMaxRecords
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := rc.Client.Get(ctx, client.ObjectKey{Name: configMapName(record.Cluster), Namespace: rc.Namespace}, &cm)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		notFound := errors.IsNotFound(err)
+
+		records, err := decodeRecords(cm.Data[KeyRecords])
+		if err != nil {
+			rc.Log.Error(err, "failed to decode audit records, they will be overwritten", "cluster", record.Cluster)
+			records = nil
+		}
+
+		records = append(records, record)
+		if len(records) > maxRecords {
+			records = records[len(records)-maxRecords:]
+		}
+
+		content, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+
+		if notFound {
+			cm = corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configMapName(record.Cluster),
+					Namespace: rc.Namespace,
+					Labels: map[string]string{
+						constants.KeyFabedgeAPP: AppAudit,
+						constants.KeyCluster:    record.Cluster,
+					},
+				},
+				Data: map[string]string{KeyRecords: string(content)},
+			}
+			err = rc.Client.Create(ctx, &cm)
+			if errors.IsAlreadyExists(err) {
+				// let RetryOnConflict try again
+				return errors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[KeyRecords] = string(content)
+		return rc.Client.Update(ctx, &cm)
+	})
+}
+
+// List returns kept records of cluster, from oldest to latest
+func (rc ConfigMapRecorder) List(ctx context.Context, cluster string) ([]Record, error) {
+	var cm corev1.ConfigMap
+	err := rc.Client.Get(ctx, client.ObjectKey{Name: configMapName(cluster), Namespace: rc.Namespace}, &cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return decodeRecords(cm.Data[KeyRecords])
+}
+
+func decodeRecords(content string) ([]Record, error) {
+	if content == "" {
+		return nil, nil
+	}
+
+	var records []Record
+	err := json.Unmarshal([]byte(content), &records)
+	return records, err
+}
+
+// configMapName returns the name of configmap for records of cluster, records which
+// don't belong to any cluster are kept in a configmap without cluster suffix
+func configMapName(cluster string) string {
+	if cluster == "" {
+		return AppAudit
+	}
+	return configMapNamePrefix + cluster
+}
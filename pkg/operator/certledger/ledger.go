@@ -0,0 +1,279 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package certledger records every certificate signed by operator, so it can be told which
+// identities exist and who asked for them
+package certledger
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+const (
+	// AppCertLedger is the value of fabedge.io/app label of ledger configmap
+	AppCertLedger = "fabedge-cert-ledger"
+	// ConfigMapName is the name of ledger configmap
+	ConfigMapName = "fabedge-cert-ledger"
+	// KeyEntries is the key of entries in ledger configmap
+	KeyEntries = "entries"
+	// DefaultMaxEntries is how many entries are kept by default
+	DefaultMaxEntries = 1000
+
+	// RequesterOperator is the requester of certificates which operator makes by itself
+	RequesterOperator = "operator"
+
+	recordTimeout = 10 * time.Second
+)
+
+// purposes of certificates
+const (
+	PurposeAgent          = "agent"
+	PurposeAgentRenewal   = "agent-renewal"
+	PurposeAgentBootstrap = "agent-bootstrap"
+	PurposeConnector      = "connector"
+	PurposeMemberCluster  = "member-cluster"
+)
+
+// Entry describes a signed certificate and who asked for it
+type Entry struct {
+	Time         time.Time `json:"time"`
+	SerialNumber string    `json:"serialNumber"`
+	Subject      string    `json:"subject"`
+	URIs         []string  `json:"uris,omitempty"`
+	DNSNames     []string  `json:"dnsNames,omitempty"`
+	IPs          []string  `json:"ips,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	// Requester is who asked for the certificate, e.g. operator, the common name of a client
+	// certificate, token:<cluster> or agent:<node>
+	Requester string `json:"requester"`
+	// Purpose is what the certificate is used for, e.g. agent or connector
+	Purpose string `json:"purpose"`
+}
+
+// NewEntry makes an entry of cert
+func NewEntry(cert *x509.Certificate, requester, purpose string) Entry {
+	entry := Entry{
+		SerialNumber: cert.SerialNumber.String(),
+		Subject:      cert.Subject.String(),
+		DNSNames:     cert.DNSNames,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Requester:    requester,
+		Purpose:      purpose,
+	}
+
+	for _, uri := range cert.URIs {
+		entry.URIs = append(entry.URIs, uri.String())
+	}
+
+	for _, ip := range cert.IPAddresses {
+		entry.IPs = append(entry.IPs, ip.String())
+	}
+
+	return entry
+}
+
+type Ledger interface {
+	// Record appends entry to ledger, entries are never changed once they are recorded
+	Record(ctx context.Context, entry Entry) error
+	// List returns entries from oldest to latest
+	List(ctx context.Context) ([]Entry, error)
+}
+
+// ConfigMapLedger keeps entries in a configmap. Entries of certificates which expired longer than
+// Retention ago are dropped, and only the latest MaxEntries entries are kept
+type ConfigMapLedger struct {
+	Namespace string
+	Client    client.Client
+	Log       logr.Logger
+	// MaxEntries is how many entries are kept, 0 means DefaultMaxEntries
+	MaxEntries int
+	// Retention is how long entries are kept after their certificates expire, 0 means
+	// entries are only dropped by MaxEntries
+	Retention time.Duration
+}
+
+func (l ConfigMapLedger) Record(ctx context.Context, entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.Log.V(3).Info("certificate is signed", "subject", entry.Subject, "serialNumber", entry.SerialNumber,
+		"requester", entry.Requester, "purpose", entry.Purpose, "notAfter", entry.NotAfter)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := l.Client.Get(ctx, client.ObjectKey{Name: ConfigMapName, Namespace: l.Namespace}, &cm)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		notFound := errors.IsNotFound(err)
+
+		entries, err := decodeEntries(cm.Data[KeyEntries])
+		if err != nil {
+			l.Log.Error(err, "failed to decode ledger entries, they will be overwritten")
+			entries = nil
+		}
+
+		content, err := json.Marshal(l.prune(append(entries, entry), entry.Time))
+		if err != nil {
+			return err
+		}
+
+		if notFound {
+			cm = corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName,
+					Namespace: l.Namespace,
+					Labels: map[string]string{
+						constants.KeyFabedgeAPP: AppCertLedger,
+					},
+				},
+				Data: map[string]string{KeyEntries: string(content)},
+			}
+			err = l.Client.Create(ctx, &cm)
+			if errors.IsAlreadyExists(err) {
+				// let RetryOnConflict try again
+				return errors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[KeyEntries] = string(content)
+		return l.Client.Update(ctx, &cm)
+	})
+}
+
+// prune drops entries which are out of retention and the oldest entries beyond MaxEntries
+func (l ConfigMapLedger) prune(entries []Entry, now time.Time) []Entry {
+	if l.Retention > 0 {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if now.Sub(entry.NotAfter) <= l.Retention {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+
+	maxEntries := l.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	if len(entries) > maxEntries {
+		entries = entries[len(entries)-maxEntries:]
+	}
+
+	return entries
+}
+
+func (l ConfigMapLedger) List(ctx context.Context) ([]Entry, error) {
+	var cm corev1.ConfigMap
+	err := l.Client.Get(ctx, client.ObjectKey{Name: ConfigMapName, Namespace: l.Namespace}, &cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return decodeEntries(cm.Data[KeyEntries])
+}
+
+func decodeEntries(content string) ([]Entry, error) {
+	if content == "" {
+		return nil, nil
+	}
+
+	var entries []Entry
+	err := json.Unmarshal([]byte(content), &entries)
+	return entries, err
+}
+
+// Record records certDER in ledger, a failure is only logged because the certificate is signed already
+func Record(ledger Ledger, log logr.Logger, certDER []byte, requester, purpose string) {
+	if ledger == nil {
+		return
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		log.Error(err, "failed to parse signed certificate")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	if err = ledger.Record(ctx, NewEntry(cert, requester, purpose)); err != nil {
+		log.Error(err, "failed to record signed certificate", "serialNumber", cert.SerialNumber.String())
+	}
+}
+
+// WithLedger returns a manager which records certificates signed by m in ledger
+func WithLedger(m certutil.Manager, ledger Ledger, log logr.Logger, requester, purpose string) certutil.Manager {
+	return &recordingManager{
+		Manager:   m,
+		ledger:    ledger,
+		log:       log,
+		requester: requester,
+		purpose:   purpose,
+	}
+}
+
+type recordingManager struct {
+	certutil.Manager
+
+	ledger    Ledger
+	log       logr.Logger
+	requester string
+	purpose   string
+}
+
+func (m *recordingManager) SignCert(csr []byte) ([]byte, error) {
+	certDER, err := m.Manager.SignCert(csr)
+	if err != nil {
+		return nil, err
+	}
+
+	Record(m.ledger, m.log, certDER, m.requester, m.purpose)
+	return certDER, nil
+}
+
+func (m *recordingManager) NewCertKey(cfg certutil.Config) ([]byte, []byte, error) {
+	certDER, keyDER, err := m.Manager.NewCertKey(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	Record(m.ledger, m.log, certDER, m.requester, m.purpose)
+	return certDER, keyDER, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certledger_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/certledger"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("ConfigMapLedger", func() {
+	const namespace = "fabedge"
+
+	var (
+		cli    client.Client
+		ledger certledger.ConfigMapLedger
+		now    time.Time
+	)
+
+	newEntry := func(serialNumber int, notAfter time.Time) certledger.Entry {
+		return certledger.Entry{
+			Time:         now,
+			SerialNumber: fmt.Sprint(serialNumber),
+			Subject:      "CN=edge1",
+			NotAfter:     notAfter,
+			Requester:    certledger.RequesterOperator,
+			Purpose:      certledger.PurposeAgent,
+		}
+	}
+
+	serialNumbers := func() []string {
+		entries, err := ledger.List(context.Background())
+		Expect(err).Should(BeNil())
+
+		var numbers []string
+		for _, entry := range entries {
+			numbers = append(numbers, entry.SerialNumber)
+		}
+		return numbers
+	}
+
+	BeforeEach(func() {
+		now = time.Now().Truncate(time.Second)
+		cli = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		ledger = certledger.ConfigMapLedger{
+			Namespace: namespace,
+			Client:    cli,
+			Log:       klogr.New(),
+		}
+	})
+
+	It("should return nothing if no certificate is recorded", func() {
+		entries, err := ledger.List(context.Background())
+		Expect(err).Should(BeNil())
+		Expect(entries).Should(BeEmpty())
+	})
+
+	It("should append entries to ledger configmap", func() {
+		for i := 1; i <= 3; i++ {
+			Expect(ledger.Record(context.Background(), newEntry(i, now.Add(time.Hour)))).Should(Succeed())
+		}
+		Expect(serialNumbers()).Should(Equal([]string{"1", "2", "3"}))
+
+		var cm corev1.ConfigMap
+		Expect(cli.Get(context.Background(), client.ObjectKey{Name: certledger.ConfigMapName, Namespace: namespace}, &cm)).Should(Succeed())
+		Expect(cm.Labels[constants.KeyFabedgeAPP]).Should(Equal(certledger.AppCertLedger))
+
+		entries, err := ledger.List(context.Background())
+		Expect(err).Should(BeNil())
+		Expect(entries[0].Time.Equal(now)).Should(BeTrue())
+		Expect(entries[0].Subject).Should(Equal("CN=edge1"))
+		Expect(entries[0].Requester).Should(Equal(certledger.RequesterOperator))
+		Expect(entries[0].Purpose).Should(Equal(certledger.PurposeAgent))
+	})
+
+	It("should set time of entry if it's not set", func() {
+		entry := newEntry(1, now.Add(time.Hour))
+		entry.Time = time.Time{}
+		Expect(ledger.Record(context.Background(), entry)).Should(Succeed())
+
+		entries, err := ledger.List(context.Background())
+		Expect(err).Should(BeNil())
+		Expect(entries[0].Time.IsZero()).Should(BeFalse())
+	})
+
+	It("should only keep the latest MaxEntries entries", func() {
+		ledger.MaxEntries = 3
+		for i := 1; i <= 5; i++ {
+			Expect(ledger.Record(context.Background(), newEntry(i, now.Add(time.Hour)))).Should(Succeed())
+		}
+
+		Expect(serialNumbers()).Should(Equal([]string{"3", "4", "5"}))
+	})
+
+	It("should keep DefaultMaxEntries entries if MaxEntries is not set", func() {
+		for i := 1; i <= certledger.DefaultMaxEntries+2; i++ {
+			Expect(ledger.Record(context.Background(), newEntry(i, now.Add(time.Hour)))).Should(Succeed())
+		}
+
+		numbers := serialNumbers()
+		Expect(numbers).Should(HaveLen(certledger.DefaultMaxEntries))
+		Expect(numbers[0]).Should(Equal("3"))
+	})
+
+	It("should drop entries of certificates which expired longer than Retention ago", func() {
+		ledger.Retention = time.Hour
+		Expect(ledger.Record(context.Background(), newEntry(1, now.Add(-2*time.Hour)))).Should(Succeed())
+		Expect(ledger.Record(context.Background(), newEntry(2, now.Add(-30*time.Minute)))).Should(Succeed())
+		Expect(ledger.Record(context.Background(), newEntry(3, now.Add(time.Hour)))).Should(Succeed())
+
+		Expect(serialNumbers()).Should(Equal([]string{"2", "3"}))
+	})
+
+	It("should overwrite entries which can't be decoded", func() {
+		cm := corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      certledger.ConfigMapName,
+				Namespace: namespace,
+			},
+			Data: map[string]string{certledger.KeyEntries: "broken"},
+		}
+		Expect(cli.Create(context.Background(), &cm)).Should(Succeed())
+
+		_, err := ledger.List(context.Background())
+		Expect(err).Should(HaveOccurred())
+
+		Expect(ledger.Record(context.Background(), newEntry(1, now.Add(time.Hour)))).Should(Succeed())
+		Expect(serialNumbers()).Should(Equal([]string{"1"}))
+	})
+
+	It("should record certificates signed by manager wrapped by WithLedger", func() {
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: timeutil.Days(1),
+		})
+		Expect(err).Should(BeNil())
+		certManager, err := certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		manager := certledger.WithLedger(certManager, ledger, klogr.New(), certledger.RequesterOperator, certledger.PurposeConnector)
+		certDER, _, err := manager.NewCertKey(certutil.Config{
+			CommonName:     "connector",
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		})
+		Expect(err).Should(BeNil())
+
+		_, csr, err := certutil.NewCertRequest(certutil.Request{CommonName: "edge1"})
+		Expect(err).Should(BeNil())
+		_, err = manager.SignCert(csr)
+		Expect(err).Should(BeNil())
+
+		entries, err := ledger.List(context.Background())
+		Expect(err).Should(BeNil())
+		Expect(entries).Should(HaveLen(2))
+		Expect(entries[0].Subject).Should(ContainSubstring("CN=connector"))
+		Expect(entries[0].Purpose).Should(Equal(certledger.PurposeConnector))
+		Expect(entries[1].Subject).Should(ContainSubstring("CN=edge1"))
+
+		By("recording nothing without ledger")
+		certledger.Record(nil, klogr.New(), certDER, certledger.RequesterOperator, certledger.PurposeConnector)
+	})
+})
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+)
+
+// CertificateStore holds the client certificate of API client, it's used as
+// tls.Config.GetClientCertificate so the certificate can be replaced after renewal
+type CertificateStore struct {
+	mux  sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (s *CertificateStore) Set(cert tls.Certificate) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.cert = &cert
+}
+
+func (s *CertificateStore) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if s.cert == nil {
+		return nil, fmt.Errorf("no client certificate")
+	}
+
+	return s.cert, nil
+}
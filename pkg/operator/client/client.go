@@ -2,32 +2,85 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2/klogr"
+
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/about"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/fips"
 )
 
 const defaultTimeout = 5 * time.Second
 
+// supportedAPIVersions are API versions which client can use, the preferred comes first
+var supportedAPIVersions = []string{apiserver.APIVersionV1beta1, apiserver.APIVersionV1alpha1}
+
 type Interface interface {
 	GetEndpointsAndCommunities() (apiserver.EndpointsAndCommunity, error)
+	// WatchEndpointsAndCommunities waits at most timeout until endpoints and communities differ from
+	// the version identified by etag, then returns the latest ones and their etag. ErrNotModified is
+	// returned if nothing changes before timeout
+	WatchEndpointsAndCommunities(ctx context.Context, etag string, timeout time.Duration) (apiserver.EndpointsAndCommunity, string, error)
+	// StreamEndpointsAndCommunities keeps a stream to API server and calls onChange each time endpoints
+	// and communities change. It returns nil when API server ends the stream, or an error if the stream
+	// is broken or no event is received within idleTimeout
+	StreamEndpointsAndCommunities(ctx context.Context, idleTimeout time.Duration, onChange func(apiserver.EndpointsAndCommunity)) error
 	UpdateEndpoints(endpoints []apis.Endpoint) error
+	PatchEndpoints(delta apiserver.EndpointsDelta) error
+	Heartbeat() error
 	SignCert(csr []byte) (Certificate, error)
+	// GetCRL returns CRLs in PEM which list certificates revoked in host cluster
+	GetCRL() ([]byte, error)
+	// APIVersion returns the API version negotiated with API server, empty means
+	// API server doesn't support versioned URLs
+	APIVersion() string
 }
 
 type client struct {
 	clusterName string
-	baseURL     *url.URL
-	client      *http.Client
+	// baseURLs are addresses of API server replicas, requests are sent to
+	// the current one and fail over to another healthy one when it's unavailable
+	baseURLs []*url.URL
+	client   *http.Client
+	// watchClient has no timeout, because watch requests may take much longer
+	// than defaultTimeout, their timeout is set by context
+	watchClient *http.Client
+
+	mux sync.Mutex
+	// apiVersion is the API version negotiated with API server, empty means unversioned URLs
+	apiVersion string
+	negotiated bool
+	// current is the index of base URL which requests are sent to
+	current int
+	// pageSize is the max number of endpoints in each response, 0 means no pagination
+	pageSize int
+	log      logr.Logger
+}
+
+type option func(c *client)
+
+// PageSize makes client get endpoints in pages of size, if it's 0, all endpoints are
+// got by one request. Responses are compressed no matter whether it's set or not
+func PageSize(size int) option {
+	return func(c *client) {
+		c.pageSize = size
+	}
 }
 
 type Certificate struct {
@@ -36,31 +89,193 @@ type Certificate struct {
 	PEM []byte
 }
 
-func NewClient(apiServerAddr string, clusterName string, transport http.RoundTripper) (Interface, error) {
-	baseURL, err := url.Parse(apiServerAddr)
+// NewClient creates a client of API server, apiServerAddr can be a comma separated list of
+// addresses of API server replicas
+func NewClient(apiServerAddr string, clusterName string, transport http.RoundTripper, opts ...option) (Interface, error) {
+	baseURLs, err := parseAddresses(apiServerAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &client{
-		baseURL:     baseURL,
+	transport = instrumentTransport(transport)
+	c := &client{
+		baseURLs:    baseURLs,
 		clusterName: clusterName,
 		client: &http.Client{
 			Timeout:   defaultTimeout,
 			Transport: transport,
 		},
-	}, nil
+		watchClient: &http.Client{
+			Transport: transport,
+		},
+		log: klogr.New().WithName("apiClient"),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// APIVersion returns the API version negotiated with API server, empty means
+// API server doesn't support versioned URLs
+func (c *client) APIVersion() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.negotiated {
+		return c.apiVersion
+	}
+
+	version, err := c.negotiateAPIVersion()
+	if err != nil {
+		// use unversioned URLs this time and negotiate again next time
+		return ""
+	}
+
+	c.apiVersion, c.negotiated = version, true
+	return version
+}
+
+// negotiateAPIVersion picks the most preferred API version supported by both client and API server
+func (c *client) negotiateAPIVersion() (string, error) {
+	resp, err := c.client.Get(join(c.baseURLs[c.current], apiserver.URLGetAPIVersions))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return "", nil
+	}
+
+	data, err := handleResponse(resp)
+	if err != nil {
+		return "", err
+	}
+
+	var versions apiserver.APIVersions
+	if err = json.Unmarshal(data, &versions); err != nil {
+		return "", err
+	}
+
+	for _, version := range supportedAPIVersions {
+		for _, v := range versions.Versions {
+			if v == version {
+				return version, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// do sends request, if the API server is unavailable, the request is sent again to another
+// healthy replica if there is one. API version will be negotiated again if the request fails
+// to be sent, because API server may be restarted with a different version
+func (c *client) do(cli *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := cli.Do(req)
+	for i := 1; i < len(c.baseURLs) && isUnavailable(resp, err) && req.Context().Err() == nil; i++ {
+		baseURL, ok := c.failover()
+		if !ok {
+			break
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if req, err = rebase(req, baseURL); err != nil {
+			return nil, err
+		}
+		RetriesTotal.Inc()
+		resp, err = cli.Do(req)
+	}
+
+	if err != nil {
+		c.mux.Lock()
+		c.negotiated = false
+		c.mux.Unlock()
+	}
+
+	return resp, err
+}
+
+// failover switches to the next replica of API server which passes health check
+func (c *client) failover() (*url.URL, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i := 1; i < len(c.baseURLs); i++ {
+		index := (c.current + i) % len(c.baseURLs)
+		if !isHealthy(c.client, c.baseURLs[index]) {
+			continue
+		}
+
+		c.current = index
+		FailoversTotal.WithLabelValues("success").Inc()
+		return c.baseURLs[index], true
+	}
+
+	FailoversTotal.WithLabelValues("failure").Inc()
+	return nil, false
+}
+
+func (c *client) url(version, ref string) string {
+	c.mux.Lock()
+	baseURL := c.baseURLs[c.current]
+	c.mux.Unlock()
+
+	return join(baseURL, apiserver.VersionedURL(version, ref))
+}
+
+// isHealthy checks if API server at baseURL is able to handle requests, API server of
+// old versions responds 404 which is also considered healthy
+func isHealthy(cli *http.Client, baseURL *url.URL) bool {
+	resp, err := cli.Get(join(baseURL, apiserver.URLGetAPIVersions))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+// isUnavailable checks if a request should be sent to another replica of API server
+func isUnavailable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusBadGateway
+}
+
+// rebase copies req and replaces its scheme and host with those of baseURL
+func rebase(req *http.Request, baseURL *url.URL) (*http.Request, error) {
+	newReq := req.Clone(req.Context())
+	newReq.URL.Scheme, newReq.URL.Host = baseURL.Scheme, baseURL.Host
+	newReq.Host = ""
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		newReq.Body = body
+	}
+
+	return newReq, nil
 }
 
 func (c *client) SignCert(csr []byte) (cert Certificate, err error) {
-	req, err := http.NewRequest(http.MethodPost, join(c.baseURL, apiserver.URLSignCERT), csrBody(csr))
+	req, err := http.NewRequest(http.MethodPost, c.url(c.APIVersion(), apiserver.URLSignCERT), csrBody(csr))
 	if err != nil {
 		return cert, err
 	}
 	req.Header.Set("Content-Type", "text/plain")
 	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(c.client, req)
 	if err != nil {
 		return cert, err
 	}
@@ -74,14 +289,53 @@ func (c *client) UpdateEndpoints(endpoints []apis.Endpoint) error {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, join(c.baseURL, apiserver.URLUpdateEndpoints), bytes.NewReader(data))
+	req, err := http.NewRequest(http.MethodPut, c.url(c.APIVersion(), apiserver.URLUpdateEndpoints), bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(c.client, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = handleResponse(resp)
+	return err
+}
+
+func (c *client) PatchEndpoints(delta apiserver.EndpointsDelta) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, c.url(c.APIVersion(), apiserver.URLUpdateEndpoints), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+
+	resp, err := c.do(c.client, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = handleResponse(resp)
+	return err
+}
+
+func (c *client) Heartbeat() error {
+	req, err := http.NewRequest(http.MethodPut, c.url(c.APIVersion(), apiserver.URLHeartbeat), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+	req.Header.Set(apiserver.HeaderOperatorVersion, about.Version())
+
+	resp, err := c.do(c.client, req)
 	if err != nil {
 		return err
 	}
@@ -90,75 +344,447 @@ func (c *client) UpdateEndpoints(endpoints []apis.Endpoint) error {
 	return err
 }
 
+func (c *client) GetCRL() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(c.APIVersion(), apiserver.URLGetCRL), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+
+	resp, err := c.do(c.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return handleResponse(resp)
+}
+
 func (c *client) GetEndpointsAndCommunities() (ea apiserver.EndpointsAndCommunity, err error) {
-	req, err := http.NewRequest(http.MethodGet, join(c.baseURL, apiserver.URLGetEndpointsAndCommunities), nil)
+	version := c.APIVersion()
+	req, err := http.NewRequest(http.MethodGet, c.url(version, apiserver.URLGetEndpointsAndCommunities)+c.pageQuery(nil, ""), nil)
 	if err != nil {
 		return ea, err
 	}
 	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(c.client, req)
 	if err != nil {
 		return ea, err
 	}
 
 	data, err := handleResponse(resp)
+	if err != nil {
+		return ea, err
+	}
+
+	ea, err = decodeEndpointsAndCommunities(version, data)
+	if err != nil {
+		return ea, err
+	}
+
+	return c.getRestPages(context.Background(), version, ea)
+}
+
+// getRestPages gets the rest pages of endpoints after the first page and merges them into first.
+// If anything changes during pagination, a conflict error is returned
+func (c *client) getRestPages(ctx context.Context, version string, first apiserver.EndpointsAndCommunity) (ea apiserver.EndpointsAndCommunity, err error) {
+	ea = first
+	for ea.Continue != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(version, apiserver.URLGetEndpointsAndCommunities)+c.pageQuery(nil, ea.Continue), nil)
+		if err != nil {
+			return ea, err
+		}
+		req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+
+		resp, err := c.do(c.client, req)
+		if err != nil {
+			return ea, err
+		}
+
+		data, err := handleResponse(resp)
+		if err != nil {
+			return ea, err
+		}
+
+		page, err := decodeEndpointsAndCommunities(version, data)
+		if err != nil {
+			return ea, err
+		}
+
+		ea.Endpoints = append(ea.Endpoints, page.Endpoints...)
+		ea.Continue = page.Continue
+	}
+
+	return ea, nil
+}
+
+// pageQuery adds pagination parameters to query and encodes it, an empty string is
+// returned if there is no parameter
+func (c *client) pageQuery(query url.Values, continueToken string) string {
+	if query == nil {
+		query = url.Values{}
+	}
+
+	if c.pageSize > 0 {
+		query.Set(apiserver.QueryLimit, strconv.Itoa(c.pageSize))
+		if continueToken != "" {
+			query.Set(apiserver.QueryContinue, continueToken)
+		}
+	}
+
+	if len(query) == 0 {
+		return ""
+	}
+
+	return "?" + query.Encode()
+}
+
+func (c *client) WatchEndpointsAndCommunities(ctx context.Context, etag string, timeout time.Duration) (ea apiserver.EndpointsAndCommunity, newETag string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout+defaultTimeout)
+	defer cancel()
+
+	version := c.APIVersion()
+	query := url.Values{apiserver.QueryWatchTimeout: []string{timeout.String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(version, apiserver.URLGetEndpointsAndCommunities)+c.pageQuery(query, ""), nil)
+	if err != nil {
+		return ea, etag, err
+	}
+	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+	if etag != "" {
+		req.Header.Set(apiserver.HeaderIfNoneMatch, etag)
+	}
+
+	resp, err := c.do(c.watchClient, req)
+	if err != nil {
+		return ea, etag, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return ea, etag, ErrNotModified
+	}
+
+	data, err := handleResponse(resp)
+	if err != nil {
+		return ea, etag, err
+	}
+
+	newETag = resp.Header.Get(apiserver.HeaderETag)
+	ea, err = decodeEndpointsAndCommunities(version, data)
+	if err != nil {
+		return ea, etag, err
+	}
+
+	ea, err = c.getRestPages(ctx, version, ea)
+	if err != nil {
+		return ea, etag, err
+	}
+
+	return ea, newETag, nil
+}
+
+func (c *client) StreamEndpointsAndCommunities(ctx context.Context, idleTimeout time.Duration, onChange func(apiserver.EndpointsAndCommunity)) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the stream is closed if API server sends nothing, e.g. the connection is broken silently by NAT
+	idleTimer := time.AfterFunc(idleTimeout, cancel)
+	defer idleTimer.Stop()
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, c.url(apiserver.APIVersionV1beta1, apiserver.URLStream), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(apiserver.HeaderClusterName, c.clusterName)
+
+	resp, err := c.do(c.watchClient, req)
+	if err != nil {
+		return err
+	}
+	// close the connection of stream if it's idle, so the next stream is made by a new
+	// connection with the latest client certificate
+	defer c.watchClient.CloseIdleConnections()
+
+	if resp.StatusCode != http.StatusOK {
+		_, err = handleResponse(resp)
+		if err == nil {
+			err = fmt.Errorf("unexpected status code of stream: %d", resp.StatusCode)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	StreamsTotal.WithLabelValues(resp.Proto).Inc()
+	if resp.ProtoMajor < 2 {
+		// only endpoints and communities are pushed through stream, other requests can't share its
+		// connection without HTTP/2, so they are sent through other connections, which may be
+		// broken by NAT when they are idle
+		c.log.V(3).Info("stream is not served by HTTP/2, other requests are sent through other connections", "protocol", resp.Proto)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event apiserver.StreamEvent
+		if err = decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctx.Err() == nil && streamCtx.Err() != nil {
+				return fmt.Errorf("no event is received from stream in %s", idleTimeout)
+			}
+			return err
+		}
+		idleTimer.Reset(idleTimeout)
+
+		if event.Type == apiserver.StreamEventEndpointsAndCommunities && event.EndpointsAndCommunities != nil {
+			onChange(event.EndpointsAndCommunities.ToV1alpha1())
+		}
+	}
+}
+
+func decodeEndpointsAndCommunities(version string, data []byte) (ea apiserver.EndpointsAndCommunity, err error) {
+	if version == apiserver.APIVersionV1beta1 {
+		var eaV1beta1 apiserver.EndpointsAndCommunitiesV1beta1
+		err = json.Unmarshal(data, &eaV1beta1)
+		return eaV1beta1.ToV1alpha1(), err
+	}
 
 	err = json.Unmarshal(data, &ea)
 	return ea, err
 }
 
+// GetCertificate gets CA certificate from API server, apiServerAddr can be a comma separated
+// list of addresses, they are tried in order until one succeeds
 func GetCertificate(apiServerAddr string) (cert Certificate, err error) {
-	baseURL, err := url.Parse(apiServerAddr)
+	resp, err := getCA(apiServerAddr, apiserver.URLGetCA)
 	if err != nil {
 		return cert, err
 	}
 
+	return readCertFromResponse(resp)
+}
+
+// GetCABundle gets all trusted CA certs of host cluster, they are more than the CA cert when
+// CA is being rotated. API servers of old versions respond not found
+func GetCABundle(apiServerAddr string) (certs []Certificate, err error) {
+	resp, err := getCA(apiServerAddr, apiserver.URLGetCABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	ders, err := certutil.DecodeCertsPEM(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, der := range ders {
+		raw, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, Certificate{
+			Raw: raw,
+			DER: der,
+			PEM: certutil.EncodeCertPEM(der),
+		})
+	}
+
+	return certs, nil
+}
+
+// getCA sends request to path of API server without verifying its certificate, because CA
+// of host cluster is unknown yet
+func getCA(apiServerAddr string, path string) (resp *http.Response, err error) {
+	baseURLs, err := parseAddresses(apiServerAddr)
+	if err != nil {
+		return nil, err
+	}
+
 	cli := &http.Client{
 		Timeout: defaultTimeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
+		Transport: instrumentTransport(&http.Transport{
+			TLSClientConfig: fips.TLSConfig(&tls.Config{
 				InsecureSkipVerify: true,
-			},
-		},
+			}),
+		}),
 	}
 
-	resp, err := cli.Get(join(baseURL, apiserver.URLGetCA))
-	if err != nil {
-		return cert, err
+	for _, baseURL := range baseURLs {
+		resp, err = cli.Get(join(baseURL, path))
+		if isUnavailable(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			continue
+		}
+
+		return resp, nil
 	}
 
-	return readCertFromResponse(resp)
+	if err == nil {
+		err = fmt.Errorf("no API server is available")
+	}
+	return nil, err
 }
 
+// SignCertByToken signs csr with token, apiServerAddr can be a comma separated list of
+// addresses, they are tried in order until one succeeds
 func SignCertByToken(apiServerAddr string, token string, csr []byte, certPool *x509.CertPool) (cert Certificate, err error) {
-	baseURL, err := url.Parse(apiServerAddr)
+	baseURLs, err := parseAddresses(apiServerAddr)
 	if err != nil {
 		return cert, err
 	}
 
 	cli := &http.Client{
 		Timeout: defaultTimeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
+		Transport: instrumentTransport(&http.Transport{
+			TLSClientConfig: fips.TLSConfig(&tls.Config{
 				RootCAs: certPool,
-			},
-		},
+			}),
+		}),
 	}
 
-	req, err := http.NewRequest(http.MethodPost, join(baseURL, apiserver.URLSignCERT), csrBody(csr))
+	for _, baseURL := range baseURLs {
+		var (
+			req  *http.Request
+			resp *http.Response
+		)
+		req, err = http.NewRequest(http.MethodPost, join(baseURL, apiserver.URLSignCERT), csrBody(csr))
+		if err != nil {
+			return cert, err
+		}
+		req.Header.Set(apiserver.HeaderAuthorization, "bearer "+token)
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err = cli.Do(req)
+		if isUnavailable(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			continue
+		}
+
+		return readCertFromResponse(resp)
+	}
+
+	return cert, err
+}
+
+// RenewAgentCert renews the certificate of agent by csr, the request is authorized by clientCert
+// which is the current certificate of agent. apiServerAddr can be a comma separated list of
+// addresses, they are tried in order until one succeeds
+func RenewAgentCert(apiServerAddr string, csr []byte, clientCert tls.Certificate, certPool *x509.CertPool) (cert Certificate, err error) {
+	baseURLs, err := parseAddresses(apiServerAddr)
 	if err != nil {
 		return cert, err
 	}
-	req.Header.Set(apiserver.HeaderAuthorization, "bearer "+token)
-	req.Header.Set("Content-Type", "text/html")
 
-	resp, err := cli.Do(req)
+	cli := &http.Client{
+		Timeout: defaultTimeout,
+		Transport: instrumentTransport(&http.Transport{
+			TLSClientConfig: fips.TLSConfig(&tls.Config{
+				RootCAs:      certPool,
+				Certificates: []tls.Certificate{clientCert},
+			}),
+		}),
+	}
+
+	for _, baseURL := range baseURLs {
+		var (
+			req  *http.Request
+			resp *http.Response
+		)
+		req, err = http.NewRequest(http.MethodPost, join(baseURL, apiserver.URLRenewAgentCert), csrBody(csr))
+		if err != nil {
+			return cert, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err = cli.Do(req)
+		if isUnavailable(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			continue
+		}
+
+		return readCertFromResponse(resp)
+	}
+
+	return cert, err
+}
+
+// BootstrapAgentCert gets the first certificate of agent by csr, the request is authorized by token which
+// is the service account token of agent pod. apiServerAddr can be a comma separated list of
+// addresses, they are tried in order until one succeeds
+func BootstrapAgentCert(apiServerAddr string, token string, csr []byte, certPool *x509.CertPool) (cert Certificate, err error) {
+	baseURLs, err := parseAddresses(apiServerAddr)
 	if err != nil {
 		return cert, err
 	}
 
-	return readCertFromResponse(resp)
+	cli := &http.Client{
+		Timeout: defaultTimeout,
+		Transport: instrumentTransport(&http.Transport{
+			TLSClientConfig: fips.TLSConfig(&tls.Config{
+				RootCAs: certPool,
+			}),
+		}),
+	}
+
+	for _, baseURL := range baseURLs {
+		var (
+			req  *http.Request
+			resp *http.Response
+		)
+		req, err = http.NewRequest(http.MethodPost, join(baseURL, apiserver.URLBootstrapAgentCert), csrBody(csr))
+		if err != nil {
+			return cert, err
+		}
+		req.Header.Set(apiserver.HeaderAuthorization, "bearer "+token)
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err = cli.Do(req)
+		if isUnavailable(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			continue
+		}
+
+		return readCertFromResponse(resp)
+	}
+
+	return cert, err
+}
+
+// parseAddresses parses a comma separated list of addresses of API server
+func parseAddresses(apiServerAddr string) ([]*url.URL, error) {
+	var baseURLs []*url.URL
+	for _, addr := range strings.Split(apiServerAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		baseURL, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		baseURLs = append(baseURLs, baseURL)
+	}
+
+	if len(baseURLs) == 0 {
+		return nil, fmt.Errorf("no address of API server is provided")
+	}
+
+	return baseURLs, nil
 }
 
 func join(baseURL *url.URL, ref string) string {
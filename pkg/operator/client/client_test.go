@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"io/ioutil"
@@ -10,8 +12,10 @@ import (
 	"time"
 
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/about"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 )
@@ -75,6 +79,80 @@ func TestSignCertByToken(t *testing.T) {
 	g.Expect(requestContent).Should(Equal(csrPEM))
 }
 
+func TestRenewAgentCert(t *testing.T) {
+	g := NewGomegaWithT(t)
+	certManager, certPool := newCertManager()
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	var requestContent []byte
+	mux.HandleFunc(apiserver.URLRenewAgentCert, func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).Should(Equal(http.MethodPost))
+		requestContent, _ = ioutil.ReadAll(r.Body)
+
+		csr, _ := certutil.DecodePEM(requestContent)
+		certDER, _ := certManager.SignCert(csr)
+
+		w.Write(certutil.EncodeCertPEM(certDER))
+	})
+
+	keyDER, csr, _ := certutil.NewCertRequest(certutil.Request{CommonName: "edge1"})
+	privateKey, _ := x509.ParsePKCS1PrivateKey(keyDER)
+
+	cert, err := RenewAgentCert(url, csr, tls.Certificate{}, certPool)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cert.Raw.Subject.CommonName).Should(Equal("edge1"))
+	g.Expect(cert.Raw.PublicKey).Should(Equal(privateKey.Public()))
+	g.Expect(requestContent).Should(Equal(certutil.EncodeCertRequestPEM(csr)))
+}
+
+func TestGetCertificateFromMultipleAddresses(t *testing.T) {
+	_, downURL, teardown := newServer()
+	teardown()
+
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	certManager, _ := newCertManager()
+	mux.HandleFunc(apiserver.URLGetCA, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(certManager.GetCACertPEM())
+	})
+
+	cert, err := GetCertificate(downURL + "," + url)
+
+	g := NewGomegaWithT(t)
+	g.Expect(err).Should(BeNil())
+	g.Expect(*cert.Raw).Should(Equal(*certManager.GetCACert()))
+}
+
+func TestGetCABundle(t *testing.T) {
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	oldCertManager, _ := newCertManager()
+	certDER, keyDER, _ := certutil.NewSelfSignedCA(certutil.Config{CommonName: "CA"})
+	certManager, _ := certutil.NewManger(certDER, keyDER, time.Hour, oldCertManager.GetCACert().Raw)
+
+	mux.HandleFunc(apiserver.URLGetCABundle, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(certManager.GetCABundlePEM())
+	})
+
+	certs, err := GetCABundle(url)
+
+	g := NewGomegaWithT(t)
+	g.Expect(err).Should(BeNil())
+	g.Expect(certs).Should(HaveLen(2))
+	g.Expect(*certs[0].Raw).Should(Equal(*oldCertManager.GetCACert()))
+	g.Expect(*certs[1].Raw).Should(Equal(*certManager.GetCACert()))
+
+	// API servers of old versions have no CA bundle
+	_, oldURL, oldTeardown := newServer()
+	defer oldTeardown()
+
+	_, err = GetCABundle(oldURL)
+	g.Expect(IsStatus(err, http.StatusNotFound)).Should(BeTrue())
+}
+
 func TestClient_SignCert(t *testing.T) {
 	g := NewGomegaWithT(t)
 	certManager, _ := newCertManager()
@@ -141,6 +219,123 @@ func TestClient_UpdateEndpoints(t *testing.T) {
 	g.Expect(receivedEndpoints).Should(Equal(endpoints))
 }
 
+func TestClient_PatchEndpoints(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	var receivedDelta apiserver.EndpointsDelta
+	var req *http.Request
+	mux.HandleFunc(apiserver.URLUpdateEndpoints, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		content, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(content, &receivedDelta)
+
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+
+	delta := apiserver.EndpointsDelta{
+		BaseRevision: "123",
+		Deleted:      []string{"edge1"},
+	}
+	err = cli.PatchEndpoints(delta)
+	g.Expect(IsStatus(err, http.StatusConflict)).Should(BeTrue())
+	g.Expect(req.Method).Should(Equal(http.MethodPatch))
+	g.Expect(req.Header.Get(apiserver.HeaderClusterName)).Should(Equal(clusterName))
+	g.Expect(receivedDelta).Should(Equal(delta))
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	var req *http.Request
+	mux.HandleFunc(apiserver.URLHeartbeat, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+
+	heartbeats := testutil.ToFloat64(RequestsTotal.WithLabelValues(http.MethodPut, apiserver.URLHeartbeat, "204"))
+	g.Expect(cli.Heartbeat()).Should(Succeed())
+	g.Expect(testutil.ToFloat64(RequestsTotal.WithLabelValues(http.MethodPut, apiserver.URLHeartbeat, "204"))).Should(Equal(heartbeats + 1))
+	g.Expect(req.Method).Should(Equal(http.MethodPut))
+	g.Expect(req.Header.Get(apiserver.HeaderClusterName)).Should(Equal(clusterName))
+	g.Expect(req.Header.Get(apiserver.HeaderOperatorVersion)).Should(Equal(about.Version()))
+}
+
+func TestClient_GetCRL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	crlPEM := []byte("-----BEGIN X509 CRL-----\nMA==\n-----END X509 CRL-----\n")
+	mux.HandleFunc(apiserver.URLGetCRL, func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.Method).Should(Equal(http.MethodGet))
+		g.Expect(r.Header.Get(apiserver.HeaderClusterName)).Should(Equal(clusterName))
+		w.Write(crlPEM)
+	})
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+
+	data, err := cli.GetCRL()
+	g.Expect(err).Should(BeNil())
+	g.Expect(data).Should(Equal(crlPEM))
+}
+
+func TestClient_Failover(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	_, downURL, teardown := newServer()
+	teardown()
+
+	unavailableMux, unavailableURL, teardown := newServer()
+	defer teardown()
+	unavailableMux.HandleFunc(apiserver.URLHeartbeat, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	var requests int
+	mux.HandleFunc(apiserver.URLUpdateEndpoints, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(apiserver.URLHeartbeat, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	retries := testutil.ToFloat64(RetriesTotal)
+	failovers := testutil.ToFloat64(FailoversTotal.WithLabelValues("success"))
+
+	// a replica which can't be connected is skipped
+	cli, err := NewClient(downURL+","+url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cli.UpdateEndpoints([]apis.Endpoint{{Name: "connector"}})).Should(Succeed())
+	g.Expect(requests).Should(Equal(1))
+	g.Expect(testutil.ToFloat64(RetriesTotal)).Should(Equal(retries + 1))
+	g.Expect(testutil.ToFloat64(FailoversTotal.WithLabelValues("success"))).Should(Equal(failovers + 1))
+
+	// a replica which responds 503 is skipped too
+	cli, err = NewClient(unavailableURL+","+url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cli.Heartbeat()).Should(Succeed())
+	g.Expect(requests).Should(Equal(2))
+
+	_, err = NewClient(" , ", clusterName, nil)
+	g.Expect(err).ShouldNot(BeNil())
+}
+
 func TestClient_GetEndpointsAndCommunities(t *testing.T) {
 	g := NewGomegaWithT(t)
 	mux, url, teardown := newServer()
@@ -190,6 +385,157 @@ func TestClient_GetEndpointsAndCommunities(t *testing.T) {
 	g.Expect(req.Header.Get(apiserver.HeaderClusterName)).Should(Equal(clusterName))
 }
 
+func TestClient_GetEndpointsAndCommunitiesInPages(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	expectedEA := apiserver.EndpointsAndCommunity{
+		Communities: map[string][]string{
+			"mixed": {"cluster1.connector", "cluster2.connector", "cluster3.edge"},
+		},
+		Endpoints: []apis.Endpoint{
+			{Name: "cluster2.connector", PublicAddresses: []string{"cluster2"}},
+			{Name: "cluster3.edge", PublicAddresses: []string{"cluster3.edge"}},
+		},
+	}
+
+	var queries []string
+	mux.HandleFunc(apiserver.URLGetEndpointsAndCommunities, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+
+		page := apiserver.EndpointsAndCommunity{
+			Communities: expectedEA.Communities,
+			Endpoints:   expectedEA.Endpoints[:1],
+			Continue:    "next",
+		}
+		if r.URL.Query().Get(apiserver.QueryContinue) == "next" {
+			page = apiserver.EndpointsAndCommunity{Endpoints: expectedEA.Endpoints[1:]}
+		}
+
+		data, _ := json.Marshal(page)
+		w.Write(data)
+	})
+
+	cli, err := NewClient(url, clusterName, nil, PageSize(1))
+	g.Expect(err).Should(BeNil())
+
+	ea, err := cli.GetEndpointsAndCommunities()
+	g.Expect(err).Should(BeNil())
+	g.Expect(ea).Should(Equal(expectedEA))
+	g.Expect(queries).Should(Equal([]string{
+		apiserver.QueryLimit + "=1",
+		apiserver.QueryContinue + "=next&" + apiserver.QueryLimit + "=1",
+	}))
+}
+
+func TestClient_WatchEndpointsAndCommunities(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	expectedEA := apiserver.EndpointsAndCommunity{
+		Communities: map[string][]string{
+			"connectors": {"cluster1.connector", "cluster2.connector"},
+		},
+		Endpoints: []apis.Endpoint{
+			{
+				Name:            "cluster2.connector",
+				PublicAddresses: []string{"cluster2"},
+				Subnets:         []string{"2.5.0.0/16"},
+				NodeSubnets:     []string{"10.10.10.100/32"},
+			},
+		},
+	}
+	var req *http.Request
+	mux.HandleFunc(apiserver.URLGetEndpointsAndCommunities, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		if r.Header.Get(apiserver.HeaderIfNoneMatch) == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		data, _ := json.Marshal(expectedEA)
+		w.Header().Set(apiserver.HeaderETag, `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+
+	ea, etag, err := cli.WatchEndpointsAndCommunities(context.Background(), "", 30*time.Second)
+	g.Expect(err).Should(BeNil())
+	g.Expect(ea).Should(Equal(expectedEA))
+	g.Expect(etag).Should(Equal(`"v1"`))
+	g.Expect(req.URL.Query().Get(apiserver.QueryWatchTimeout)).Should(Equal("30s"))
+	g.Expect(req.Header.Get(apiserver.HeaderClusterName)).Should(Equal(clusterName))
+
+	_, etag, err = cli.WatchEndpointsAndCommunities(context.Background(), etag, 30*time.Second)
+	g.Expect(err).Should(Equal(ErrNotModified))
+	g.Expect(etag).Should(Equal(`"v1"`))
+}
+
+func TestClient_NegotiateAPIVersion(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	expectedEA := apiserver.EndpointsAndCommunity{
+		Communities: map[string][]string{
+			"connectors": {"cluster1.connector", "cluster2.connector"},
+		},
+		Endpoints: []apis.Endpoint{
+			{
+				Name:            "cluster2.connector",
+				PublicAddresses: []string{"cluster2"},
+			},
+		},
+	}
+
+	serverVersions := []string{"v2", apiserver.APIVersionV1beta1, apiserver.APIVersionV1alpha1}
+	mux.HandleFunc(apiserver.URLGetAPIVersions, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(apiserver.APIVersions{Versions: serverVersions})
+		w.Write(data)
+	})
+	mux.HandleFunc(apiserver.VersionedURL(apiserver.APIVersionV1beta1, apiserver.URLGetEndpointsAndCommunities), func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(apiserver.ToV1beta1(expectedEA))
+		w.Write(data)
+	})
+	mux.HandleFunc(apiserver.VersionedURL(apiserver.APIVersionV1alpha1, apiserver.URLGetEndpointsAndCommunities), func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(expectedEA)
+		w.Write(data)
+	})
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cli.APIVersion()).Should(Equal(apiserver.APIVersionV1beta1))
+
+	ea, err := cli.GetEndpointsAndCommunities()
+	g.Expect(err).Should(BeNil())
+	g.Expect(ea).Should(Equal(expectedEA))
+
+	// a client only picks versions it supports
+	serverVersions = []string{apiserver.APIVersionV1alpha1}
+	cli, err = NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cli.APIVersion()).Should(Equal(apiserver.APIVersionV1alpha1))
+
+	ea, err = cli.GetEndpointsAndCommunities()
+	g.Expect(err).Should(BeNil())
+	g.Expect(ea).Should(Equal(expectedEA))
+}
+
+func TestClient_APIVersionOfOldServer(t *testing.T) {
+	g := NewGomegaWithT(t)
+	_, url, teardown := newServer()
+	defer teardown()
+
+	cli, err := NewClient(url, clusterName, nil)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cli.APIVersion()).Should(BeEmpty())
+}
+
 func newServer() (mux *http.ServeMux, url string, close func()) {
 	mux = http.NewServeMux()
 	server := httptest.NewServer(mux)
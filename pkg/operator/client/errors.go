@@ -5,6 +5,9 @@ import (
 	"net/http"
 )
 
+// ErrNotModified means the watched resources are not changed
+var ErrNotModified = fmt.Errorf("not modified")
+
 type HttpError struct {
 	Response *http.Response
 	Message  string
@@ -13,3 +16,24 @@ type HttpError struct {
 func (e HttpError) Error() string {
 	return fmt.Sprintf("Status Code: %d. Message: %s", e.Response.StatusCode, e.Message)
 }
+
+// IsDeregistered checks if err is caused by the cluster being deregistered from host cluster
+func IsDeregistered(err error) bool {
+	return IsStatus(err, http.StatusGone)
+}
+
+// IsStatus checks if err is an HttpError with one of statusCodes
+func IsStatus(err error, statusCodes ...int) bool {
+	httpErr, ok := err.(*HttpError)
+	if !ok {
+		return false
+	}
+
+	for _, code := range statusCodes {
+		if httpErr.Response.StatusCode == code {
+			return true
+		}
+	}
+
+	return false
+}
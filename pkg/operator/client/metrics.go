@@ -0,0 +1,128 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+// codeError is the code label of requests which get no response, e.g. connection refused
+const codeError = "error"
+
+var (
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Number of requests sent to API server of host cluster, partitioned by method, path and status code",
+	}, []string{"method", "path", "code"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "fabedge",
+		Subsystem: "client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests sent to API server of host cluster until response headers are received, watch requests wait for changes",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	RetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "client",
+		Name:      "retries_total",
+		Help:      "Number of requests sent again to another replica of API server because the previous one is unavailable",
+	})
+
+	FailoversTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "client",
+		Name:      "failovers_total",
+		Help:      "Number of attempts to fail over to another replica of API server, partitioned by result",
+	}, []string{"result"})
+
+	StreamsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabedge",
+		Subsystem: "client",
+		Name:      "streams_total",
+		Help:      "Number of streams of endpoints and communities made to API server of host cluster, partitioned by protocol, e.g. HTTP/2.0",
+	}, []string{"protocol"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(RequestsTotal, RequestDuration, RetriesTotal, FailoversTotal, StreamsTotal)
+}
+
+// instrumentedTransport records count and latency of requests and makes each request a span
+// whose trace context is sent to API server. Paths of member cluster's requests are fixed,
+// so they are used as labels and span names directly
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func instrumentTransport(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return instrumentedTransport{next: transport}
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.String()),
+	))
+	// a RoundTripper must not modify the request, trace context is put in a copy
+	req = req.Clone(ctx)
+	tracing.Inject(ctx, req.Header)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	RequestDuration.WithLabelValues(req.Method, req.URL.Path).Observe(time.Since(start).Seconds())
+
+	code := codeError
+	spanErr := err
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			spanErr = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	RequestsTotal.WithLabelValues(req.Method, req.URL.Path, code).Inc()
+	tracing.End(span, spanErr)
+
+	return resp, err
+}
+
+// CloseIdleConnections lets http.Client close idle connections of the wrapped transport
+func (t instrumentedTransport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	if ci, ok := t.next.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
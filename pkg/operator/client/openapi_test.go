@@ -0,0 +1,213 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `yaml:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `yaml:"parameters"`
+	} `yaml:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter `yaml:"parameters"`
+	RequestBody struct {
+		Content map[string]interface{} `yaml:"content"`
+	} `yaml:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Ref  string `yaml:"$ref"`
+	Name string `yaml:"name"`
+	In   string `yaml:"in"`
+}
+
+// validate checks if r is an operation in the document, and its query parameters, headers of
+// FabEdge and content type are declared by the operation. The path of operation is returned
+func (doc openAPIDocument) validate(r *http.Request) (string, error) {
+	path := r.URL.Path
+	// all versions share the same paths, so only v1beta1 paths are documented
+	if path != apiserver.URLGetAPIVersions && path != apiserver.URLGetOpenAPI {
+		for _, version := range apiserver.SupportedAPIVersions {
+			path = strings.TrimPrefix(path, "/api/"+version)
+		}
+		path = apiserver.VersionedURL(apiserver.APIVersionV1beta1, strings.TrimPrefix(path, "/api"))
+	}
+
+	for template, operations := range doc.Paths {
+		pattern := regexp.MustCompile(`\{[^/]+\}`).ReplaceAllString(template, `[^/]+`)
+		if !regexp.MustCompile("^" + pattern + "$").MatchString(path) {
+			continue
+		}
+
+		operation, ok := operations[strings.ToLower(r.Method)]
+		if !ok {
+			return template, fmt.Errorf("%s %s is not documented", r.Method, template)
+		}
+
+		declared := make(map[string]bool)
+		for _, p := range operation.Parameters {
+			if p.Ref != "" {
+				p = doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+			}
+			declared[p.In+":"+strings.ToLower(p.Name)] = true
+		}
+
+		for name := range r.URL.Query() {
+			if !declared["query:"+strings.ToLower(name)] {
+				return template, fmt.Errorf("query parameter %s of %s %s is not documented", name, r.Method, template)
+			}
+		}
+
+		for name := range r.Header {
+			name = strings.ToLower(name)
+			if (strings.HasPrefix(name, "x-fabedge-") || name == "if-none-match") && !declared["header:"+name] {
+				return template, fmt.Errorf("header %s of %s %s is not documented", name, r.Method, template)
+			}
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		contentType := r.Header.Get("Content-Type")
+		if len(body) > 0 || contentType != "" {
+			if _, ok := operation.RequestBody.Content[contentType]; !ok {
+				return template, fmt.Errorf("content type %q of %s %s is not documented", contentType, r.Method, template)
+			}
+		}
+
+		return template, nil
+	}
+
+	return "", fmt.Errorf("path %s is not documented", path)
+}
+
+// TestClientFollowsOpenAPI sends requests by every method of Interface and every function of
+// API server, then checks them against the OpenAPI document served by API server
+func TestClientFollowsOpenAPI(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var doc openAPIDocument
+	g.Expect(yaml.Unmarshal(apiserver.OpenAPI, &doc)).Should(Succeed())
+
+	var (
+		lock    sync.Mutex
+		errs    []error
+		visited = make(map[string]int)
+	)
+	mux, url, teardown := newServer()
+	defer teardown()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		template, err := doc.validate(r)
+
+		lock.Lock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		visited[r.Method+" "+template]++
+		lock.Unlock()
+
+		switch {
+		case r.URL.Path == apiserver.URLGetAPIVersions:
+			json.NewEncoder(w).Encode(apiserver.APIVersions{Versions: apiserver.SupportedAPIVersions})
+		case strings.HasSuffix(r.URL.Path, apiserver.URLGetEndpointsAndCommunities[len("/api"):]):
+			// the first page has a continue token, so the rest pages are requested too
+			ea := apiserver.EndpointsAndCommunity{}
+			if r.URL.Query().Get("continue") == "" {
+				ea.Continue = "next"
+			}
+			json.NewEncoder(w).Encode(apiserver.ToV1beta1(ea))
+		case strings.HasSuffix(r.URL.Path, apiserver.URLStream[len("/api"):]):
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		case r.Method == http.MethodGet:
+			w.Write(nil)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	cli, err := NewClient(url, clusterName, nil, PageSize(10))
+	g.Expect(err).Should(BeNil())
+
+	_, csr, _ := certutil.NewCertRequest(certutil.Request{CommonName: clusterName + apiserver.ClientCommonNameSuffix})
+	calls := map[string]func(){
+		"APIVersion":                 func() { cli.APIVersion() },
+		"GetEndpointsAndCommunities": func() { cli.GetEndpointsAndCommunities() },
+		"UpdateEndpoints":            func() { cli.UpdateEndpoints([]apis.Endpoint{{Name: "fabedge.connector"}}) },
+		"PatchEndpoints":             func() { cli.PatchEndpoints(apiserver.EndpointsDelta{Deleted: []string{"fabedge.edge1"}}) },
+		"Heartbeat":                  func() { cli.Heartbeat() },
+		"SignCert":                   func() { cli.SignCert(csr) },
+		"GetCRL":                     func() { cli.GetCRL() },
+		"StreamEndpointsAndCommunities": func() {
+			cli.StreamEndpointsAndCommunities(context.Background(), time.Second, func(apiserver.EndpointsAndCommunity) {})
+		},
+		"WatchEndpointsAndCommunities": func() {
+			cli.WatchEndpointsAndCommunities(context.Background(), `"etag"`, time.Second)
+		},
+	}
+
+	clientType := reflect.TypeOf((*Interface)(nil)).Elem()
+	g.Expect(calls).Should(HaveLen(clientType.NumMethod()))
+	for i := 0; i < clientType.NumMethod(); i++ {
+		name := clientType.Method(i).Name
+		g.Expect(calls).Should(HaveKey(name), "method %s is not checked against OpenAPI document", name)
+		calls[name]()
+	}
+
+	GetCertificate(url)
+	GetCABundle(url)
+	SignCertByToken(url, "token", csr, nil)
+	RenewAgentCert(url, csr, tls.Certificate{}, nil)
+	BootstrapAgentCert(url, "token", csr, nil)
+
+	g.Expect(errs).Should(BeEmpty())
+	for _, operation := range []string{
+		"GET /api/versions",
+		"GET /api/v1beta1/ca-cert",
+		"GET /api/v1beta1/ca-bundle",
+		"GET /api/v1beta1/crl",
+		"POST /api/v1beta1/sign-cert",
+		"POST /api/v1beta1/agent-cert",
+		"POST /api/v1beta1/agent-cert/bootstrap",
+		"PUT /api/v1beta1/endpoints",
+		"PATCH /api/v1beta1/endpoints",
+		"GET /api/v1beta1/endpoints-and-communities",
+		"GET /api/v1beta1/stream",
+		"PUT /api/v1beta1/heartbeat",
+	} {
+		g.Expect(visited).Should(HaveKey(operation))
+	}
+}
@@ -15,6 +15,8 @@
 package operator
 
 import (
+	"os"
+
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	flag "github.com/spf13/pflag"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -47,6 +49,14 @@ func Execute() error {
 
 	about.DisplayAndExitIfRequested()
 
+	if opts.PrintRBAC {
+		return opts.writeRBAC(os.Stdout)
+	}
+
+	if err := opts.LoadFabEdgeConfig(); err != nil {
+		return err
+	}
+
 	if err := opts.Validate(); err != nil {
 		log.Error(err, "invalid arguments found")
 		return err
@@ -15,6 +15,7 @@
 package agent
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -22,10 +23,12 @@ import (
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/common/constants"
 	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 	testutil "github.com/fabedge/fabedge/pkg/util/test"
@@ -52,9 +55,13 @@ var _ = BeforeSuite(func(done Done) {
 
 	By("starting test environment")
 	var err error
-	testEnv, cfg, k8sClient, err = testutil.StartTestEnv()
+	testEnv, cfg, k8sClient, err = testutil.StartTestEnvWithCRD(
+		[]string{filepath.Join("..", "..", "..", "..", "deploy", "crds")},
+	)
 	Expect(err).NotTo(HaveOccurred())
 
+	_ = apis.AddToScheme(scheme.Scheme)
+
 	close(done)
 }, 60)
 
@@ -18,21 +18,38 @@ import (
 	"context"
 	"fmt"
 	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	"github.com/fabedge/fabedge/pkg/common/constants"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 )
 
+const (
+	kubeProxyNamespace = "kube-system"
+	kubeProxyName      = "kube-proxy"
+	edgeMeshNamespace  = "kubeedge"
+	edgeMeshName       = "edgemesh-agent"
+
+	resourceModeNormal = "normal"
+	resourceModeLow    = "low"
+)
+
 var _ Handler = &agentPodHandler{}
 
 type agentPodHandler struct {
@@ -45,26 +62,122 @@ type agentPodHandler struct {
 	useXfrm           bool
 	masqOutgoing      bool
 	enableProxy       bool
+	detectKubeProxy   bool
 	enableIPAM        bool
 	enableHairpinMode bool
 	networkPluginMTU  int
+	subnetsPerChildSA int
+	crlSecretName     string
+	apiServerAddress  string
+	enableFirewall    bool
+	firewallPorts     []string
+	fipsMode          bool
+	// certBootstrap makes agent get its certificate by the service account token of
+	// its pod, the key and certificate are kept in an emptyDir volume
+	certBootstrap bool
+	// offlineCache makes agent keep the last valid config in a hostPath volume
+	offlineCache bool
+	// dnsListenAddress makes agent serve DNS for edge pods, it's disabled if empty
+	dnsListenAddress string
+	dnsUpstreams     []string
+	// metricsPort makes agent serve /metrics and /healthz, /healthz is used as probes of agent container
+	metricsPort int
+	// ipvs settings are only passed to agents whose proxy is enabled
+	ipvsTCPTimeout          time.Duration
+	ipvsTCPFinTimeout       time.Duration
+	ipvsUDPTimeout          time.Duration
+	ipvsGracefulTermination time.Duration
+	// nodeCondition is passed to agent, if it's not empty, agent pod
+	// needs a service account to update node status
+	nodeCondition string
+	// relayTimeout is passed to agent, if it's positive, agent pod needs a service account
+	// to annotate the node as relay-only
+	relayTimeout time.Duration
+	// configResource makes agent get its config from AgentConfig through API, agent pod needs a
+	// service account to read it and report status, config files are written in an emptyDir volume
+	configResource bool
+	// probeInterval is passed to agent when configResource is true, results are reported in AgentConfig
+	probeInterval      time.Duration
+	flapThreshold      int
+	serviceAccountName string
+	// kubeEdge makes agents on nodes managed by edgecore access API through metaServerAddress
+	// when kube-apiserver can't be reached, and agent's proxy is disabled where EdgeMesh runs
+	kubeEdge          bool
+	metaServerAddress string
+	// openYurt makes agent pod labeled with the NodePool of its node, so it's taken as a
+	// workload of the pool like pods of YurtAppSet
+	openYurt bool
+	// superEdge makes agents access API through liteAPIServerAddress where tunnel-edge runs
+	// and accept checks of edge-health, SuperEdge components are found in superEdgeNamespace
+	superEdge            bool
+	liteAPIServerAddress string
+	superEdgeNamespace   string
 
+	// mux protects settings of agent pod which may be changed at runtime
+	mux    sync.RWMutex
 	client client.Client
 	log    logr.Logger
 }
 
+// PodSettings are settings of agent pod which can be changed at runtime,
+// agent pods will be recreated if their settings change
+type PodSettings struct {
+	AgentImage        string
+	StrongswanImage   string
+	ImagePullPolicy   corev1.PullPolicy
+	LogLevel          int
+	UseXfrm           bool
+	MasqOutgoing      bool
+	EnableHairpinMode bool
+	NetworkPluginMTU  int
+	SubnetsPerChildSA int
+	NodeCondition     string
+}
+
+func (handler *agentPodHandler) updatePodSettings(settings PodSettings) {
+	handler.mux.Lock()
+	defer handler.mux.Unlock()
+
+	handler.agentImage = settings.AgentImage
+	handler.strongswanImage = settings.StrongswanImage
+	handler.imagePullPolicy = settings.ImagePullPolicy
+	handler.logLevel = settings.LogLevel
+	handler.useXfrm = settings.UseXfrm
+	handler.masqOutgoing = settings.MasqOutgoing
+	handler.enableHairpinMode = settings.EnableHairpinMode
+	handler.networkPluginMTU = settings.NetworkPluginMTU
+	handler.subnetsPerChildSA = settings.SubnetsPerChildSA
+	handler.nodeCondition = settings.NodeCondition
+}
+
 func (handler *agentPodHandler) Do(ctx context.Context, node corev1.Node) error {
+	handler.mux.RLock()
+	defer handler.mux.RUnlock()
+
 	agentPodName := getAgentPodName(node.Name)
 
 	log := handler.log.WithValues("nodeName", node.Name, "podName", agentPodName, "namespace", handler.namespace)
 
+	enableProxy, proxyStatus, err := handler.isProxyEnabled(ctx, node)
+	if err != nil {
+		log.Error(err, "failed to decide whether to enable proxy")
+		return err
+	}
+	log.V(5).Info("proxy decision is made", "enableProxy", enableProxy, "reason", proxyStatus)
+
+	superEdge, err := handler.detectSuperEdge(ctx, node)
+	if err != nil {
+		log.Error(err, "failed to detect SuperEdge components")
+		return err
+	}
+
 	var oldPod corev1.Pod
-	err := handler.client.Get(ctx, ObjectKey{Name: agentPodName, Namespace: handler.namespace}, &oldPod)
+	err = handler.client.Get(ctx, ObjectKey{Name: agentPodName, Namespace: handler.namespace}, &oldPod)
 	switch {
 	case err == nil:
 		needRestart := ctx.Value(keyRestartAgent) == errRestartAgent
 		if !needRestart {
-			newPod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName)
+			newPod := handler.buildAgentPodOfNode(node, agentPodName, enableProxy, superEdge)
 			needRestart = newPod.Labels[constants.KeyPodHash] != oldPod.Labels[constants.KeyPodHash]
 		}
 
@@ -82,14 +195,17 @@ func (handler *agentPodHandler) Do(ctx context.Context, node corev1.Node) error
 		return err
 	case errors.IsNotFound(err):
 		log.V(5).Info("Agent pod is not found, create it now")
-		newPod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName)
+		newPod := handler.buildAgentPodOfNode(node, agentPodName, enableProxy, superEdge)
+		newPod.Annotations = map[string]string{
+			constants.KeyProxyStatus: proxyStatus,
+		}
 
 		if err = controllerutil.SetControllerReference(&node, newPod, scheme.Scheme); err != nil {
 			log.Error(err, "failed to set ownerReference to TLS secret")
 			return err
 		}
 
-		err = handler.client.Create(ctx, newPod)
+		err = handler.client.Patch(ctx, newPod, client.Apply, applyOptions...)
 		if err != nil {
 			log.Error(err, "failed to create agent pod")
 		}
@@ -100,7 +216,162 @@ func (handler *agentPodHandler) Do(ctx context.Context, node corev1.Node) error
 	}
 }
 
-func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName string) *corev1.Pod {
+// buildAgentPodOfNode builds agent pod with settings taken from annotations of node
+// and SuperEdge components running on node
+func (handler *agentPodHandler) buildAgentPodOfNode(node corev1.Node, podName string, enableProxy bool, superEdge superEdgeComponents) *corev1.Pod {
+	pod := handler.buildAgentPod(handler.namespace, node.Name, podName, enableProxy)
+
+	var args []string
+	switch mode := node.Annotations[constants.KeyAgentResourceMode]; mode {
+	case "", resourceModeNormal:
+	case resourceModeLow:
+		args = append(args, fmt.Sprintf("--resource-mode=%s", mode))
+	default:
+		handler.log.V(3).Info("unknown agent resource mode, normal mode is used", "nodeName", node.Name, "mode", mode)
+	}
+
+	// MetaServer only runs on nodes managed by edgecore, and it's only used by agents which access API
+	usesAPI := pod.Spec.AutomountServiceAccountToken != nil && *pod.Spec.AutomountServiceAccountToken
+	if handler.kubeEdge && handler.metaServerAddress != "" && usesAPI && nodeutil.IsKubeEdgeNode(node) {
+		args = append(args, fmt.Sprintf("--metaserver-address=%s", handler.metaServerAddress))
+	}
+
+	if len(args) > 0 || superEdge != (superEdgeComponents{}) {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, args...)
+		handler.useSuperEdge(pod, superEdge)
+		pod.Labels[constants.KeyPodHash] = computePodHash(pod.Spec)
+	}
+
+	if pool := node.Labels[constants.KeyOpenYurtNodePool]; handler.openYurt && pool != "" {
+		pod.Labels[constants.KeyOpenYurtPoolName] = pool
+	}
+
+	return pod
+}
+
+// useBootstrapVolumes replaces TLS secret volumes of agent pod with an emptyDir volume where agent saves
+// its key and certificate, and a projected service account token which agent bootstraps the certificate by
+func (handler *agentPodHandler) useBootstrapVolumes(pod *corev1.Pod) {
+	expirationSeconds := int64(3600)
+
+	pod.Spec.ServiceAccountName = handler.serviceAccountName
+	pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+		"--cert-bootstrap",
+		fmt.Sprintf("--bootstrap-token-file=%s/token", agentBootstrapTokenDir),
+		fmt.Sprintf("--bootstrap-ca-file=/etc/fabedge/%s", agentConfigCABundleFileName),
+	)
+
+	var volumes []corev1.Volume
+	for _, volume := range pod.Spec.Volumes {
+		switch volume.Name {
+		case "ipsec-secrets":
+			continue
+		case "ipsec-d":
+			volume.VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		}
+		volumes = append(volumes, volume)
+	}
+	pod.Spec.Volumes = append(volumes, corev1.Volume{
+		Name: "bootstrap-token",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          tokenpkg.AgentTokenAudience,
+							ExpirationSeconds: &expirationSeconds,
+							Path:              "token",
+						},
+					},
+				},
+			},
+		},
+	})
+
+	// agent writes the key and certificate, strongswan gets them from agent by vici
+	agent := &pod.Spec.Containers[0]
+	for i := range agent.VolumeMounts {
+		if agent.VolumeMounts[i].Name == "ipsec-d" {
+			agent.VolumeMounts[i].ReadOnly = false
+		}
+	}
+	agent.VolumeMounts = append(agent.VolumeMounts, corev1.VolumeMount{
+		Name:      "bootstrap-token",
+		MountPath: agentBootstrapTokenDir,
+		ReadOnly:  true,
+	})
+
+	strongswan := &pod.Spec.Containers[1]
+	var mounts []corev1.VolumeMount
+	for _, mount := range strongswan.VolumeMounts {
+		if mount.Name != "ipsec-secrets" {
+			mounts = append(mounts, mount)
+		}
+	}
+	strongswan.VolumeMounts = mounts
+}
+
+// isProxyEnabled decides whether agent's proxy should be enabled on the node and explains why.
+// If kube-proxy or EdgeMesh runs on the node, agent's proxy has to be disabled, otherwise services
+// will be programmed twice which breaks traffic
+func (handler *agentPodHandler) isProxyEnabled(ctx context.Context, node corev1.Node) (bool, string, error) {
+	if !handler.enableProxy {
+		return false, "proxy is disabled by operator", nil
+	}
+
+	// EdgeMesh intercepts traffic to services by its own iptables rules
+	if handler.kubeEdge {
+		ds, err := handler.getDaemonSet(ctx, edgeMeshNamespace, edgeMeshName)
+		if err != nil {
+			return false, "", err
+		}
+
+		if ds != nil && nodeutil.IsSchedulableBy(node, ds.Spec.Template.Spec) {
+			return false, "EdgeMesh daemonset is running on this node", nil
+		}
+	}
+
+	if !handler.detectKubeProxy {
+		return true, "proxy is enabled by operator", nil
+	}
+
+	switch node.Annotations[constants.KeyKubeProxy] {
+	case "true":
+		return false, "kube-proxy is declared to be running on this node by annotation", nil
+	case "false":
+		return true, "kube-proxy is declared to be absent on this node by annotation", nil
+	}
+
+	ds, err := handler.getDaemonSet(ctx, kubeProxyNamespace, kubeProxyName)
+	switch {
+	case err != nil:
+		return false, "", err
+	case ds == nil:
+		return true, "kube-proxy daemonset is not found", nil
+	}
+
+	if nodeutil.IsSchedulableBy(node, ds.Spec.Template.Spec) {
+		return false, "kube-proxy daemonset is running on this node", nil
+	}
+
+	return true, "kube-proxy daemonset is not running on this node", nil
+}
+
+// getDaemonSet returns nil if the daemonset is not found
+func (handler *agentPodHandler) getDaemonSet(ctx context.Context, namespace, name string) (*appsv1.DaemonSet, error) {
+	var ds appsv1.DaemonSet
+	err := handler.client.Get(ctx, ObjectKey{Name: name, Namespace: namespace}, &ds)
+	switch {
+	case err == nil:
+		return &ds, nil
+	case errors.IsNotFound(err):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName string, enableProxy bool) *corev1.Pod {
 	hostPathDirectory := corev1.HostPathDirectory
 	hostPathDirectoryOrCreate := corev1.HostPathDirectoryOrCreate
 	privileged := true
@@ -108,6 +379,10 @@ func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName strin
 	automountServiceAccountToken := false
 
 	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podName,
 			Namespace: namespace,
@@ -144,7 +419,7 @@ func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName strin
 						fmt.Sprintf("--enable-hairpinmode=%t", handler.enableHairpinMode),
 						fmt.Sprintf("--network-plugin-mtu=%d", handler.networkPluginMTU),
 						fmt.Sprintf("--use-xfrm=%t", handler.useXfrm),
-						fmt.Sprintf("--enable-proxy=%t", handler.enableProxy),
+						fmt.Sprintf("--enable-proxy=%t", enableProxy),
 						fmt.Sprintf("-v=%d", handler.logLevel),
 					},
 					SecurityContext: &corev1.SecurityContext{
@@ -237,6 +512,10 @@ func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName strin
 									Key:  secretutil.KeyCACert,
 									Path: "cacerts/ca.crt",
 								},
+								{
+									Key:  secretutil.KeyCABundle,
+									Path: "cacerts/ca-bundle.crt",
+								},
 								{
 									Key:  corev1.TLSCertKey,
 									Path: "certs/tls.crt",
@@ -268,6 +547,145 @@ func (handler *agentPodHandler) buildAgentPod(namespace, nodeName, podName strin
 		},
 	}
 
+	if handler.subnetsPerChildSA > 0 {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+			fmt.Sprintf("--subnets-per-child-sa=%d", handler.subnetsPerChildSA),
+		)
+	}
+
+	if handler.apiServerAddress != "" {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+			fmt.Sprintf("--api-server-address=%s", handler.apiServerAddress),
+		)
+	}
+
+	if handler.enableFirewall {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+			"--enable-firewall",
+			fmt.Sprintf("--firewall-allowed-ports=%s", strings.Join(handler.firewallPorts, ",")),
+		)
+	}
+
+	if handler.fipsMode {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, "--fips-mode")
+	}
+
+	if handler.certBootstrap {
+		handler.useBootstrapVolumes(pod)
+	}
+
+	if handler.dnsListenAddress != "" {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+			fmt.Sprintf("--dns-listen-address=%s", handler.dnsListenAddress),
+			fmt.Sprintf("--dns-upstreams=%s", strings.Join(handler.dnsUpstreams, ",")),
+		)
+	}
+
+	if enableProxy {
+		for _, arg := range []struct {
+			name  string
+			value time.Duration
+		}{
+			{"ipvs-tcp-timeout", handler.ipvsTCPTimeout},
+			{"ipvs-tcpfin-timeout", handler.ipvsTCPFinTimeout},
+			{"ipvs-udp-timeout", handler.ipvsUDPTimeout},
+			{"ipvs-graceful-termination", handler.ipvsGracefulTermination},
+		} {
+			if arg.value > 0 {
+				pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--%s=%s", arg.name, arg.value))
+			}
+		}
+	}
+
+	if handler.offlineCache {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--cache-dir=%s", agentCacheDir))
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "cache",
+			MountPath: agentCacheDir,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "cache",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: agentCacheDir,
+					Type: &hostPathDirectoryOrCreate,
+				},
+			},
+		})
+	}
+
+	if handler.metricsPort > 0 {
+		probe := &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromInt(handler.metricsPort),
+				},
+			},
+			PeriodSeconds:    10,
+			FailureThreshold: 3,
+		}
+		// strongswan may be started later than agent, so liveness probe is delayed
+		livenessProbe := probe.DeepCopy()
+		livenessProbe.InitialDelaySeconds = 30
+
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--metrics-bind-address=:%d", handler.metricsPort))
+		pod.Spec.Containers[0].LivenessProbe = livenessProbe
+		pod.Spec.Containers[0].ReadinessProbe = probe
+	}
+
+	if handler.crlSecretName != "" {
+		optional := true
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args,
+			fmt.Sprintf("--crl-file=%s/%s", agentCRLDir, secretutil.KeyCRL),
+		)
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "crl",
+			MountPath: agentCRLDir,
+			ReadOnly:  true,
+		})
+		// CRL secret may not exist, e.g. member cluster didn't get CRLs from host cluster yet
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "crl",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  handler.crlSecretName,
+					DefaultMode: &defaultMode,
+					Optional:    &optional,
+				},
+			},
+		})
+	}
+
+	if handler.configResource {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--agent-config-namespace=%s", namespace))
+		if handler.probeInterval > 0 {
+			pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--probe-interval=%s", handler.probeInterval))
+		}
+		if handler.flapThreshold > 0 {
+			pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--flap-threshold=%d", handler.flapThreshold))
+		}
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].Name == "netconf" {
+				pod.Spec.Volumes[i].VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+			}
+		}
+	}
+
+	if handler.nodeCondition != "" || handler.relayTimeout > 0 || handler.configResource {
+		automountServiceAccountToken = true
+		pod.Spec.ServiceAccountName = handler.serviceAccountName
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--node-name=%s", nodeName))
+	}
+
+	if handler.nodeCondition != "" {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--node-condition=%s", handler.nodeCondition))
+	}
+
+	if handler.relayTimeout > 0 {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--relay-timeout=%s", handler.relayTimeout))
+	}
+
 	if handler.enableIPAM {
 		container := handler.buildEnvPrepareContainer()
 		pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
@@ -343,6 +761,147 @@ func (handler *agentPodHandler) buildEnvPrepareContainer() corev1.Container {
 	}
 }
 
+// buildCleanupPod builds a pod which runs agent in cleanup mode to remove network
+// settings made by agent on the node, the pod exits after cleanup is done
+func (handler *agentPodHandler) buildCleanupPod(nodeName string, enableProxy bool) *corev1.Pod {
+	handler.mux.RLock()
+	defer handler.mux.RUnlock()
+
+	hostPathDirectory := corev1.HostPathDirectory
+	hostPathDirectoryOrCreate := corev1.HostPathDirectoryOrCreate
+	privileged := true
+	automountServiceAccountToken := false
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getCleanupPodName(nodeName),
+			Namespace: handler.namespace,
+			Labels: map[string]string{
+				constants.KeyFabedgeAPP: constants.AppAgentCleanup,
+				constants.KeyCreatedBy:  constants.AppOperator,
+			},
+		},
+		Spec: corev1.PodSpec{
+			AutomountServiceAccountToken: &automountServiceAccountToken,
+			NodeName:                     nodeName,
+			HostNetwork:                  true,
+			RestartPolicy:                corev1.RestartPolicyOnFailure,
+			Tolerations: []corev1.Toleration{
+				{
+					Key:      "",
+					Operator: corev1.TolerationOpExists,
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:            "cleanup",
+					Image:           handler.agentImage,
+					ImagePullPolicy: handler.imagePullPolicy,
+					Args: []string{
+						"--cleanup",
+						fmt.Sprintf("--enable-ipam=%t", handler.enableIPAM),
+						fmt.Sprintf("--use-xfrm=%t", handler.useXfrm),
+						fmt.Sprintf("--enable-proxy=%t", enableProxy),
+						fmt.Sprintf("-v=%d", handler.logLevel),
+					},
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: &privileged,
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "lib-modules",
+							MountPath: "/lib/modules",
+							ReadOnly:  true,
+						},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "lib-modules",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{
+							Path: "/lib/modules",
+							Type: &hostPathDirectory,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// cached config is removed, xfrm policies of peers in it are removed too
+	if handler.offlineCache {
+		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("--cache-dir=%s", agentCacheDir))
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "cache",
+			MountPath: agentCacheDir,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "cache",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: agentCacheDir,
+					Type: &hostPathDirectoryOrCreate,
+				},
+			},
+		})
+	}
+
+	if handler.enableIPAM {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "cni-config",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: "/etc/cni/net.d",
+					Type: &hostPathDirectoryOrCreate,
+				},
+			},
+		})
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "cni-config",
+			MountPath: "/etc/cni/net.d",
+		})
+	}
+
+	return pod
+}
+
+// runCleanupPod makes sure a cleanup pod is created on the node and returns true if the pod succeeds
+func (handler *agentPodHandler) runCleanupPod(ctx context.Context, node corev1.Node) (bool, error) {
+	log := handler.log.WithValues("nodeName", node.Name)
+
+	var pod corev1.Pod
+	err := handler.client.Get(ctx, ObjectKey{Name: getCleanupPodName(node.Name), Namespace: handler.namespace}, &pod)
+	switch {
+	case err == nil:
+		return pod.Status.Phase == corev1.PodSucceeded, nil
+	case errors.IsNotFound(err):
+	default:
+		log.Error(err, "failed to get cleanup pod")
+		return false, err
+	}
+
+	enableProxy, _, err := handler.isProxyEnabled(ctx, node)
+	if err != nil {
+		log.Error(err, "failed to decide whether proxy is enabled")
+		return false, err
+	}
+
+	log.V(3).Info("create cleanup pod")
+	newPod := handler.buildCleanupPod(node.Name, enableProxy)
+	if err = handler.client.Patch(ctx, newPod, client.Apply, applyOptions...); err != nil {
+		log.Error(err, "failed to create cleanup pod")
+		return false, err
+	}
+
+	return false, nil
+}
+
 func (handler *agentPodHandler) Undo(ctx context.Context, nodeName string) error {
 	pod := corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -361,6 +920,10 @@ func (handler *agentPodHandler) Undo(ctx context.Context, nodeName string) error
 	return err
 }
 
+func getCleanupPodName(nodeName string) string {
+	return fmt.Sprintf("fabedge-agent-cleanup-%s", nodeName)
+}
+
 func getAgentPodName(nodeName string) string {
 	return fmt.Sprintf("fabedge-agent-%s", nodeName)
 }
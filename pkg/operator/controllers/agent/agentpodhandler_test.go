@@ -16,14 +16,18 @@ package agent
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2/klogr"
 
 	"github.com/fabedge/fabedge/pkg/common/constants"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
 	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 )
 
@@ -122,6 +126,10 @@ var _ = Describe("AgentPodHandler", func() {
 								Key:  secretutil.KeyCACert,
 								Path: "cacerts/ca.crt",
 							},
+							{
+								Key:  secretutil.KeyCABundle,
+								Path: "cacerts/ca-bundle.crt",
+							},
 							{
 								Key:  corev1.TLSCertKey,
 								Path: "certs/tls.crt",
@@ -286,7 +294,7 @@ var _ = Describe("AgentPodHandler", func() {
 	It("agent pod should not contain CNI related volumes and initContainer when enableIPAM is false", func() {
 		handler.enableIPAM = false
 		agentPodName := getAgentPodName(node.Name)
-		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName)
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
 
 		Expect(len(pod.Spec.Volumes)).To(Equal(5))
 
@@ -331,6 +339,10 @@ var _ = Describe("AgentPodHandler", func() {
 								Key:  secretutil.KeyCACert,
 								Path: "cacerts/ca.crt",
 							},
+							{
+								Key:  secretutil.KeyCABundle,
+								Path: "cacerts/ca-bundle.crt",
+							},
 							{
 								Key:  corev1.TLSCertKey,
 								Path: "certs/tls.crt",
@@ -436,4 +448,330 @@ var _ = Describe("AgentPodHandler", func() {
 		err := k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: agentPodName}, &pod)
 		Expect(errors.IsNotFound(err) || pod.DeletionTimestamp != nil).Should(BeTrue())
 	})
+
+	It("should disable proxy on the node where kube-proxy is declared to be running", func() {
+		handler.enableProxy = true
+		handler.detectKubeProxy = true
+
+		enabled, reason, err := handler.isProxyEnabled(context.TODO(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(enabled).To(BeTrue())
+		Expect(reason).To(Equal("kube-proxy daemonset is not found"))
+
+		node.Annotations[constants.KeyKubeProxy] = "true"
+		enabled, _, err = handler.isProxyEnabled(context.TODO(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(enabled).To(BeFalse())
+
+		handler.enableProxy = false
+		node.Annotations[constants.KeyKubeProxy] = "false"
+		enabled, _, err = handler.isProxyEnabled(context.TODO(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(enabled).To(BeFalse())
+	})
+
+	It("should grant agent pod a service account when node condition is enabled", func() {
+		handler.nodeCondition = "NetworkUnavailable"
+		handler.serviceAccountName = "fabedge-agent"
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(*pod.Spec.AutomountServiceAccountToken).To(BeTrue())
+		Expect(pod.Spec.ServiceAccountName).To(Equal("fabedge-agent"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElements(
+			"--node-name="+node.Name,
+			"--node-condition=NetworkUnavailable",
+		))
+	})
+
+	It("should disable proxy on the node where EdgeMesh is running in KubeEdge mode", func() {
+		handler.enableProxy = true
+		handler.kubeEdge = true
+
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: edgeMeshNamespace}}
+		err := k8sClient.Create(context.Background(), &ns)
+		Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+		labels := map[string]string{"app": "edgemesh-agent"}
+		ds := appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: edgeMeshName, Namespace: edgeMeshNamespace},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "edgemesh-agent", Image: "kubeedge/edgemesh-agent"}},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &ds)).To(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), &ds)).To(Succeed())
+		}()
+
+		enabled, reason, err := handler.isProxyEnabled(context.TODO(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(enabled).To(BeFalse())
+		Expect(reason).To(Equal("EdgeMesh daemonset is running on this node"))
+
+		handler.kubeEdge = false
+		enabled, _, err = handler.isProxyEnabled(context.TODO(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(enabled).To(BeTrue())
+	})
+
+	It("should pass metaserver address to agents on nodes managed by edgecore in KubeEdge mode", func() {
+		handler.kubeEdge = true
+		handler.metaServerAddress = "http://127.0.0.1:10550"
+		handler.nodeCondition = "NetworkUnavailable"
+		handler.serviceAccountName = "fabedge-agent"
+
+		pod := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--metaserver-address")))
+
+		node.Status.NodeInfo.KubeletVersion = "v1.19.3-kubeedge-v1.5.0"
+		pod = handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--metaserver-address=http://127.0.0.1:10550"))
+		Expect(pod.Labels[constants.KeyPodHash]).To(Equal(computePodHash(pod.Spec)))
+
+		By("skipping metaserver if agent doesn't access API")
+		handler.nodeCondition = ""
+		pod = handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--metaserver-address")))
+	})
+
+	It("should label agent pod with NodePool of its node in OpenYurt mode", func() {
+		handler.openYurt = true
+
+		pod := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Labels).NotTo(HaveKey(constants.KeyOpenYurtPoolName))
+
+		node.Labels[constants.KeyOpenYurtNodePool] = "hangzhou"
+		pod = handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Labels).To(HaveKeyWithValue(constants.KeyOpenYurtPoolName, "hangzhou"))
+	})
+
+	It("should make agent work with SuperEdge components on its node in SuperEdge mode", func() {
+		handler.superEdge = true
+		handler.liteAPIServerAddress = "https://127.0.0.1:51003"
+		handler.nodeCondition = "NetworkUnavailable"
+		handler.serviceAccountName = "fabedge-agent"
+		handler.enableFirewall = true
+		handler.firewallPorts = []string{"22"}
+
+		pod := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--lite-apiserver-address")))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--firewall-allowed-ports=22"))
+
+		pod = handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{liteAPIServer: true, edgeHealth: true})
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--lite-apiserver-address=https://127.0.0.1:51003"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--firewall-allowed-ports=22,51005"))
+		Expect(pod.Labels[constants.KeyPodHash]).To(Equal(computePodHash(pod.Spec)))
+
+		By("skipping lite-apiserver if agent doesn't access API")
+		handler.nodeCondition = ""
+		pod = handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{liteAPIServer: true})
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--lite-apiserver-address")))
+	})
+
+	It("should grant agent pod a service account when relay timeout is set", func() {
+		handler.relayTimeout = 5 * time.Minute
+		handler.serviceAccountName = "fabedge-agent"
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(*pod.Spec.AutomountServiceAccountToken).To(BeTrue())
+		Expect(pod.Spec.ServiceAccountName).To(Equal("fabedge-agent"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElements(
+			"--node-name="+node.Name,
+			"--relay-timeout=5m0s",
+		))
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--node-condition")))
+	})
+
+	It("should pass probe interval to agent only if agent config is kept in AgentConfig", func() {
+		handler.probeInterval = time.Minute
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--probe-interval")))
+
+		handler.configResource = true
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElements(
+			"--agent-config-namespace="+handler.namespace,
+			"--probe-interval=1m0s",
+		))
+	})
+
+	It("should pass flap threshold to agent only if agent config is kept in AgentConfig", func() {
+		handler.flapThreshold = 3
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement(HavePrefix("--flap-threshold")))
+
+		handler.configResource = true
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--flap-threshold=3"))
+	})
+
+	It("should mount CRL secret to agent pod if CRL secret is provided", func() {
+		handler.crlSecretName = "fabedge-crl"
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--crl-file=/etc/fabedge-crl/crl.pem"))
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      "crl",
+			MountPath: "/etc/fabedge-crl",
+			ReadOnly:  true,
+		}))
+
+		var volume corev1.Volume
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == "crl" {
+				volume = v
+			}
+		}
+		Expect(volume.Secret).NotTo(BeNil())
+		Expect(volume.Secret.SecretName).To(Equal("fabedge-crl"))
+		Expect(*volume.Secret.Optional).To(BeTrue())
+	})
+
+	It("should pass API server address to agent to renew its certificate", func() {
+		handler.apiServerAddress = "https://10.0.0.1:30303"
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--api-server-address=https://10.0.0.1:30303"))
+	})
+
+	It("should pass firewall settings to agent if firewall is enabled", func() {
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).NotTo(ContainElement("--enable-firewall"))
+
+		handler.enableFirewall = true
+		handler.firewallPorts = []string{"22", "10250"}
+
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--enable-firewall"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--firewall-allowed-ports=22,10250"))
+	})
+
+	It("should pass fips mode to agent", func() {
+		handler.fipsMode = true
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--fips-mode"))
+	})
+
+	It("should pass DNS settings to agent if DNS listen address is provided", func() {
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		for _, arg := range pod.Spec.Containers[0].Args {
+			Expect(arg).NotTo(HavePrefix("--dns-"))
+		}
+
+		handler.dnsListenAddress = "169.254.20.10:53"
+		handler.dnsUpstreams = []string{"10.96.0.10:53"}
+
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--dns-listen-address=169.254.20.10:53"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--dns-upstreams=10.96.0.10:53"))
+	})
+
+	It("should pass ipvs settings to agent only if proxy is enabled", func() {
+		handler.ipvsTCPTimeout = 900 * time.Second
+		handler.ipvsGracefulTermination = time.Minute
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		for _, arg := range pod.Spec.Containers[0].Args {
+			Expect(arg).NotTo(HavePrefix("--ipvs-"))
+		}
+
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, true)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--ipvs-tcp-timeout=15m0s"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--ipvs-graceful-termination=1m0s"))
+		for _, arg := range pod.Spec.Containers[0].Args {
+			Expect(arg).NotTo(HavePrefix("--ipvs-udp-timeout"))
+		}
+	})
+
+	It("should serve metrics and probe /healthz of agent if metrics port is set", func() {
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].LivenessProbe).To(BeNil())
+		Expect(pod.Spec.Containers[0].ReadinessProbe).To(BeNil())
+
+		handler.metricsPort = 30307
+		pod = handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--metrics-bind-address=:30307"))
+
+		for _, probe := range []*corev1.Probe{pod.Spec.Containers[0].LivenessProbe, pod.Spec.Containers[0].ReadinessProbe} {
+			Expect(probe).NotTo(BeNil())
+			Expect(probe.HTTPGet.Path).To(Equal("/healthz"))
+			Expect(probe.HTTPGet.Port.IntValue()).To(Equal(30307))
+		}
+	})
+
+	It("should run agent in low resource mode if the node is annotated so", func() {
+		normal := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(normal.Spec.Containers[0].Args).NotTo(ContainElement("--resource-mode=low"))
+
+		node.Annotations = map[string]string{constants.KeyAgentResourceMode: "low"}
+		low := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(low.Spec.Containers[0].Args).To(ContainElement("--resource-mode=low"))
+		Expect(low.Labels[constants.KeyPodHash]).NotTo(Equal(normal.Labels[constants.KeyPodHash]))
+
+		node.Annotations = map[string]string{constants.KeyAgentResourceMode: "unknown"}
+		unknown := handler.buildAgentPodOfNode(node, agentPodName, false, superEdgeComponents{})
+		Expect(unknown.Labels[constants.KeyPodHash]).To(Equal(normal.Labels[constants.KeyPodHash]))
+	})
+
+	It("should mount a hostPath cache dir to agent if offline cache is enabled", func() {
+		handler.offlineCache = true
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--cache-dir=/var/lib/fabedge/agent"))
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      "cache",
+			MountPath: "/var/lib/fabedge/agent",
+		}))
+
+		var volume corev1.Volume
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == "cache" {
+				volume = v
+			}
+		}
+		Expect(volume.HostPath).NotTo(BeNil())
+		Expect(volume.HostPath.Path).To(Equal("/var/lib/fabedge/agent"))
+	})
+
+	It("should use emptyDir and projected token instead of TLS secret if cert bootstrap is enabled", func() {
+		handler.certBootstrap = true
+		handler.serviceAccountName = "fabedge-agent"
+
+		pod := handler.buildAgentPod(handler.namespace, node.Name, agentPodName, false)
+		Expect(pod.Spec.ServiceAccountName).To(Equal("fabedge-agent"))
+		Expect(pod.Spec.Containers[0].Args).To(ContainElement("--cert-bootstrap"))
+
+		for _, volume := range pod.Spec.Volumes {
+			Expect(volume.Name).NotTo(Equal("ipsec-secrets"))
+			Expect(volume.Secret).To(BeNil())
+
+			switch volume.Name {
+			case "ipsec-d":
+				Expect(volume.EmptyDir).NotTo(BeNil())
+			case "bootstrap-token":
+				token := volume.Projected.Sources[0].ServiceAccountToken
+				Expect(token.Audience).To(Equal(tokenpkg.AgentTokenAudience))
+			}
+		}
+
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      "ipsec-d",
+			MountPath: "/etc/ipsec.d",
+		}))
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      "bootstrap-token",
+			MountPath: agentBootstrapTokenDir,
+			ReadOnly:  true,
+		}))
+		for _, mount := range pod.Spec.Containers[1].VolumeMounts {
+			Expect(mount.Name).NotTo(Equal("ipsec-secrets"))
+		}
+	})
 })
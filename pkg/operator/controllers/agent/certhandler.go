@@ -16,9 +16,15 @@ package agent
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,6 +36,7 @@ import (
 	"github.com/fabedge/fabedge/pkg/operator/types"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 var _ Handler = &certHandler{}
@@ -38,8 +45,17 @@ type certHandler struct {
 	namespace string
 
 	getEndpointName  types.GetNameFunc
+	getEndpointID    types.GetIDFunc
 	certManager      certutil.Manager
 	certOrganization string
+	certKeyType      certutil.KeyType
+	certKeySize      int
+	certSigAlgorithm x509.SignatureAlgorithm
+	// reissueLimiter paces reissues of certificates when CA is being rotated
+	reissueLimiter *rate.Limiter
+	// csrSignerName makes certificates requested by CertificateSigningRequests of this signer, empty
+	// means certificates are signed by certManager directly
+	csrSignerName string
 
 	client client.Client
 	log    logr.Logger
@@ -60,7 +76,10 @@ func (handler *certHandler) Do(ctx context.Context, node corev1.Node) error {
 		}
 
 		log.V(5).Info("TLS secret for agent is not found, generate it now")
-		secret, err = handler.buildCertAndKeySecret(secretName, node)
+		secret, err = handler.newCertAndKeySecret(ctx, secretName, node)
+		if err == errCertPending {
+			return err
+		}
 		if err != nil {
 			log.Error(err, "failed to create cert and key for agent")
 			return err
@@ -71,24 +90,50 @@ func (handler *certHandler) Do(ctx context.Context, node corev1.Node) error {
 			return err
 		}
 
-		err = handler.client.Create(ctx, &secret)
+		err = handler.client.Patch(ctx, &secret, client.Apply, applyOptions...)
 		if err != nil {
 			log.Error(err, "failed to create secret")
 			return err
 		}
 
-		return errRestartAgent
+		return handler.finishRequest(ctx, node.Name)
 	}
 
 	certPEM := secretutil.GetCert(secret)
 	err = handler.certManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient)
+	if err == nil && handler.getEndpointID != nil {
+		// cert is reissued when SPIFFE IDs are enabled or disabled
+		err = certutil.VerifySPIFFEIDInPEM(certPEM, handler.getEndpointID(node.Name))
+	}
 	if err == nil {
 		log.V(5).Info("cert is verified")
-		return nil
+
+		// secrets made by old versions have no CA bundle, it's added without restarting agent
+		if len(secretutil.GetCABundle(secret)) == 0 {
+			secret.Data[secretutil.KeyCABundle] = secretutil.GetCACert(secret)
+			if err = handler.client.Update(ctx, &secret); err != nil {
+				log.Error(err, "failed to add CA bundle to secret")
+				return err
+			}
+		}
+
+		if secretutil.IsCAUpToDate(secret, handler.certManager) {
+			return handler.removeCSRKey(ctx, node.Name)
+		}
+
+		if !handler.reissueLimiter.Allow() {
+			log.V(5).Info("CA is being rotated, cert will be reissued later")
+			return errReissueDeferred
+		}
+		log.V(3).Info("CA is being rotated, reissue a cert to agent")
+	} else {
+		log.Error(err, "failed to verify cert, need to regenerate a cert to agent")
 	}
 
-	log.Error(err, "failed to verify cert, need to regenerate a cert to agent")
-	secret, err = handler.buildCertAndKeySecret(secretName, node)
+	secret, err = handler.newCertAndKeySecret(ctx, secretName, node)
+	if err == errCertPending {
+		return err
+	}
 	if err != nil {
 		log.Error(err, "failed to recreate cert and key for agent")
 		return err
@@ -99,24 +144,45 @@ func (handler *certHandler) Do(ctx context.Context, node corev1.Node) error {
 		return err
 	}
 
-	if err = handler.client.Update(ctx, &secret); err != nil {
+	if err = handler.client.Patch(ctx, &secret, client.Apply, applyOptions...); err != nil {
 		log.Error(err, "failed to save secret")
 		return err
 	}
 
+	return handler.finishRequest(ctx, node.Name)
+}
+
+func (handler *certHandler) newCertAndKeySecret(ctx context.Context, secretName string, node corev1.Node) (corev1.Secret, error) {
+	if handler.csrSignerName != "" {
+		return handler.requestCertAndKeySecret(ctx, secretName, node)
+	}
+
+	return handler.buildCertAndKeySecret(ctx, secretName, node)
+}
+
+// finishRequest removes the certificate signing request of node and the secret of its private key
+// after its certificate is saved, the private key is only kept in TLS secret of agent afterwards.
+// If they fail to be removed, removeCSRKey removes them later
+func (handler *certHandler) finishRequest(ctx context.Context, nodeName string) error {
+	if handler.csrSignerName != "" {
+		if err := handler.deleteCSR(ctx, nodeName); err != nil {
+			handler.log.Error(err, "failed to delete certificate signing request", "nodeName", nodeName)
+		}
+	}
+
 	return errRestartAgent
 }
 
-func (handler *certHandler) buildCertAndKeySecret(secretName string, node corev1.Node) (corev1.Secret, error) {
-	keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
-		CommonName:   handler.getEndpointName(node.Name),
-		Organization: []string{handler.certOrganization},
-	})
+func (handler *certHandler) buildCertAndKeySecret(ctx context.Context, secretName string, node corev1.Node) (corev1.Secret, error) {
+	_, span := tracing.Start(ctx, "SignCert", trace.WithAttributes(attribute.String("purpose", "agent")))
+	keyDER, csr, err := certutil.NewCertRequest(handler.getCertRequest(node.Name))
 	if err != nil {
+		tracing.End(span, err)
 		return corev1.Secret{}, err
 	}
 
 	certDER, err := handler.certManager.SignCert(csr)
+	tracing.End(span, err)
 	if err != nil {
 		return corev1.Secret{}, err
 	}
@@ -127,11 +193,41 @@ func (handler *certHandler) buildCertAndKeySecret(secretName string, node corev1
 		EncodeCert(certDER).
 		EncodeKey(keyDER).
 		CACertPEM(handler.certManager.GetCACertPEM()).
+		CABundlePEM(handler.certManager.GetCABundlePEM()).
 		Label(constants.KeyCreatedBy, constants.AppOperator).
 		Label(constants.KeyNode, node.Name).Build(), nil
 }
 
+// getURIs returns SPIFFE ID of the agent if endpoint IDs are SPIFFE IDs, so peers can
+// authenticate the agent by it
+// getCertRequest returns the request to create certificate request of agent on node
+func (handler *certHandler) getCertRequest(nodeName string) certutil.Request {
+	return certutil.Request{
+		CommonName:         handler.getEndpointName(nodeName),
+		Organization:       []string{handler.certOrganization},
+		URIs:               handler.getURIs(nodeName),
+		KeyType:            handler.certKeyType,
+		KeySize:            handler.certKeySize,
+		SignatureAlgorithm: handler.certSigAlgorithm,
+	}
+}
+
+func (handler *certHandler) getURIs(nodeName string) []*url.URL {
+	if handler.getEndpointID == nil {
+		return nil
+	}
+
+	return certutil.URIsOfID(handler.getEndpointID(nodeName))
+}
+
 func (handler *certHandler) Undo(ctx context.Context, nodeName string) error {
+	if handler.csrSignerName != "" {
+		if err := handler.deleteCSR(ctx, nodeName); err != nil {
+			handler.log.Error(err, "failed to delete certificate signing request", "nodeName", nodeName)
+			return err
+		}
+	}
+
 	secret := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      getCertSecretName(nodeName),
@@ -149,6 +245,15 @@ func (handler *certHandler) Undo(ctx context.Context, nodeName string) error {
 	return err
 }
 
+// newReissueLimiter creates a limiter which allows one reissue per interval, 0 means no limit
+func newReissueLimiter(interval time.Duration) *rate.Limiter {
+	if interval <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+
+	return rate.NewLimiter(rate.Every(interval), 1)
+}
+
 func getCertSecretName(nodeName string) string {
 	return fmt.Sprintf("fabedge-agent-tls-%s", nodeName)
 }
@@ -16,12 +16,17 @@ package agent
 
 import (
 	"context"
+	"crypto/x509"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+	certv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2/klogr"
 
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
@@ -34,6 +39,7 @@ var _ = Describe("CertHandler", func() {
 		namespace = "default"
 		node      corev1.Node
 
+		caCertDER   []byte
 		certManager certutil.Manager
 		handler     *certHandler
 
@@ -42,7 +48,8 @@ var _ = Describe("CertHandler", func() {
 	)
 
 	BeforeEach(func() {
-		caCertDER, caKeyDER, _ := certutil.NewSelfSignedCA(certutil.Config{
+		var caKeyDER []byte
+		caCertDER, caKeyDER, _ = certutil.NewSelfSignedCA(certutil.Config{
 			CommonName:     certutil.DefaultCAName,
 			Organization:   []string{certutil.DefaultOrganization},
 			IsCA:           true,
@@ -55,6 +62,7 @@ var _ = Describe("CertHandler", func() {
 			certManager:      certManager,
 			getEndpointName:  getEndpointName,
 			certOrganization: certutil.DefaultOrganization,
+			reissueLimiter:   newReissueLimiter(0),
 			log:              klogr.New().WithName("configHandler"),
 		}
 
@@ -99,6 +107,142 @@ var _ = Describe("CertHandler", func() {
 		Expect(caCertPEM).Should(Equal(certManager.GetCACertPEM()))
 	})
 
+	It("should add CA bundle to secrets made by old versions without restarting agent", func() {
+		var secret corev1.Secret
+		secretName := getCertSecretName(node.Name)
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: secretName}, &secret)).Should(Succeed())
+		Expect(secretutil.GetCABundle(secret)).Should(Equal(certManager.GetCABundlePEM()))
+
+		delete(secret.Data, secretutil.KeyCABundle)
+		Expect(k8sClient.Update(context.Background(), &secret)).Should(Succeed())
+
+		Expect(handler.Do(context.Background(), node)).Should(Succeed())
+
+		secret = corev1.Secret{}
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: secretName}, &secret)).Should(Succeed())
+		Expect(secretutil.GetCABundle(secret)).Should(Equal(certManager.GetCACertPEM()))
+	})
+
+	It("should reissue certificates one by one when CA is being rotated", func() {
+		anotherNode := newNode(getNodeName(), "10.40.20.182", "2.2.1.192/26")
+		anotherNode.UID = "654321"
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Equal(errRestartAgent))
+
+		newCACertDER, newCAKeyDER, _ := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: timeutil.Days(365),
+		})
+		newCertManager, err := certutil.NewManger(newCACertDER, newCAKeyDER, timeutil.Days(365), caCertDER)
+		Expect(err).Should(BeNil())
+
+		handler.certManager = newCertManager
+		handler.reissueLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+
+		By("Reissuing certificate of the first node")
+		Expect(handler.Do(context.Background(), node)).Should(Equal(errRestartAgent))
+
+		var secret corev1.Secret
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: getCertSecretName(node.Name)}, &secret)).Should(Succeed())
+		Expect(secretutil.IsCAUpToDate(secret, newCertManager)).Should(BeTrue())
+		Expect(secretutil.GetCACert(secret)).Should(Equal(newCertManager.GetCACertPEM()))
+		Expect(secretutil.GetCABundle(secret)).Should(Equal(newCertManager.GetCABundlePEM()))
+
+		By("Deferring reissue of the second node")
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Equal(errReissueDeferred))
+
+		secret = corev1.Secret{}
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: getCertSecretName(anotherNode.Name)}, &secret)).Should(Succeed())
+		Expect(secretutil.IsCAUpToDate(secret, newCertManager)).Should(BeFalse())
+
+		By("Checking up-to-date certificate is not reissued again")
+		Expect(handler.Do(context.Background(), node)).Should(Succeed())
+	})
+
+	It("should embed SPIFFE ID in certificate and reissue it when SPIFFE ID is enabled", func() {
+		secretName := getCertSecretName(node.Name)
+		getCert := func() *x509.Certificate {
+			var secret corev1.Secret
+			Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: secretName}, &secret)).Should(Succeed())
+
+			certDER, err := certutil.DecodePEM(secretutil.GetCert(secret))
+			Expect(err).Should(BeNil())
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			return cert
+		}
+		Expect(certutil.GetSPIFFEID(getCert())).Should(BeEmpty())
+
+		handler.getEndpointID = func(nodeName string) string { return "spiffe://example.org/cluster/" + nodeName }
+		Expect(handler.Do(context.Background(), node)).Should(Equal(errRestartAgent))
+		Expect(certutil.GetSPIFFEID(getCert())).Should(Equal("spiffe://example.org/cluster/" + node.Name))
+
+		By("Checking certificate with the right SPIFFE ID is not reissued")
+		Expect(handler.Do(context.Background(), node)).Should(Succeed())
+	})
+
+	It("should request certificate by CertificateSigningRequest if csr signer name is provided", func() {
+		handler.csrSignerName = "fabedge.io/agent"
+		anotherNode := newNode(getNodeName(), "10.40.20.183", "2.2.1.64/26")
+		anotherNode.UID = "987654"
+
+		By("Creating certificate signing request")
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Equal(errCertPending))
+
+		var csr certv1.CertificateSigningRequest
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: getCSRName(anotherNode.Name)}, &csr)).Should(Succeed())
+		Expect(csr.Spec.SignerName).Should(Equal(handler.csrSignerName))
+		Expect(csr.Spec.Usages).Should(ConsistOf(CSRUsages))
+		expectOwnerReference(&csr, anotherNode)
+
+		By("Waiting for the request to be approved and signed")
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Equal(errCertPending))
+
+		csr.Status.Conditions = append(csr.Status.Conditions, certv1.CertificateSigningRequestCondition{
+			Type:   certv1.CertificateApproved,
+			Status: corev1.ConditionTrue,
+			Reason: "Test",
+		})
+		clientset := kubernetes.NewForConfigOrDie(cfg)
+		_, err := clientset.CertificatesV1().CertificateSigningRequests().UpdateApproval(context.Background(), csr.Name, &csr, metav1.UpdateOptions{})
+		Expect(err).Should(BeNil())
+
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: csr.Name}, &csr)).Should(Succeed())
+		csrDER, err := certutil.DecodePEM(csr.Spec.Request)
+		Expect(err).Should(BeNil())
+		certDER, err := certManager.SignCert(csrDER)
+		Expect(err).Should(BeNil())
+		csr.Status.Certificate = certutil.EncodeCertPEM(certDER)
+		Expect(k8sClient.Status().Update(context.Background(), &csr)).Should(Succeed())
+
+		By("Saving the signed certificate and removing the request")
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Equal(errRestartAgent))
+
+		var secret corev1.Secret
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: getCertSecretName(anotherNode.Name)}, &secret)).Should(Succeed())
+		Expect(secretutil.GetCert(secret)).Should(Equal(csr.Status.Certificate))
+		Expect(certManager.VerifyCertInPEM(secretutil.GetCert(secret), certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+
+		err = k8sClient.Get(context.Background(), ObjectKey{Name: csr.Name}, &csr)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+		err = k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: getCSRKeySecretName(anotherNode.Name)}, &secret)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+
+		By("Removing the key secret which is left by a finished request")
+		keySecret := corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getCSRKeySecretName(anotherNode.Name),
+				Namespace: namespace,
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &keySecret)).Should(Succeed())
+		Expect(handler.Do(context.Background(), anotherNode)).Should(Succeed())
+
+		err = k8sClient.Get(context.Background(), ObjectKey{Namespace: namespace, Name: keySecret.Name}, &keySecret)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
 	It("should be able to delete cert secret created for specified node", func() {
 		Expect(handler.Undo(context.Background(), node.Name)).Should(Succeed())
 
@@ -17,6 +17,9 @@ package agent
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"gopkg.in/yaml.v2"
@@ -33,6 +36,7 @@ import (
 	"github.com/fabedge/fabedge/pkg/common/netconf"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 )
 
 var _ Handler = &configHandler{}
@@ -42,11 +46,65 @@ type configHandler struct {
 	store                storepkg.Interface
 	getEndpointName      types.GetNameFunc
 	getConnectorEndpoint types.EndpointGetter
-	client               client.Client
-	log                  logr.Logger
+	// connectorEndpoints holds endpoint getters of non-default connectors, the key is connector name
+	connectorEndpoints map[string]types.EndpointGetter
+	assignment         types.ConnectorAssignment
+	client             client.Client
+	log                logr.Logger
+	// connectorNames are names of all connectors, the default one included, edge nodes without a
+	// known connector label are assigned among them by hashing, it's nil if hashing is disabled
+	connectorNames []string
+
+	// certManager provides CA bundle which is put in configmap for agents which bootstrap
+	// their certificates, it's nil if agents' certificates are kept in TLS secrets
+	certManager certutil.Manager
+
+	// masqueradeCIDRs and nonMasqueradeCIDRs are defaults of outbound NAT of edge nodes,
+	// a node can override them by annotations
+	masqueradeCIDRs    []string
+	nonMasqueradeCIDRs []string
+
+	// configResource makes config of agents kept in AgentConfigs instead of configmaps
+	configResource bool
+
+	// localIngressInterface is the default LAN interface of edge nodes where services with local
+	// ingress ports are exposed, local ingress is disabled if it's empty
+	localIngressInterface string
 }
 
 func (handler *configHandler) Do(ctx context.Context, node corev1.Node) error {
+	networkConf := handler.buildNetworkConf(node)
+
+	var err error
+	networkConf.HostPorts, err = handler.getHostPorts(ctx, node.Name)
+	if err != nil {
+		handler.log.Error(err, "failed to get host ports of pods", "nodeName", node.Name)
+		return err
+	}
+
+	if handler.localIngressInterface != "" {
+		networkConf.LocalIngressInterface = handler.getLocalIngressInterface(node)
+		networkConf.LocalIngresses, err = handler.getLocalIngresses(ctx)
+		if err != nil {
+			handler.log.Error(err, "failed to get local ingress ports of services", "nodeName", node.Name)
+			return err
+		}
+	}
+
+	configDataBytes, err := yaml.Marshal(networkConf)
+	if err != nil {
+		handler.log.Error(err, "not able to marshal NetworkConf")
+		return err
+	}
+
+	if handler.configResource {
+		return handler.syncAgentConfig(ctx, node, string(configDataBytes))
+	}
+
+	return handler.syncConfigMap(ctx, node, string(configDataBytes))
+}
+
+func (handler *configHandler) syncConfigMap(ctx context.Context, node corev1.Node, configData string) error {
 	configName := getAgentConfigMapName(node.Name)
 	log := handler.log.WithValues("nodeName", node.Name, "configName", configName, "namespace", handler.namespace)
 
@@ -60,70 +118,128 @@ func (handler *configHandler) Do(ctx context.Context, node corev1.Node) error {
 	}
 	isConfigNotFound := errors.IsNotFound(err)
 
-	networkConf := handler.buildNetworkConf(node.Name)
-	configDataBytes, err := yaml.Marshal(networkConf)
-	if err != nil {
-		handler.log.Error(err, "not able to marshal NetworkConf")
-		return err
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configName,
+			Namespace: handler.namespace,
+			Labels: map[string]string{
+				constants.KeyFabedgeAPP: constants.AppAgent,
+				constants.KeyCreatedBy:  constants.AppOperator,
+			},
+		},
+		Data: map[string]string{
+			agentConfigTunnelFileName: configData,
+		},
 	}
 
-	configData := string(configDataBytes)
+	if err = controllerutil.SetControllerReference(&node, configMap, scheme.Scheme); err != nil {
+		log.Error(err, "failed to set ownerReference to configmap")
+		return err
+	}
 
 	if isConfigNotFound {
 		handler.log.V(5).Info("Agent configMap is not found, create it now")
-		configMap := &corev1.ConfigMap{
+		// agent controller just create configmap, the load balance rules is kept by proxy controller.
+		// services key is created by Create instead of Apply, so it won't be removed by later applying
+		// which leaves services key out
+		configMap.Data[agentConfigServicesFileName] = ""
+		return handler.client.Create(ctx, configMap, client.FieldOwner(constants.FieldManager))
+	}
+
+	if handler.certManager != nil {
+		configMap.Data[agentConfigCABundleFileName] = string(handler.certManager.GetCABundlePEM())
+	}
+
+	if configData == agentConfig.Data[agentConfigTunnelFileName] &&
+		configMap.Data[agentConfigCABundleFileName] == agentConfig.Data[agentConfigCABundleFileName] {
+		log.V(5).Info("agent config is not changed, skip updating")
+		return nil
+	}
+
+	err = handler.client.Patch(ctx, configMap, client.Apply, applyOptions...)
+	if err != nil {
+		log.Error(err, "failed to update agent configmap")
+	}
+
+	return err
+}
+
+// syncAgentConfig keeps tunnels and CA bundle in spec of AgentConfig of the node, services
+// are kept by proxy controller and status is reported by agent, so they are not touched
+func (handler *configHandler) syncAgentConfig(ctx context.Context, node corev1.Node, configData string) error {
+	key := ObjectKey{Name: getAgentConfigName(node.Name), Namespace: handler.namespace}
+	log := handler.log.WithValues("nodeName", node.Name, "key", key)
+
+	log.V(5).Info("Sync agent config")
+
+	var caBundle string
+	if handler.certManager != nil {
+		caBundle = string(handler.certManager.GetCABundlePEM())
+	}
+
+	var config apis.AgentConfig
+	err := handler.client.Get(ctx, key, &config)
+	switch {
+	case errors.IsNotFound(err):
+		log.V(5).Info("Agent config is not found, create it now")
+		config = apis.AgentConfig{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      configName,
-				Namespace: handler.namespace,
+				Name:      key.Name,
+				Namespace: key.Namespace,
 				Labels: map[string]string{
 					constants.KeyFabedgeAPP: constants.AppAgent,
 					constants.KeyCreatedBy:  constants.AppOperator,
 				},
 			},
-			Data: map[string]string{
-				agentConfigTunnelFileName: configData,
-				// agent controller just create configmap, the load balance rules is kept by proxy controller
-				agentConfigServicesFileName: "",
+			Spec: apis.AgentConfigSpec{
+				Tunnels:  configData,
+				CABundle: caBundle,
 			},
 		}
 
-		if err = controllerutil.SetControllerReference(&node, configMap, scheme.Scheme); err != nil {
-			log.Error(err, "failed to set ownerReference to configmap")
+		if err = controllerutil.SetControllerReference(&node, &config, scheme.Scheme); err != nil {
+			log.Error(err, "failed to set ownerReference to agent config")
 			return err
 		}
 
-		return handler.client.Create(ctx, configMap)
+		return handler.client.Create(ctx, &config, client.FieldOwner(constants.FieldManager))
+	case err != nil:
+		log.Error(err, "failed to get agent config")
+		return err
 	}
 
-	if configData == agentConfig.Data[agentConfigTunnelFileName] {
+	if config.Spec.Tunnels == configData && config.Spec.CABundle == caBundle {
 		log.V(5).Info("agent config is not changed, skip updating")
 		return nil
 	}
 
-	agentConfig.Data[agentConfigTunnelFileName] = configData
-	if err = controllerutil.SetControllerReference(&node, &agentConfig, scheme.Scheme); err != nil {
-		log.Error(err, "failed to set ownerReference to configmap")
-		return err
-	}
-
-	err = handler.client.Update(ctx, &agentConfig)
-	if err != nil {
-		log.Error(err, "failed to update agent configmap")
+	patch := client.MergeFrom(config.DeepCopy())
+	config.Spec.Tunnels = configData
+	config.Spec.CABundle = caBundle
+	if err = handler.client.Patch(ctx, &config, patch, client.FieldOwner(constants.FieldManager)); err != nil {
+		log.Error(err, "failed to update agent config")
 	}
 
 	return err
 }
 
-func (handler *configHandler) buildNetworkConf(nodeName string) netconf.NetworkConf {
+func (handler *configHandler) buildNetworkConf(node corev1.Node) netconf.NetworkConf {
 	store := handler.store
 
-	epName := handler.getEndpointName(nodeName)
+	epName := handler.getEndpointName(node.Name)
 	endpoint, _ := store.GetEndpoint(epName)
-	peerEndpoints := handler.getPeers(epName)
+	peerEndpoints := handler.getPeers(epName, handler.assignConnector(epName, node))
 
 	conf := netconf.NetworkConf{
-		Endpoint: endpoint,
-		Peers:    make([]apis.Endpoint, 0, len(peerEndpoints)),
+		Endpoint:           endpoint,
+		Peers:              make([]apis.Endpoint, 0, len(peerEndpoints)),
+		MasqueradeCIDRs:    handler.getCIDRsOfNode(node, constants.KeyMasqueradeCIDRs, handler.masqueradeCIDRs),
+		NonMasqueradeCIDRs: handler.getCIDRsOfNode(node, constants.KeyNonMasqueradeCIDRs, handler.nonMasqueradeCIDRs),
+		BandwidthRules:     handler.getBandwidthRules(epName),
 	}
 
 	for _, ep := range peerEndpoints {
@@ -133,7 +249,92 @@ func (handler *configHandler) buildNetworkConf(nodeName string) netconf.NetworkC
 	return conf
 }
 
-func (handler *configHandler) getPeers(name string) []apis.Endpoint {
+// getBandwidthRules makes a rule for each community of the endpoint with egress bandwidth, the rule
+// covers subnets of other members, a community without other members has no rule
+func (handler *configHandler) getBandwidthRules(name string) []netconf.BandwidthRule {
+	var rules []netconf.BandwidthRule
+	for _, community := range handler.store.GetCommunitiesByEndpoint(name) {
+		if community.EgressBandwidth <= 0 {
+			continue
+		}
+
+		members := community.Members.Difference(sets.NewString(name))
+		var cidrs []string
+		for _, ep := range handler.store.GetEndpoints(members.List()...) {
+			cidrs = append(cidrs, ep.Subnets...)
+			cidrs = append(cidrs, ep.NodeSubnets...)
+		}
+
+		if len(cidrs) == 0 {
+			continue
+		}
+
+		rules = append(rules, netconf.BandwidthRule{
+			Name:  community.Name,
+			Rate:  community.EgressBandwidth,
+			CIDRs: cidrs,
+		})
+	}
+
+	// communities are returned in random order, rules are sorted to keep config the same
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+
+	return rules
+}
+
+// getCIDRsOfNode returns CIDRs in the annotation of node, they are comma separated. If the node
+// doesn't have the annotation or any CIDR in it is invalid, the defaults are returned
+func (handler *configHandler) getCIDRsOfNode(node corev1.Node, key string, defaults []string) []string {
+	value, ok := node.Annotations[key]
+	if !ok {
+		return defaults
+	}
+
+	var cidrs []string
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			handler.log.Error(err, "invalid CIDR in annotation, use defaults", "nodeName", node.Name, "annotation", key)
+			return defaults
+		}
+		cidrs = append(cidrs, cidr)
+	}
+
+	return cidrs
+}
+
+// assignConnector finds out which connector the node should connect to according to
+// its connector label, if the label is absent or unknown, the connector is picked by hashing
+// if connectorNames is provided, otherwise the default connector is used
+func (handler *configHandler) assignConnector(epName string, node corev1.Node) apis.Endpoint {
+	connectorName := node.Labels[constants.KeyConnector]
+	getConnectorEndpoint, ok := handler.connectorEndpoints[connectorName]
+	if !ok {
+		if connectorName != "" {
+			handler.log.V(3).Info("unknown connector, assign node without label", "nodeName", node.Name, "connector", connectorName)
+		}
+
+		connectorName = types.HashConnectorName(epName, handler.connectorNames)
+		if getConnectorEndpoint, ok = handler.connectorEndpoints[connectorName]; !ok {
+			connectorName = ""
+			getConnectorEndpoint = handler.getConnectorEndpoint
+		}
+	}
+
+	if handler.assignment != nil {
+		handler.assignment.Assign(epName, connectorName)
+	}
+
+	return getConnectorEndpoint()
+}
+
+func (handler *configHandler) getPeers(name string, connector apis.Endpoint) []apis.Endpoint {
 	store := handler.store
 	nameSet := sets.NewString()
 
@@ -142,27 +343,59 @@ func (handler *configHandler) getPeers(name string) []apis.Endpoint {
 	}
 	nameSet.Delete(name)
 
-	endpoints := make([]apis.Endpoint, 0, len(nameSet)+1)
+	self, _ := store.GetEndpoint(name)
+	members := store.GetEndpoints(nameSet.List()...)
+
+	// no tunnels are made between a relay-only edge node and other edge nodes, their subnets
+	// are reached through connector instead
+	var peers []apis.Endpoint
+	var relayedSubnets []string
+	for _, ep := range members {
+		if ep.Type == apis.EdgeNode && (self.RelayOnly || ep.RelayOnly) {
+			relayedSubnets = append(relayedSubnets, ep.Subnets...)
+			continue
+		}
+		peers = append(peers, ep)
+	}
+
+	if len(relayedSubnets) > 0 {
+		connector.Subnets = append(append([]string{}, connector.Subnets...), relayedSubnets...)
+	}
+
+	endpoints := make([]apis.Endpoint, 0, len(peers)+1)
 	// always put connector endpoint first
-	endpoints = append(endpoints, handler.getConnectorEndpoint())
-	endpoints = append(endpoints, store.GetEndpoints(nameSet.List()...)...)
+	endpoints = append(endpoints, connector)
+	endpoints = append(endpoints, peers...)
 
 	return endpoints
 }
 
 func (handler *configHandler) Undo(ctx context.Context, nodeName string) error {
-	config := corev1.ConfigMap{
+	if handler.assignment != nil {
+		handler.assignment.Unassign(handler.getEndpointName(nodeName))
+	}
+
+	var config client.Object = &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      getAgentConfigMapName(nodeName),
 			Namespace: handler.namespace,
 		},
 	}
-	err := handler.client.Delete(ctx, &config)
+	if handler.configResource {
+		config = &apis.AgentConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getAgentConfigName(nodeName),
+				Namespace: handler.namespace,
+			},
+		}
+	}
+
+	err := handler.client.Delete(ctx, config)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = nil
 		} else {
-			handler.log.Error(err, "failed to delete configmap", "name", config.Name, "namespace", config.Namespace)
+			handler.log.Error(err, "failed to delete agent config", "name", config.GetName(), "namespace", config.GetNamespace())
 		}
 	}
 	return err
@@ -171,3 +404,8 @@ func (handler *configHandler) Undo(ctx context.Context, nodeName string) error {
 func getAgentConfigMapName(nodeName string) string {
 	return fmt.Sprintf("fabedge-agent-config-%s", nodeName)
 }
+
+// getAgentConfigName returns the name of AgentConfig of the node, it's the name of the node
+func getAgentConfigName(nodeName string) string {
+	return nodeName
+}
@@ -22,10 +22,12 @@ import (
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2/klogr"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
 	"github.com/fabedge/fabedge/pkg/common/netconf"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
@@ -158,6 +160,265 @@ var _ = Describe("ConfigHandler", func() {
 		Expect(conf.Peers[1].PublicAddresses).Should(Equal(edge2PublicAddresses))
 	})
 
+	It("buildNetworkConf should put subnets of relay-only peers in connector endpoint instead of tunnels to them", func() {
+		edge2Endpoint.RelayOnly = true
+		store.SaveEndpoint(edge2Endpoint)
+
+		conf := handler.buildNetworkConf(node)
+		Expect(conf.Peers).Should(HaveLen(1))
+		Expect(conf.Peers[0].Name).Should(Equal(connectorEndpoint.Name))
+		Expect(conf.Peers[0].Subnets).Should(Equal(append(append([]string{}, connectorEndpoint.Subnets...), edge2Endpoint.Subnets...)))
+
+		By("building config of a relay-only node")
+		edge2Endpoint.RelayOnly = false
+		store.SaveEndpoint(edge2Endpoint)
+
+		node.Annotations[constants.KeyRelayOnly] = "true"
+		store.SaveEndpoint(newEndpoint(node))
+
+		conf = handler.buildNetworkConf(node)
+		Expect(conf.RelayOnly).Should(BeTrue())
+		Expect(conf.Peers).Should(HaveLen(1))
+		Expect(conf.Peers[0].Subnets).Should(Equal(append(append([]string{}, connectorEndpoint.Subnets...), edge2Endpoint.Subnets...)))
+	})
+
+	It("buildNetworkConf should make bandwidth rules of communities with egress bandwidth", func() {
+		conf := handler.buildNetworkConf(node)
+		Expect(conf.BandwidthRules).Should(BeEmpty())
+
+		testCommunity.EgressBandwidth = 10000000
+		store.SaveCommunity(testCommunity)
+
+		conf = handler.buildNetworkConf(node)
+		Expect(conf.BandwidthRules).Should(Equal([]netconf.BandwidthRule{
+			{
+				Name:  testCommunity.Name,
+				Rate:  10000000,
+				CIDRs: append(append([]string{}, edge2Endpoint.Subnets...), edge2Endpoint.NodeSubnets...),
+			},
+		}))
+	})
+
+	It("Do should keep labels added by users and services data when updating agent configmap", func() {
+		var cm corev1.ConfigMap
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: agentConfigName, Namespace: namespace}, &cm)).To(Succeed())
+
+		By("adding a label and services data")
+		cm.Labels["user-label"] = "kept"
+		cm.Data[agentConfigServicesFileName] = "services"
+		Expect(k8sClient.Update(context.Background(), &cm)).To(Succeed())
+
+		By("changing edge2 ip address and re-executing Do method")
+		edge2Endpoint.PublicAddresses = []string{"10.20.8.143"}
+		store.SaveEndpoint(edge2Endpoint)
+		Expect(handler.Do(context.TODO(), node)).To(Succeed())
+
+		cm = corev1.ConfigMap{}
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: agentConfigName, Namespace: namespace}, &cm)).To(Succeed())
+		Expect(cm.Labels["user-label"]).Should(Equal("kept"))
+		Expect(cm.Labels[constants.KeyCreatedBy]).Should(Equal(constants.AppOperator))
+		Expect(cm.Data[agentConfigServicesFileName]).Should(Equal("services"))
+		Expect(cm.Data[agentConfigTunnelFileName]).Should(ContainSubstring("10.20.8.143"))
+	})
+
+	It("should assign nodes without connector label by hashing if connector names are provided", func() {
+		eastEndpoint := apis.Endpoint{
+			ID:   "C=CN, O=StrongSwan, CN=cloud-connector-east",
+			Name: "cloud-connector-east",
+			Type: apis.Connector,
+		}
+		handler.connectorEndpoints = map[string]types.EndpointGetter{
+			"east": func() apis.Endpoint { return eastEndpoint },
+		}
+		handler.assignment = types.NewConnectorAssignment()
+		handler.connectorNames = []string{"", "east"}
+
+		for _, name := range []string{"edge1", "edge2", "edge3", "edge4"} {
+			expectedName := types.HashConnectorName(name, handler.connectorNames)
+			expectedEndpoint := connectorEndpoint
+			if expectedName == "east" {
+				expectedEndpoint = eastEndpoint
+			}
+
+			Expect(handler.assignConnector(name, newNode(name, "10.40.20.183", "2.2.1.192/26"))).Should(Equal(expectedEndpoint))
+			Expect(handler.assignment.GetConnectorName(name)).Should(Equal(expectedName))
+		}
+
+		By("label still decides the connector")
+		labeled := newNode("edge5", "10.40.20.184", "2.2.1.192/26")
+		labeled.Labels = map[string]string{constants.KeyConnector: "east"}
+		Expect(handler.assignConnector("edge5", labeled)).Should(Equal(eastEndpoint))
+		Expect(handler.assignment.GetConnectorName("edge5")).Should(Equal("east"))
+	})
+
+	It("Do should put host ports of pods on the node in agent configmap", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx-" + node.Name,
+				Namespace: namespace,
+			},
+			Spec: corev1.PodSpec{
+				NodeName: node.Name,
+				Containers: []corev1.Container{
+					{
+						Name:  "nginx",
+						Image: "nginx",
+						Ports: []corev1.ContainerPort{
+							{ContainerPort: 80, HostPort: 8080},
+							{ContainerPort: 443},
+						},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &pod)).To(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), &pod)
+		}()
+
+		pod.Status.PodIP = "2.2.1.130"
+		Expect(k8sClient.Status().Update(context.Background(), &pod)).To(Succeed())
+
+		Expect(handler.Do(context.TODO(), node)).To(Succeed())
+
+		var cm corev1.ConfigMap
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: agentConfigName, Namespace: namespace}, &cm)).To(Succeed())
+
+		var conf netconf.NetworkConf
+		Expect(yaml.Unmarshal([]byte(cm.Data[agentConfigTunnelFileName]), &conf)).ShouldNot(HaveOccurred())
+		Expect(conf.HostPorts).Should(Equal([]netconf.HostPort{
+			{
+				HostPort:      8080,
+				Protocol:      corev1.ProtocolTCP,
+				PodIP:         "2.2.1.130",
+				ContainerPort: 80,
+			},
+		}))
+	})
+
+	It("Do should put services with local ingress ports in agent configmap if local ingress is enabled", func() {
+		handler.localIngressInterface = "eth1"
+
+		newService := func(name, clusterIP, ports string) corev1.Service {
+			return corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Annotations: map[string]string{constants.KeyLocalIngressPorts: ports},
+				},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: clusterIP,
+					Ports: []corev1.ServicePort{
+						{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+						{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+					},
+				},
+			}
+		}
+
+		services := []corev1.Service{
+			newService("web", "10.0.0.110", "8080:80, 5353:53"),
+			// port 8080 is taken by web already
+			newService("web2", "10.0.0.111", "8080:80"),
+			newService("invalid", "10.0.0.112", "8081"),
+		}
+		for i := range services {
+			Expect(k8sClient.Create(context.Background(), &services[i])).To(Succeed())
+		}
+		defer func() {
+			for i := range services {
+				_ = k8sClient.Delete(context.Background(), &services[i])
+			}
+		}()
+
+		getConf := func(node corev1.Node) netconf.NetworkConf {
+			Expect(handler.Do(context.TODO(), node)).To(Succeed())
+
+			var cm corev1.ConfigMap
+			Expect(k8sClient.Get(context.Background(), ObjectKey{Name: agentConfigName, Namespace: namespace}, &cm)).To(Succeed())
+
+			var conf netconf.NetworkConf
+			Expect(yaml.Unmarshal([]byte(cm.Data[agentConfigTunnelFileName]), &conf)).ShouldNot(HaveOccurred())
+			return conf
+		}
+
+		conf := getConf(node)
+		Expect(conf.LocalIngressInterface).Should(Equal("eth1"))
+		Expect(conf.LocalIngresses).Should(Equal([]netconf.LocalIngress{
+			{
+				Service:     namespace + "/web",
+				Port:        5353,
+				Protocol:    corev1.ProtocolUDP,
+				ServiceIP:   "10.0.0.110",
+				ServicePort: 53,
+			},
+			{
+				Service:     namespace + "/web",
+				Port:        8080,
+				Protocol:    corev1.ProtocolTCP,
+				ServiceIP:   "10.0.0.110",
+				ServicePort: 80,
+			},
+		}))
+
+		By("overriding LAN interface by node annotation")
+		annotated := node.DeepCopy()
+		annotated.Annotations[constants.KeyLocalIngressInterface] = "br-lan"
+		Expect(getConf(*annotated).LocalIngressInterface).Should(Equal("br-lan"))
+	})
+
+	It("buildNetworkConf should put masquerade CIDRs in config and let node annotations override them", func() {
+		handler.masqueradeCIDRs = []string{"0.0.0.0/0"}
+		handler.nonMasqueradeCIDRs = []string{"192.168.0.0/16"}
+
+		conf := handler.buildNetworkConf(node)
+		Expect(conf.MasqueradeCIDRs).Should(Equal([]string{"0.0.0.0/0"}))
+		Expect(conf.NonMasqueradeCIDRs).Should(Equal([]string{"192.168.0.0/16"}))
+
+		By("overriding non-masquerade CIDRs by annotation")
+		annotated := node.DeepCopy()
+		annotated.Annotations[constants.KeyNonMasqueradeCIDRs] = "10.10.0.0/16, 172.16.0.0/12"
+		conf = handler.buildNetworkConf(*annotated)
+		Expect(conf.MasqueradeCIDRs).Should(Equal([]string{"0.0.0.0/0"}))
+		Expect(conf.NonMasqueradeCIDRs).Should(Equal([]string{"10.10.0.0/16", "172.16.0.0/12"}))
+
+		By("ignoring annotation with invalid CIDR")
+		annotated.Annotations[constants.KeyMasqueradeCIDRs] = "10.10.0.0/16,bad"
+		conf = handler.buildNetworkConf(*annotated)
+		Expect(conf.MasqueradeCIDRs).Should(Equal([]string{"0.0.0.0/0"}))
+	})
+
+	It("Do should keep tunnels config in AgentConfig instead of configmap if config resource is enabled", func() {
+		handler.configResource = true
+		Expect(handler.Do(context.TODO(), node)).To(Succeed())
+
+		var cfg apis.AgentConfig
+		key := ObjectKey{Name: getAgentConfigName(node.Name), Namespace: namespace}
+		Expect(k8sClient.Get(context.Background(), key, &cfg)).To(Succeed())
+		expectOwnerReference(&cfg, node)
+
+		var conf netconf.NetworkConf
+		Expect(yaml.Unmarshal([]byte(cfg.Spec.Tunnels), &conf)).Should(Succeed())
+		Expect(conf.Endpoint).Should(Equal(newEndpoint(node)))
+
+		By("keeping services written by proxy controller when tunnels config is changed")
+		cfg.Spec.Services = "services"
+		Expect(k8sClient.Update(context.Background(), &cfg)).To(Succeed())
+
+		edge2Endpoint.PublicAddresses = []string{"10.20.8.142"}
+		store.SaveEndpoint(edge2Endpoint)
+		Expect(handler.Do(context.TODO(), node)).To(Succeed())
+
+		Expect(k8sClient.Get(context.Background(), key, &cfg)).To(Succeed())
+		Expect(cfg.Spec.Services).Should(Equal("services"))
+		Expect(yaml.Unmarshal([]byte(cfg.Spec.Tunnels), &conf)).Should(Succeed())
+		Expect(conf.Peers[1].PublicAddresses).Should(Equal(edge2Endpoint.PublicAddresses))
+
+		Expect(handler.Undo(context.TODO(), node.Name)).To(Succeed())
+		err := k8sClient.Get(context.Background(), key, &cfg)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
 	It("Undo should delete configmap created by Do method", func() {
 		Expect(handler.Undo(context.TODO(), node.Name)).To(Succeed())
 
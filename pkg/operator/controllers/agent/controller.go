@@ -16,36 +16,68 @@ package agent
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	certv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
 	ctrlpkg "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
 	"github.com/fabedge/fabedge/pkg/operator/allocator"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 // errRestartAgent is used to signal controller put restartAgent in context
 var errRestartAgent = fmt.Errorf("restart agent")
 
+// errReissueDeferred is used by certHandler to signal controller that the certificate of agent
+// has to be reissued because CA is being rotated, but it's deferred to avoid rebuilding all tunnels at once
+var errReissueDeferred = fmt.Errorf("certificate reissue deferred")
+
 const (
 	controllerName              = "agent-controller"
 	agentConfigTunnelFileName   = "tunnels.yaml"
 	agentConfigServicesFileName = "services.yaml"
 	agentConfigTunnelsFilepath  = "/etc/fabedge/tunnels.yaml"
 	agentConfigServicesFilepath = "/etc/fabedge/services.yaml"
+	agentCRLDir                 = "/etc/fabedge-crl"
+	agentConfigCABundleFileName = "ca-bundle.crt"
+	agentBootstrapTokenDir      = "/var/run/secrets/fabedge"
+	agentCacheDir               = "/var/lib/fabedge/agent"
 
 	keyRestartAgent = "restartAgent"
 )
 
+// applyOptions are used to apply generated objects, the fields set by operator
+// are owned by it, but labels or annotations added by users are kept untouched
+var applyOptions = []client.PatchOption{client.FieldOwner(constants.FieldManager), client.ForceOwnership}
+
 type ObjectKey = client.ObjectKey
 type Handler interface {
 	Do(ctx context.Context, node corev1.Node) error
@@ -59,6 +91,22 @@ type agentController struct {
 	client      client.Client
 	log         logr.Logger
 	edgeNameSet *types.SafeStringSet
+	// syncInterval is used as RequeueAfter of successful reconciliation
+	syncInterval time.Duration
+	// reissueInterval is used as RequeueAfter when certificate reissue is deferred
+	reissueInterval time.Duration
+	// events is used to enqueue edge nodes when settings are changed
+	events chan event.GenericEvent
+	// isTearingDown may be nil
+	isTearingDown func() bool
+	// store and getEndpointName are used to find peers of edge nodes whose relay-only is changed
+	store           storepkg.Interface
+	getEndpointName types.GetNameFunc
+	// superEdgeNamespace is where daemonsets of SuperEdge are watched, it's empty if SuperEdge is not used
+	superEdgeNamespace string
+	// allocated tells whether pod subnets annotations of edge nodes are made by operator,
+	// they are removed when edge nodes are decommissioned
+	allocated bool
 }
 
 type Config struct {
@@ -75,39 +123,281 @@ type Config struct {
 	MasqOutgoing    bool
 
 	GetConnectorEndpoint types.EndpointGetter
-	NewEndpoint          types.NewEndpointFunc
-	GetEndpointName      types.GetNameFunc
+	// ConnectorEndpoints holds endpoint getters of non-default connectors, the key is connector name
+	ConnectorEndpoints  map[string]types.EndpointGetter
+	ConnectorAssignment types.ConnectorAssignment
+	NewEndpoint         types.NewEndpointFunc
+	GetEndpointName     types.GetNameFunc
+	GetEndpointID       types.GetIDFunc
+	// HashConnectorAssignment makes edge nodes without a known connector label be assigned
+	// among all connectors by hashing instead of to the default connector
+	HashConnectorAssignment bool
 
 	CertManager      certutil.Manager
 	CertOrganization string
+	CertKeyType      certutil.KeyType
+	// CertKeySize is the size of agents' keys, see certutil.NewPrivateKey
+	CertKeySize int
+	// CertSignatureAlgorithm is the algorithm to sign certificate requests of agents, it's chosen
+	// according to key type if it's unknown
+	CertSignatureAlgorithm x509.SignatureAlgorithm
+	// CertReissueInterval is the least interval between reissues of agents' certificates when CA
+	// is being rotated, so tunnels are rebuilt one by one. 0 means they are reissued at once
+	CertReissueInterval time.Duration
+	// CSRSignerName makes agents' certificates requested by CertificateSigningRequests of this
+	// signer, so they can be approved by administrators or approval controllers. Empty means
+	// certificates are signed by CertManager directly
+	CSRSignerName string
+	// CRLSecretName is the secret of CRLs which is mounted to agent pods, so agents won't
+	// establish tunnels with peers whose certificates are revoked. Empty means no CRL
+	CRLSecretName string
+	// APIServerAddress is passed to agents to renew their certificates in place, empty means
+	// certificates are reissued by operator when they expire
+	APIServerAddress string
+	// CertBootstrap makes agents get their certificates from APIServerAddress by service account
+	// tokens of their pods, operator keeps no TLS secrets of agents, CA bundle is put in configmaps
+	// of agents instead. Agent pods use ServiceAccountName
+	CertBootstrap bool
+
+	// EnableFirewall makes agents keep a minimal firewall on the WAN interface of edge nodes
+	// which only accepts IKE and ESP from peers and FirewallAllowedPorts
+	EnableFirewall       bool
+	FirewallAllowedPorts []string
+	// FIPSMode makes agents restrict IPsec proposals and TLS to FIPS approved algorithms
+	FIPSMode bool
+	// OfflineCache makes agents keep the last valid config on the host, so they keep working
+	// when edge nodes are cut off from cloud
+	OfflineCache bool
+	// DNSListenAddress makes agents serve DNS for edge pods there, queries are forwarded to DNSUpstreams
+	DNSListenAddress string
+	DNSUpstreams     []string
+	// MetricsPort is where agents serve /metrics and /healthz, 0 means they are not served
+	MetricsPort int
+	// RelayTimeout is passed to agents, they register their nodes for relay by connector
+	// if tunnels to edge peers are down for that long, 0 means they never do
+	RelayTimeout time.Duration
+	// MasqueradeCIDRs and NonMasqueradeCIDRs are put in tunnels config of edge nodes which don't
+	// override them by annotations, they only work when MasqOutgoing is true
+	MasqueradeCIDRs    []string
+	NonMasqueradeCIDRs []string
+	// LocalIngressInterface is the default LAN interface of edge nodes where services annotated with
+	// fabedge.io/local-ingress-ports are exposed by agents, empty means local ingress is disabled
+	LocalIngressInterface string
+	// ConfigResource makes config of agents kept in AgentConfigs of the same names as edge nodes
+	// instead of configmaps, agents get it through API and report the result in status
+	ConfigResource bool
+	// ProbeInterval is passed to agents, they ping their peers that often and report results in
+	// status of AgentConfigs, it only works with ConfigResource. 0 means agents don't probe
+	ProbeInterval time.Duration
+	// FlapThreshold is passed to agents, a tunnel going down more times than it in an hour is flapping,
+	// it only works with ConfigResource. 0 means agents use their default
+	FlapThreshold int
 
 	EnableProxy bool
+	// DetectKubeProxy makes operator disable agent's proxy on those edge nodes
+	// where kube-proxy is running
+	DetectKubeProxy bool
+	// KubeEdge makes agents on edge nodes managed by edgecore access API through MetaServerAddress
+	// when kube-apiserver can't be reached, agent's proxy is disabled on edge nodes where EdgeMesh runs
+	KubeEdge          bool
+	MetaServerAddress string
+	// OpenYurt makes agent pods labeled with NodePools of their nodes like pods of YurtAppSet
+	OpenYurt bool
+	// SuperEdge makes agents on edge nodes where tunnel-edge runs access API through LiteAPIServerAddress
+	// and accept checks of edge-health, components of SuperEdge are found in SuperEdgeNamespace
+	SuperEdge            bool
+	LiteAPIServerAddress string
+	SuperEdgeNamespace   string
+	// IPVSTCPTimeout, IPVSTCPFinTimeout, IPVSUDPTimeout and IPVSGracefulTermination are passed
+	// to agents whose proxy is enabled, 0 means agent's default is used
+	IPVSTCPTimeout          time.Duration
+	IPVSTCPFinTimeout       time.Duration
+	IPVSUDPTimeout          time.Duration
+	IPVSGracefulTermination time.Duration
 
 	EnableEdgeIPAM        bool
 	EnableEdgeHairpinMode bool
 	NetworkPluginMTU      int
+	// SubnetsPerChildSA is passed to agent to split traffic selectors into multiple child SAs
+	SubnetsPerChildSA int
+
+	// NodeCondition is the type of node condition managed by agents, empty means disabled
+	NodeCondition      string
+	ServiceAccountName string
+
+	// MaxConcurrentReconciles is the number of workers which reconcile edge nodes,
+	// a node is never reconciled by two workers at the same time
+	MaxConcurrentReconciles int
+	// SyncInterval is the interval to reconcile each edge node again, 0 means
+	// edge nodes are reconciled only when they or their resources change
+	SyncInterval time.Duration
+	// RetryBaseDelay and RetryMaxDelay limit how often a failed node is retried,
+	// the delay grows exponentially for each node
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// RetryQPS and RetryBurst limit the overall rate of retries of all nodes
+	RetryQPS   float64
+	RetryBurst int
+
+	// IsTearingDown tells whether operator is removing everything fabedge made,
+	// agent controller stops reconciling edge nodes when it returns true
+	IsTearingDown func() bool
+}
+
+// UpdatePodSettingsFunc changes settings of agent pods at runtime
+type UpdatePodSettingsFunc func(settings PodSettings)
+
+func (cnf Config) PodSettings() PodSettings {
+	return PodSettings{
+		AgentImage:        cnf.AgentImage,
+		StrongswanImage:   cnf.StrongswanImage,
+		ImagePullPolicy:   corev1.PullPolicy(cnf.ImagePullPolicy),
+		LogLevel:          cnf.AgentLogLevel,
+		UseXfrm:           cnf.UseXfrm,
+		MasqOutgoing:      cnf.MasqOutgoing,
+		EnableHairpinMode: cnf.EnableEdgeHairpinMode,
+		NetworkPluginMTU:  cnf.NetworkPluginMTU,
+		SubnetsPerChildSA: cnf.SubnetsPerChildSA,
+		NodeCondition:     cnf.NodeCondition,
+	}
 }
 
-func AddToManager(cnf Config) error {
+func AddToManager(cnf Config) (UpdatePodSettingsFunc, error) {
 	mgr := cnf.Manager
 
 	log := mgr.GetLogger().WithName(controllerName)
 	cli := mgr.GetClient()
 
 	reconciler := &agentController{
-		log:         log,
-		client:      cli,
-		edgeNameSet: types.NewSafeStringSet(),
-		handlers:    initHandlers(cnf, cli, log),
+		log:             log,
+		client:          cli,
+		edgeNameSet:     types.NewSafeStringSet(),
+		handlers:        initHandlers(cnf, cli, log),
+		syncInterval:    cnf.SyncInterval,
+		reissueInterval: cnf.CertReissueInterval,
+		events:          make(chan event.GenericEvent),
+		isTearingDown:   cnf.IsTearingDown,
+		store:           cnf.Store,
+		getEndpointName: cnf.GetEndpointName,
+		allocated:       cnf.Allocator != nil,
+	}
+	if cnf.SuperEdge {
+		reconciler.superEdgeNamespace = cnf.SuperEdgeNamespace
 	}
 
-	return ctrlpkg.NewControllerManagedBy(mgr).
+	builder := ctrlpkg.NewControllerManagedBy(mgr).
 		For(&corev1.Node{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.Pod{}).
+		Watches(&source.Channel{Source: reconciler.events}, &handler.EnqueueRequestForObject{})
+
+	if cnf.CSRSignerName != "" {
+		builder = builder.Owns(&certv1.CertificateSigningRequest{})
+	}
+
+	// status of AgentConfig is updated by agent, it doesn't need reconciling
+	if cnf.ConfigResource {
+		builder = builder.Owns(&apis.AgentConfig{}, ctrlbuilder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	}
+
+	// host ports of pods on edge nodes are mapped by agents, see configHandler.getHostPorts
+	if err := indexPodsByNodeName(mgr); err != nil {
+		return nil, err
+	}
+	builder = builder.Watches(
+		&source.Kind{Type: &corev1.Pod{}},
+		handler.EnqueueRequestsFromMapFunc(reconciler.edgeNodeOfHostPortPod),
+	)
+
+	// services with local ingress ports are put in tunnels config of all edge nodes
+	if cnf.LocalIngressInterface != "" {
+		builder = builder.Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(reconciler.edgeNodesOfLocalIngressService),
+		)
+	}
+
+	if (cnf.EnableProxy && (cnf.DetectKubeProxy || cnf.KubeEdge)) || cnf.SuperEdge {
+		builder = builder.Watches(
+			&source.Kind{Type: &appsv1.DaemonSet{}},
+			handler.EnqueueRequestsFromMapFunc(reconciler.edgeNodesForDaemonSets),
+		)
+	}
+
+	return reconciler.updatePodSettings, builder.
 		Named(controllerName).
-		Complete(reconciler)
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: cnf.MaxConcurrentReconciles,
+			RateLimiter:             newRateLimiter(cnf),
+		}).
+		Complete(tracing.WrapReconciler(controllerName, reconciler))
+}
+
+// updatePodSettings changes settings of agent pod handler and enqueues all edge nodes,
+// agent pods whose hash is changed will be recreated
+func (ctl *agentController) updatePodSettings(settings PodSettings) {
+	for _, h := range ctl.handlers {
+		if podHandler, ok := h.(*agentPodHandler); ok {
+			podHandler.updatePodSettings(settings)
+		}
+	}
+
+	go func() {
+		var nodes corev1.NodeList
+		err := ctl.client.List(context.Background(), &nodes, client.MatchingLabels(nodeutil.GetEdgeNodeLabels()))
+		if err != nil {
+			ctl.log.Error(err, "failed to list edge nodes")
+			return
+		}
+
+		for i := range nodes.Items {
+			ctl.events <- event.GenericEvent{Object: &nodes.Items[i]}
+		}
+	}()
+}
+
+// newRateLimiter creates a rate limiter which delays retries of each node exponentially
+// and limits the overall rate of retries, so a lot of failed nodes won't make workers
+// busy retrying them all the time
+func newRateLimiter(cnf Config) workqueue.RateLimiter {
+	if cnf.RetryBaseDelay <= 0 || cnf.RetryMaxDelay <= 0 || cnf.RetryQPS <= 0 || cnf.RetryBurst <= 0 {
+		return workqueue.DefaultControllerRateLimiter()
+	}
+
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(cnf.RetryBaseDelay, cnf.RetryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(cnf.RetryQPS), cnf.RetryBurst)},
+	)
+}
+
+// edgeNodesForDaemonSets enqueues all edge nodes when kube-proxy, EdgeMesh or SuperEdge daemonsets
+// change, because the change may affect whether agent's proxy should be enabled on them or
+// which SuperEdge components agents work with
+func (ctl *agentController) edgeNodesForDaemonSets(obj client.Object) []reconcile.Request {
+	isKubeProxy := obj.GetNamespace() == kubeProxyNamespace && obj.GetName() == kubeProxyName
+	isEdgeMesh := obj.GetNamespace() == edgeMeshNamespace && obj.GetName() == edgeMeshName
+	isSuperEdge := ctl.superEdgeNamespace != "" && obj.GetNamespace() == ctl.superEdgeNamespace &&
+		(obj.GetName() == superEdgeTunnelEdgeName || obj.GetName() == superEdgeHealthName)
+	if !isKubeProxy && !isEdgeMesh && !isSuperEdge {
+		return nil
+	}
+
+	var nodes corev1.NodeList
+	err := ctl.client.List(context.Background(), &nodes, client.MatchingLabels(nodeutil.GetEdgeNodeLabels()))
+	if err != nil {
+		ctl.log.Error(err, "failed to list edge nodes")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: ObjectKey{Name: node.Name},
+		})
+	}
+
+	return requests
 }
 
 func initHandlers(cnf Config, cli client.Client, log logr.Logger) []Handler {
@@ -129,14 +419,31 @@ func initHandlers(cnf Config, cli client.Client, log logr.Logger) []Handler {
 		})
 	}
 
-	handlers = append(handlers, &configHandler{
-		namespace:            cnf.Namespace,
-		client:               cli,
-		store:                cnf.Store,
-		getEndpointName:      cnf.GetEndpointName,
-		getConnectorEndpoint: cnf.GetConnectorEndpoint,
-		log:                  log.WithName("configHandler"),
-	})
+	configHandler := &configHandler{
+		namespace:             cnf.Namespace,
+		client:                cli,
+		store:                 cnf.Store,
+		getEndpointName:       cnf.GetEndpointName,
+		getConnectorEndpoint:  cnf.GetConnectorEndpoint,
+		connectorEndpoints:    cnf.ConnectorEndpoints,
+		assignment:            cnf.ConnectorAssignment,
+		masqueradeCIDRs:       cnf.MasqueradeCIDRs,
+		nonMasqueradeCIDRs:    cnf.NonMasqueradeCIDRs,
+		configResource:        cnf.ConfigResource,
+		localIngressInterface: cnf.LocalIngressInterface,
+		log:                   log.WithName("configHandler"),
+	}
+	if cnf.HashConnectorAssignment {
+		configHandler.connectorNames = append([]string{""}, sets.StringKeySet(cnf.ConnectorEndpoints).List()...)
+	}
+	handlers = append(handlers, configHandler)
+
+	// agents keep their own certificates, they only need CA bundle to verify API server
+	if cnf.CertBootstrap {
+		configHandler.certManager = cnf.CertManager
+		handlers = append(handlers, newAgentPodHandler(cnf, cli, log))
+		return handlers
+	}
 
 	handlers = append(handlers, &certHandler{
 		namespace: cnf.Namespace,
@@ -144,12 +451,24 @@ func initHandlers(cnf Config, cli client.Client, log logr.Logger) []Handler {
 
 		certManager:      cnf.CertManager,
 		getEndpointName:  cnf.GetEndpointName,
+		getEndpointID:    cnf.GetEndpointID,
 		certOrganization: cnf.CertOrganization,
+		certKeyType:      cnf.CertKeyType,
+		certKeySize:      cnf.CertKeySize,
+		certSigAlgorithm: cnf.CertSignatureAlgorithm,
+		reissueLimiter:   newReissueLimiter(cnf.CertReissueInterval),
+		csrSignerName:    cnf.CSRSignerName,
 
 		log: log.WithName("certHandler"),
 	})
 
-	handlers = append(handlers, &agentPodHandler{
+	handlers = append(handlers, newAgentPodHandler(cnf, cli, log))
+
+	return handlers
+}
+
+func newAgentPodHandler(cnf Config, cli client.Client, log logr.Logger) *agentPodHandler {
+	return &agentPodHandler{
 		namespace: cnf.Namespace,
 		client:    cli,
 		log:       log.WithName("agentPodHandler"),
@@ -161,17 +480,51 @@ func initHandlers(cnf Config, cli client.Client, log logr.Logger) []Handler {
 		useXfrm:           cnf.UseXfrm,
 		masqOutgoing:      cnf.MasqOutgoing,
 		enableProxy:       cnf.EnableProxy,
+		detectKubeProxy:   cnf.DetectKubeProxy,
 		enableIPAM:        true,
 		enableHairpinMode: cnf.EnableEdgeHairpinMode,
 		networkPluginMTU:  cnf.NetworkPluginMTU,
-	})
-
-	return handlers
+		subnetsPerChildSA: cnf.SubnetsPerChildSA,
+		crlSecretName:     cnf.CRLSecretName,
+		apiServerAddress:  cnf.APIServerAddress,
+		enableFirewall:    cnf.EnableFirewall,
+		firewallPorts:     cnf.FirewallAllowedPorts,
+		fipsMode:          cnf.FIPSMode,
+		certBootstrap:     cnf.CertBootstrap,
+		offlineCache:      cnf.OfflineCache,
+		dnsListenAddress:  cnf.DNSListenAddress,
+		dnsUpstreams:      cnf.DNSUpstreams,
+		metricsPort:       cnf.MetricsPort,
+
+		ipvsTCPTimeout:          cnf.IPVSTCPTimeout,
+		ipvsTCPFinTimeout:       cnf.IPVSTCPFinTimeout,
+		ipvsUDPTimeout:          cnf.IPVSUDPTimeout,
+		ipvsGracefulTermination: cnf.IPVSGracefulTermination,
+
+		nodeCondition:      cnf.NodeCondition,
+		relayTimeout:       cnf.RelayTimeout,
+		configResource:     cnf.ConfigResource,
+		probeInterval:      cnf.ProbeInterval,
+		flapThreshold:      cnf.FlapThreshold,
+		serviceAccountName: cnf.ServiceAccountName,
+		kubeEdge:           cnf.KubeEdge,
+		metaServerAddress:  cnf.MetaServerAddress,
+		openYurt:           cnf.OpenYurt,
+
+		superEdge:            cnf.SuperEdge,
+		liteAPIServerAddress: cnf.LiteAPIServerAddress,
+		superEdgeNamespace:   cnf.SuperEdgeNamespace,
+	}
 }
 
 func (ctl *agentController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	log := ctl.log.WithValues("key", request)
 
+	if ctl.isTearingDown != nil && ctl.isTearingDown() {
+		log.V(5).Info("operator is tearing down, skip reconciling")
+		return reconcile.Result{}, nil
+	}
+
 	var node corev1.Node
 	if err := ctl.client.Get(ctx, request.NamespacedName, &node); err != nil {
 		if errors.IsNotFound(err) {
@@ -186,23 +539,81 @@ func (ctl *agentController) Reconcile(ctx context.Context, request reconcile.Req
 		return reconcile.Result{}, ctl.clearAllocatedResourcesForEdgeNode(ctx, request.Name)
 	}
 
+	if isDecommissioning(node) {
+		return ctl.decommission(ctx, node)
+	}
+
 	if ctl.shouldSkip(node) {
 		log.V(5).Info("This node has no ip or pod CIDRs, skip reconciling")
 		return reconcile.Result{}, nil
 	}
 
 	ctl.edgeNameSet.Insert(node.Name)
+	oldEndpoint, _ := ctl.store.GetEndpoint(ctl.getEndpointName(node.Name))
+
+	requeueAfter := ctl.syncInterval
 	for _, handler := range ctl.handlers {
-		if err := handler.Do(ctx, node); err != nil {
-			if err == errRestartAgent {
+		if err := ctl.doHandler(ctx, handler, node); err != nil {
+			switch err {
+			case errRestartAgent:
 				ctx = context.WithValue(ctx, keyRestartAgent, err)
 				continue
+			case errReissueDeferred:
+				requeueAfter = ctl.reissueInterval
+				continue
+			case errCertPending:
+				// node is enqueued again when its certificate signing request changes
+				continue
 			}
 			return reconcile.Result{}, err
 		}
 	}
 
-	return reconcile.Result{}, nil
+	if endpoint, _ := ctl.store.GetEndpoint(ctl.getEndpointName(node.Name)); endpoint.RelayOnly != oldEndpoint.RelayOnly {
+		log.V(3).Info("relay-only of edge node is changed, enqueue its peers", "relayOnly", endpoint.RelayOnly)
+		ctl.enqueuePeersOf(node.Name)
+	}
+
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// doHandler runs handler in a span, so it's clear which step makes reconciling of an edge node slow
+func (ctl *agentController) doHandler(ctx context.Context, handler Handler, node corev1.Node) error {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", handler), "*agent.")
+	ctx, span := tracing.Start(ctx, name+"/Do", trace.WithAttributes(attribute.String("node", node.Name)))
+
+	err := handler.Do(ctx, node)
+	switch err {
+	case errRestartAgent, errReissueDeferred, errCertPending:
+		span.SetAttributes(attribute.String("result", err.Error()))
+		tracing.End(span, nil)
+	default:
+		tracing.End(span, err)
+	}
+
+	return err
+}
+
+// enqueuePeersOf enqueues edge nodes in the same communities with the node, so their configs are
+// built again, e.g. tunnels to the node are replaced by relay through connector
+func (ctl *agentController) enqueuePeersOf(nodeName string) {
+	members := sets.NewString()
+	for _, community := range ctl.store.GetCommunitiesByEndpoint(ctl.getEndpointName(nodeName)) {
+		members.Insert(community.Members.List()...)
+	}
+
+	var peers []string
+	for _, name := range ctl.edgeNameSet.List() {
+		if name != nodeName && members.Has(ctl.getEndpointName(name)) {
+			peers = append(peers, name)
+		}
+	}
+
+	go func() {
+		for _, name := range peers {
+			ctl.events <- event.GenericEvent{Object: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}}
+		}
+	}()
 }
 
 func (ctl *agentController) shouldSkip(node corev1.Node) bool {
@@ -17,6 +17,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -26,7 +27,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 	testutil "github.com/fabedge/fabedge/pkg/util/test"
 )
 
@@ -43,11 +47,14 @@ var _ = Describe("AgentController", func() {
 
 	JustBeforeEach(func() {
 		log := klogr.New().WithName(controllerName)
+		getEndpointName, _, _ := types.NewEndpointFuncs("cluster", "C=CN, O=StrongSwan, CN={node}", nodeutil.GetPodCIDRsFromAnnotation)
 		controller = &agentController{
-			handlers:    handlers,
-			edgeNameSet: types.NewSafeStringSet(),
-			client:      k8sClient,
-			log:         log,
+			handlers:        handlers,
+			edgeNameSet:     types.NewSafeStringSet(),
+			client:          k8sClient,
+			log:             log,
+			store:           storepkg.NewStore(),
+			getEndpointName: getEndpointName,
 		}
 	})
 
@@ -153,6 +160,22 @@ var _ = Describe("AgentController", func() {
 				Expect(lastHandler.DoContext.Value(keyRestartAgent)).To(Equal(errRestartAgent))
 			})
 
+			It("continue with next handlers and requeue node later if a handler defers certificate reissue", func() {
+				firstHandler = &FuncHandler{ErrorForDo: errReissueDeferred}
+				lastHandler = &FuncHandler{}
+				controller.handlers = []Handler{firstHandler, lastHandler}
+				controller.reissueInterval = time.Minute
+
+				result, err := controller.Reconcile(context.Background(), reconcile.Request{
+					NamespacedName: ObjectKey{
+						Name: nodeName,
+					},
+				})
+				Expect(err).To(BeNil())
+				Expect(result.RequeueAfter).To(Equal(time.Minute))
+				Expect(lastHandler.DoContext).NotTo(BeNil())
+			})
+
 			It("return error if Do method of any handler return a error but errRestartAgent", func() {
 				firstHandler = &FuncHandler{ErrorForDo: fmt.Errorf("some error")}
 				lastHandler = &FuncHandler{}
@@ -170,6 +193,28 @@ var _ = Describe("AgentController", func() {
 				Expect(controller.edgeNameSet.Has(nodeName)).To(BeTrue())
 			})
 
+			It("execute Undo method of each handlers instead of Do if node is decommissioned", func() {
+				firstHandler = &FuncHandler{}
+				lastHandler = &FuncHandler{}
+				controller.handlers = []Handler{firstHandler, lastHandler}
+
+				node.Annotations = map[string]string{constants.KeyDecommission: "true"}
+				Expect(k8sClient.Update(context.Background(), &node)).To(Succeed())
+
+				_, err := controller.Reconcile(context.Background(), reconcile.Request{
+					NamespacedName: ObjectKey{
+						Name: nodeName,
+					},
+				})
+				Expect(err).To(BeNil())
+
+				Expect(firstHandler.DoContext).To(BeNil())
+				Expect(lastHandler.DoContext).To(BeNil())
+				Expect(firstHandler.UndoContext).NotTo(BeNil())
+				Expect(lastHandler.UndoContext).NotTo(BeNil())
+				Expect(controller.edgeNameSet.Has(nodeName)).To(BeFalse())
+			})
+
 			When("node is deleted or lose edge labels", func() {
 				DescribeTable("execute Undo method of each handlers", func(action func() error) {
 					Expect(action()).Should(Succeed())
@@ -255,3 +300,29 @@ func (fh *FuncHandler) Undo(ctx context.Context, nodeName string) error {
 		return fh.UndoFunc(ctx, nodeName)
 	}
 }
+
+var _ = Describe("newRateLimiter", func() {
+	It("should delay retries of each node exponentially and independently", func() {
+		limiter := newRateLimiter(Config{
+			RetryBaseDelay: 100 * time.Millisecond,
+			RetryMaxDelay:  time.Second,
+			RetryQPS:       100,
+			RetryBurst:     100,
+		})
+
+		edge1 := reconcile.Request{NamespacedName: ObjectKey{Name: "edge1"}}
+		edge2 := reconcile.Request{NamespacedName: ObjectKey{Name: "edge2"}}
+
+		Expect(limiter.When(edge1)).To(Equal(100 * time.Millisecond))
+		Expect(limiter.When(edge1)).To(Equal(200 * time.Millisecond))
+		Expect(limiter.When(edge2)).To(Equal(100 * time.Millisecond))
+
+		for i := 0; i < 10; i++ {
+			limiter.When(edge1)
+		}
+		Expect(limiter.When(edge1)).To(Equal(time.Second))
+
+		limiter.Forget(edge1)
+		Expect(limiter.When(edge1)).To(Equal(100 * time.Millisecond))
+	})
+})
@@ -0,0 +1,251 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+// errCertPending is used by certHandler to signal controller that the certificate of agent
+// is requested by a CertificateSigningRequest which is not approved or signed yet
+var errCertPending = fmt.Errorf("certificate signing request pending")
+
+// CSRUsages are usages of CertificateSigningRequests of agents
+var CSRUsages = []certv1.KeyUsage{
+	certv1.UsageDigitalSignature,
+	certv1.UsageKeyEncipherment,
+	certv1.UsageServerAuth,
+	certv1.UsageClientAuth,
+}
+
+// requestCertAndKeySecret requests a certificate by a CertificateSigningRequest of csrSignerName, the private
+// key is kept in a secret until the request is signed, then the TLS secret is built from them.
+// errCertPending is returned if the request is just created or it's not signed yet
+func (handler *certHandler) requestCertAndKeySecret(ctx context.Context, secretName string, node corev1.Node) (corev1.Secret, error) {
+	log := handler.log.WithValues("nodeName", node.Name, "csrName", getCSRName(node.Name))
+
+	var csr certv1.CertificateSigningRequest
+	err := handler.client.Get(ctx, ObjectKey{Name: getCSRName(node.Name)}, &csr)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return corev1.Secret{}, err
+		}
+
+		if err = handler.createCSR(ctx, node); err != nil {
+			return corev1.Secret{}, err
+		}
+
+		log.V(3).Info("certificate signing request is created for agent")
+		return corev1.Secret{}, errCertPending
+	}
+
+	for _, cond := range csr.Status.Conditions {
+		if (cond.Type == certv1.CertificateDenied || cond.Type == certv1.CertificateFailed) && cond.Status == corev1.ConditionTrue {
+			return corev1.Secret{}, fmt.Errorf("certificate signing request %s is %s: %s, delete it to request again", csr.Name, cond.Type, cond.Message)
+		}
+	}
+
+	if len(csr.Status.Certificate) == 0 {
+		log.V(5).Info("certificate signing request is not signed yet")
+		return corev1.Secret{}, errCertPending
+	}
+
+	var keySecret corev1.Secret
+	err = handler.client.Get(ctx, ObjectKey{Name: getCSRKeySecretName(node.Name), Namespace: handler.namespace}, &keySecret)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return corev1.Secret{}, err
+		}
+
+		log.Info("private key of certificate signing request is lost, request again")
+		return corev1.Secret{}, handler.requestAgain(ctx, node.Name)
+	}
+
+	keyPEM := keySecret.Data[corev1.TLSPrivateKeyKey]
+	if err = handler.verifyIssuedCert(csr.Status.Certificate, keyPEM); err != nil {
+		log.Error(err, "issued certificate is invalid, request again")
+		return corev1.Secret{}, handler.requestAgain(ctx, node.Name)
+	}
+
+	return secretutil.TLSSecret().
+		Name(secretName).
+		Namespace(handler.namespace).
+		CertPEM(csr.Status.Certificate).
+		KeyPEM(keyPEM).
+		CACertPEM(handler.certManager.GetCACertPEM()).
+		CABundlePEM(handler.certManager.GetCABundlePEM()).
+		Label(constants.KeyCreatedBy, constants.AppOperator).
+		Label(constants.KeyNode, node.Name).Build(), nil
+}
+
+func (handler *certHandler) createCSR(ctx context.Context, node corev1.Node) error {
+	keyDER, csrDER, err := certutil.NewCertRequest(handler.getCertRequest(node.Name))
+	if err != nil {
+		return err
+	}
+
+	keySecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getCSRKeySecretName(node.Name),
+			Namespace: handler.namespace,
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+				constants.KeyNode:      node.Name,
+			},
+		},
+		Data: map[string][]byte{
+			corev1.TLSPrivateKeyKey: certutil.EncodePrivateKeyPEM(keyDER),
+		},
+	}
+	if err = controllerutil.SetControllerReference(&node, &keySecret, scheme.Scheme); err != nil {
+		return err
+	}
+
+	if err = handler.client.Create(ctx, &keySecret); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+
+		// the key of last request which is not finished
+		if err = handler.client.Update(ctx, &keySecret); err != nil {
+			return err
+		}
+	}
+
+	csr := certv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getCSRName(node.Name),
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+				constants.KeyNode:      node.Name,
+			},
+		},
+		Spec: certv1.CertificateSigningRequestSpec{
+			Request:    certutil.EncodeCertRequestPEM(csrDER),
+			SignerName: handler.csrSignerName,
+			Usages:     CSRUsages,
+		},
+	}
+	if err = controllerutil.SetControllerReference(&node, &csr, scheme.Scheme); err != nil {
+		return err
+	}
+
+	return handler.client.Create(ctx, &csr)
+}
+
+// verifyIssuedCert checks if certPEM is issued by CA and is the certificate of keyPEM
+func (handler *certHandler) verifyIssuedCert(certPEM, keyPEM []byte) error {
+	if err := handler.certManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient); err != nil {
+		return err
+	}
+
+	certDER, err := certutil.DecodePEM(certPEM)
+	if err != nil {
+		return err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := certutil.DecodePEM(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	key, err := certutil.ParsePrivateKey(keyDER)
+	if err != nil {
+		return err
+	}
+
+	if !certutil.IsKeyOfCert(key, cert) {
+		return fmt.Errorf("certificate doesn't match private key")
+	}
+
+	return nil
+}
+
+// deleteCSR removes the certificate signing request of node and its private key
+func (handler *certHandler) deleteCSR(ctx context.Context, nodeName string) error {
+	csr := certv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: getCSRName(nodeName)},
+	}
+	if err := handler.client.Delete(ctx, &csr); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	keySecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getCSRKeySecretName(nodeName),
+			Namespace: handler.namespace,
+		},
+	}
+	if err := handler.client.Delete(ctx, &keySecret); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// removeCSRKey removes the secret of private key of a finished request and the request itself
+// if they are left, e.g. they failed to be removed after the certificate was saved
+func (handler *certHandler) removeCSRKey(ctx context.Context, nodeName string) error {
+	if handler.csrSignerName == "" {
+		return nil
+	}
+
+	var keySecret corev1.Secret
+	err := handler.client.Get(ctx, ObjectKey{Name: getCSRKeySecretName(nodeName), Namespace: handler.namespace}, &keySecret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	handler.log.V(3).Info("remove private key of finished certificate signing request", "nodeName", nodeName)
+	return handler.deleteCSR(ctx, nodeName)
+}
+
+// requestAgain removes the certificate signing request of node, a new one is created next time
+func (handler *certHandler) requestAgain(ctx context.Context, nodeName string) error {
+	if err := handler.deleteCSR(ctx, nodeName); err != nil {
+		return err
+	}
+
+	return errCertPending
+}
+
+func getCSRName(nodeName string) string {
+	return fmt.Sprintf("fabedge-agent-%s", nodeName)
+}
+
+func getCSRKeySecretName(nodeName string) string {
+	return fmt.Sprintf("fabedge-agent-csr-key-%s", nodeName)
+}
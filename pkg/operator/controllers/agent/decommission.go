@@ -0,0 +1,131 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+// decommissionInterval is how often a decommissioning node is checked until its cleanup pod succeeds
+const decommissionInterval = 5 * time.Second
+
+func isDecommissioning(node corev1.Node) bool {
+	_, ok := node.Annotations[constants.KeyDecommission]
+	return ok
+}
+
+// decommission removes agent from the node and network settings made by agent on the host, so the
+// hardware can be returned without stale routes, xfrm policies, iptables rules, ipsets and virtual
+// servers. Agent pod and resources of the node are removed first and peers stop building tunnels to
+// it, then a cleanup pod runs on the node, after it succeeds, the annotation is changed to done
+func (ctl *agentController) decommission(ctx context.Context, node corev1.Node) (reconcile.Result, error) {
+	log := ctl.log.WithValues("nodeName", node.Name)
+
+	done := node.Annotations[constants.KeyDecommission] == constants.DecommissionDone
+	if _, ok := ctl.store.GetEndpoint(ctl.getEndpointName(node.Name)); done && !ok && !ctl.edgeNameSet.Has(node.Name) {
+		return reconcile.Result{}, nil
+	}
+
+	// handlers are undone even if the node is not known, endpoint of the node may be loaded
+	// into store when operator starts
+	for i := len(ctl.handlers) - 1; i >= 0; i-- {
+		if err := ctl.handlers[i].Undo(ctx, node.Name); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if ctl.edgeNameSet.Has(node.Name) {
+		log.Info("edge node is decommissioned, agent and its resources are removed")
+		ctl.enqueuePeersOf(node.Name)
+		ctl.edgeNameSet.Delete(node.Name)
+	}
+
+	if done {
+		return reconcile.Result{}, nil
+	}
+
+	podHandler := ctl.getAgentPodHandler()
+	if podHandler == nil {
+		return reconcile.Result{}, nil
+	}
+
+	// network settings made by a running agent would come back after cleanup
+	var agentPod corev1.Pod
+	err := ctl.client.Get(ctx, ObjectKey{Name: getAgentPodName(node.Name), Namespace: podHandler.namespace}, &agentPod)
+	switch {
+	case err == nil:
+		log.V(3).Info("wait for agent pod to be deleted")
+		return reconcile.Result{RequeueAfter: decommissionInterval}, nil
+	case !errors.IsNotFound(err):
+		log.Error(err, "failed to get agent pod")
+		return reconcile.Result{}, err
+	}
+
+	if !isNodeReady(node) {
+		log.V(3).Info("node is not ready, wait for it to run cleanup pod")
+		return reconcile.Result{RequeueAfter: decommissionInterval}, nil
+	}
+
+	succeeded, err := podHandler.runCleanupPod(ctx, node)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if !succeeded {
+		log.V(3).Info("wait for cleanup pod to succeed")
+		return reconcile.Result{RequeueAfter: decommissionInterval}, nil
+	}
+
+	cleanupPod := corev1.Pod{}
+	cleanupPod.Name, cleanupPod.Namespace = getCleanupPodName(node.Name), podHandler.namespace
+	if err = ctl.client.Delete(ctx, &cleanupPod); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to delete cleanup pod")
+		return reconcile.Result{}, err
+	}
+
+	if ctl.allocated {
+		if err = removePodSubnetsAnnotation(ctx, ctl.client, node); err != nil {
+			log.Error(err, "failed to remove pod subnets annotation")
+			return reconcile.Result{}, err
+		}
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Annotations[constants.KeyDecommission] = constants.DecommissionDone
+	if err = ctl.client.Patch(ctx, &node, patch); err != nil {
+		log.Error(err, "failed to mark edge node as decommissioned")
+		return reconcile.Result{}, err
+	}
+
+	log.Info("edge node is decommissioned")
+	return reconcile.Result{}, nil
+}
+
+func (ctl *agentController) getAgentPodHandler() *agentPodHandler {
+	for _, h := range ctl.handlers {
+		if podHandler, ok := h.(*agentPodHandler); ok {
+			return podHandler
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+// indexPodNodeName is the field pods are indexed by, so pods of a node can be listed from cache
+const indexPodNodeName = "spec.nodeName"
+
+func indexPodsByNodeName(mgr manager.Manager) error {
+	return mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, indexPodNodeName, func(obj client.Object) []string {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || pod.Spec.NodeName == "" {
+			return nil
+		}
+		return []string{pod.Spec.NodeName}
+	})
+}
+
+// getHostPorts returns host ports of running pods on the node, agent maps them to pods
+// because the container runtime of edge node may not do it
+func (handler *configHandler) getHostPorts(ctx context.Context, nodeName string) ([]netconf.HostPort, error) {
+	var pods corev1.PodList
+	if err := handler.client.List(ctx, &pods, client.MatchingFields{indexPodNodeName: nodeName}); err != nil {
+		return nil, err
+	}
+
+	var hostPorts []netconf.HostPort
+	for _, pod := range pods.Items {
+		if pod.Spec.HostNetwork || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.HostPort == 0 {
+					continue
+				}
+
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = corev1.ProtocolTCP
+				}
+
+				hostPorts = append(hostPorts, netconf.HostPort{
+					HostIP:        port.HostIP,
+					HostPort:      port.HostPort,
+					Protocol:      protocol,
+					PodIP:         pod.Status.PodIP,
+					ContainerPort: port.ContainerPort,
+				})
+			}
+		}
+	}
+
+	// pods are listed in random order, host ports are sorted to keep config the same
+	sort.Slice(hostPorts, func(i, j int) bool {
+		a, b := hostPorts[i], hostPorts[j]
+		if a.HostPort != b.HostPort {
+			return a.HostPort < b.HostPort
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.HostIP < b.HostIP
+	})
+
+	return hostPorts, nil
+}
+
+// edgeNodeOfHostPortPod enqueues the edge node of a pod which has host ports
+func (ctl *agentController) edgeNodeOfHostPortPod(obj client.Object) []reconcile.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork || !ctl.edgeNameSet.Has(pod.Spec.NodeName) {
+		return nil
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				return []reconcile.Request{{NamespacedName: ObjectKey{Name: pod.Spec.NodeName}}}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+// getLocalIngressInterface returns the LAN interface of the node where local ingress ports are
+// exposed, the annotation of node overrides the default one
+func (handler *configHandler) getLocalIngressInterface(node corev1.Node) string {
+	if iface := strings.TrimSpace(node.Annotations[constants.KeyLocalIngressInterface]); iface != "" {
+		return iface
+	}
+	return handler.localIngressInterface
+}
+
+// getLocalIngresses returns local ingress ports of all services which have the annotation
+// fabedge.io/local-ingress-ports, every edge node exposes the same ports
+func (handler *configHandler) getLocalIngresses(ctx context.Context) ([]netconf.LocalIngress, error) {
+	var services corev1.ServiceList
+	if err := handler.client.List(ctx, &services); err != nil {
+		return nil, err
+	}
+
+	var ingresses []netconf.LocalIngress
+	for _, svc := range services.Items {
+		value, ok := svc.Annotations[constants.KeyLocalIngressPorts]
+		if !ok {
+			continue
+		}
+
+		if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			continue
+		}
+
+		portMap, err := parseLocalIngressPorts(value)
+		if err != nil {
+			handler.log.Error(err, "invalid local ingress ports", "service", client.ObjectKeyFromObject(&svc))
+			continue
+		}
+
+		for _, sp := range svc.Spec.Ports {
+			port, ok := portMap[sp.Port]
+			if !ok {
+				continue
+			}
+
+			protocol := sp.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+
+			ingresses = append(ingresses, netconf.LocalIngress{
+				Service:     client.ObjectKeyFromObject(&svc).String(),
+				Port:        port,
+				Protocol:    protocol,
+				ServiceIP:   svc.Spec.ClusterIP,
+				ServicePort: sp.Port,
+			})
+		}
+	}
+
+	// services are listed in random order, ingresses are sorted to keep config the same
+	sort.Slice(ingresses, func(i, j int) bool {
+		a, b := ingresses[i], ingresses[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Service < b.Service
+	})
+
+	// a port can only be mapped to one service, the first one is taken
+	result := ingresses[:0]
+	for i, ingress := range ingresses {
+		if i > 0 && ingress.Port == ingresses[i-1].Port && ingress.Protocol == ingresses[i-1].Protocol {
+			handler.log.V(3).Info("local ingress port is already used", "port", ingress.Port, "protocol", ingress.Protocol, "service", ingress.Service)
+			continue
+		}
+		result = append(result, ingress)
+	}
+
+	return result, nil
+}
+
+// parseLocalIngressPorts parses value like "8080:80,5353:53", each pair is a port of edge node
+// and the service port it's mapped to, the result is keyed by service ports
+func parseLocalIngressPorts(value string) (map[int32]int32, error) {
+	ports := make(map[int32]int32)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid port pair: %s", pair)
+		}
+
+		port, err := parsePort(parts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		servicePort, err := parsePort(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		ports[servicePort] = port
+	}
+
+	return ports, nil
+}
+
+func parsePort(value string) (int32, error) {
+	port, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %s", value)
+	}
+	return int32(port), nil
+}
+
+// edgeNodesOfLocalIngressService enqueues all edge nodes when a service with local ingress ports is
+// changed, old and new services are both passed in when a service is updated
+func (ctl *agentController) edgeNodesOfLocalIngressService(obj client.Object) []reconcile.Request {
+	if _, ok := obj.GetAnnotations()[constants.KeyLocalIngressPorts]; !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, name := range ctl.edgeNameSet.List() {
+		requests = append(requests, reconcile.Request{NamespacedName: ObjectKey{Name: name}})
+	}
+
+	return requests
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+)
+
+const (
+	superEdgeTunnelEdgeName = "tunnel-edge"
+	superEdgeHealthName     = "edge-health"
+	// superEdgeHealthPort is where edge-health on edge nodes checks each other
+	superEdgeHealthPort = "51005"
+)
+
+// superEdgeComponents tells which components of SuperEdge run on an edge node
+type superEdgeComponents struct {
+	// liteAPIServer is installed on every edge node where tunnel-edge runs
+	liteAPIServer bool
+	edgeHealth    bool
+}
+
+// detectSuperEdge finds components of SuperEdge on the node by their daemonsets
+func (handler *agentPodHandler) detectSuperEdge(ctx context.Context, node corev1.Node) (superEdgeComponents, error) {
+	var components superEdgeComponents
+	if !handler.superEdge {
+		return components, nil
+	}
+
+	for _, c := range []struct {
+		name    string
+		running *bool
+	}{
+		{superEdgeTunnelEdgeName, &components.liteAPIServer},
+		{superEdgeHealthName, &components.edgeHealth},
+	} {
+		ds, err := handler.getDaemonSet(ctx, handler.superEdgeNamespace, c.name)
+		if err != nil {
+			return components, err
+		}
+		*c.running = ds != nil && nodeutil.IsSchedulableBy(node, ds.Spec.Template.Spec)
+	}
+
+	return components, nil
+}
+
+// useSuperEdge makes agent work with SuperEdge components on the node: agent accesses API through
+// lite-apiserver when kube-apiserver can't be reached, and edge-health checks from other edge nodes
+// are accepted by the firewall of agent
+func (handler *agentPodHandler) useSuperEdge(pod *corev1.Pod, components superEdgeComponents) {
+	agent := &pod.Spec.Containers[0]
+
+	usesAPI := pod.Spec.AutomountServiceAccountToken != nil && *pod.Spec.AutomountServiceAccountToken
+	if components.liteAPIServer && usesAPI && handler.liteAPIServerAddress != "" {
+		agent.Args = append(agent.Args, fmt.Sprintf("--lite-apiserver-address=%s", handler.liteAPIServerAddress))
+	}
+
+	if components.edgeHealth && handler.enableFirewall {
+		ports := append([]string{}, handler.firewallPorts...)
+		ports = append(ports, superEdgeHealthPort)
+		for i, arg := range agent.Args {
+			if strings.HasPrefix(arg, "--firewall-allowed-ports=") {
+				agent.Args[i] = fmt.Sprintf("--firewall-allowed-ports=%s", strings.Join(ports, ","))
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+)
+
+// Teardown removes what agent controller made for edge nodes. Agent pods are deleted first,
+// then a cleanup pod is created on each ready edge node to remove network settings made by
+// agent, after all cleanup pods succeed, they are deleted and pod subnets annotations
+// allocated by operator are removed from edge nodes.
+// Agent configmaps and secrets are not deleted here, they are left to the caller.
+type Teardown struct {
+	namespace string
+	// allocated tells whether pod subnets annotations of edge nodes are made by operator
+	allocated  bool
+	podHandler *agentPodHandler
+
+	client client.Client
+	log    logr.Logger
+}
+
+func NewTeardown(cnf Config) *Teardown {
+	cli := cnf.Manager.GetClient()
+	log := cnf.Manager.GetLogger().WithName("agent-teardown")
+
+	return &Teardown{
+		namespace:  cnf.Namespace,
+		allocated:  cnf.Allocator != nil,
+		podHandler: newAgentPodHandler(cnf, cli, log),
+		client:     cli,
+		log:        log,
+	}
+}
+
+// Run executes one round of teardown, it returns true when everything is removed,
+// otherwise the caller needs to call it again later
+func (t *Teardown) Run(ctx context.Context) (bool, error) {
+	var nodes corev1.NodeList
+	if err := t.client.List(ctx, &nodes, client.MatchingLabels(nodeutil.GetEdgeNodeLabels())); err != nil {
+		t.log.Error(err, "failed to list edge nodes")
+		return false, err
+	}
+
+	t.log.V(3).Info("delete agent pods")
+	err := t.client.DeleteAllOf(ctx, &corev1.Pod{},
+		client.InNamespace(t.namespace),
+		client.MatchingLabels{
+			constants.KeyFabedgeAPP: constants.AppAgent,
+			constants.KeyCreatedBy:  constants.AppOperator,
+		},
+	)
+	if err != nil {
+		t.log.Error(err, "failed to delete agent pods")
+		return false, err
+	}
+
+	done := true
+	for _, node := range nodes.Items {
+		succeeded, err := t.cleanupNode(ctx, node)
+		if err != nil {
+			return false, err
+		}
+		done = done && succeeded
+	}
+
+	if !done {
+		t.log.V(3).Info("wait for cleanup pods to succeed")
+		return false, nil
+	}
+
+	t.log.V(3).Info("delete cleanup pods")
+	err = t.client.DeleteAllOf(ctx, &corev1.Pod{},
+		client.InNamespace(t.namespace),
+		client.MatchingLabels{
+			constants.KeyFabedgeAPP: constants.AppAgentCleanup,
+			constants.KeyCreatedBy:  constants.AppOperator,
+		},
+	)
+	if err != nil {
+		t.log.Error(err, "failed to delete cleanup pods")
+		return false, err
+	}
+
+	if !t.allocated {
+		return true, nil
+	}
+
+	for i := range nodes.Items {
+		if err = removePodSubnetsAnnotation(ctx, t.client, nodes.Items[i]); err != nil {
+			t.log.Error(err, "failed to remove pod subnets annotation", "nodeName", nodes.Items[i].Name)
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// cleanupNode makes sure a cleanup pod is created on the node and returns true if
+// the pod succeeds. Nodes which are not ready are skipped because cleanup pods
+// can't run on them
+func (t *Teardown) cleanupNode(ctx context.Context, node corev1.Node) (bool, error) {
+	log := t.log.WithValues("nodeName", node.Name)
+
+	if !isNodeReady(node) {
+		log.V(3).Info("node is not ready, skip cleaning it up")
+		return true, nil
+	}
+
+	return t.podHandler.runCleanupPod(ctx, node)
+}
+
+// removePodSubnetsAnnotation removes pod subnets allocated by operator from the node
+func removePodSubnetsAnnotation(ctx context.Context, cli client.Client, node corev1.Node) error {
+	if _, ok := node.Annotations[constants.KeyPodSubnets]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	delete(node.Annotations, constants.KeyPodSubnets)
+	return cli.Patch(ctx, &node, patch)
+}
+
+func isNodeReady(node corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autocommunity
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "auto-community-controller"
+)
+
+type ObjectKey = client.ObjectKey
+
+type Config struct {
+	Manager manager.Manager
+	// LabelKey is the node label used to group edge nodes, edge nodes with
+	// the same value of this label make up a community
+	LabelKey string
+	// NamePrefix is prepended to label value to make a community name
+	NamePrefix      string
+	GetEndpointName types.GetNameFunc
+}
+
+// autoCommunityController maintains communities from edge nodes' labels.
+// The reconcile request's name is a label value instead of an object name.
+type autoCommunityController struct {
+	Config
+
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &autoCommunityController{
+		Config: cnf,
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ctl.Watch(
+		&source.Kind{Type: &corev1.Node{}},
+		handler.EnqueueRequestsFromMapFunc(reconciler.labelValuesForNode),
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctl.Watch(
+		&source.Kind{Type: &apis.Community{}},
+		handler.EnqueueRequestsFromMapFunc(reconciler.labelValueForCommunity),
+	)
+}
+
+// labelValuesForNode returns the label value of node and the label values of those
+// auto communities which have the node as member, the latter is necessary when
+// a node's label is changed or a node is removed
+func (ctl *autoCommunityController) labelValuesForNode(obj client.Object) []reconcile.Request {
+	values := sets.NewString()
+	if value, ok := obj.GetLabels()[ctl.LabelKey]; ok && value != "" {
+		values.Insert(value)
+	}
+
+	var communities apis.CommunityList
+	err := ctl.client.List(context.Background(), &communities, client.HasLabels{constants.KeyAutoCommunity})
+	if err != nil {
+		ctl.log.Error(err, "failed to list auto communities")
+	}
+
+	member := ctl.GetEndpointName(obj.GetName())
+	for _, community := range communities.Items {
+		if sets.NewString(community.Spec.Members...).Has(member) {
+			values.Insert(community.Labels[constants.KeyAutoCommunity])
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, values.Len())
+	for _, value := range values.List() {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: ObjectKey{Name: value},
+		})
+	}
+
+	return requests
+}
+
+func (ctl *autoCommunityController) labelValueForCommunity(obj client.Object) []reconcile.Request {
+	value, ok := obj.GetLabels()[constants.KeyAutoCommunity]
+	if !ok {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: ObjectKey{Name: value}},
+	}
+}
+
+func (ctl *autoCommunityController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	value := request.Name
+	name := ctl.getCommunityName(value)
+	log := ctl.log.WithValues("labelValue", value, "community", name)
+
+	members, err := ctl.getMembers(ctx, value)
+	if err != nil {
+		log.Error(err, "failed to get edge nodes")
+		return reconcile.Result{}, err
+	}
+
+	var community apis.Community
+	err = ctl.client.Get(ctx, ObjectKey{Name: name}, &community)
+	switch {
+	case errors.IsNotFound(err):
+		if len(members) == 0 {
+			return reconcile.Result{}, nil
+		}
+
+		log.V(3).Info("create auto community", "members", members)
+		community = apis.Community{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					constants.KeyAutoCommunity: value,
+					constants.KeyCreatedBy:     constants.AppOperator,
+				},
+			},
+			Spec: apis.CommunitySpec{
+				Members: members,
+			},
+		}
+		err = ctl.client.Create(ctx, &community)
+		if err != nil {
+			log.Error(err, "failed to create community")
+		}
+		return reconcile.Result{}, err
+	case err != nil:
+		log.Error(err, "failed to get community")
+		return reconcile.Result{}, err
+	}
+
+	if community.Labels[constants.KeyAutoCommunity] != value {
+		log.V(3).Info("community exists but is not created automatically, skip it")
+		return reconcile.Result{}, nil
+	}
+
+	if community.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	if len(members) == 0 {
+		log.V(3).Info("no edge node matches, delete auto community")
+		err = ctl.client.Delete(ctx, &community)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "failed to delete community")
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if sets.NewString(community.Spec.Members...).Equal(sets.NewString(members...)) {
+		return reconcile.Result{}, nil
+	}
+
+	log.V(3).Info("update members of auto community", "members", members)
+	community.Spec.Members = members
+	err = ctl.client.Update(ctx, &community)
+	if err != nil {
+		log.Error(err, "failed to update community")
+	}
+	return reconcile.Result{}, err
+}
+
+func (ctl *autoCommunityController) getMembers(ctx context.Context, value string) ([]string, error) {
+	selector := client.MatchingLabels{ctl.LabelKey: value}
+	for key, v := range nodeutil.GetEdgeNodeLabels() {
+		selector[key] = v
+	}
+
+	var nodes corev1.NodeList
+	if err := ctl.client.List(ctx, &nodes, selector); err != nil {
+		return nil, err
+	}
+
+	members := sets.NewString()
+	for _, node := range nodes.Items {
+		if node.DeletionTimestamp != nil {
+			continue
+		}
+		members.Insert(ctl.GetEndpointName(node.Name))
+	}
+
+	return members.List(), nil
+}
+
+// getCommunityName makes a valid community name from label value, label value may
+// contain uppercase letters and underscores which are not allowed in object name
+func (ctl *autoCommunityController) getCommunityName(value string) string {
+	value = strings.ReplaceAll(strings.ToLower(value), "_", "-")
+	return ctl.NamePrefix + value
+}
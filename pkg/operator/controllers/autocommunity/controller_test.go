@@ -0,0 +1,130 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package autocommunity
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+)
+
+var _ = Describe("AutoCommunityController", func() {
+	const labelKey = "topology.fabedge.io/site"
+
+	var (
+		ctl *autoCommunityController
+		ctx = context.Background()
+	)
+
+	newEdgeNode := func(name, site string) corev1.Node {
+		labels := map[string]string{labelKey: site}
+		for key, value := range nodeutil.GetEdgeNodeLabels() {
+			labels[key] = value
+		}
+
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+	}
+
+	reconcileSite := func(site string) {
+		_, err := ctl.Reconcile(ctx, reconcile.Request{NamespacedName: ObjectKey{Name: site}})
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		nodeutil.SetEdgeNodeLabels(map[string]string{"edge": ""})
+
+		ctl = &autoCommunityController{
+			Config: Config{
+				LabelKey:   labelKey,
+				NamePrefix: "auto-",
+				GetEndpointName: func(name string) string {
+					return "test." + name
+				},
+			},
+			client: k8sClient,
+			log:    klogr.New().WithName(controllerName),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.DeleteAllOf(ctx, &corev1.Node{})).Should(Succeed())
+		Expect(k8sClient.DeleteAllOf(ctx, &apis.Community{})).Should(Succeed())
+	})
+
+	It("should create, update and delete community according to labels of edge nodes", func() {
+		edge1, edge2 := newEdgeNode("edge1", "foo"), newEdgeNode("edge2", "foo")
+		Expect(k8sClient.Create(ctx, &edge1)).Should(Succeed())
+		Expect(k8sClient.Create(ctx, &edge2)).Should(Succeed())
+
+		reconcileSite("foo")
+
+		var community apis.Community
+		Expect(k8sClient.Get(ctx, ObjectKey{Name: "auto-foo"}, &community)).Should(Succeed())
+		Expect(community.Labels[constants.KeyAutoCommunity]).To(Equal("foo"))
+		Expect(community.Spec.Members).To(ConsistOf("test.edge1", "test.edge2"))
+
+		Expect(ctl.labelValuesForNode(&edge1)).To(ConsistOf(reconcile.Request{NamespacedName: ObjectKey{Name: "foo"}}))
+
+		edge2.Labels[labelKey] = "bar"
+		Expect(k8sClient.Update(ctx, &edge2)).Should(Succeed())
+		Expect(ctl.labelValuesForNode(&edge2)).To(ConsistOf(
+			reconcile.Request{NamespacedName: ObjectKey{Name: "foo"}},
+			reconcile.Request{NamespacedName: ObjectKey{Name: "bar"}},
+		))
+
+		reconcileSite("foo")
+		Expect(k8sClient.Get(ctx, ObjectKey{Name: "auto-foo"}, &community)).Should(Succeed())
+		Expect(community.Spec.Members).To(ConsistOf("test.edge1"))
+
+		Expect(k8sClient.Delete(ctx, &edge1)).Should(Succeed())
+		reconcileSite("foo")
+		err := k8sClient.Get(ctx, ObjectKey{Name: "auto-foo"}, &community)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should not touch communities which are not created automatically", func() {
+		community := apis.Community{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "auto-foo",
+			},
+			Spec: apis.CommunitySpec{
+				Members: []string{"test.edge3"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, &community)).Should(Succeed())
+
+		edge1 := newEdgeNode("edge1", "foo")
+		Expect(k8sClient.Create(ctx, &edge1)).Should(Succeed())
+
+		reconcileSite("foo")
+		Expect(k8sClient.Get(ctx, ObjectKey{Name: "auto-foo"}, &community)).Should(Succeed())
+		Expect(community.Spec.Members).To(ConsistOf("test.edge3"))
+	})
+})
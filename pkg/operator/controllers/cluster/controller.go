@@ -2,27 +2,43 @@ package cluster
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/golang-jwt/jwt/v4"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 const (
 	controllerName = "cluster-controller"
+	// finalizerDeregister is added to member clusters to make sure the cleanup is done
+	// before a member cluster is removed
+	finalizerDeregister = "fabedge.io/deregister"
+	// defaultReadyTimeout is how long a cluster can miss heartbeats before it's not ready
+	// if EvictionTimeout is not set
+	defaultReadyTimeout = time.Minute
 )
 
 type EndpointNameSet = sets.String
@@ -39,9 +55,26 @@ type controller struct {
 type Config struct {
 	Cluster       string
 	TokenDuration time.Duration
-	PrivateKey    *rsa.PrivateKey
+	PrivateKey    crypto.Signer
 	Store         storepkg.Interface
 	Manager       manager.Manager
+	// Tokens is used to mint tokens for clusters, a cluster's token is replaced
+	// when it's expired or revoked. If it's nil, tokens are not stored or renewed
+	Tokens *tokenpkg.Manager
+
+	MaxConcurrentReconciles int
+	// SyncInterval is the interval to reconcile each cluster again, 0 means
+	// clusters are reconciled only when they change
+	SyncInterval time.Duration
+	// EvictionTimeout is how long a cluster can be absent before its endpoints are
+	// removed from store, 0 means clusters are never evicted. Clusters which never
+	// report heartbeat are not evicted either
+	EvictionTimeout time.Duration
+	// Notifier sends events when a cluster goes stale or is removed, nil means no events are sent
+	Notifier webhook.Notifier
+	// CRL revokes client certificates of a cluster when it's deregistered, if it's nil, they are
+	// rejected only while the cluster doesn't exist
+	CRL *crlpkg.Manager
 }
 
 func AddToManager(config Config) error {
@@ -50,12 +83,13 @@ func AddToManager(config Config) error {
 		controllerName,
 		mgr,
 		ctrlpkg.Options{
-			Reconciler: &controller{
+			MaxConcurrentReconciles: config.MaxConcurrentReconciles,
+			Reconciler: tracing.WrapReconciler(controllerName, &controller{
 				Config:       config,
 				client:       mgr.GetClient(),
 				log:          mgr.GetLogger().WithName(controllerName),
 				clusterCache: make(map[string]EndpointNameSet),
-			},
+			}),
 		},
 	)
 	if err != nil {
@@ -89,37 +123,175 @@ func (ctl *controller) Reconcile(ctx context.Context, request reconcile.Request)
 
 	if cluster.DeletionTimestamp != nil {
 		ctl.pruneEndpoints(request.Name)
-		return reconcile.Result{}, nil
+		return reconcile.Result{}, ctl.deregister(ctx, cluster)
 	}
 
-	if err := ctl.generateTokenIfNeeded(ctx, cluster); err != nil {
+	tokenChanged, err := ctl.generateTokenIfNeeded(ctx, &cluster)
+	if err != nil {
 		ctl.log.Error(err, "failed to assign token for cluster", "cluster", cluster.Name)
 		return reconcile.Result{}, err
 	}
 
+	if tokenChanged || !controllerutil.ContainsFinalizer(&cluster, finalizerDeregister) {
+		controllerutil.AddFinalizer(&cluster, finalizerDeregister)
+		if err = ctl.client.Update(ctx, &cluster); err != nil {
+			log.Error(err, "failed to update cluster")
+			return reconcile.Result{}, err
+		}
+	}
+
+	result := reconcile.Result{RequeueAfter: ctl.SyncInterval}
+	requeueAfter := func(d time.Duration) {
+		if result.RequeueAfter == 0 || d < result.RequeueAfter {
+			result.RequeueAfter = d
+		}
+	}
+
+	timeToEvict, evictable := ctl.timeToEvict(cluster)
+	evicted := evictable && timeToEvict <= 0
+
+	timeLeft, seen := ctl.updateStatus(ctx, cluster, evicted)
+	if seen {
+		requeueAfter(timeLeft)
+	}
+
+	if evicted {
+		log.Info("cluster is not seen for a long time, evicting its endpoints", "lastSeen", cluster.Status.LastSeen)
+		ctl.pruneEndpoints(cluster.Name)
+		return result, nil
+	}
+
+	if evictable {
+		requeueAfter(timeToEvict)
+	}
+
 	// for now, endpoints will contain only connector of every cluster
 	ctl.syncEndpoints(cluster)
 
-	return reconcile.Result{}, nil
+	return result, nil
+}
+
+// updateStatus updates inventory, EndpointsSynced and Ready conditions of cluster, it returns true
+// and how long is left before the cluster is not ready if the cluster is seen recently
+func (ctl *controller) updateStatus(ctx context.Context, cluster apis.Cluster, evicted bool) (time.Duration, bool) {
+	readyTimeout := ctl.EvictionTimeout
+	if readyTimeout <= 0 {
+		readyTimeout = defaultReadyTimeout
+	}
+
+	var (
+		timeLeft        time.Duration
+		seen            bool
+		reason, message string
+	)
+	switch {
+	case cluster.Status.LastSeen == nil:
+		reason, message = types.ReasonNeverSeen, "cluster has never reported heartbeat"
+	default:
+		timeLeft = time.Until(cluster.Status.LastSeen.Add(readyTimeout))
+		seen = timeLeft > 0
+		if seen {
+			reason = types.ReasonHeartbeatReceived
+		} else {
+			reason, message = types.ReasonHeartbeatMissed, fmt.Sprintf("no heartbeat since %s", cluster.Status.LastSeen.Format(time.RFC3339))
+		}
+	}
+
+	oldStatus := cluster.Status.DeepCopy()
+	types.SetClusterInventory(&cluster)
+	types.SetClusterEndpointsSynced(&cluster, evicted)
+	types.SetClusterReady(&cluster, seen, reason, message)
+	if !reflect.DeepEqual(oldStatus, &cluster.Status) {
+		if err := ctl.client.Status().Update(ctx, &cluster); err != nil {
+			ctl.log.Error(err, "failed to update status of cluster", "cluster", cluster.Name)
+		} else if reason == types.ReasonHeartbeatMissed && !isHeartbeatMissed(oldStatus) {
+			ctl.notify(webhook.EventClusterStale, cluster.Name, map[string]string{
+				"lastSeen": cluster.Status.LastSeen.Format(time.RFC3339),
+			})
+		}
+	}
+
+	return timeLeft, seen
+}
+
+func isHeartbeatMissed(status *apis.ClusterStatus) bool {
+	condition := meta.FindStatusCondition(status.Conditions, apis.ClusterConditionReady)
+	return condition != nil && condition.Reason == types.ReasonHeartbeatMissed
+}
+
+func (ctl *controller) notify(eventType, clusterName string, detail map[string]string) {
+	if ctl.Notifier == nil {
+		return
+	}
+
+	ctl.Notifier.Notify(webhook.Event{
+		Type:    eventType,
+		Cluster: clusterName,
+		Detail:  detail,
+	})
+}
+
+// timeToEvict returns how long is left before the cluster is evicted,
+// false is returned if the cluster won't be evicted
+func (ctl *controller) timeToEvict(cluster apis.Cluster) (time.Duration, bool) {
+	if ctl.EvictionTimeout <= 0 || cluster.Status.LastSeen == nil {
+		return 0, false
+	}
+
+	return time.Until(cluster.Status.LastSeen.Add(ctl.EvictionTimeout)), true
 }
 
-func (ctl *controller) generateTokenIfNeeded(ctx context.Context, cluster apis.Cluster) error {
+// generateTokenIfNeeded assigns a token to cluster if it has none, it returns true
+// if the token of cluster is changed
+func (ctl *controller) generateTokenIfNeeded(ctx context.Context, cluster *apis.Cluster) (bool, error) {
+	if ctl.Tokens != nil {
+		return ctl.renewTokenIfNeeded(ctx, cluster)
+	}
+
 	if len(cluster.Spec.Token) != 0 {
-		return nil
+		return false, nil
+	}
+
+	method, err := tokenpkg.SigningMethod(ctl.PrivateKey.Public())
+	if err != nil {
+		return false, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{
 		Subject:   cluster.Name,
 		ExpiresAt: time.Now().Add(ctl.TokenDuration).Unix(),
 	})
 
-	tokenString, err := token.SignedString(ctl.PrivateKey)
+	tokenString, err := tokenpkg.SignedString(token, ctl.PrivateKey)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	cluster.Spec.Token = tokenString
-	return ctl.client.Update(ctx, &cluster)
+	return true, nil
+}
+
+func (ctl *controller) renewTokenIfNeeded(ctx context.Context, cluster *apis.Cluster) (bool, error) {
+	if len(cluster.Spec.Token) != 0 {
+		_, err := ctl.Tokens.Verify(ctx, cluster.Spec.Token)
+		if err == nil {
+			return false, nil
+		}
+
+		// errors from API server don't mean the token is invalid
+		if _, ok := err.(errors.APIStatus); ok {
+			return false, err
+		}
+		ctl.log.V(3).Info("token of cluster is invalid, mint a new one", "cluster", cluster.Name, "reason", err.Error())
+	}
+
+	token, err := ctl.Tokens.Mint(ctx, cluster.Name, ctl.TokenDuration)
+	if err != nil {
+		return false, err
+	}
+
+	cluster.Spec.Token = token.Value
+	return true, nil
 }
 
 func (ctl *controller) syncEndpoints(cluster apis.Cluster) {
@@ -148,6 +320,75 @@ func (ctl *controller) syncEndpoints(cluster apis.Cluster) {
 	}
 }
 
+// deregister removes endpoints of cluster from communities and revokes its tokens and
+// client certificates, then removes the finalizer to let the cluster go
+func (ctl *controller) deregister(ctx context.Context, cluster apis.Cluster) error {
+	log := ctl.log.WithValues("cluster", cluster.Name)
+
+	if !controllerutil.ContainsFinalizer(&cluster, finalizerDeregister) {
+		return nil
+	}
+
+	if err := ctl.removeFromCommunities(ctx, cluster.Name); err != nil {
+		log.Error(err, "failed to remove endpoints of cluster from communities")
+		return err
+	}
+
+	if ctl.Tokens != nil {
+		if err := ctl.Tokens.RevokeAll(ctx, cluster.Name); err != nil {
+			log.Error(err, "failed to revoke tokens of cluster")
+			return err
+		}
+	}
+
+	if ctl.CRL != nil {
+		for _, serialNumber := range types.GetClusterCertSerialNumbers(cluster) {
+			if err := ctl.CRL.Revoke(ctx, serialNumber); err != nil {
+				log.Error(err, "failed to revoke certificate of cluster", "serialNumber", crlpkg.FormatSerialNumber(serialNumber))
+				return err
+			}
+		}
+	}
+
+	controllerutil.RemoveFinalizer(&cluster, finalizerDeregister)
+	if err := ctl.client.Update(ctx, &cluster); err != nil {
+		log.Error(err, "failed to remove deregister finalizer from cluster")
+		return err
+	}
+
+	log.Info("cluster is deregistered")
+	ctl.notify(webhook.EventClusterRemoved, cluster.Name, nil)
+	return nil
+}
+
+func (ctl *controller) removeFromCommunities(ctx context.Context, clusterName string) error {
+	var communities apis.CommunityList
+	if err := ctl.client.List(ctx, &communities); err != nil {
+		return err
+	}
+
+	prefix := clusterName + "."
+	for _, community := range communities.Items {
+		members := make([]string, 0, len(community.Spec.Members))
+		for _, member := range community.Spec.Members {
+			if !strings.HasPrefix(member, prefix) {
+				members = append(members, member)
+			}
+		}
+
+		if len(members) == len(community.Spec.Members) {
+			continue
+		}
+
+		community.Spec.Members = members
+		if err := ctl.client.Update(ctx, &community); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (ctl *controller) pruneEndpoints(clusterName string) {
 	ctl.mux.Lock()
 	defer ctl.mux.Unlock()
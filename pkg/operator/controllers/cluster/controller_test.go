@@ -3,11 +3,16 @@ package cluster
 import (
 	"context"
 	"crypto/x509"
+	"math/big"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
@@ -17,9 +22,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 	. "github.com/fabedge/fabedge/pkg/util/ginkgoext"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 	testutil "github.com/fabedge/fabedge/pkg/util/test"
 	timeutil "github.com/fabedge/fabedge/pkg/util/time"
 )
@@ -32,10 +41,13 @@ var _ = Describe("Controller", func() {
 		cluster  apis.Cluster
 		ctrl     *controller
 		cert     *x509.Certificate
+		notifier *fakeNotifier
+		caSecret corev1.Secret
 	)
 
 	BeforeEach(func() {
 		ctx, cancel = context.WithCancel(context.Background())
+		notifier = &fakeNotifier{}
 
 		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
 			CommonName:     certutil.DefaultCAName,
@@ -45,6 +57,16 @@ var _ = Describe("Controller", func() {
 		})
 		privateKey, _ := x509.ParsePKCS1PrivateKey(caKeyDER)
 		cert, _ = x509.ParseCertificate(caCertDER)
+		caSecret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "fabedge-ca",
+				Namespace: "default",
+			},
+			Data: map[string][]byte{
+				secretutil.KeyCACert: certutil.EncodeCertPEM(caCertDER),
+				secretutil.KeyCAKey:  certutil.EncodePrivateKeyPEM(caKeyDER),
+			},
+		}
 
 		mgr, err := manager.New(cfg, manager.Options{
 			MetricsBindAddress:     "0",
@@ -58,6 +80,12 @@ var _ = Describe("Controller", func() {
 				Store:         storepkg.NewStore(),
 				PrivateKey:    privateKey,
 				TokenDuration: time.Hour,
+				Notifier:      notifier,
+				CRL: &crlpkg.Manager{
+					SecretKey:   client.ObjectKey{Name: "fabedge-crl", Namespace: "default"},
+					CASecretKey: client.ObjectKeyFromObject(&caSecret),
+					Client:      k8sClient,
+				},
 			},
 			clusterCache: make(map[string]EndpointNameSet),
 			client:       mgr.GetClient(),
@@ -127,10 +155,18 @@ var _ = Describe("Controller", func() {
 		Eventually(requests, 5*time.Second).Should(ReceiveKey(client.ObjectKey{
 			Name: cluster.Name,
 		}))
+		// status update
+		testutil.DrainChan(requests, time.Second)
 	})
 
 	AfterEach(func() {
 		cancel()
+
+		// the controller is stopped, so finalizer has to be removed here
+		if err := k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster); err == nil {
+			cluster.Finalizers = nil
+			Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+		}
 		Expect(k8sClient.Delete(context.Background(), &cluster))
 	})
 
@@ -214,6 +250,156 @@ var _ = Describe("Controller", func() {
 		}
 	})
 
+	It("should evict endpoints of cluster which is not seen for a long time", func() {
+		ctrl.EvictionTimeout = time.Minute
+
+		By("reporting heartbeat long time ago")
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		lastSeen := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		cluster.Status.LastSeen = &lastSeen
+		Expect(k8sClient.Status().Update(context.Background(), &cluster)).Should(Succeed())
+		Eventually(requests, 5*time.Second).Should(ReceiveKey(client.ObjectKey{
+			Name: cluster.Name,
+		}))
+
+		_, ok := ctrl.clusterCache[cluster.Name]
+		Expect(ok).Should(BeFalse())
+		for _, ep := range cluster.Spec.EndPoints {
+			_, ok := ctrl.Store.GetEndpoint(ep.Name)
+			Expect(ok).Should(BeFalse())
+		}
+
+		// status of cluster is updated by controller
+		testutil.DrainChan(requests, time.Second)
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		condition := meta.FindStatusCondition(cluster.Status.Conditions, apis.ConditionEndpointsSynced)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal(types.ReasonEvicted))
+
+		By("reporting heartbeat again")
+		lastSeen = metav1.Now()
+		cluster.Status.LastSeen = &lastSeen
+		Expect(k8sClient.Status().Update(context.Background(), &cluster)).Should(Succeed())
+		Eventually(requests, 5*time.Second).Should(ReceiveKey(client.ObjectKey{
+			Name: cluster.Name,
+		}))
+
+		for _, ep := range cluster.Spec.EndPoints {
+			_, ok := ctrl.Store.GetEndpoint(ep.Name)
+			Expect(ok).Should(BeTrue())
+		}
+
+		testutil.DrainChan(requests, time.Second)
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		condition = meta.FindStatusCondition(cluster.Status.Conditions, apis.ConditionEndpointsSynced)
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).Should(Equal(types.ReasonSynced))
+	})
+
+	It("should notify when cluster goes stale", func() {
+		ctrl.EvictionTimeout = time.Minute
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		lastSeen := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		cluster.Status.LastSeen = &lastSeen
+		Expect(k8sClient.Status().Update(context.Background(), &cluster)).Should(Succeed())
+		Eventually(requests, 5*time.Second).Should(ReceiveKey(client.ObjectKey{
+			Name: cluster.Name,
+		}))
+		testutil.DrainChan(requests, time.Second)
+
+		Expect(notifier.Types(cluster.Name)).Should(Equal([]string{webhook.EventClusterStale}))
+	})
+
+	It("should update inventory and ready condition of cluster", func() {
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		Expect(cluster.Status.EndpointCount).Should(Equal(int32(2)))
+		Expect(cluster.Status.ConnectorPublicAddresses).Should(ConsistOf("10.10.10.10", "test.example", "10.10.10.1"))
+
+		condition := meta.FindStatusCondition(cluster.Status.Conditions, apis.ClusterConditionReady)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal(types.ReasonNeverSeen))
+
+		By("reporting heartbeat")
+		lastSeen := metav1.Now()
+		cluster.Status.LastSeen = &lastSeen
+		Expect(k8sClient.Status().Update(context.Background(), &cluster)).Should(Succeed())
+		Eventually(requests, 5*time.Second).Should(ReceiveKey(client.ObjectKey{
+			Name: cluster.Name,
+		}))
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		condition = meta.FindStatusCondition(cluster.Status.Conditions, apis.ClusterConditionReady)
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).Should(Equal(types.ReasonHeartbeatReceived))
+	})
+
+	It("should remove endpoints of cluster from communities when cluster is deregistered", func() {
+		community := apis.Community{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "connectors",
+			},
+			Spec: apis.CommunitySpec{
+				Members: []string{"root.connector", "beijing.connector"},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &community)).Should(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), &community)).Should(Succeed())
+		}()
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		Expect(cluster.Finalizers).Should(ContainElement(finalizerDeregister))
+
+		Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &apis.Cluster{})
+			return errors.IsNotFound(err)
+		}, 5*time.Second).Should(BeTrue())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: community.Name}, &community)).Should(Succeed())
+		Expect(community.Spec.Members).Should(ConsistOf("beijing.connector"))
+		Expect(notifier.Types(cluster.Name)).Should(ContainElement(webhook.EventClusterRemoved))
+
+		for _, ep := range cluster.Spec.EndPoints {
+			_, ok := ctrl.Store.GetEndpoint(ep.Name)
+			Expect(ok).Should(BeFalse())
+		}
+	})
+
+	It("should revoke client certificates of cluster when cluster is deregistered", func() {
+		Expect(k8sClient.Create(context.Background(), &caSecret)).Should(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), &caSecret)).Should(Succeed())
+			crlSecret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "fabedge-crl", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), &crlSecret))).Should(Succeed())
+		}()
+
+		clientCert := &x509.Certificate{
+			SerialNumber: big.NewInt(1234),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &cluster)).Should(Succeed())
+		types.RecordClusterCertificate(&cluster, clientCert)
+		Expect(k8sClient.Update(context.Background(), &cluster)).Should(Succeed())
+
+		revoked, err := ctrl.CRL.IsRevoked(context.Background(), clientCert.SerialNumber)
+		Expect(err).Should(BeNil())
+		Expect(revoked).Should(BeFalse())
+
+		Expect(k8sClient.Delete(context.Background(), &cluster)).Should(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(context.Background(), client.ObjectKey{Name: cluster.Name}, &apis.Cluster{})
+			return errors.IsNotFound(err)
+		}, 5*time.Second).Should(BeTrue())
+
+		revoked, err = ctrl.CRL.IsRevoked(context.Background(), clientCert.SerialNumber)
+		Expect(err).Should(BeNil())
+		Expect(revoked).Should(BeTrue())
+	})
+
 	It("will skip cluster with name specified in controller", func() {
 		cluster = apis.Cluster{
 			ObjectMeta: metav1.ObjectMeta{
@@ -251,3 +437,28 @@ var _ = Describe("Controller", func() {
 		Expect(ok).Should(BeFalse())
 	})
 })
+
+type fakeNotifier struct {
+	mux    sync.Mutex
+	events []webhook.Event
+}
+
+func (n *fakeNotifier) Notify(event webhook.Event) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.events = append(n.events, event)
+}
+
+// Types returns types of events of cluster in order
+func (n *fakeNotifier) Types(cluster string) []string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	var eventTypes []string
+	for _, event := range n.events {
+		if event.Cluster == cluster {
+			eventTypes = append(eventTypes, event.Type)
+		}
+	}
+	return eventTypes
+}
@@ -16,24 +16,31 @@ package community
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 const (
 	controllerName = "community-controller"
+	// resyncInterval is how often a community is checked again when endpoints
+	// of some members are not synced
+	resyncInterval = 30 * time.Second
 )
 
 type ObjectKey = client.ObjectKey
@@ -44,20 +51,22 @@ func AddToManager(config Config) error {
 		controllerName,
 		mgr,
 		ctlpkg.Options{
-			Reconciler: &communityController{
+			Reconciler: tracing.WrapReconciler(controllerName, &communityController{
 				store:  config.Store,
 				client: mgr.GetClient(),
 				log:    mgr.GetLogger().WithName(controllerName),
-			},
+			}),
 		},
 	)
 	if err != nil {
 		return err
 	}
 
+	// changes of status don't need reconciling
 	return ctl.Watch(
 		&source.Kind{Type: &apis.Community{}},
 		&handler.EnqueueRequestForObject{},
+		predicate.GenerationChangedPredicate{},
 	)
 }
 
@@ -87,9 +96,45 @@ func (ctl *communityController) Reconcile(ctx context.Context, request reconcile
 		return reconcile.Result{}, nil
 	}
 
+	var egressBandwidth int64
+	if community.Spec.EgressBandwidth != nil {
+		egressBandwidth = community.Spec.EgressBandwidth.Value()
+	}
+
 	ctl.store.SaveCommunity(types.Community{
-		Name:    community.Name,
-		Members: sets.NewString(community.Spec.Members...),
+		Name:            community.Name,
+		Members:         sets.NewString(community.Spec.Members...),
+		DSCP:            community.Spec.DSCP,
+		EgressBandwidth: egressBandwidth,
 	})
+
+	return ctl.updateStatus(ctx, community)
+}
+
+// updateStatus sets EndpointsSynced and Ready conditions of community, the community is
+// checked again later if endpoints of some members are not found in store
+func (ctl *communityController) updateStatus(ctx context.Context, community apis.Community) (reconcile.Result, error) {
+	var missing []string
+	for _, member := range sets.NewString(community.Spec.Members...).List() {
+		if _, ok := ctl.store.GetEndpoint(member); !ok {
+			missing = append(missing, member)
+		}
+	}
+
+	oldStatus := community.Status.DeepCopy()
+	types.SetCommunityEndpointsSynced(&community, missing)
+	types.SetCommunityReady(&community)
+
+	if !equality.Semantic.DeepEqual(oldStatus, &community.Status) {
+		if err := ctl.client.Status().Update(ctx, &community); err != nil {
+			ctl.log.Error(err, "failed to update status of community", "community", community.Name)
+			return reconcile.Result{}, err
+		}
+	}
+
+	if len(missing) > 0 {
+		return reconcile.Result{RequeueAfter: resyncInterval}, nil
+	}
+
 	return reconcile.Result{}, nil
 }
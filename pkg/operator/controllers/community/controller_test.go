@@ -20,6 +20,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -29,6 +30,7 @@ import (
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
 	testutil "github.com/fabedge/fabedge/pkg/util/test"
 )
 
@@ -138,6 +140,44 @@ var _ = Describe("Controller", func() {
 		}
 	})
 
+	It("should set conditions of community according to endpoints of members", func() {
+		community := apis.Community{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test",
+			},
+			Spec: apis.CommunitySpec{
+				Members: []string{
+					"edge1",
+					"edge2",
+					"edge3",
+				},
+			},
+		}
+
+		Expect(k8sClient.Create(context.Background(), &community)).Should(Succeed())
+		testutil.DrainChan(requests, 2*time.Second)
+
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: community.Name}, &community)).Should(Succeed())
+		condition := meta.FindStatusCondition(community.Status.Conditions, apis.ConditionEndpointsSynced)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal(types.ReasonMembersMissing))
+		Expect(condition.Message).Should(ContainSubstring("edge3"))
+
+		condition = meta.FindStatusCondition(community.Status.Conditions, apis.ConditionReady)
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal(types.ReasonMembersMissing))
+
+		By("removing the missing member")
+		community.Spec.Members = []string{"edge1", "edge2"}
+		Expect(k8sClient.Update(context.Background(), &community)).Should(Succeed())
+		testutil.DrainChan(requests, 2*time.Second)
+
+		Expect(k8sClient.Get(context.Background(), ObjectKey{Name: community.Name}, &community)).Should(Succeed())
+		Expect(meta.IsStatusConditionTrue(community.Status.Conditions, apis.ConditionEndpointsSynced)).Should(BeTrue())
+		Expect(meta.IsStatusConditionTrue(community.Status.Conditions, apis.ConditionReady)).Should(BeTrue())
+	})
+
 	It("should clear community in store", func() {
 		var community apis.Community
 		community = apis.Community{
@@ -0,0 +1,439 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivitycheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlpkg "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/util/probe"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "connectivity-check-controller"
+
+	roleServer = "server"
+	roleClient = "client"
+
+	defaultPort    = 8790
+	defaultTimeout = 3 * time.Second
+
+	// podStartTimeout is how long to wait for server probe pods to run, client probe pods
+	// have the same time to finish their tests
+	podStartTimeout = 2 * time.Minute
+	checkInterval   = 2 * time.Second
+)
+
+var allProtocols = []apis.CheckProtocol{apis.CheckProtocolTCP, apis.CheckProtocolUDP, apis.CheckProtocolICMP}
+
+type Config struct {
+	Manager manager.Manager
+	// Namespace is where probe pods run, they use agent image
+	Namespace       string
+	Image           string
+	ImagePullPolicy corev1.PullPolicy
+}
+
+// checkController runs connectivity checks. A server probe pod is started on each node of a check,
+// then a client probe pod on each node tests servers of the others, results of clients are collected
+// from their termination messages and probe pods are removed
+type checkController struct {
+	Config
+
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &checkController{
+		Config: cnf,
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	return ctrlpkg.NewControllerManagedBy(mgr).
+		For(&apis.ConnectivityCheck{}).
+		Owns(&corev1.Pod{}).
+		Named(controllerName).
+		Complete(tracing.WrapReconciler(controllerName, reconciler))
+}
+
+// probePods are probe pods of a check, keys are node names
+type probePods struct {
+	servers map[string]corev1.Pod
+	clients map[string]corev1.Pod
+}
+
+func (ctl *checkController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := ctl.log.WithValues("request", request)
+
+	var check apis.ConnectivityCheck
+	if err := ctl.client.Get(ctx, request.NamespacedName, &check); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get connectivity check")
+		return reconcile.Result{}, err
+	}
+
+	// probe pods are removed by garbage collector when the check is deleted
+	if check.DeletionTimestamp != nil || check.Status.Phase == apis.ConnectivityCheckCompleted {
+		return reconcile.Result{}, nil
+	}
+
+	if check.Status.Phase == "" {
+		now := metav1.Now()
+		check.Status.Phase = apis.ConnectivityCheckRunning
+		check.Status.StartTime = &now
+		if err := ctl.client.Status().Update(ctx, &check); err != nil {
+			log.Error(err, "failed to update status of connectivity check")
+			return reconcile.Result{}, err
+		}
+	}
+
+	pods, err := ctl.listProbePods(ctx, check)
+	if err != nil {
+		log.Error(err, "failed to list probe pods")
+		return reconcile.Result{}, err
+	}
+
+	elapsed := time.Since(check.Status.StartTime.Time)
+
+	created := false
+	for _, node := range check.Spec.Nodes {
+		if _, ok := pods.servers[node]; ok {
+			continue
+		}
+
+		args := []string{"--check-role=" + roleServer, fmt.Sprintf("--check-port=%d", getPort(check))}
+		if err = ctl.createProbePod(ctx, check, node, roleServer, args); err != nil {
+			log.Error(err, "failed to create server probe pod", "nodeName", node)
+			return reconcile.Result{}, err
+		}
+		created = true
+	}
+	if created {
+		return reconcile.Result{RequeueAfter: checkInterval}, nil
+	}
+
+	// tests start when all servers are running or some nodes can't run them in time
+	addresses := make(map[string]string)
+	for _, node := range check.Spec.Nodes {
+		if pod := pods.servers[node]; isServerRunning(pod) {
+			addresses[node] = pod.Status.PodIP
+		}
+	}
+	if len(addresses) < len(check.Spec.Nodes) && elapsed < podStartTimeout {
+		log.V(5).Info("wait for server probe pods to run")
+		return reconcile.Result{RequeueAfter: checkInterval}, nil
+	}
+
+	for _, node := range check.Spec.Nodes {
+		if _, ok := addresses[node]; !ok {
+			continue
+		}
+		if _, ok := pods.clients[node]; ok {
+			continue
+		}
+
+		if err = ctl.createProbePod(ctx, check, node, roleClient, buildClientArgs(check, node, addresses)); err != nil {
+			log.Error(err, "failed to create client probe pod", "nodeName", node)
+			return reconcile.Result{}, err
+		}
+		created = true
+	}
+	if created {
+		return reconcile.Result{RequeueAfter: checkInterval}, nil
+	}
+
+	finished := true
+	for node := range addresses {
+		if !isClientFinished(pods.clients[node]) {
+			finished = false
+		}
+	}
+	if !finished && elapsed < 2*podStartTimeout {
+		log.V(5).Info("wait for client probe pods to finish")
+		return reconcile.Result{RequeueAfter: checkInterval}, nil
+	}
+
+	results := collectResults(check, pods)
+	failed := 0
+	for _, r := range results {
+		if !r.Succeeded {
+			failed++
+		}
+	}
+
+	now := metav1.Now()
+	check.Status.Phase = apis.ConnectivityCheckCompleted
+	check.Status.CompletionTime = &now
+	check.Status.Results = results
+	check.Status.Message = fmt.Sprintf("%d of %d tests failed", failed, len(results))
+	if err = ctl.client.Status().Update(ctx, &check); err != nil {
+		log.Error(err, "failed to update status of connectivity check")
+		return reconcile.Result{}, err
+	}
+
+	log.V(3).Info("connectivity check is completed", "message", check.Status.Message)
+	ctl.deleteProbePods(ctx, log, pods)
+
+	return reconcile.Result{}, nil
+}
+
+func (ctl *checkController) listProbePods(ctx context.Context, check apis.ConnectivityCheck) (probePods, error) {
+	pods := probePods{
+		servers: make(map[string]corev1.Pod),
+		clients: make(map[string]corev1.Pod),
+	}
+
+	var podList corev1.PodList
+	err := ctl.client.List(ctx, &podList, client.InNamespace(ctl.Namespace), client.MatchingLabels{
+		constants.KeyConnectivityCheck: string(check.UID),
+	})
+	if err != nil {
+		return pods, err
+	}
+
+	for _, pod := range podList.Items {
+		switch pod.Labels[constants.KeyCheckRole] {
+		case roleServer:
+			pods.servers[pod.Spec.NodeName] = pod
+		case roleClient:
+			pods.clients[pod.Spec.NodeName] = pod
+		}
+	}
+
+	return pods, nil
+}
+
+func (ctl *checkController) createProbePod(ctx context.Context, check apis.ConnectivityCheck, nodeName, role string, args []string) error {
+	automountServiceAccountToken := false
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("fabedge-check-%s-%s-%s", check.Name, nodeName, role),
+			Namespace: ctl.Namespace,
+			Labels: map[string]string{
+				constants.KeyFabedgeAPP:        constants.AppConnectivityCheck,
+				constants.KeyCreatedBy:         constants.AppOperator,
+				constants.KeyConnectivityCheck: string(check.UID),
+				constants.KeyCheckRole:         role,
+			},
+		},
+		Spec: corev1.PodSpec{
+			AutomountServiceAccountToken: &automountServiceAccountToken,
+			NodeName:                     nodeName,
+			RestartPolicy:                corev1.RestartPolicyNever,
+			Tolerations: []corev1.Toleration{
+				{
+					Key:      "",
+					Operator: corev1.TolerationOpExists,
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:            "probe",
+					Image:           ctl.Image,
+					ImagePullPolicy: ctl.ImagePullPolicy,
+					Args:            args,
+					// ICMP tests need raw sockets
+					SecurityContext: &corev1.SecurityContext{
+						Capabilities: &corev1.Capabilities{
+							Add: []corev1.Capability{"NET_RAW"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(&check, pod, scheme.Scheme); err != nil {
+		return err
+	}
+
+	err := ctl.client.Create(ctx, pod)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func (ctl *checkController) deleteProbePods(ctx context.Context, log logr.Logger, pods probePods) {
+	for _, group := range []map[string]corev1.Pod{pods.servers, pods.clients} {
+		for _, pod := range group {
+			pod := pod
+			if err := ctl.client.Delete(ctx, &pod); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "failed to delete probe pod", "name", pod.Name)
+			}
+		}
+	}
+}
+
+func buildClientArgs(check apis.ConnectivityCheck, nodeName string, addresses map[string]string) []string {
+	var targets []string
+	for _, node := range check.Spec.Nodes {
+		if address, ok := addresses[node]; ok && node != nodeName {
+			targets = append(targets, fmt.Sprintf("%s=%s", node, address))
+		}
+	}
+
+	var protocols []string
+	for _, protocol := range getProtocols(check) {
+		protocols = append(protocols, string(protocol))
+	}
+
+	return []string{
+		"--check-role=" + roleClient,
+		fmt.Sprintf("--check-port=%d", getPort(check)),
+		fmt.Sprintf("--check-timeout=%s", getTimeout(check)),
+		"--check-protocols=" + strings.Join(protocols, ","),
+		"--check-targets=" + strings.Join(targets, ","),
+	}
+}
+
+// collectResults makes a result for each pair of nodes and each protocol, tests which
+// are not run because of probe pods are failed with the reasons
+func collectResults(check apis.ConnectivityCheck, pods probePods) []apis.CheckResult {
+	var results []apis.CheckResult
+	for _, from := range check.Spec.Nodes {
+		reported, clientErr := getClientResults(from, pods)
+
+		for _, to := range check.Spec.Nodes {
+			if from == to {
+				continue
+			}
+
+			for _, protocol := range getProtocols(check) {
+				result := apis.CheckResult{From: from, To: to, Protocol: protocol}
+
+				switch {
+				case !isServerRunning(pods.servers[from]):
+					result.Message = fmt.Sprintf("probe pod is not running on %s: %s", from, describePod(pods.servers[from]))
+				case !isServerRunning(pods.servers[to]):
+					result.Message = fmt.Sprintf("probe pod is not running on %s: %s", to, describePod(pods.servers[to]))
+				case clientErr != nil:
+					result.Message = clientErr.Error()
+				default:
+					result.Message = "no result is reported"
+					for _, r := range reported {
+						if r.To == to && r.Protocol == protocol {
+							result = r
+							break
+						}
+					}
+				}
+
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results
+}
+
+func getClientResults(nodeName string, pods probePods) ([]apis.CheckResult, error) {
+	pod, ok := pods.clients[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("probe pod on %s is not created", nodeName)
+	}
+
+	if !isClientFinished(pod) {
+		return nil, fmt.Errorf("probe pod on %s didn't finish in time: %s", nodeName, describePod(pod))
+	}
+
+	terminated := getTerminatedState(pod)
+	if pod.Status.Phase == corev1.PodFailed || terminated == nil {
+		return nil, fmt.Errorf("probe pod on %s failed: %s", nodeName, describePod(pod))
+	}
+
+	return probe.ParseCheckResults(nodeName, terminated.Message)
+}
+
+func isServerRunning(pod corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != ""
+}
+
+func isClientFinished(pod corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func getTerminatedState(pod corev1.Pod) *corev1.ContainerStateTerminated {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			return status.State.Terminated
+		}
+	}
+	return nil
+}
+
+// describePod tells the phase of pod and why its container is not running, it helps
+// to find out problems like images can't be pulled
+func describePod(pod corev1.Pod) string {
+	if pod.Name == "" {
+		return "pod is not created"
+	}
+
+	desc := string(pod.Status.Phase)
+	for _, status := range pod.Status.ContainerStatuses {
+		switch {
+		case status.State.Waiting != nil:
+			desc = fmt.Sprintf("%s, %s %s", desc, status.State.Waiting.Reason, status.State.Waiting.Message)
+		case status.State.Terminated != nil && status.State.Terminated.ExitCode != 0:
+			desc = fmt.Sprintf("%s, %s with exit code %d", desc, status.State.Terminated.Reason, status.State.Terminated.ExitCode)
+		}
+	}
+
+	return strings.TrimSpace(desc)
+}
+
+func getPort(check apis.ConnectivityCheck) int32 {
+	if check.Spec.Port > 0 {
+		return check.Spec.Port
+	}
+	return defaultPort
+}
+
+func getTimeout(check apis.ConnectivityCheck) time.Duration {
+	if check.Spec.Timeout != nil && check.Spec.Timeout.Duration > 0 {
+		return check.Spec.Timeout.Duration
+	}
+	return defaultTimeout
+}
+
+func getProtocols(check apis.ConnectivityCheck) []apis.CheckProtocol {
+	if len(check.Spec.Protocols) > 0 {
+		return check.Spec.Protocols
+	}
+	return allProtocols
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivitycheck
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	testutil "github.com/fabedge/fabedge/pkg/util/test"
+)
+
+var _ = Describe("ConnectivityCheckController", func() {
+	var (
+		ctl       *checkController
+		ctx       = context.Background()
+		namespace = "default"
+		key       = client.ObjectKey{Name: "check"}
+	)
+
+	BeforeEach(func() {
+		ctl = &checkController{
+			Config: Config{
+				Namespace:       namespace,
+				Image:           "fabedge/agent:latest",
+				ImagePullPolicy: corev1.PullIfNotPresent,
+			},
+			client: k8sClient,
+			log:    klogr.New(),
+		}
+
+		check := apis.ConnectivityCheck{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name},
+			Spec: apis.ConnectivityCheckSpec{
+				Nodes:     []string{"edge1", "edge2"},
+				Protocols: []apis.CheckProtocol{apis.CheckProtocolTCP},
+			},
+		}
+		Expect(k8sClient.Create(ctx, &check)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.DeleteAllOf(ctx, &apis.ConnectivityCheck{})).Should(Succeed())
+		Expect(testutil.PurgeAllPods(k8sClient, client.InNamespace(namespace))).Should(Succeed())
+	})
+
+	reconcileAndGet := func() (reconcile.Result, apis.ConnectivityCheck) {
+		result, err := ctl.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var check apis.ConnectivityCheck
+		Expect(k8sClient.Get(ctx, key, &check)).Should(Succeed())
+		return result, check
+	}
+
+	getPod := func(nodeName, role string) corev1.Pod {
+		var pod corev1.Pod
+		podKey := client.ObjectKey{Namespace: namespace, Name: "fabedge-check-check-" + nodeName + "-" + role}
+		Expect(k8sClient.Get(ctx, podKey, &pod)).Should(Succeed())
+		return pod
+	}
+
+	updatePodStatus := func(pod corev1.Pod, phase corev1.PodPhase, podIP string, terminated *corev1.ContainerStateTerminated) {
+		pod.Status.Phase = phase
+		pod.Status.PodIP = podIP
+		if terminated != nil {
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{
+					Name:  "probe",
+					Image: pod.Spec.Containers[0].Image,
+					State: corev1.ContainerState{Terminated: terminated},
+				},
+			}
+		}
+		Expect(k8sClient.Status().Update(ctx, &pod)).Should(Succeed())
+	}
+
+	It("should run probe pods and report results", func() {
+		By("creating server probe pods")
+		result, check := reconcileAndGet()
+		Expect(result.RequeueAfter).To(Equal(checkInterval))
+		Expect(check.Status.Phase).To(Equal(apis.ConnectivityCheckRunning))
+		Expect(check.Status.StartTime).NotTo(BeNil())
+
+		server1, server2 := getPod("edge1", roleServer), getPod("edge2", roleServer)
+		Expect(server1.Spec.NodeName).To(Equal("edge1"))
+		Expect(server1.Labels[constants.KeyConnectivityCheck]).To(Equal(string(check.UID)))
+		Expect(server1.Spec.Containers[0].Args).To(ConsistOf("--check-role=server", "--check-port=8790"))
+		Expect(metav1.IsControlledBy(&server1, &check)).To(BeTrue())
+
+		By("waiting for server probe pods to run")
+		result, _ = reconcileAndGet()
+		Expect(result.RequeueAfter).To(Equal(checkInterval))
+
+		var pods corev1.PodList
+		Expect(k8sClient.List(ctx, &pods, client.InNamespace(namespace))).Should(Succeed())
+		Expect(pods.Items).To(HaveLen(2))
+
+		By("creating client probe pods when servers are running")
+		updatePodStatus(server1, corev1.PodRunning, "10.0.0.1", nil)
+		updatePodStatus(server2, corev1.PodRunning, "10.0.0.2", nil)
+		result, _ = reconcileAndGet()
+		Expect(result.RequeueAfter).To(Equal(checkInterval))
+
+		client1 := getPod("edge1", roleClient)
+		Expect(client1.Spec.Containers[0].Args).To(ContainElements("--check-targets=edge2=10.0.0.2", "--check-protocols=TCP", "--check-timeout=3s"))
+		client2 := getPod("edge2", roleClient)
+		Expect(client2.Spec.Containers[0].Args).To(ContainElements("--check-targets=edge1=10.0.0.1"))
+
+		By("collecting results when clients are finished")
+		updatePodStatus(client1, corev1.PodSucceeded, "10.0.0.3", &corev1.ContainerStateTerminated{
+			Message: "edge2 TCP 2ms",
+		})
+		updatePodStatus(client2, corev1.PodSucceeded, "10.0.0.4", &corev1.ContainerStateTerminated{
+			Message: "edge1 TCP - i/o timeout",
+		})
+		result, check = reconcileAndGet()
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(check.Status.Phase).To(Equal(apis.ConnectivityCheckCompleted))
+		Expect(check.Status.CompletionTime).NotTo(BeNil())
+		Expect(check.Status.Message).To(Equal("1 of 2 tests failed"))
+		Expect(check.Status.Results).To(HaveLen(2))
+
+		Expect(check.Status.Results[0].From).To(Equal("edge1"))
+		Expect(check.Status.Results[0].To).To(Equal("edge2"))
+		Expect(check.Status.Results[0].Succeeded).To(BeTrue())
+		Expect(check.Status.Results[0].RTT.Duration.String()).To(Equal("2ms"))
+
+		Expect(check.Status.Results[1].From).To(Equal("edge2"))
+		Expect(check.Status.Results[1].Succeeded).To(BeFalse())
+		Expect(check.Status.Results[1].Message).To(Equal("i/o timeout"))
+
+		By("removing probe pods")
+		Expect(k8sClient.List(ctx, &pods, client.InNamespace(namespace))).Should(Succeed())
+		for _, pod := range pods.Items {
+			Expect(pod.DeletionTimestamp).NotTo(BeNil())
+		}
+	})
+
+	It("should report failures of nodes which can't run probe pods", func() {
+		check := apis.ConnectivityCheck{
+			Spec: apis.ConnectivityCheckSpec{
+				Nodes:     []string{"edge1", "edge2"},
+				Protocols: []apis.CheckProtocol{apis.CheckProtocolTCP, apis.CheckProtocolICMP},
+			},
+		}
+		pods := probePods{
+			servers: map[string]corev1.Pod{
+				"edge1": {
+					ObjectMeta: metav1.ObjectMeta{Name: "server1"},
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+				},
+				"edge2": {
+					ObjectMeta: metav1.ObjectMeta{Name: "server2"},
+					Status: corev1.PodStatus{
+						Phase: corev1.PodPending,
+						ContainerStatuses: []corev1.ContainerStatus{
+							{
+								State: corev1.ContainerState{
+									Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "not found"},
+								},
+							},
+						},
+					},
+				},
+			},
+			clients: map[string]corev1.Pod{},
+		}
+
+		results := collectResults(check, pods)
+		Expect(results).To(HaveLen(4))
+		for _, r := range results {
+			Expect(r.Succeeded).To(BeFalse())
+			Expect(r.Message).To(Equal("probe pod is not running on edge2: Pending, ErrImagePull not found"))
+		}
+	})
+})
@@ -16,13 +16,17 @@ package connector
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -41,12 +45,16 @@ import (
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
 	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 const (
 	controllerName = "connector-controller"
 )
 
+// applyOptions are used to apply generated objects, labels or annotations added by users are kept
+var applyOptions = []client.PatchOption{client.FieldOwner(constants.FieldManager), client.ForceOwnership}
+
 type Node struct {
 	Name     string
 	IP       string
@@ -54,6 +62,9 @@ type Node struct {
 }
 
 type Config struct {
+	// Name is used to distinguish connectors in the same cluster,
+	// the default connector's name is empty
+	Name            string
 	Namespace       string
 	Endpoint        apis.Endpoint
 	ProvidedSubnets []string
@@ -62,10 +73,33 @@ type Config struct {
 	ConnectorLabels map[string]string
 
 	CertOrganization string
-	SyncInterval     time.Duration
-
-	Store   storepkg.Interface
-	Manager manager.Manager
+	CertKeyType      certutil.KeyType
+	// CertKeySize is the size of connector's key, see certutil.NewPrivateKey
+	CertKeySize int
+	// CertSignatureAlgorithm is the algorithm to sign certificate request of connector
+	CertSignatureAlgorithm x509.SignatureAlgorithm
+	SyncInterval           time.Duration
+
+	MaxConcurrentReconciles int
+
+	Store      storepkg.Interface
+	Assignment types.ConnectorAssignment
+	Manager    manager.Manager
+
+	// MemberlistPeers are identities allowed to join memberlist of connectors and cloud agents,
+	// see certutil.IdentityOf. If it's not empty, a configmap of them and a TLS secret of cloud
+	// agents whose certificate is made for CloudAgentName and CloudAgentID are maintained
+	MemberlistPeers []string
+	CloudAgentName  string
+	CloudAgentID    string
+
+	// ConfigResource makes controller maintain a ConnectorConfig of the same name as the
+	// configmap, so connector can get its config through API instead of the configmap file
+	ConfigResource bool
+
+	// IsTearingDown tells whether operator is removing everything fabedge made,
+	// connector config and TLS secret are not maintained when it returns true
+	IsTearingDown func() bool
 }
 
 // controller generate tunnels config for connector and
@@ -94,8 +128,8 @@ func AddToManager(cnf Config) (types.EndpointGetter, error) {
 		nodeNameSet: sets.NewString(),
 		nodeCache:   make(map[string]Node),
 		client:      mgr.GetClient(),
-		log:         mgr.GetLogger().WithName(controllerName),
 	}
+	ctl.log = mgr.GetLogger().WithName(ctl.getControllerName())
 
 	err := ctl.initializeConnectorEndpoint()
 	if err != nil {
@@ -108,10 +142,11 @@ func AddToManager(cnf Config) (types.EndpointGetter, error) {
 	}
 
 	c, err := controllerpkg.New(
-		controllerName,
+		ctl.getControllerName(),
 		mgr,
 		controllerpkg.Options{
-			Reconciler: reconcile.Func(ctl.onNodeRequest),
+			MaxConcurrentReconciles: cnf.MaxConcurrentReconciles,
+			Reconciler:              tracing.WrapReconciler(ctl.getControllerName(), reconcile.Func(ctl.onNodeRequest)),
 		},
 	)
 	if err != nil {
@@ -139,16 +174,37 @@ func (ctl *controller) SyncConnectorConfig(ctx context.Context) error {
 }
 
 func (ctl *controller) operateConnector() {
-	ctl.updateConfigMapIfNeeded()
-	generated := ctl.generateCertIfNeeded()
+	if ctl.IsTearingDown != nil && ctl.IsTearingDown() {
+		ctl.log.V(5).Info("operator is tearing down, skip operating connector")
+		return
+	}
+
+	peers := ctl.getPeers()
+	conf := netconf.NetworkConf{
+		Endpoint:  ctl.getConnectorEndpoint(),
+		Peers:     peers,
+		DSCPRules: ctl.getDSCPRules(peers),
+	}
+	ctl.updateConfigMapIfNeeded(conf)
+	if ctl.ConfigResource {
+		ctl.updateConnectorConfigIfNeeded(conf)
+	}
+
+	generated := ctl.generateCertIfNeeded(ctl.getTLSSecretName(), ctl.Endpoint.Name, ctl.Endpoint.ID)
 	if generated {
 		ctl.restartConnectorPods()
 	}
+
+	if len(ctl.MemberlistPeers) > 0 {
+		ctl.syncMemberlistPeers()
+		// cloud agents reload their certificates periodically, no restart is needed
+		ctl.generateCertIfNeeded(constants.CloudAgentTLSName, ctl.CloudAgentName, ctl.CloudAgentID)
+	}
 }
 
-func (ctl *controller) updateConfigMapIfNeeded() {
+func (ctl *controller) updateConfigMapIfNeeded(conf netconf.NetworkConf) {
 	key := client.ObjectKey{
-		Name:      constants.ConnectorConfigName,
+		Name:      ctl.getConfigMapName(),
 		Namespace: ctl.Namespace,
 	}
 	log := ctl.log.WithValues("key", key)
@@ -156,12 +212,6 @@ func (ctl *controller) updateConfigMapIfNeeded() {
 	ctx, cancel := context.WithTimeout(context.Background(), ctl.SyncInterval)
 	defer cancel()
 
-	connectorEndpoint := ctl.getConnectorEndpoint()
-	conf := netconf.NetworkConf{
-		Endpoint: connectorEndpoint,
-		Peers:    ctl.getPeers(),
-	}
-
 	confBytes, err := yaml.Marshal(conf)
 	if err != nil {
 		log.Error(err, "failed to marshal connector tunnels conf")
@@ -177,39 +227,88 @@ func (ctl *controller) updateConfigMapIfNeeded() {
 		return
 	}
 
-	if errors.IsNotFound(err) {
-		log.V(5).Info("connector config is not found, create it now")
+	if err == nil && cm.Data[constants.ConnectorConfigFileName] == configData &&
+		cm.Labels[constants.KeyCreatedBy] == constants.AppOperator {
+		log.V(5).Info("node endpoints are not changed, skip updating")
+		return
+	}
 
-		cm = corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      key.Name,
-				Namespace: key.Namespace,
-			},
-			Data: map[string]string{
-				constants.ConnectorConfigFileName: configData,
+	log.V(5).Info("connector tunnels are changed, apply it now")
+	cm = corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
 			},
-		}
-		if err = ctl.client.Create(ctx, &cm); err != nil {
-			log.Error(err, "failed to create connector configmap")
-		}
+		},
+		Data: map[string]string{
+			constants.ConnectorConfigFileName: configData,
+		},
+	}
+	if err = ctl.client.Patch(ctx, &cm, client.Apply, applyOptions...); err != nil {
+		log.Error(err, "failed to apply connector configmap")
+	}
+}
+
+// updateConnectorConfigIfNeeded applies conf as the spec of ConnectorConfig, status is left to connector
+func (ctl *controller) updateConnectorConfigIfNeeded(conf netconf.NetworkConf) {
+	key := client.ObjectKey{
+		Name:      ctl.getConfigMapName(),
+		Namespace: ctl.Namespace,
+	}
+	log := ctl.log.WithValues("key", key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctl.SyncInterval)
+	defer cancel()
+
+	spec := apis.ConnectorConfigSpec{
+		Endpoint:  conf.Endpoint,
+		Peers:     conf.Peers,
+		DSCPRules: conf.DSCPRules,
+	}
+
+	var config apis.ConnectorConfig
+	err := ctl.client.Get(ctx, key, &config)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to get connector config")
 		return
 	}
 
-	if cm.Data[constants.ConnectorConfigFileName] == configData {
-		log.V(5).Info("node endpoints are not changed, skip updating")
+	if err == nil && equality.Semantic.DeepEqual(config.Spec, spec) &&
+		config.Labels[constants.KeyCreatedBy] == constants.AppOperator {
 		return
 	}
 
-	log.V(5).Info("connector tunnels are changed, update it now")
-	cm.Data[constants.ConnectorConfigFileName] = configData
-	if err = ctl.client.Update(ctx, &cm); err != nil {
-		log.Error(err, "failed to update connector configmap")
+	log.V(5).Info("connector config is changed, apply it now")
+	config = apis.ConnectorConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apis.SchemeGroupVersion.String(),
+			Kind:       "ConnectorConfig",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+			},
+		},
+		Spec: spec,
+	}
+	if err = ctl.client.Patch(ctx, &config, client.Apply, applyOptions...); err != nil {
+		log.Error(err, "failed to apply connector config")
 	}
 }
 
-func (ctl *controller) generateCertIfNeeded() bool {
+// generateCertIfNeeded makes sure secret of secretName has a valid certificate of name and id,
+// it returns true if the certificate is generated
+func (ctl *controller) generateCertIfNeeded(secretName, name, id string) bool {
 	key := client.ObjectKey{
-		Name:      constants.ConnectorTLSName,
+		Name:      secretName,
 		Namespace: ctl.Namespace,
 	}
 	log := ctl.log.WithValues("key", key)
@@ -225,14 +324,14 @@ func (ctl *controller) generateCertIfNeeded() bool {
 			return false
 		}
 
-		log.V(5).Info("TLS secret for connector is not found, generate it now")
-		secret, err = ctl.buildCertAndKeySecret(key)
+		log.V(5).Info("TLS secret is not found, generate it now")
+		secret, err = ctl.buildCertAndKeySecret(key, name, id)
 		if err != nil {
-			log.Error(err, "failed to create cert and key for connector")
+			log.Error(err, "failed to create cert and key")
 			return false
 		}
 
-		err = ctl.client.Create(ctx, &secret)
+		err = ctl.client.Patch(ctx, &secret, client.Apply, applyOptions...)
 		if err != nil {
 			log.Error(err, "failed to create secret")
 			return false
@@ -243,19 +342,37 @@ func (ctl *controller) generateCertIfNeeded() bool {
 
 	certPEM := secretutil.GetCert(secret)
 	err = ctl.CertManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient)
+	if err == nil {
+		// cert is reissued when SPIFFE IDs are enabled or disabled
+		err = certutil.VerifySPIFFEIDInPEM(certPEM, id)
+	}
 	if err == nil {
 		log.V(5).Info("cert is verified")
-		return false
+
+		// secrets made by old versions have no CA bundle, it's added without restarting pods
+		if len(secretutil.GetCABundle(secret)) == 0 {
+			secret.Data[secretutil.KeyCABundle] = secretutil.GetCACert(secret)
+			if err = ctl.client.Update(ctx, &secret); err != nil {
+				log.Error(err, "failed to add CA bundle to secret")
+				return false
+			}
+		}
+
+		if secretutil.IsCAUpToDate(secret, ctl.CertManager) {
+			return false
+		}
+		log.V(3).Info("CA is being rotated, reissue a cert")
+	} else {
+		log.Error(err, "failed to verify cert, need to regenerate a cert")
 	}
 
-	log.Error(err, "failed to verify cert, need to regenerate a cert for connector")
-	secret, err = ctl.buildCertAndKeySecret(key)
+	secret, err = ctl.buildCertAndKeySecret(key, name, id)
 	if err != nil {
-		log.Error(err, "failed to recreate cert and key for connector")
+		log.Error(err, "failed to recreate cert and key")
 		return false
 	}
 
-	err = ctl.client.Update(ctx, &secret)
+	err = ctl.client.Patch(ctx, &secret, client.Apply, applyOptions...)
 	if err != nil {
 		log.Error(err, "failed to save secret")
 		return false
@@ -264,6 +381,54 @@ func (ctl *controller) generateCertIfNeeded() bool {
 	return true
 }
 
+// syncMemberlistPeers saves MemberlistPeers into configmap, connectors and cloud agents mount it
+// as the file of allowed peers
+func (ctl *controller) syncMemberlistPeers() {
+	key := client.ObjectKey{
+		Name:      constants.MemberlistPeersName,
+		Namespace: ctl.Namespace,
+	}
+	log := ctl.log.WithValues("key", key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctl.SyncInterval)
+	defer cancel()
+
+	peers := strings.Join(ctl.MemberlistPeers, "\n") + "\n"
+
+	var cm corev1.ConfigMap
+	err := ctl.client.Get(ctx, key, &cm)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to get memberlist peers configmap")
+		return
+	}
+
+	if err == nil && cm.Data[constants.MemberlistPeersFileName] == peers &&
+		cm.Labels[constants.KeyCreatedBy] == constants.AppOperator {
+		return
+	}
+
+	log.V(3).Info("memberlist peers are changed, apply them now", "peers", ctl.MemberlistPeers)
+	cm = corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+			},
+		},
+		Data: map[string]string{
+			constants.MemberlistPeersFileName: peers,
+		},
+	}
+	if err = ctl.client.Patch(ctx, &cm, client.Apply, applyOptions...); err != nil {
+		log.Error(err, "failed to apply memberlist peers configmap")
+	}
+}
+
 func (ctl *controller) restartConnectorPods() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -275,16 +440,25 @@ func (ctl *controller) restartConnectorPods() {
 	}
 
 	for _, pod := range podList.Items {
+		// pods of other connectors may match default connector's labels too
+		if pod.Labels[constants.KeyConnector] != ctl.Name {
+			continue
+		}
+
 		if err := ctl.client.Delete(ctx, &pod); err != nil {
 			ctl.log.Error(err, "failed to delete connector")
 		}
 	}
 }
 
-func (ctl *controller) buildCertAndKeySecret(key client.ObjectKey) (corev1.Secret, error) {
+func (ctl *controller) buildCertAndKeySecret(key client.ObjectKey, name, id string) (corev1.Secret, error) {
 	keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
-		CommonName:   ctl.Endpoint.Name,
-		Organization: []string{ctl.CertOrganization},
+		CommonName:         name,
+		Organization:       []string{ctl.CertOrganization},
+		URIs:               certutil.URIsOfID(id),
+		KeyType:            ctl.CertKeyType,
+		KeySize:            ctl.CertKeySize,
+		SignatureAlgorithm: ctl.CertSignatureAlgorithm,
 	})
 	if err != nil {
 		return corev1.Secret{}, err
@@ -301,13 +475,25 @@ func (ctl *controller) buildCertAndKeySecret(key client.ObjectKey) (corev1.Secre
 		EncodeCert(certDER).
 		EncodeKey(keyDER).
 		CACertPEM(ctl.CertManager.GetCACertPEM()).
+		CABundlePEM(ctl.CertManager.GetCABundlePEM()).
 		Label(constants.KeyCreatedBy, constants.AppOperator).Build(), nil
 }
 
 func (ctl *controller) getPeers() []apis.Endpoint {
 	connectorName := ctl.Endpoint.Name
 
-	nameSet := ctl.Store.GetLocalEndpointNames()
+	nameSet := sets.NewString()
+	for _, ep := range ctl.Store.GetEndpoints(ctl.Store.GetLocalEndpointNames().List()...) {
+		// connectors in the same cluster share the same cloud subnets, no tunnel is needed between them
+		if ep.Type == apis.Connector {
+			continue
+		}
+
+		if ctl.isAssignedToMe(ep.Name) {
+			nameSet.Insert(ep.Name)
+		}
+	}
+
 	for _, community := range ctl.Store.GetCommunitiesByEndpoint(connectorName) {
 		for name := range community.Members {
 			nameSet.Insert(name)
@@ -325,6 +511,68 @@ func (ctl *controller) getPeers() []apis.Endpoint {
 	return peers
 }
 
+// getDSCPRules makes a rule for each community with DSCP, the rule covers subnets of members
+// which are peers of the connector, a community without such members has no rule
+func (ctl *controller) getDSCPRules(peers []apis.Endpoint) []apis.DSCPRule {
+	peerSet := make(map[string]apis.Endpoint, len(peers))
+	for _, peer := range peers {
+		peerSet[peer.Name] = peer
+	}
+
+	var rules []apis.DSCPRule
+	for _, name := range ctl.Store.GetAllCommunityNames().List() {
+		community, ok := ctl.Store.GetCommunity(name)
+		if !ok || community.DSCP == 0 {
+			continue
+		}
+
+		var cidrs []string
+		for _, member := range community.Members.List() {
+			peer, ok := peerSet[member]
+			if !ok {
+				continue
+			}
+
+			for _, subnet := range append(append([]string{}, peer.Subnets...), peer.NodeSubnets...) {
+				cidrs = append(cidrs, toCIDR(subnet))
+			}
+		}
+
+		if len(cidrs) == 0 {
+			continue
+		}
+
+		rules = append(rules, apis.DSCPRule{
+			Name:  community.Name,
+			DSCP:  community.DSCP,
+			CIDRs: cidrs,
+		})
+	}
+
+	return rules
+}
+
+// toCIDR turns a single IP, e.g. a node subnet, into a CIDR
+func toCIDR(subnet string) string {
+	ip := net.ParseIP(subnet)
+	if ip == nil {
+		return subnet
+	}
+
+	if ip.To4() != nil {
+		return subnet + "/32"
+	}
+	return subnet + "/128"
+}
+
+func (ctl *controller) isAssignedToMe(endpointName string) bool {
+	if ctl.Assignment == nil {
+		return true
+	}
+
+	return ctl.Assignment.GetConnectorName(endpointName) == ctl.Name
+}
+
 func (ctl *controller) onNodeRequest(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	log := ctl.log.WithValues("request", request)
 
@@ -428,6 +676,30 @@ func (ctl *controller) rebuildConnectorEndpoint() {
 	ctl.Store.SaveEndpointAsLocal(ctl.Endpoint)
 }
 
+func (ctl *controller) getControllerName() string {
+	if ctl.Name == "" {
+		return controllerName
+	}
+
+	return fmt.Sprintf("%s-%s", controllerName, ctl.Name)
+}
+
+func (ctl *controller) getConfigMapName() string {
+	if ctl.Name == "" {
+		return constants.ConnectorConfigName
+	}
+
+	return fmt.Sprintf("%s-%s", constants.ConnectorConfigName, ctl.Name)
+}
+
+func (ctl *controller) getTLSSecretName() string {
+	if ctl.Name == "" {
+		return constants.ConnectorTLSName
+	}
+
+	return fmt.Sprintf("%s-%s", constants.ConnectorTLSName, ctl.Name)
+}
+
 func (ctl *controller) getConnectorEndpoint() apis.Endpoint {
 	ctl.mux.RLock()
 	defer ctl.mux.RUnlock()
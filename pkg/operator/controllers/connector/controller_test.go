@@ -247,6 +247,7 @@ var _ = Describe("Controller", func() {
 		caCertPEM, certPEM := secretutil.GetCACert(secret), secretutil.GetCert(secret)
 		Expect(certManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
 		Expect(caCertPEM).Should(Equal(certManager.GetCACertPEM()))
+		Expect(secretutil.GetCABundle(secret)).Should(Equal(certManager.GetCABundlePEM()))
 
 		certDER, err := certutil.DecodePEM(certPEM)
 		Expect(err).Should(BeNil())
@@ -299,6 +300,178 @@ var _ = Describe("Controller", func() {
 
 		expectConnectorDeleted(connectorPod, 2*interval)
 	})
+
+	It("should maintain memberlist peers and TLS secret of cloud agents if memberlist peers are provided", func() {
+		cfg := config
+		cfg.MemberlistPeers = []string{"cloud-connector", "cloud-agent"}
+		cfg.CloudAgentName, cfg.CloudAgentID = "cloud-agent", "cloud-agent"
+		ctl := &controller{
+			Config: cfg,
+			client: k8sClient,
+			log:    cfg.Manager.GetLogger(),
+		}
+
+		ctl.syncMemberlistPeers()
+		Expect(ctl.generateCertIfNeeded(constants.CloudAgentTLSName, cfg.CloudAgentName, cfg.CloudAgentID)).Should(BeTrue())
+
+		var cm corev1.ConfigMap
+		key := client.ObjectKey{Name: constants.MemberlistPeersName, Namespace: namespace}
+		Expect(k8sClient.Get(context.Background(), key, &cm)).Should(Succeed())
+		Expect(cm.Data[constants.MemberlistPeersFileName]).Should(Equal("cloud-connector\ncloud-agent\n"))
+
+		var secret corev1.Secret
+		key = client.ObjectKey{Name: constants.CloudAgentTLSName, Namespace: namespace}
+		Expect(k8sClient.Get(context.Background(), key, &secret)).Should(Succeed())
+
+		certPEM := secretutil.GetCert(secret)
+		Expect(certManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+
+		certDER, err := certutil.DecodePEM(certPEM)
+		Expect(err).Should(BeNil())
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).Should(BeNil())
+		Expect(certutil.GetIdentity(cert)).Should(Equal("cloud-agent"))
+
+		By("checking a valid certificate is kept")
+		Expect(ctl.generateCertIfNeeded(constants.CloudAgentTLSName, cfg.CloudAgentName, cfg.CloudAgentID)).Should(BeFalse())
+	})
+})
+
+var _ = Describe("ControllerWithAssignment", func() {
+	It("should only take edge endpoints assigned to it as peers", func() {
+		store := storepkg.NewStore()
+		assignment := types.NewConnectorAssignment()
+
+		edge1 := apis.Endpoint{Name: "edge1", Type: apis.EdgeNode}
+		edge2 := apis.Endpoint{Name: "edge2", Type: apis.EdgeNode}
+		eastConnector := apis.Endpoint{Name: "connector-east", Type: apis.Connector}
+		defaultConnector := apis.Endpoint{Name: "connector", Type: apis.Connector}
+
+		store.SaveEndpointAsLocal(edge1)
+		store.SaveEndpointAsLocal(edge2)
+		store.SaveEndpointAsLocal(eastConnector)
+		store.SaveEndpointAsLocal(defaultConnector)
+		assignment.Assign(edge2.Name, "east")
+
+		defaultCtl := &controller{Config: Config{Endpoint: defaultConnector, Store: store, Assignment: assignment}}
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge1))
+		Expect(defaultCtl.getConfigMapName()).Should(Equal(constants.ConnectorConfigName))
+
+		eastCtl := &controller{Config: Config{Name: "east", Endpoint: eastConnector, Store: store, Assignment: assignment}}
+		Expect(eastCtl.getPeers()).Should(ConsistOf(edge2))
+		Expect(eastCtl.getConfigMapName()).Should(Equal(constants.ConnectorConfigName + "-east"))
+		Expect(eastCtl.getTLSSecretName()).Should(Equal(constants.ConnectorTLSName + "-east"))
+	})
+
+	It("should take all edge endpoints as peers if there is no assignment", func() {
+		store := storepkg.NewStore()
+
+		edge1 := apis.Endpoint{Name: "edge1", Type: apis.EdgeNode}
+		edge2 := apis.Endpoint{Name: "edge2", Type: apis.EdgeNode}
+		eastConnector := apis.Endpoint{Name: "connector-east", Type: apis.Connector}
+		defaultConnector := apis.Endpoint{Name: "connector", Type: apis.Connector}
+
+		store.SaveEndpointAsLocal(edge1)
+		store.SaveEndpointAsLocal(edge2)
+		store.SaveEndpointAsLocal(eastConnector)
+		store.SaveEndpointAsLocal(defaultConnector)
+
+		defaultCtl := &controller{Config: Config{Endpoint: defaultConnector, Store: store}}
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge1, edge2))
+	})
+
+	It("should not take edge endpoints assigned to a connector which doesn't exist", func() {
+		store := storepkg.NewStore()
+		assignment := types.NewConnectorAssignment()
+
+		edge1 := apis.Endpoint{Name: "edge1", Type: apis.EdgeNode}
+		edge2 := apis.Endpoint{Name: "edge2", Type: apis.EdgeNode}
+		eastConnector := apis.Endpoint{Name: "connector-east", Type: apis.Connector}
+		defaultConnector := apis.Endpoint{Name: "connector", Type: apis.Connector}
+
+		store.SaveEndpointAsLocal(edge1)
+		store.SaveEndpointAsLocal(edge2)
+		store.SaveEndpointAsLocal(eastConnector)
+		store.SaveEndpointAsLocal(defaultConnector)
+		assignment.Assign(edge2.Name, "west")
+
+		defaultCtl := &controller{Config: Config{Endpoint: defaultConnector, Store: store, Assignment: assignment}}
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge1))
+
+		eastCtl := &controller{Config: Config{Name: "east", Endpoint: eastConnector, Store: store, Assignment: assignment}}
+		Expect(eastCtl.getPeers()).Should(BeEmpty())
+	})
+
+	It("should move edge endpoints between connectors when they are reassigned", func() {
+		store := storepkg.NewStore()
+		assignment := types.NewConnectorAssignment()
+
+		edge1 := apis.Endpoint{Name: "edge1", Type: apis.EdgeNode, Subnets: []string{"2.2.0.0/26"}}
+		edge2 := apis.Endpoint{Name: "edge2", Type: apis.EdgeNode, Subnets: []string{"2.2.0.64/26"}}
+		eastConnector := apis.Endpoint{Name: "connector-east", Type: apis.Connector}
+		defaultConnector := apis.Endpoint{Name: "connector", Type: apis.Connector}
+
+		store.SaveEndpointAsLocal(edge1)
+		store.SaveEndpointAsLocal(edge2)
+		store.SaveEndpointAsLocal(eastConnector)
+		store.SaveEndpointAsLocal(defaultConnector)
+		store.SaveCommunity(types.Community{Name: "telemetry", Members: sets.NewString("edge1"), DSCP: 46})
+		assignment.Assign(edge1.Name, "east")
+
+		defaultCtl := &controller{Config: Config{Endpoint: defaultConnector, Store: store, Assignment: assignment}}
+		eastCtl := &controller{Config: Config{Name: "east", Endpoint: eastConnector, Store: store, Assignment: assignment}}
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge2))
+		Expect(eastCtl.getPeers()).Should(ConsistOf(edge1))
+		Expect(defaultCtl.getDSCPRules(defaultCtl.getPeers())).Should(BeEmpty())
+		Expect(eastCtl.getDSCPRules(eastCtl.getPeers())).Should(HaveLen(1))
+
+		By("reassign edge1 to default connector and edge2 to east connector")
+		assignment.Assign(edge1.Name, "")
+		assignment.Assign(edge2.Name, "east")
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge1))
+		Expect(eastCtl.getPeers()).Should(ConsistOf(edge2))
+		Expect(defaultCtl.getDSCPRules(defaultCtl.getPeers())).Should(Equal([]apis.DSCPRule{
+			{Name: "telemetry", DSCP: 46, CIDRs: []string{"2.2.0.0/26"}},
+		}))
+		Expect(eastCtl.getDSCPRules(eastCtl.getPeers())).Should(BeEmpty())
+
+		By("unassign edge2")
+		assignment.Unassign(edge2.Name)
+		Expect(defaultCtl.getPeers()).Should(ConsistOf(edge1, edge2))
+		Expect(eastCtl.getPeers()).Should(BeEmpty())
+	})
+
+	It("should have no peers and DSCP rules if there is no edge endpoint", func() {
+		store := storepkg.NewStore()
+
+		defaultConnector := apis.Endpoint{Name: "connector", Type: apis.Connector}
+		store.SaveEndpointAsLocal(defaultConnector)
+		store.SaveEndpointAsLocal(apis.Endpoint{Name: "connector-east", Type: apis.Connector})
+		store.SaveCommunity(types.Community{Name: "telemetry", Members: sets.NewString("edge1"), DSCP: 46})
+
+		ctl := &controller{Config: Config{Endpoint: defaultConnector, Store: store, Assignment: types.NewConnectorAssignment()}}
+		peers := ctl.getPeers()
+		Expect(peers).ShouldNot(BeNil())
+		Expect(peers).Should(BeEmpty())
+		Expect(ctl.getDSCPRules(peers)).Should(BeEmpty())
+	})
+
+	It("should make DSCP rules from communities with DSCP", func() {
+		store := storepkg.NewStore()
+
+		edge1 := apis.Endpoint{Name: "edge1", Type: apis.EdgeNode, Subnets: []string{"2.2.0.0/26"}, NodeSubnets: []string{"10.20.40.181"}}
+		edge2 := apis.Endpoint{Name: "edge2", Type: apis.EdgeNode, Subnets: []string{"2.2.0.64/26"}, NodeSubnets: []string{"10.20.40.182"}}
+		store.SaveEndpointAsLocal(edge1)
+		store.SaveEndpointAsLocal(edge2)
+		store.SaveCommunity(types.Community{Name: "telemetry", Members: sets.NewString("edge1", "edge3"), DSCP: 46})
+		store.SaveCommunity(types.Community{Name: "bulk", Members: sets.NewString("edge1", "edge2")})
+		store.SaveCommunity(types.Community{Name: "others", Members: sets.NewString("edge3"), DSCP: 10})
+
+		ctl := &controller{Config: Config{Endpoint: apis.Endpoint{Name: "connector", Type: apis.Connector}, Store: store}}
+		Expect(ctl.getDSCPRules(ctl.getPeers())).Should(Equal([]apis.DSCPRule{
+			{Name: "telemetry", DSCP: 46, CIDRs: []string{"2.2.0.0/26", "10.20.40.181/32"}},
+		}))
+	})
 })
 
 func newNormalNode(ip, subnets string) corev1.Node {
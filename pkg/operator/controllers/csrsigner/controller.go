@@ -0,0 +1,237 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csrsigner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "csr-signer"
+
+	// ReasonSignerValidationFailure is the reason of Failed condition when a request can't be signed
+	ReasonSignerValidationFailure = "SignerValidationFailure"
+
+	// csrNamePrefix is the prefix of names of requests of agents, it's followed by the node name
+	csrNamePrefix = "fabedge-agent-"
+)
+
+// allowedUsages are usages which certificates of agents may have
+var allowedUsages = map[certv1.KeyUsage]bool{
+	certv1.UsageDigitalSignature: true,
+	certv1.UsageKeyEncipherment:  true,
+	certv1.UsageServerAuth:       true,
+	certv1.UsageClientAuth:       true,
+}
+
+// Config of csr signer, it signs approved CertificateSigningRequests of SignerName by CertManager,
+// the approval is left to administrators or approval controllers
+type Config struct {
+	Manager     manager.Manager
+	SignerName  string
+	CertManager certutil.Manager
+	// GetEndpointName and GetEndpointID tell the common name and SPIFFE ID which the certificate
+	// of an agent must have, the node is told by the name of request, e.g. fabedge-agent-edge1.
+	// GetEndpointID may be nil
+	GetEndpointName types.GetNameFunc
+	GetEndpointID   types.GetIDFunc
+}
+
+type csrSigner struct {
+	Config
+
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &csrSigner{
+		Config: cnf,
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctl.Watch(
+		&source.Kind{Type: &certv1.CertificateSigningRequest{}},
+		&handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			csr, ok := obj.(*certv1.CertificateSigningRequest)
+			return ok && csr.Spec.SignerName == cnf.SignerName
+		}),
+	)
+}
+
+func (ctl *csrSigner) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := ctl.log.WithValues("request", request)
+
+	var csr certv1.CertificateSigningRequest
+	if err := ctl.client.Get(ctx, request.NamespacedName, &csr); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get certificate signing request")
+		return reconcile.Result{}, err
+	}
+
+	if csr.Spec.SignerName != ctl.SignerName || len(csr.Status.Certificate) > 0 || !isApproved(csr) || isDeniedOrFailed(csr) {
+		return reconcile.Result{}, nil
+	}
+
+	certDER, err := ctl.sign(ctx, csr)
+	if err != nil {
+		log.Error(err, "failed to sign certificate signing request")
+
+		csr.Status.Conditions = append(csr.Status.Conditions, certv1.CertificateSigningRequestCondition{
+			Type:           certv1.CertificateFailed,
+			Status:         corev1.ConditionTrue,
+			Reason:         ReasonSignerValidationFailure,
+			Message:        err.Error(),
+			LastUpdateTime: metav1.Now(),
+		})
+	} else {
+		csr.Status.Certificate = certutil.EncodeCertPEM(certDER)
+	}
+
+	if err = ctl.client.Status().Update(ctx, &csr); err != nil {
+		log.Error(err, "failed to update status of certificate signing request")
+		return reconcile.Result{}, err
+	}
+
+	if len(csr.Status.Certificate) > 0 {
+		log.V(3).Info("certificate signing request is signed", "username", csr.Spec.Username)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (ctl *csrSigner) sign(ctx context.Context, csr certv1.CertificateSigningRequest) ([]byte, error) {
+	for _, usage := range csr.Spec.Usages {
+		if !allowedUsages[usage] {
+			return nil, fmt.Errorf("usage %s is not allowed", usage)
+		}
+	}
+
+	csrDER, err := certutil.DecodePEM(csr.Spec.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = req.CheckSignature(); err != nil {
+		return nil, err
+	}
+
+	if err = ctl.verifyRequest(csr.Name, req); err != nil {
+		return nil, err
+	}
+
+	_, span := tracing.Start(ctx, "SignCert", trace.WithAttributes(
+		attribute.String("purpose", "csr"),
+		attribute.String("username", csr.Spec.Username),
+	))
+	certDER, err := ctl.CertManager.SignCert(csrDER)
+	tracing.End(span, err)
+
+	return certDER, err
+}
+
+// verifyRequest checks if req asks for the certificate of the agent whose node is told by name of
+// the request, so an approved request can't get a certificate of another identity. The common name
+// must be the endpoint name of the agent, and SANs are not allowed except the SPIFFE ID of the agent
+func (ctl *csrSigner) verifyRequest(name string, req *x509.CertificateRequest) error {
+	if !strings.HasPrefix(name, csrNamePrefix) || len(name) == len(csrNamePrefix) {
+		return fmt.Errorf("name of request must be %s<node>", csrNamePrefix)
+	}
+	nodeName := strings.TrimPrefix(name, csrNamePrefix)
+
+	if expected := ctl.GetEndpointName(nodeName); req.Subject.CommonName != expected {
+		return fmt.Errorf("common name must be %s", expected)
+	}
+
+	if len(req.DNSNames) > 0 || len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 {
+		return fmt.Errorf("DNS names, IP addresses and email addresses are not allowed")
+	}
+
+	var expectedURIs []*url.URL
+	if ctl.GetEndpointID != nil {
+		expectedURIs = certutil.URIsOfID(ctl.GetEndpointID(nodeName))
+	}
+	if len(req.URIs) != len(expectedURIs) || (len(req.URIs) > 0 && req.URIs[0].String() != expectedURIs[0].String()) {
+		return fmt.Errorf("URIs are not allowed except the SPIFFE ID of agent")
+	}
+
+	return nil
+}
+
+func isApproved(csr certv1.CertificateSigningRequest) bool {
+	for _, cond := range csr.Status.Conditions {
+		if cond.Type == certv1.CertificateApproved && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDeniedOrFailed(csr certv1.CertificateSigningRequest) bool {
+	for _, cond := range csr.Status.Conditions {
+		if (cond.Type == certv1.CertificateDenied || cond.Type == certv1.CertificateFailed) && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
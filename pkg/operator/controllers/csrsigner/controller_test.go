@@ -0,0 +1,123 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package csrsigner
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	certv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("csrSigner", func() {
+	var ctl *csrSigner
+
+	BeforeEach(func() {
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: timeutil.Days(365),
+		})
+		Expect(err).Should(BeNil())
+
+		certManager, err := certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(365))
+		Expect(err).Should(BeNil())
+
+		ctl = &csrSigner{
+			Config: Config{
+				SignerName:      "fabedge.io/agent",
+				CertManager:     certManager,
+				GetEndpointName: func(nodeName string) string { return "cluster." + nodeName },
+			},
+		}
+	})
+
+	newCSR := func(name string, req certutil.Request) certv1.CertificateSigningRequest {
+		_, csrDER, err := certutil.NewCertRequest(req)
+		Expect(err).Should(BeNil())
+
+		return certv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: certv1.CertificateSigningRequestSpec{
+				Request:    certutil.EncodeCertRequestPEM(csrDER),
+				SignerName: ctl.SignerName,
+				Usages:     []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageClientAuth},
+			},
+		}
+	}
+
+	It("should sign request of agent with its endpoint name", func() {
+		certDER, err := ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{CommonName: "cluster.edge1"}))
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).Should(BeNil())
+		Expect(cert.Subject.CommonName).Should(Equal("cluster.edge1"))
+	})
+
+	It("should reject request whose common name is not the endpoint name of the node", func() {
+		_, err := ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{CommonName: "cluster.edge2"}))
+		Expect(err).Should(HaveOccurred())
+
+		_, err = ctl.sign(context.Background(), newCSR("edge1", certutil.Request{CommonName: "cluster.edge1"}))
+		Expect(err).Should(HaveOccurred())
+
+		_, err = ctl.sign(context.Background(), newCSR("fabedge-agent-", certutil.Request{CommonName: "cluster."}))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should reject request with SANs", func() {
+		_, err := ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{
+			CommonName: "cluster.edge1",
+			DNSNames:   []string{"example.com"},
+		}))
+		Expect(err).Should(HaveOccurred())
+
+		_, err = ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{
+			CommonName: "cluster.edge1",
+			IPs:        []net.IP{net.ParseIP("10.10.10.10")},
+		}))
+		Expect(err).Should(HaveOccurred())
+
+		_, err = ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{
+			CommonName: "cluster.edge1",
+			URIs:       certutil.URIsOfID("spiffe://example.org/cluster/edge1"),
+		}))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should only allow SPIFFE ID of the agent if SPIFFE IDs are enabled", func() {
+		ctl.GetEndpointID = func(nodeName string) string { return "spiffe://example.org/cluster/" + nodeName }
+
+		_, err := ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{
+			CommonName: "cluster.edge1",
+			URIs:       certutil.URIsOfID("spiffe://example.org/cluster/edge1"),
+		}))
+		Expect(err).Should(BeNil())
+
+		_, err = ctl.sign(context.Background(), newCSR("fabedge-agent-edge1", certutil.Request{
+			CommonName: "cluster.edge1",
+			URIs:       certutil.URIsOfID("spiffe://example.org/cluster/edge2"),
+		}))
+		Expect(err).Should(HaveOccurred())
+	})
+})
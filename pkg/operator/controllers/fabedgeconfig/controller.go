@@ -0,0 +1,197 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabedgeconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "fabedge-config-controller"
+
+	reasonApplied       = "Applied"
+	reasonInvalid       = "InvalidSpec"
+	reasonNoRestart     = "NoRestartRequired"
+	reasonFieldsChanged = "FieldsChanged"
+
+	// finalizerTeardown is added to FabEdge resource when teardown is enabled,
+	// it's removed after everything fabedge made is removed
+	finalizerTeardown = "fabedge.io/teardown"
+	teardownInterval  = 5 * time.Second
+)
+
+type Config struct {
+	Manager manager.Manager
+	// Name is the name of FabEdge resource used by operator, others are ignored
+	Name string
+	// Validate checks if spec can be used by operator
+	Validate func(spec apis.FabEdgeSpec) error
+	// Apply applies spec to running controllers and returns those changed
+	// fields which only take effect after operator restarts
+	Apply func(spec apis.FabEdgeSpec) []string
+	// Teardown removes everything fabedge made when FabEdge resource is deleted,
+	// it returns true when it's finished. Nil means deleting FabEdge resource only
+	// makes operator fall back to arguments
+	Teardown func(ctx context.Context) (bool, error)
+}
+
+// controller watches the FabEdge resource, applies the changes which can be
+// applied at runtime and reports the result by status conditions
+type controller struct {
+	Config
+
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &controller{
+		Config: cnf,
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctl.Watch(
+		&source.Kind{Type: &apis.FabEdge{}},
+		&handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() == cnf.Name
+		}),
+		predicate.GenerationChangedPredicate{},
+	)
+}
+
+func (ctl *controller) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := ctl.log.WithValues("request", request)
+
+	var fabedge apis.FabEdge
+	if err := ctl.client.Get(ctx, request.NamespacedName, &fabedge); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("FabEdge is deleted, fall back to arguments")
+			ctl.Apply(apis.FabEdgeSpec{})
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get FabEdge")
+		return reconcile.Result{}, err
+	}
+
+	if fabedge.DeletionTimestamp != nil {
+		return ctl.teardown(ctx, fabedge)
+	}
+
+	if ctl.Teardown != nil && !controllerutil.ContainsFinalizer(&fabedge, finalizerTeardown) {
+		controllerutil.AddFinalizer(&fabedge, finalizerTeardown)
+		if err := ctl.client.Update(ctx, &fabedge); err != nil {
+			log.Error(err, "failed to add teardown finalizer to FabEdge")
+			return reconcile.Result{}, err
+		}
+	}
+
+	generation := fabedge.Generation
+	if err := ctl.Validate(fabedge.Spec); err != nil {
+		log.Error(err, "invalid FabEdge spec, it's not applied")
+		setCondition(&fabedge, apis.FabEdgeConditionApplied, metav1.ConditionFalse, reasonInvalid, err.Error())
+		meta.RemoveStatusCondition(&fabedge.Status.Conditions, apis.FabEdgeConditionRestartRequired)
+	} else {
+		fields := ctl.Apply(fabedge.Spec)
+		log.V(3).Info("FabEdge spec is applied", "restartRequiredFields", fields)
+
+		setCondition(&fabedge, apis.FabEdgeConditionApplied, metav1.ConditionTrue, reasonApplied, "spec is applied")
+		if len(fields) == 0 {
+			setCondition(&fabedge, apis.FabEdgeConditionRestartRequired, metav1.ConditionFalse, reasonNoRestart, "all changes are applied")
+		} else {
+			msg := fmt.Sprintf("changes of these fields take effect after operator restarts: %s", strings.Join(fields, ", "))
+			setCondition(&fabedge, apis.FabEdgeConditionRestartRequired, metav1.ConditionTrue, reasonFieldsChanged, msg)
+		}
+	}
+	fabedge.Status.ObservedGeneration = generation
+
+	if err := ctl.client.Status().Update(ctx, &fabedge); err != nil {
+		log.Error(err, "failed to update status of FabEdge")
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (ctl *controller) teardown(ctx context.Context, fabedge apis.FabEdge) (reconcile.Result, error) {
+	if ctl.Teardown == nil || !controllerutil.ContainsFinalizer(&fabedge, finalizerTeardown) {
+		return reconcile.Result{}, nil
+	}
+
+	log := ctl.log.WithValues("name", fabedge.Name)
+
+	done, err := ctl.Teardown(ctx)
+	if err != nil {
+		log.Error(err, "failed to tear down")
+		return reconcile.Result{}, err
+	}
+
+	if !done {
+		log.V(3).Info("teardown is not finished yet")
+		return reconcile.Result{RequeueAfter: teardownInterval}, nil
+	}
+
+	controllerutil.RemoveFinalizer(&fabedge, finalizerTeardown)
+	if err = ctl.client.Update(ctx, &fabedge); err != nil {
+		log.Error(err, "failed to remove teardown finalizer from FabEdge")
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func setCondition(fabedge *apis.FabEdge, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&fabedge.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: fabedge.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fabedgeconfig
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("FabEdgeConfigController", func() {
+	var (
+		ctl        *controller
+		applied    []apis.FabEdgeSpec
+		restartFor []string
+		invalid    error
+		ctx        = context.Background()
+		key        = client.ObjectKey{Name: "fabedge"}
+	)
+
+	BeforeEach(func() {
+		applied, restartFor, invalid = nil, nil, nil
+		ctl = &controller{
+			Config: Config{
+				Name: "fabedge",
+				Validate: func(spec apis.FabEdgeSpec) error {
+					return invalid
+				},
+				Apply: func(spec apis.FabEdgeSpec) []string {
+					applied = append(applied, spec)
+					return restartFor
+				},
+			},
+			client: k8sClient,
+			log:    klogr.New(),
+		}
+
+		fabedge := apis.FabEdge{
+			ObjectMeta: metav1.ObjectMeta{Name: "fabedge"},
+			Spec: apis.FabEdgeSpec{
+				Agent: apis.FabEdgeAgentSpec{Image: "fabedge/agent:v0.6.0"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, &fabedge)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.DeleteAllOf(ctx, &apis.FabEdge{})).Should(Succeed())
+	})
+
+	reconcileAndGet := func() apis.FabEdge {
+		_, err := ctl.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var fabedge apis.FabEdge
+		Expect(k8sClient.Get(ctx, key, &fabedge)).Should(Succeed())
+		return fabedge
+	}
+
+	It("should apply spec and report no restart is required", func() {
+		fabedge := reconcileAndGet()
+
+		Expect(applied).To(HaveLen(1))
+		Expect(applied[0].Agent.Image).To(Equal("fabedge/agent:v0.6.0"))
+		Expect(fabedge.Status.ObservedGeneration).To(Equal(fabedge.Generation))
+		Expect(meta.IsStatusConditionTrue(fabedge.Status.Conditions, apis.FabEdgeConditionApplied)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(fabedge.Status.Conditions, apis.FabEdgeConditionRestartRequired)).To(BeTrue())
+	})
+
+	It("should report fields which require restart", func() {
+		restartFor = []string{"edgePodCIDR", "cniType"}
+		fabedge := reconcileAndGet()
+
+		cond := meta.FindStatusCondition(fabedge.Status.Conditions, apis.FabEdgeConditionRestartRequired)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Message).To(ContainSubstring("edgePodCIDR, cniType"))
+	})
+
+	It("should not apply invalid spec", func() {
+		invalid = fmt.Errorf("invalid edge pod cidr")
+		fabedge := reconcileAndGet()
+
+		Expect(applied).To(BeEmpty())
+		cond := meta.FindStatusCondition(fabedge.Status.Conditions, apis.FabEdgeConditionApplied)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Message).To(Equal("invalid edge pod cidr"))
+		Expect(meta.FindStatusCondition(fabedge.Status.Conditions, apis.FabEdgeConditionRestartRequired)).To(BeNil())
+	})
+
+	It("should fall back to arguments when FabEdge is deleted", func() {
+		Expect(k8sClient.DeleteAllOf(ctx, &apis.FabEdge{})).Should(Succeed())
+
+		_, err := ctl.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(applied).To(Equal([]apis.FabEdgeSpec{{}}))
+	})
+
+	It("should keep FabEdge until teardown is finished when teardown is enabled", func() {
+		rounds := 0
+		ctl.Teardown = func(ctx context.Context) (bool, error) {
+			rounds++
+			return rounds > 1, nil
+		}
+
+		fabedge := reconcileAndGet()
+		Expect(fabedge.Finalizers).To(ContainElement(finalizerTeardown))
+
+		Expect(k8sClient.Delete(ctx, &fabedge)).Should(Succeed())
+
+		By("reconciling when teardown is not finished")
+		result, err := ctl.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(teardownInterval))
+		Expect(k8sClient.Get(ctx, key, &fabedge)).Should(Succeed())
+
+		By("reconciling when teardown is finished")
+		result, err = ctl.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(rounds).To(Equal(2))
+
+		err = k8sClient.Get(ctx, key, &fabedge)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(applied).To(HaveLen(1))
+	})
+})
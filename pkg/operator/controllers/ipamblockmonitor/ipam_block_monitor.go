@@ -15,6 +15,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 	"github.com/fabedge/fabedge/third_party/calicoapi"
 )
 
@@ -34,11 +35,11 @@ func AddToManager(cfg Config) error {
 		controllerName,
 		mgr,
 		controller.Options{
-			Reconciler: &ipamBlockMonitor{
+			Reconciler: tracing.WrapReconciler(controllerName, &ipamBlockMonitor{
 				Config: cfg,
 				log:    mgr.GetLogger().WithName(controllerName),
 				client: mgr.GetClient(),
-			},
+			}),
 		},
 	)
 	if err != nil {
@@ -0,0 +1,115 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeedge
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "kubeedge-node-labeler"
+)
+
+type Config struct {
+	Manager manager.Manager
+}
+
+// nodeLabeler puts edge labels on nodes managed by edgecore, so they are taken as edge nodes
+// by operator, connector and cloud agents even if they are not labeled when they join
+type nodeLabeler struct {
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &nodeLabeler{
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctl.Watch(
+		&source.Kind{Type: &corev1.Node{}},
+		&handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			node, ok := obj.(*corev1.Node)
+			return ok && needsLabels(*node)
+		}),
+	)
+}
+
+func (ctl *nodeLabeler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := ctl.log.WithValues("request", request)
+
+	var node corev1.Node
+	if err := ctl.client.Get(ctx, request.NamespacedName, &node); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get node")
+		return reconcile.Result{}, err
+	}
+
+	if node.DeletionTimestamp != nil || !needsLabels(node) {
+		return reconcile.Result{}, nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	for key, value := range nodeutil.GetEdgeNodeLabels() {
+		node.Labels[key] = value
+	}
+
+	log.V(3).Info("node is managed by edgecore, put edge labels on it", "labels", nodeutil.GetEdgeNodeLabels())
+	if err := ctl.client.Patch(ctx, &node, patch); err != nil {
+		log.Error(err, "failed to put edge labels on node")
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func needsLabels(node corev1.Node) bool {
+	return nodeutil.IsKubeEdgeNode(node) && !nodeutil.IsEdgeNode(node)
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openyurt
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "nodepool-controller"
+)
+
+// nodePoolGVK is the kind of OpenYurt NodePool, NodePools are accessed as unstructured
+// objects, so operator doesn't depend on API of OpenYurt
+var nodePoolGVK = schema.GroupVersionKind{Group: "apps.openyurt.io", Version: "v1beta1", Kind: "NodePool"}
+
+type ObjectKey = client.ObjectKey
+
+type Config struct {
+	Manager manager.Manager
+}
+
+// nodePoolController puts public addresses in annotation of NodePools on their edge nodes,
+// addresses put on nodes by users are never changed. The reconcile request's name is a pool name
+type nodePoolController struct {
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &nodePoolController{
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ctl.Watch(
+		&source.Kind{Type: &corev1.Node{}},
+		handler.EnqueueRequestsFromMapFunc(poolsOfNode),
+	)
+	if err != nil {
+		return err
+	}
+
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(nodePoolGVK)
+	return ctl.Watch(
+		&source.Kind{Type: pool},
+		&handler.EnqueueRequestForObject{},
+	)
+}
+
+// poolsOfNode returns the pool of node and the pool which public addresses of node are copied
+// from, the latter is necessary when a node is moved to another pool
+func poolsOfNode(obj client.Object) []reconcile.Request {
+	pools := sets.NewString()
+	if pool := obj.GetLabels()[constants.KeyOpenYurtNodePool]; pool != "" {
+		pools.Insert(pool)
+	}
+	if pool := obj.GetAnnotations()[constants.KeyPublicAddressesFromPool]; pool != "" {
+		pools.Insert(pool)
+	}
+
+	requests := make([]reconcile.Request, 0, pools.Len())
+	for _, pool := range pools.List() {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: ObjectKey{Name: pool},
+		})
+	}
+
+	return requests
+}
+
+func (ctl *nodePoolController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	poolName := request.Name
+	log := ctl.log.WithValues("nodePool", poolName)
+
+	// addresses are removed from nodes if the pool is gone
+	var addresses string
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(nodePoolGVK)
+	err := ctl.client.Get(ctx, ObjectKey{Name: poolName}, pool)
+	switch {
+	case err == nil:
+		if pool.GetDeletionTimestamp() == nil {
+			addresses = pool.GetAnnotations()[constants.KeyNodePublicAddresses]
+		}
+	case errors.IsNotFound(err):
+	default:
+		log.Error(err, "failed to get node pool")
+		return reconcile.Result{}, err
+	}
+
+	var nodes corev1.NodeList
+	if err = ctl.client.List(ctx, &nodes, client.MatchingLabels(nodeutil.GetEdgeNodeLabels())); err != nil {
+		log.Error(err, "failed to list edge nodes")
+		return reconcile.Result{}, err
+	}
+
+	for i := range nodes.Items {
+		if err = ctl.syncNode(ctx, &nodes.Items[i], poolName, addresses); err != nil {
+			log.Error(err, "failed to sync public addresses of node", "nodeName", nodes.Items[i].Name)
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// syncNode puts addresses of the pool on node if node is in the pool, or removes them if node is
+// not in the pool any more or the pool has no addresses
+func (ctl *nodePoolController) syncNode(ctx context.Context, node *corev1.Node, poolName, addresses string) error {
+	if node.DeletionTimestamp != nil {
+		return nil
+	}
+
+	inPool := node.Labels[constants.KeyOpenYurtNodePool] == poolName
+	copiedFrom, copied := node.Annotations[constants.KeyPublicAddressesFromPool]
+	if !copied && node.Annotations[constants.KeyNodePublicAddresses] != "" {
+		return nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	switch {
+	case inPool && addresses != "":
+		if copiedFrom == poolName && node.Annotations[constants.KeyNodePublicAddresses] == addresses {
+			return nil
+		}
+
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[constants.KeyNodePublicAddresses] = addresses
+		node.Annotations[constants.KeyPublicAddressesFromPool] = poolName
+		ctl.log.V(3).Info("put public addresses of node pool on node", "nodeName", node.Name, "nodePool", poolName, "addresses", addresses)
+	case copied && copiedFrom == poolName:
+		delete(node.Annotations, constants.KeyNodePublicAddresses)
+		delete(node.Annotations, constants.KeyPublicAddressesFromPool)
+		ctl.log.V(3).Info("remove public addresses of node pool from node", "nodeName", node.Name, "nodePool", poolName)
+	default:
+		return nil
+	}
+
+	return ctl.client.Patch(ctx, node, patch)
+}
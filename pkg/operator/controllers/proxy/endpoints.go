@@ -0,0 +1,205 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// EndpointsSourceAuto uses EndpointSlices if the API is served, otherwise Endpoints
+	EndpointsSourceAuto = "auto"
+	// EndpointsSourceEndpointSlice watches EndpointSlices, each slice holds at most 100 endpoints
+	// by default, so services with thousands of backends are synced slice by slice
+	EndpointsSourceEndpointSlice = "endpointslice"
+	// EndpointsSourceEndpoints watches Endpoints, it's for clusters without EndpointSlice API.
+	// Endpoints with more than 1000 addresses are truncated by kubernetes
+	EndpointsSourceEndpoints = "endpoints"
+
+	AnnotationEndpointsOverCapacity = "endpoints.kubernetes.io/over-capacity"
+)
+
+// resolveEndpointsSource returns the source proxy watches endpoints from. If source is auto,
+// EndpointSlice is chosen only if discovery.k8s.io/v1beta1 is served by kube-apiserver
+func resolveEndpointsSource(mgr manager.Manager, source string) (string, error) {
+	switch source {
+	case EndpointsSourceEndpointSlice, EndpointsSourceEndpoints:
+		return source, nil
+	case EndpointsSourceAuto, "":
+	default:
+		return "", fmt.Errorf("unknown endpoints source: %s", source)
+	}
+
+	gvk := discoveryv1.SchemeGroupVersion.WithKind("EndpointSlice")
+	_, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	switch {
+	case err == nil:
+		return EndpointsSourceEndpointSlice, nil
+	case meta.IsNoMatchError(err):
+		return EndpointsSourceEndpoints, nil
+	default:
+		return "", err
+	}
+}
+
+// OnEndpointsUpdate synchronizes endpoints of a service from its Endpoints. Each subset of Endpoints
+// is taken as an endpointslice, so they are handled the same way as EndpointSlices
+func (p *proxy) OnEndpointsUpdate(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := p.log.WithValues("request", request)
+
+	var endpoints corev1.Endpoints
+	err := p.client.Get(ctx, request.NamespacedName, &endpoints)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("endpoints is deleted, cleanup related endpoints")
+
+			p.cleanupEndpointsOfEndpoints(request.NamespacedName, nil)
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get endpoints")
+		return reconcile.Result{}, err
+	}
+
+	if endpoints.DeletionTimestamp != nil {
+		log.Info("endpoints is terminating, cleanup related endpoints")
+		p.cleanupEndpointsOfEndpoints(request.NamespacedName, nil)
+		return reconcile.Result{}, nil
+	}
+
+	var service corev1.Service
+	if err = p.client.Get(ctx, request.NamespacedName, &service); err != nil {
+		log.Error(err, "failed to get service")
+
+		if errors.IsNotFound(err) {
+			log.Info("Corresponding service is not found, cleanup service and endpoints")
+			p.cleanupEndpointsOfEndpoints(request.NamespacedName, nil)
+			p.cleanupService(request.NamespacedName)
+			return Result{}, nil
+		}
+
+		return Result{}, err
+	}
+
+	if p.shouldSkipService(&service) {
+		log.V(5).Info("service has no ClusterIP, skip it")
+		p.cleanupEndpointsOfEndpoints(request.NamespacedName, nil)
+		p.cleanupService(request.NamespacedName)
+		return Result{}, nil
+	}
+
+	if getValueByKey(endpoints.Annotations, AnnotationEndpointsOverCapacity) != "" {
+		log.Info("endpoints is truncated by kubernetes, some backends are missing, use endpointslice as endpoints source instead")
+	}
+
+	infos := p.makeEndpointSliceInfosFromEndpoints(&endpoints)
+
+	keys := sets.NewString()
+	for _, info := range infos {
+		keys.Insert(info.Name)
+	}
+	// subsets which are gone are removed first, so endpoints moved to other subsets are not removed by mistake
+	p.cleanupEndpointsOfEndpoints(request.NamespacedName, keys)
+
+	serviceChanged := p.syncServiceInfoFromService(request.NamespacedName, &service)
+	for _, info := range infos {
+		p.syncServiceEndpointsFromEndpointSlice(info, serviceChanged)
+	}
+
+	return Result{}, nil
+}
+
+// cleanupEndpointsOfEndpoints cleans up endpoints of subsets of Endpoints except those in keep
+func (p *proxy) cleanupEndpointsOfEndpoints(key ObjectKey, keep sets.String) {
+	prefix := key.Name + "/"
+
+	var stale []ObjectKey
+	p.mu.Lock()
+	for esKey, es := range p.endpointSliceMap {
+		if es.ServiceKey == key && strings.HasPrefix(esKey.Name, prefix) && !keep.Has(esKey.Name) {
+			stale = append(stale, esKey)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, esKey := range stale {
+		p.cleanupEndpointsOfEndpointSlice(esKey)
+	}
+}
+
+// makeEndpointSliceInfosFromEndpoints makes an endpointslice info for each subset of endpoints. The name of
+// each info is made of the name of endpoints and ports of the subset, it can't conflict with real endpointslices
+func (p *proxy) makeEndpointSliceInfosFromEndpoints(endpoints *corev1.Endpoints) []EndpointSliceInfo {
+	serviceKey := ObjectKey{Name: endpoints.Name, Namespace: endpoints.Namespace}
+
+	var infos []EndpointSliceInfo
+	for _, subset := range endpoints.Subsets {
+		info := EndpointSliceInfo{
+			ServiceKey: serviceKey,
+			Ports:      make(map[Port]Empty),
+			Endpoints:  make(map[string]EndpointInfo),
+			PortNames:  make(map[Port]string),
+		}
+
+		var ports []string
+		for _, port := range subset.Ports {
+			p := Port{
+				Port:     port.Port,
+				Protocol: port.Protocol,
+			}
+			info.Ports[p] = Empty{}
+			info.PortNames[p] = port.Name
+			ports = append(ports, p.String())
+		}
+		sort.Strings(ports)
+		info.ObjectKey = ObjectKey{
+			Name:      fmt.Sprintf("%s/%s", endpoints.Name, strings.Join(ports, ",")),
+			Namespace: endpoints.Namespace,
+		}
+
+		// not ready addresses are kept like those of endpointslices
+		for _, addresses := range [][]corev1.EndpointAddress{subset.Addresses, subset.NotReadyAddresses} {
+			for _, addr := range addresses {
+				if addr.NodeName == nil {
+					continue
+				}
+
+				if _, exists := p.nodeSet[*addr.NodeName]; !exists {
+					continue
+				}
+
+				info.Endpoints[addr.IP] = EndpointInfo{
+					IP:       addr.IP,
+					NodeName: *addr.NodeName,
+				}
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
@@ -26,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/common/netconf"
 )
 
@@ -42,6 +43,8 @@ type loadBalanceConfigKeeper struct {
 	namespace     string
 	interval      time.Duration
 	ipvsScheduler string
+	// configResource makes rules written to AgentConfigs instead of configmaps
+	configResource bool
 
 	client client.Client
 	log    logr.Logger
@@ -99,19 +102,33 @@ func (k *loadBalanceConfigKeeper) syncRules() {
 	for _, node := range nodeSet {
 		servers := make(netconf.VirtualServers, 0, len(node.ServicePortMap))
 		for spn, sp := range node.ServicePortMap {
+			scheduler := sp.Scheduler
+			if scheduler == "" {
+				scheduler = k.ipvsScheduler
+			}
+
 			servers = append(servers, netconf.VirtualServer{
 				IP:                  sp.ClusterIP,
 				Port:                sp.Port,
 				Protocol:            sp.Protocol,
 				SessionAffinity:     sp.SessionAffinity,
 				StickyMaxAgeSeconds: sp.StickyMaxAgeSeconds,
-				Scheduler:           k.ipvsScheduler,
-				RealServers:         convertEndpointSetToRealServers(node.EndpointMap[spn]),
+				Scheduler:           scheduler,
+
+				NodePort:              sp.NodePort,
+				ExternalTrafficPolicy: sp.ExternalTrafficPolicy,
+
+				RealServers: convertEndpointSetToRealServers(node.EndpointMap[spn]),
 			})
 		}
 		sort.Sort(servers)
 
-		if err := k.writeToConfigMap(node.Name, servers); err != nil {
+		write := k.writeToConfigMap
+		if k.configResource {
+			write = k.writeToAgentConfig
+		}
+
+		if err := write(node.Name, servers); err != nil {
 			// add node back to nodeset to wait next sync loop
 			// because the node is old, we should override newest node
 			k.AddNodeIfNotPresent(node)
@@ -157,6 +174,44 @@ func (k *loadBalanceConfigKeeper) writeToConfigMap(nodeName string, servers netc
 	return err
 }
 
+// writeToAgentConfig writes servers to spec of AgentConfig of the node, other fields are kept by agent controller
+func (k *loadBalanceConfigKeeper) writeToAgentConfig(nodeName string, servers netconf.VirtualServers) error {
+	key := ObjectKey{Name: nodeName, Namespace: k.namespace}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	configDataBytes, err := yaml.Marshal(&servers)
+	if err != nil {
+		k.log.Info("failed to generate load balance config")
+		return err
+	}
+	configData := string(configDataBytes)
+
+	log := k.log.WithValues("nodeName", nodeName, "key", key)
+	log.V(5).Info("Sync services to agent config")
+
+	var agentConfig apis.AgentConfig
+	if err = k.client.Get(ctx, key, &agentConfig); err != nil {
+		// agent config is created by another controller
+		log.Error(err, "failed to get agent config")
+		return err
+	}
+
+	if configData == agentConfig.Spec.Services {
+		log.V(5).Info("services are not changed, skip updating")
+		return nil
+	}
+
+	patch := client.MergeFrom(agentConfig.DeepCopy())
+	agentConfig.Spec.Services = configData
+	if err = k.client.Patch(ctx, &agentConfig, patch); err != nil {
+		log.Error(err, "failed to update agent config")
+	}
+
+	return err
+}
+
 func convertEndpointSetToRealServers(endpointSet EndpointSet) netconf.RealServers {
 	servers := make(netconf.RealServers, 0, len(endpointSet))
 	for endpoint := range endpointSet {
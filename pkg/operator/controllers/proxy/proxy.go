@@ -33,14 +33,23 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
 	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 )
 
 const (
 	LabelServiceName = "kubernetes.io/service-name"
 	LabelHostname    = "kubernetes.io/hostname"
+
+	// AnnotationIPVSScheduler overrides the ipvs scheduler of a service, e.g. wrr, lc, sh
+	AnnotationIPVSScheduler = "fabedge.io/ipvs-scheduler"
 )
 
+// ipvsSchedulers are schedulers which can be set by AnnotationIPVSScheduler
+var ipvsSchedulers = sets.NewString("rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq", "mh")
+
 // type shortcuts
 type (
 	EndpointSlice = discoveryv1.EndpointSlice
@@ -52,11 +61,27 @@ type Config struct {
 	Manager manager.Manager
 	// the namespace where agent and configmap are created
 	AgentNamespace string
+	// AgentConfigResource makes load balance rules written to AgentConfigs instead of configmaps
+	AgentConfigResource bool
 
 	IPVSScheduler string
 
 	// the interval to check if agent load balance rules is consistent with configmap
 	CheckInterval time.Duration
+	// WriteInterval is the interval to write changed load balance rules to configmaps,
+	// changes of a node in an interval are written at once
+	WriteInterval time.Duration
+
+	MaxConcurrentReconciles int
+
+	// EndpointsSource is where endpoints of services are watched from, see EndpointsSourceAuto
+	EndpointsSource string
+
+	// Mode decides which endpoints are used by edge nodes, see ModeLocal and ModeTopology
+	Mode string
+	// Store and GetEndpointName are used to find community peers of edge nodes in topology mode
+	Store           storepkg.Interface
+	GetEndpointName types.GetNameFunc
 }
 
 // proxy keep proxy rules configmap for each service which has edge endpoints.
@@ -74,6 +99,12 @@ type proxy struct {
 
 	client client.Client
 	log    logr.Logger
+
+	mode            string
+	store           storepkg.Interface
+	getEndpointName types.GetNameFunc
+	// endpointToNode maps endpoint names of edge nodes to node names
+	endpointToNode map[string]string
 }
 
 func AddToManager(cnf Config) error {
@@ -81,10 +112,12 @@ func AddToManager(cnf Config) error {
 
 	keeper := &loadBalanceConfigKeeper{
 		namespace:     cnf.AgentNamespace,
-		interval:      5 * time.Second,
+		interval:      cnf.WriteInterval,
 		nodeSet:       make(EdgeNodeSet),
 		ipvsScheduler: cnf.IPVSScheduler,
 
+		configResource: cnf.AgentConfigResource,
+
 		client: mgr.GetClient(),
 		log:    mgr.GetLogger().WithName("load-balance-keeper"),
 	}
@@ -98,22 +131,50 @@ func AddToManager(cnf Config) error {
 
 		log:    mgr.GetLogger().WithName("fab-proxy"),
 		client: mgr.GetClient(),
+
+		mode:            cnf.Mode,
+		store:           cnf.Store,
+		getEndpointName: cnf.GetEndpointName,
+		endpointToNode:  make(map[string]string),
 	}
 
 	if err := mgr.Add(manager.RunnableFunc(keeper.Start)); err != nil {
 		return err
 	}
 
+	if proxy.mode == ModeTopology {
+		if err := mgr.Add(manager.RunnableFunc(proxy.watchCommunities)); err != nil {
+			return err
+		}
+	}
+
 	if err := mgr.Add(manager.RunnableFunc(proxy.startCheckLoadBalanceRules)); err != nil {
 		return err
 	}
 
-	err := addController(
-		"proxy-endpointslice",
-		mgr,
-		proxy.OnEndpointSliceUpdate,
-		&EndpointSlice{},
-	)
+	source, err := resolveEndpointsSource(mgr, cnf.EndpointsSource)
+	if err != nil {
+		return err
+	}
+	proxy.log.Info("watch endpoints of services", "source", source)
+
+	if source == EndpointsSourceEndpoints {
+		err = addController(
+			"proxy-endpoints",
+			mgr,
+			cnf.MaxConcurrentReconciles,
+			proxy.OnEndpointsUpdate,
+			&corev1.Endpoints{},
+		)
+	} else {
+		err = addController(
+			"proxy-endpointslice",
+			mgr,
+			cnf.MaxConcurrentReconciles,
+			proxy.OnEndpointSliceUpdate,
+			&EndpointSlice{},
+		)
+	}
 	if err != nil {
 		return err
 	}
@@ -121,6 +182,7 @@ func AddToManager(cnf Config) error {
 	err = addController(
 		"proxy-node",
 		mgr,
+		cnf.MaxConcurrentReconciles,
 		proxy.onNodeUpdate,
 		&corev1.Node{},
 	)
@@ -130,17 +192,19 @@ func AddToManager(cnf Config) error {
 
 	return addController("proxy-service",
 		mgr,
+		cnf.MaxConcurrentReconciles,
 		proxy.OnServiceUpdate,
 		&corev1.Service{},
 	)
 }
 
-func addController(name string, mgr manager.Manager, reconciler reconcile.Func, watchObj client.Object, predicates ...predicate.Predicate) error {
+func addController(name string, mgr manager.Manager, concurrency int, reconciler reconcile.Func, watchObj client.Object, predicates ...predicate.Predicate) error {
 	c, err := controller.New(
 		name,
 		mgr,
 		controller.Options{
-			Reconciler: reconciler,
+			MaxConcurrentReconciles: concurrency,
+			Reconciler:              tracing.WrapReconciler(name, reconciler),
 		},
 	)
 	if err != nil {
@@ -185,12 +249,20 @@ func (p *proxy) addNode(name string) {
 	if _, exists := p.nodeSet[name]; !exists {
 		p.nodeSet[name] = newEdgeNode(name)
 	}
+
+	if p.getEndpointName != nil {
+		p.endpointToNode[p.getEndpointName(name)] = name
+	}
 }
 
 func (p *proxy) removeNode(name string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	delete(p.nodeSet, name)
+
+	if p.getEndpointName != nil {
+		delete(p.endpointToNode, p.getEndpointName(name))
+	}
 }
 
 func (p *proxy) OnServiceUpdate(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
@@ -208,6 +280,10 @@ func (p *proxy) OnServiceUpdate(ctx context.Context, request reconcile.Request)
 		return Result{}, err
 	}
 
+	if scheduler := getValueByKey(service.Annotations, AnnotationIPVSScheduler); scheduler != "" && !ipvsSchedulers.Has(scheduler) {
+		log.Info("unknown ipvs scheduler in annotation, the default one is used", "scheduler", scheduler)
+	}
+
 	// if service is updated to a invalid service, we take it as deleted and cleanup related resources
 	if p.shouldSkipService(&service) {
 		log.V(5).Info("service has no ClusterIP, skip it")
@@ -235,13 +311,21 @@ func (p *proxy) syncServiceInfoFromService(key ObjectKey, svc *corev1.Service) b
 
 	if oldService.ClusterIP == newService.ClusterIP &&
 		oldService.SessionAffinity == newService.SessionAffinity &&
-		oldService.StickyMaxAgeSeconds == newService.StickyMaxAgeSeconds {
+		oldService.StickyMaxAgeSeconds == newService.StickyMaxAgeSeconds &&
+		oldService.InternalTrafficPolicy == newService.InternalTrafficPolicy &&
+		oldService.Scheduler == newService.Scheduler &&
+		oldService.ExternalTrafficPolicy == newService.ExternalTrafficPolicy &&
+		reflect.DeepEqual(oldService.NodePorts, newService.NodePorts) {
 		return false
 	}
 
 	oldService.ClusterIP = newService.ClusterIP
 	oldService.SessionAffinity = newService.SessionAffinity
 	oldService.StickyMaxAgeSeconds = newService.StickyMaxAgeSeconds
+	oldService.InternalTrafficPolicy = newService.InternalTrafficPolicy
+	oldService.Scheduler = newService.Scheduler
+	oldService.NodePorts = newService.NodePorts
+	oldService.ExternalTrafficPolicy = newService.ExternalTrafficPolicy
 
 	if oldService.EndpointMap == nil {
 		oldService.EndpointMap = make(map[Port]EndpointSet)
@@ -252,9 +336,40 @@ func (p *proxy) syncServiceInfoFromService(key ObjectKey, svc *corev1.Service) b
 
 	p.serviceMap[key] = oldService
 
+	// service ports of nodes are made when endpoints are synchronized, they have to be
+	// refreshed here, or changes like session affinity are not applied until endpoints change
+	nodeNames := sets.NewString()
+	for _, nodeName := range oldService.EndpointToNodes {
+		nodeNames.Insert(nodeName)
+	}
+	for nodeName := range nodeNames {
+		p.refreshServicePortsOfNode(nodeName, key, oldService)
+	}
+
 	return true
 }
 
+func (p *proxy) refreshServicePortsOfNode(nodeName string, serviceKey ObjectKey, serviceInfo ServiceInfo) {
+	node, ok := p.nodeSet[nodeName]
+	if !ok {
+		return
+	}
+
+	for spn, sp := range node.ServicePortMap {
+		if spn.NamespacedName != serviceKey {
+			continue
+		}
+
+		sp.ClusterIP = serviceInfo.ClusterIP
+		sp.SessionAffinity = serviceInfo.SessionAffinity
+		sp.StickyMaxAgeSeconds = serviceInfo.StickyMaxAgeSeconds
+		sp.Scheduler = serviceInfo.Scheduler
+		sp.NodePort = serviceInfo.NodePorts[sp.PortName]
+		sp.ExternalTrafficPolicy = serviceInfo.ExternalTrafficPolicy
+		node.ServicePortMap[spn] = sp
+	}
+}
+
 func (p *proxy) cleanupService(serviceKey ObjectKey) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -295,13 +410,11 @@ func (p *proxy) syncServiceChangesToAgentByKey(key ObjectKey) {
 }
 
 func (p *proxy) syncServiceChangesToAgent(serviceInfo ServiceInfo) {
+	nodeNames := sets.NewString()
 	for _, name := range serviceInfo.EndpointToNodes {
-		node, ok := p.nodeSet[name]
-		if !ok {
-			continue
-		}
-		p.keeper.AddNode(node)
+		nodeNames.Insert(name)
 	}
+	p.syncNodesToAgent(nodeNames)
 }
 
 func (p *proxy) OnEndpointSliceUpdate(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
@@ -401,6 +514,11 @@ func (p *proxy) syncServiceEndpointsFromEndpointSlice(newES EndpointSliceInfo, s
 				Protocol:            port.Protocol,
 				SessionAffinity:     serviceInfo.SessionAffinity,
 				StickyMaxAgeSeconds: serviceInfo.StickyMaxAgeSeconds,
+				Scheduler:           serviceInfo.Scheduler,
+
+				PortName:              newES.PortNames[port],
+				NodePort:              serviceInfo.NodePorts[newES.PortNames[port]],
+				ExternalTrafficPolicy: serviceInfo.ExternalTrafficPolicy,
 			})
 
 			added := p.addEndpointToNode(ep.NodeName, servicePortName, endpoint)
@@ -458,13 +576,7 @@ func (p *proxy) syncServiceEndpointsFromEndpointSlice(newES EndpointSliceInfo, s
 	p.endpointSliceMap[key] = newES
 	p.serviceMap[serviceKey] = serviceInfo
 
-	for nodeName := range changedNodeNames {
-		node, ok := p.nodeSet[nodeName]
-		if !ok {
-			continue
-		}
-		p.keeper.AddNode(node)
-	}
+	p.syncNodesToAgent(changedNodeNames)
 }
 
 func (p *proxy) cleanupEndpointsOfEndpointSlice(key ObjectKey) {
@@ -491,9 +603,11 @@ func (p *proxy) startCheckLoadBalanceRules(ctx context.Context) error {
 	for {
 		select {
 		case <-tick.C:
+			p.mu.Lock()
 			for _, node := range p.nodeSet {
-				p.keeper.AddNodeIfNotPresent(node)
+				p.keeper.AddNodeIfNotPresent(p.nodeForAgent(node))
 			}
+			p.mu.Unlock()
 		case <-ctx.Done():
 			return nil
 		}
@@ -568,6 +682,7 @@ func (p *proxy) makeEndpointSliceInfo(es *EndpointSlice) EndpointSliceInfo {
 		},
 		Ports:     make(map[Port]Empty),
 		Endpoints: make(map[string]EndpointInfo),
+		PortNames: make(map[Port]string),
 	}
 
 	for _, port := range es.Ports {
@@ -576,6 +691,9 @@ func (p *proxy) makeEndpointSliceInfo(es *EndpointSlice) EndpointSliceInfo {
 			Protocol: *port.Protocol,
 		}
 		info.Ports[p] = Empty{}
+		if port.Name != nil {
+			info.PortNames[p] = *port.Name
+		}
 	}
 
 	for _, ep := range es.Endpoints {
@@ -605,7 +723,10 @@ func (p *proxy) getEndpointSliceInfo(key ObjectKey) (EndpointSliceInfo, bool) {
 }
 
 func (p *proxy) shouldSkipService(svc *corev1.Service) bool {
-	if svc.Spec.Type != corev1.ServiceTypeClusterIP {
+	// node ports of NodePort and LoadBalancer services are served too, but load balancers are not cared
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
 		return true
 	}
 
@@ -627,10 +748,25 @@ func makeServiceInfo(svc *corev1.Service) ServiceInfo {
 		stickyMaxAgeSeconds = *svc.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds
 	}
 
+	var nodePorts map[string]int32
+	for _, port := range svc.Spec.Ports {
+		if port.NodePort == 0 {
+			continue
+		}
+		if nodePorts == nil {
+			nodePorts = make(map[string]int32)
+		}
+		nodePorts[port.Name] = port.NodePort
+	}
+
 	return ServiceInfo{
-		ClusterIP:           svc.Spec.ClusterIP,
-		SessionAffinity:     svc.Spec.SessionAffinity,
-		StickyMaxAgeSeconds: stickyMaxAgeSeconds,
+		ClusterIP:             svc.Spec.ClusterIP,
+		SessionAffinity:       svc.Spec.SessionAffinity,
+		StickyMaxAgeSeconds:   stickyMaxAgeSeconds,
+		InternalTrafficPolicy: getInternalTrafficPolicy(svc),
+		Scheduler:             getIPVSScheduler(svc),
+		NodePorts:             nodePorts,
+		ExternalTrafficPolicy: svc.Spec.ExternalTrafficPolicy,
 	}
 }
 
@@ -642,6 +778,15 @@ func getValueByKey(data map[string]string, key string) string {
 	return data[key]
 }
 
+// getIPVSScheduler returns the scheduler in annotation of service, unknown schedulers are ignored
+func getIPVSScheduler(svc *corev1.Service) string {
+	scheduler := getValueByKey(svc.Annotations, AnnotationIPVSScheduler)
+	if !ipvsSchedulers.Has(scheduler) {
+		return ""
+	}
+	return scheduler
+}
+
 func getServiceName(data map[string]string) string {
 	return getValueByKey(data, LabelServiceName)
 }
@@ -101,6 +101,12 @@ var _ = Describe("Proxy", func() {
 			Expect(k8sClient.Delete(ctx, &obj)).ShouldNot(HaveOccurred())
 		}
 
+		var endpointsList corev1.EndpointsList
+		Expect(k8sClient.List(ctx, &endpointsList)).ShouldNot(HaveOccurred())
+		for _, obj := range endpointsList.Items {
+			Expect(k8sClient.Delete(ctx, &obj)).ShouldNot(HaveOccurred())
+		}
+
 		cancel()
 	})
 
@@ -114,7 +120,7 @@ var _ = Describe("Proxy", func() {
 		BeforeEach(func() {
 			var reconciler reconcile.Func
 			reconciler, requests = testutil.WrapReconcileFunc(px.OnServiceUpdate)
-			err := addController("proxy-service", mgr, reconciler, &corev1.Service{})
+			err := addController("proxy-service", mgr, 1, reconciler, &corev1.Service{})
 			Expect(err).ShouldNot(HaveOccurred())
 
 			service = corev1.Service{
@@ -182,6 +188,39 @@ var _ = Describe("Proxy", func() {
 			Expect(serviceInfo.StickyMaxAgeSeconds).Should(Equal(timeoutSeconds))
 		})
 
+		It("should update service ports of nodes when SessionAffinity is changed", func() {
+			endpoint := Endpoint{IP: "10.40.20.181", Port: 80}
+			spn := ServicePortName{NamespacedName: serviceKey, Port: 80, Protocol: corev1.ProtocolTCP}
+
+			node := newEdgeNode("node1")
+			node.ServicePortMap[spn] = ServicePort{
+				ClusterIP: service.Spec.ClusterIP,
+				Port:      80,
+				Protocol:  corev1.ProtocolTCP,
+			}
+			node.EndpointMap[spn] = EndpointSet{endpoint: Empty{}}
+			edgeNodeSet["node1"] = node
+			serviceMap[serviceKey].EndpointToNodes[endpoint] = "node1"
+
+			By("update service")
+			timeoutSeconds := int32(3600)
+			service.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+			service.Spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+				ClientIP: &corev1.ClientIPConfig{
+					TimeoutSeconds: &timeoutSeconds,
+				},
+			}
+			Expect(k8sClient.Update(context.Background(), &service)).ShouldNot(HaveOccurred())
+
+			testutil.DrainChan(requests, 5*time.Second)
+
+			By("check service ports of node1")
+			sp := edgeNodeSet["node1"].ServicePortMap[spn]
+			Expect(sp.SessionAffinity).Should(Equal(corev1.ServiceAffinityClientIP))
+			Expect(sp.StickyMaxAgeSeconds).Should(Equal(timeoutSeconds))
+			Expect(keeper.nodeSet).Should(HaveKey("node1"))
+		})
+
 		Context("invalidate service", func() {
 			const (
 				endpointIP = "10.40.20.181"
@@ -266,6 +305,7 @@ var _ = Describe("Proxy", func() {
 			reconciler, requests = testutil.WrapReconcileFunc(px.onNodeUpdate)
 			err := addController("proxy-node",
 				mgr,
+				1,
 				reconciler,
 				&corev1.Node{},
 			)
@@ -348,7 +388,7 @@ var _ = Describe("Proxy", func() {
 		BeforeEach(func() {
 			var reconciler reconcile.Func
 			reconciler, requests = testutil.WrapReconcileFunc(px.OnEndpointSliceUpdate)
-			err := addController("proxy-endpointslice", mgr, reconciler, &discoveryv1.EndpointSlice{})
+			err := addController("proxy-endpointslice", mgr, 1, reconciler, &discoveryv1.EndpointSlice{})
 			Expect(err).ShouldNot(HaveOccurred())
 
 			edgeNodeSet["node1"] = newEdgeNode("node1")
@@ -726,6 +766,108 @@ var _ = Describe("Proxy", func() {
 			Expect(len(node1.EndpointMap)).Should(Equal(0))
 		})
 	})
+
+	Context("endpoints", func() {
+		var requests chan reconcile.Request
+
+		BeforeEach(func() {
+			var reconciler reconcile.Func
+			reconciler, requests = testutil.WrapReconcileFunc(px.OnEndpointsUpdate)
+			err := addController("proxy-endpoints", mgr, 1, reconciler, &corev1.Endpoints{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			edgeNodeSet["node1"] = newEdgeNode("node1")
+			edgeNodeSet["node2"] = newEdgeNode("node2")
+		})
+
+		It("should synchronize service and endpoints info from subsets of endpoints", func() {
+			By("create service")
+			service := corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      getServiceName(),
+					Namespace: defaultNamespace,
+				},
+				Spec: corev1.ServiceSpec{
+					Type: corev1.ServiceTypeClusterIP,
+					Selector: map[string]string{
+						"app": "nginx",
+					},
+					Ports: []corev1.ServicePort{
+						{
+							Name:     "http",
+							Port:     80,
+							Protocol: corev1.ProtocolTCP,
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), &service)).ShouldNot(HaveOccurred())
+
+			By("create endpoints for service")
+			node1, node2, node3 := "node1", "node2", "node3"
+			endpoints := corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      service.Name,
+					Namespace: service.Namespace,
+				},
+				Subsets: []corev1.EndpointSubset{
+					{
+						Addresses: []corev1.EndpointAddress{
+							{IP: "10.40.20.181", NodeName: &node1},
+							{IP: "10.40.20.183", NodeName: &node3},
+						},
+						NotReadyAddresses: []corev1.EndpointAddress{
+							{IP: "10.40.20.182", NodeName: &node2},
+						},
+						Ports: []corev1.EndpointPort{
+							{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+						},
+					},
+				},
+			}
+			key := ObjectKey{Name: endpoints.Name, Namespace: endpoints.Namespace}
+			Expect(k8sClient.Create(context.Background(), &endpoints)).ShouldNot(HaveOccurred())
+			Eventually(requests, 2*time.Second).Should(ReceiveKey(key))
+
+			By("check service info")
+			serviceInfo, ok := serviceMap[key]
+			Expect(ok).To(BeTrue())
+			Expect(serviceInfo.ClusterIP).Should(Equal(service.Spec.ClusterIP))
+
+			ess := serviceInfo.EndpointMap[Port{Port: 80, Protocol: corev1.ProtocolTCP}]
+			Expect(len(ess)).Should(Equal(2))
+			Expect(ess).Should(HaveKey(Endpoint{IP: "10.40.20.181", Port: 80}))
+			Expect(ess).Should(HaveKey(Endpoint{IP: "10.40.20.182", Port: 80}))
+			Expect(serviceInfo.EndpointToNodes).To(HaveKeyWithValue(Endpoint{IP: "10.40.20.181", Port: 80}, "node1"))
+			Expect(serviceInfo.EndpointToNodes).To(HaveKeyWithValue(Endpoint{IP: "10.40.20.182", Port: 80}, "node2"))
+			Expect(keeper.nodeSet).Should(HaveKey("node1"))
+			Expect(keeper.nodeSet).Should(HaveKey("node2"))
+
+			By("change port of endpoints")
+			keeper.nodeSet = make(EdgeNodeSet)
+			endpoints.Subsets[0].Ports[0].Port = 8080
+			Expect(k8sClient.Update(context.Background(), &endpoints)).ShouldNot(HaveOccurred())
+			Eventually(requests, 2*time.Second).Should(ReceiveKey(key))
+
+			serviceInfo = serviceMap[key]
+			Expect(serviceInfo.EndpointMap).ShouldNot(HaveKey(Port{Port: 80, Protocol: corev1.ProtocolTCP}))
+			ess = serviceInfo.EndpointMap[Port{Port: 8080, Protocol: corev1.ProtocolTCP}]
+			Expect(len(ess)).Should(Equal(2))
+			Expect(len(endpointSliceMap)).Should(Equal(1))
+
+			spn := ServicePortName{NamespacedName: key, Port: 8080, Protocol: corev1.ProtocolTCP}
+			Expect(edgeNodeSet["node1"].EndpointMap[spn]).Should(HaveKey(Endpoint{IP: "10.40.20.181", Port: 8080}))
+			Expect(edgeNodeSet["node1"].EndpointMap).ShouldNot(HaveKey(ServicePortName{NamespacedName: key, Port: 80, Protocol: corev1.ProtocolTCP}))
+
+			By("delete endpoints")
+			Expect(k8sClient.Delete(context.Background(), &endpoints)).NotTo(HaveOccurred())
+			Eventually(requests, 2*time.Second).Should(ReceiveKey(key))
+
+			Expect(len(endpointSliceMap)).Should(Equal(0))
+			Expect(len(edgeNodeSet["node1"].EndpointMap)).Should(Equal(0))
+			Expect(len(edgeNodeSet["node2"].EndpointMap)).Should(Equal(0))
+		})
+	})
 })
 
 var _ = Describe("Proxy's shouldSkipService", func() {
@@ -762,13 +904,63 @@ var _ = Describe("Proxy's shouldSkipService", func() {
 		Expect(px.shouldSkipService(&svc)).To(BeTrue())
 	})
 
-	It("should return true when service's type is not ClusterIP", func() {
+	It("should return true when service's type is ExternalName", func() {
 		svc := corev1.Service{
 			Spec: corev1.ServiceSpec{
-				Type: corev1.ServiceTypeNodePort,
+				Type: corev1.ServiceTypeExternalName,
 			},
 		}
 
 		Expect(px.shouldSkipService(&svc)).To(BeTrue())
 	})
+
+	It("should return false for NodePort and LoadBalancer services", func() {
+		svc := corev1.Service{
+			Spec: corev1.ServiceSpec{
+				Type:      corev1.ServiceTypeNodePort,
+				ClusterIP: "10.0.0.181",
+				Selector: map[string]string{
+					"app": "nginx",
+				},
+			},
+		}
+		Expect(px.shouldSkipService(&svc)).To(BeFalse())
+
+		svc.Spec.Type = corev1.ServiceTypeLoadBalancer
+		Expect(px.shouldSkipService(&svc)).To(BeFalse())
+	})
+})
+
+var _ = Describe("makeServiceInfo", func() {
+	It("should take node ports and external traffic policy of NodePort services", func() {
+		svc := corev1.Service{
+			Spec: corev1.ServiceSpec{
+				Type:                  corev1.ServiceTypeNodePort,
+				ClusterIP:             "10.0.0.181",
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, NodePort: 30080},
+					{Name: "health", Port: 8080, Protocol: corev1.ProtocolTCP, NodePort: 30081},
+				},
+			},
+		}
+
+		info := makeServiceInfo(&svc)
+		Expect(info.NodePorts).To(Equal(map[string]int32{"http": 30080, "health": 30081}))
+		Expect(info.ExternalTrafficPolicy).To(Equal(corev1.ServiceExternalTrafficPolicyTypeLocal))
+	})
+
+	It("should have no node ports for ClusterIP services", func() {
+		svc := corev1.Service{
+			Spec: corev1.ServiceSpec{
+				Type:      corev1.ServiceTypeClusterIP,
+				ClusterIP: "10.0.0.181",
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+				},
+			},
+		}
+
+		Expect(makeServiceInfo(&svc).NodePorts).To(BeNil())
+	})
 })
@@ -0,0 +1,154 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// ModeLocal makes load balance rules of an edge node only from endpoints on the node,
+	// requests to services without such endpoints are sent to cloud
+	ModeLocal = "local"
+	// ModeTopology prefers endpoints on the same edge node, then endpoints on edge nodes of
+	// the same communities, requests to services without both are sent to cloud
+	ModeTopology = "topology"
+
+	// AnnotationInternalTrafficPolicy stands for spec.internalTrafficPolicy of service which can't be read
+	// with the kubernetes API used by operator, Local means only endpoints on the same node are used
+	AnnotationInternalTrafficPolicy = "fabedge.io/internal-traffic-policy"
+	InternalTrafficPolicyLocal      = "Local"
+)
+
+// syncNodesToAgent puts nodes to keeper, their community peers are put too in topology mode
+// because they may use endpoints of those nodes. It must be called with lock held
+func (p *proxy) syncNodesToAgent(nodeNames sets.String) {
+	if p.mode == ModeTopology {
+		for _, name := range nodeNames.List() {
+			nodeNames.Insert(p.getCommunityPeers(name).UnsortedList()...)
+		}
+	}
+
+	for name := range nodeNames {
+		node, ok := p.nodeSet[name]
+		if !ok {
+			continue
+		}
+		p.keeper.AddNode(p.nodeForAgent(node))
+	}
+}
+
+// nodeForAgent returns the node whose service ports and endpoints are what its agent should use.
+// In topology mode, endpoints of community peers are added for service ports which have
+// no endpoints on the node. It must be called with lock held
+func (p *proxy) nodeForAgent(node EdgeNode) EdgeNode {
+	if p.mode != ModeTopology {
+		return node
+	}
+
+	peers := p.getCommunityPeers(node.Name)
+	if len(peers) == 0 {
+		return node
+	}
+
+	result := newEdgeNode(node.Name)
+	for spn, sp := range node.ServicePortMap {
+		result.ServicePortMap[spn] = sp
+	}
+	for spn, endpoints := range node.EndpointMap {
+		result.EndpointMap[spn] = endpoints
+	}
+
+	// peers are iterated in order, so the same service port is taken from the same peer every time
+	for _, peerName := range peers.List() {
+		peer := p.nodeSet[peerName]
+		for spn, endpoints := range peer.EndpointMap {
+			if len(node.EndpointMap[spn]) > 0 || len(endpoints) == 0 {
+				continue
+			}
+
+			if p.serviceMap[spn.NamespacedName].InternalTrafficPolicy == InternalTrafficPolicyLocal {
+				continue
+			}
+
+			sp, ok := peer.ServicePortMap[spn]
+			if !ok {
+				continue
+			}
+
+			merged := make(EndpointSet, len(result.EndpointMap[spn])+len(endpoints))
+			for ep := range result.EndpointMap[spn] {
+				merged.Add(ep)
+			}
+			for ep := range endpoints {
+				merged.Add(ep)
+			}
+
+			// requests to node ports of services whose external traffic policy is Local are
+			// only sent to endpoints on the node, so node ports are not served here
+			if sp.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+				sp.NodePort = 0
+			}
+
+			result.ServicePortMap[spn] = sp
+			result.EndpointMap[spn] = merged
+		}
+	}
+
+	return result
+}
+
+// getCommunityPeers returns names of edge nodes which are in the same communities with the node
+func (p *proxy) getCommunityPeers(nodeName string) sets.String {
+	peers := sets.NewString()
+	if p.store == nil {
+		return peers
+	}
+
+	epName := p.getEndpointName(nodeName)
+	for _, community := range p.store.GetCommunitiesByEndpoint(epName) {
+		for member := range community.Members {
+			peerName, ok := p.endpointToNode[member]
+			if !ok || peerName == nodeName {
+				continue
+			}
+			peers.Insert(peerName)
+		}
+	}
+
+	return peers
+}
+
+// watchCommunities syncs all nodes to agents when communities are changed, so endpoints
+// of community peers are updated in topology mode
+func (p *proxy) watchCommunities(ctx context.Context) error {
+	for {
+		select {
+		case <-p.store.Changed():
+			p.mu.Lock()
+			p.syncNodesToAgent(sets.StringKeySet(p.nodeSet))
+			p.mu.Unlock()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func getInternalTrafficPolicy(svc *corev1.Service) string {
+	return getValueByKey(svc.Annotations, AnnotationInternalTrafficPolicy)
+}
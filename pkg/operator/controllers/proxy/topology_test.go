@@ -0,0 +1,127 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+var _ = Describe("Proxy in topology mode", func() {
+	var (
+		px        *proxy
+		store     storepkg.Interface
+		serviceA  = ObjectKey{Name: "a", Namespace: "default"}
+		serviceB  = ObjectKey{Name: "b", Namespace: "default"}
+		spnA      = ServicePortName{NamespacedName: serviceA, Port: 80, Protocol: corev1.ProtocolTCP}
+		spnB      = ServicePortName{NamespacedName: serviceB, Port: 80, Protocol: corev1.ProtocolTCP}
+		endpointA = Endpoint{IP: "2.2.2.2", Port: 80}
+		endpointB = Endpoint{IP: "2.2.2.3", Port: 80}
+	)
+
+	BeforeEach(func() {
+		store = storepkg.NewStore()
+		store.SaveCommunity(types.Community{
+			Name:    "beijing",
+			Members: sets.NewString("edge1", "edge2"),
+		})
+
+		px = &proxy{
+			serviceMap:      make(ServiceMap),
+			nodeSet:         make(EdgeNodeSet),
+			mode:            ModeTopology,
+			store:           store,
+			getEndpointName: func(name string) string { return name },
+			endpointToNode:  make(map[string]string),
+		}
+		for _, name := range []string{"edge1", "edge2", "edge3"} {
+			px.addNode(name)
+		}
+
+		px.serviceMap[serviceA] = ServiceInfo{ClusterIP: "10.96.0.10"}
+		px.serviceMap[serviceB] = ServiceInfo{ClusterIP: "10.96.0.11", InternalTrafficPolicy: InternalTrafficPolicyLocal}
+
+		edge2 := px.nodeSet["edge2"]
+		edge2.ServicePortMap[spnA] = ServicePort{ClusterIP: "10.96.0.10", Port: 80, Protocol: corev1.ProtocolTCP}
+		edge2.EndpointMap[spnA] = EndpointSet{endpointA: Empty{}}
+		edge2.ServicePortMap[spnB] = ServicePort{ClusterIP: "10.96.0.11", Port: 80, Protocol: corev1.ProtocolTCP}
+		edge2.EndpointMap[spnB] = EndpointSet{endpointB: Empty{}}
+	})
+
+	It("should use endpoints of community peers if there is no endpoint on the node", func() {
+		node := px.nodeForAgent(px.nodeSet["edge1"])
+
+		Expect(node.ServicePortMap).To(HaveKey(spnA))
+		Expect(node.EndpointMap[spnA]).To(Equal(EndpointSet{endpointA: Empty{}}))
+	})
+
+	It("should prefer endpoints on the node", func() {
+		local := Endpoint{IP: "2.2.2.1", Port: 80}
+		edge1 := px.nodeSet["edge1"]
+		edge1.ServicePortMap[spnA] = ServicePort{ClusterIP: "10.96.0.10", Port: 80, Protocol: corev1.ProtocolTCP}
+		edge1.EndpointMap[spnA] = EndpointSet{local: Empty{}}
+
+		node := px.nodeForAgent(px.nodeSet["edge1"])
+		Expect(node.EndpointMap[spnA]).To(Equal(EndpointSet{local: Empty{}}))
+	})
+
+	It("should not use endpoints of community peers for services whose internal traffic policy is Local", func() {
+		node := px.nodeForAgent(px.nodeSet["edge1"])
+
+		Expect(node.ServicePortMap).NotTo(HaveKey(spnB))
+		Expect(node.EndpointMap).NotTo(HaveKey(spnB))
+	})
+
+	It("should not use endpoints of nodes out of communities", func() {
+		node := px.nodeForAgent(px.nodeSet["edge3"])
+
+		Expect(node.ServicePortMap).To(BeEmpty())
+		Expect(node.EndpointMap).To(BeEmpty())
+	})
+
+	It("should not serve node ports with endpoints of community peers if external traffic policy is Local", func() {
+		sp := px.nodeSet["edge2"].ServicePortMap[spnA]
+		sp.NodePort = 30080
+		sp.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+		px.nodeSet["edge2"].ServicePortMap[spnA] = sp
+
+		node := px.nodeForAgent(px.nodeSet["edge1"])
+		Expect(node.ServicePortMap[spnA].NodePort).To(BeZero())
+		Expect(px.nodeSet["edge2"].ServicePortMap[spnA].NodePort).To(Equal(int32(30080)))
+	})
+
+	It("should not change the node itself", func() {
+		px.nodeForAgent(px.nodeSet["edge1"])
+
+		Expect(px.nodeSet["edge1"].ServicePortMap).To(BeEmpty())
+		Expect(px.nodeSet["edge1"].EndpointMap).To(BeEmpty())
+	})
+
+	It("should put community peers to keeper in topology mode", func() {
+		px.keeper = &loadBalanceConfigKeeper{nodeSet: make(EdgeNodeSet)}
+
+		px.syncNodesToAgent(sets.NewString("edge2"))
+
+		Expect(px.keeper.nodeSet).To(HaveKey("edge1"))
+		Expect(px.keeper.nodeSet).To(HaveKey("edge2"))
+		Expect(px.keeper.nodeSet).NotTo(HaveKey("edge3"))
+		Expect(px.keeper.nodeSet["edge1"].EndpointMap[spnA]).To(Equal(EndpointSet{endpointA: Empty{}}))
+	})
+})
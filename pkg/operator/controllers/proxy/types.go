@@ -38,6 +38,13 @@ type ServiceInfo struct {
 	ClusterIP           string
 	SessionAffinity     corev1.ServiceAffinity
 	StickyMaxAgeSeconds int32
+	// InternalTrafficPolicy is taken from annotation, see AnnotationInternalTrafficPolicy
+	InternalTrafficPolicy string
+	// Scheduler is taken from annotation, empty means the default scheduler is used
+	Scheduler string
+	// NodePorts are node ports of service ports by their names, only NodePort and LoadBalancer services have them
+	NodePorts             map[string]int32
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType
 
 	EndpointMap     map[Port]EndpointSet
 	EndpointToNodes map[Endpoint]NodeName
@@ -58,6 +65,12 @@ type ServicePort struct {
 	Protocol            corev1.Protocol
 	StickyMaxAgeSeconds int32
 	SessionAffinity     corev1.ServiceAffinity
+	Scheduler           string
+
+	// PortName is the name of service port, node port is found by it
+	PortName              string
+	NodePort              int32
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType
 }
 
 func (s ServicePort) String() string {
@@ -84,6 +97,8 @@ type EndpointSliceInfo struct {
 	ServiceKey ObjectKey
 	Ports      PortSet
 	Endpoints  EndpointByIP
+	// PortNames are names of ports, which are the same as names of service ports
+	PortNames map[Port]string
 }
 
 type EdgeNode struct {
@@ -0,0 +1,115 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokencleaner
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctlpkg "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+const (
+	controllerName = "token-cleaner"
+)
+
+type Config struct {
+	Manager   manager.Manager
+	Namespace string
+}
+
+// tokenCleaner deletes secrets of expired tokens
+type tokenCleaner struct {
+	Config
+
+	client client.Client
+	log    logr.Logger
+}
+
+func AddToManager(cnf Config) error {
+	mgr := cnf.Manager
+
+	reconciler := &tokenCleaner{
+		Config: cnf,
+		client: mgr.GetClient(),
+		log:    mgr.GetLogger().WithName(controllerName),
+	}
+
+	ctl, err := ctlpkg.New(
+		controllerName,
+		mgr,
+		ctlpkg.Options{
+			Reconciler: tracing.WrapReconciler(controllerName, reconciler),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctl.Watch(
+		&source.Kind{Type: &corev1.Secret{}},
+		&handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == cnf.Namespace &&
+				obj.GetLabels()[constants.KeyFabedgeAPP] == tokenpkg.AppToken
+		}),
+	)
+}
+
+func (ctl *tokenCleaner) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log := ctl.log.WithValues("request", request)
+
+	var secret corev1.Secret
+	if err := ctl.client.Get(ctx, request.NamespacedName, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		log.Error(err, "failed to get token secret")
+		return reconcile.Result{}, err
+	}
+
+	if secret.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	expiresAt, err := tokenpkg.GetExpiresAt(secret)
+	if err != nil {
+		log.Error(err, "token secret has invalid expiration time, delete it")
+	} else if remaining := time.Until(expiresAt); remaining > 0 {
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	log.V(3).Info("token is expired, delete its secret")
+	if err = ctl.client.Delete(ctx, &secret); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to delete token secret")
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,329 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crl
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+// DefaultValidPeriod is how long a CRL is valid by default
+const DefaultValidPeriod = 7 * 24 * time.Hour
+
+// Revocation is a certificate revoked by administrator
+type Revocation struct {
+	// SerialNumber is in hex, the same as the output of "openssl x509 -serial"
+	SerialNumber string    `json:"serialNumber"`
+	RevokedAt    time.Time `json:"revokedAt"`
+}
+
+// Manager revokes certificates and keeps CRLs in a secret. Revoked certificates are only
+// recorded in CRLs, there is a CRL signed by each CA which has a key, so certificates of
+// both the old and the new CA can be revoked when CA is being rotated
+type Manager struct {
+	// SecretKey is the key of secret where CRLs are saved
+	SecretKey client.ObjectKey
+	// CASecretKey is the key of CA secret, CAs in it sign CRLs
+	CASecretKey client.ObjectKey
+	// CAKey signs CRLs instead of the key in CA secret if it's set, e.g. the key is kept in KMS or HSM
+	CAKey crypto.Signer
+	// ValidPeriod is how long a CRL is valid, CRLs are signed again when half of it passes.
+	// DefaultValidPeriod is used if it's 0
+	ValidPeriod time.Duration
+	Client      client.Client
+}
+
+type signer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// ParseSerialNumber parses a serial number in hex, colons are allowed, e.g. 1A:2B
+func ParseSerialNumber(value string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(strings.ReplaceAll(value, ":", ""), 16)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number: %s", value)
+	}
+
+	return n, nil
+}
+
+// FormatSerialNumber formats a serial number in hex
+func FormatSerialNumber(n *big.Int) string {
+	return fmt.Sprintf("%X", n)
+}
+
+// Revoke adds the certificate of serialNumber to CRLs, nothing is changed if it's revoked already
+func (m Manager) Revoke(ctx context.Context, serialNumber *big.Int) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, found, err := m.getSecret(ctx)
+		if err != nil {
+			return err
+		}
+
+		revoked, err := getRevoked(secret)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range revoked {
+			if r.SerialNumber.Cmp(serialNumber) == 0 {
+				return nil
+			}
+		}
+
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   serialNumber,
+			RevocationTime: time.Now().UTC(),
+		})
+
+		signers, err := m.getSigners(ctx)
+		if err != nil {
+			return err
+		}
+
+		return m.save(ctx, secret, found, signers, revoked)
+	})
+}
+
+// List returns all revoked certificates
+func (m Manager) List(ctx context.Context) ([]Revocation, error) {
+	secret, _, err := m.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := getRevoked(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := make([]Revocation, 0, len(revoked))
+	for _, r := range revoked {
+		revocations = append(revocations, Revocation{
+			SerialNumber: FormatSerialNumber(r.SerialNumber),
+			RevokedAt:    r.RevocationTime,
+		})
+	}
+
+	return revocations, nil
+}
+
+// IsRevoked checks if the certificate of serialNumber is revoked
+func (m Manager) IsRevoked(ctx context.Context, serialNumber *big.Int) (bool, error) {
+	secret, _, err := m.getSecret(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	revoked, err := getRevoked(secret)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range revoked {
+		if r.SerialNumber.Cmp(serialNumber) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetCRLPEM returns CRLs in PEM, it's nil if no CRL is made yet
+func (m Manager) GetCRLPEM(ctx context.Context) ([]byte, error) {
+	secret, _, err := m.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretutil.GetCRL(secret), nil
+}
+
+// Update makes CRLs if they don't exist, and signs them again if they are going to expire
+// or CAs are changed, e.g. CA is being rotated
+func (m Manager) Update(ctx context.Context) error {
+	secret, found, err := m.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	signers, err := m.getSigners(ctx)
+	if err != nil {
+		return err
+	}
+
+	crls, err := certutil.DecodeCRLsPEM(secretutil.GetCRL(secret))
+	if err != nil {
+		return err
+	}
+
+	if found && !m.needsUpdate(crls, signers) {
+		return nil
+	}
+
+	var revoked []pkix.RevokedCertificate
+	if len(crls) > 0 {
+		revoked = crls[0].TBSCertList.RevokedCertificates
+	}
+
+	return m.save(ctx, secret, found, signers, revoked)
+}
+
+func (m Manager) needsUpdate(crls []*pkix.CertificateList, signers []signer) bool {
+	if len(crls) != len(signers) {
+		return true
+	}
+
+	for i, crl := range crls {
+		if signers[i].cert.CheckCRLSignature(crl) != nil {
+			return true
+		}
+
+		if time.Until(crl.TBSCertList.NextUpdate) < m.validPeriod()/2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Manager) save(ctx context.Context, secret corev1.Secret, found bool, signers []signer, revoked []pkix.RevokedCertificate) error {
+	var crlPEM []byte
+	for _, s := range signers {
+		crlDER, err := certutil.NewCRL(s.cert, s.key, revoked, m.validPeriod())
+		if err != nil {
+			return err
+		}
+		crlPEM = append(crlPEM, certutil.EncodeCRLPEM(crlDER)...)
+	}
+
+	if !found {
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.SecretKey.Name,
+				Namespace: m.SecretKey.Namespace,
+				Labels: map[string]string{
+					constants.KeyCreatedBy: constants.AppOperator,
+				},
+			},
+			Data: map[string][]byte{
+				secretutil.KeyCRL: crlPEM,
+			},
+		}
+		return m.Client.Create(ctx, &secret)
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[secretutil.KeyCRL] = crlPEM
+
+	return m.Client.Update(ctx, &secret)
+}
+
+// getSecret returns the CRL secret, the bool is false if it's not found
+func (m Manager) getSecret(ctx context.Context) (corev1.Secret, bool, error) {
+	var secret corev1.Secret
+	err := m.Client.Get(ctx, m.SecretKey, &secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return secret, false, nil
+		}
+		return secret, false, err
+	}
+
+	return secret, true, nil
+}
+
+// getSigners returns CAs which sign CRLs, the new CA signs CRLs too when CA is being rotated
+func (m Manager) getSigners(ctx context.Context) ([]signer, error) {
+	var secret corev1.Secret
+	if err := m.Client.Get(ctx, m.CASecretKey, &secret); err != nil {
+		return nil, err
+	}
+
+	pairs := [][2][]byte{
+		{secret.Data[secretutil.KeyCACert], secret.Data[secretutil.KeyCAKey]},
+	}
+	if len(secret.Data[secretutil.KeyNewCACert]) > 0 && len(secret.Data[secretutil.KeyNewCAKey]) > 0 {
+		pairs = append(pairs, [2][]byte{secret.Data[secretutil.KeyNewCACert], secret.Data[secretutil.KeyNewCAKey]})
+	}
+
+	var signers []signer
+	for i, pair := range pairs {
+		certDER, err := certutil.DecodePEM(pair[0])
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 && m.CAKey != nil {
+			signers = append(signers, signer{cert: cert, key: m.CAKey})
+			continue
+		}
+
+		keyDER, err := certutil.DecodePEM(pair[1])
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := certutil.ParsePrivateKey(keyDER)
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, signer{cert: cert, key: key})
+	}
+
+	return signers, nil
+}
+
+func (m Manager) validPeriod() time.Duration {
+	if m.ValidPeriod > 0 {
+		return m.ValidPeriod
+	}
+
+	return DefaultValidPeriod
+}
+
+func getRevoked(secret corev1.Secret) ([]pkix.RevokedCertificate, error) {
+	crls, err := certutil.DecodeCRLsPEM(secretutil.GetCRL(secret))
+	if err != nil || len(crls) == 0 {
+		return nil, err
+	}
+
+	return crls[0].TBSCertList.RevokedCertificates, nil
+}
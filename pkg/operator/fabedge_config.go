@@ -0,0 +1,189 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+// LoadFabEdgeConfig overrides arguments with the spec of FabEdge resource if it exists.
+// Arguments are kept, so that a field removed from spec falls back to its argument.
+func (opts *Options) LoadFabEdgeConfig() error {
+	args := *opts
+	opts.args = &args
+
+	if opts.FabEdgeName == "" {
+		return nil
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		log.Error(err, "failed to load kubeconfig")
+		return err
+	}
+
+	cli, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		log.Error(err, "failed to create kube client")
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var fabedge apis.FabEdge
+	err = cli.Get(ctx, client.ObjectKey{Name: opts.FabEdgeName}, &fabedge)
+	switch {
+	case err == nil:
+		log.Info("arguments are overridden by FabEdge resource", "name", opts.FabEdgeName)
+		opts.applyFabEdgeSpec(fabedge.Spec)
+		return nil
+	case errors.IsNotFound(err):
+		log.Info("FabEdge resource is not found, use arguments only", "name", opts.FabEdgeName)
+		return nil
+	case meta.IsNoMatchError(err):
+		log.Error(err, "FabEdge CRD is not installed")
+		return err
+	default:
+		log.Error(err, "failed to get FabEdge resource", "name", opts.FabEdgeName)
+		return err
+	}
+}
+
+// withFabEdgeSpec returns options made of arguments and spec, they are normalized like
+// Complete does, so they can be compared with the running options
+func (opts *Options) withFabEdgeSpec(spec apis.FabEdgeSpec) *Options {
+	o := *opts.args
+	o.applyFabEdgeSpec(spec)
+	o.normalize()
+	return &o
+}
+
+// normalize cleans up values of options which are compared with those of FabEdge spec,
+// it's called by Complete, so it must not have side effects
+func (opts *Options) normalize() {
+	opts.CNIType = strings.TrimSpace(opts.CNIType)
+}
+
+func (opts *Options) applyFabEdgeSpec(spec apis.FabEdgeSpec) {
+	if len(spec.EdgeLabels) > 0 {
+		opts.EdgeLabels = spec.EdgeLabels
+	}
+	if spec.EdgePodCIDR != "" {
+		opts.EdgePodCIDR = spec.EdgePodCIDR
+	}
+	if spec.CNIType != "" {
+		opts.CNIType = spec.CNIType
+	}
+	if spec.EndpointIDFormat != "" {
+		opts.EndpointIDFormat = spec.EndpointIDFormat
+	}
+	if spec.CertValidPeriod != nil {
+		opts.CertValidPeriod = *spec.CertValidPeriod
+	}
+	if spec.CertOrganization != "" {
+		opts.CertOrganization = spec.CertOrganization
+	}
+
+	connector := spec.Connector
+	if len(connector.PublicAddresses) > 0 {
+		opts.Connector.Endpoint.PublicAddresses = connector.PublicAddresses
+	}
+	if len(connector.Subnets) > 0 {
+		opts.Connector.ProvidedSubnets = connector.Subnets
+	}
+	if len(connector.Labels) > 0 {
+		opts.Connector.ConnectorLabels = connector.Labels
+	}
+
+	agent := spec.Agent
+	if agent.Image != "" {
+		opts.Agent.AgentImage = agent.Image
+	}
+	if agent.StrongswanImage != "" {
+		opts.Agent.StrongswanImage = agent.StrongswanImage
+	}
+	if agent.ImagePullPolicy != "" {
+		opts.Agent.ImagePullPolicy = agent.ImagePullPolicy
+	}
+	if agent.LogLevel != nil {
+		opts.Agent.AgentLogLevel = *agent.LogLevel
+	}
+	if agent.UseXfrm != nil {
+		opts.Agent.UseXfrm = *agent.UseXfrm
+	}
+	if agent.MasqOutgoing != nil {
+		opts.Agent.MasqOutgoing = *agent.MasqOutgoing
+	}
+	if agent.EnableProxy != nil {
+		opts.Agent.EnableProxy = *agent.EnableProxy
+	}
+	if agent.DetectKubeProxy != nil {
+		opts.Agent.DetectKubeProxy = *agent.DetectKubeProxy
+	}
+	if agent.EnableHairpinMode != nil {
+		opts.Agent.EnableEdgeHairpinMode = *agent.EnableHairpinMode
+	}
+	if agent.NetworkPluginMTU != nil {
+		opts.Agent.NetworkPluginMTU = *agent.NetworkPluginMTU
+	}
+	if agent.NodeCondition != "" {
+		opts.Agent.NodeCondition = agent.NodeCondition
+	}
+	if agent.SubnetsPerChildSA != nil {
+		opts.Agent.SubnetsPerChildSA = *agent.SubnetsPerChildSA
+	}
+}
+
+// restartRequiredFields returns the fields of FabEdge spec whose values in desired differ
+// from the running ones and can't be applied at runtime
+func (opts *Options) restartRequiredFields(desired *Options) []string {
+	fields := []struct {
+		name           string
+		running, value interface{}
+	}{
+		{"edgeLabels", opts.EdgeLabels, desired.EdgeLabels},
+		{"edgePodCIDR", opts.EdgePodCIDR, desired.EdgePodCIDR},
+		{"cniType", opts.CNIType, desired.CNIType},
+		{"endpointIDFormat", opts.EndpointIDFormat, desired.EndpointIDFormat},
+		{"certValidPeriod", opts.CertValidPeriod, desired.CertValidPeriod},
+		{"certOrganization", opts.CertOrganization, desired.CertOrganization},
+		{"connector.publicAddresses", opts.Connector.Endpoint.PublicAddresses, desired.Connector.Endpoint.PublicAddresses},
+		{"connector.subnets", opts.Connector.ProvidedSubnets, desired.Connector.ProvidedSubnets},
+		{"connector.labels", opts.Connector.ConnectorLabels, desired.Connector.ConnectorLabels},
+		{"agent.enableProxy", opts.Agent.EnableProxy, desired.Agent.EnableProxy},
+		{"agent.detectKubeProxy", opts.Agent.DetectKubeProxy, desired.Agent.DetectKubeProxy},
+	}
+
+	var names []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.running, f.value) {
+			names = append(names, f.name)
+		}
+	}
+
+	return names
+}
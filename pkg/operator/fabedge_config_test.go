@@ -0,0 +1,68 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+var _ = Describe("FabEdgeConfig", func() {
+	var opts *Options
+
+	BeforeEach(func() {
+		opts = &Options{}
+		flags := pflag.NewFlagSet("operator", pflag.ContinueOnError)
+		opts.AddFlags(flags)
+		Expect(flags.Parse([]string{
+			"--cni-type= calico ",
+			"--edge-pod-cidr=10.10.0.0/16",
+		})).To(Succeed())
+
+		// no FabEdge name, so only arguments are kept
+		Expect(opts.LoadFabEdgeConfig()).To(Succeed())
+		// Complete normalizes running options the same way
+		opts.normalize()
+	})
+
+	It("should not require restart when spec is empty", func() {
+		desired := opts.withFabEdgeSpec(apis.FabEdgeSpec{})
+		Expect(opts.restartRequiredFields(desired)).To(BeEmpty())
+	})
+
+	It("should not require restart when spec is the same as arguments", func() {
+		validPeriod := opts.CertValidPeriod
+		desired := opts.withFabEdgeSpec(apis.FabEdgeSpec{
+			EdgeLabels:       opts.EdgeLabels,
+			EdgePodCIDR:      "10.10.0.0/16",
+			CNIType:          "calico",
+			EndpointIDFormat: opts.EndpointIDFormat,
+			CertValidPeriod:  &validPeriod,
+			CertOrganization: opts.CertOrganization,
+		})
+		Expect(opts.restartRequiredFields(desired)).To(BeEmpty())
+	})
+
+	It("should report fields which are changed by spec", func() {
+		desired := opts.withFabEdgeSpec(apis.FabEdgeSpec{
+			EdgePodCIDR: "10.20.0.0/16",
+			CNIType:     "calico",
+		})
+		Expect(opts.restartRequiredFields(desired)).To(ConsistOf("edgePodCIDR"))
+	})
+})
@@ -0,0 +1,147 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var supportedResourceLocks = []string{
+	resourcelock.ConfigMapsResourceLock,
+	resourcelock.LeasesResourceLock,
+	resourcelock.ConfigMapsLeasesResourceLock,
+}
+
+func isSupportedResourceLock(lock string) bool {
+	for _, l := range supportedResourceLocks {
+		if l == lock {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkLeaderElectionMigration prevents two leaders when lock type is changed directly
+// between configmaps and leases, e.g. during a rolling update the old operator may hold
+// the lock of another type. Such migration should go through configmapsleases, which
+// holds both locks with the same identity, so a holder found in both locks is safe to
+// compete with. The operator refuses to start only when the other lock is held by someone
+// who doesn't hold the lock of current type, until that lock is expired.
+func (opts *Options) checkLeaderElectionMigration(cli client.Client) error {
+	if !opts.ManagerOpts.LeaderElection {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := client.ObjectKey{
+		Name:      opts.ManagerOpts.LeaderElectionID,
+		Namespace: opts.ManagerOpts.LeaderElectionNamespace,
+	}
+
+	var (
+		getHolder, getOtherHolder func(context.Context, client.Client, client.ObjectKey) (string, error)
+		otherLock                 string
+	)
+	switch opts.ManagerOpts.LeaderElectionResourceLock {
+	case resourcelock.LeasesResourceLock:
+		otherLock = resourcelock.ConfigMapsResourceLock
+		getHolder, getOtherHolder = getLeaseLockHolder, getConfigMapLockHolder
+	case resourcelock.ConfigMapsResourceLock:
+		otherLock = resourcelock.LeasesResourceLock
+		getHolder, getOtherHolder = getConfigMapLockHolder, getLeaseLockHolder
+	default:
+		return nil
+	}
+
+	otherHolder, err := getOtherHolder(ctx, cli, key)
+	if err != nil || otherHolder == "" {
+		return err
+	}
+
+	holder, err := getHolder(ctx, cli, key)
+	if err != nil {
+		return err
+	}
+
+	if holder != otherHolder {
+		return fmt.Errorf("%s lock %s is held by %s, migrate lock type through %s first or wait until it expires",
+			otherLock, key, otherHolder, resourcelock.ConfigMapsLeasesResourceLock)
+	}
+
+	return nil
+}
+
+// getConfigMapLockHolder returns the holder of configmap lock if it's not expired
+func getConfigMapLockHolder(ctx context.Context, cli client.Client, key client.ObjectKey) (string, error) {
+	var cm corev1.ConfigMap
+	if err := cli.Get(ctx, key, &cm); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	value, ok := cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
+	if !ok {
+		return "", nil
+	}
+
+	var record resourcelock.LeaderElectionRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return "", err
+	}
+
+	expireTime := record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second)
+	if record.HolderIdentity == "" || time.Now().After(expireTime) {
+		return "", nil
+	}
+
+	return record.HolderIdentity, nil
+}
+
+// getLeaseLockHolder returns the holder of lease lock if it's not expired
+func getLeaseLockHolder(ctx context.Context, cli client.Client, key client.ObjectKey) (string, error) {
+	var lease coordinationv1.Lease
+	if err := cli.Get(ctx, key, &lease); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return "", nil
+	}
+
+	expireTime := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	if time.Now().After(expireTime) {
+		return "", nil
+	}
+
+	return *spec.HolderIdentity, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("checkLeaderElectionMigration", func() {
+	const (
+		lockName      = "fabedge-operator-leader"
+		lockNamespace = "fabedge"
+	)
+
+	var opts *Options
+
+	newConfigMapLock := func(holder string, renewTime time.Time) client.Object {
+		record, err := json.Marshal(resourcelock.LeaderElectionRecord{
+			HolderIdentity:       holder,
+			LeaseDurationSeconds: 15,
+			RenewTime:            metav1.NewTime(renewTime),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      lockName,
+				Namespace: lockNamespace,
+				Annotations: map[string]string{
+					resourcelock.LeaderElectionRecordAnnotationKey: string(record),
+				},
+			},
+		}
+	}
+
+	newLeaseLock := func(holder string, renewTime time.Time) client.Object {
+		rt := metav1.NewMicroTime(renewTime)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      lockName,
+				Namespace: lockNamespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       pointer.StringPtr(holder),
+				LeaseDurationSeconds: pointer.Int32Ptr(15),
+				RenewTime:            &rt,
+			},
+		}
+	}
+
+	check := func(objects ...client.Object) error {
+		cli := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()
+		return opts.checkLeaderElectionMigration(cli)
+	}
+
+	BeforeEach(func() {
+		opts = &Options{}
+		opts.ManagerOpts.LeaderElection = true
+		opts.ManagerOpts.LeaderElectionID = lockName
+		opts.ManagerOpts.LeaderElectionNamespace = lockNamespace
+		opts.ManagerOpts.LeaderElectionResourceLock = resourcelock.LeasesResourceLock
+	})
+
+	It("should pass when the other lock doesn't exist", func() {
+		Expect(check()).To(Succeed())
+	})
+
+	It("should pass when the other lock is expired", func() {
+		Expect(check(newConfigMapLock("old", time.Now().Add(-time.Minute)))).To(Succeed())
+	})
+
+	It("should pass when the holder of the other lock holds both locks", func() {
+		now := time.Now()
+		Expect(check(newConfigMapLock("old", now), newLeaseLock("old", now))).To(Succeed())
+
+		opts.ManagerOpts.LeaderElectionResourceLock = resourcelock.ConfigMapsResourceLock
+		Expect(check(newConfigMapLock("old", now), newLeaseLock("old", now))).To(Succeed())
+	})
+
+	It("should fail when only the other lock is held", func() {
+		Expect(check(newConfigMapLock("old", time.Now()))).NotTo(Succeed())
+
+		opts.ManagerOpts.LeaderElectionResourceLock = resourcelock.ConfigMapsResourceLock
+		Expect(check(newLeaseLock("old", time.Now()))).NotTo(Succeed())
+	})
+
+	It("should fail when the other lock is held by someone else", func() {
+		now := time.Now()
+		Expect(check(newConfigMapLock("old", now), newLeaseLock("new", now))).NotTo(Succeed())
+	})
+
+	It("should skip checking when lock type is configmapsleases", func() {
+		opts.ManagerOpts.LeaderElectionResourceLock = resourcelock.ConfigMapsLeasesResourceLock
+		Expect(check(newConfigMapLock("old", time.Now()))).To(Succeed())
+	})
+})
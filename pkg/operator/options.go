@@ -15,22 +15,30 @@
 package operator
 
 import (
+	"bytes"
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2/klogr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -41,20 +49,35 @@ import (
 	"github.com/fabedge/fabedge/pkg/common/constants"
 	"github.com/fabedge/fabedge/pkg/operator/allocator"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	"github.com/fabedge/fabedge/pkg/operator/audit"
+	"github.com/fabedge/fabedge/pkg/operator/certledger"
 	fclient "github.com/fabedge/fabedge/pkg/operator/client"
 	agentctl "github.com/fabedge/fabedge/pkg/operator/controllers/agent"
+	autocmmctl "github.com/fabedge/fabedge/pkg/operator/controllers/autocommunity"
 	clusterctl "github.com/fabedge/fabedge/pkg/operator/controllers/cluster"
 	cmmctl "github.com/fabedge/fabedge/pkg/operator/controllers/community"
+	checkctl "github.com/fabedge/fabedge/pkg/operator/controllers/connectivitycheck"
 	connectorctl "github.com/fabedge/fabedge/pkg/operator/controllers/connector"
+	"github.com/fabedge/fabedge/pkg/operator/controllers/csrsigner"
+	fabedgectl "github.com/fabedge/fabedge/pkg/operator/controllers/fabedgeconfig"
 	"github.com/fabedge/fabedge/pkg/operator/controllers/ipamblockmonitor"
+	kubeedgectl "github.com/fabedge/fabedge/pkg/operator/controllers/kubeedge"
+	openyurtctl "github.com/fabedge/fabedge/pkg/operator/controllers/openyurt"
 	proxyctl "github.com/fabedge/fabedge/pkg/operator/controllers/proxy"
+	"github.com/fabedge/fabedge/pkg/operator/controllers/tokencleaner"
+	crlpkg "github.com/fabedge/fabedge/pkg/operator/crl"
 	"github.com/fabedge/fabedge/pkg/operator/routines"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	tokenpkg "github.com/fabedge/fabedge/pkg/operator/token"
 	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
 	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/fips"
 	nodeutil "github.com/fabedge/fabedge/pkg/util/node"
 	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+	"github.com/fabedge/fabedge/pkg/util/topology"
+	"github.com/fabedge/fabedge/pkg/util/tracing"
 	"github.com/fabedge/fabedge/third_party/calicoapi"
 )
 
@@ -62,12 +85,27 @@ const (
 	RoleHost   = "host"
 	RoleMember = "member"
 
+	ConnectorShardingLabel = "label"
+	ConnectorShardingHash  = "hash"
+
 	ClientTLSSecretName = "api-client-tls"
+	// KeyInitToken is the key of init token in the secret specified by init-token-secret
+	KeyInitToken = "token"
 )
 
 var dns1123Reg, _ = regexp.Compile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
 
 type Options struct {
+	// FabEdgeName is the name of FabEdge resource which overrides arguments, empty means disabled
+	FabEdgeName string
+	// TeardownOnFabEdgeDeletion makes operator remove everything fabedge made when
+	// FabEdge resource is deleted, FabEdge resource is kept until it's finished
+	TeardownOnFabEdgeDeletion bool
+	// Teardown makes operator remove everything fabedge made and exit
+	Teardown bool
+	// args holds the options made of arguments only
+	args *Options
+
 	Cluster string
 	// ClusterRole will determine how operator will be running:
 	// Host: operator will start an API server
@@ -78,13 +116,45 @@ type Options struct {
 	EndpointIDFormat string
 	EdgeLabels       map[string]string
 	CNIType          string
+	// SyncGlobalNetworkSets makes operator maintain calico GlobalNetworkSets for
+	// communities and clusters, only works with calico
+	SyncGlobalNetworkSets bool
+	// OpenYurt makes NodePools groups of edge nodes: a community is made for each NodePool and
+	// public addresses in annotation of NodePools are put on their edge nodes
+	OpenYurt bool
+	// FailoverDrill is disabled if its interval is 0
+	FailoverDrill routines.FailoverDrill
+	DrillWindow   string
+	// EnableConnectivityCheck makes operator run ConnectivityChecks by probe pods of agent image
+	EnableConnectivityCheck bool
+	// Prober is disabled if its interval is 0
+	Prober routines.ConnectivityProber
 
 	CASecretName     string
+	CRLSecretName    string
 	CertValidPeriod  int64
 	CertOrganization string
-	Agent            agentctl.Config
-	Connector        connectorctl.Config
-	Proxy            proxyctl.Config
+	CertKeyType      string
+	// CertKeySize is the size of keys of certificates and new CAs, see certutil.NewPrivateKey
+	CertKeySize int
+	// CertSignatureAlgorithm is the algorithm to sign certificate requests, e.g. SHA384-RSA
+	// or ECDSA-SHA384. Empty means it's chosen according to CertKeyType
+	CertSignatureAlgorithm string
+	Agent                  agentctl.Config
+	Connector              connectorctl.Config
+	Proxy                  proxyctl.Config
+	ClusterCtl             clusterctl.Config
+	AutoCommunity          autocmmctl.Config
+	// ExtraConnectors holds public addresses of non-default connectors, the key is connector name
+	// and the value is addresses separated by semicolon
+	ExtraConnectors       map[string]string
+	ExtraConnectorConfigs []connectorctl.Config
+	// MemberlistTLS makes operator issue a TLS certificate for cloud agents and maintain identities
+	// of connectors and cloud agents as allowed peers of memberlist
+	MemberlistTLS bool
+	// ConnectorSharding is how edge nodes without connector label are assigned, label means they
+	// are assigned to the default connector, hash means they are spread among all connectors
+	ConnectorSharding string
 
 	ManagerOpts manager.Options
 
@@ -92,31 +162,135 @@ type Options struct {
 	APIServerKeyFile       string
 	APIServerListenAddress string
 	APIServerAddress       string
-	TokenValidPeriod       time.Duration
-	InitToken              string
+	// GRPCServerListenAddress is the address on which gRPC variant of API server listens,
+	// empty means gRPC API is not served
+	GRPCServerListenAddress string
+	TokenValidPeriod        time.Duration
+	InitToken               string
+	// InitTokenFile is the file which contains init token, e.g. a projected bound service account token
+	InitTokenFile string
+	// InitTokenSecret is the name of secret which contains init token in key "token"
+	InitTokenSecret string
+	// AcceptServiceAccountTokens lets API server accept bound service account tokens of member
+	// clusters whose issuers are recorded in cluster resources
+	AcceptServiceAccountTokens  bool
+	ServiceAccountTokenAudience string
+	// ServiceAccountTokenSubject is the service account of member clusters' operators, only its tokens are accepted
+	ServiceAccountTokenSubject string
+	// HeartbeatInterval is the interval for member cluster to report heartbeat to host cluster
+	HeartbeatInterval time.Duration
+	// EndpointsWatchTimeout is how long each watch request of member cluster waits for changes of endpoints
+	// and communities, 0 means member cluster polls them every 10 seconds
+	EndpointsWatchTimeout time.Duration
+	// EndpointsPageSize is the max number of endpoints in each response when member cluster gets
+	// endpoints from host cluster, 0 means all endpoints are got by one request
+	EndpointsPageSize int
+	// APIServerStream makes host cluster push endpoints and communities through a long-lived stream.
+	// Other requests share the connection of stream only if HTTP/2 is negotiated
+	APIServerStream bool
+	// APIServerRateLimitQPS is how many requests per second each client can send to API server, 0 means no limit
+	APIServerRateLimitQPS   float64
+	APIServerRateLimitBurst int
+	// AuditMaxRecords is how many audit records are kept for each member cluster
+	AuditMaxRecords int
+	// CertLedgerMaxEntries is how many signed certificates are kept in ledger, 0 means ledger is disabled.
+	// CertLedgerRetention is how long they are kept after they expire, 0 means forever
+	CertLedgerMaxEntries int
+	CertLedgerRetention  time.Duration
+	// WebhookURL is where lifecycle events of member clusters are posted, empty means no events are sent
+	WebhookURL string
+	// WebhookSecretFile is the file which contains the secret to sign webhooks
+	WebhookSecretFile string
+	// AlertWebhookURL is where alerts of tunnels and member clusters are posted, empty means no alerts are sent
+	AlertWebhookURL string
+	// AlertWebhookFormat is how alerts are encoded, json or slack
+	AlertWebhookFormat string
+	// AlertWebhookSecretFile is the file which contains the secret to sign alerts, alerts are not signed if it's empty
+	AlertWebhookSecretFile string
+	// AlertThreshold is how long a tunnel stays down or a member cluster stops exporting before it's alerted
+	AlertThreshold time.Duration
+	// ServeTopology makes operator serve the topology of fabric at /topology of the metrics server
+	ServeTopology bool
+	// CARotationDistributePeriod is the least time to distribute the new CA to everyone before it signs
+	// certificates when CA is being rotated
+	CARotationDistributePeriod time.Duration
+	// AgentCertValidPeriod is the validity period of agents' certificates, 0 means CertValidPeriod is used.
+	// Agents renew short-lived certificates in place if Agent.APIServerAddress is provided
+	AgentCertValidPeriod time.Duration
+	// SPIFFETrustDomain makes endpoint IDs SPIFFE IDs in this trust domain, which are embedded
+	// in certificates of agents and connectors. Empty means EndpointIDFormat is used
+	SPIFFETrustDomain string
+	// CAKeySignerCommand is the command which signs by CA key kept in KMS or HSM, see
+	// certutil.NewExternalSigner. Empty means CA key is read from CA secret
+	CAKeySignerCommand string
+	// CertRenewalWindow is how long before expiry a certificate made by operator is reported as expiring,
+	// by events and condition of FabEdge resource. 0 means certificate expiry is not monitored
+	CertRenewalWindow time.Duration
+	// FIPSMode restricts TLS, certificates and IPsec proposals to FIPS approved algorithms,
+	// it's passed to agents too
+	FIPSMode bool
+	// PrintRBAC makes operator print the least RBAC rules it needs with the other arguments and exit
+	PrintRBAC bool
+	// Tracing exports spans of reconciles, certificate signing and requests between host and member
+	// clusters by OTLP, it's disabled if Tracing.Endpoint is empty
+	Tracing tracing.Config
 
 	Store        storepkg.Interface
 	PodCIDRStore types.PodCIDRStore
 	NewEndpoint  types.NewEndpointFunc
 	Manager      manager.Manager
 	APIServer    *http.Server
+	GRPCServer   *grpc.Server
 	APIClient    fclient.Interface
-	PrivateKey   *rsa.PrivateKey
+	PrivateKey   crypto.Signer
+	// CRL manages revoked certificates of host cluster, it's nil in member clusters
+	CRL *crlpkg.Manager
+	// CertLedger records certificates signed by operator, nil means disabled
+	CertLedger certledger.Ledger
+
+	// apiClientTransport and apiClientCert are used to reload API client after its certificate is renewed
+	apiClientTransport *http.Transport
+	apiClientCert      *fclient.CertificateStore
 }
 
 func (opts *Options) AddFlags(flag *pflag.FlagSet) {
+	flag.StringVar(&opts.FabEdgeName, "fabedge-name", "", "The name of FabEdge resource which holds configurations of operator, its fields override arguments. Leave it empty to use arguments only")
+	flag.BoolVar(&opts.TeardownOnFabEdgeDeletion, "teardown-on-fabedge-deletion", false, "Remove agent pods, configmaps, secrets, node annotations and network settings on edge nodes made by fabedge when FabEdge resource is deleted")
+	flag.BoolVar(&opts.Teardown, "teardown", false, "Remove agent pods, configmaps, secrets, node annotations and network settings on edge nodes made by fabedge, then exit. It's used to uninstall fabedge")
+	flag.BoolVar(&opts.PrintRBAC, "print-rbac", false, "Print ClusterRoles, Roles and bindings with the least rules which operator and agents need with the other arguments, then exit")
 	flag.StringVar(&opts.Cluster, "cluster", "", "The name of cluster must be unique among all clusters and be a valid dns name(RFC 1123)")
 	flag.StringVar(&opts.ClusterRole, "cluster-role", "host", "The role of cluster, possible values are: host, member")
 	flag.StringVar(&opts.Namespace, "namespace", "fabedge", "The namespace in which operator will get or create objects, includes pods, secrets and configmaps")
 	flag.StringVar(&opts.CNIType, "cni-type", "", "The CNI name in your kubernetes cluster")
+	flag.BoolVar(&opts.SyncGlobalNetworkSets, "sync-global-network-sets", false, "Maintain calico GlobalNetworkSets for each community and cluster, so network policies can select traffic by them. Only works with calico")
+	flag.DurationVar(&opts.FailoverDrill.Interval, "drill-interval", 0, "The interval to run connector failover drills, 0 means drills are disabled")
+	flag.StringVar(&opts.DrillWindow, "drill-window", "", "The daily time window in which drills can run, e.g. 02:00-04:00, empty means the whole day")
+	flag.DurationVar(&opts.FailoverDrill.Timeout, "drill-timeout", 5*time.Minute, "The max time to wait for connector to recover in a drill")
+	flag.IntVar(&opts.FailoverDrill.ReportsToKeep, "drill-reports-to-keep", 10, "The number of latest drill reports to keep")
+	flag.BoolVar(&opts.EnableConnectivityCheck, "enable-connectivity-check", false, "Run ConnectivityChecks, probe pods are launched on nodes of a check to test connectivity between each pair of them")
+	flag.DurationVar(&opts.Prober.Interval, "prober-interval", 0, "How often probers test pods of other probers and the prober service, probers are deployed by a DaemonSet of agent image on edge nodes and nodes of connectors. 0 means probers are not deployed")
+	flag.DurationVar(&opts.Prober.Timeout, "prober-timeout", 3*time.Second, "How long a prober waits for the reply of a test")
+	flag.Int32Var(&opts.Prober.Port, "prober-port", 8790, "The TCP and UDP port which probers listen on")
+	flag.Int32Var(&opts.Prober.MetricsPort, "prober-metrics-port", 8791, "The port on which probers serve /metrics")
 	flag.StringVar(&opts.EdgePodCIDR, "edge-pod-cidr", "", "Specify range of IP addresses for the edge pod. If set, fabedge-operator will automatically allocate CIDRs for every edge node, configure this when you use Calico")
 	flag.StringVar(&opts.EndpointIDFormat, "endpoint-id-format", "C=CN, O=fabedge.io, CN={node}", "the id format of tunnel endpoint")
+	flag.StringVar(&opts.SPIFFETrustDomain, "spiffe-trust-domain", "", "The SPIFFE trust domain, e.g. example.org. If set, endpoint IDs are SPIFFE IDs like spiffe://example.org/<cluster>/<node> instead of endpoint-id-format, and they are embedded in certificates of agents and connectors. All clusters should use the same trust domain")
 	flag.StringToStringVar(&opts.EdgeLabels, "edge-labels", map[string]string{"node-role.kubernetes.io/edge": ""}, "Labels to filter edge nodes, e.g. key2=,key3=value3")
+	flag.BoolVar(&opts.Agent.KubeEdge, "kubeedge", false, "Integrate with KubeEdge: nodes managed by edgecore are labeled with edge-labels, agents on them access API through kubeedge-metaserver-address when kube-apiserver can't be reached, and the proxy feature is disabled on edge nodes where EdgeMesh daemonset kubeedge/edgemesh-agent is running")
+	flag.StringVar(&opts.Agent.MetaServerAddress, "kubeedge-metaserver-address", "http://127.0.0.1:10550", "The address of MetaServer of edgecore on edge nodes, it has to be enabled in edgecore.yaml. Leave it empty to not use MetaServer")
+	flag.BoolVar(&opts.Agent.SuperEdge, "superedge", false, "Integrate with SuperEdge: agents on edge nodes where tunnel-edge is running access API through superedge-lite-apiserver-address when kube-apiserver can't be reached, and the firewall of agents accepts checks of edge-health if edge-health is running")
+	flag.StringVar(&opts.Agent.LiteAPIServerAddress, "superedge-lite-apiserver-address", "https://127.0.0.1:51003", "The address of lite-apiserver on edge nodes of SuperEdge. Leave it empty to not use lite-apiserver")
+	flag.StringVar(&opts.Agent.SuperEdgeNamespace, "superedge-namespace", "edge-system", "The namespace where daemonsets tunnel-edge and edge-health of SuperEdge are deployed")
 
 	flag.StringToStringVar(&opts.Connector.ConnectorLabels, "connector-labels", map[string]string{"app": "fabedge-connector"}, "The labels used to find connector pods, e.g. key2=,key3=value3")
 	flag.StringSliceVar(&opts.Connector.Endpoint.PublicAddresses, "connector-public-addresses", nil, "The connector's public addresses which should be accessible for every edge node, comma separated. Takes single IPv4 addresses, DNS names")
 	flag.StringSliceVar(&opts.Connector.ProvidedSubnets, "connector-subnets", nil, "The subnets of connector, mostly the CIDRs to assign pod IP and service ClusterIP")
 	flag.DurationVar(&opts.Connector.SyncInterval, "connector-config-sync-interval", 5*time.Second, "The interval to synchronize connector configmap")
+	flag.IntVar(&opts.Connector.MaxConcurrentReconciles, "connector-max-concurrent-reconciles", 1, "The max number of concurrent reconciles of connector controller")
+	flag.BoolVar(&opts.Connector.ConfigResource, "connector-config-resource", false, "Maintain ConnectorConfig resources besides connector configmaps, so connectors can get their config through API by --connector-config. CRD deploy/crds/fabedge.io_connectorconfigs.yaml is needed")
+	flag.StringToStringVar(&opts.ExtraConnectors, "extra-connectors", nil, "The names and public addresses of extra connectors, addresses are separated by semicolon, e.g. east=10.0.0.1;east.example.com,west=10.0.1.1. Edge nodes are assigned to a connector by label fabedge.io/connector")
+	flag.StringVar(&opts.ConnectorSharding, "connector-sharding", ConnectorShardingLabel, "How edge nodes without a known fabedge.io/connector label are assigned to connectors: label, to the default connector, or hash, spread among the default and extra connectors by consistent hashing")
+	flag.BoolVar(&opts.MemberlistTLS, "memberlist-tls", false, "Issue a TLS certificate for cloud agents in secret cloud-agent-tls and save identities of connectors and cloud agents in configmap memberlist-peers, which are used by mutual TLS of memberlist between connectors and cloud agents")
 
 	flag.StringVar(&opts.Agent.AgentImage, "agent-image", "fabedge/agent:latest", "The image of agent container of agent pod")
 	flag.StringVar(&opts.Agent.StrongswanImage, "agent-strongswan-image", "fabedge/strongswan:latest", "The image of strongswan container of agent pod")
@@ -124,35 +298,133 @@ func (opts *Options) AddFlags(flag *pflag.FlagSet) {
 	flag.IntVar(&opts.Agent.AgentLogLevel, "agent-log-level", 3, "The log level of agent")
 	flag.BoolVar(&opts.Agent.UseXfrm, "agent-use-xfrm", false, "let agent use xfrm if edge OS supports")
 	flag.BoolVar(&opts.Agent.EnableProxy, "agent-enable-proxy", false, "Enable the proxy feature")
+	flag.BoolVar(&opts.Agent.DetectKubeProxy, "agent-detect-kube-proxy", false, "Disable the proxy feature on edge nodes where kube-proxy is running, only works when agent-enable-proxy is true")
 	flag.BoolVar(&opts.Agent.MasqOutgoing, "agent-masq-outgoing", false, "Determine if perform outbound NAT from edge pods to outside of the cluster")
+	flag.StringSliceVar(&opts.Agent.MasqueradeCIDRs, "agent-masquerade-cidrs", nil, "The destination CIDRs which outbound NAT of edge pods is only performed to, e.g. 0.0.0.0/0. Leave it empty to masquerade traffic to anywhere outside the cluster. Edge nodes can override it by annotation fabedge.io/masquerade-cidrs. Only works when agent-masq-outgoing is true")
+	flag.StringSliceVar(&opts.Agent.NonMasqueradeCIDRs, "agent-non-masquerade-cidrs", nil, "The destination CIDRs which outbound NAT of edge pods is never performed to, e.g. 192.168.0.0/16, so devices there can reach edge pods back. Edge nodes can override it by annotation fabedge.io/non-masquerade-cidrs. Only works when agent-masq-outgoing is true")
+	flag.StringVar(&opts.Agent.LocalIngressInterface, "agent-local-ingress-interface", "", "The LAN interface of edge nodes where agents expose services annotated with fabedge.io/local-ingress-ports, e.g. eth1, devices of edge sites reach those services through tunnels. Edge nodes can override it by annotation fabedge.io/local-ingress-interface. Leave it empty to disable it")
 	flag.BoolVar(&opts.Agent.EnableEdgeHairpinMode, "agent-enable-edge-hairpinmode", true, "Enable edge node pods HairpinMode")
 	flag.IntVar(&opts.Agent.NetworkPluginMTU, "agent-network-plugin-mtu", 1400, "Set network plugin MTU for edge nodes")
+	flag.StringVar(&opts.Agent.DNSListenAddress, "agent-dns-listen-address", "", "The address where agents serve DNS for edge pods, e.g. 169.254.20.10:53, queries are forwarded to agent-dns-upstreams through tunnels. Kubelet of edge nodes should use the IP as cluster DNS. Leave it empty to disable it")
+	flag.StringSliceVar(&opts.Agent.DNSUpstreams, "agent-dns-upstreams", nil, "Addresses of cluster DNS which agents forward queries to, e.g. 10.96.0.10:53")
+	flag.BoolVar(&opts.Agent.OfflineCache, "agent-offline-cache", false, "Let agents keep the last valid tunnels and services config in /var/lib/fabedge/agent on edge nodes, they keep working with it when edge nodes are cut off from cloud")
+	flag.BoolVar(&opts.Agent.EnableFirewall, "agent-enable-firewall", false, "Let agents keep a minimal firewall on the WAN interface of edge nodes, only IKE and ESP from peers and agent-firewall-allowed-ports are accepted")
+	flag.StringSliceVar(&opts.Agent.FirewallAllowedPorts, "agent-firewall-allowed-ports", []string{"10250"}, "The TCP ports accepted by firewall of edge nodes, e.g. 22,10250. Add the port of SSH if edge nodes are managed through the WAN interface")
+	flag.IntVar(&opts.Agent.MetricsPort, "agent-metrics-port", 0, "The port on which agents serve /metrics and /healthz, /healthz is used as liveness and readiness probes of agent container. Agents run in host network, so pick a port which is free on edge nodes. 0 means they are not served")
+	flag.IntVar(&opts.Agent.SubnetsPerChildSA, "agent-subnets-per-child-sa", 0, "The max number of subnets in traffic selectors of a child SA of agent, 0 means no splitting")
+	flag.StringVar(&opts.Agent.NodeCondition, "agent-node-condition", "", "The type of node condition which agent uses to reflect whether tunnels to connector are established, e.g. NetworkUnavailable or FabEdgeTunnelReady. Leave it empty to disable it")
+	flag.DurationVar(&opts.Agent.RelayTimeout, "agent-relay-timeout", 0, "How long tunnels of an edge node to its edge peers can be down while its tunnels to connector are established, after that agent annotates the node with fabedge.io/relay-only=true and traffic between it and edge peers is relayed by connector. Agent pods use agent-service-account. 0 means it's disabled")
+	flag.BoolVar(&opts.Agent.ConfigResource, "agent-config-resource", false, "Keep config of agents in AgentConfig resources named after edge nodes instead of configmaps, agents get it through API and report whether it's applied in status. Agent pods use agent-service-account. CRD deploy/crds/fabedge.io_agentconfigs.yaml is needed")
+	flag.DurationVar(&opts.Agent.ProbeInterval, "agent-probe-interval", 0, "How often agents ping their peers through tunnels, round-trip time and loss are reported in status of AgentConfigs and aggregated into status of communities, so agent-config-resource is required. Connectors report theirs in ConnectorConfigs if they are started with --probe-interval. 0 means it's disabled")
+	flag.IntVar(&opts.Agent.FlapThreshold, "agent-flap-threshold", 0, "Agents set condition Flapping of their AgentConfigs if any tunnel goes down more than this many times in an hour, summaries of tunnel events are reported in status too, so agent-config-resource is required. 0 means agents use their default, which is 5")
+	flag.StringVar(&opts.Agent.ServiceAccountName, "agent-service-account", "fabedge-agent", "The service account of agent pod, only used when agent-node-condition, agent-relay-timeout, agent-config-resource or agent-cert-bootstrap is set")
+	flag.DurationVar(&opts.Agent.SyncInterval, "agent-sync-interval", 0, "The interval to reconcile each edge node again, 0 means edge nodes are reconciled only when they or their resources change")
+	flag.IntVar(&opts.Agent.MaxConcurrentReconciles, "agent-max-concurrent-reconciles", 5, "The max number of concurrent reconciles of agent controller, each edge node is reconciled by one worker at a time")
+	flag.DurationVar(&opts.Agent.RetryBaseDelay, "agent-retry-base-delay", 100*time.Millisecond, "The base delay to retry a failed edge node, the delay grows exponentially for each node")
+	flag.DurationVar(&opts.Agent.RetryMaxDelay, "agent-retry-max-delay", 5*time.Minute, "The max delay to retry a failed edge node")
+	flag.Float64Var(&opts.Agent.RetryQPS, "agent-retry-qps", 20, "The overall rate of retrying failed edge nodes")
+	flag.IntVar(&opts.Agent.RetryBurst, "agent-retry-burst", 200, "The burst of retrying failed edge nodes")
 
 	flag.StringVar(&opts.CASecretName, "ca-secret", "fabedge-ca", "The name of secret which contains CA's cert and key")
+	flag.StringVar(&opts.CAKeySignerCommand, "ca-key-signer-command", "", "The command and its arguments to sign by CA key kept in KMS or HSM, e.g. /usr/local/bin/kms-signer --key=fabedge-ca. If set, CA secret only needs CA's cert and CA can't be rotated. Only works in host cluster")
+	flag.StringVar(&opts.CRLSecretName, "crl-secret", "fabedge-crl", "The name of secret which contains CRLs of revoked certificates, agents and connectors load CRLs from it. Leave it empty to disable certificate revocation")
 	flag.StringVar(&opts.CertOrganization, "cert-organization", certutil.DefaultOrganization, "The organization name for agent's cert")
+	flag.StringVar(&opts.CertKeyType, "cert-key-type", string(certutil.KeyTypeRSA), "The algorithm of keys of certificates and new CAs made by operator: rsa, ecdsa or ed25519. ECDSA keys use curve P-256 unless cert-key-size is provided")
+	flag.IntVar(&opts.CertKeySize, "cert-key-size", 0, "The size of keys of certificates and new CAs made by operator: bits of RSA keys, e.g. 3072, or bits of the curve of ECDSA keys: 256, 384 or 521. It can't be used with ed25519. 0 means RSA keys of certificates have 2048 bits, RSA keys of CAs have 4096 bits and ECDSA keys use curve P-256")
+	flag.StringVar(&opts.CertSignatureAlgorithm, "cert-signature-algorithm", "", "The algorithm to sign certificate requests made by operator, e.g. SHA384-RSA, SHA384-RSAPSS, ECDSA-SHA384 or Ed25519. It must match cert-key-type, leave it empty to choose it by cert-key-type")
+	flag.BoolVar(&opts.FIPSMode, "fips-mode", fips.BuildEnabled(), "Restrict TLS, certificates and IPsec proposals of operator and agents to FIPS approved algorithms, configurations which are not compliant are rejected at startup. It's always on if operator is built with tag fips")
 	flag.Int64Var(&opts.CertValidPeriod, "cert-validity-period", 3650, "The validity period for agent's cert")
+	flag.DurationVar(&opts.AgentCertValidPeriod, "agent-cert-validity-period", 0, "The validity period of agents' certificates, e.g. 720h. 0 means cert-validity-period is used. Only works in host cluster")
+	flag.StringVar(&opts.Agent.CSRSignerName, "agent-csr-signer-name", "", "The signer name of CertificateSigningRequests of agents' certificates, e.g. fabedge.io/agent. If set, agents' certificates are requested by CertificateSigningRequests which have to be approved by administrators or approval controllers, then operator signs them. Leave it empty to sign certificates directly")
+	flag.StringVar(&opts.Agent.APIServerAddress, "agent-api-server-address", "", "The address of API server which agents use to renew their certificates in place before they expire, e.g. https://10.0.0.1:30303. Leave it empty to let operator reissue certificates and restart agents when they expire. Only works in host cluster")
+	flag.BoolVar(&opts.Agent.CertBootstrap, "agent-cert-bootstrap", false, "Let agents get their certificates from agent-api-server-address by projected service account tokens of their pods and keep them in emptyDir volumes, no TLS secrets of agents are made. Agent pods use agent-service-account. Only works in host cluster")
+	flag.DurationVar(&opts.CARotationDistributePeriod, "ca-rotation-distribute-period", 24*time.Hour, "The least time to distribute the new CA to member clusters and edge nodes before it signs certificates when CA is being rotated")
+	flag.DurationVar(&opts.CertRenewalWindow, "cert-renewal-window", 30*24*time.Hour, "How long before expiry a certificate of agent, connector or API client is reported as expiring by events and condition of FabEdge resource, days until expiry of certificates are exported as metrics. 0 means certificate expiry is not monitored")
+	flag.DurationVar(&opts.Agent.CertReissueInterval, "cert-reissue-interval", 10*time.Second, "The least interval between reissues of agents' certificates when CA is being rotated, so tunnels of edge nodes are rebuilt one by one. 0 means they are reissued at once")
+
+	flag.StringVar(&opts.AutoCommunity.LabelKey, "auto-community-label", "", "The label key used to make communities automatically, edge nodes with the same value of this label will be put in the same community, e.g. topology.fabedge.io/site. It's apps.openyurt.io/nodepool by default if openyurt is true")
+	flag.BoolVar(&opts.OpenYurt, "openyurt", false, "Integrate with OpenYurt: a community is made for each NodePool, public addresses in annotation fabedge.io/node-public-addresses of NodePools are put on their edge nodes which have no public addresses, and agent pods are labeled with their NodePools like pods of YurtAppSet")
+	flag.StringVar(&opts.AutoCommunity.NamePrefix, "auto-community-prefix", "auto-", "The name prefix of communities made automatically")
 
 	flag.StringVar(&opts.Proxy.IPVSScheduler, "ipvs-scheduler", "rr", "The ipvs scheduler for each service")
+	flag.DurationVar(&opts.Proxy.CheckInterval, "proxy-sync-interval", 5*time.Second, "The interval to check if load balance rules of agents are consistent with services")
+	flag.DurationVar(&opts.Proxy.WriteInterval, "proxy-write-interval", 5*time.Second, "The interval to write changed load balance rules to configmaps of agents, changes of an edge node in an interval are written at once")
+	flag.DurationVar(&opts.Agent.IPVSTCPTimeout, "agent-ipvs-tcp-timeout", 0, "The timeout of idle IPVS TCP connections on edge nodes, e.g. 900s. 0 keeps the value of system")
+	flag.DurationVar(&opts.Agent.IPVSTCPFinTimeout, "agent-ipvs-tcpfin-timeout", 0, "The timeout of IPVS TCP connections after receiving a FIN packet on edge nodes, e.g. 120s. 0 keeps the value of system")
+	flag.DurationVar(&opts.Agent.IPVSUDPTimeout, "agent-ipvs-udp-timeout", 0, "The timeout of IPVS UDP packets on edge nodes, e.g. 300s. 0 keeps the value of system")
+	flag.DurationVar(&opts.Agent.IPVSGracefulTermination, "agent-ipvs-graceful-termination", 0, "The longest time a TCP endpoint removed from a service is kept by agents with weight 0 until its connections are gone. 0 means endpoints are removed at once")
+	flag.IntVar(&opts.Proxy.MaxConcurrentReconciles, "proxy-max-concurrent-reconciles", 1, "The max number of concurrent reconciles of each proxy controller")
+	flag.StringVar(&opts.Proxy.Mode, "proxy-mode", proxyctl.ModeLocal, "Which endpoints agents' proxy uses: local or topology. local only uses endpoints on the same edge node, topology uses endpoints on edge nodes of the same communities too if there is no endpoint on the node. Requests to services without these endpoints are sent to cloud")
+	flag.StringVar(&opts.Proxy.EndpointsSource, "proxy-endpoints-source", proxyctl.EndpointsSourceAuto, "Where proxy watches endpoints of services from: endpointslice, endpoints or auto. auto uses endpointslice if the API is served, otherwise endpoints")
+
+	flag.DurationVar(&opts.ClusterCtl.SyncInterval, "cluster-sync-interval", 0, "The interval to reconcile each cluster again, 0 means clusters are reconciled only when they change")
+	flag.IntVar(&opts.ClusterCtl.MaxConcurrentReconciles, "cluster-max-concurrent-reconciles", 1, "The max number of concurrent reconciles of cluster controller")
+	flag.DurationVar(&opts.ClusterCtl.EvictionTimeout, "cluster-eviction-timeout", 0, "How long a member cluster can miss heartbeats before its endpoints are removed, 0 means member clusters are never evicted")
 
 	flag.BoolVar(&opts.ManagerOpts.LeaderElection, "leader-election", false, "Determines whether or not to use leader election")
 	flag.StringVar(&opts.ManagerOpts.LeaderElectionID, "leader-election-id", "fabedge-operator-leader", "The name of the resource that leader election will use for holding the leader lock")
+	flag.StringVar(&opts.ManagerOpts.LeaderElectionResourceLock, "leader-election-resource-lock", resourcelock.ConfigMapsLeasesResourceLock, "The type of resource used as leader lock: configmaps, leases or configmapsleases. To migrate between configmaps and leases, use configmapsleases first")
+	flag.StringVar(&opts.ManagerOpts.MetricsBindAddress, "metrics-bind-address", "0", "The address on which Prometheus metrics of operator, API server and API client are served, e.g. :9090. 0 means metrics are not served")
+	flag.StringVar(&opts.Tracing.Endpoint, "tracing-endpoint", "", "The address of OTLP gRPC receiver which spans of reconciles, certificate signing and requests between host and member clusters are exported to, e.g. otel-collector.observability:4317. Leave it empty to disable tracing")
+	flag.BoolVar(&opts.Tracing.Insecure, "tracing-insecure", false, "Export spans to tracing-endpoint without TLS")
+	flag.Float64Var(&opts.Tracing.SampleRatio, "tracing-sample-ratio", 0.1, "The ratio of traces started by operator to sample, from 0 to 1. Requests from member clusters follow sampling decisions of member clusters")
+	flag.StringVar(&opts.ManagerOpts.LeaderElectionNamespace, "leader-election-namespace", "", "The namespace in which the leader lock is created, defaults to the namespace of operator")
 	opts.ManagerOpts.LeaseDuration = flag.Duration("leader-lease-duration", 15*time.Second, "The duration that non-leader candidates will wait to force acquire leadership")
 	opts.ManagerOpts.RenewDeadline = flag.Duration("leader-renew-deadline", 10*time.Second, "The duration that the acting controlplane will retry refreshing leadership before giving up")
 	opts.ManagerOpts.RetryPeriod = flag.Duration("leader-retry-period", 2*time.Second, "The duration that the LeaderElector clients should wait between tries of actions")
 
 	flag.StringVar(&opts.APIServerListenAddress, "api-server-listen-address", "0.0.0.0:3030", "The address on which for API server to listen")
-	flag.StringVar(&opts.APIServerAddress, "api-server-address", "", "The address of host cluster's API server")
+	flag.StringVar(&opts.GRPCServerListenAddress, "grpc-server-listen-address", "", "The address on which for gRPC API server to listen, e.g. 0.0.0.0:3031. It serves certificate signing, endpoints and communities like API server with the same certificate. Leave it empty to not serve gRPC API. Only works in host cluster")
+	flag.StringVar(&opts.APIServerAddress, "api-server-address", "", "The address of host cluster's API server, multiple addresses of API server replicas can be provided separated by comma, e.g. https://10.0.0.1:30303,https://10.0.0.2:30303")
 	flag.StringVar(&opts.APIServerCertFile, "api-server-cert-file", "", "The cert file path for api server")
 	flag.StringVar(&opts.APIServerKeyFile, "api-server-key-file", "", "The key file path for api server")
-	flag.StringVar(&opts.InitToken, "init-token", "", "The token used to initialize TLS cert for API client")
+	flag.StringVar(&opts.InitToken, "init-token", "", "The token used to initialize TLS cert for API client. It's visible in pod spec, use init-token-secret or init-token-file instead if possible")
+	flag.StringVar(&opts.InitTokenSecret, "init-token-secret", "", "The name of secret in which the init token is stored in key token")
+	flag.StringVar(&opts.InitTokenFile, "init-token-file", "", "The file which contains the init token, e.g. a bound service account token projected into operator pod")
+	flag.BoolVar(&opts.AcceptServiceAccountTokens, "accept-service-account-tokens", false, "Accept bound service account tokens of member clusters as init tokens, the issuer of a member cluster's tokens is set by annotation fabedge.io/service-account-issuer of its cluster resource")
+	flag.StringVar(&opts.ServiceAccountTokenAudience, "service-account-token-audience", tokenpkg.DefaultServiceAccountAudience, "The audience which bound service account tokens of member clusters must have")
+	flag.StringVar(&opts.ServiceAccountTokenSubject, "service-account-token-subject", tokenpkg.DefaultServiceAccountSubject, "The subject which bound service account tokens of member clusters must have, in format of system:serviceaccount:<namespace>:<name>, it's the service account of operators of member clusters")
 	flag.DurationVar(&opts.TokenValidPeriod, "token-valid-period", 12*time.Hour, "The validity duration of token for child cluster to initialize")
+	flag.DurationVar(&opts.HeartbeatInterval, "heartbeat-interval", 10*time.Second, "The interval for member cluster to report heartbeat to host cluster")
+	flag.DurationVar(&opts.EndpointsWatchTimeout, "endpoints-watch-timeout", 30*time.Second, "How long each request of member cluster waits for changes of endpoints and communities from host cluster, 0 means polling them every 10 seconds")
+	flag.BoolVar(&opts.APIServerStream, "api-server-stream", false, "Get endpoints and communities pushed by host cluster's API server through a long-lived stream. Other requests share its connection only if HTTP/2 is negotiated. It's useful when member cluster is behind NAT")
+	flag.IntVar(&opts.EndpointsPageSize, "endpoints-page-size", 500, "The max number of endpoints in each response when member cluster gets endpoints from host cluster, 0 means getting all endpoints by one request")
+	flag.Float64Var(&opts.APIServerRateLimitQPS, "api-server-rate-limit-qps", 10, "How many requests per second each client can send to API server, 0 means no limit")
+	flag.IntVar(&opts.APIServerRateLimitBurst, "api-server-rate-limit-burst", 20, "The maximum burst of requests of each client to API server")
+	flag.IntVar(&opts.AuditMaxRecords, "audit-max-records", audit.DefaultMaxRecords, "How many audit records of API server are kept for each member cluster")
+	flag.IntVar(&opts.CertLedgerMaxEntries, "cert-ledger-max-entries", 0, "How many certificates signed by operator are recorded in configmap fabedge-cert-ledger with their subjects, serial numbers, requesters, validity and purposes. 0 means ledger is disabled. Only works in host cluster")
+	flag.DurationVar(&opts.CertLedgerRetention, "cert-ledger-retention", 0, "How long records of certificates are kept in ledger after certificates expire, e.g. 2160h. 0 means they are only dropped when there are more than cert-ledger-max-entries records")
+	flag.StringVar(&opts.WebhookURL, "webhook-url", "", "The URL to which lifecycle events of member clusters are posted, e.g. a member cluster joins, exports endpoints for the first time, goes stale or is removed")
+	flag.StringVar(&opts.WebhookSecretFile, "webhook-secret-file", "", "The file which contains the secret to sign webhooks by HMAC-SHA256, it's required if webhook-url is provided")
+	flag.StringVar(&opts.AlertWebhookURL, "alert-webhook-url", "", "The URL to which alerts are posted when a tunnel stays down or a member cluster stops exporting endpoints longer than alert-threshold, empty means no alerts are sent")
+	flag.StringVar(&opts.AlertWebhookFormat, "alert-webhook-format", webhook.FormatJSON, "How alerts are posted: json or slack. slack posts messages of Slack incoming webhooks")
+	flag.StringVar(&opts.AlertWebhookSecretFile, "alert-webhook-secret-file", "", "The file which contains the secret to sign alerts by HMAC-SHA256, alerts are not signed if it's not provided")
+	flag.DurationVar(&opts.AlertThreshold, "alert-threshold", 10*time.Minute, "How long a tunnel stays down or a member cluster stops exporting endpoints before it's alerted")
+	flag.BoolVar(&opts.ServeTopology, "serve-topology", false, "Serve the topology of clusters, connectors, edge nodes, communities and tunnels at /topology of the metrics server in JSON, or in DOT with ?format=dot. metrics-bind-address is required")
 }
 
 func (opts *Options) Complete() (err error) {
-	opts.CNIType = strings.TrimSpace(opts.CNIType)
+	opts.normalize()
+
+	if opts.FIPSMode {
+		fips.Enable()
+	}
 
 	nodeutil.SetEdgeNodeLabels(opts.EdgeLabels)
 
+	opts.Tracing.ServiceName = constants.AppOperator
+	opts.Tracing.Attributes = map[string]string{
+		"fabedge.cluster":      opts.Cluster,
+		"fabedge.cluster_role": opts.ClusterRole,
+	}
+
+	if opts.OpenYurt {
+		opts.Agent.OpenYurt = true
+		if opts.AutoCommunity.LabelKey == "" {
+			opts.AutoCommunity.LabelKey = constants.KeyOpenYurtNodePool
+		}
+	}
+
 	var (
 		getEdgePodCIDRs  types.PodCIDRsGetter
 		getCloudPodCIDRs types.PodCIDRsGetter
@@ -175,7 +447,12 @@ func (opts *Options) Complete() (err error) {
 		return fmt.Errorf("unknown CNI: %s", opts.CNIType)
 	}
 
-	getEndpointName, getEndpointID, newEndpoint := types.NewEndpointFuncs(opts.Cluster, opts.EndpointIDFormat, getEdgePodCIDRs)
+	idFormat := opts.EndpointIDFormat
+	if opts.SPIFFETrustDomain != "" {
+		idFormat = fmt.Sprintf("%s://%s/{cluster}/{name}", certutil.SPIFFEScheme, opts.SPIFFETrustDomain)
+	}
+
+	getEndpointName, getEndpointID, newEndpoint := types.NewEndpointFuncs(opts.Cluster, idFormat, getEdgePodCIDRs)
 	opts.NewEndpoint = newEndpoint
 
 	cfg, err := config.GetConfig()
@@ -190,8 +467,14 @@ func (opts *Options) Complete() (err error) {
 		return err
 	}
 
-	opts.ManagerOpts.LeaderElectionNamespace = opts.Namespace
-	opts.ManagerOpts.MetricsBindAddress = "0"
+	if opts.ManagerOpts.LeaderElectionNamespace == "" {
+		opts.ManagerOpts.LeaderElectionNamespace = opts.Namespace
+	}
+	if err = opts.checkLeaderElectionMigration(kubeClient); err != nil {
+		log.Error(err, "failed to check leader election lock")
+		return err
+	}
+
 	opts.ManagerOpts.Logger = klogr.New().WithName("fabedge-operator")
 	opts.Manager, err = manager.New(cfg, opts.ManagerOpts)
 	if err != nil {
@@ -199,27 +482,43 @@ func (opts *Options) Complete() (err error) {
 		return err
 	}
 
-	var certManager certutil.Manager
+	var (
+		certManager certutil.Manager
+		caKey       crypto.Signer
+	)
 	if opts.ClusterRole == RoleHost {
+		if opts.CAKeySignerCommand != "" {
+			caKey, err = certutil.NewExternalSigner(strings.Fields(opts.CAKeySignerCommand))
+			if err != nil {
+				log.Error(err, "failed to create external signer of CA key")
+				return err
+			}
+		}
+
 		certManager, opts.PrivateKey, err = createCertManager(kubeClient, client.ObjectKey{
 			Name:      opts.CASecretName,
 			Namespace: opts.Namespace,
-		}, timeutil.Days(opts.CertValidPeriod))
+		}, timeutil.Days(opts.CertValidPeriod), caKey)
 		if err != nil {
 			log.Error(err, "failed to create cert manager")
 			return err
 		}
 	} else {
-		cacert, err := fclient.GetCertificate(opts.APIServerAddress)
+		cacert, trustedCACerts, err := getHostCA(opts.APIServerAddress)
 		if err != nil {
 			log.Error(err, "failed to get CA cert from host cluster")
 			return err
 		}
 
-		if err = opts.initAPIClient(kubeClient, cacert); err != nil {
+		if err = opts.initAPIClient(kubeClient, cacert, trustedCACerts); err != nil {
 			return err
 		}
 
+		var trustedCADERs [][]byte
+		for _, cert := range trustedCACerts {
+			trustedCADERs = append(trustedCADERs, cert.DER)
+		}
+
 		certManager, err = certutil.NewRemoteManager(cacert.DER, func(csr []byte) ([]byte, error) {
 			cert, innerErr := opts.APIClient.SignCert(csr)
 			if innerErr != nil {
@@ -227,66 +526,271 @@ func (opts *Options) Complete() (err error) {
 			}
 
 			return cert.DER, nil
-		})
+		}, trustedCADERs...)
 		if err != nil {
 			log.Error(err, "failed to create certManager")
 			return err
 		}
 	}
 
+	if fips.Enabled() {
+		if err = fips.ValidateCertsPEM(certManager.GetCACertPEM()); err != nil {
+			log.Error(err, "CA is not compliant with FIPS mode")
+			return err
+		}
+	}
+
 	opts.Store = storepkg.NewStore()
+	assignment := types.NewConnectorAssignment()
 
 	opts.Agent.Namespace = opts.Namespace
 	opts.Agent.CertManager = certManager
+	if opts.AgentCertValidPeriod > 0 {
+		opts.Agent.CertManager = certutil.WithValidPeriod(certManager, opts.AgentCertValidPeriod)
+	}
 	opts.Agent.Manager = opts.Manager
 	opts.Agent.Store = opts.Store
 	opts.Agent.NewEndpoint = opts.NewEndpoint
 	opts.Agent.GetEndpointName = getEndpointName
+	opts.Agent.GetEndpointID = getEndpointID
 	opts.Agent.CertOrganization = opts.CertOrganization
+	opts.Agent.CertKeyType = certutil.KeyType(opts.CertKeyType)
+	opts.Agent.CertKeySize = opts.CertKeySize
+	opts.Agent.CertSignatureAlgorithm = opts.certSignatureAlgorithm()
+	opts.Agent.FIPSMode = opts.FIPSMode
+	opts.Agent.CRLSecretName = opts.CRLSecretName
+	opts.Agent.ConnectorAssignment = assignment
+	opts.Agent.HashConnectorAssignment = opts.ConnectorSharding == ConnectorShardingHash
 
 	opts.Connector.Namespace = opts.Namespace
 	opts.Connector.CertOrganization = opts.CertOrganization
+	opts.Connector.CertKeyType = certutil.KeyType(opts.CertKeyType)
+	opts.Connector.CertKeySize = opts.CertKeySize
+	opts.Connector.CertSignatureAlgorithm = opts.certSignatureAlgorithm()
 	opts.Connector.CertManager = certManager
 	opts.Connector.Manager = opts.Manager
 	opts.Connector.Store = opts.Store
 	opts.Connector.GetPodCIDRs = getCloudPodCIDRs
 	opts.Connector.Endpoint.Name = getEndpointName("connector")
 	opts.Connector.Endpoint.ID = getEndpointID("connector")
+	opts.Connector.Assignment = assignment
+
+	for _, name := range sets.StringKeySet(opts.ExtraConnectors).List() {
+		connectorLabels := make(map[string]string, len(opts.Connector.ConnectorLabels)+1)
+		for key, value := range opts.Connector.ConnectorLabels {
+			connectorLabels[key] = value
+		}
+		connectorLabels[constants.KeyConnector] = name
+
+		extra := opts.Connector
+		extra.Name = name
+		extra.ConnectorLabels = connectorLabels
+		extra.Endpoint = apis.Endpoint{
+			Name:            getEndpointName("connector-" + name),
+			ID:              getEndpointID("connector-" + name),
+			PublicAddresses: strings.Split(opts.ExtraConnectors[name], ";"),
+		}
+		opts.ExtraConnectorConfigs = append(opts.ExtraConnectorConfigs, extra)
+	}
+
+	// only the default connector maintains memberlist peers, connectors share the TLS secret of cloud agents
+	if opts.MemberlistTLS {
+		opts.Connector.CloudAgentName = getEndpointName("cloud-agent")
+		opts.Connector.CloudAgentID = getEndpointID("cloud-agent")
+		opts.Connector.MemberlistPeers = []string{
+			certutil.IdentityOf(opts.Connector.Endpoint.Name, opts.Connector.Endpoint.ID),
+			certutil.IdentityOf(opts.Connector.CloudAgentName, opts.Connector.CloudAgentID),
+		}
+		for _, extra := range opts.ExtraConnectorConfigs {
+			opts.Connector.MemberlistPeers = append(opts.Connector.MemberlistPeers, certutil.IdentityOf(extra.Endpoint.Name, extra.Endpoint.ID))
+		}
+	}
+
+	opts.AutoCommunity.Manager = opts.Manager
+	opts.AutoCommunity.GetEndpointName = getEndpointName
 
 	opts.Proxy.AgentNamespace = opts.Namespace
+	opts.Proxy.AgentConfigResource = opts.Agent.ConfigResource
 	opts.Proxy.Manager = opts.Manager
-	opts.Proxy.CheckInterval = 5 * time.Second
+	opts.Proxy.Store = opts.Store
+	opts.Proxy.GetEndpointName = getEndpointName
 
 	if opts.ClusterRole == RoleHost {
-		opts.APIServer, err = apiserver.New(apiserver.Config{
-			Addr:        opts.APIServerListenAddress,
-			CertManager: certManager,
-			Store:       opts.Store,
-			Client:      opts.Manager.GetClient(),
-			Log:         log.WithName("apiserver"),
-		})
+		if opts.CertLedgerMaxEntries > 0 {
+			opts.CertLedger = certledger.ConfigMapLedger{
+				Namespace:  opts.Namespace,
+				Client:     opts.Manager.GetClient(),
+				Log:        log.WithName("certLedger"),
+				MaxEntries: opts.CertLedgerMaxEntries,
+				Retention:  opts.CertLedgerRetention,
+			}
+		}
+
+		opts.ClusterCtl.Tokens = &tokenpkg.Manager{
+			Namespace:  opts.Namespace,
+			PrivateKey: opts.PrivateKey,
+			Client:     opts.Manager.GetClient(),
+		}
+
+		var serviceAccountTokens *tokenpkg.ServiceAccountVerifier
+		if opts.AcceptServiceAccountTokens {
+			serviceAccountTokens = &tokenpkg.ServiceAccountVerifier{
+				Audience:   opts.ServiceAccountTokenAudience,
+				Subject:    opts.ServiceAccountTokenSubject,
+				Client:     opts.Manager.GetClient(),
+				HTTPClient: &http.Client{Timeout: 5 * time.Second},
+			}
+		}
+
+		if opts.WebhookURL != "" {
+			secret, err := ioutil.ReadFile(opts.WebhookSecretFile)
+			if err != nil {
+				log.Error(err, "failed to read webhook secret")
+				return err
+			}
+
+			opts.ClusterCtl.Notifier = webhook.Sender{
+				URL:        opts.WebhookURL,
+				Secret:     bytes.TrimSpace(secret),
+				HTTPClient: &http.Client{Timeout: 10 * time.Second},
+				Log:        log.WithName("webhook"),
+			}
+		}
+
+		if opts.CRLSecretName != "" {
+			opts.CRL = &crlpkg.Manager{
+				SecretKey:   client.ObjectKey{Name: opts.CRLSecretName, Namespace: opts.Namespace},
+				CASecretKey: client.ObjectKey{Name: opts.CASecretName, Namespace: opts.Namespace},
+				CAKey:       caKey,
+				Client:      opts.Manager.GetClient(),
+			}
+			opts.ClusterCtl.CRL = opts.CRL
+		}
+
+		// agents renew their certificates in place only if they are told where API server is
+		var agentCertManager certutil.Manager
+		if opts.Agent.APIServerAddress != "" {
+			agentCertManager = opts.Agent.CertManager
+		}
+
+		// agents bootstrap their certificates by tokens of their pods
+		var agentTokens *tokenpkg.AgentVerifier
+		if opts.Agent.CertBootstrap {
+			agentTokens = &tokenpkg.AgentVerifier{
+				Namespace:          opts.Namespace,
+				ServiceAccountName: opts.Agent.ServiceAccountName,
+				Client:             opts.Manager.GetClient(),
+			}
+		}
+
+		var rateLimiter *apiserver.ClientRateLimiter
+		if opts.APIServerRateLimitQPS > 0 {
+			rateLimiter = apiserver.NewClientRateLimiter(opts.APIServerRateLimitQPS, opts.APIServerRateLimitBurst)
+		}
+
+		apiServerConfig := apiserver.Config{
+			Addr:                 opts.APIServerListenAddress,
+			PublicKey:            opts.PrivateKey.Public(),
+			CertManager:          certManager,
+			Store:                opts.Store,
+			Client:               opts.Manager.GetClient(),
+			Log:                  log.WithName("apiserver"),
+			Tokens:               opts.ClusterCtl.Tokens,
+			TokenValidPeriod:     opts.TokenValidPeriod,
+			RateLimiter:          rateLimiter,
+			ServiceAccountTokens: serviceAccountTokens,
+			IsLeader:             opts.isLeader,
+			Notifier:             opts.ClusterCtl.Notifier,
+			CRL:                  opts.CRL,
+			AgentCertManager:     agentCertManager,
+			AgentNamespace:       opts.Namespace,
+			AgentTokens:          agentTokens,
+			Ledger:               opts.CertLedger,
+			GetAgentEndpointName: opts.Agent.GetEndpointName,
+			GetAgentEndpointID:   opts.Agent.GetEndpointID,
+			Auditor: audit.ConfigMapRecorder{
+				Namespace:  opts.Namespace,
+				Client:     opts.Manager.GetClient(),
+				Log:        log.WithName("audit"),
+				MaxRecords: opts.AuditMaxRecords,
+			},
+		}
+		opts.APIServer, err = apiserver.New(apiServerConfig)
 		if err != nil {
 			log.Error(err, "failed to create api server")
 			return err
 		}
 
+		// certificates issued by all trusted CAs are accepted when CA is being rotated
 		certPool := x509.NewCertPool()
-		certPool.AddCert(certManager.GetCACert())
+		certPool.AppendCertsFromPEM(certManager.GetCABundlePEM())
 		cert, err := tls.LoadX509KeyPair(opts.APIServerCertFile, opts.APIServerKeyFile)
 		if err != nil {
 			log.Error(err, "failed to load api server key pair")
 			return err
 		}
-		opts.APIServer.TLSConfig = &tls.Config{
+		if fips.Enabled() {
+			if err = fips.ValidateCertsPEM(certutil.EncodeCertPEM(cert.Certificate[0])); err != nil {
+				log.Error(err, "api server certificate is not compliant with FIPS mode")
+				return err
+			}
+		}
+
+		cert.Certificate = appendIntermediateCAs(cert.Certificate, certManager.GetCACertPEM())
+		opts.APIServer.TLSConfig = fips.TLSConfig(&tls.Config{
 			ClientCAs:    certPool,
 			Certificates: []tls.Certificate{cert},
 			ClientAuth:   tls.RequestClientCert,
+		})
+
+		if opts.GRPCServerListenAddress != "" {
+			opts.GRPCServer = apiserver.NewGRPCServer(apiServerConfig, grpc.Creds(credentials.NewTLS(opts.APIServer.TLSConfig)))
+		}
+
+		// API server records certificates it signs by itself with requesters, so
+		// it keeps the managers which are not wrapped
+		if opts.CertLedger != nil {
+			ledgerLog := log.WithName("certLedger")
+			opts.Agent.CertManager = certledger.WithLedger(opts.Agent.CertManager, opts.CertLedger, ledgerLog, certledger.RequesterOperator, certledger.PurposeAgent)
+			opts.Connector.CertManager = certledger.WithLedger(opts.Connector.CertManager, opts.CertLedger, ledgerLog, certledger.RequesterOperator, certledger.PurposeConnector)
+			for i := range opts.ExtraConnectorConfigs {
+				extra := &opts.ExtraConnectorConfigs[i]
+				extra.CertManager = certledger.WithLedger(extra.CertManager, opts.CertLedger, ledgerLog, certledger.RequesterOperator, certledger.PurposeConnector)
+			}
 		}
 	}
 
 	return nil
 }
 
+// appendIntermediateCAs appends intermediate CAs in caChainPEM to chain if chain only has the leaf
+// certificate, so clients which only trust the root CA can verify it
+func appendIntermediateCAs(chain [][]byte, caChainPEM []byte) [][]byte {
+	if len(chain) != 1 {
+		return chain
+	}
+
+	ders, err := certutil.DecodeCertsPEM(caChainPEM)
+	if err != nil {
+		return chain
+	}
+
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return chain
+		}
+
+		// the root CA is trusted by clients already
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			break
+		}
+		chain = append(chain, der)
+	}
+
+	return chain
+}
+
 func (opts Options) Validate() (err error) {
 	if len(opts.Cluster) == 0 {
 		return fmt.Errorf("a cluster name is required")
@@ -300,8 +804,43 @@ func (opts Options) Validate() (err error) {
 		return fmt.Errorf("unknown cluster role: %s", opts.ClusterRole)
 	}
 
-	if opts.ClusterRole == RoleMember && len(opts.InitToken) == 0 {
-		return fmt.Errorf("initialization token is needed when cluster role is member")
+	if opts.ClusterRole == RoleMember && len(opts.InitToken) == 0 && len(opts.InitTokenSecret) == 0 && len(opts.InitTokenFile) == 0 {
+		return fmt.Errorf("initialization token, token secret or token file is needed when cluster role is member")
+	}
+
+	if parts := strings.Split(opts.ServiceAccountTokenSubject, ":"); opts.AcceptServiceAccountTokens &&
+		(len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" || parts[2] == "" || parts[3] == "") {
+		return fmt.Errorf("service account token subject must be in the form of system:serviceaccount:<namespace>:<name>")
+	}
+
+	if opts.AgentCertValidPeriod < 0 {
+		return fmt.Errorf("agent cert validity period can not be negative")
+	}
+
+	if opts.ClusterRole != RoleHost && (opts.AgentCertValidPeriod > 0 || opts.Agent.APIServerAddress != "") {
+		return fmt.Errorf("agent cert validity period and agent api server address only work in host cluster")
+	}
+
+	if opts.Agent.CertBootstrap && (opts.Agent.APIServerAddress == "" || opts.Agent.CSRSignerName != "") {
+		return fmt.Errorf("agent cert bootstrap needs agent api server address and can not work with agent csr signer name")
+	}
+
+	if opts.ClusterRole != RoleHost && opts.GRPCServerListenAddress != "" {
+		return fmt.Errorf("grpc server listen address only works in host cluster")
+	}
+
+	if opts.ClusterRole != RoleHost && opts.CAKeySignerCommand != "" {
+		return fmt.Errorf("ca key signer command only works in host cluster")
+	}
+
+	if opts.SPIFFETrustDomain != "" {
+		if err = certutil.ValidateTrustDomain(opts.SPIFFETrustDomain); err != nil {
+			return err
+		}
+	}
+
+	if parts := strings.SplitN(opts.Agent.CSRSignerName, "/", 2); opts.Agent.CSRSignerName != "" && (len(parts) != 2 || parts[0] == "" || parts[1] == "") {
+		return fmt.Errorf("agent csr signer name must be in the form of domain/path, e.g. fabedge.io/agent")
 	}
 
 	if opts.ClusterRole == RoleHost {
@@ -326,12 +865,52 @@ func (opts Options) Validate() (err error) {
 		return fmt.Errorf("connector public addresses is needed")
 	}
 
+	for name, addresses := range opts.ExtraConnectors {
+		if !dns1123Reg.MatchString(name) {
+			return fmt.Errorf("invalid connector name: %s", name)
+		}
+
+		if len(strings.TrimSpace(addresses)) == 0 {
+			return fmt.Errorf("public addresses of connector %s is needed", name)
+		}
+	}
+
+	if opts.ConnectorSharding != ConnectorShardingLabel && opts.ConnectorSharding != ConnectorShardingHash {
+		return fmt.Errorf("invalid connector sharding: %s", opts.ConnectorSharding)
+	}
+
+	if opts.AutoCommunity.LabelKey != "" {
+		if errs := validation.IsQualifiedName(opts.AutoCommunity.LabelKey); len(errs) > 0 {
+			return fmt.Errorf("invalid auto community label: %s", strings.Join(errs, ","))
+		}
+
+		if !dns1123Reg.MatchString(opts.AutoCommunity.NamePrefix + "a") {
+			return fmt.Errorf("invalid auto community prefix: %s", opts.AutoCommunity.NamePrefix)
+		}
+	}
+
+	if opts.Agent.DNSListenAddress != "" && len(opts.Agent.DNSUpstreams) == 0 {
+		return fmt.Errorf("agent dns upstreams are needed when agent dns listen address is provided")
+	}
+
+	if opts.SyncGlobalNetworkSets && opts.CNIType != constants.CNICalico {
+		return fmt.Errorf("global network sets can only be synchronized when CNI is calico")
+	}
+
 	for _, subnet := range opts.Connector.ProvidedSubnets {
 		if _, _, err := net.ParseCIDR(subnet); err != nil {
 			return fmt.Errorf("invalid subnet: %s. %w", subnet, err)
 		}
 	}
 
+	for _, cidrs := range [][]string{opts.Agent.MasqueradeCIDRs, opts.Agent.NonMasqueradeCIDRs} {
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid masquerade cidr of agents: %s. %w", cidr, err)
+			}
+		}
+	}
+
 	if opts.Agent.EnableEdgeIPAM {
 		ip, subnet, err := net.ParseCIDR(opts.EdgePodCIDR)
 		if err != nil {
@@ -346,6 +925,246 @@ func (opts Options) Validate() (err error) {
 		}
 	}
 
+	if opts.Agent.MaxConcurrentReconciles < 1 ||
+		opts.Connector.MaxConcurrentReconciles < 1 ||
+		opts.Proxy.MaxConcurrentReconciles < 1 ||
+		opts.ClusterCtl.MaxConcurrentReconciles < 1 {
+		return fmt.Errorf("max concurrent reconciles of controllers must be positive")
+	}
+
+	switch opts.Proxy.EndpointsSource {
+	case "", proxyctl.EndpointsSourceAuto, proxyctl.EndpointsSourceEndpointSlice, proxyctl.EndpointsSourceEndpoints:
+	default:
+		return fmt.Errorf("unknown endpoints source of proxy: %s", opts.Proxy.EndpointsSource)
+	}
+
+	if opts.Proxy.Mode != proxyctl.ModeLocal && opts.Proxy.Mode != proxyctl.ModeTopology {
+		return fmt.Errorf("unknown proxy mode: %s", opts.Proxy.Mode)
+	}
+
+	if opts.Connector.SyncInterval < time.Second || opts.Proxy.CheckInterval < time.Second {
+		return fmt.Errorf("the least sync interval of connector and proxy is 1 second")
+	}
+
+	if opts.Proxy.WriteInterval < time.Second {
+		return fmt.Errorf("the least write interval of proxy is 1 second")
+	}
+
+	if opts.Agent.RelayTimeout < 0 {
+		return fmt.Errorf("relay timeout of agents can not be negative")
+	}
+
+	if opts.Agent.ProbeInterval < 0 {
+		return fmt.Errorf("probe interval of agents can not be negative")
+	}
+
+	if opts.Agent.ProbeInterval > 0 && !opts.Agent.ConfigResource {
+		return fmt.Errorf("agent config resource is required to probe peers")
+	}
+
+	if opts.Agent.FlapThreshold < 0 {
+		return fmt.Errorf("flap threshold of agents can not be negative")
+	}
+
+	if opts.Agent.FlapThreshold > 0 && !opts.Agent.ConfigResource {
+		return fmt.Errorf("agent config resource is required to report flapping tunnels")
+	}
+
+	if opts.Agent.KubeEdge && opts.Agent.MetaServerAddress != "" {
+		if u, err := url.Parse(opts.Agent.MetaServerAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid metaserver address of kubeedge: %s", opts.Agent.MetaServerAddress)
+		}
+	}
+
+	if opts.Agent.SuperEdge {
+		if opts.Agent.KubeEdge {
+			return fmt.Errorf("kubeedge and superedge can not be both enabled")
+		}
+
+		if opts.Agent.SuperEdgeNamespace == "" {
+			return fmt.Errorf("namespace of superedge is needed")
+		}
+
+		if opts.Agent.LiteAPIServerAddress != "" {
+			if u, err := url.Parse(opts.Agent.LiteAPIServerAddress); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("invalid lite-apiserver address of superedge: %s", opts.Agent.LiteAPIServerAddress)
+			}
+		}
+	}
+
+	if opts.Agent.MetricsPort < 0 || opts.Agent.MetricsPort > 65535 {
+		return fmt.Errorf("invalid metrics port of agents: %d", opts.Agent.MetricsPort)
+	}
+
+	if opts.Agent.IPVSTCPTimeout < 0 || opts.Agent.IPVSTCPFinTimeout < 0 || opts.Agent.IPVSUDPTimeout < 0 || opts.Agent.IPVSGracefulTermination < 0 {
+		return fmt.Errorf("ipvs timeouts and graceful termination of agents can not be negative")
+	}
+
+	if opts.TeardownOnFabEdgeDeletion && opts.FabEdgeName == "" {
+		return fmt.Errorf("fabedge name is required to tear down on FabEdge deletion")
+	}
+
+	if !isSupportedResourceLock(opts.ManagerOpts.LeaderElectionResourceLock) {
+		return fmt.Errorf("unsupported leader election resource lock: %s", opts.ManagerOpts.LeaderElectionResourceLock)
+	}
+
+	if opts.Agent.SyncInterval < 0 || opts.ClusterCtl.SyncInterval < 0 {
+		return fmt.Errorf("sync interval of agent and cluster controllers can not be negative")
+	}
+
+	if opts.ClusterCtl.EvictionTimeout < 0 {
+		return fmt.Errorf("cluster eviction timeout can not be negative")
+	}
+
+	if opts.ClusterRole == RoleMember && opts.HeartbeatInterval < time.Second {
+		return fmt.Errorf("the least heartbeat interval is 1 second")
+	}
+
+	if opts.APIServerRateLimitQPS < 0 || (opts.APIServerRateLimitQPS > 0 && opts.APIServerRateLimitBurst < 1) {
+		return fmt.Errorf("api server rate limit qps can not be negative and burst must be positive")
+	}
+
+	if opts.AuditMaxRecords < 1 {
+		return fmt.Errorf("audit max records must be positive")
+	}
+
+	if opts.Tracing.SampleRatio < 0 || opts.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio must be between 0 and 1")
+	}
+
+	if opts.CertLedgerMaxEntries < 0 || opts.CertLedgerRetention < 0 {
+		return fmt.Errorf("cert ledger max entries and retention can not be negative")
+	}
+
+	if opts.ClusterRole != RoleHost && opts.CertLedgerMaxEntries > 0 {
+		return fmt.Errorf("cert ledger only works in host cluster")
+	}
+
+	if opts.EndpointsWatchTimeout < 0 || opts.EndpointsWatchTimeout > apiserver.MaxWatchTimeout {
+		return fmt.Errorf("endpoints watch timeout must be between 0 and %s", apiserver.MaxWatchTimeout)
+	}
+
+	if opts.EndpointsPageSize < 0 {
+		return fmt.Errorf("endpoints page size can not be negative")
+	}
+
+	if opts.WebhookURL != "" {
+		if u, err := url.Parse(opts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url: %s", opts.WebhookURL)
+		}
+
+		if !fileExists(opts.WebhookSecretFile) {
+			return fmt.Errorf("webhook secret file is required when webhook url is provided")
+		}
+	}
+
+	if opts.AlertWebhookURL != "" {
+		if u, err := url.Parse(opts.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid alert webhook url: %s", opts.AlertWebhookURL)
+		}
+
+		if opts.AlertWebhookFormat != webhook.FormatJSON && opts.AlertWebhookFormat != webhook.FormatSlack {
+			return fmt.Errorf("unknown alert webhook format: %s", opts.AlertWebhookFormat)
+		}
+
+		if opts.AlertWebhookSecretFile != "" && !fileExists(opts.AlertWebhookSecretFile) {
+			return fmt.Errorf("alert webhook secret file doesn't exist: %s", opts.AlertWebhookSecretFile)
+		}
+
+		if opts.AlertThreshold <= 0 {
+			return fmt.Errorf("alert threshold must be positive")
+		}
+	}
+
+	if opts.ServeTopology && opts.ManagerOpts.MetricsBindAddress == "0" {
+		return fmt.Errorf("metrics bind address is required to serve topology")
+	}
+
+	if opts.Agent.RetryBaseDelay <= 0 || opts.Agent.RetryMaxDelay < opts.Agent.RetryBaseDelay {
+		return fmt.Errorf("agent retry base delay must be positive and not greater than max delay")
+	}
+
+	if opts.Agent.RetryQPS <= 0 || opts.Agent.RetryBurst <= 0 {
+		return fmt.Errorf("agent retry qps and burst must be positive")
+	}
+
+	if opts.FailoverDrill.Interval < 0 {
+		return fmt.Errorf("drill interval can not be negative")
+	}
+
+	if opts.Prober.Interval < 0 {
+		return fmt.Errorf("prober interval can not be negative")
+	}
+
+	if opts.Prober.Interval > 0 {
+		if opts.Prober.Timeout <= 0 || opts.Prober.Timeout >= opts.Prober.Interval {
+			return fmt.Errorf("prober timeout must be positive and less than prober interval")
+		}
+
+		port, metricsPort := opts.Prober.Port, opts.Prober.MetricsPort
+		if port < 1 || port > 65535 || metricsPort < 1 || metricsPort > 65535 || port == metricsPort {
+			return fmt.Errorf("prober port and prober metrics port must be different ports between 1 and 65535")
+		}
+	}
+
+	if opts.CertRenewalWindow < 0 {
+		return fmt.Errorf("cert renewal window can not be negative")
+	}
+
+	if opts.CARotationDistributePeriod < 0 || opts.Agent.CertReissueInterval < 0 {
+		return fmt.Errorf("CA rotation distribute period and cert reissue interval can not be negative")
+	}
+
+	keyType, err := certutil.ParseKeyType(opts.CertKeyType)
+	if err != nil {
+		return err
+	}
+
+	if err = certutil.ValidateKeySize(keyType, opts.CertKeySize); err != nil {
+		return err
+	}
+
+	sigAlgorithm, err := certutil.ParseSignatureAlgorithm(opts.CertSignatureAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	if err = certutil.ValidateSignatureAlgorithm(keyType, sigAlgorithm); err != nil {
+		return err
+	}
+
+	if fips.BuildEnabled() && !opts.FIPSMode {
+		return fmt.Errorf("fips mode can not be disabled, operator is built with tag fips")
+	}
+
+	if opts.FIPSMode {
+		if err = fips.ValidateKey(keyType, opts.CertKeySize); err != nil {
+			return err
+		}
+
+		if err = fips.ValidateSignatureAlgorithm(sigAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	if opts.FailoverDrill.Interval > 0 {
+		if _, err := routines.ParseTimeWindow(opts.DrillWindow); err != nil {
+			return err
+		}
+
+		if opts.FailoverDrill.Timeout <= 0 || opts.FailoverDrill.Timeout >= opts.FailoverDrill.Interval {
+			return fmt.Errorf("drill timeout must be positive and less than drill interval")
+		}
+
+		if opts.FailoverDrill.ReportsToKeep < 1 {
+			return fmt.Errorf("at least one drill report should be kept")
+		}
+	}
+
+	if opts.Agent.SubnetsPerChildSA < 0 {
+		return fmt.Errorf("agent subnets per child SA can not be negative")
+	}
+
 	policy := corev1.PullPolicy(opts.Agent.ImagePullPolicy)
 	if policy != corev1.PullAlways &&
 		policy != corev1.PullIfNotPresent &&
@@ -375,7 +1194,9 @@ func (opts Options) Validate() (err error) {
 	return nil
 }
 
-func createCertManager(cli client.Client, key client.ObjectKey, validPeriod time.Duration) (certutil.Manager, *rsa.PrivateKey, error) {
+// createCertManager creates cert manager by CA secret, caKey is used instead of the key in
+// CA secret if it's not nil
+func createCertManager(cli client.Client, key client.ObjectKey, validPeriod time.Duration, caKey crypto.Signer) (certutil.Manager, crypto.Signer, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -385,41 +1206,117 @@ func createCertManager(cli client.Client, key client.ObjectKey, validPeriod time
 	if err != nil {
 		return nil, nil, err
 	}
-	certPEM, keyPEM := secretutil.GetCA(secret)
 
-	certDER, err := certutil.DecodePEM(certPEM)
+	// CA cert may be followed by its issuers if it's an intermediate CA
+	chain, trustedCADERs, err := getCAsOfSecret(secret)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if caKey != nil {
+		// CA key is kept out of secret, so CA can't be rotated and there is only one CA
+		certManager, err := certutil.NewMangerWithChain(chain[0], caKey, chain[1:], validPeriod)
+		return certManager, caKey, err
+	}
+
+	// tokens are signed by the key of CA cert, which is replaced only when the old CA is retired
+	_, caKeyPEM := secretutil.GetCA(secret)
+	caKeyDER, err := certutil.DecodePEM(caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, err := certutil.ParsePrivateKey(caKeyDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, keyPEM := secretutil.GetSigningCA(secret)
 	keyDER, err := certutil.DecodePEM(keyPEM)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(keyDER)
+	signingKey, err := certutil.ParsePrivateKey(keyDER)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	certManager, err := certutil.NewManger(certDER, keyDER, validPeriod)
+	certManager, err := certutil.NewMangerWithChain(chain[0], signingKey, chain[1:], validPeriod, trustedCADERs...)
 	return certManager, privateKey, err
 }
 
+// getCAsOfSecret returns the chain of signing CA in which the signing CA comes first and its
+// issuers follow, and certs of other trusted CAs in CA secret
+func getCAsOfSecret(secret corev1.Secret) (chain [][]byte, trustedCADERs [][]byte, err error) {
+	signingCA, _ := secretutil.GetSigningCA(secret)
+	chain, err = certutil.DecodeCertsPEM(signingCA)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, pem := range secretutil.GetTrustedCACerts(secret) {
+		ders, err := certutil.DecodeCertsPEM(pem)
+		if err != nil {
+			return nil, nil, err
+		}
+		trustedCADERs = append(trustedCADERs, ders...)
+	}
+
+	return chain, trustedCADERs, nil
+}
+
 func (opts Options) RunManager() error {
+	if opts.Teardown {
+		return opts.runTeardown()
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), opts.Tracing)
+	if err != nil {
+		log.Error(err, "failed to set up tracing")
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error(err, "failed to flush spans")
+		}
+	}()
+
 	if err := opts.Manager.Add(manager.RunnableFunc(opts.initializeControllers)); err != nil {
 		log.Error(err, "failed to add init runnable")
 		return err
 	}
 
 	if opts.ClusterRole == RoleHost {
-		if err := opts.Manager.Add(manager.RunnableFunc(opts.runAPIServer)); err != nil {
+		// API server runs on every replica, so member clusters can still sign certificates and
+		// export endpoints when the leader is down
+		if err := opts.Manager.Add(leaderIndependentRunnable(opts.runAPIServer)); err != nil {
 			log.Error(err, "failed to add api server runnable")
 			return err
 		}
+
+		if opts.GRPCServer != nil {
+			if err := opts.Manager.Add(leaderIndependentRunnable(opts.runGRPCServer)); err != nil {
+				log.Error(err, "failed to add grpc server runnable")
+				return err
+			}
+		}
+
+		if err := opts.Manager.Add(opts.restartOnCAChange(opts.getCAsFromSecret)); err != nil {
+			log.Error(err, "failed to add CA watcher")
+			return err
+		}
+	} else {
+		if err := opts.Manager.Add(opts.restartOnCAChange(opts.getCAsFromHost)); err != nil {
+			log.Error(err, "failed to add CA watcher")
+			return err
+		}
 	}
 
-	err := opts.Manager.Start(signals.SetupSignalHandler())
+	err = opts.Manager.Start(signals.SetupSignalHandler())
 	if err != nil {
 		log.Error(err, "failed to start controller manager")
 	}
@@ -427,6 +1324,49 @@ func (opts Options) RunManager() error {
 	return err
 }
 
+// runTeardown runs manager only to remove everything fabedge made, manager is
+// stopped when it's finished
+func (opts Options) runTeardown() error {
+	ctx, cancel := context.WithCancel(signals.SetupSignalHandler())
+	defer cancel()
+
+	err := opts.Manager.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		defer cancel()
+		return newTeardown(opts).runUntilDone(ctx, 5*time.Second)
+	}))
+	if err != nil {
+		log.Error(err, "failed to add teardown runnable")
+		return err
+	}
+
+	if err = opts.Manager.Start(ctx); err != nil {
+		log.Error(err, "failed to tear down")
+	}
+
+	return err
+}
+
+// leaderIndependentRunnable is a runnable which runs no matter whether this replica is leader
+type leaderIndependentRunnable func(ctx context.Context) error
+
+func (r leaderIndependentRunnable) Start(ctx context.Context) error {
+	return r(ctx)
+}
+
+func (r leaderIndependentRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// isLeader tells if this replica is elected as leader, it's always true if leader election is disabled
+func (opts Options) isLeader() bool {
+	select {
+	case <-opts.Manager.Elected():
+		return true
+	default:
+		return false
+	}
+}
+
 func (opts Options) runAPIServer(ctx context.Context) error {
 	errChan := make(chan error)
 
@@ -451,6 +1391,27 @@ func (opts Options) runAPIServer(ctx context.Context) error {
 	return err
 }
 
+func (opts Options) runGRPCServer(ctx context.Context) error {
+	listener, err := net.Listen("tcp", opts.GRPCServerListenAddress)
+	if err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- opts.GRPCServer.Serve(listener)
+	}()
+
+	select {
+	case err = <-errChan:
+		return err
+	case <-ctx.Done():
+		// streams of member clusters last long, so they are not waited
+		opts.GRPCServer.Stop()
+		return ctx.Err()
+	}
+}
+
 // initializeControllers adds controllers which are related to tunnels management to manager.
 // we have to put controller registry logic in a Runnable because allocator and store initialization
 // have to be done after leader election is finished, otherwise their data may be out of date
@@ -476,6 +1437,10 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 		return err
 	}
 
+	td := newTeardown(opts)
+	opts.Agent.IsTearingDown = td.isStarted
+	opts.Connector.IsTearingDown = td.isStarted
+
 	// todo: ugly!!! try to move getConnectorEndpoint init in Complete
 	getConnectorEndpoint, err := connectorctl.AddToManager(opts.Connector)
 	if err != nil {
@@ -484,11 +1449,61 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 	}
 
 	opts.Agent.GetConnectorEndpoint = getConnectorEndpoint
-	if err = agentctl.AddToManager(opts.Agent); err != nil {
+	opts.Agent.ConnectorEndpoints = make(map[string]types.EndpointGetter, len(opts.ExtraConnectorConfigs))
+	for _, cnf := range opts.ExtraConnectorConfigs {
+		cnf.IsTearingDown = td.isStarted
+		getEndpoint, err := connectorctl.AddToManager(cnf)
+		if err != nil {
+			log.Error(err, "failed to add connector controller to manager", "connector", cnf.Name)
+			return err
+		}
+		opts.Agent.ConnectorEndpoints[cnf.Name] = getEndpoint
+	}
+
+	updateAgentPodSettings, err := agentctl.AddToManager(opts.Agent)
+	if err != nil {
 		log.Error(err, "failed to add agent controller to manager")
 		return err
 	}
 
+	if opts.Agent.CSRSignerName != "" {
+		if err = csrsigner.AddToManager(csrsigner.Config{
+			Manager:         opts.Manager,
+			SignerName:      opts.Agent.CSRSignerName,
+			CertManager:     opts.Agent.CertManager,
+			GetEndpointName: opts.Agent.GetEndpointName,
+			GetEndpointID:   opts.Agent.GetEndpointID,
+		}); err != nil {
+			log.Error(err, "failed to add csr signer to manager")
+			return err
+		}
+	}
+
+	if opts.FabEdgeName != "" {
+		var teardown func(ctx context.Context) (bool, error)
+		if opts.TeardownOnFabEdgeDeletion {
+			teardown = td.run
+		}
+
+		err = fabedgectl.AddToManager(fabedgectl.Config{
+			Manager: opts.Manager,
+			Name:    opts.FabEdgeName,
+			Validate: func(spec apis.FabEdgeSpec) error {
+				return opts.withFabEdgeSpec(spec).Validate()
+			},
+			Apply: func(spec apis.FabEdgeSpec) []string {
+				desired := opts.withFabEdgeSpec(spec)
+				updateAgentPodSettings(desired.Agent.PodSettings())
+				return opts.restartRequiredFields(desired)
+			},
+			Teardown: teardown,
+		})
+		if err != nil {
+			log.Error(err, "failed to add FabEdge config controller to manager")
+			return err
+		}
+	}
+
 	if err = cmmctl.AddToManager(cmmctl.Config{
 		Manager: opts.Manager,
 		Store:   opts.Store,
@@ -497,6 +1512,27 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 		return err
 	}
 
+	if opts.AutoCommunity.LabelKey != "" {
+		if err = autocmmctl.AddToManager(opts.AutoCommunity); err != nil {
+			log.Error(err, "failed to add auto community controller to manager")
+			return err
+		}
+	}
+
+	if opts.OpenYurt {
+		if err = openyurtctl.AddToManager(openyurtctl.Config{Manager: opts.Manager}); err != nil {
+			log.Error(err, "failed to add node pool controller to manager")
+			return err
+		}
+	}
+
+	if opts.Agent.KubeEdge {
+		if err = kubeedgectl.AddToManager(kubeedgectl.Config{Manager: opts.Manager}); err != nil {
+			log.Error(err, "failed to add kubeedge node labeler to manager")
+			return err
+		}
+	}
+
 	if opts.Agent.EnableProxy {
 		if err = proxyctl.AddToManager(opts.Proxy); err != nil {
 			log.Error(err, "failed to add proxy controller to manager")
@@ -504,20 +1540,163 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 		}
 	}
 
-	if err = clusterctl.AddToManager(clusterctl.Config{
-		Cluster:       opts.Cluster,
-		Manager:       opts.Manager,
-		PrivateKey:    opts.PrivateKey,
-		TokenDuration: opts.TokenValidPeriod,
-		Store:         opts.Store,
-	}); err != nil {
+	opts.ClusterCtl.Cluster = opts.Cluster
+	opts.ClusterCtl.Manager = opts.Manager
+	opts.ClusterCtl.PrivateKey = opts.PrivateKey
+	opts.ClusterCtl.TokenDuration = opts.TokenValidPeriod
+	opts.ClusterCtl.Store = opts.Store
+	if err = clusterctl.AddToManager(opts.ClusterCtl); err != nil {
 		log.Error(err, "failed to add cluster controller to manager")
 		return err
 	}
 
+	if opts.ClusterCtl.Tokens != nil {
+		if err = tokencleaner.AddToManager(tokencleaner.Config{
+			Manager:   opts.Manager,
+			Namespace: opts.Namespace,
+		}); err != nil {
+			log.Error(err, "failed to add token cleaner to manager")
+			return err
+		}
+	}
+
+	if opts.FailoverDrill.Interval > 0 {
+		opts.FailoverDrill.Namespace = opts.Namespace
+		opts.FailoverDrill.ConnectorName = opts.Connector.Name
+		opts.FailoverDrill.ConnectorLabels = opts.Connector.ConnectorLabels
+		opts.FailoverDrill.Window, _ = routines.ParseTimeWindow(opts.DrillWindow)
+		opts.FailoverDrill.Client = opts.Manager.GetClient()
+		opts.FailoverDrill.Log = opts.Manager.GetLogger().WithName("FailoverDrill")
+		if err = opts.Manager.Add(&opts.FailoverDrill); err != nil {
+			log.Error(err, "failed to add failover drill to manager")
+			return err
+		}
+	}
+
+	if opts.EnableConnectivityCheck {
+		if err = checkctl.AddToManager(checkctl.Config{
+			Manager:         opts.Manager,
+			Namespace:       opts.Namespace,
+			Image:           opts.Agent.AgentImage,
+			ImagePullPolicy: corev1.PullPolicy(opts.Agent.ImagePullPolicy),
+		}); err != nil {
+			log.Error(err, "failed to add connectivity check controller to manager")
+			return err
+		}
+	}
+
+	if opts.Prober.Interval > 0 {
+		opts.Prober.Namespace = opts.Namespace
+		opts.Prober.Image = opts.Agent.AgentImage
+		opts.Prober.ImagePullPolicy = corev1.PullPolicy(opts.Agent.ImagePullPolicy)
+		opts.Prober.EdgeLabels = opts.EdgeLabels
+		opts.Prober.ConnectorLabels = opts.Connector.ConnectorLabels
+		opts.Prober.GetEndpointName = opts.Agent.GetEndpointName
+		opts.Prober.Store = opts.Store
+		opts.Prober.SyncInterval = 30 * time.Second
+		opts.Prober.Client = opts.Manager.GetClient()
+		opts.Prober.Log = opts.Manager.GetLogger().WithName("ConnectivityProber")
+		if err = opts.Manager.Add(&opts.Prober); err != nil {
+			log.Error(err, "failed to add connectivity prober to manager")
+			return err
+		}
+	}
+
+	if opts.SyncGlobalNetworkSets {
+		err = opts.Manager.Add(&routines.GlobalNetworkSetSyncer{
+			Store:        opts.Store,
+			SyncInterval: 10 * time.Second,
+			Client:       opts.Manager.GetClient(),
+			Log:          opts.Manager.GetLogger().WithName("GlobalNetworkSetSyncer"),
+		})
+		if err != nil {
+			log.Error(err, "failed to add global network set syncer to manager")
+			return err
+		}
+	}
+
+	if opts.Agent.ProbeInterval > 0 {
+		err = opts.Manager.Add(&routines.ConnectivityAggregator{
+			Namespace:        opts.Namespace,
+			GetEndpointName:  opts.Agent.GetEndpointName,
+			ConnectorConfigs: opts.Connector.ConfigResource,
+			Interval:         opts.Agent.ProbeInterval,
+			Client:           opts.Manager.GetClient(),
+			Log:              opts.Manager.GetLogger().WithName("ConnectivityAggregator"),
+		})
+		if err != nil {
+			log.Error(err, "failed to add connectivity aggregator to manager")
+			return err
+		}
+	}
+
+	if opts.AlertWebhookURL != "" {
+		var secret []byte
+		if opts.AlertWebhookSecretFile != "" {
+			if secret, err = ioutil.ReadFile(opts.AlertWebhookSecretFile); err != nil {
+				log.Error(err, "failed to read alert webhook secret")
+				return err
+			}
+		}
+
+		err = opts.Manager.Add(&routines.TunnelAlerter{
+			Namespace:        opts.Namespace,
+			Cluster:          opts.Cluster,
+			GetEndpointName:  opts.Agent.GetEndpointName,
+			AgentConfigs:     opts.Agent.ConfigResource,
+			ConnectorConfigs: opts.Connector.ConfigResource,
+			MemberClusters:   opts.ClusterRole == RoleHost,
+			Threshold:        opts.AlertThreshold,
+			Interval:         time.Minute,
+			Notifier: webhook.Sender{
+				URL:        opts.AlertWebhookURL,
+				Secret:     bytes.TrimSpace(secret),
+				HTTPClient: &http.Client{Timeout: 10 * time.Second},
+				Log:        log.WithName("alert-webhook"),
+				Format:     opts.AlertWebhookFormat,
+			},
+			Client: opts.Manager.GetClient(),
+			Log:    opts.Manager.GetLogger().WithName("TunnelAlerter"),
+		})
+		if err != nil {
+			log.Error(err, "failed to add tunnel alerter to manager")
+			return err
+		}
+	}
+
+	if opts.ServeTopology {
+		err = opts.Manager.AddMetricsExtraHandler("/topology", topology.Loader{
+			Namespace:        opts.Namespace,
+			AgentConfigs:     opts.Agent.ConfigResource,
+			ConnectorConfigs: opts.Connector.ConfigResource,
+			Client:           opts.Manager.GetClient(),
+		})
+		if err != nil {
+			log.Error(err, "failed to serve topology")
+			return err
+		}
+	}
+
+	if opts.CertRenewalWindow > 0 {
+		err = opts.Manager.Add(&routines.CertExpiryMonitor{
+			Namespace:     opts.Namespace,
+			RenewalWindow: opts.CertRenewalWindow,
+			CheckInterval: time.Hour,
+			FabEdgeName:   opts.FabEdgeName,
+			Client:        opts.Manager.GetClient(),
+			Recorder:      opts.Manager.GetEventRecorderFor("fabedge-operator"),
+			Log:           opts.Manager.GetLogger().WithName("CertExpiryMonitor"),
+		})
+		if err != nil {
+			log.Error(err, "failed to add certificate expiry monitor to manager")
+			return err
+		}
+	}
+
 	if opts.ClusterRole == RoleHost {
 		reporter := &routines.LocalClusterReporter{
 			Cluster:      opts.Cluster,
+			Namespace:    opts.Namespace,
 			GetConnector: getConnectorEndpoint,
 			SyncInterval: 10 * time.Second,
 			Client:       opts.Manager.GetClient(),
@@ -527,14 +1706,55 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 			log.Error(err, "failed to add local cluster reporter to manager")
 			return err
 		}
+
+		// a new CA key can't be generated in KMS or HSM by operator
+		if opts.CAKeySignerCommand == "" {
+			err = opts.Manager.Add(&routines.CARotator{
+				SecretKey:        client.ObjectKey{Name: opts.CASecretName, Namespace: opts.Namespace},
+				DistributePeriod: opts.CARotationDistributePeriod,
+				KeyType:          certutil.KeyType(opts.CertKeyType),
+				KeySize:          opts.CertKeySize,
+				CheckInterval:    30 * time.Second,
+				Client:           opts.Manager.GetClient(),
+				Log:              opts.Manager.GetLogger().WithName("CARotator"),
+			})
+			if err != nil {
+				log.Error(err, "failed to add CA rotator to manager")
+				return err
+			}
+		}
+
+		if opts.CRL != nil {
+			if err = opts.Manager.Add(routines.UpdateCRL(time.Hour, opts.CRL.Update)); err != nil {
+				log.Error(err, "failed to start routine to update CRL")
+				return err
+			}
+		}
 	} else {
-		err = opts.Manager.Add(routines.LoadEndpointsAndCommunities(
-			timeutil.Seconds(10),
-			opts.Store,
-			opts.APIClient.GetEndpointsAndCommunities,
-		))
+		if opts.APIServerStream {
+			err = opts.Manager.Add(routines.StreamEndpointsAndCommunities(
+				3*apiserver.DefaultStreamPingInterval,
+				timeutil.Seconds(10),
+				opts.Store,
+				opts.APIClient.StreamEndpointsAndCommunities,
+				opts.APIClient.GetEndpointsAndCommunities,
+			))
+		} else if opts.EndpointsWatchTimeout > 0 {
+			err = opts.Manager.Add(routines.WatchEndpointsAndCommunities(
+				opts.EndpointsWatchTimeout,
+				timeutil.Seconds(10),
+				opts.Store,
+				opts.APIClient.WatchEndpointsAndCommunities,
+			))
+		} else {
+			err = opts.Manager.Add(routines.LoadEndpointsAndCommunities(
+				timeutil.Seconds(10),
+				opts.Store,
+				opts.APIClient.GetEndpointsAndCommunities,
+			))
+		}
 		if err != nil {
-			log.Error(err, "failed to start loadEndpointsAndCommunities routine")
+			log.Error(err, "failed to start routine to load endpoints and communities")
 			return err
 		}
 
@@ -542,11 +1762,54 @@ func (opts Options) initializeControllers(ctx context.Context) error {
 			timeutil.Seconds(10),
 			getConnectorEndpoint,
 			opts.APIClient.UpdateEndpoints,
+			opts.APIClient.PatchEndpoints,
 		))
 		if err != nil {
 			log.Error(err, "failed to start exportEndpoints routine")
 			return err
 		}
+
+		err = opts.Manager.Add(routines.Heartbeat(opts.HeartbeatInterval, opts.APIClient.Heartbeat))
+		if err != nil {
+			log.Error(err, "failed to start heartbeat routine")
+			return err
+		}
+
+		if opts.CRLSecretName != "" {
+			err = opts.Manager.Add(routines.SyncCRL(
+				timeutil.Minutes(5),
+				client.ObjectKey{Name: opts.CRLSecretName, Namespace: opts.Namespace},
+				opts.Manager.GetClient(),
+				opts.APIClient.GetCRL,
+			))
+			if err != nil {
+				log.Error(err, "failed to start routine to sync CRL")
+				return err
+			}
+		}
+
+		err = opts.Manager.Add(&routines.ClientCertRenewer{
+			SecretKey:          client.ObjectKey{Name: ClientTLSSecretName, Namespace: opts.Namespace},
+			CommonName:         opts.Cluster + apiserver.ClientCommonNameSuffix,
+			Organization:       opts.CertOrganization,
+			KeyType:            certutil.KeyType(opts.CertKeyType),
+			KeySize:            opts.CertKeySize,
+			SignatureAlgorithm: opts.certSignatureAlgorithm(),
+			CheckInterval:      time.Hour,
+			Client:             opts.Manager.GetClient(),
+			CACert:             opts.Agent.CertManager.GetCACert(),
+			SignCert:           opts.APIClient.SignCert,
+			OnRenewed: func(cert tls.Certificate) {
+				opts.apiClientCert.Set(cert)
+				// connections made with the old certificate are closed when they are idle
+				opts.apiClientTransport.CloseIdleConnections()
+			},
+			Log: opts.Manager.GetLogger().WithName("ClientCertRenewer"),
+		})
+		if err != nil {
+			log.Error(err, "failed to start client certificate renewer")
+			return err
+		}
 	}
 
 	return nil
@@ -623,7 +1886,7 @@ func (opts Options) recordIPAMBlocks(ctx context.Context) error {
 	return nil
 }
 
-func (opts *Options) initAPIClient(kubeClient client.Client, cacert fclient.Certificate) error {
+func (opts *Options) initAPIClient(kubeClient client.Client, cacert fclient.Certificate, trustedCACerts []fclient.Certificate) error {
 	key := client.ObjectKey{
 		Name:      ClientTLSSecretName,
 		Namespace: opts.Namespace,
@@ -631,6 +1894,9 @@ func (opts *Options) initAPIClient(kubeClient client.Client, cacert fclient.Cert
 
 	certPool := x509.NewCertPool()
 	certPool.AddCert(cacert.Raw)
+	for _, cert := range trustedCACerts {
+		certPool.AddCert(cert.Raw)
+	}
 
 	var secret corev1.Secret
 	err := kubeClient.Get(context.Background(), key, &secret)
@@ -654,31 +1920,54 @@ func (opts *Options) initAPIClient(kubeClient client.Client, cacert fclient.Cert
 		return err
 	}
 
-	opts.APIClient, err = fclient.NewClient(opts.APIServerAddress, opts.Cluster, &http.Transport{
-		TLSClientConfig: &tls.Config{
-			RootCAs:      certPool,
-			Certificates: []tls.Certificate{cert},
-		},
-	})
+	opts.apiClientCert = &fclient.CertificateStore{}
+	opts.apiClientCert.Set(cert)
+	opts.apiClientTransport = &http.Transport{
+		TLSClientConfig: fips.TLSConfig(&tls.Config{
+			RootCAs:              certPool,
+			GetClientCertificate: opts.apiClientCert.GetClientCertificate,
+		}),
+		// with HTTP/2, other requests share one connection with the stream, but if API server or a proxy
+		// in between only speaks HTTP/1.1, they are sent through other connections
+		ForceAttemptHTTP2: opts.APIServerStream,
+	}
+
+	opts.APIClient, err = fclient.NewClient(opts.APIServerAddress, opts.Cluster, opts.apiClientTransport, fclient.PageSize(opts.EndpointsPageSize))
 	if err != nil {
 		log.Error(err, "failed to create API client")
 		return err
 	}
+	log.V(3).Info("API version of host cluster is negotiated", "version", opts.APIClient.APIVersion())
 
 	return nil
 }
 
+// certSignatureAlgorithm returns the algorithm to sign certificate requests, it's validated before
+func (opts Options) certSignatureAlgorithm() x509.SignatureAlgorithm {
+	algorithm, _ := certutil.ParseSignatureAlgorithm(opts.CertSignatureAlgorithm)
+	return algorithm
+}
+
 func (opts Options) createTLSSecretForClient(kubeClient client.Client, certPool *x509.CertPool, cacert fclient.Certificate) (secret corev1.Secret, err error) {
 	keyDER, csrDER, err := certutil.NewCertRequest(certutil.Request{
-		CommonName:   fmt.Sprintf("%s.fabedge-client", opts.Cluster),
-		Organization: []string{opts.CertOrganization},
+		CommonName:         opts.Cluster + apiserver.ClientCommonNameSuffix,
+		Organization:       []string{opts.CertOrganization},
+		KeyType:            certutil.KeyType(opts.CertKeyType),
+		KeySize:            opts.CertKeySize,
+		SignatureAlgorithm: opts.certSignatureAlgorithm(),
 	})
 	if err != nil {
 		log.Error(err, "failed to create certificate request")
 		return secret, err
 	}
 
-	cert, err := fclient.SignCertByToken(opts.APIServerAddress, opts.InitToken, csrDER, certPool)
+	initToken, err := opts.getInitToken(kubeClient)
+	if err != nil {
+		log.Error(err, "failed to get init token")
+		return secret, err
+	}
+
+	cert, err := fclient.SignCertByToken(opts.APIServerAddress, initToken, csrDER, certPool)
 	if err != nil {
 		log.Error(err, "failed to create certificate for API client")
 		return secret, err
@@ -696,6 +1985,34 @@ func (opts Options) createTLSSecretForClient(kubeClient client.Client, certPool
 	return secret, err
 }
 
+// getInitToken returns the init token from argument, file or secret in order
+func (opts Options) getInitToken(kubeClient client.Client) (string, error) {
+	if opts.InitToken != "" {
+		return opts.InitToken, nil
+	}
+
+	if opts.InitTokenFile != "" {
+		content, err := ioutil.ReadFile(opts.InitTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	var secret corev1.Secret
+	key := client.ObjectKey{Name: opts.InitTokenSecret, Namespace: opts.Namespace}
+	if err := kubeClient.Get(context.Background(), key, &secret); err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(secret.Data[KeyInitToken]))
+	if token == "" {
+		return "", fmt.Errorf("no token is found in secret %s", key)
+	}
+
+	return token, nil
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -703,3 +2020,88 @@ func fileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// getHostCA gets the CA cert which signs certificates and other trusted CA certs from host cluster
+func getHostCA(apiServerAddr string) (fclient.Certificate, []fclient.Certificate, error) {
+	certs, err := fclient.GetCABundle(apiServerAddr)
+	if fclient.IsStatus(err, http.StatusNotFound) {
+		// API server of old versions has no CA bundle
+		cacert, err := fclient.GetCertificate(apiServerAddr)
+		return cacert, nil, err
+	}
+	if err != nil {
+		return fclient.Certificate{}, nil, err
+	}
+	if len(certs) == 0 {
+		return fclient.Certificate{}, nil, fmt.Errorf("no CA cert is got from host cluster")
+	}
+
+	// the CA which signs certificates comes last
+	return certs[len(certs)-1], certs[:len(certs)-1], nil
+}
+
+// restartOnCAChange returns a runnable which checks CA certs got by getCAs periodically, if they are
+// different from those of cert manager, it returns an error to stop operator, so cert manager,
+// API server and API client are created with the new CA certs after operator is restarted
+func (opts Options) restartOnCAChange(getCAs func(ctx context.Context) ([][]byte, error)) leaderIndependentRunnable {
+	return func(ctx context.Context) error {
+		expected, err := certutil.DecodeCertsPEM(opts.Agent.CertManager.GetCABundlePEM())
+		if err != nil {
+			return err
+		}
+
+		tick := time.NewTicker(time.Minute)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return nil
+			}
+
+			cas, err := getCAs(ctx)
+			if err != nil {
+				log.Error(err, "failed to get CA certs")
+				continue
+			}
+
+			if !reflect.DeepEqual(cas, expected) {
+				log.Info("CA certs are changed, restart operator to reload them")
+				return fmt.Errorf("CA certs are changed")
+			}
+		}
+	}
+}
+
+// getCAsFromSecret returns CA certs from CA secret in the same order as cert manager's CA bundle
+func (opts Options) getCAsFromSecret(ctx context.Context) ([][]byte, error) {
+	var secret corev1.Secret
+	err := opts.Manager.GetAPIReader().Get(ctx, client.ObjectKey{Name: opts.CASecretName, Namespace: opts.Namespace}, &secret)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, trustedCADERs, err := getCAsOfSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// issuers of signing CA come right before it, the same as CA bundle of cert manager
+	return certutil.CABundleOf(chain[0], append(trustedCADERs, chain[1:]...)), nil
+}
+
+// getCAsFromHost returns CA certs from host cluster in the same order as cert manager's CA bundle
+func (opts Options) getCAsFromHost(ctx context.Context) ([][]byte, error) {
+	cacert, trustedCACerts, err := getHostCA(opts.APIServerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var cas [][]byte
+	for _, cert := range trustedCACerts {
+		cas = append(cas, cert.DER)
+	}
+
+	return append(cas, cacert.DER), nil
+}
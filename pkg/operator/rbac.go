@@ -0,0 +1,404 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	proxyctl "github.com/fabedge/fabedge/pkg/operator/controllers/proxy"
+)
+
+const (
+	operatorServiceAccount = "fabedge-operator"
+
+	groupCore         = ""
+	groupFabEdge      = "fabedge.io"
+	groupApps         = "apps"
+	groupDiscovery    = "discovery.k8s.io"
+	groupCoordination = "coordination.k8s.io"
+	groupCertificates = "certificates.k8s.io"
+	groupAuthn        = "authentication.k8s.io"
+	groupCalico       = "crd.projectcalico.org"
+	groupOpenYurt     = "apps.openyurt.io"
+)
+
+// readVerbs are needed by every type which is read through the cache of manager,
+// the cache watches objects in all namespaces
+var readVerbs = []string{"get", "list", "watch"}
+
+// policyRules collects verbs of resources, rules of the same resource are merged
+type policyRules map[string]sets.String
+
+func (rules policyRules) allow(group string, resources []string, verbs ...string) {
+	for _, resource := range resources {
+		key := group + "/" + resource
+		if rules[key] == nil {
+			rules[key] = sets.NewString()
+		}
+		rules[key].Insert(verbs...)
+	}
+}
+
+// build returns rules sorted by group and resource, resources of the same group and verbs
+// are put in one rule
+func (rules policyRules) build() []rbacv1.PolicyRule {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var policyRules []rbacv1.PolicyRule
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 2)
+		group, resource, verbs := parts[0], parts[1], rules[key].List()
+
+		if n := len(policyRules); n > 0 {
+			last := &policyRules[n-1]
+			if last.APIGroups[0] == group && sets.NewString(last.Verbs...).Equal(rules[key]) {
+				last.Resources = append(last.Resources, resource)
+				continue
+			}
+		}
+
+		policyRules = append(policyRules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: []string{resource},
+			Verbs:     verbs,
+		})
+	}
+
+	return policyRules
+}
+
+// rbacPolicy is the RBAC rules which operator needs with its arguments, namespaced
+// rules are kept in roles of their namespaces
+type rbacPolicy struct {
+	cluster    policyRules
+	namespaces map[string]policyRules
+	// signerNames are the signers of CertificateSigningRequests which operator signs
+	signerNames []string
+	// agentRules are the rules which agents need, agents need no rules if it's empty
+	agentRules policyRules
+	// agentServiceAccount tells if agent pods use a service account
+	agentServiceAccount bool
+	// proberRules are the rules which probers need in the namespace of operator, probers
+	// are not deployed if it's empty
+	proberRules policyRules
+}
+
+func (p *rbacPolicy) namespace(name string) policyRules {
+	if p.namespaces[name] == nil {
+		p.namespaces[name] = policyRules{}
+	}
+	return p.namespaces[name]
+}
+
+// rbacPolicy works out the least RBAC rules which operator needs, it follows what Complete,
+// RunManager and initializeControllers do with the same options, so it has to be updated
+// when any of them accesses a new resource
+func (opts Options) rbacPolicy() rbacPolicy {
+	p := rbacPolicy{
+		cluster:     policyRules{},
+		namespaces:  map[string]policyRules{},
+		agentRules:  policyRules{},
+		proberRules: policyRules{},
+	}
+	ns := p.namespace(opts.Namespace)
+
+	// events are recorded on cluster scoped objects too, which are put in default namespace
+	p.cluster.allow(groupCore, []string{"events"}, "create", "patch")
+
+	if opts.ManagerOpts.LeaderElection {
+		lockNamespace := opts.ManagerOpts.LeaderElectionNamespace
+		if lockNamespace == "" {
+			lockNamespace = opts.Namespace
+		}
+
+		lock := opts.ManagerOpts.LeaderElectionResourceLock
+		if strings.Contains(lock, "configmaps") {
+			p.namespace(lockNamespace).allow(groupCore, []string{"configmaps"}, "get", "create", "update")
+		}
+		if strings.Contains(lock, "leases") {
+			p.namespace(lockNamespace).allow(groupCoordination, []string{"leases"}, "get", "create", "update")
+		}
+	}
+
+	// CA secret, API client secret, secrets and configmaps of agents and connector
+	p.cluster.allow(groupCore, []string{"secrets", "configmaps", "pods"}, readVerbs...)
+	ns.allow(groupCore, []string{"secrets", "configmaps"}, "create", "update", "patch", "delete")
+	// agent pods are applied by server side apply, connector pods are deleted to reload certificates
+	ns.allow(groupCore, []string{"pods"}, "create", "patch", "delete")
+
+	// agent and connector controllers, pod CIDRs are recorded in annotations of nodes
+	p.cluster.allow(groupCore, []string{"nodes"}, readVerbs...)
+	p.cluster.allow(groupCore, []string{"nodes"}, "update", "patch")
+
+	// community and cluster controllers
+	p.cluster.allow(groupFabEdge, []string{"communities", "clusters"}, readVerbs...)
+	p.cluster.allow(groupFabEdge, []string{"communities", "clusters"}, "update")
+	p.cluster.allow(groupFabEdge, []string{"communities/status", "clusters/status"}, "update")
+
+	if opts.CNIType == constants.CNICalico {
+		p.cluster.allow(groupCalico, []string{"ipamblocks"}, readVerbs...)
+	}
+
+	if opts.Agent.CSRSignerName != "" {
+		p.cluster.allow(groupCertificates, []string{"certificatesigningrequests"}, append(readVerbs, "create", "delete")...)
+		p.cluster.allow(groupCertificates, []string{"certificatesigningrequests/status"}, "update")
+		p.signerNames = append(p.signerNames, opts.Agent.CSRSignerName)
+	}
+
+	if opts.FabEdgeName != "" || opts.Teardown {
+		p.cluster.allow(groupFabEdge, []string{"fabedges"}, readVerbs...)
+		p.cluster.allow(groupFabEdge, []string{"fabedges"}, "update")
+		p.cluster.allow(groupFabEdge, []string{"fabedges/status"}, "update")
+	}
+
+	if opts.TeardownOnFabEdgeDeletion || opts.Teardown {
+		ns.allow(groupCore, []string{"pods", "secrets", "configmaps"}, "deletecollection")
+	}
+
+	if opts.AutoCommunity.LabelKey != "" || opts.OpenYurt {
+		p.cluster.allow(groupFabEdge, []string{"communities"}, "create", "delete")
+	}
+
+	if opts.OpenYurt {
+		p.cluster.allow(groupOpenYurt, []string{"nodepools"}, readVerbs...)
+	}
+
+	// services with local ingress ports are exposed by agents
+	if opts.Agent.LocalIngressInterface != "" {
+		p.cluster.allow(groupCore, []string{"services"}, readVerbs...)
+	}
+
+	if opts.Agent.EnableProxy {
+		p.cluster.allow(groupCore, []string{"services"}, readVerbs...)
+		p.cluster.allow(groupDiscovery, []string{"endpointslices"}, readVerbs...)
+		if opts.Proxy.EndpointsSource != proxyctl.EndpointsSourceEndpointSlice {
+			p.cluster.allow(groupCore, []string{"endpoints"}, readVerbs...)
+		}
+
+		if opts.Agent.DetectKubeProxy || opts.Agent.KubeEdge {
+			p.cluster.allow(groupApps, []string{"daemonsets"}, readVerbs...)
+		}
+	}
+
+	// SuperEdge components on edge nodes are found by their daemonsets
+	if opts.Agent.SuperEdge {
+		p.cluster.allow(groupApps, []string{"daemonsets"}, readVerbs...)
+	}
+
+	if opts.Connector.ConfigResource {
+		p.cluster.allow(groupFabEdge, []string{"connectorconfigs"}, readVerbs...)
+		ns.allow(groupFabEdge, []string{"connectorconfigs"}, "create", "update", "patch", "delete")
+		if opts.TeardownOnFabEdgeDeletion || opts.Teardown {
+			ns.allow(groupFabEdge, []string{"connectorconfigs"}, "deletecollection")
+		}
+	}
+
+	if opts.Agent.ConfigResource {
+		p.cluster.allow(groupFabEdge, []string{"agentconfigs"}, readVerbs...)
+		ns.allow(groupFabEdge, []string{"agentconfigs"}, "create", "patch", "delete")
+		if opts.TeardownOnFabEdgeDeletion || opts.Teardown {
+			ns.allow(groupFabEdge, []string{"agentconfigs"}, "deletecollection")
+		}
+
+		// agents get their config and report status
+		p.agentServiceAccount = true
+		p.agentRules.allow(groupFabEdge, []string{"agentconfigs"}, readVerbs...)
+		p.agentRules.allow(groupFabEdge, []string{"agentconfigs/status"}, "update")
+	}
+
+	// connectivity aggregator reads probes reported by agents and connectors
+	if opts.Agent.ProbeInterval > 0 && opts.Connector.ConfigResource {
+		p.cluster.allow(groupFabEdge, []string{"connectorconfigs"}, readVerbs...)
+	}
+
+	if opts.FailoverDrill.Interval > 0 {
+		p.cluster.allow(groupFabEdge, []string{"drillreports"}, append(readVerbs, "create", "delete")...)
+	}
+
+	// probe pods of checks are created and deleted like agent pods
+	if opts.EnableConnectivityCheck {
+		p.cluster.allow(groupFabEdge, []string{"connectivitychecks"}, readVerbs...)
+		p.cluster.allow(groupFabEdge, []string{"connectivitychecks/status"}, "update")
+	}
+
+	// probers are deployed by a DaemonSet and record events on their pods
+	if opts.Prober.Interval > 0 {
+		p.cluster.allow(groupApps, []string{"daemonsets"}, readVerbs...)
+		p.cluster.allow(groupCore, []string{"services"}, readVerbs...)
+		ns.allow(groupApps, []string{"daemonsets"}, "create", "update")
+		ns.allow(groupCore, []string{"services"}, "create", "update")
+		p.proberRules.allow(groupCore, []string{"events"}, "create", "patch")
+	}
+
+	if opts.SyncGlobalNetworkSets {
+		p.cluster.allow(groupCalico, []string{"globalnetworksets"}, append(readVerbs, "create", "update", "delete")...)
+	}
+
+	if opts.ClusterRole == RoleHost {
+		// the local cluster is reported by operator itself, tokens of member clusters are kept in secrets
+		p.cluster.allow(groupFabEdge, []string{"clusters"}, "create")
+		ns.allow(groupCore, []string{"secrets"}, "deletecollection")
+	}
+
+	if opts.Agent.CertBootstrap {
+		// tokens of agent pods are verified by TokenReview
+		p.cluster.allow(groupAuthn, []string{"tokenreviews"}, "create")
+		p.agentServiceAccount = true
+	}
+
+	if opts.Agent.NodeCondition != "" {
+		p.agentServiceAccount = true
+		p.agentRules.allow(groupCore, []string{"nodes"}, "get")
+		p.agentRules.allow(groupCore, []string{"nodes/status"}, "patch")
+	}
+
+	if opts.Agent.RelayTimeout > 0 {
+		// agents annotate their nodes as relay-only
+		p.agentServiceAccount = true
+		p.agentRules.allow(groupCore, []string{"nodes"}, "patch")
+	}
+
+	return p
+}
+
+// objects returns ClusterRoles, Roles, ServiceAccounts and bindings of operator, agents and probers
+func (p rbacPolicy) objects(namespace string) []runtime.Object {
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: operatorServiceAccount},
+		Rules:      p.cluster.build(),
+	}
+	if len(p.signerNames) > 0 {
+		clusterRole.Rules = append(clusterRole.Rules, rbacv1.PolicyRule{
+			APIGroups:     []string{groupCertificates},
+			Resources:     []string{"signers"},
+			ResourceNames: p.signerNames,
+			Verbs:         []string{"sign"},
+		})
+	}
+
+	objects := []runtime.Object{
+		clusterRole,
+		newServiceAccount(operatorServiceAccount, namespace),
+		newClusterRoleBinding(operatorServiceAccount, namespace),
+	}
+
+	namespaces := make([]string, 0, len(p.namespaces))
+	for name := range p.namespaces {
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+
+	for _, name := range namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: operatorServiceAccount, Namespace: name},
+				Rules:      p.namespaces[name].build(),
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: operatorServiceAccount, Namespace: name},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: operatorServiceAccount},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: operatorServiceAccount, Namespace: namespace}},
+			},
+		)
+	}
+
+	if p.agentServiceAccount {
+		objects = append(objects, newServiceAccount("fabedge-agent", namespace))
+	}
+
+	if len(p.agentRules) > 0 {
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: "fabedge-agent"},
+				Rules:      p.agentRules.build(),
+			},
+			newClusterRoleBinding("fabedge-agent", namespace),
+		)
+	}
+
+	if len(p.proberRules) > 0 {
+		objects = append(objects,
+			newServiceAccount(constants.AppProber, namespace),
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: constants.AppProber, Namespace: namespace},
+				Rules:      p.proberRules.build(),
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: constants.AppProber, Namespace: namespace},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: constants.AppProber},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: constants.AppProber, Namespace: namespace}},
+			},
+		)
+	}
+
+	return objects
+}
+
+func newServiceAccount(name, namespace string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+}
+
+func newClusterRoleBinding(name, namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}},
+	}
+}
+
+// writeRBAC writes manifests of the least RBAC rules which operator and agents need
+// with the current arguments to w in YAML
+func (opts Options) writeRBAC(w io.Writer) error {
+	serializer := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme, json.SerializerOptions{Yaml: true})
+
+	for i, obj := range opts.rbacPolicy().objects(opts.Namespace) {
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+
+		if err := serializer.Encode(obj, w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
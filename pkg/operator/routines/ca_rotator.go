@@ -0,0 +1,222 @@
+package routines
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+// CARotator drives CA rotation through the phases recorded in annotations of CA secret.
+// Administrator starts a rotation by setting phase to Requested, then a new CA is generated and
+// distributed. When the distribute period is passed and all TLS secrets made by operator carry
+// the new CA, the new CA starts to sign certificates. Administrator sets phase to Retiring when
+// all certificates are reissued, then the old CA is removed.
+// Operator restarts itself when the CAs which sign or are trusted are changed, so a rotator only
+// changes CA secret and leaves certificates to others
+type CARotator struct {
+	SecretKey client.ObjectKey
+	// DistributePeriod is the least time to distribute the new CA before it signs certificates,
+	// member clusters and edge nodes which are offline longer than it may miss the new CA
+	DistributePeriod time.Duration
+	// KeyType is the algorithm of the new CA's key
+	KeyType certutil.KeyType
+	// KeySize is the size of the new CA's key, see certutil.NewSelfSignedCA
+	KeySize       int
+	CheckInterval time.Duration
+	Client        client.Client
+	Log           logr.Logger
+}
+
+func (r *CARotator) Start(ctx context.Context) error {
+	tick := time.NewTicker(r.CheckInterval)
+
+	r.rotate(ctx)
+	for {
+		select {
+		case <-tick.C:
+			r.rotate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *CARotator) rotate(ctx context.Context) {
+	var secret corev1.Secret
+	if err := r.Client.Get(ctx, r.SecretKey, &secret); err != nil {
+		r.Log.Error(err, "failed to get CA secret")
+		return
+	}
+
+	switch secretutil.GetCARotationPhase(secret) {
+	case secretutil.CARotationRequested:
+		r.generateNewCA(ctx, secret)
+	case secretutil.CARotationDistributing:
+		r.startReissuing(ctx, secret)
+	case secretutil.CARotationReissuing:
+		r.countPendingCerts(ctx, secret)
+	case secretutil.CARotationRetiring:
+		r.retireOldCA(ctx, secret)
+	}
+}
+
+func (r *CARotator) generateNewCA(ctx context.Context, secret corev1.Secret) {
+	oldCA, err := parseCertPEM(secretutil.GetCACert(secret))
+	if err != nil {
+		r.Log.Error(err, "failed to parse CA cert")
+		return
+	}
+
+	certDER, keyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+		CommonName:     oldCA.Subject.CommonName,
+		Organization:   oldCA.Subject.Organization,
+		IsCA:           true,
+		ValidityPeriod: oldCA.NotAfter.Sub(oldCA.NotBefore),
+		KeyType:        r.KeyType,
+		KeySize:        r.KeySize,
+	})
+	if err != nil {
+		r.Log.Error(err, "failed to generate new CA")
+		return
+	}
+
+	secret.Data[secretutil.KeyNewCACert] = certutil.EncodeCertPEM(certDER)
+	secret.Data[secretutil.KeyNewCAKey] = certutil.EncodePrivateKeyPEM(keyDER)
+	if r.updatePhase(ctx, &secret, secretutil.CARotationDistributing) {
+		r.Log.Info("new CA is generated and being distributed", "distributePeriod", r.DistributePeriod)
+	}
+}
+
+func (r *CARotator) startReissuing(ctx context.Context, secret corev1.Secret) {
+	startTime, err := time.Parse(time.RFC3339, secret.Annotations[secretutil.AnnotationCARotationTime])
+	if err == nil && time.Since(startTime) < r.DistributePeriod {
+		return
+	}
+
+	newCACertPEM := secret.Data[secretutil.KeyNewCACert]
+	secrets, err := r.listTLSSecrets(ctx)
+	if err != nil {
+		r.Log.Error(err, "failed to list TLS secrets")
+		return
+	}
+
+	for _, s := range secrets {
+		if !bytes.Contains(secretutil.GetCABundle(s), newCACertPEM) {
+			r.Log.V(3).Info("new CA is not distributed to TLS secret yet", "secretName", s.Name)
+			return
+		}
+	}
+
+	if r.updatePhase(ctx, &secret, secretutil.CARotationReissuing) {
+		r.Log.Info("new CA is distributed, certificates will be reissued by new CA")
+	}
+}
+
+func (r *CARotator) countPendingCerts(ctx context.Context, secret corev1.Secret) {
+	newCA, err := parseCertPEM(secret.Data[secretutil.KeyNewCACert])
+	if err != nil {
+		r.Log.Error(err, "failed to parse new CA cert")
+		return
+	}
+
+	secrets, err := r.listTLSSecrets(ctx)
+	if err != nil {
+		r.Log.Error(err, "failed to list TLS secrets")
+		return
+	}
+
+	pending := 0
+	for _, s := range secrets {
+		cert, err := parseCertPEM(secretutil.GetCert(s))
+		if err != nil || cert.CheckSignatureFrom(newCA) != nil {
+			pending++
+		}
+	}
+
+	value := strconv.Itoa(pending)
+	if secret.Annotations[secretutil.AnnotationCARotationPending] == value {
+		return
+	}
+
+	secret.Annotations[secretutil.AnnotationCARotationPending] = value
+	if err = r.Client.Update(ctx, &secret); err != nil {
+		r.Log.Error(err, "failed to update pending certificates of CA rotation")
+		return
+	}
+
+	if pending == 0 {
+		r.Log.Info("all certificates of this cluster are reissued by new CA, set phase to Retiring when member clusters are done too")
+	}
+}
+
+func (r *CARotator) retireOldCA(ctx context.Context, secret corev1.Secret) {
+	newCACertPEM, newCAKeyPEM := secret.Data[secretutil.KeyNewCACert], secret.Data[secretutil.KeyNewCAKey]
+	if len(newCACertPEM) > 0 && len(newCAKeyPEM) > 0 {
+		secret.Data[secretutil.KeyCACert] = newCACertPEM
+		secret.Data[secretutil.KeyCAKey] = newCAKeyPEM
+	}
+	delete(secret.Data, secretutil.KeyNewCACert)
+	delete(secret.Data, secretutil.KeyNewCAKey)
+
+	delete(secret.Annotations, secretutil.AnnotationCARotationPhase)
+	delete(secret.Annotations, secretutil.AnnotationCARotationTime)
+	delete(secret.Annotations, secretutil.AnnotationCARotationPending)
+
+	if err := r.Client.Update(ctx, &secret); err != nil {
+		r.Log.Error(err, "failed to retire old CA")
+		return
+	}
+
+	r.Log.Info("old CA is retired, CA rotation is finished")
+}
+
+func (r *CARotator) updatePhase(ctx context.Context, secret *corev1.Secret, phase string) bool {
+	secret.Annotations[secretutil.AnnotationCARotationPhase] = phase
+	secret.Annotations[secretutil.AnnotationCARotationTime] = time.Now().UTC().Format(time.RFC3339)
+	if err := r.Client.Update(ctx, secret); err != nil {
+		r.Log.Error(err, "failed to update phase of CA rotation", "phase", phase)
+		return false
+	}
+
+	return true
+}
+
+// listTLSSecrets returns TLS secrets made by operator in the namespace of CA secret
+func (r *CARotator) listTLSSecrets(ctx context.Context) ([]corev1.Secret, error) {
+	var secrets corev1.SecretList
+	err := r.Client.List(ctx, &secrets,
+		client.InNamespace(r.SecretKey.Namespace),
+		client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsSecrets []corev1.Secret
+	for _, s := range secrets.Items {
+		if len(secretutil.GetCert(s)) > 0 {
+			tlsSecrets = append(tlsSecrets, s)
+		}
+	}
+
+	return tlsSecrets, nil
+}
+
+func parseCertPEM(data []byte) (*x509.Certificate, error) {
+	der, err := certutil.DecodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,169 @@
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("CARotator", func() {
+	const namespace = "ca-rotation"
+
+	var (
+		rotator     *CARotator
+		certManager certutil.Manager
+		caSecret    corev1.Secret
+		tlsSecret   corev1.Secret
+	)
+
+	getSecret := func(key client.ObjectKey) corev1.Secret {
+		var secret corev1.Secret
+		Expect(k8sClient.Get(context.Background(), key, &secret)).Should(Succeed())
+		return secret
+	}
+
+	setPhase := func(phase string) {
+		secret := getSecret(rotator.SecretKey)
+		secret.Annotations = map[string]string{secretutil.AnnotationCARotationPhase: phase}
+		Expect(k8sClient.Update(context.Background(), &secret)).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		err := k8sClient.Create(context.Background(), &ns)
+		Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		certManager, err = certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		caSecret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "fabedge-ca",
+				Namespace: namespace,
+			},
+			Data: map[string][]byte{
+				secretutil.KeyCACert: certutil.EncodeCertPEM(caCertDER),
+				secretutil.KeyCAKey:  certutil.EncodePrivateKeyPEM(caKeyDER),
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), &caSecret)).Should(Succeed())
+
+		certDER, keyDER, err := certManager.NewCertKey(certutil.Config{
+			CommonName:     "edge1",
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		})
+		Expect(err).Should(BeNil())
+
+		tlsSecret = secretutil.TLSSecret().
+			Name("fabedge-agent-tls-edge1").
+			Namespace(namespace).
+			EncodeCert(certDER).
+			EncodeKey(keyDER).
+			CACertPEM(certManager.GetCACertPEM()).
+			Label(constants.KeyCreatedBy, constants.AppOperator).
+			Build()
+		Expect(k8sClient.Create(context.Background(), &tlsSecret)).Should(Succeed())
+
+		rotator = &CARotator{
+			SecretKey:     client.ObjectKey{Name: caSecret.Name, Namespace: namespace},
+			CheckInterval: time.Hour,
+			Client:        k8sClient,
+			Log:           klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.Delete(context.Background(), &caSecret)).Should(Succeed())
+		Expect(k8sClient.Delete(context.Background(), &tlsSecret)).Should(Succeed())
+	})
+
+	It("should do nothing if CA is not being rotated", func() {
+		rotator.rotate(context.Background())
+
+		secret := getSecret(rotator.SecretKey)
+		Expect(secret.Annotations).Should(BeEmpty())
+		Expect(secret.Data).Should(Equal(caSecret.Data))
+	})
+
+	It("should rotate CA through phases", func() {
+		By("Generating new CA when rotation is requested")
+		setPhase(secretutil.CARotationRequested)
+		rotator.rotate(context.Background())
+
+		secret := getSecret(rotator.SecretKey)
+		Expect(secretutil.GetCARotationPhase(secret)).Should(Equal(secretutil.CARotationDistributing))
+		Expect(secret.Annotations).Should(HaveKey(secretutil.AnnotationCARotationTime))
+		Expect(secretutil.GetCACert(secret)).Should(Equal(caSecret.Data[secretutil.KeyCACert]))
+		newCACertPEM, newCAKeyPEM := secret.Data[secretutil.KeyNewCACert], secret.Data[secretutil.KeyNewCAKey]
+		Expect(newCACertPEM).ShouldNot(BeEmpty())
+		Expect(newCAKeyPEM).ShouldNot(BeEmpty())
+
+		signingCert, _ := secretutil.GetSigningCA(secret)
+		Expect(signingCert).Should(Equal(caSecret.Data[secretutil.KeyCACert]))
+		Expect(secretutil.GetTrustedCACerts(secret)).Should(Equal([][]byte{newCACertPEM}))
+
+		By("Waiting for the new CA to be distributed")
+		rotator.DistributePeriod = time.Hour
+		tls := getSecret(client.ObjectKeyFromObject(&tlsSecret))
+		tls.Data[secretutil.KeyCABundle] = append(append([]byte{}, newCACertPEM...), certManager.GetCACertPEM()...)
+		Expect(k8sClient.Update(context.Background(), &tls)).Should(Succeed())
+
+		rotator.rotate(context.Background())
+		Expect(secretutil.GetCARotationPhase(getSecret(rotator.SecretKey))).Should(Equal(secretutil.CARotationDistributing))
+
+		By("Starting to reissue certificates after distribute period")
+		rotator.DistributePeriod = 0
+		rotator.rotate(context.Background())
+
+		secret = getSecret(rotator.SecretKey)
+		Expect(secretutil.GetCARotationPhase(secret)).Should(Equal(secretutil.CARotationReissuing))
+		signingCert, signingKey := secretutil.GetSigningCA(secret)
+		Expect(signingCert).Should(Equal(newCACertPEM))
+		Expect(signingKey).Should(Equal(newCAKeyPEM))
+		Expect(secretutil.GetTrustedCACerts(secret)).Should(Equal([][]byte{caSecret.Data[secretutil.KeyCACert]}))
+
+		By("Counting certificates which are not reissued")
+		rotator.rotate(context.Background())
+		Expect(getSecret(rotator.SecretKey).Annotations[secretutil.AnnotationCARotationPending]).Should(Equal("1"))
+
+		By("Retiring old CA")
+		setPhase(secretutil.CARotationRetiring)
+		rotator.rotate(context.Background())
+
+		secret = getSecret(rotator.SecretKey)
+		Expect(secret.Annotations).Should(BeEmpty())
+		Expect(secretutil.GetCACert(secret)).Should(Equal(newCACertPEM))
+		Expect(secretutil.GetCAKey(secret)).Should(Equal(newCAKeyPEM))
+		Expect(secret.Data).ShouldNot(HaveKey(secretutil.KeyNewCACert))
+		Expect(secret.Data).ShouldNot(HaveKey(secretutil.KeyNewCAKey))
+	})
+
+	It("should not reissue certificates until new CA is distributed to TLS secrets", func() {
+		setPhase(secretutil.CARotationRequested)
+		rotator.rotate(context.Background())
+		rotator.rotate(context.Background())
+
+		Expect(secretutil.GetCARotationPhase(getSecret(rotator.SecretKey))).Should(Equal(secretutil.CARotationDistributing))
+	})
+})
@@ -0,0 +1,180 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+const (
+	ReasonCertExpiring    = "CertificateExpiring"
+	ReasonCertsExpiring   = "CertificatesExpiring"
+	ReasonNoCertsExpiring = "NoCertificatesExpiring"
+)
+
+// CertExpiryDays is the number of days until certificates in secrets made by operator
+// expire, it's negative if a certificate is expired
+var CertExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "fabedge",
+	Subsystem: "operator",
+	Name:      "cert_expiry_days",
+	Help:      "Days until the certificate in a secret made by operator expires, partitioned by namespace and secret",
+}, []string{"namespace", "secret"})
+
+func init() {
+	metrics.Registry.MustRegister(CertExpiryDays)
+}
+
+// CertExpiryMonitor scans TLS secrets made by operator, e.g. secrets of agents, connector and
+// API client, and reports how long their certificates remain valid. When a certificate is
+// within RenewalWindow, a warning event is recorded on its secret, and if FabEdgeName is set,
+// condition CertificatesExpiring of that FabEdge resource tells which secrets are expiring
+type CertExpiryMonitor struct {
+	Namespace     string
+	RenewalWindow time.Duration
+	CheckInterval time.Duration
+	// FabEdgeName is the name of FabEdge resource to set condition, empty means no condition is set
+	FabEdgeName string
+	Client      client.Client
+	Recorder    record.EventRecorder
+	Log         logr.Logger
+}
+
+func (m *CertExpiryMonitor) Start(ctx context.Context) error {
+	tick := time.NewTicker(m.CheckInterval)
+
+	m.check(ctx)
+	for {
+		select {
+		case <-tick.C:
+			m.check(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *CertExpiryMonitor) check(ctx context.Context) {
+	var secrets corev1.SecretList
+	err := m.Client.List(ctx, &secrets,
+		client.InNamespace(m.Namespace),
+		client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator},
+	)
+	if err != nil {
+		m.Log.Error(err, "failed to list secrets")
+		return
+	}
+
+	// secrets which are removed since last check shouldn't be reported any more
+	CertExpiryDays.Reset()
+
+	var expiring []string
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Type != corev1.SecretTypeTLS {
+			continue
+		}
+
+		cert, err := parseCertOfSecret(*secret)
+		if err != nil {
+			m.Log.Error(err, "failed to parse certificate of secret", "secret", secret.Name)
+			continue
+		}
+
+		remaining := time.Until(cert.NotAfter)
+		CertExpiryDays.WithLabelValues(secret.Namespace, secret.Name).Set(remaining.Hours() / 24)
+
+		if remaining > m.RenewalWindow {
+			continue
+		}
+
+		expiring = append(expiring, secret.Name)
+		m.Log.V(3).Info("certificate is within renewal window", "secret", secret.Name, "notAfter", cert.NotAfter)
+		if m.Recorder != nil {
+			m.Recorder.Eventf(secret, corev1.EventTypeWarning, ReasonCertExpiring,
+				"certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	if m.FabEdgeName == "" {
+		return
+	}
+
+	if err = m.setCondition(ctx, expiring); err != nil {
+		m.Log.Error(err, "failed to set certificate expiry condition of FabEdge", "name", m.FabEdgeName)
+	}
+}
+
+func (m *CertExpiryMonitor) setCondition(ctx context.Context, expiring []string) error {
+	var fabedge apis.FabEdge
+	if err := m.Client.Get(ctx, client.ObjectKey{Name: m.FabEdgeName}, &fabedge); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               apis.FabEdgeConditionCertificatesExpiring,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: fabedge.Generation,
+		Reason:             ReasonNoCertsExpiring,
+		Message:            "no certificates are within renewal window",
+	}
+	if len(expiring) > 0 {
+		sort.Strings(expiring)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonCertsExpiring
+		condition.Message = fmt.Sprintf("certificates of these secrets are within renewal window: %s", strings.Join(expiring, ", "))
+	}
+
+	old := meta.FindStatusCondition(fabedge.Status.Conditions, condition.Type)
+	if old != nil && old.Status == condition.Status && old.Message == condition.Message {
+		return nil
+	}
+
+	meta.SetStatusCondition(&fabedge.Status.Conditions, condition)
+	return m.Client.Status().Update(ctx, &fabedge)
+}
+
+func parseCertOfSecret(secret corev1.Secret) (*x509.Certificate, error) {
+	certPEM, _ := secretutil.GetCertAndKey(secret)
+	certDER, err := certutil.DecodePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(certDER)
+}
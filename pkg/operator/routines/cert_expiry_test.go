@@ -0,0 +1,114 @@
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("CertExpiryMonitor", func() {
+	var (
+		monitor  *CertExpiryMonitor
+		recorder *record.FakeRecorder
+		secret   corev1.Secret
+		fabedge  apis.FabEdge
+	)
+
+	BeforeEach(func() {
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		certManager, err := certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		certDER, keyDER, err := certManager.NewCertKey(certutil.Config{
+			CommonName:     "cluster1.edge1",
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		})
+		Expect(err).Should(BeNil())
+
+		secret = secretutil.TLSSecret().
+			Name("fabedge-agent-tls-edge1").
+			Namespace("default").
+			EncodeCert(certDER).
+			EncodeKey(keyDER).
+			CACertPEM(certManager.GetCACertPEM()).
+			Label(constants.KeyCreatedBy, constants.AppOperator).
+			Build()
+		Expect(k8sClient.Create(context.Background(), &secret)).Should(Succeed())
+
+		fabedge = apis.FabEdge{
+			ObjectMeta: metav1.ObjectMeta{Name: "fabedge"},
+		}
+		Expect(k8sClient.Create(context.Background(), &fabedge)).Should(Succeed())
+
+		recorder = record.NewFakeRecorder(10)
+		monitor = &CertExpiryMonitor{
+			Namespace:     "default",
+			RenewalWindow: 2 * time.Hour,
+			CheckInterval: time.Hour,
+			FabEdgeName:   fabedge.Name,
+			Client:        k8sClient,
+			Recorder:      recorder,
+			Log:           klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.Delete(context.Background(), &secret)).Should(Succeed())
+		Expect(k8sClient.Delete(context.Background(), &fabedge)).Should(Succeed())
+	})
+
+	It("should report days until expiry of certificates", func() {
+		monitor.check(context.Background())
+
+		days := promtestutil.ToFloat64(CertExpiryDays.WithLabelValues(secret.Namespace, secret.Name))
+		Expect(days).Should(BeNumerically("~", 1.0/24, 0.01))
+	})
+
+	It("should record event and set condition if a certificate is within renewal window", func() {
+		monitor.check(context.Background())
+
+		Expect(recorder.Events).Should(Receive(ContainSubstring(ReasonCertExpiring)))
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: fabedge.Name}, &fabedge)).Should(Succeed())
+		condition := meta.FindStatusCondition(fabedge.Status.Conditions, apis.FabEdgeConditionCertificatesExpiring)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).Should(ContainSubstring(secret.Name))
+	})
+
+	It("should not record event if no certificates are within renewal window", func() {
+		monitor.RenewalWindow = 30 * time.Minute
+		monitor.check(context.Background())
+
+		Expect(recorder.Events).ShouldNot(Receive())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: fabedge.Name}, &fabedge)).Should(Succeed())
+		condition := meta.FindStatusCondition(fabedge.Status.Conditions, apis.FabEdgeConditionCertificatesExpiring)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+	})
+})
@@ -0,0 +1,136 @@
+package routines
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+type SignCertFunc func(csr []byte) (fclient.Certificate, error)
+
+// ClientCertRenewer renews the certificate of API client before it expires. The new certificate
+// is signed by host cluster with the current one, then it's saved into the same secret and
+// passed to OnRenewed, so API client can use it for new connections
+type ClientCertRenewer struct {
+	SecretKey    client.ObjectKey
+	CommonName   string
+	Organization string
+	// KeyType is the algorithm of the key of new certificate
+	KeyType certutil.KeyType
+	// KeySize is the size of the key of new certificate, see certutil.NewPrivateKey
+	KeySize int
+	// SignatureAlgorithm is the algorithm to sign certificate request
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// RenewBefore is how long before expiry the certificate is renewed,
+	// if it's zero, the certificate is renewed when 2/3 of validity period passed
+	RenewBefore time.Duration
+	// CACert is the CA which signs certificates in host cluster, the certificate is renewed if it's
+	// not issued by CACert, which happens when CA is being rotated. nil means it's not checked
+	CACert        *x509.Certificate
+	CheckInterval time.Duration
+	Client        client.Client
+	SignCert      SignCertFunc
+	OnRenewed     func(cert tls.Certificate)
+	Log           logr.Logger
+}
+
+func (r *ClientCertRenewer) Start(ctx context.Context) error {
+	tick := time.NewTicker(r.CheckInterval)
+
+	r.renewIfNeeded(ctx)
+	for {
+		select {
+		case <-tick.C:
+			r.renewIfNeeded(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *ClientCertRenewer) renewIfNeeded(ctx context.Context) {
+	var secret corev1.Secret
+	if err := r.Client.Get(ctx, r.SecretKey, &secret); err != nil {
+		r.Log.Error(err, "failed to get secret of client certificate")
+		return
+	}
+
+	certPEM, _ := secretutil.GetCertAndKey(secret)
+	certDER, err := certutil.DecodePEM(certPEM)
+	if err != nil {
+		r.Log.Error(err, "failed to decode client certificate")
+		return
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		r.Log.Error(err, "failed to parse client certificate")
+		return
+	}
+
+	renewBefore := r.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = cert.NotAfter.Sub(cert.NotBefore) / 3
+	}
+
+	remaining := time.Until(cert.NotAfter)
+	issuedByCA := r.CACert == nil || cert.CheckSignatureFrom(r.CACert) == nil
+	if remaining > renewBefore && issuedByCA {
+		return
+	}
+
+	if remaining <= 0 {
+		r.Log.Info("client certificate is expired, reinstall operator with a new token if renewal fails", "notAfter", cert.NotAfter)
+	}
+
+	keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
+		CommonName:         r.CommonName,
+		Organization:       []string{r.Organization},
+		KeyType:            r.KeyType,
+		KeySize:            r.KeySize,
+		SignatureAlgorithm: r.SignatureAlgorithm,
+	})
+	if err != nil {
+		r.Log.Error(err, "failed to create certificate request")
+		return
+	}
+
+	newCert, err := r.SignCert(csr)
+	if err != nil {
+		if fclient.IsDeregistered(err) {
+			r.Log.V(3).Info("this cluster is deregistered, client certificate is not renewed")
+			return
+		}
+
+		r.Log.Error(err, "failed to renew client certificate")
+		return
+	}
+
+	keyPEM := certutil.EncodePrivateKeyPEM(keyDER)
+	keyPair, err := tls.X509KeyPair(newCert.PEM, keyPEM)
+	if err != nil {
+		r.Log.Error(err, "renewed client certificate doesn't match private key")
+		return
+	}
+
+	secret.Data[corev1.TLSCertKey] = newCert.PEM
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	if err = r.Client.Update(ctx, &secret); err != nil {
+		r.Log.Error(err, "failed to save renewed client certificate")
+		return
+	}
+
+	r.Log.V(3).Info("client certificate is renewed", "notAfter", newCert.Raw.NotAfter)
+	if r.OnRenewed != nil {
+		r.OnRenewed(keyPair)
+	}
+}
@@ -0,0 +1,125 @@
+package routines
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("ClientCertRenewer", func() {
+	var (
+		renewer     *ClientCertRenewer
+		certManager certutil.Manager
+		secret      corev1.Secret
+		renewedCert *tls.Certificate
+	)
+
+	BeforeEach(func() {
+		caCertDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		certManager, err = certutil.NewManger(caCertDER, caKeyDER, timeutil.Days(1))
+		Expect(err).Should(BeNil())
+
+		certDER, keyDER, err := certManager.NewCertKey(certutil.Config{
+			CommonName:     "cluster1.fabedge-client",
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		})
+		Expect(err).Should(BeNil())
+
+		secret = secretutil.TLSSecret().
+			Name("api-client-tls").
+			Namespace("default").
+			EncodeCert(certDER).
+			EncodeKey(keyDER).
+			CACertPEM(certManager.GetCACertPEM()).
+			Build()
+		Expect(k8sClient.Create(context.Background(), &secret)).Should(Succeed())
+
+		renewedCert = nil
+		renewer = &ClientCertRenewer{
+			SecretKey:     client.ObjectKey{Name: secret.Name, Namespace: secret.Namespace},
+			CommonName:    "cluster1.fabedge-client",
+			Organization:  certutil.DefaultOrganization,
+			CheckInterval: time.Hour,
+			Client:        k8sClient,
+			SignCert: func(csr []byte) (cert fclient.Certificate, err error) {
+				cert.DER, err = certManager.SignCert(csr)
+				if err != nil {
+					return cert, err
+				}
+				cert.PEM = certutil.EncodeCertPEM(cert.DER)
+				cert.Raw, err = x509.ParseCertificate(cert.DER)
+				return cert, err
+			},
+			OnRenewed: func(cert tls.Certificate) {
+				renewedCert = &cert
+			},
+			Log: klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.Delete(context.Background(), &secret)).Should(Succeed())
+	})
+
+	It("should not renew certificate which is far from expiry", func() {
+		renewer.renewIfNeeded(context.Background())
+		Expect(renewedCert).Should(BeNil())
+
+		var newSecret corev1.Secret
+		Expect(k8sClient.Get(context.Background(), renewer.SecretKey, &newSecret)).Should(Succeed())
+		Expect(newSecret.Data).Should(Equal(secret.Data))
+	})
+
+	It("should renew certificate which is about to expire", func() {
+		renewer.RenewBefore = 2 * time.Hour
+		renewer.renewIfNeeded(context.Background())
+		Expect(renewedCert).ShouldNot(BeNil())
+
+		var newSecret corev1.Secret
+		Expect(k8sClient.Get(context.Background(), renewer.SecretKey, &newSecret)).Should(Succeed())
+		certPEM, keyPEM := secretutil.GetCertAndKey(newSecret)
+		Expect(certPEM).ShouldNot(Equal(secret.Data[corev1.TLSCertKey]))
+		Expect(certManager.VerifyCertInPEM(certPEM, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+
+		keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+		Expect(err).Should(BeNil())
+		Expect(renewedCert.Certificate).Should(Equal(keyPair.Certificate))
+	})
+
+	It("should renew certificate which is not issued by CA of host cluster", func() {
+		newCACertDER, _, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			ValidityPeriod: timeutil.Days(1),
+			IsCA:           true,
+		})
+		Expect(err).Should(BeNil())
+
+		renewer.CACert, err = x509.ParseCertificate(newCACertDER)
+		Expect(err).Should(BeNil())
+
+		renewer.renewIfNeeded(context.Background())
+		Expect(renewedCert).ShouldNot(BeNil())
+	})
+})
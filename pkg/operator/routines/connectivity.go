@@ -0,0 +1,143 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+// ConnectivityAggregator puts probes reported by agents in status of AgentConfigs and by connectors
+// in status of ConnectorConfigs into status of communities, so each community has a matrix of
+// round-trip time and loss between its members, and from connectors to its members. Conditions
+// TunnelsEstablished and Ready of communities are set according to the matrix
+type ConnectivityAggregator struct {
+	Namespace string
+	// GetEndpointName returns the endpoint name of an edge node, AgentConfigs are named after edge nodes
+	GetEndpointName types.GetNameFunc
+	// ConnectorConfigs tells if connectors report probes in ConnectorConfigs
+	ConnectorConfigs bool
+	Interval         time.Duration
+	Client           client.Client
+	Log              logr.Logger
+}
+
+func (a *ConnectivityAggregator) Start(ctx context.Context) error {
+	tick := time.NewTicker(a.Interval)
+
+	a.aggregate(ctx)
+	for {
+		select {
+		case <-tick.C:
+			a.aggregate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (a *ConnectivityAggregator) aggregate(ctx context.Context) {
+	// probes are keyed by the endpoint names of reporters
+	probes := make(map[string][]apis.PeerProbe)
+	connectors := sets.NewString()
+
+	var agentConfigs apis.AgentConfigList
+	if err := a.Client.List(ctx, &agentConfigs, client.InNamespace(a.Namespace)); err != nil {
+		a.Log.Error(err, "failed to list agent configs")
+		return
+	}
+	for _, cfg := range agentConfigs.Items {
+		probes[a.GetEndpointName(cfg.Name)] = cfg.Status.Probes
+	}
+
+	if a.ConnectorConfigs {
+		var connectorConfigs apis.ConnectorConfigList
+		if err := a.Client.List(ctx, &connectorConfigs, client.InNamespace(a.Namespace)); err != nil {
+			a.Log.Error(err, "failed to list connector configs")
+			return
+		}
+		for _, cfg := range connectorConfigs.Items {
+			connectors.Insert(cfg.Spec.Endpoint.Name)
+			probes[cfg.Spec.Endpoint.Name] = cfg.Status.Probes
+		}
+	}
+
+	var communities apis.CommunityList
+	if err := a.Client.List(ctx, &communities); err != nil {
+		a.Log.Error(err, "failed to list communities")
+		return
+	}
+
+	for i := range communities.Items {
+		community := &communities.Items[i]
+		if community.DeletionTimestamp != nil {
+			continue
+		}
+
+		oldStatus := community.Status.DeepCopy()
+		community.Status.Connectivity = buildConnectivity(sets.NewString(community.Spec.Members...), connectors, probes)
+		types.SetCommunityTunnelsEstablished(community)
+		types.SetCommunityReady(community)
+		if equality.Semantic.DeepEqual(oldStatus, &community.Status) {
+			continue
+		}
+
+		if err := a.Client.Status().Update(ctx, community); err != nil {
+			a.Log.Error(err, "failed to update connectivity of community", "community", community.Name)
+		}
+	}
+}
+
+// buildConnectivity picks probes from members and connectors to other members, they are sorted by from and to
+func buildConnectivity(members, connectors sets.String, probes map[string][]apis.PeerProbe) []apis.Connectivity {
+	var connectivity []apis.Connectivity
+	for from, peerProbes := range probes {
+		if !members.Has(from) && !connectors.Has(from) {
+			continue
+		}
+
+		for _, probe := range peerProbes {
+			if probe.Peer == from || !members.Has(probe.Peer) {
+				continue
+			}
+
+			connectivity = append(connectivity, apis.Connectivity{
+				From:      from,
+				To:        probe.Peer,
+				RTT:       probe.RTT,
+				Loss:      probe.Loss,
+				ProbeTime: probe.ProbeTime,
+			})
+		}
+	}
+
+	sort.Slice(connectivity, func(i, j int) bool {
+		if connectivity[i].From != connectivity[j].From {
+			return connectivity[i].From < connectivity[j].From
+		}
+		return connectivity[i].To < connectivity[j].To
+	})
+
+	return connectivity
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+var _ = Describe("ConnectivityAggregator", func() {
+	var (
+		aggregator *ConnectivityAggregator
+		objects    []client.Object
+		probeTime  metav1.Time
+	)
+
+	BeforeEach(func() {
+		probeTime = metav1.NewTime(time.Now().Truncate(time.Second))
+		objects = nil
+
+		create := func(obj client.Object, setProbes func()) {
+			Expect(k8sClient.Create(context.Background(), obj)).Should(Succeed())
+			if setProbes != nil {
+				setProbes()
+				Expect(k8sClient.Status().Update(context.Background(), obj)).Should(Succeed())
+			}
+			objects = append(objects, obj)
+		}
+
+		edge1 := &apis.AgentConfig{ObjectMeta: metav1.ObjectMeta{Name: "edge1", Namespace: "default"}}
+		create(edge1, func() {
+			edge1.Status.Probes = []apis.PeerProbe{
+				{Peer: "cloud-connector", Loss: 0, RTT: &metav1.Duration{Duration: 30 * time.Millisecond}, ProbeTime: probeTime},
+				{Peer: "fabedge.edge2", Loss: 20, RTT: &metav1.Duration{Duration: 5 * time.Millisecond}, ProbeTime: probeTime},
+				{Peer: "fabedge.edge3", Loss: 100, ProbeTime: probeTime},
+			}
+		})
+
+		edge2 := &apis.AgentConfig{ObjectMeta: metav1.ObjectMeta{Name: "edge2", Namespace: "default"}}
+		create(edge2, func() {
+			edge2.Status.Probes = []apis.PeerProbe{
+				{Peer: "fabedge.edge1", Loss: 0, RTT: &metav1.Duration{Duration: 6 * time.Millisecond}, ProbeTime: probeTime},
+			}
+		})
+
+		connector := &apis.ConnectorConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "connector", Namespace: "default"},
+			Spec: apis.ConnectorConfigSpec{
+				Endpoint: apis.Endpoint{Name: "cloud-connector", ID: "C=CN, O=fabedge.io, CN=cloud-connector"},
+			},
+		}
+		create(connector, func() {
+			connector.Status.Probes = []apis.PeerProbe{
+				{Peer: "fabedge.edge1", Loss: 0, RTT: &metav1.Duration{Duration: 31 * time.Millisecond}, ProbeTime: probeTime},
+				{Peer: "fabedge.edge3", Loss: 0, RTT: &metav1.Duration{Duration: 40 * time.Millisecond}, ProbeTime: probeTime},
+			}
+		})
+
+		create(&apis.Community{
+			ObjectMeta: metav1.ObjectMeta{Name: "beijing"},
+			Spec:       apis.CommunitySpec{Members: []string{"fabedge.edge1", "fabedge.edge2"}},
+		}, nil)
+
+		aggregator = &ConnectivityAggregator{
+			Namespace:        "default",
+			GetEndpointName:  func(name string) string { return "fabedge." + name },
+			ConnectorConfigs: true,
+			Interval:         time.Minute,
+			Client:           k8sClient,
+			Log:              klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		for _, obj := range objects {
+			Expect(k8sClient.Delete(context.Background(), obj)).Should(Succeed())
+		}
+	})
+
+	It("should put probes between members and from connectors to members in status of communities", func() {
+		aggregator.aggregate(context.Background())
+
+		var community apis.Community
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "beijing"}, &community)).Should(Succeed())
+
+		connectivity := community.Status.Connectivity
+		Expect(connectivity).To(HaveLen(3))
+
+		Expect(connectivity[0].From).To(Equal("cloud-connector"))
+		Expect(connectivity[0].To).To(Equal("fabedge.edge1"))
+		Expect(connectivity[0].RTT.Duration).To(Equal(31 * time.Millisecond))
+
+		Expect(connectivity[1].From).To(Equal("fabedge.edge1"))
+		Expect(connectivity[1].To).To(Equal("fabedge.edge2"))
+		Expect(connectivity[1].Loss).To(Equal(int32(20)))
+		Expect(connectivity[1].ProbeTime.Equal(&probeTime)).To(BeTrue())
+
+		Expect(connectivity[2].From).To(Equal("fabedge.edge2"))
+		Expect(connectivity[2].To).To(Equal("fabedge.edge1"))
+	})
+
+	It("should set TunnelsEstablished and Ready conditions of communities", func() {
+		community := &apis.Community{
+			ObjectMeta: metav1.ObjectMeta{Name: "shanghai"},
+			Spec:       apis.CommunitySpec{Members: []string{"fabedge.edge1", "fabedge.edge3"}},
+		}
+		Expect(k8sClient.Create(context.Background(), community)).Should(Succeed())
+		objects = append(objects, community)
+
+		aggregator.aggregate(context.Background())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "beijing"}, community)).Should(Succeed())
+		Expect(meta.IsStatusConditionTrue(community.Status.Conditions, apis.ConditionTunnelsEstablished)).To(BeTrue())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "shanghai"}, community)).Should(Succeed())
+		condition := meta.FindStatusCondition(community.Status.Conditions, apis.ConditionTunnelsEstablished)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(types.ReasonTunnelsDown))
+		Expect(condition.Message).To(ContainSubstring("fabedge.edge1 -> fabedge.edge3"))
+
+		condition = meta.FindStatusCondition(community.Status.Conditions, apis.ConditionReady)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(types.ReasonTunnelsDown))
+	})
+
+	It("should not list connector configs if connectors don't report probes", func() {
+		aggregator.ConnectorConfigs = false
+		aggregator.aggregate(context.Background())
+
+		var community apis.Community
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: "beijing"}, &community)).Should(Succeed())
+		Expect(community.Status.Connectivity).To(HaveLen(2))
+		Expect(community.Status.Connectivity[0].From).To(Equal("fabedge.edge1"))
+	})
+})
@@ -0,0 +1,97 @@
+package routines
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+type UpdateCRLFunc func(ctx context.Context) error
+type GetCRLFunc func() ([]byte, error)
+
+// UpdateCRL makes CRLs of host cluster periodically, CRLs are signed again before they expire
+func UpdateCRL(interval time.Duration, update UpdateCRLFunc) manager.Runnable {
+	log := klogr.New().WithName("updateCRL")
+
+	fn := func(ctx context.Context) {
+		if err := update(ctx); err != nil {
+			log.Error(err, "failed to update CRL")
+		}
+	}
+
+	return Periodic(interval, fn)
+}
+
+// SyncCRL saves CRLs of host cluster into the secret of key periodically, agents and connectors
+// of this cluster load CRLs from it
+func SyncCRL(interval time.Duration, key client.ObjectKey, cli client.Client, getCRL GetCRLFunc) manager.Runnable {
+	log := klogr.New().WithName("syncCRL")
+
+	fn := func(ctx context.Context) {
+		crlPEM, err := getCRL()
+		switch {
+		case err == nil:
+		case fclient.IsStatus(err, http.StatusNotFound):
+			log.V(5).Info("host cluster has no CRL")
+			return
+		case fclient.IsDeregistered(err):
+			log.V(3).Info("this cluster is deregistered, CRL is not synced")
+			return
+		default:
+			log.Error(err, "failed to get CRL from host cluster")
+			return
+		}
+
+		if err = saveCRL(ctx, cli, key, crlPEM); err != nil {
+			log.Error(err, "failed to save CRL", "secret", key)
+		}
+	}
+
+	return Periodic(interval, fn)
+}
+
+func saveCRL(ctx context.Context, cli client.Client, key client.ObjectKey, crlPEM []byte) error {
+	var secret corev1.Secret
+	err := cli.Get(ctx, key, &secret)
+	if errors.IsNotFound(err) {
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					constants.KeyCreatedBy: constants.AppOperator,
+				},
+			},
+			Data: map[string][]byte{
+				secretutil.KeyCRL: crlPEM,
+			},
+		}
+		return cli.Create(ctx, &secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(secretutil.GetCRL(secret), crlPEM) {
+		return nil
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[secretutil.KeyCRL] = crlPEM
+
+	return cli.Update(ctx, &secret)
+}
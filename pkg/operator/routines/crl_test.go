@@ -0,0 +1,55 @@
+package routines
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
+)
+
+var _ = Describe("SyncCRL", func() {
+	var key = client.ObjectKey{Name: "fabedge-crl", Namespace: "default"}
+
+	AfterEach(func() {
+		secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), &secret))).Should(Succeed())
+	})
+
+	getCRL := func() []byte {
+		var secret corev1.Secret
+		if err := k8sClient.Get(context.Background(), key, &secret); err != nil {
+			return nil
+		}
+		return secretutil.GetCRL(secret)
+	}
+
+	It("can save CRLs got from host cluster into secret", func() {
+		var lock sync.Mutex
+		crlPEM := []byte("crl1")
+		getCRLFromHost := func() ([]byte, error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return crlPEM, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go SyncCRL(10*time.Millisecond, key, k8sClient, getCRLFromHost).Start(ctx)
+		Eventually(getCRL).Should(Equal([]byte("crl1")))
+
+		By("changing CRLs")
+		lock.Lock()
+		crlPEM = []byte("crl2")
+		lock.Unlock()
+		Eventually(getCRL).Should(Equal([]byte("crl2")))
+	})
+})
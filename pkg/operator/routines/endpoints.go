@@ -2,6 +2,7 @@ package routines
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -10,23 +11,69 @@ import (
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 	"github.com/fabedge/fabedge/pkg/operator/types"
 )
 
 type UpdateEndpointsFunc func(endpoints []apis.Endpoint) error
+type PatchEndpointsFunc func(delta apiserver.EndpointsDelta) error
 type GetEndpointsAndCommunitiesFunc func() (apiserver.EndpointsAndCommunity, error)
-
-func ExportEndpoints(interval time.Duration, getConnector types.EndpointGetter, updateEndpoints UpdateEndpointsFunc) manager.Runnable {
+type HeartbeatFunc func() error
+type WatchEndpointsAndCommunitiesFunc func(ctx context.Context, etag string, timeout time.Duration) (apiserver.EndpointsAndCommunity, string, error)
+type StreamEndpointsAndCommunitiesFunc func(ctx context.Context, idleTimeout time.Duration, onChange func(apiserver.EndpointsAndCommunity)) error
+
+// ExportEndpoints exports endpoints of this cluster to host cluster periodically. After all endpoints
+// are exported once, only changes are sent. If host cluster doesn't accept the changes, e.g. host
+// cluster has different endpoints or doesn't support patching, all endpoints are exported again
+func ExportEndpoints(interval time.Duration, getConnector types.EndpointGetter, updateEndpoints UpdateEndpointsFunc, patchEndpoints PatchEndpointsFunc) manager.Runnable {
 	log := klogr.New().WithName("exportEndpoints")
 
+	// exported is nil if endpoints are never exported or host cluster doesn't accept patching
+	var exported []apis.Endpoint
 	fn := func(ctx context.Context) {
-		err := updateEndpoints([]apis.Endpoint{
+		endpoints := []apis.Endpoint{
 			getConnector(),
-		})
+		}
 
-		if err != nil {
+		var err error
+		if exported != nil {
+			err = patchEndpoints(apiserver.NewEndpointsDelta(exported, endpoints))
+			if fclient.IsStatus(err, http.StatusConflict, http.StatusMethodNotAllowed) {
+				log.V(3).Info("host cluster doesn't accept endpoints delta, export all endpoints", "reason", err.Error())
+				err = updateEndpoints(endpoints)
+			}
+		} else {
+			err = updateEndpoints(endpoints)
+		}
+
+		switch {
+		case err == nil:
+			exported = endpoints
+		case fclient.IsDeregistered(err):
+			log.V(3).Info("this cluster is deregistered, endpoints are not exported")
+			exported = nil
+		default:
 			log.Error(err, "failed to export endpoints to host cluster")
+			exported = nil
+		}
+	}
+
+	return Periodic(interval, fn)
+}
+
+// Heartbeat reports to host cluster that this cluster is alive periodically
+func Heartbeat(interval time.Duration, heartbeat HeartbeatFunc) manager.Runnable {
+	log := klogr.New().WithName("heartbeat")
+
+	fn := func(ctx context.Context) {
+		err := heartbeat()
+		switch {
+		case err == nil:
+		case fclient.IsDeregistered(err):
+			log.V(3).Info("this cluster is deregistered, heartbeat is not accepted")
+		default:
+			log.Error(err, "failed to report heartbeat to host cluster")
 		}
 	}
 
@@ -35,42 +82,147 @@ func ExportEndpoints(interval time.Duration, getConnector types.EndpointGetter,
 
 func LoadEndpointsAndCommunities(interval time.Duration, store storepkg.Interface, getEndpointsAndCommunities GetEndpointsAndCommunitiesFunc) manager.Runnable {
 	log := klogr.New().WithName("loadEndpointsAndCommunities")
-
-	communitySet := sets.NewString()
-	endpointSet := sets.NewString()
+	loader := newEndpointsLoader(store)
 
 	fn := func(ctx context.Context) {
 		ec, err := getEndpointsAndCommunities()
 		if err != nil {
-			log.Error(err, "failed to load endpoints and communities")
-			return
+			if !fclient.IsDeregistered(err) {
+				log.Error(err, "failed to load endpoints and communities")
+				return
+			}
+
+			// endpoints and communities from host cluster are not valid any more
+			log.V(3).Info("this cluster is deregistered, clearing endpoints and communities from host cluster")
+			ec = apiserver.EndpointsAndCommunity{}
 		}
 
-		currentCommunitySet, currentEndpointSet := sets.NewString(), sets.NewString()
-		for name, members := range ec.Communities {
-			currentCommunitySet.Insert(name)
-			store.SaveCommunity(types.Community{
-				Name:    name,
-				Members: sets.NewString(members...),
-			})
-		}
+		loader.load(ec)
+	}
 
-		for _, endpoint := range ec.Endpoints {
-			currentEndpointSet.Insert(endpoint.Name)
-			store.SaveEndpoint(endpoint)
-		}
+	return Periodic(interval, fn)
+}
 
-		for name := range communitySet.Difference(currentCommunitySet) {
-			store.DeleteCommunity(name)
+// WatchEndpointsAndCommunities loads endpoints and communities from host cluster as soon as they change,
+// each watch request waits for changes at most timeout. If host cluster doesn't support watching, or
+// something goes wrong, it waits for interval before next request
+func WatchEndpointsAndCommunities(timeout, interval time.Duration, store storepkg.Interface, watch WatchEndpointsAndCommunitiesFunc) manager.Runnable {
+	log := klogr.New().WithName("watchEndpointsAndCommunities")
+	loader := newEndpointsLoader(store)
+
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		var etag string
+		for {
+			ec, newETag, err := watch(ctx, etag, timeout)
+
+			var wait time.Duration
+			switch {
+			case err == nil:
+				loader.load(ec)
+				etag = newETag
+				// an old host cluster responds immediately without etag
+				if etag == "" {
+					wait = interval
+				}
+			case err == fclient.ErrNotModified:
+			case fclient.IsDeregistered(err):
+				log.V(3).Info("this cluster is deregistered, clearing endpoints and communities from host cluster")
+				loader.load(apiserver.EndpointsAndCommunity{})
+				etag, wait = "", interval
+			default:
+				if ctx.Err() == nil {
+					log.Error(err, "failed to watch endpoints and communities")
+				}
+				wait = interval
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
 		}
+	})
+}
 
-		for name := range endpointSet.Difference(currentEndpointSet) {
-			store.DeleteEndpoint(name)
+// StreamEndpointsAndCommunities keeps a stream to host cluster, through which endpoints and communities
+// are pushed as soon as they change. The stream is established again after interval if it ends. If host
+// cluster doesn't support streaming, endpoints and communities are loaded by getEndpointsAndCommunities
+// every interval instead
+func StreamEndpointsAndCommunities(idleTimeout, interval time.Duration, store storepkg.Interface, stream StreamEndpointsAndCommunitiesFunc, getEndpointsAndCommunities GetEndpointsAndCommunitiesFunc) manager.Runnable {
+	log := klogr.New().WithName("streamEndpointsAndCommunities")
+	loader := newEndpointsLoader(store)
+
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		for {
+			err := stream(ctx, idleTimeout, loader.load)
+			switch {
+			case ctx.Err() != nil:
+			case err == nil:
+				log.V(5).Info("stream of endpoints and communities is ended by host cluster")
+			case fclient.IsStatus(err, http.StatusNotFound, http.StatusMethodNotAllowed):
+				log.V(5).Info("host cluster doesn't support streaming, load endpoints and communities instead")
+				ec, err := getEndpointsAndCommunities()
+				if err == nil {
+					loader.load(ec)
+				} else if !fclient.IsDeregistered(err) {
+					log.Error(err, "failed to load endpoints and communities")
+				}
+			case fclient.IsDeregistered(err):
+				log.V(3).Info("this cluster is deregistered, clearing endpoints and communities from host cluster")
+				loader.load(apiserver.EndpointsAndCommunity{})
+			default:
+				log.Error(err, "stream of endpoints and communities is broken")
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
 		}
+	})
+}
 
-		communitySet = currentCommunitySet
-		endpointSet = currentEndpointSet
+// endpointsLoader saves endpoints and communities from host cluster to store and
+// deletes those which are not in host cluster any more
+type endpointsLoader struct {
+	store        storepkg.Interface
+	communitySet sets.String
+	endpointSet  sets.String
+}
+
+func newEndpointsLoader(store storepkg.Interface) *endpointsLoader {
+	return &endpointsLoader{
+		store:        store,
+		communitySet: sets.NewString(),
+		endpointSet:  sets.NewString(),
 	}
+}
 
-	return Periodic(interval, fn)
+func (l *endpointsLoader) load(ec apiserver.EndpointsAndCommunity) {
+	currentCommunitySet, currentEndpointSet := sets.NewString(), sets.NewString()
+	for name, members := range ec.Communities {
+		currentCommunitySet.Insert(name)
+		l.store.SaveCommunity(types.Community{
+			Name:    name,
+			Members: sets.NewString(members...),
+		})
+	}
+
+	for _, endpoint := range ec.Endpoints {
+		currentEndpointSet.Insert(endpoint.Name)
+		l.store.SaveEndpoint(endpoint)
+	}
+
+	for name := range l.communitySet.Difference(currentCommunitySet) {
+		l.store.DeleteCommunity(name)
+	}
+
+	for name := range l.endpointSet.Difference(currentEndpointSet) {
+		l.store.DeleteEndpoint(name)
+	}
+
+	l.communitySet = currentCommunitySet
+	l.endpointSet = currentEndpointSet
 }
@@ -2,7 +2,11 @@ package routines
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -10,6 +14,7 @@ import (
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 	"github.com/fabedge/fabedge/pkg/operator/apiserver"
+	fclient "github.com/fabedge/fabedge/pkg/operator/client"
 	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
 )
 
@@ -97,4 +102,355 @@ var _ = Describe("LoadEndpointsAndCommunities", func() {
 		_, ok = store.GetEndpoint(e2.Name)
 		Expect(ok).Should(BeFalse())
 	})
+
+	It("should clear endpoints and communities when this cluster is deregistered", func() {
+		e1 := apis.Endpoint{
+			Name:            "cluster1.connector",
+			PublicAddresses: []string{"cluster1"},
+			Subnets:         []string{"2.2.2.0/24"},
+			NodeSubnets:     []string{"10.10.0.1/32"},
+		}
+
+		var (
+			lock         sync.RWMutex
+			deregistered bool
+		)
+		getEndpointsAndCommunities := func() (apiserver.EndpointsAndCommunity, error) {
+			lock.RLock()
+			defer lock.RUnlock()
+
+			if deregistered {
+				return apiserver.EndpointsAndCommunity{}, &fclient.HttpError{
+					Response: &http.Response{StatusCode: http.StatusGone},
+				}
+			}
+
+			return apiserver.EndpointsAndCommunity{
+				Communities: map[string][]string{"connectors": {e1.Name}},
+				Endpoints:   []apis.Endpoint{e1},
+			}, nil
+		}
+
+		store := storepkg.NewStore()
+		loader := LoadEndpointsAndCommunities(10*time.Millisecond, store, getEndpointsAndCommunities)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go loader.Start(ctx)
+
+		time.Sleep(50 * time.Millisecond)
+		_, ok := store.GetEndpoint(e1.Name)
+		Expect(ok).Should(BeTrue())
+
+		lock.Lock()
+		deregistered = true
+		lock.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+		_, ok = store.GetEndpoint(e1.Name)
+		Expect(ok).Should(BeFalse())
+
+		_, ok = store.GetCommunity("connectors")
+		Expect(ok).Should(BeFalse())
+	})
+})
+
+var _ = Describe("ExportEndpoints", func() {
+	It("should export only changes after all endpoints are exported", func() {
+		var (
+			lock      sync.Mutex
+			connector = apis.Endpoint{
+				Name:            "cluster1.connector",
+				PublicAddresses: []string{"cluster1"},
+			}
+			updated  [][]apis.Endpoint
+			patched  []apiserver.EndpointsDelta
+			patchErr error
+		)
+		getConnector := func() apis.Endpoint {
+			lock.Lock()
+			defer lock.Unlock()
+			return connector
+		}
+		updateEndpoints := func(endpoints []apis.Endpoint) error {
+			lock.Lock()
+			defer lock.Unlock()
+			updated = append(updated, endpoints)
+			return nil
+		}
+		patchEndpoints := func(delta apiserver.EndpointsDelta) error {
+			lock.Lock()
+			defer lock.Unlock()
+			patched = append(patched, delta)
+			return patchErr
+		}
+		getCounts := func() (int, int) {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(updated), len(patched)
+		}
+
+		exporter := ExportEndpoints(10*time.Millisecond, getConnector, updateEndpoints, patchEndpoints)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go exporter.Start(ctx)
+
+		Eventually(func() int {
+			_, patches := getCounts()
+			return patches
+		}, time.Second).Should(BeNumerically(">", 1))
+
+		lock.Lock()
+		Expect(updated).Should(HaveLen(1))
+		Expect(patched[0].IsEmpty()).Should(BeTrue())
+		Expect(patched[0].BaseRevision).Should(Equal(apiserver.GetEndpointsRevision([]apis.Endpoint{connector})))
+
+		By("changing connector and letting host cluster refuse the delta")
+		connector.PublicAddresses = []string{"cluster1.example"}
+		patchErr = &fclient.HttpError{
+			Response: &http.Response{StatusCode: http.StatusConflict},
+		}
+		lock.Unlock()
+
+		Eventually(func() int {
+			updates, _ := getCounts()
+			return updates
+		}, time.Second).Should(BeNumerically(">", 1))
+
+		lock.Lock()
+		defer lock.Unlock()
+		Expect(updated[1]).Should(Equal([]apis.Endpoint{connector}))
+		Expect(patched[len(patched)-1].Updated).Should(Equal([]apis.Endpoint{connector}))
+	})
+})
+
+var _ = Describe("WatchEndpointsAndCommunities", func() {
+	It("should load endpoints and communities once they are changed", func() {
+		e1 := apis.Endpoint{
+			Name:            "cluster1.connector",
+			PublicAddresses: []string{"cluster1"},
+			Subnets:         []string{"2.2.2.0/24"},
+			NodeSubnets:     []string{"10.10.0.1/32"},
+		}
+		e2 := apis.Endpoint{
+			Name:            "cluster2.connector",
+			PublicAddresses: []string{"cluster2.connector"},
+			Subnets:         []string{"192.168.1.0/24"},
+			NodeSubnets:     []string{"192.168.1.1/32"},
+		}
+
+		type response struct {
+			ec   apiserver.EndpointsAndCommunity
+			etag string
+		}
+		responses := make(chan response)
+		var receivedETags []string
+		var lock sync.Mutex
+		watch := func(ctx context.Context, etag string, timeout time.Duration) (apiserver.EndpointsAndCommunity, string, error) {
+			lock.Lock()
+			receivedETags = append(receivedETags, etag)
+			lock.Unlock()
+
+			select {
+			case resp := <-responses:
+				return resp.ec, resp.etag, nil
+			case <-time.After(timeout):
+				return apiserver.EndpointsAndCommunity{}, etag, fclient.ErrNotModified
+			case <-ctx.Done():
+				return apiserver.EndpointsAndCommunity{}, etag, ctx.Err()
+			}
+		}
+
+		store := storepkg.NewStore()
+		watcher := WatchEndpointsAndCommunities(20*time.Millisecond, time.Hour, store, watch)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go watcher.Start(ctx)
+
+		responses <- response{
+			ec: apiserver.EndpointsAndCommunity{
+				Communities: map[string][]string{"connectors": {e1.Name, e2.Name}},
+				Endpoints:   []apis.Endpoint{e1, e2},
+			},
+			etag: "v1",
+		}
+		Eventually(func() bool {
+			_, ok := store.GetEndpoint(e2.Name)
+			return ok
+		}, time.Second).Should(BeTrue())
+
+		By("changing endpoints after some watch requests time out")
+		time.Sleep(50 * time.Millisecond)
+		responses <- response{
+			ec: apiserver.EndpointsAndCommunity{
+				Communities: map[string][]string{"connectors": {e1.Name}},
+				Endpoints:   []apis.Endpoint{e1},
+			},
+			etag: "v2",
+		}
+		Eventually(func() bool {
+			_, ok := store.GetEndpoint(e2.Name)
+			return ok
+		}, time.Second).Should(BeFalse())
+
+		community, _ := store.GetCommunity("connectors")
+		Expect(community.Members.List()).Should(ConsistOf(e1.Name))
+
+		lock.Lock()
+		defer lock.Unlock()
+		Expect(receivedETags[0]).Should(Equal(""))
+		Expect(receivedETags).Should(ContainElement("v1"))
+	})
+})
+
+var _ = Describe("StreamEndpointsAndCommunities", func() {
+	e1 := apis.Endpoint{
+		Name:            "cluster1.connector",
+		PublicAddresses: []string{"cluster1"},
+	}
+	e2 := apis.Endpoint{
+		Name:            "cluster2.connector",
+		PublicAddresses: []string{"cluster2"},
+	}
+
+	It("should load endpoints and communities pushed through stream", func() {
+		changes := make(chan apiserver.EndpointsAndCommunity)
+		stream := func(ctx context.Context, idleTimeout time.Duration, onChange func(apiserver.EndpointsAndCommunity)) error {
+			for {
+				select {
+				case ec := <-changes:
+					onChange(ec)
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+		var loads int32
+		getEndpointsAndCommunities := func() (apiserver.EndpointsAndCommunity, error) {
+			atomic.AddInt32(&loads, 1)
+			return apiserver.EndpointsAndCommunity{}, nil
+		}
+
+		store := storepkg.NewStore()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go StreamEndpointsAndCommunities(time.Minute, time.Hour, store, stream, getEndpointsAndCommunities).Start(ctx)
+
+		changes <- apiserver.EndpointsAndCommunity{
+			Communities: map[string][]string{"connectors": {e1.Name, e2.Name}},
+			Endpoints:   []apis.Endpoint{e1, e2},
+		}
+		Eventually(func() bool {
+			_, ok := store.GetEndpoint(e2.Name)
+			return ok
+		}, time.Second).Should(BeTrue())
+
+		changes <- apiserver.EndpointsAndCommunity{
+			Communities: map[string][]string{"connectors": {e1.Name}},
+			Endpoints:   []apis.Endpoint{e1},
+		}
+		Eventually(func() bool {
+			_, ok := store.GetEndpoint(e2.Name)
+			return ok
+		}, time.Second).Should(BeFalse())
+		Expect(atomic.LoadInt32(&loads)).Should(BeZero())
+	})
+
+	It("should load endpoints and communities periodically if host cluster doesn't support streaming", func() {
+		stream := func(ctx context.Context, idleTimeout time.Duration, onChange func(apiserver.EndpointsAndCommunity)) error {
+			return &fclient.HttpError{
+				Response: &http.Response{StatusCode: http.StatusNotFound},
+			}
+		}
+		getEndpointsAndCommunities := func() (apiserver.EndpointsAndCommunity, error) {
+			return apiserver.EndpointsAndCommunity{Endpoints: []apis.Endpoint{e1}}, nil
+		}
+
+		store := storepkg.NewStore()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go StreamEndpointsAndCommunities(time.Minute, time.Hour, store, stream, getEndpointsAndCommunities).Start(ctx)
+
+		Eventually(func() bool {
+			_, ok := store.GetEndpoint(e1.Name)
+			return ok
+		}, time.Second).Should(BeTrue())
+	})
+
+	Context("with API client", func() {
+		// newStreamServer serves streams which push e1 in the first one and e2 in the rest, then calls
+		// afterPush, the number of streams requested so far is returned too
+		newStreamServer := func(afterPush func(r *http.Request)) (*httptest.Server, *int32) {
+			var streams int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				endpoint := e2
+				if atomic.AddInt32(&streams, 1) == 1 {
+					endpoint = e1
+				}
+
+				w.Header().Set("Content-Type", apiserver.ContentTypeStream)
+				ea := apiserver.ToV1beta1(apiserver.EndpointsAndCommunity{Endpoints: []apis.Endpoint{endpoint}})
+				json.NewEncoder(w).Encode(apiserver.StreamEvent{
+					Type:                    apiserver.StreamEventEndpointsAndCommunities,
+					EndpointsAndCommunities: &ea,
+				})
+				w.(http.Flusher).Flush()
+
+				afterPush(r)
+			}))
+			return server, &streams
+		}
+
+		streamOf := func(server *httptest.Server) StreamEndpointsAndCommunitiesFunc {
+			cli, err := fclient.NewClient(server.URL, "cluster1", nil)
+			Expect(err).Should(BeNil())
+			return cli.StreamEndpointsAndCommunities
+		}
+
+		It("should connect again after stream is ended by host cluster at its max age", func() {
+			server, streams := newStreamServer(func(r *http.Request) {})
+			defer server.Close()
+
+			store := storepkg.NewStore()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go StreamEndpointsAndCommunities(time.Minute, 10*time.Millisecond, store, streamOf(server), nil).Start(ctx)
+
+			Eventually(func() bool {
+				_, ok := store.GetEndpoint(e2.Name)
+				return ok
+			}, time.Second).Should(BeTrue())
+			Expect(atomic.LoadInt32(streams)).Should(BeNumerically(">=", 2))
+			Eventually(func() bool {
+				_, ok := store.GetEndpoint(e1.Name)
+				return ok
+			}, time.Second).Should(BeFalse())
+		})
+
+		It("should connect again if no ping is received", func() {
+			// the stream is kept but nothing is sent after endpoints, like ping events are lost
+			server, streams := newStreamServer(func(r *http.Request) {
+				<-r.Context().Done()
+			})
+			defer server.Close()
+
+			store := storepkg.NewStore()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go StreamEndpointsAndCommunities(100*time.Millisecond, 10*time.Millisecond, store, streamOf(server), nil).Start(ctx)
+
+			Eventually(func() bool {
+				_, ok := store.GetEndpoint(e1.Name)
+				return ok
+			}, time.Second).Should(BeTrue())
+
+			Eventually(func() bool {
+				_, ok := store.GetEndpoint(e2.Name)
+				return ok
+			}, time.Second).Should(BeTrue())
+			Expect(atomic.LoadInt32(streams)).Should(BeNumerically(">=", 2))
+		})
+	})
 })
@@ -0,0 +1,265 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+// TimeWindow is a daily time range, e.g. 02:00-04:00, End may be less than
+// Start which means the window crosses midnight
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeWindow parses a value like 02:00-04:00, an empty value means the whole day
+func ParseTimeWindow(value string) (TimeWindow, error) {
+	if value == "" {
+		return TimeWindow{Start: 0, End: 24 * time.Hour}, nil
+	}
+
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return TimeWindow{}, fmt.Errorf("invalid time window: %s", value)
+	}
+
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("invalid time window: %s", value)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+
+	return TimeWindow{Start: offsets[0], End: offsets[1]}, nil
+}
+
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	return offset >= w.Start || offset < w.End
+}
+
+// FailoverDrill regularly deletes the active connector pod in the time window to validate
+// that connector can fail over, the time taken for another connector pod to be ready is
+// measured and recorded in a DrillReport.
+type FailoverDrill struct {
+	Namespace string
+	// ConnectorName is used to tell pods of default connector from extra connectors
+	ConnectorName   string
+	ConnectorLabels map[string]string
+	Interval        time.Duration
+	Window          TimeWindow
+	// Timeout is the max time to wait for connector to recover
+	Timeout time.Duration
+	// ReportsToKeep is the number of latest reports to keep
+	ReportsToKeep int
+	Client        client.Client
+	Log           logr.Logger
+}
+
+func (drill *FailoverDrill) Start(ctx context.Context) error {
+	tick := time.NewTicker(drill.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if !drill.Window.Contains(time.Now()) {
+				drill.Log.V(5).Info("not in drill window, skip")
+				continue
+			}
+
+			drill.run(ctx)
+			drill.pruneReports(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (drill *FailoverDrill) run(ctx context.Context) {
+	log := drill.Log.WithValues("type", apis.DrillConnectorSwitchover)
+
+	pods, err := drill.listConnectorPods(ctx)
+	if err != nil {
+		log.Error(err, "failed to list connector pods")
+		return
+	}
+
+	// the oldest ready pod is taken as the active connector and withdrawn
+	var targets []corev1.Pod
+	for _, pod := range pods {
+		if !isPodReady(pod) {
+			continue
+		}
+		if len(targets) == 0 || pod.CreationTimestamp.Before(&targets[0].CreationTimestamp) {
+			targets = []corev1.Pod{pod}
+		}
+	}
+	if len(targets) == 0 {
+		log.Info("no ready connector pod, skip drill")
+		return
+	}
+
+	startTime := metav1.Now()
+	report := apis.DrillReport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("connector-switchover-%d", startTime.Unix()),
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+			},
+		},
+		Spec: apis.DrillReportSpec{
+			Type:      apis.DrillConnectorSwitchover,
+			StartTime: startTime,
+		},
+	}
+
+	withdrawn := sets.NewString()
+	for i := range targets {
+		pod := &targets[i]
+		log.V(3).Info("delete connector pod for drill", "pod", pod.Name)
+		if err = drill.Client.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "failed to delete connector pod", "pod", pod.Name)
+			report.Spec.Message = fmt.Sprintf("failed to delete pod %s: %s", pod.Name, err)
+			break
+		}
+		withdrawn.Insert(string(pod.UID))
+		report.Spec.Targets = append(report.Spec.Targets, pod.Name)
+	}
+
+	if withdrawn.Len() > 0 {
+		recoveredAt, err := drill.waitForRecovery(ctx, withdrawn)
+		if err != nil {
+			report.Spec.Message = fmt.Sprintf("connector is not recovered: %s", err)
+		} else {
+			report.Spec.Succeeded = report.Spec.Message == ""
+			report.Spec.RecoveryDuration = &metav1.Duration{Duration: recoveredAt.Sub(startTime.Time)}
+		}
+	}
+
+	finishTime := metav1.Now()
+	report.Spec.FinishTime = &finishTime
+
+	log.Info("drill is finished", "succeeded", report.Spec.Succeeded, "recoveryDuration", report.Spec.RecoveryDuration, "message", report.Spec.Message)
+	if err = drill.Client.Create(ctx, &report); err != nil {
+		log.Error(err, "failed to create drill report")
+	}
+}
+
+// waitForRecovery waits until a connector pod which is not withdrawn is ready
+func (drill *FailoverDrill) waitForRecovery(ctx context.Context, withdrawn sets.String) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, drill.Timeout)
+	defer cancel()
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		pods, err := drill.listConnectorPods(ctx)
+		if err != nil {
+			drill.Log.Error(err, "failed to list connector pods")
+		}
+
+		for _, pod := range pods {
+			if !withdrawn.Has(string(pod.UID)) && isPodReady(pod) {
+				return time.Now(), nil
+			}
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+	}
+}
+
+func (drill *FailoverDrill) listConnectorPods(ctx context.Context) ([]corev1.Pod, error) {
+	var podList corev1.PodList
+	err := drill.Client.List(ctx, &podList, client.InNamespace(drill.Namespace), client.MatchingLabels(drill.ConnectorLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		// pods of other connectors may match default connector's labels too
+		if pod.Labels[constants.KeyConnector] != drill.ConnectorName || pod.DeletionTimestamp != nil {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	return pods, nil
+}
+
+func (drill *FailoverDrill) pruneReports(ctx context.Context) {
+	var reports apis.DrillReportList
+	err := drill.Client.List(ctx, &reports, client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator})
+	if err != nil {
+		drill.Log.Error(err, "failed to list drill reports")
+		return
+	}
+
+	if len(reports.Items) <= drill.ReportsToKeep {
+		return
+	}
+
+	sort.Slice(reports.Items, func(i, j int) bool {
+		return reports.Items[i].Spec.StartTime.After(reports.Items[j].Spec.StartTime.Time)
+	})
+
+	for i := drill.ReportsToKeep; i < len(reports.Items); i++ {
+		report := &reports.Items[i]
+		if err = drill.Client.Delete(ctx, report); err != nil && !errors.IsNotFound(err) {
+			drill.Log.Error(err, "failed to delete drill report", "name", report.Name)
+		}
+	}
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
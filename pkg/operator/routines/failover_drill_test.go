@@ -0,0 +1,172 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	testutil "github.com/fabedge/fabedge/pkg/util/test"
+)
+
+var _ = Describe("TimeWindow", func() {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2021, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	It("should contain the whole day if value is empty", func() {
+		w, err := ParseTimeWindow("")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(w.Contains(at(0, 0))).To(BeTrue())
+		Expect(w.Contains(at(23, 59))).To(BeTrue())
+	})
+
+	It("should handle windows crossing midnight", func() {
+		w, err := ParseTimeWindow("02:00-04:00")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(w.Contains(at(2, 0))).To(BeTrue())
+		Expect(w.Contains(at(3, 59))).To(BeTrue())
+		Expect(w.Contains(at(4, 0))).To(BeFalse())
+
+		w, err = ParseTimeWindow("23:00-01:00")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(w.Contains(at(23, 30))).To(BeTrue())
+		Expect(w.Contains(at(0, 30))).To(BeTrue())
+		Expect(w.Contains(at(12, 0))).To(BeFalse())
+	})
+
+	It("should reject invalid values", func() {
+		_, err := ParseTimeWindow("02:00")
+		Expect(err).Should(HaveOccurred())
+
+		_, err = ParseTimeWindow("2am-4am")
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("FailoverDrill", func() {
+	var (
+		drill     *FailoverDrill
+		namespace = "default"
+		labels    = map[string]string{"app": "fabedge-connector"}
+		ctx       = context.Background()
+	)
+
+	newReadyPod := func(name string) {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "connector", Image: "fabedge/connector"},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, &pod)).Should(Succeed())
+
+		pod.Status = corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		}
+		Expect(k8sClient.Status().Update(ctx, &pod)).Should(Succeed())
+	}
+
+	getReports := func() []apis.DrillReport {
+		var reports apis.DrillReportList
+		Expect(k8sClient.List(ctx, &reports)).Should(Succeed())
+		return reports.Items
+	}
+
+	BeforeEach(func() {
+		drill = &FailoverDrill{
+			Namespace:       namespace,
+			ConnectorLabels: labels,
+			Interval:        time.Hour,
+			Timeout:         2 * time.Second,
+			ReportsToKeep:   1,
+			Client:          k8sClient,
+			Log:             klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(testutil.PurgeAllPods(k8sClient, client.InNamespace(namespace))).Should(Succeed())
+		Expect(k8sClient.DeleteAllOf(ctx, &apis.DrillReport{})).Should(Succeed())
+	})
+
+	It("should record a succeeded report if a standby connector is ready", func() {
+		newReadyPod("connector-a")
+		time.Sleep(time.Second)
+		newReadyPod("connector-b")
+
+		drill.run(ctx)
+
+		reports := getReports()
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].Spec.Type).To(Equal(apis.DrillConnectorSwitchover))
+		Expect(reports[0].Spec.Targets).To(ConsistOf("connector-a"))
+		Expect(reports[0].Spec.Succeeded).To(BeTrue())
+		Expect(reports[0].Spec.RecoveryDuration).NotTo(BeNil())
+		Expect(reports[0].Spec.FinishTime).NotTo(BeNil())
+	})
+
+	It("should record a failed report if connector is not recovered in time", func() {
+		newReadyPod("connector-a")
+
+		drill.run(ctx)
+
+		reports := getReports()
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].Spec.Succeeded).To(BeFalse())
+		Expect(reports[0].Spec.RecoveryDuration).To(BeNil())
+		Expect(reports[0].Spec.Message).NotTo(BeEmpty())
+	})
+
+	It("should keep only the latest reports", func() {
+		for i, name := range []string{"old", "new"} {
+			report := apis.DrillReport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{constants.KeyCreatedBy: constants.AppOperator},
+				},
+				Spec: apis.DrillReportSpec{
+					Type:      apis.DrillConnectorSwitchover,
+					StartTime: metav1.NewTime(time.Now().Add(time.Duration(i) * time.Minute)),
+				},
+			}
+			Expect(k8sClient.Create(ctx, &report)).Should(Succeed())
+		}
+
+		drill.pruneReports(ctx)
+
+		reports := getReports()
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].Name).To(Equal("new"))
+	})
+})
@@ -0,0 +1,164 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/third_party/calicoapi"
+)
+
+// GlobalNetworkSetSyncer maintains a calico GlobalNetworkSet for each community and
+// each cluster, the nets of a set are the subnets of its endpoints. With these sets,
+// NetworkPolicies can allow or deny traffic by edge site identity, e.g. selector
+// "fabedge.io/community == 'beijing-edges'", instead of raw CIDRs.
+type GlobalNetworkSetSyncer struct {
+	Store        storepkg.Interface
+	SyncInterval time.Duration
+	Client       client.Client
+	Log          logr.Logger
+}
+
+func (syncer *GlobalNetworkSetSyncer) Start(ctx context.Context) error {
+	tick := time.NewTicker(syncer.SyncInterval)
+	defer tick.Stop()
+
+	syncer.sync(ctx)
+	for {
+		select {
+		case <-tick.C:
+			syncer.sync(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (syncer *GlobalNetworkSetSyncer) sync(ctx context.Context) {
+	expectedSets := syncer.buildGlobalNetworkSets()
+
+	var setList calicoapi.GlobalNetworkSetList
+	err := syncer.Client.List(ctx, &setList, client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator})
+	if err != nil {
+		syncer.Log.Error(err, "failed to list global network sets")
+		return
+	}
+
+	for i := range setList.Items {
+		set := &setList.Items[i]
+		expected, ok := expectedSets[set.Name]
+		if !ok {
+			syncer.Log.V(3).Info("delete global network set", "name", set.Name)
+			if err = syncer.Client.Delete(ctx, set); err != nil && !errors.IsNotFound(err) {
+				syncer.Log.Error(err, "failed to delete global network set", "name", set.Name)
+			}
+			continue
+		}
+		delete(expectedSets, set.Name)
+
+		if reflect.DeepEqual(set.Labels, expected.Labels) && reflect.DeepEqual(set.Spec, expected.Spec) {
+			continue
+		}
+
+		syncer.Log.V(3).Info("update global network set", "name", set.Name, "nets", expected.Spec.Nets)
+		set.Labels = expected.Labels
+		set.Spec = expected.Spec
+		if err = syncer.Client.Update(ctx, set); err != nil {
+			syncer.Log.Error(err, "failed to update global network set", "name", set.Name)
+		}
+	}
+
+	for _, set := range expectedSets {
+		syncer.Log.V(3).Info("create global network set", "name", set.Name, "nets", set.Spec.Nets)
+		if err = syncer.Client.Create(ctx, set); err != nil {
+			syncer.Log.Error(err, "failed to create global network set", "name", set.Name)
+		}
+	}
+}
+
+func (syncer *GlobalNetworkSetSyncer) buildGlobalNetworkSets() map[string]*calicoapi.GlobalNetworkSet {
+	networkSets := make(map[string]*calicoapi.GlobalNetworkSet)
+
+	for _, name := range syncer.Store.GetAllCommunityNames().List() {
+		community, ok := syncer.Store.GetCommunity(name)
+		if !ok {
+			continue
+		}
+
+		endpoints := syncer.Store.GetEndpoints(community.Members.List()...)
+		set := newGlobalNetworkSet("fabedge-community-"+name, constants.KeyCommunity, name, endpoints)
+		networkSets[set.Name] = set
+	}
+
+	clusterEndpoints := make(map[string][]apis.Endpoint)
+	for _, ep := range syncer.Store.GetEndpoints(syncer.Store.GetAllEndpointNames().List()...) {
+		cluster := getClusterName(ep.Name)
+		if cluster == "" {
+			continue
+		}
+		clusterEndpoints[cluster] = append(clusterEndpoints[cluster], ep)
+	}
+
+	for cluster, endpoints := range clusterEndpoints {
+		set := newGlobalNetworkSet("fabedge-cluster-"+cluster, constants.KeyCluster, cluster, endpoints)
+		networkSets[set.Name] = set
+	}
+
+	return networkSets
+}
+
+func newGlobalNetworkSet(name, labelKey, labelValue string, endpoints []apis.Endpoint) *calicoapi.GlobalNetworkSet {
+	nets := sets.NewString()
+	for _, ep := range endpoints {
+		nets.Insert(ep.Subnets...)
+		nets.Insert(ep.NodeSubnets...)
+	}
+
+	return &calicoapi.GlobalNetworkSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constants.KeyCreatedBy: constants.AppOperator,
+				labelKey:               labelValue,
+			},
+		},
+		Spec: calicoapi.GlobalNetworkSetSpec{
+			Nets: nets.List(),
+		},
+	}
+}
+
+// getClusterName extracts cluster name from endpoint name which is
+// in format of "cluster.node"
+func getClusterName(endpointName string) string {
+	i := strings.Index(endpointName, ".")
+	if i <= 0 {
+		return ""
+	}
+
+	return endpointName[:i]
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/third_party/calicoapi"
+)
+
+var _ = Describe("GlobalNetworkSetSyncer", func() {
+	It("should maintain global network sets for communities and clusters", func() {
+		store := storepkg.NewStore()
+		store.SaveEndpoint(apis.Endpoint{
+			Name:        "beijing.edge1",
+			Subnets:     []string{"2.2.2.0/26"},
+			NodeSubnets: []string{"10.10.10.1/32"},
+		})
+		store.SaveEndpoint(apis.Endpoint{
+			Name:    "beijing.edge2",
+			Subnets: []string{"2.2.2.64/26"},
+		})
+		store.SaveEndpoint(apis.Endpoint{
+			Name:    "shanghai.connector",
+			Subnets: []string{"3.3.0.0/16"},
+		})
+		store.SaveCommunity(types.Community{
+			Name:    "edges",
+			Members: sets.NewString("beijing.edge1", "beijing.edge2"),
+		})
+
+		syncer := &GlobalNetworkSetSyncer{
+			Store:        store,
+			SyncInterval: time.Second,
+			Client:       k8sClient,
+			Log:          klogr.New(),
+		}
+
+		ctx := context.Background()
+		syncer.sync(ctx)
+
+		var set calicoapi.GlobalNetworkSet
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "fabedge-community-edges"}, &set)).Should(Succeed())
+		Expect(set.Labels[constants.KeyCommunity]).To(Equal("edges"))
+		Expect(set.Spec.Nets).To(ConsistOf("2.2.2.0/26", "2.2.2.64/26", "10.10.10.1/32"))
+
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "fabedge-cluster-shanghai"}, &set)).Should(Succeed())
+		Expect(set.Labels[constants.KeyCluster]).To(Equal("shanghai"))
+		Expect(set.Spec.Nets).To(ConsistOf("3.3.0.0/16"))
+
+		By("changing subnets and communities")
+		store.SaveEndpoint(apis.Endpoint{
+			Name:    "beijing.edge2",
+			Subnets: []string{"2.2.2.128/26"},
+		})
+		store.DeleteCommunity("edges")
+		syncer.sync(ctx)
+
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "fabedge-cluster-beijing"}, &set)).Should(Succeed())
+		Expect(set.Spec.Nets).To(ConsistOf("2.2.2.0/26", "2.2.2.128/26", "10.10.10.1/32"))
+
+		err := k8sClient.Get(ctx, client.ObjectKey{Name: "fabedge-community-edges"}, &set)
+		Expect(errors.IsNotFound(err)).Should(BeTrue())
+	})
+})
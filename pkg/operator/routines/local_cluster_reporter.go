@@ -3,21 +3,28 @@ package routines
 import (
 	"context"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/about"
+	"github.com/fabedge/fabedge/pkg/common/constants"
 	"github.com/fabedge/fabedge/pkg/operator/types"
 )
 
 // LocalClusterReporter create or update cluster data in the cluster where
 // controller is running
 type LocalClusterReporter struct {
-	Cluster      string
+	Cluster string
+	// Namespace is where TLS secrets made by operator are checked for CertificateValid condition,
+	// empty means certificates are not checked
+	Namespace    string
 	GetConnector types.EndpointGetter
 	SyncInterval time.Duration
 	Client       client.Client
@@ -63,7 +70,9 @@ func (ctl *LocalClusterReporter) report(ctx context.Context) {
 
 		if err = ctl.Client.Create(ctx, &cluster); err != nil {
 			ctl.Log.Error(err, "failed to create cluster")
+			return
 		}
+		ctl.reportStatus(ctx, &cluster, true)
 		return
 	}
 
@@ -72,11 +81,75 @@ func (ctl *LocalClusterReporter) report(ctx context.Context) {
 	}
 
 	if reflect.DeepEqual(endpoints, cluster.Spec.EndPoints) {
+		ctl.reportStatus(ctx, &cluster, false)
 		return
 	}
 
 	cluster.Spec.EndPoints = endpoints
 	if err = ctl.Client.Update(ctx, &cluster); err != nil {
 		ctl.Log.Error(err, "failed to update cluster")
+		return
+	}
+	ctl.reportStatus(ctx, &cluster, true)
+}
+
+// reportStatus updates status of local cluster, since the local cluster doesn't report heartbeat to
+// API server, the report itself is taken as heartbeat
+func (ctl *LocalClusterReporter) reportStatus(ctx context.Context, cluster *apis.Cluster, exported bool) {
+	now := metav1.Now()
+	cluster.Status.LastSeen = &now
+	if exported || cluster.Status.LastExportTime == nil {
+		cluster.Status.LastExportTime = &now
 	}
+	cluster.Status.OperatorVersion = about.Version()
+	types.SetClusterInventory(cluster)
+	types.SetClusterEndpointsSynced(cluster, false)
+	if ctl.Namespace != "" {
+		invalid, err := ctl.findInvalidCertificates(ctx)
+		if err != nil {
+			ctl.Log.Error(err, "failed to check certificates")
+		} else {
+			types.SetClusterCertificateValid(cluster, invalid)
+		}
+	}
+	types.SetClusterReady(cluster, true, types.ReasonHeartbeatReceived, "")
+
+	if err := ctl.Client.Status().Update(ctx, cluster); err != nil {
+		ctl.Log.Error(err, "failed to update cluster status")
+	}
+}
+
+// findInvalidCertificates returns names of TLS secrets made by operator whose certificates
+// are expired or not valid yet
+func (ctl *LocalClusterReporter) findInvalidCertificates(ctx context.Context) ([]string, error) {
+	var secrets corev1.SecretList
+	err := ctl.Client.List(ctx, &secrets,
+		client.InNamespace(ctl.Namespace),
+		client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var invalid []string
+	for _, secret := range secrets.Items {
+		if secret.Type != corev1.SecretTypeTLS {
+			continue
+		}
+
+		cert, err := parseCertOfSecret(secret)
+		if err != nil {
+			ctl.Log.Error(err, "failed to parse certificate of secret", "secret", secret.Name)
+			invalid = append(invalid, secret.Name)
+			continue
+		}
+
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			invalid = append(invalid, secret.Name)
+		}
+	}
+	sort.Strings(invalid)
+
+	return invalid, nil
 }
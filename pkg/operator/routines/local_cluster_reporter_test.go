@@ -6,10 +6,15 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2/klogr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	secretutil "github.com/fabedge/fabedge/pkg/util/secret"
 )
 
 var _ = Describe("LocalClusterReporter", func() {
@@ -24,6 +29,7 @@ var _ = Describe("LocalClusterReporter", func() {
 
 		reporter := &LocalClusterReporter{
 			Cluster:      "test",
+			Namespace:    "default",
 			Client:       k8sClient,
 			SyncInterval: time.Second,
 			Log:          klogr.New(),
@@ -40,6 +46,13 @@ var _ = Describe("LocalClusterReporter", func() {
 		err := k8sClient.Get(context.Background(), client.ObjectKey{Name: reporter.Cluster}, &cluster)
 		Expect(err).Should(BeNil())
 		Expect(cluster.Spec.EndPoints[0]).Should(Equal(connector))
+		Expect(cluster.Status.LastSeen).ShouldNot(BeNil())
+		Expect(cluster.Status.LastExportTime).ShouldNot(BeNil())
+		Expect(cluster.Status.EndpointCount).Should(Equal(int32(1)))
+		Expect(cluster.Status.ConnectorPublicAddresses).Should(ConsistOf("10.10.10.10"))
+		Expect(meta.IsStatusConditionTrue(cluster.Status.Conditions, apis.ClusterConditionReady)).Should(BeTrue())
+		Expect(meta.IsStatusConditionTrue(cluster.Status.Conditions, apis.ConditionEndpointsSynced)).Should(BeTrue())
+		Expect(meta.IsStatusConditionTrue(cluster.Status.Conditions, apis.ConditionCertificateValid)).Should(BeTrue())
 
 		By("update connector and report again")
 		connector.PublicAddresses = []string{"10.10.1.1"}
@@ -49,5 +62,31 @@ var _ = Describe("LocalClusterReporter", func() {
 		err = k8sClient.Get(context.Background(), client.ObjectKey{Name: reporter.Cluster}, &cluster)
 		Expect(err).Should(BeNil())
 		Expect(cluster.Spec.EndPoints[0]).Should(Equal(connector))
+		Expect(cluster.Status.ConnectorPublicAddresses).Should(ConsistOf("10.10.1.1"))
+
+		By("create a secret with invalid certificate and report again")
+		secret := secretutil.TLSSecret().
+			Name("fabedge-agent-tls-edge2").
+			Namespace("default").
+			CertPEM([]byte("invalid")).
+			KeyPEM([]byte("invalid")).
+			Label(constants.KeyCreatedBy, constants.AppOperator).
+			Build()
+		Expect(k8sClient.Create(context.Background(), &secret)).Should(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), &secret)).Should(Succeed())
+		}()
+		reporter.report(context.Background())
+
+		By("check if cluster is not ready")
+		err = k8sClient.Get(context.Background(), client.ObjectKey{Name: reporter.Cluster}, &cluster)
+		Expect(err).Should(BeNil())
+		condition := meta.FindStatusCondition(cluster.Status.Conditions, apis.ConditionCertificateValid)
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Message).Should(ContainSubstring(secret.Name))
+
+		condition = meta.FindStatusCondition(cluster.Status.Conditions, apis.ClusterConditionReady)
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal(types.ReasonCertificatesInvalid))
 	})
 })
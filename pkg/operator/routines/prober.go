@@ -0,0 +1,437 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+const (
+	// proberName is the name of the DaemonSet, service, configmap and service account of probers
+	proberName       = constants.AppProber
+	proberTargetsDir = "/etc/fabedge-prober"
+	// proberServiceTarget is the target name of the prober service, the prefix tells probers it's a service
+	proberServiceTarget = "service/" + proberName
+)
+
+// ConnectivityProber deploys a prober of agent image on edge nodes and nodes of connectors by a
+// DaemonSet. Each prober tests pods of the other probers it should reach through tunnels and
+// the prober service continuously, failures are exported as metrics and recorded as events on
+// prober pods. Targets of each node are kept in a configmap, which is updated every SyncInterval
+type ConnectivityProber struct {
+	Namespace       string
+	Image           string
+	ImagePullPolicy corev1.PullPolicy
+	// Interval is how often probers test their targets, 0 means probers are not deployed
+	Interval    time.Duration
+	Timeout     time.Duration
+	Port        int32
+	MetricsPort int32
+	// EdgeLabels and ConnectorLabels are used to find edge nodes and connector pods
+	EdgeLabels      map[string]string
+	ConnectorLabels map[string]string
+	GetEndpointName types.GetNameFunc
+	Store           storepkg.Interface
+	SyncInterval    time.Duration
+	Client          client.Client
+	Log             logr.Logger
+}
+
+func (p *ConnectivityProber) Start(ctx context.Context) error {
+	tick := time.NewTicker(p.SyncInterval)
+
+	p.sync(ctx)
+	for {
+		select {
+		case <-tick.C:
+			p.sync(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *ConnectivityProber) sync(ctx context.Context) {
+	connectorNodes, err := p.getConnectorNodes(ctx)
+	if err != nil {
+		p.Log.Error(err, "failed to get nodes of connectors")
+		return
+	}
+
+	if err = p.syncDaemonSet(ctx, connectorNodes); err != nil {
+		p.Log.Error(err, "failed to sync prober daemonset")
+		return
+	}
+
+	serviceIP, err := p.syncService(ctx)
+	if err != nil {
+		p.Log.Error(err, "failed to sync prober service")
+		return
+	}
+
+	var pods corev1.PodList
+	err = p.Client.List(ctx, &pods, client.InNamespace(p.Namespace), client.MatchingLabels(p.labels()))
+	if err != nil {
+		p.Log.Error(err, "failed to list prober pods")
+		return
+	}
+
+	targets := buildProberTargets(pods.Items, connectorNodes, serviceIP, p.inSameCommunity)
+	if err = p.syncConfigMap(ctx, targets); err != nil {
+		p.Log.Error(err, "failed to sync targets of probers")
+	}
+}
+
+func (p *ConnectivityProber) getConnectorNodes(ctx context.Context) (sets.String, error) {
+	var pods corev1.PodList
+	err := p.Client.List(ctx, &pods, client.InNamespace(p.Namespace), client.MatchingLabels(p.ConnectorLabels))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := sets.NewString()
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" {
+			nodes.Insert(pod.Spec.NodeName)
+		}
+	}
+
+	return nodes, nil
+}
+
+// inSameCommunity tells if endpoints of two edge nodes are members of the same community,
+// tunnels are only established between such edge nodes
+func (p *ConnectivityProber) inSameCommunity(node1, node2 string) bool {
+	endpoint2 := p.GetEndpointName(node2)
+	for _, community := range p.Store.GetCommunitiesByEndpoint(p.GetEndpointName(node1)) {
+		if community.Members.Has(endpoint2) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildProberTargets returns targets of each node which has a running prober, keys are node names
+// and values are lines like edge2=10.233.65.8. Probers on connector nodes test probers on edge nodes
+// and vice versa, probers on edge nodes test each other only if they are in the same community
+func buildProberTargets(pods []corev1.Pod, connectorNodes sets.String, serviceIP string, inSameCommunity func(node1, node2 string) bool) map[string]string {
+	addresses := make(map[string]string)
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" && pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" {
+			addresses[pod.Spec.NodeName] = pod.Status.PodIP
+		}
+	}
+
+	nodes := make([]string, 0, len(addresses))
+	for node := range addresses {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	targets := make(map[string]string, len(nodes))
+	for _, from := range nodes {
+		var lines []string
+		for _, to := range nodes {
+			if from == to {
+				continue
+			}
+
+			fromConnector, toConnector := connectorNodes.Has(from), connectorNodes.Has(to)
+			if fromConnector != toConnector || (!fromConnector && inSameCommunity(from, to)) {
+				lines = append(lines, fmt.Sprintf("%s=%s", to, addresses[to]))
+			}
+		}
+
+		if serviceIP != "" {
+			lines = append(lines, fmt.Sprintf("%s=%s", proberServiceTarget, serviceIP))
+		}
+
+		targets[from] = strings.Join(lines, "\n")
+	}
+
+	return targets
+}
+
+func (p *ConnectivityProber) syncDaemonSet(ctx context.Context, connectorNodes sets.String) error {
+	newDS := p.buildDaemonSet(connectorNodes)
+
+	var ds appsv1.DaemonSet
+	err := p.Client.Get(ctx, client.ObjectKey{Name: proberName, Namespace: p.Namespace}, &ds)
+	switch {
+	case errors.IsNotFound(err):
+		p.Log.V(3).Info("create prober daemonset")
+		return p.Client.Create(ctx, newDS)
+	case err != nil:
+		return err
+	}
+
+	if ds.Labels[constants.KeyPodHash] == newDS.Labels[constants.KeyPodHash] {
+		return nil
+	}
+
+	p.Log.V(3).Info("update prober daemonset", "connectorNodes", connectorNodes.List())
+	ds.Labels = newDS.Labels
+	ds.Spec = newDS.Spec
+	return p.Client.Update(ctx, &ds)
+}
+
+func (p *ConnectivityProber) buildDaemonSet(connectorNodes sets.String) *appsv1.DaemonSet {
+	labels := p.labels()
+
+	edgeTerm := corev1.NodeSelectorTerm{}
+	keys := make([]string, 0, len(p.EdgeLabels))
+	for key := range p.EdgeLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		requirement := corev1.NodeSelectorRequirement{Key: key, Operator: corev1.NodeSelectorOpExists}
+		if value := p.EdgeLabels[key]; value != "" {
+			requirement.Operator = corev1.NodeSelectorOpIn
+			requirement.Values = []string{value}
+		}
+		edgeTerm.MatchExpressions = append(edgeTerm.MatchExpressions, requirement)
+	}
+
+	// terms are ORed, so probers run on edge nodes and nodes of connectors
+	terms := []corev1.NodeSelectorTerm{edgeTerm}
+	if connectorNodes.Len() > 0 {
+		terms = append(terms, corev1.NodeSelectorTerm{
+			MatchFields: []corev1.NodeSelectorRequirement{
+				{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: connectorNodes.List()},
+			},
+		})
+	}
+
+	// probers start before operator puts their targets in configmap
+	optional := true
+	spec := corev1.PodSpec{
+		ServiceAccountName: proberName,
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			},
+		},
+		Tolerations: []corev1.Toleration{
+			{
+				Key:      "",
+				Operator: corev1.TolerationOpExists,
+			},
+		},
+		Containers: []corev1.Container{
+			{
+				Name:            "prober",
+				Image:           p.Image,
+				ImagePullPolicy: p.ImagePullPolicy,
+				Args: []string{
+					"--check-role=prober",
+					fmt.Sprintf("--check-port=%d", p.Port),
+					fmt.Sprintf("--check-interval=%s", p.Interval),
+					fmt.Sprintf("--check-timeout=%s", p.Timeout),
+					fmt.Sprintf("--check-targets-file=%s/$(NODE_NAME)", proberTargetsDir),
+					"--check-pod-name=$(POD_NAME)",
+					"--check-pod-namespace=" + p.Namespace,
+					"--node-name=$(NODE_NAME)",
+					fmt.Sprintf("--metrics-bind-address=:%d", p.MetricsPort),
+				},
+				Env: []corev1.EnvVar{
+					{
+						Name: "NODE_NAME",
+						ValueFrom: &corev1.EnvVarSource{
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"},
+						},
+					},
+					{
+						Name: "POD_NAME",
+						ValueFrom: &corev1.EnvVarSource{
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+						},
+					},
+				},
+				Ports: []corev1.ContainerPort{
+					{Name: "tcp", ContainerPort: p.Port, Protocol: corev1.ProtocolTCP},
+					{Name: "udp", ContainerPort: p.Port, Protocol: corev1.ProtocolUDP},
+					{Name: "metrics", ContainerPort: p.MetricsPort, Protocol: corev1.ProtocolTCP},
+				},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("16Mi"),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("64Mi"),
+					},
+				},
+				// ICMP tests need raw sockets
+				SecurityContext: &corev1.SecurityContext{
+					Capabilities: &corev1.Capabilities{
+						Add: []corev1.Capability{"NET_RAW"},
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "targets", MountPath: proberTargetsDir, ReadOnly: true},
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "targets",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: proberName},
+						Optional:             &optional,
+					},
+				},
+			},
+		},
+	}
+
+	dsLabels := p.labels()
+	dsLabels[constants.KeyPodHash] = hashOf(spec)
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      proberName,
+			Namespace: p.Namespace,
+			Labels:    dsLabels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       spec,
+			},
+		},
+	}
+}
+
+// syncService makes sure the prober service exists and returns its cluster IP, the service
+// selects all probers, so tests against it go through the proxy of services
+func (p *ConnectivityProber) syncService(ctx context.Context) (string, error) {
+	ports := []corev1.ServicePort{
+		{Name: "tcp", Port: p.Port, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(int(p.Port))},
+		{Name: "udp", Port: p.Port, Protocol: corev1.ProtocolUDP, TargetPort: intstr.FromInt(int(p.Port))},
+	}
+
+	var svc corev1.Service
+	err := p.Client.Get(ctx, client.ObjectKey{Name: proberName, Namespace: p.Namespace}, &svc)
+	switch {
+	case errors.IsNotFound(err):
+		svc = corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      proberName,
+				Namespace: p.Namespace,
+				Labels:    p.labels(),
+			},
+			Spec: corev1.ServiceSpec{
+				Selector: p.labels(),
+				Ports:    ports,
+			},
+		}
+		if err = p.Client.Create(ctx, &svc); err != nil {
+			return "", err
+		}
+		return svc.Spec.ClusterIP, nil
+	case err != nil:
+		return "", err
+	}
+
+	if !sameServicePorts(svc.Spec.Ports, ports) || !equality.Semantic.DeepEqual(svc.Spec.Selector, p.labels()) {
+		svc.Spec.Ports = ports
+		svc.Spec.Selector = p.labels()
+		if err = p.Client.Update(ctx, &svc); err != nil {
+			return "", err
+		}
+	}
+
+	return svc.Spec.ClusterIP, nil
+}
+
+func sameServicePorts(ports1, ports2 []corev1.ServicePort) bool {
+	if len(ports1) != len(ports2) {
+		return false
+	}
+
+	for i := range ports1 {
+		if ports1[i].Name != ports2[i].Name || ports1[i].Port != ports2[i].Port ||
+			ports1[i].Protocol != ports2[i].Protocol || ports1[i].TargetPort != ports2[i].TargetPort {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *ConnectivityProber) syncConfigMap(ctx context.Context, targets map[string]string) error {
+	var cm corev1.ConfigMap
+	err := p.Client.Get(ctx, client.ObjectKey{Name: proberName, Namespace: p.Namespace}, &cm)
+	switch {
+	case errors.IsNotFound(err):
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      proberName,
+				Namespace: p.Namespace,
+				Labels:    p.labels(),
+			},
+			Data: targets,
+		}
+		return p.Client.Create(ctx, &cm)
+	case err != nil:
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(cm.Data, targets) {
+		return nil
+	}
+
+	cm.Data = targets
+	return p.Client.Update(ctx, &cm)
+}
+
+func (p *ConnectivityProber) labels() map[string]string {
+	return map[string]string{
+		constants.KeyFabedgeAPP: constants.AppProber,
+		constants.KeyCreatedBy:  constants.AppOperator,
+	}
+}
+
+func hashOf(obj interface{}) string {
+	data, _ := json.Marshal(obj)
+
+	hasher := fnv.New32a()
+	_, _ = hasher.Write(data)
+	return fmt.Sprint(hasher.Sum32())
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	storepkg "github.com/fabedge/fabedge/pkg/operator/store"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+var _ = Describe("ConnectivityProber", func() {
+	newPod := func(name, nodeName, ip string, labels map[string]string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec: corev1.PodSpec{
+				NodeName:   nodeName,
+				Containers: []corev1.Container{{Name: "main", Image: "fabedge/agent"}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+		}
+	}
+
+	It("should build targets according to connectors and communities", func() {
+		proberLabels := map[string]string{constants.KeyFabedgeAPP: constants.AppProber}
+		pods := []corev1.Pod{
+			newPod("prober-master", "master", "10.233.0.2", proberLabels),
+			newPod("prober-edge1", "edge1", "10.233.64.2", proberLabels),
+			newPod("prober-edge2", "edge2", "10.233.65.2", proberLabels),
+			newPod("prober-edge3", "edge3", "10.233.66.2", proberLabels),
+			newPod("prober-edge4", "edge4", "", proberLabels),
+		}
+		inSameCommunity := func(node1, node2 string) bool {
+			return sets.NewString(node1, node2).Equal(sets.NewString("edge1", "edge2"))
+		}
+
+		targets := buildProberTargets(pods, sets.NewString("master"), "10.96.0.20", inSameCommunity)
+		Expect(targets).To(Equal(map[string]string{
+			"master": "edge1=10.233.64.2\nedge2=10.233.65.2\nedge3=10.233.66.2\nservice/fabedge-prober=10.96.0.20",
+			"edge1":  "edge2=10.233.65.2\nmaster=10.233.0.2\nservice/fabedge-prober=10.96.0.20",
+			"edge2":  "edge1=10.233.64.2\nmaster=10.233.0.2\nservice/fabedge-prober=10.96.0.20",
+			"edge3":  "master=10.233.0.2\nservice/fabedge-prober=10.96.0.20",
+		}))
+	})
+
+	It("should create daemonset, service and targets of probers", func() {
+		store := storepkg.NewStore()
+		store.SaveCommunity(types.Community{Name: "beijing", Members: sets.NewString("edge1", "edge2")})
+
+		prober := &ConnectivityProber{
+			Namespace:       "default",
+			Image:           "fabedge/agent:latest",
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Interval:        30 * time.Second,
+			Timeout:         3 * time.Second,
+			Port:            8790,
+			MetricsPort:     8791,
+			EdgeLabels:      map[string]string{"node-role.kubernetes.io/edge": ""},
+			ConnectorLabels: map[string]string{"app": "fabedge-connector"},
+			GetEndpointName: func(name string) string { return name },
+			Store:           store,
+			SyncInterval:    time.Minute,
+			Client:          k8sClient,
+			Log:             klogr.New(),
+		}
+
+		var objects []client.Object
+		defer func() {
+			for _, obj := range objects {
+				Expect(k8sClient.Delete(context.Background(), obj)).Should(Succeed())
+			}
+		}()
+
+		create := func(pod corev1.Pod) {
+			status := pod.Status
+			Expect(k8sClient.Create(context.Background(), &pod)).Should(Succeed())
+			pod.Status = status
+			Expect(k8sClient.Status().Update(context.Background(), &pod)).Should(Succeed())
+			objects = append(objects, &pod)
+		}
+		create(newPod("connector", "master", "10.233.0.1", prober.ConnectorLabels))
+		create(newPod("prober-edge1", "edge1", "10.233.64.2", prober.labels()))
+		create(newPod("prober-edge2", "edge2", "10.233.65.2", prober.labels()))
+
+		prober.sync(context.Background())
+
+		var ds appsv1.DaemonSet
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: proberName, Namespace: "default"}, &ds)).Should(Succeed())
+		objects = append(objects, &ds)
+
+		terms := ds.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		Expect(terms).To(HaveLen(2))
+		Expect(terms[0].MatchExpressions[0].Key).To(Equal("node-role.kubernetes.io/edge"))
+		Expect(terms[1].MatchFields[0].Values).To(ConsistOf("master"))
+		Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--check-role=prober"))
+
+		var svc corev1.Service
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: proberName, Namespace: "default"}, &svc)).Should(Succeed())
+		objects = append(objects, &svc)
+		Expect(svc.Spec.ClusterIP).NotTo(BeEmpty())
+
+		var cm corev1.ConfigMap
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: proberName, Namespace: "default"}, &cm)).Should(Succeed())
+		objects = append(objects, &cm)
+		Expect(cm.Data).To(Equal(map[string]string{
+			"edge1": "edge2=10.233.65.2\nservice/fabedge-prober=" + svc.Spec.ClusterIP,
+			"edge2": "edge1=10.233.64.2\nservice/fabedge-prober=" + svc.Spec.ClusterIP,
+		}))
+
+		By("changing the interval of probers")
+		hash := ds.Labels[constants.KeyPodHash]
+		prober.Interval = time.Minute
+		prober.sync(context.Background())
+
+		Expect(k8sClient.Get(context.Background(), client.ObjectKey{Name: proberName, Namespace: "default"}, &ds)).Should(Succeed())
+		Expect(ds.Labels[constants.KeyPodHash]).NotTo(Equal(hash))
+		Expect(ds.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--check-interval=1m0s"))
+	})
+})
@@ -13,6 +13,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 
 	testutil "github.com/fabedge/fabedge/pkg/util/test"
+	"github.com/fabedge/fabedge/third_party/calicoapi"
 )
 
 var cfg *rest.Config
@@ -33,11 +34,15 @@ var _ = BeforeSuite(func(done Done) {
 	By("starting test environment")
 	var err error
 	testEnv, cfg, k8sClient, err = testutil.StartTestEnvWithCRD(
-		[]string{filepath.Join("..", "..", "..", "deploy", "crds")},
+		[]string{
+			filepath.Join("..", "..", "..", "deploy", "crds"),
+			filepath.Join("..", "..", "..", "third_party", "calicoapi", "crd"),
+		},
 	)
 	Expect(err).ToNot(HaveOccurred())
 
 	Expect(apis.AddToScheme(scheme.Scheme)).Should(Succeed())
+	Expect(calicoapi.AddToScheme(scheme.Scheme)).Should(Succeed())
 
 	close(done)
 }, 60)
@@ -0,0 +1,244 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
+)
+
+// TunnelAlerter sends alerts by Notifier when a tunnel reported in tunnel history of AgentConfigs
+// or ConnectorConfigs stays down longer than Threshold, or a member cluster stops exporting
+// endpoints longer than Threshold. Each problem is alerted once, and a recovered event is sent
+// when it's gone. Problems are kept in memory, so they are alerted again after operator restarts
+type TunnelAlerter struct {
+	Namespace string
+	// Cluster is the name of local cluster, tunnels are alerted as tunnels of it
+	Cluster string
+	// GetEndpointName returns the endpoint name of an edge node, AgentConfigs are named after edge nodes
+	GetEndpointName types.GetNameFunc
+	// AgentConfigs and ConnectorConfigs tell if agents and connectors report tunnel history in them
+	AgentConfigs     bool
+	ConnectorConfigs bool
+	// MemberClusters tells if member clusters are checked, it's only true in host cluster
+	MemberClusters bool
+	Threshold      time.Duration
+	Interval       time.Duration
+	Notifier       webhook.Notifier
+	Client         client.Client
+	Log            logr.Logger
+
+	// alerted holds events of problems which are alerted, keys are like tunnel/edge1/edge2 or cluster/beijing
+	alerted map[string]webhook.Event
+}
+
+func (a *TunnelAlerter) Start(ctx context.Context) error {
+	tick := time.NewTicker(a.Interval)
+
+	a.check(ctx)
+	for {
+		select {
+		case <-tick.C:
+			a.check(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (a *TunnelAlerter) check(ctx context.Context) {
+	if a.alerted == nil {
+		a.alerted = make(map[string]webhook.Event)
+	}
+
+	now := time.Now()
+	problems := make(map[string]webhook.Event)
+
+	if a.AgentConfigs {
+		var agentConfigs apis.AgentConfigList
+		if err := a.Client.List(ctx, &agentConfigs, client.InNamespace(a.Namespace)); err != nil {
+			a.Log.Error(err, "failed to list agent configs")
+			return
+		}
+		for _, cfg := range agentConfigs.Items {
+			synced := meta.FindStatusCondition(cfg.Status.Conditions, apis.AgentConfigConditionSynced)
+			a.findDownTunnels(problems, now, cfg.Name, a.GetEndpointName(cfg.Name), cfg.Status.TunnelHistory, cfg.Status.Probes, synced)
+		}
+	}
+
+	if a.ConnectorConfigs {
+		var connectorConfigs apis.ConnectorConfigList
+		if err := a.Client.List(ctx, &connectorConfigs, client.InNamespace(a.Namespace)); err != nil {
+			a.Log.Error(err, "failed to list connector configs")
+			return
+		}
+		for _, cfg := range connectorConfigs.Items {
+			synced := meta.FindStatusCondition(cfg.Status.Conditions, apis.ConnectorConfigConditionSynced)
+			a.findDownTunnels(problems, now, "", cfg.Spec.Endpoint.Name, cfg.Status.TunnelHistory, cfg.Status.Probes, synced)
+		}
+	}
+
+	if a.MemberClusters {
+		var clusters apis.ClusterList
+		if err := a.Client.List(ctx, &clusters); err != nil {
+			a.Log.Error(err, "failed to list clusters")
+			return
+		}
+		for _, cluster := range clusters.Items {
+			if cluster.Name == a.Cluster || cluster.DeletionTimestamp != nil {
+				continue
+			}
+			a.findNotExportingCluster(problems, now, cluster)
+		}
+	}
+
+	for key, event := range problems {
+		if _, ok := a.alerted[key]; ok {
+			continue
+		}
+
+		a.Log.V(3).Info("problem lasts longer than threshold, sending alert", "type", event.Type, "summary", event.Summary)
+		a.alerted[key] = event
+		a.Notifier.Notify(event)
+	}
+
+	for key, event := range a.alerted {
+		if _, ok := problems[key]; ok {
+			continue
+		}
+
+		delete(a.alerted, key)
+		a.Notifier.Notify(recoveredEvent(event))
+	}
+}
+
+// findDownTunnels puts tunnels of a reporter which are down longer than threshold in problems,
+// node is empty if the reporter is a connector, synced is the Synced condition of the reporter
+func (a *TunnelAlerter) findDownTunnels(problems map[string]webhook.Event, now time.Time, node, endpoint string,
+	history []apis.TunnelSummary, probes []apis.PeerProbe, synced *metav1.Condition) {
+	for _, summary := range history {
+		if summary.Up || summary.LastTransitionTime == nil {
+			continue
+		}
+
+		downFor := now.Sub(summary.LastTransitionTime.Time)
+		if downFor < a.Threshold {
+			continue
+		}
+
+		detail := map[string]string{
+			"endpoint":  endpoint,
+			"peer":      summary.Peer,
+			"downSince": summary.LastTransitionTime.Format(time.RFC3339),
+			"flaps":     strconv.Itoa(int(summary.Flaps)),
+		}
+		if node != "" {
+			detail["node"] = node
+		}
+		if lastError := lastTunnelError(summary.Peer, probes, synced); lastError != "" {
+			detail["lastError"] = lastError
+		}
+
+		problems["tunnel/"+endpoint+"/"+summary.Peer] = webhook.Event{
+			Type:    webhook.EventTunnelDown,
+			Cluster: a.Cluster,
+			Time:    now,
+			Summary: fmt.Sprintf("tunnel from %s to %s in cluster %s is down for %s", endpoint, summary.Peer, a.Cluster, downFor.Round(time.Minute)),
+			Detail:  detail,
+		}
+	}
+}
+
+// findNotExportingCluster puts cluster in problems if it's not ready longer than threshold because
+// it stops reporting heartbeat or has no endpoints exported
+func (a *TunnelAlerter) findNotExportingCluster(problems map[string]webhook.Event, now time.Time, cluster apis.Cluster) {
+	condition := meta.FindStatusCondition(cluster.Status.Conditions, apis.ClusterConditionReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse {
+		return
+	}
+
+	if condition.Reason != types.ReasonHeartbeatMissed && condition.Reason != types.ReasonNoEndpoints {
+		return
+	}
+
+	notExportingFor := now.Sub(condition.LastTransitionTime.Time)
+	if notExportingFor < a.Threshold {
+		return
+	}
+
+	detail := map[string]string{
+		"reason":        condition.Reason,
+		"lastError":     condition.Message,
+		"since":         condition.LastTransitionTime.Format(time.RFC3339),
+		"endpointCount": strconv.Itoa(int(cluster.Status.EndpointCount)),
+	}
+	if cluster.Status.LastSeen != nil {
+		detail["lastSeen"] = cluster.Status.LastSeen.Format(time.RFC3339)
+	}
+
+	problems["cluster/"+cluster.Name] = webhook.Event{
+		Type:    webhook.EventClusterNotExporting,
+		Cluster: cluster.Name,
+		Time:    now,
+		Summary: fmt.Sprintf("cluster %s stops exporting endpoints for %s", cluster.Name, notExportingFor.Round(time.Minute)),
+		Detail:  detail,
+	}
+}
+
+// lastTunnelError tells why a tunnel may be down: the error of applying config by the reporter,
+// or the last probe to the peer which got no reply
+func lastTunnelError(peer string, probes []apis.PeerProbe, synced *metav1.Condition) string {
+	if synced != nil && synced.Status == metav1.ConditionFalse {
+		return synced.Message
+	}
+
+	for _, p := range probes {
+		if p.Peer == peer && p.Loss >= 100 {
+			return fmt.Sprintf("no pings to %s were replied at %s", p.Address, p.ProbeTime.Format(time.RFC3339))
+		}
+	}
+
+	return ""
+}
+
+func recoveredEvent(event webhook.Event) webhook.Event {
+	recovered := webhook.Event{
+		Cluster: event.Cluster,
+		Time:    time.Now(),
+		Detail:  event.Detail,
+	}
+
+	switch event.Type {
+	case webhook.EventTunnelDown:
+		recovered.Type = webhook.EventTunnelRecovered
+		recovered.Summary = fmt.Sprintf("tunnel from %s to %s in cluster %s is recovered", event.Detail["endpoint"], event.Detail["peer"], event.Cluster)
+	default:
+		recovered.Type = webhook.EventClusterRecovered
+		recovered.Summary = fmt.Sprintf("cluster %s is exporting endpoints again", event.Cluster)
+	}
+
+	return recovered
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routines
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
+)
+
+type notifierFunc func(event webhook.Event)
+
+func (fn notifierFunc) Notify(event webhook.Event) {
+	fn(event)
+}
+
+var _ = Describe("TunnelAlerter", func() {
+	var (
+		alerter *TunnelAlerter
+		events  []webhook.Event
+		objects []client.Object
+		longAgo metav1.Time
+	)
+
+	BeforeEach(func() {
+		events, objects = nil, nil
+		longAgo = metav1.NewTime(time.Now().Add(-20 * time.Minute).Truncate(time.Second))
+
+		alerter = &TunnelAlerter{
+			Namespace:        "default",
+			Cluster:          "beijing",
+			GetEndpointName:  func(name string) string { return "fabedge." + name },
+			AgentConfigs:     true,
+			ConnectorConfigs: true,
+			MemberClusters:   true,
+			Threshold:        10 * time.Minute,
+			Interval:         time.Minute,
+			Notifier:         notifierFunc(func(event webhook.Event) { events = append(events, event) }),
+			Client:           k8sClient,
+			Log:              klogr.New(),
+		}
+	})
+
+	AfterEach(func() {
+		for _, obj := range objects {
+			Expect(k8sClient.Delete(context.Background(), obj)).Should(Succeed())
+		}
+	})
+
+	create := func(obj client.Object, setStatus func()) {
+		Expect(k8sClient.Create(context.Background(), obj)).Should(Succeed())
+		setStatus()
+		Expect(k8sClient.Status().Update(context.Background(), obj)).Should(Succeed())
+		objects = append(objects, obj)
+	}
+
+	It("should alert tunnels which are down longer than threshold once and send recovered events", func() {
+		justNow := metav1.NewTime(time.Now().Add(-time.Minute))
+
+		edge1 := &apis.AgentConfig{ObjectMeta: metav1.ObjectMeta{Name: "edge1", Namespace: "default"}}
+		create(edge1, func() {
+			edge1.Status.TunnelHistory = []apis.TunnelSummary{
+				{Peer: "cloud-connector", Up: false, LastTransitionTime: &longAgo, Flaps: 2},
+				{Peer: "fabedge.edge2", Up: false, LastTransitionTime: &justNow},
+				{Peer: "fabedge.edge3", Up: true, LastTransitionTime: &longAgo},
+			}
+			edge1.Status.Probes = []apis.PeerProbe{
+				{Peer: "cloud-connector", Address: "10.233.0.1", Loss: 100, ProbeTime: longAgo},
+			}
+		})
+
+		connector := &apis.ConnectorConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "connector", Namespace: "default"},
+			Spec: apis.ConnectorConfigSpec{
+				Endpoint: apis.Endpoint{Name: "cloud-connector", ID: "C=CN, O=fabedge.io, CN=cloud-connector"},
+			},
+		}
+		create(connector, func() {
+			connector.Status.TunnelHistory = []apis.TunnelSummary{
+				{Peer: "fabedge.edge1", Up: false, LastTransitionTime: &longAgo},
+			}
+			connector.Status.Conditions = []metav1.Condition{
+				{Type: apis.ConnectorConfigConditionSynced, Status: metav1.ConditionFalse, Reason: "Failed", Message: "failed to load certificates", LastTransitionTime: longAgo},
+			}
+		})
+
+		alerter.check(context.Background())
+		Expect(events).To(HaveLen(2))
+
+		byEndpoint := map[string]webhook.Event{}
+		for _, event := range events {
+			Expect(event.Type).To(Equal(webhook.EventTunnelDown))
+			Expect(event.Cluster).To(Equal("beijing"))
+			byEndpoint[event.Detail["endpoint"]] = event
+		}
+		Expect(byEndpoint["fabedge.edge1"].Detail).To(Equal(map[string]string{
+			"endpoint":  "fabedge.edge1",
+			"node":      "edge1",
+			"peer":      "cloud-connector",
+			"downSince": longAgo.Format(time.RFC3339),
+			"flaps":     "2",
+			"lastError": "no pings to 10.233.0.1 were replied at " + longAgo.Format(time.RFC3339),
+		}))
+		Expect(byEndpoint["cloud-connector"].Detail["lastError"]).To(Equal("failed to load certificates"))
+		Expect(byEndpoint["cloud-connector"].Detail).NotTo(HaveKey("node"))
+
+		By("checking again")
+		alerter.check(context.Background())
+		Expect(events).To(HaveLen(2))
+
+		By("recovering the tunnel of edge1")
+		edge1.Status.TunnelHistory[0].Up = true
+		Expect(k8sClient.Status().Update(context.Background(), edge1)).Should(Succeed())
+
+		alerter.check(context.Background())
+		Expect(events).To(HaveLen(3))
+		Expect(events[2].Type).To(Equal(webhook.EventTunnelRecovered))
+		Expect(events[2].Detail["endpoint"]).To(Equal("fabedge.edge1"))
+	})
+
+	It("should alert member clusters which stop exporting longer than threshold", func() {
+		newCluster := func(name, reason string) *apis.Cluster {
+			cluster := &apis.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}}
+			create(cluster, func() {
+				cluster.Status.LastSeen = &longAgo
+				cluster.Status.Conditions = []metav1.Condition{
+					{Type: apis.ClusterConditionReady, Status: metav1.ConditionFalse, Reason: reason, Message: "no heartbeat", LastTransitionTime: longAgo},
+				}
+			})
+			return cluster
+		}
+
+		newCluster("beijing", types.ReasonHeartbeatMissed)
+		newCluster("hangzhou", types.ReasonNeverSeen)
+		shanghai := newCluster("shanghai", types.ReasonHeartbeatMissed)
+
+		alerter.check(context.Background())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Type).To(Equal(webhook.EventClusterNotExporting))
+		Expect(events[0].Cluster).To(Equal("shanghai"))
+		Expect(events[0].Detail).To(HaveKeyWithValue("lastSeen", longAgo.Format(time.RFC3339)))
+		Expect(events[0].Detail).To(HaveKeyWithValue("lastError", "no heartbeat"))
+
+		By("cluster reports heartbeat again")
+		shanghai.Status.Conditions[0].Status = metav1.ConditionTrue
+		shanghai.Status.Conditions[0].Reason = types.ReasonHeartbeatReceived
+		Expect(k8sClient.Status().Update(context.Background(), shanghai)).Should(Succeed())
+
+		alerter.check(context.Background())
+		Expect(events).To(HaveLen(2))
+		Expect(events[1].Type).To(Equal(webhook.EventClusterRecovered))
+		Expect(events[1].Cluster).To(Equal("shanghai"))
+	})
+})
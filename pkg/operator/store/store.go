@@ -15,6 +15,7 @@
 package store
 
 import (
+	"reflect"
 	"sync"
 
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
@@ -33,8 +34,12 @@ type Interface interface {
 
 	SaveCommunity(ep types.Community)
 	GetCommunity(name string) (types.Community, bool)
+	GetAllCommunityNames() sets.String
 	GetCommunitiesByEndpoint(name string) []types.Community
 	DeleteCommunity(name string)
+
+	// Changed returns a channel which will be closed when any endpoint or community is changed
+	Changed() <-chan struct{}
 }
 
 var _ Interface = &store{}
@@ -44,6 +49,7 @@ type store struct {
 	endpoints             map[string]apis.Endpoint
 	communities           map[string]types.Community
 	endpointToCommunities map[string]sets.String
+	changed               chan struct{}
 
 	mux sync.RWMutex
 }
@@ -54,6 +60,7 @@ func NewStore() Interface {
 		endpoints:             make(map[string]apis.Endpoint),
 		communities:           make(map[string]types.Community),
 		endpointToCommunities: make(map[string]sets.String),
+		changed:               make(chan struct{}),
 	}
 }
 
@@ -61,17 +68,26 @@ func (s *store) SaveEndpoint(ep apis.Endpoint) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	s.endpoints[ep.Name] = ep
+	s.saveEndpoint(ep)
 }
 
 func (s *store) SaveEndpointAsLocal(ep apis.Endpoint) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	s.endpoints[ep.Name] = ep
+	s.saveEndpoint(ep)
 	s.localNameSet.Insert(ep.Name)
 }
 
+func (s *store) saveEndpoint(ep apis.Endpoint) {
+	oldEndpoint, ok := s.endpoints[ep.Name]
+	s.endpoints[ep.Name] = ep
+
+	if !ok || !reflect.DeepEqual(oldEndpoint, ep) {
+		s.notifyChanged()
+	}
+}
+
 func (s *store) GetEndpoint(name string) (apis.Endpoint, bool) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -124,6 +140,10 @@ func (s *store) DeleteEndpoint(name string) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	if _, ok := s.endpoints[name]; ok {
+		s.notifyChanged()
+	}
+
 	delete(s.endpoints, name)
 	s.localNameSet.Delete(name)
 }
@@ -133,11 +153,12 @@ func (s *store) SaveCommunity(c types.Community) {
 	defer s.mux.Unlock()
 
 	oldCommunity := s.communities[c.Name]
-	if oldCommunity.Members.Equal(c.Members) {
+	if oldCommunity.Members.Equal(c.Members) && oldCommunity.DSCP == c.DSCP && oldCommunity.EgressBandwidth == c.EgressBandwidth {
 		return
 	}
 
 	s.communities[c.Name] = c
+	s.notifyChanged()
 
 	// add new member to communities index
 	for member := range c.Members {
@@ -173,6 +194,18 @@ func (s *store) GetCommunity(name string) (types.Community, bool) {
 	return c, ok
 }
 
+func (s *store) GetAllCommunityNames() sets.String {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	names := make(sets.String, len(s.communities))
+	for name := range s.communities {
+		names.Insert(name)
+	}
+
+	return names
+}
+
 func (s *store) GetCommunitiesByEndpoint(name string) []types.Community {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -198,8 +231,12 @@ func (s *store) DeleteCommunity(name string) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	cmm, ok := s.communities[name]
+	if !ok {
+		return
+	}
+
 	// remove this community from endpointToCommunity
-	cmm := s.communities[name]
 	for member := range cmm.Members {
 		cs := s.endpointToCommunities[member]
 		cs.Delete(name)
@@ -209,4 +246,18 @@ func (s *store) DeleteCommunity(name string) {
 	}
 
 	delete(s.communities, name)
+	s.notifyChanged()
+}
+
+func (s *store) Changed() <-chan struct{} {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.changed
+}
+
+// notifyChanged wakes up all watchers by closing current channel, it must be called with lock held
+func (s *store) notifyChanged() {
+	close(s.changed)
+	s.changed = make(chan struct{})
 }
@@ -90,6 +90,7 @@ var _ = Describe("Store", func() {
 		c, ok := store.GetCommunity(c1.Name)
 		Expect(ok).To(BeTrue())
 		Expect(c).To(Equal(c1))
+		Expect(store.GetAllCommunityNames().List()).To(ConsistOf(c1.Name, c2.Name))
 
 		communities := store.GetCommunitiesByEndpoint("edge1")
 		Expect(communities).To(ContainElement(c1))
@@ -117,4 +118,51 @@ var _ = Describe("Store", func() {
 		Expect(ok).To(BeFalse())
 		Expect(c).NotTo(Equal(c1))
 	})
+
+	It("should notify watchers when endpoints or communities are changed", func() {
+		e1 := apis.Endpoint{
+			Name:            "edge1",
+			PublicAddresses: []string{"10.40.20.181"},
+		}
+		c1 := types.Community{
+			Name:    "nginx",
+			Members: sets.NewString("edge1", "edge2"),
+		}
+
+		changed := store.Changed()
+		store.SaveEndpoint(e1)
+		Expect(changed).To(BeClosed())
+
+		By("saving the same endpoint again")
+		changed = store.Changed()
+		store.SaveEndpoint(e1)
+		Expect(changed).NotTo(BeClosed())
+
+		store.SaveCommunity(c1)
+		Expect(changed).To(BeClosed())
+
+		By("saving the same community again")
+		changed = store.Changed()
+		store.SaveCommunity(c1)
+		Expect(changed).NotTo(BeClosed())
+
+		By("changing DSCP of community")
+		c1.DSCP = 46
+		store.SaveCommunity(c1)
+		Expect(changed).To(BeClosed())
+		changed = store.Changed()
+
+		store.DeleteCommunity(c1.Name)
+		Expect(changed).To(BeClosed())
+
+		changed = store.Changed()
+		store.DeleteEndpoint(e1.Name)
+		Expect(changed).To(BeClosed())
+
+		By("deleting endpoints and communities which don't exist")
+		changed = store.Changed()
+		store.DeleteEndpoint(e1.Name)
+		store.DeleteCommunity(c1.Name)
+		Expect(changed).NotTo(BeClosed())
+	})
 })
@@ -0,0 +1,112 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	agentctl "github.com/fabedge/fabedge/pkg/operator/controllers/agent"
+)
+
+// teardown removes everything fabedge made in the cluster, including network settings
+// on edge nodes. It's triggered by deleting FabEdge resource or by --teardown argument.
+// Once it starts, agent and connector controllers stop maintaining their objects.
+type teardown struct {
+	started   int32
+	namespace string
+	agent     *agentctl.Teardown
+	client    client.Client
+
+	// connectorConfigs tells if ConnectorConfigs are maintained, they are deleted too
+	connectorConfigs bool
+	// agentConfigs tells if AgentConfigs are maintained, they are deleted too
+	agentConfigs bool
+}
+
+func newTeardown(opts Options) *teardown {
+	return &teardown{
+		namespace: opts.Namespace,
+		agent:     agentctl.NewTeardown(opts.Agent),
+		client:    opts.Manager.GetClient(),
+
+		connectorConfigs: opts.Connector.ConfigResource,
+		agentConfigs:     opts.Agent.ConfigResource,
+	}
+}
+
+func (td *teardown) isStarted() bool {
+	return atomic.LoadInt32(&td.started) == 1
+}
+
+// run executes one round of teardown, it returns true when everything is removed
+func (td *teardown) run(ctx context.Context) (bool, error) {
+	if atomic.CompareAndSwapInt32(&td.started, 0, 1) {
+		log.Info("start tearing down")
+	}
+
+	done, err := td.agent.Run(ctx)
+	if err != nil || !done {
+		return done, err
+	}
+
+	// agent configmaps/secrets, agent configs, connector config and TLS secret are all labeled
+	objects := []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}}
+	if td.connectorConfigs {
+		objects = append(objects, &apis.ConnectorConfig{})
+	}
+	if td.agentConfigs {
+		objects = append(objects, &apis.AgentConfig{})
+	}
+
+	for _, obj := range objects {
+		err = td.client.DeleteAllOf(ctx, obj,
+			client.InNamespace(td.namespace),
+			client.MatchingLabels{constants.KeyCreatedBy: constants.AppOperator},
+		)
+		if err != nil {
+			log.Error(err, "failed to delete objects created by operator")
+			return false, err
+		}
+	}
+
+	log.Info("teardown is finished")
+	return true, nil
+}
+
+// runUntilDone runs teardown repeatedly until it's finished or ctx is done
+func (td *teardown) runUntilDone(ctx context.Context, interval time.Duration) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		done, err := td.run(ctx)
+		if err == nil && done {
+			return nil
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"context"
+	"fmt"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+const (
+	// AgentTokenAudience is the audience of projected service account tokens of agent pods, it's
+	// different from DefaultServiceAccountAudience, so tokens of agents can't be used as tokens of clusters
+	AgentTokenAudience = "fabedge-agent"
+
+	extraPodName = "authentication.kubernetes.io/pod-name"
+	extraPodUID  = "authentication.kubernetes.io/pod-uid"
+)
+
+// AgentVerifier verifies projected service account tokens of agent pods by TokenReview of
+// the local cluster. A token must be bound to an agent pod made by operator, so the node
+// of the agent is the node where the pod is scheduled
+type AgentVerifier struct {
+	// Namespace and ServiceAccountName are where agent pods are and the service account they use
+	Namespace          string
+	ServiceAccountName string
+	Client             client.Client
+}
+
+// Verify checks if value is a token of an agent pod, it returns the node of the agent
+func (v *AgentVerifier) Verify(ctx context.Context, value string) (string, error) {
+	review := authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{
+			Token:     value,
+			Audiences: []string{AgentTokenAudience},
+		},
+	}
+	if err := v.Client.Create(ctx, &review); err != nil {
+		return "", err
+	}
+
+	status := review.Status
+	if !status.Authenticated {
+		if status.Error != "" {
+			return "", fmt.Errorf("%w: %s", ErrInvalidToken, status.Error)
+		}
+		return "", ErrInvalidToken
+	}
+
+	username := fmt.Sprintf("%s%s:%s", serviceAccountSubjectPrefix, v.Namespace, v.ServiceAccountName)
+	if status.User.Username != username || !containsString(status.Audiences, AgentTokenAudience) {
+		return "", ErrInvalidToken
+	}
+
+	// only tokens bound to pods have pod name and uid
+	podName, podUID := getExtra(status.User, extraPodName), getExtra(status.User, extraPodUID)
+	if podName == "" || podUID == "" {
+		return "", fmt.Errorf("%w: token is not bound to a pod", ErrInvalidToken)
+	}
+
+	var pod corev1.Pod
+	if err := v.Client.Get(ctx, client.ObjectKey{Name: podName, Namespace: v.Namespace}, &pod); err != nil {
+		return "", err
+	}
+
+	if string(pod.UID) != podUID ||
+		pod.Labels[constants.KeyFabedgeAPP] != constants.AppAgent ||
+		pod.Labels[constants.KeyCreatedBy] != constants.AppOperator ||
+		pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("%w: token is not bound to an agent pod", ErrInvalidToken)
+	}
+
+	return pod.Spec.NodeName, nil
+}
+
+func getExtra(user authnv1.UserInfo, key string) string {
+	if values := user.Extra[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
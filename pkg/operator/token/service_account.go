@@ -0,0 +1,306 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	// KeyServiceAccountIssuer is the annotation of cluster resource which records the issuer of service
+	// account tokens of the member cluster, e.g. https://kubernetes.default.svc.cluster.local. If it's set,
+	// bound service account tokens issued by it are accepted as tokens of the cluster
+	KeyServiceAccountIssuer = "fabedge.io/service-account-issuer"
+	// DefaultServiceAccountAudience is the audience which bound service account tokens must have
+	DefaultServiceAccountAudience = "fabedge"
+	// DefaultServiceAccountSubject is the subject which bound service account tokens must have,
+	// it's the service account of operator in member clusters
+	DefaultServiceAccountSubject = "system:serviceaccount:fabedge:fabedge-operator"
+
+	serviceAccountSubjectPrefix = "system:serviceaccount:"
+	// keysCacheDuration is how long keys of an issuer are cached
+	keysCacheDuration = 10 * time.Minute
+	// keysRefetchInterval is the shortest interval to fetch keys of an issuer again, keys are
+	// fetched again when a token has an unknown kid, it keeps them from being fetched by every token
+	keysRefetchInterval = time.Minute
+)
+
+// ServiceAccountVerifier verifies bound service account tokens of member clusters. The issuer of
+// a token must be the one recorded in a cluster resource and no other cluster, and the token
+// is verified by the keys which are found through OIDC discovery of the issuer
+type ServiceAccountVerifier struct {
+	Audience string
+	// Subject is the service account which tokens must be issued to, in format of
+	// system:serviceaccount:<namespace>:<name>, DefaultServiceAccountSubject is used if it's empty
+	Subject    string
+	Client     client.Client
+	HTTPClient *http.Client
+
+	mux  sync.Mutex
+	keys map[string]issuerKeys
+}
+
+type issuerKeys struct {
+	keys map[string]crypto.PublicKey
+	// err is the error of last fetching, it's returned until keys can be fetched again
+	err       error
+	fetchedAt time.Time
+}
+
+// Verify checks if value is a bound service account token issued by the issuer of a cluster,
+// it returns the cluster which the token belongs to
+func (v *ServiceAccountVerifier) Verify(ctx context.Context, value string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(value, &claims); err != nil {
+		return "", err
+	}
+
+	if claims.Issuer == "" || claims.Subject != v.subject() {
+		return "", ErrInvalidToken
+	}
+
+	clusterName, err := v.getClusterByIssuer(ctx, claims.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.ParseWithClaims(value, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.getKey(ctx, claims.Issuer, kid)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid || claims.ExpiresAt == nil || !claims.VerifyAudience(v.audience(), true) {
+		return "", ErrInvalidToken
+	}
+
+	return clusterName, nil
+}
+
+func (v *ServiceAccountVerifier) audience() string {
+	if v.Audience == "" {
+		return DefaultServiceAccountAudience
+	}
+	return v.Audience
+}
+
+func (v *ServiceAccountVerifier) subject() string {
+	if v.Subject == "" {
+		return DefaultServiceAccountSubject
+	}
+	return v.Subject
+}
+
+// getClusterByIssuer returns the cluster which trusts issuer. Clusters of default settings share
+// the same issuer, e.g. https://kubernetes.default.svc.cluster.local, a token of one of them would
+// be a token of others, so an issuer trusted by more than one cluster is rejected
+func (v *ServiceAccountVerifier) getClusterByIssuer(ctx context.Context, issuer string) (string, error) {
+	var clusters apis.ClusterList
+	if err := v.Client.List(ctx, &clusters); err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, cluster := range clusters.Items {
+		if cluster.DeletionTimestamp == nil && cluster.Annotations[KeyServiceAccountIssuer] == issuer {
+			names = append(names, cluster.Name)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no cluster trusts issuer %s", issuer)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("issuer %s is trusted by more than one cluster: %s", issuer, strings.Join(names, ","))
+	}
+}
+
+// getKey returns the key of issuer identified by kid, keys are fetched again if they are
+// out of date or kid is unknown, e.g. keys of issuer are rotated. Tokens are not authenticated
+// before their keys are found, so keys of an issuer are fetched at most once every
+// keysRefetchInterval, unknown kids and failures are answered from cache until then
+func (v *ServiceAccountVerifier) getKey(ctx context.Context, issuer, kid string) (crypto.PublicKey, error) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	cached, ok := v.keys[issuer]
+	key, found := cached.keys[kid]
+	switch {
+	case ok && found && time.Since(cached.fetchedAt) < keysCacheDuration:
+		return key, nil
+	case ok && time.Since(cached.fetchedAt) < keysRefetchInterval:
+		if cached.err != nil {
+			return nil, cached.err
+		}
+		return nil, fmt.Errorf("unknown key %q of issuer %s", kid, issuer)
+	}
+
+	keys, err := v.fetchKeys(ctx, issuer)
+
+	if v.keys == nil {
+		v.keys = make(map[string]issuerKeys)
+	}
+	v.keys[issuer] = issuerKeys{keys: keys, err: err, fetchedAt: time.Now()}
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q of issuer %s", kid, issuer)
+	}
+
+	return key, nil
+}
+
+func (v *ServiceAccountVerifier) fetchKeys(ctx context.Context, issuer string) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("issuer in discovery document %s doesn't match %s", discovery.Issuer, issuer)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+func (v *ServiceAccountVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: status code %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jsonWebKey is a public key in JWK format, only RSA and EC keys are supported
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	N     string `json:"n,omitempty"`
+	E     string `json:"e,omitempty"`
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Type {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Type)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,330 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+const (
+	// AppToken is the value of fabedge.io/app label of token secrets
+	AppToken = "fabedge-token"
+	// KeyHash is the key of token hash in token secret
+	KeyHash = "hash"
+	// KeyExpiresAt is the annotation which records when token expires, in RFC3339 format
+	KeyExpiresAt = "fabedge.io/expires-at"
+
+	secretNamePrefix = "fabedge-token-"
+)
+
+var ErrInvalidToken = fmt.Errorf("invalid token")
+
+// Token is a token issued to a cluster, Value is only available when it's minted
+type Token struct {
+	ID        string    `json:"id"`
+	Cluster   string    `json:"cluster"`
+	Value     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Manager mints, verifies and revokes tokens of clusters. A token is a JWT signed by
+// CA key, only its hash is stored in a secret. A token is valid only when its secret exists,
+// so a token is revoked by deleting its secret.
+type Manager struct {
+	Namespace  string
+	PrivateKey crypto.Signer
+	Client     client.Client
+}
+
+// SigningMethod returns the JWT signing method for tokens signed by the private key of publicKey
+func SigningMethod(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+}
+
+// SignedString signs token by key and returns the complete token. Unlike token.SignedString, key
+// can be any signer, e.g. an external signer whose key is kept in KMS or HSM
+func SignedString(token *jwt.Token, key crypto.Signer) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	var signature []byte
+	switch method := token.Method.(type) {
+	case *jwt.SigningMethodRSA:
+		digest := hashString(method.Hash, signingString)
+		signature, err = key.Sign(rand.Reader, digest, method.Hash)
+	case *jwt.SigningMethodECDSA:
+		digest := hashString(method.Hash, signingString)
+		signature, err = key.Sign(rand.Reader, digest, method.Hash)
+		if err == nil {
+			// JWT needs r and s in fixed size instead of ASN.1 form
+			signature, err = toRawECDSASignature(signature, method.KeySize)
+		}
+	case *jwt.SigningMethodEd25519:
+		signature, err = key.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	default:
+		return "", fmt.Errorf("unsupported signing method: %s", token.Method.Alg())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return signingString + "." + jwt.EncodeSegment(signature), nil
+}
+
+func hashString(hash crypto.Hash, s string) []byte {
+	hasher := hash.New()
+	hasher.Write([]byte(s))
+	return hasher.Sum(nil)
+}
+
+func toRawECDSASignature(der []byte, keySize int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("failed to parse ECDSA signature: %w", err)
+	}
+
+	raw := make([]byte, 2*keySize)
+	sig.R.FillBytes(raw[:keySize])
+	sig.S.FillBytes(raw[keySize:])
+	return raw, nil
+}
+
+// Mint creates a token for cluster which expires after validPeriod
+func (m Manager) Mint(ctx context.Context, cluster string, validPeriod time.Duration) (Token, error) {
+	id, err := newID()
+	if err != nil {
+		return Token{}, err
+	}
+
+	method, err := SigningMethod(m.PrivateKey.Public())
+	if err != nil {
+		return Token{}, err
+	}
+
+	expiresAt := time.Now().Add(validPeriod)
+	jwtToken := jwt.NewWithClaims(method, jwt.StandardClaims{
+		Id:        id,
+		Subject:   cluster,
+		ExpiresAt: expiresAt.Unix(),
+	})
+
+	value, err := SignedString(jwtToken, m.PrivateKey)
+	if err != nil {
+		return Token{}, err
+	}
+
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSecretName(id),
+			Namespace: m.Namespace,
+			Labels: map[string]string{
+				constants.KeyFabedgeAPP: AppToken,
+				constants.KeyCluster:    cluster,
+				constants.KeyCreatedBy:  constants.AppOperator,
+			},
+			Annotations: map[string]string{
+				KeyExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{
+			KeyHash: []byte(hash(value)),
+		},
+	}
+	if err = m.Client.Create(ctx, &secret); err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		ID:        id,
+		Cluster:   cluster,
+		Value:     value,
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0),
+	}, nil
+}
+
+// Verify checks if the token is signed by CA key, not expired and not revoked,
+// it returns the cluster which the token is issued to
+func (m Manager) Verify(ctx context.Context, value string) (string, error) {
+	var claims jwt.StandardClaims
+	token, err := jwt.ParseWithClaims(value, &claims, func(token *jwt.Token) (interface{}, error) {
+		return m.PrivateKey.Public(), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid || claims.Id == "" {
+		return "", ErrInvalidToken
+	}
+
+	var secret corev1.Secret
+	err = m.Client.Get(ctx, client.ObjectKey{Name: getSecretName(claims.Id), Namespace: m.Namespace}, &secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("token is revoked")
+		}
+		return "", err
+	}
+
+	if secret.Labels[constants.KeyCluster] != claims.Subject ||
+		subtle.ConstantTimeCompare(secret.Data[KeyHash], []byte(hash(value))) != 1 {
+		return "", ErrInvalidToken
+	}
+
+	return claims.Subject, nil
+}
+
+// List returns tokens of cluster sorted by expiration time, token values are not included
+func (m Manager) List(ctx context.Context, cluster string) ([]Token, error) {
+	var secrets corev1.SecretList
+	err := m.Client.List(ctx, &secrets,
+		client.InNamespace(m.Namespace),
+		client.MatchingLabels{
+			constants.KeyFabedgeAPP: AppToken,
+			constants.KeyCluster:    cluster,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		expiresAt, _ := GetExpiresAt(secret)
+		tokens = append(tokens, Token{
+			ID:        secret.Name[len(secretNamePrefix):],
+			Cluster:   cluster,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ExpiresAt.Before(tokens[j].ExpiresAt)
+	})
+
+	return tokens, nil
+}
+
+// Revoke revokes a token of cluster, it returns a NotFound error if the token doesn't exist
+func (m Manager) Revoke(ctx context.Context, cluster, id string) error {
+	var secret corev1.Secret
+	err := m.Client.Get(ctx, client.ObjectKey{Name: getSecretName(id), Namespace: m.Namespace}, &secret)
+	if err != nil {
+		return err
+	}
+
+	if secret.Labels[constants.KeyCluster] != cluster {
+		return errors.NewNotFound(corev1.Resource("secrets"), secret.Name)
+	}
+
+	return client.IgnoreNotFound(m.Client.Delete(ctx, &secret))
+}
+
+// RevokeAll revokes all tokens of cluster
+func (m Manager) RevokeAll(ctx context.Context, cluster string) error {
+	return m.Client.DeleteAllOf(ctx, &corev1.Secret{},
+		client.InNamespace(m.Namespace),
+		client.MatchingLabels{
+			constants.KeyFabedgeAPP: AppToken,
+			constants.KeyCluster:    cluster,
+		},
+	)
+}
+
+// Rotate mints a new token for cluster and revokes all the others
+func (m Manager) Rotate(ctx context.Context, cluster string, validPeriod time.Duration) (Token, error) {
+	oldTokens, err := m.List(ctx, cluster)
+	if err != nil {
+		return Token{}, err
+	}
+
+	token, err := m.Mint(ctx, cluster, validPeriod)
+	if err != nil {
+		return Token{}, err
+	}
+
+	for _, t := range oldTokens {
+		if err = m.Revoke(ctx, cluster, t.ID); err != nil && !errors.IsNotFound(err) {
+			return token, err
+		}
+	}
+
+	return token, nil
+}
+
+// GetExpiresAt returns expiration time recorded in token secret
+func GetExpiresAt(secret corev1.Secret) (time.Time, error) {
+	return time.Parse(time.RFC3339, secret.Annotations[KeyExpiresAt])
+}
+
+func getSecretName(id string) string {
+	return secretNamePrefix + id
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
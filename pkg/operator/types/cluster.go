@@ -0,0 +1,174 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+)
+
+const (
+	ReasonHeartbeatReceived = "HeartbeatReceived"
+	ReasonHeartbeatMissed   = "HeartbeatMissed"
+	ReasonNeverSeen         = "NeverSeen"
+	ReasonNoEndpoints       = "NoEndpoints"
+
+	ReasonSynced  = "Synced"
+	ReasonEvicted = "Evicted"
+
+	ReasonCertificatesValid   = "CertificatesValid"
+	ReasonCertificatesInvalid = "CertificatesInvalid"
+)
+
+// SetClusterInventory sets endpoint count and connector public addresses of
+// cluster status according to its endpoints
+func SetClusterInventory(cluster *apis.Cluster) {
+	addresses := sets.NewString()
+	for _, endpoint := range cluster.Spec.EndPoints {
+		if endpoint.Type == apis.EdgeNode {
+			continue
+		}
+		addresses.Insert(endpoint.PublicAddresses...)
+	}
+
+	cluster.Status.EndpointCount = int32(len(cluster.Spec.EndPoints))
+	cluster.Status.ConnectorPublicAddresses = nil
+	if addresses.Len() > 0 {
+		cluster.Status.ConnectorPublicAddresses = addresses.List()
+	}
+}
+
+// SetClusterReady sets Ready condition of cluster, a cluster is ready if it's seen, has endpoints
+// and its certificates are not invalid
+func SetClusterReady(cluster *apis.Cluster, seen bool, reason, message string) {
+	certificate := meta.FindStatusCondition(cluster.Status.Conditions, apis.ConditionCertificateValid)
+
+	status := metav1.ConditionFalse
+	switch {
+	case seen && cluster.Status.EndpointCount == 0:
+		reason, message = ReasonNoEndpoints, "cluster has no endpoints exported"
+	case seen && certificate != nil && certificate.Status == metav1.ConditionFalse:
+		reason, message = certificate.Reason, certificate.Message
+	case seen:
+		status = metav1.ConditionTrue
+	}
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+		Type:    apis.ClusterConditionReady,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// SetClusterEndpointsSynced sets EndpointsSynced condition of cluster, endpoints of an evicted
+// cluster are removed from store, so they are not synced
+func SetClusterEndpointsSynced(cluster *apis.Cluster, evicted bool) {
+	condition := metav1.Condition{
+		Type:    apis.ConditionEndpointsSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonSynced,
+		Message: fmt.Sprintf("%d endpoints are synced", len(cluster.Spec.EndPoints)),
+	}
+	if evicted {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonEvicted
+		condition.Message = "endpoints are evicted because cluster is absent for a long time"
+	}
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+}
+
+// SetClusterCertificateValid sets CertificateValid condition of cluster, invalid are names of secrets
+// whose certificates are expired or not valid yet
+func SetClusterCertificateValid(cluster *apis.Cluster, invalid []string) {
+	condition := metav1.Condition{
+		Type:    apis.ConditionCertificateValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonCertificatesValid,
+		Message: "all certificates are within their validity period",
+	}
+	if len(invalid) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonCertificatesInvalid
+		condition.Message = fmt.Sprintf("certificates of these secrets are expired or not valid yet: %s", strings.Join(invalid, ", "))
+	}
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+}
+
+// RecordClusterCertificate records the serial number of a client certificate of cluster in its
+// annotation, so the certificate can be revoked when the cluster is deregistered. Records of
+// expired certificates are dropped
+func RecordClusterCertificate(cluster *apis.Cluster, cert *x509.Certificate) {
+	records := getClusterCertRecords(*cluster)
+	now := time.Now()
+	for serialNumber, notAfter := range records {
+		if now.After(notAfter) {
+			delete(records, serialNumber)
+		}
+	}
+	records[fmt.Sprintf("%X", cert.SerialNumber)] = cert.NotAfter
+
+	// a map of strings and times is always marshaled
+	content, _ := json.Marshal(records)
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[constants.KeyCertSerialNumbers] = string(content)
+}
+
+// GetClusterCertSerialNumbers returns serial numbers of client certificates of cluster which are not expired
+func GetClusterCertSerialNumbers(cluster apis.Cluster) []*big.Int {
+	var serialNumbers []*big.Int
+
+	now := time.Now()
+	for value, notAfter := range getClusterCertRecords(cluster) {
+		serialNumber, ok := new(big.Int).SetString(value, 16)
+		if !ok || now.After(notAfter) {
+			continue
+		}
+		serialNumbers = append(serialNumbers, serialNumber)
+	}
+
+	return serialNumbers
+}
+
+// getClusterCertRecords returns expiry times of client certificates of cluster, keys are serial
+// numbers in hex. Broken records are ignored
+func getClusterCertRecords(cluster apis.Cluster) map[string]time.Time {
+	records := make(map[string]time.Time)
+	value, ok := cluster.Annotations[constants.KeyCertSerialNumbers]
+	if !ok {
+		return records
+	}
+
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return make(map[string]time.Time)
+	}
+
+	return records
+}
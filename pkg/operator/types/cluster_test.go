@@ -0,0 +1,68 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"crypto/x509"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/constants"
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+var _ = Describe("ClusterCertificates", func() {
+	newCert := func(serialNumber int64, validPeriod time.Duration) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serialNumber),
+			NotAfter:     time.Now().Add(validPeriod),
+		}
+	}
+
+	It("should record serial numbers of certificates in annotation of cluster", func() {
+		var cluster apis.Cluster
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(BeEmpty())
+
+		types.RecordClusterCertificate(&cluster, newCert(0x1A2B, time.Hour))
+		types.RecordClusterCertificate(&cluster, newCert(0x3C4D, time.Hour))
+		types.RecordClusterCertificate(&cluster, newCert(0x3C4D, time.Hour))
+
+		Expect(cluster.Annotations[constants.KeyCertSerialNumbers]).Should(ContainSubstring("1A2B"))
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(ConsistOf(big.NewInt(0x1A2B), big.NewInt(0x3C4D)))
+	})
+
+	It("should drop expired certificates", func() {
+		var cluster apis.Cluster
+		types.RecordClusterCertificate(&cluster, newCert(1, -time.Second))
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(BeEmpty())
+
+		types.RecordClusterCertificate(&cluster, newCert(2, time.Hour))
+		Expect(cluster.Annotations[constants.KeyCertSerialNumbers]).ShouldNot(ContainSubstring(`"1"`))
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(ConsistOf(big.NewInt(2)))
+	})
+
+	It("should ignore broken annotation", func() {
+		cluster := apis.Cluster{}
+		cluster.Annotations = map[string]string{constants.KeyCertSerialNumbers: "broken"}
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(BeEmpty())
+
+		types.RecordClusterCertificate(&cluster, newCert(3, time.Hour))
+		Expect(types.GetClusterCertSerialNumbers(cluster)).Should(ConsistOf(big.NewInt(3)))
+	})
+})
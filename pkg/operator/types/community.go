@@ -15,10 +15,107 @@
 package types
 
 import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	ReasonMembersMissing = "MembersMissing"
+	ReasonTunnelsUp      = "TunnelsUp"
+	ReasonTunnelsDown    = "TunnelsDown"
+	ReasonNoProbes       = "NoProbes"
+	ReasonPending        = "Pending"
+	ReasonHealthy        = "Healthy"
 )
 
 type Community struct {
 	Name    string
 	Members sets.String
+	// DSCP is set by connector on traffic to members, 0 means no marking
+	DSCP int32
+	// EgressBandwidth is the cap of traffic from each member to other members in
+	// bits per second, 0 means no cap
+	EgressBandwidth int64
+}
+
+// SetCommunityEndpointsSynced sets EndpointsSynced condition of community, missing are
+// members whose endpoints are not in store
+func SetCommunityEndpointsSynced(community *apis.Community, missing []string) {
+	condition := metav1.Condition{
+		Type:    apis.ConditionEndpointsSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonSynced,
+		Message: "endpoints of all members are synced",
+	}
+	if len(missing) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonMembersMissing
+		condition.Message = fmt.Sprintf("endpoints of these members are not found: %s", strings.Join(missing, ", "))
+	}
+
+	meta.SetStatusCondition(&community.Status.Conditions, condition)
+}
+
+// SetCommunityTunnelsEstablished sets TunnelsEstablished condition of community according to
+// its connectivity, a tunnel is taken as down if all probes through it are lost
+func SetCommunityTunnelsEstablished(community *apis.Community) {
+	var down []string
+	for _, c := range community.Status.Connectivity {
+		if c.Loss >= 100 {
+			down = append(down, fmt.Sprintf("%s -> %s", c.From, c.To))
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    apis.ConditionTunnelsEstablished,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonTunnelsUp,
+		Message: fmt.Sprintf("%d tunnels are up", len(community.Status.Connectivity)),
+	}
+	switch {
+	case len(community.Status.Connectivity) == 0:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = ReasonNoProbes
+		condition.Message = "no probes are reported by members"
+	case len(down) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonTunnelsDown
+		condition.Message = fmt.Sprintf("these tunnels are down: %s", strings.Join(down, ", "))
+	}
+
+	meta.SetStatusCondition(&community.Status.Conditions, condition)
+}
+
+// SetCommunityReady sets Ready condition of community, a community is ready if endpoints of
+// its members are synced and none of tunnels between them is down
+func SetCommunityReady(community *apis.Community) {
+	synced := meta.FindStatusCondition(community.Status.Conditions, apis.ConditionEndpointsSynced)
+	tunnels := meta.FindStatusCondition(community.Status.Conditions, apis.ConditionTunnelsEstablished)
+
+	condition := metav1.Condition{
+		Type:    apis.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonHealthy,
+		Message: "endpoints of members are synced and no tunnel is down",
+	}
+	switch {
+	case synced != nil && synced.Status != metav1.ConditionTrue:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason, condition.Message = synced.Reason, synced.Message
+	case tunnels != nil && tunnels.Status == metav1.ConditionFalse:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason, condition.Message = tunnels.Reason, tunnels.Message
+	case synced == nil:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = ReasonPending
+		condition.Message = "endpoints of members are not checked yet"
+	}
+
+	meta.SetStatusCondition(&community.Status.Conditions, condition)
 }
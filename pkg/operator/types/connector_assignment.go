@@ -0,0 +1,101 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ConnectorAssignment records which connector an edge endpoint is assigned to,
+// endpoints which are not assigned explicitly belong to the default connector
+// whose name is empty
+type ConnectorAssignment interface {
+	Assign(endpointName, connectorName string)
+	Unassign(endpointName string)
+	GetConnectorName(endpointName string) string
+}
+
+type connectorAssignment struct {
+	// key is endpoint name, value is connector name
+	assignments map[string]string
+	mux         sync.RWMutex
+}
+
+func NewConnectorAssignment() ConnectorAssignment {
+	return &connectorAssignment{
+		assignments: make(map[string]string),
+	}
+}
+
+func (a *connectorAssignment) Assign(endpointName, connectorName string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if connectorName == "" {
+		delete(a.assignments, endpointName)
+		return
+	}
+
+	a.assignments[endpointName] = connectorName
+}
+
+func (a *connectorAssignment) Unassign(endpointName string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	delete(a.assignments, endpointName)
+}
+
+func (a *connectorAssignment) GetConnectorName(endpointName string) string {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return a.assignments[endpointName]
+}
+
+// HashConnectorName picks a connector for an endpoint from connectorNames by rendezvous hashing,
+// the empty name means the default connector. An endpoint stays with its connector when other
+// connectors are added or removed, only endpoints of a removed connector are moved
+func HashConnectorName(endpointName string, connectorNames []string) string {
+	var (
+		picked    string
+		highScore uint64
+	)
+
+	for i, name := range connectorNames {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(endpointName))
+
+		if score := mix64(h.Sum64()); i == 0 || score > highScore {
+			picked, highScore = name, score
+		}
+	}
+
+	return picked
+}
+
+// mix64 is the finalizer of MurmurHash3, FNV hashes of names which only differ in a few bytes
+// are close to each other, without mixing them, the same connector gets the highest score mostly
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
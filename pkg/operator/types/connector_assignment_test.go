@@ -0,0 +1,75 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fabedge/fabedge/pkg/operator/types"
+)
+
+var _ = Describe("ConnectorAssignment", func() {
+	It("should record which connector an endpoint is assigned to", func() {
+		assignment := types.NewConnectorAssignment()
+		Expect(assignment.GetConnectorName("edge1")).Should(BeEmpty())
+
+		assignment.Assign("edge1", "east")
+		assignment.Assign("edge2", "west")
+		Expect(assignment.GetConnectorName("edge1")).Should(Equal("east"))
+		Expect(assignment.GetConnectorName("edge2")).Should(Equal("west"))
+
+		By("assign edge1 back to default connector")
+		assignment.Assign("edge1", "")
+		Expect(assignment.GetConnectorName("edge1")).Should(BeEmpty())
+
+		assignment.Unassign("edge2")
+		Expect(assignment.GetConnectorName("edge2")).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("HashConnectorName", func() {
+	It("should spread endpoints among connectors", func() {
+		connectors := []string{"", "east", "west"}
+
+		counts := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			counts[types.HashConnectorName(fmt.Sprintf("edge%d", i), connectors)]++
+		}
+
+		for _, name := range connectors {
+			Expect(counts[name]).Should(BeNumerically(">", 50), "connector: %q", name)
+		}
+	})
+
+	It("should only move endpoints of a removed connector", func() {
+		before := []string{"", "east", "west"}
+		after := []string{"", "east"}
+
+		for i := 0; i < 100; i++ {
+			endpoint := fmt.Sprintf("edge%d", i)
+			name := types.HashConnectorName(endpoint, before)
+			if name != "west" {
+				Expect(types.HashConnectorName(endpoint, after)).Should(Equal(name))
+			}
+		}
+	})
+
+	It("should return empty name if no connector is provided", func() {
+		Expect(types.HashConnectorName("edge1", nil)).Should(BeEmpty())
+	})
+})
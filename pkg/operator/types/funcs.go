@@ -35,8 +35,10 @@ func NewEndpointFuncs(namePrefix, idFormat string, getPodCIDRs PodCIDRsGetter) (
 		return fmt.Sprintf("%s.%s", namePrefix, name)
 	}
 
+	// {node} is replaced with endpoint name, {cluster} and {name} are replaced with
+	// cluster name and node name respectively, e.g. spiffe://example.org/{cluster}/{name}
 	getID := func(name string) string {
-		return strings.ReplaceAll(idFormat, "{node}", getName(name))
+		return strings.NewReplacer("{node}", getName(name), "{cluster}", namePrefix, "{name}", name).Replace(idFormat)
 	}
 
 	newEndpoint := func(node corev1.Node) apis.Endpoint {
@@ -63,6 +65,7 @@ func NewEndpointFuncs(namePrefix, idFormat string, getPodCIDRs PodCIDRsGetter) (
 			Subnets:         getPodCIDRs(node),
 			NodeSubnets:     nodeSubnets,
 			Type:            apis.EdgeNode,
+			RelayOnly:       node.Annotations[constants.KeyRelayOnly] == "true",
 		}
 	}
 
@@ -93,4 +93,8 @@ var _ = Describe("EndpointFuncs", func() {
 		Expect(endpoint.PublicAddresses).Should(ConsistOf("www.example.com", "10.0.0.1"))
 	})
 
+	It("should replace {cluster} and {name} in id format", func() {
+		_, getID, _ := types.NewEndpointFuncs("cluster", "spiffe://example.org/{cluster}/{name}", nodeutil.GetPodCIDRsFromAnnotation)
+		Expect(getID("edge1")).Should(Equal("spiffe://example.org/cluster/edge1"))
+	})
 })
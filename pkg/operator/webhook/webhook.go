@@ -0,0 +1,203 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// EventClusterJoined is sent when a member cluster gets its certificate with a token
+	EventClusterJoined = "ClusterJoined"
+	// EventEndpointsExported is sent when a member cluster exports endpoints for the first time
+	EventEndpointsExported = "EndpointsExported"
+	// EventClusterStale is sent when a member cluster stops reporting heartbeats
+	EventClusterStale = "ClusterStale"
+	// EventClusterRemoved is sent when a member cluster is deregistered
+	EventClusterRemoved = "ClusterRemoved"
+
+	// EventTunnelDown is sent when a tunnel stays down longer than the alert threshold
+	EventTunnelDown = "TunnelDown"
+	// EventTunnelRecovered is sent when a tunnel which is alerted as down goes up again
+	EventTunnelRecovered = "TunnelRecovered"
+	// EventClusterNotExporting is sent when a member cluster stops exporting endpoints longer than the alert threshold
+	EventClusterNotExporting = "ClusterNotExporting"
+	// EventClusterRecovered is sent when a member cluster which is alerted as not exporting is ready again
+	EventClusterRecovered = "ClusterRecovered"
+
+	// FormatJSON posts events as they are
+	FormatJSON = "json"
+	// FormatSlack posts events as messages of Slack incoming webhooks, i.e. {"text": "..."},
+	// which are accepted by many chat tools too
+	FormatSlack = "slack"
+
+	// HeaderEvent tells which type of event a webhook is
+	HeaderEvent = "X-FabEdge-Event"
+	// HeaderSignature is the HMAC-SHA256 of request body signed by webhook secret, in format sha256=<hex>
+	HeaderSignature = "X-FabEdge-Signature"
+
+	defaultMaxRetries = 3
+	defaultRetryDelay = 5 * time.Second
+)
+
+// Event is a lifecycle event or an alert of member cluster, it's the body of webhook request
+type Event struct {
+	Type    string    `json:"type"`
+	Cluster string    `json:"cluster"`
+	Time    time.Time `json:"time"`
+	// Summary is a human readable description of event, it's the first line of Slack messages
+	Summary string `json:"summary,omitempty"`
+	// Detail holds extra information of event, e.g. names of exported endpoints
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+// Text returns event as a message for people, summary goes first and then details sorted by keys
+func (e Event) Text() string {
+	summary := e.Summary
+	if summary == "" {
+		summary = fmt.Sprintf("%s: cluster %s", e.Type, e.Cluster)
+	}
+
+	keys := make([]string, 0, len(e.Detail))
+	for key := range e.Detail {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{summary}
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", key, e.Detail[key]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type Notifier interface {
+	Notify(event Event)
+}
+
+// Sender posts events to URL in background, a failed delivery is retried a few
+// times and then dropped, so events may arrive out of order, use Time of event to sort them
+type Sender struct {
+	URL        string
+	Secret     []byte
+	HTTPClient *http.Client
+	Log        logr.Logger
+	// MaxRetries is how many times a failed delivery is retried, 0 means defaultMaxRetries
+	MaxRetries int
+	// RetryDelay is how long to wait before retrying, 0 means defaultRetryDelay
+	RetryDelay time.Duration
+	// Format is how events are encoded, empty means FormatJSON
+	Format string
+}
+
+func (s Sender) Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	go s.deliver(event)
+}
+
+func (s Sender) deliver(event Event) {
+	log := s.Log.WithValues("type", event.Type, "cluster", event.Cluster)
+
+	body, err := s.encode(event)
+	if err != nil {
+		log.Error(err, "failed to marshal webhook event")
+		return
+	}
+
+	maxRetries, retryDelay := s.MaxRetries, s.RetryDelay
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if retryDelay <= 0 {
+		retryDelay = defaultRetryDelay
+	}
+
+	for i := 0; ; i++ {
+		err = s.post(event.Type, body)
+		if err == nil {
+			log.V(5).Info("webhook is delivered")
+			return
+		}
+
+		if i >= maxRetries {
+			log.Error(err, "failed to deliver webhook, event is dropped")
+			return
+		}
+
+		log.V(3).Info("failed to deliver webhook, retry later", "error", err.Error())
+		time.Sleep(retryDelay)
+	}
+}
+
+func (s Sender) encode(event Event) ([]byte, error) {
+	if s.Format == FormatSlack {
+		return json.Marshal(map[string]string{"text": event.Text()})
+	}
+
+	return json.Marshal(event)
+}
+
+func (s Sender) post(eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	if len(s.Secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(s.Secret, body))
+	}
+
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature of body in format sha256=<hex>, receivers
+// compute it with the same secret to verify webhooks
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
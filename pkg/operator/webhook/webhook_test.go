@@ -0,0 +1,138 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/fabedge/fabedge/pkg/operator/webhook"
+)
+
+var _ = Describe("Sender", func() {
+	var (
+		secret   = []byte("secret")
+		received chan webhook.Event
+		failures int32
+		server   *httptest.Server
+		sender   webhook.Sender
+	)
+
+	BeforeEach(func() {
+		received = make(chan webhook.Event, 10)
+		atomic.StoreInt32(&failures, 0)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get(webhook.HeaderSignature) != webhook.Sign(secret, body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			var event webhook.Event
+			if err := json.Unmarshal(body, &event); err != nil || r.Header.Get(webhook.HeaderEvent) != event.Type {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			received <- event
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		sender = webhook.Sender{
+			URL:        server.URL,
+			Secret:     secret,
+			Log:        klogr.New(),
+			RetryDelay: 10 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should post signed events", func() {
+		sender.Notify(webhook.Event{
+			Type:    webhook.EventClusterJoined,
+			Cluster: "beijing",
+		})
+
+		var event webhook.Event
+		Eventually(received).Should(Receive(&event))
+		Expect(event.Type).Should(Equal(webhook.EventClusterJoined))
+		Expect(event.Cluster).Should(Equal("beijing"))
+		Expect(event.Time.IsZero()).Should(BeFalse())
+	})
+
+	It("should retry failed deliveries", func() {
+		atomic.StoreInt32(&failures, 2)
+
+		sender.Notify(webhook.Event{Type: webhook.EventClusterRemoved, Cluster: "beijing"})
+		Eventually(received).Should(Receive())
+	})
+
+	It("should drop events after max retries", func() {
+		atomic.StoreInt32(&failures, 100)
+		sender.MaxRetries = 1
+
+		sender.Notify(webhook.Event{Type: webhook.EventClusterStale, Cluster: "beijing"})
+		Consistently(received, 200*time.Millisecond).ShouldNot(Receive())
+		Expect(atomic.LoadInt32(&failures)).Should(Equal(int32(98)))
+	})
+
+	It("should post events as slack messages if format is slack", func() {
+		texts := make(chan string, 1)
+		slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var message map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&message); err != nil || r.Header.Get(webhook.HeaderSignature) != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			texts <- message["text"]
+		}))
+		defer slack.Close()
+
+		sender.URL, sender.Secret, sender.Format = slack.URL, nil, webhook.FormatSlack
+		sender.Notify(webhook.Event{
+			Type:    webhook.EventTunnelDown,
+			Cluster: "beijing",
+			Summary: "tunnel from edge1 to edge2 is down for 10m0s",
+			Detail:  map[string]string{"peer": "edge2", "node": "edge1"},
+		})
+
+		Eventually(texts).Should(Receive(Equal("tunnel from edge1 to edge2 is down for 10m0s\nnode: edge1\npeer: edge2")))
+	})
+})
+
+var _ = Describe("Event", func() {
+	It("should use type and cluster as summary if summary is empty", func() {
+		event := webhook.Event{Type: webhook.EventClusterStale, Cluster: "beijing", Detail: map[string]string{"lastSeen": "2021-10-01T00:00:00Z"}}
+		Expect(event.Text()).Should(Equal("ClusterStale: cluster beijing\nlastSeen: 2021-10-01T00:00:00Z"))
+	})
+})
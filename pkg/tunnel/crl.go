@@ -0,0 +1,39 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+)
+
+// LoadCRLFile loads CRLs in filename by tm, nothing is done if the file
+// doesn't exist or is empty, e.g. no certificate is revoked yet
+func LoadCRLFile(tm Manager, filename string) error {
+	crlPEM, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(crlPEM)) == 0 {
+		return nil
+	}
+
+	return tm.LoadCRL(crlPEM)
+}
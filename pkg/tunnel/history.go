@@ -0,0 +1,218 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+// FlapWindow is how far back events are counted when tunnels are summarized
+const FlapWindow = time.Hour
+
+// History keeps the latest events of each connection in a ring buffer, so unstable tunnels
+// can be found by how often they went down recently. It's safe for concurrent use
+type History struct {
+	size int
+
+	mux   sync.Mutex
+	rings map[string]*eventRing
+}
+
+// eventRing keeps at most size events, the oldest one is overwritten when it's full
+type eventRing struct {
+	events []Event
+	// start is the index of the oldest event once the ring is full
+	start int
+	// lastTransition is the last up or down event, it's kept even if it's overwritten by rekeys
+	lastTransition *Event
+}
+
+// NewHistory returns a history which keeps at most size events of each connection
+func NewHistory(size int) *History {
+	return &History{
+		size:  size,
+		rings: make(map[string]*eventRing),
+	}
+}
+
+// Record puts an event in the ring of its connection
+func (h *History) Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	r, ok := h.rings[event.Name]
+	if !ok {
+		r = &eventRing{events: make([]Event, 0, h.size)}
+		h.rings[event.Name] = r
+	}
+
+	if len(r.events) < h.size {
+		r.events = append(r.events, event)
+	} else {
+		r.events[r.start] = event
+		r.start = (r.start + 1) % h.size
+	}
+
+	if event.Type != EventRekey {
+		e := event
+		r.lastTransition = &e
+	}
+}
+
+// Events returns events of a connection from the oldest to the latest, if name is empty,
+// events of all connections are returned
+func (h *History) Events(name string) []Event {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if name != "" {
+		if r, ok := h.rings[name]; ok {
+			return r.list()
+		}
+		return nil
+	}
+
+	var events []Event
+	for _, r := range h.rings {
+		events = append(events, r.list()...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	return events
+}
+
+// Retain forgets connections which are not in names, e.g. peers removed from tunnels config
+func (h *History) Retain(names []string) {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	for name := range h.rings {
+		if !keep[name] {
+			delete(h.rings, name)
+		}
+	}
+}
+
+// Summarize counts flaps and rekeys of connections in names during FlapWindow before now,
+// connections without events are skipped. A flap is an up tunnel going down, SAs of a
+// tunnel going down one by one count once. A tunnel is flapping if its flaps exceed threshold
+func (h *History) Summarize(names []string, now time.Time, threshold int) []apis.TunnelSummary {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	since := now.Add(-FlapWindow)
+	summaries := make([]apis.TunnelSummary, 0, len(names))
+	for _, name := range names {
+		r, ok := h.rings[name]
+		if !ok {
+			continue
+		}
+
+		summary := apis.TunnelSummary{Peer: name}
+		// the state before the oldest event is unknown, so its first down event counts
+		up := true
+		for _, event := range r.list() {
+			switch event.Type {
+			case EventUp:
+				up = true
+			case EventDown:
+				if up && !event.Time.Before(since) {
+					summary.Flaps++
+				}
+				up = false
+			case EventRekey:
+				if !event.Time.Before(since) {
+					summary.Rekeys++
+				}
+			}
+		}
+
+		if r.lastTransition != nil {
+			// status keeps seconds only, truncating makes summaries comparable with reported ones
+			summary.Up = r.lastTransition.Type == EventUp
+			summary.LastTransitionTime = &metav1.Time{Time: r.lastTransition.Time.Truncate(time.Second)}
+		}
+		summary.Flapping = int(summary.Flaps) > threshold
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// ServeHTTP writes events in JSON, events of a connection are written if query parameter peer is provided
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events := h.Events(r.URL.Query().Get("peer"))
+	if events == nil {
+		events = []Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(events)
+}
+
+// FlappingCondition returns a condition of conditionType which is true if any tunnel in summaries
+// is flapping, flapping peers are put in its message
+func FlappingCondition(conditionType string, summaries []apis.TunnelSummary) metav1.Condition {
+	var peers []string
+	for _, summary := range summaries {
+		if summary.Flapping {
+			peers = append(peers, summary.Peer)
+		}
+	}
+
+	if len(peers) == 0 {
+		return metav1.Condition{
+			Type:    conditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Stable",
+			Message: "no tunnel is flapping",
+		}
+	}
+
+	return metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Flapping",
+		Message: fmt.Sprintf("tunnels to %s went down too many times in the last hour", strings.Join(peers, ", ")),
+	}
+}
+
+func (r *eventRing) list() []Event {
+	events := make([]Event, 0, len(r.events))
+	events = append(events, r.events[r.start:]...)
+	return append(events, r.events[:r.start]...)
+}
@@ -15,15 +15,48 @@
 package tunnel
 
 import (
+	"context"
+	"time"
+
 	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
 )
 
 type Manager interface {
 	ListConnNames() ([]string, error)
 	LoadConn(conn ConnConfig) error
+	// ReloadConn loads conn again even if it's not changed, established SAs are kept.
+	// It's used when local certificates are renewed
+	ReloadConn(conn ConnConfig) error
 	InitiateConn(name string) error
 	UnloadConn(name string) error
 	IsActive() (bool, error)
+	// IsConnEstablished checks if any child SA of connection is established
+	IsConnEstablished(name string) (bool, error)
+	// LoadCRL loads CRLs in PEM, tunnels can't be established with peers whose
+	// certificates are revoked by them
+	LoadCRL(crlPEM []byte) error
+	// LoadCredentials loads the private key and CA certificates in PEM, it's used when
+	// they are not in files which are read when strongswan starts
+	LoadCredentials(keyPEM, caCertsPEM []byte) error
+	// WatchEvents calls handler when an IKE SA or a child SA goes up, goes down or is rekeyed,
+	// it blocks until ctx is done or watching fails
+	WatchEvents(ctx context.Context, handler func(Event)) error
+}
+
+type EventType string
+
+const (
+	EventUp    EventType = "up"
+	EventDown  EventType = "down"
+	EventRekey EventType = "rekey"
+)
+
+// Event tells that an SA of a connection goes up, goes down or is rekeyed
+type Event struct {
+	// Name is the name of the connection
+	Name string    `json:"name"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
 }
 
 type ConnConfig struct {
@@ -0,0 +1,235 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+// partitionedManager spreads connections among managers by type of remote endpoint, e.g. tunnels
+// to edge nodes and tunnels to other clusters are loaded by different strongswan instances, so
+// a failure of one instance doesn't take down all tunnels
+type partitionedManager struct {
+	defaultManager Manager
+	managers       map[apis.EndpointType]Manager
+
+	mux sync.Mutex
+	// owners are managers which connections are loaded by, they are refreshed by ListConnNames
+	owners map[string]Manager
+}
+
+// NewPartitionedManager returns a manager which loads connections by managers[RemoteType], connections
+// of other types are loaded by defaultManager. defaultManager is returned if managers is empty
+func NewPartitionedManager(defaultManager Manager, managers map[apis.EndpointType]Manager) Manager {
+	if len(managers) == 0 {
+		return defaultManager
+	}
+
+	return &partitionedManager{
+		defaultManager: defaultManager,
+		managers:       managers,
+		owners:         make(map[string]Manager),
+	}
+}
+
+// all returns each manager once
+func (m *partitionedManager) all() []Manager {
+	all := []Manager{m.defaultManager}
+	for _, manager := range m.managers {
+		duplicated := false
+		for _, added := range all {
+			if added == manager {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			all = append(all, manager)
+		}
+	}
+	return all
+}
+
+func (m *partitionedManager) managerOf(conn ConnConfig) Manager {
+	if manager, ok := m.managers[conn.RemoteType]; ok {
+		return manager
+	}
+	return m.defaultManager
+}
+
+// ownerOf returns the manager which loaded connection name, it's found by asking each manager if it's unknown
+func (m *partitionedManager) ownerOf(name string) (Manager, error) {
+	m.mux.Lock()
+	owner, ok := m.owners[name]
+	m.mux.Unlock()
+	if ok {
+		return owner, nil
+	}
+
+	if _, err := m.ListConnNames(); err != nil {
+		return nil, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if owner, ok = m.owners[name]; !ok {
+		return nil, fmt.Errorf("connection %s is not loaded", name)
+	}
+	return owner, nil
+}
+
+func (m *partitionedManager) ListConnNames() ([]string, error) {
+	var names []string
+	owners := make(map[string]Manager)
+	for _, manager := range m.all() {
+		list, err := manager.ListConnNames()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range list {
+			owners[name] = manager
+		}
+		names = append(names, list...)
+	}
+
+	m.mux.Lock()
+	m.owners = owners
+	m.mux.Unlock()
+
+	return names, nil
+}
+
+// LoadConn loads conn by its manager, if conn is loaded by another manager, e.g. partitions are
+// changed, it's unloaded from that one first
+func (m *partitionedManager) LoadConn(conn ConnConfig) error {
+	manager := m.managerOf(conn)
+
+	m.mux.Lock()
+	owner, ok := m.owners[conn.Name]
+	m.mux.Unlock()
+	if ok && owner != manager {
+		if err := owner.UnloadConn(conn.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := manager.LoadConn(conn); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	m.owners[conn.Name] = manager
+	m.mux.Unlock()
+
+	return nil
+}
+
+func (m *partitionedManager) ReloadConn(conn ConnConfig) error {
+	return m.managerOf(conn).ReloadConn(conn)
+}
+
+func (m *partitionedManager) InitiateConn(name string) error {
+	owner, err := m.ownerOf(name)
+	if err != nil {
+		return err
+	}
+	return owner.InitiateConn(name)
+}
+
+func (m *partitionedManager) UnloadConn(name string) error {
+	owner, err := m.ownerOf(name)
+	if err != nil {
+		return err
+	}
+
+	if err = owner.UnloadConn(name); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	delete(m.owners, name)
+	m.mux.Unlock()
+
+	return nil
+}
+
+// IsActive returns true only if all managers are active
+func (m *partitionedManager) IsActive() (bool, error) {
+	for _, manager := range m.all() {
+		active, err := manager.IsActive()
+		if err != nil || !active {
+			return active, err
+		}
+	}
+	return true, nil
+}
+
+func (m *partitionedManager) IsConnEstablished(name string) (bool, error) {
+	owner, err := m.ownerOf(name)
+	if err != nil {
+		return false, err
+	}
+	return owner.IsConnEstablished(name)
+}
+
+func (m *partitionedManager) LoadCRL(crlPEM []byte) error {
+	var errs []error
+	for _, manager := range m.all() {
+		if err := manager.LoadCRL(crlPEM); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (m *partitionedManager) LoadCredentials(keyPEM, caCertsPEM []byte) error {
+	var errs []error
+	for _, manager := range m.all() {
+		if err := manager.LoadCredentials(keyPEM, caCertsPEM); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// WatchEvents watches events of all managers, it returns when ctx is done or watching of any manager fails
+func (m *partitionedManager) WatchEvents(ctx context.Context, handler func(Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	all := m.all()
+	errCh := make(chan error, len(all))
+	for _, manager := range all {
+		go func(manager Manager) {
+			errCh <- manager.WatchEvents(ctx, handler)
+		}(manager)
+	}
+
+	// the first one which returns stops others
+	err := <-errCh
+	cancel()
+	for i := 1; i < len(all); i++ {
+		<-errCh
+	}
+
+	return err
+}
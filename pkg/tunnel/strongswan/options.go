@@ -40,3 +40,21 @@ func InterfaceID(id *uint) option {
 		m.interfaceID = id
 	}
 }
+
+// SubnetsPerChildSA splits traffic selectors of a connection into multiple child SAs,
+// each child SA has at most n subnets in its local or remote traffic selectors,
+// 0 means no splitting
+func SubnetsPerChildSA(n int) option {
+	return func(m *StrongSwanManager) {
+		m.subnetsPerChildSA = n
+	}
+}
+
+// Proposals restricts algorithms of IKE SAs and child SAs, e.g. aes256gcm16-prfsha384-ecp384,
+// empty proposals mean the defaults of strongswan
+func Proposals(ike, esp []string) option {
+	return func(m *StrongSwanManager) {
+		m.ikeProposals = ike
+		m.espProposals = esp
+	}
+}
@@ -15,8 +15,10 @@
 package strongswan
 
 import (
+	"context"
 	"encoding/pem"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
@@ -43,11 +45,22 @@ type StrongSwanManager struct {
 	// The value start initiates the connection actively.
 	startAction string
 	interfaceID *uint
+
+	// subnetsPerChildSA is the max number of subnets in traffic selectors of a child SA,
+	// if it's positive, traffic selectors are split into multiple child SAs, so a change
+	// of subnets only affects the child SAs which contain them.
+	subnetsPerChildSA int
+
+	// ikeProposals and espProposals restrict algorithms of IKE SAs and child SAs,
+	// empty means the defaults of strongswan are used
+	ikeProposals []string
+	espProposals []string
 }
 
 type connection struct {
 	LocalAddrs  []string               `vici:"local_addrs"`
 	RemoteAddrs []string               `vici:"remote_addrs,omitempty"`
+	Proposals   []string               `vici:"proposals,omitempty"`
 	LocalAuth   authConf               `vici:"local"`
 	RemoteAuth  authConf               `vici:"remote"`
 	Children    map[string]childSAConf `vici:"children"`
@@ -149,13 +162,12 @@ func (m StrongSwanManager) InitiateConn(name string) error {
 		return err
 	}
 
-	childNames := []string{
-		fmt.Sprintf("%s-p2p", name),
-		fmt.Sprintf("%s-p2n", name),
-		fmt.Sprintf("%s-n2p", name),
+	conn, err := m.getConn(name)
+	if err != nil {
+		return err
 	}
 
-	for _, child := range childNames {
+	for child := range conn.Children {
 		if childSANames.Has(child) {
 			continue
 		}
@@ -196,15 +208,65 @@ func (m StrongSwanManager) IsActive() (bool, error) {
 	return active, err
 }
 
+func (m StrongSwanManager) IsConnEstablished(name string) (bool, error) {
+	childSANames, err := m.listSANames(name)
+	if err != nil {
+		return false, err
+	}
+
+	return childSANames.Len() > 0, nil
+}
+
 func (m StrongSwanManager) LoadConn(cnf tunnel.ConnConfig) error {
-	certs, err := m.getCerts(cnf.LocalCerts)
+	conn, err := m.newConnection(cnf)
+	if err != nil {
+		return err
+	}
+
+	loadedConn, err := m.getConn(cnf.Name)
+	switch {
+	case err == nil:
+		if areConnectionsIdentical(conn, loadedConn) {
+			return nil
+		}
+
+		if m.subnetsPerChildSA > 0 && areAddressesIdentical(conn, loadedConn) {
+			return m.updateConn(cnf.Name, conn, loadedConn)
+		}
+
+		// we call UnloadConn to remove old Connection in strongswan, but if it failed, we ignore it
+		// because the failure won't cause trouble for loadConn
+		_ = m.UnloadConn(cnf.Name)
+
+		return m.loadConn(cnf.Name, conn)
+	case err == errConnectionNotFound:
+		return m.loadConn(cnf.Name, conn)
+	default:
+		return err
+	}
+}
+
+// ReloadConn loads the connection again even if it's not changed, established SAs are kept,
+// and local certificates are read again, so new IKE SAs are authenticated by the new ones
+func (m StrongSwanManager) ReloadConn(cnf tunnel.ConnConfig) error {
+	conn, err := m.newConnection(cnf)
 	if err != nil {
 		return err
 	}
 
+	return m.loadConn(cnf.Name, conn)
+}
+
+func (m StrongSwanManager) newConnection(cnf tunnel.ConnConfig) (connection, error) {
+	certs, err := m.getCerts(cnf.LocalCerts)
+	if err != nil {
+		return connection{}, err
+	}
+
 	conn := connection{
 		LocalAddrs:  cnf.LocalAddress,
 		RemoteAddrs: cnf.RemoteAddress,
+		Proposals:   m.ikeProposals,
 		IF_ID_IN:    m.interfaceID,
 		IF_ID_OUT:   m.interfaceID,
 		LocalAuth: authConf{
@@ -216,41 +278,61 @@ func (m StrongSwanManager) LoadConn(cnf tunnel.ConnConfig) error {
 			ID:         cnf.RemoteID,
 			AuthMethod: "pubkey",
 		},
-		Children: map[string]childSAConf{
-			fmt.Sprintf("%s-p2p", cnf.Name): {
-				LocalTS:     cnf.LocalSubnets,
-				RemoteTS:    cnf.RemoteSubnets,
-				StartAction: m.startAction,
-			},
-			fmt.Sprintf("%s-n2p", cnf.Name): {
-				LocalTS:     cnf.LocalNodeSubnets,
-				RemoteTS:    cnf.RemoteSubnets,
-				StartAction: m.startAction,
-			},
-			fmt.Sprintf("%s-p2n", cnf.Name): {
-				LocalTS:     cnf.LocalSubnets,
-				RemoteTS:    cnf.RemoteNodeSubnets,
-				StartAction: m.startAction,
-			},
-		},
+		Children: make(map[string]childSAConf),
 	}
+	m.addChildren(conn.Children, fmt.Sprintf("%s-p2p", cnf.Name), cnf.LocalSubnets, cnf.RemoteSubnets)
+	m.addChildren(conn.Children, fmt.Sprintf("%s-n2p", cnf.Name), cnf.LocalNodeSubnets, cnf.RemoteSubnets)
+	m.addChildren(conn.Children, fmt.Sprintf("%s-p2n", cnf.Name), cnf.LocalSubnets, cnf.RemoteNodeSubnets)
 
-	loadedConn, err := m.getConn(cnf.Name)
-	switch {
-	case err == nil:
-		if areConnectionsIdentical(conn, loadedConn) {
-			return nil
+	return conn, nil
+}
+
+// addChildren adds child SAs for localTS and remoteTS to children. If subnetsPerChildSA is
+// positive, traffic selectors are split into chunks and a child SA is made for each pair of
+// local chunk and remote chunk, the name of child SA is derived from its traffic selectors,
+// so child SAs whose subnets are not changed keep their names.
+func (m StrongSwanManager) addChildren(children map[string]childSAConf, prefix string, localTS, remoteTS []string) {
+	if m.subnetsPerChildSA <= 0 {
+		children[prefix] = childSAConf{
+			LocalTS:      localTS,
+			RemoteTS:     remoteTS,
+			StartAction:  m.startAction,
+			ESPProposals: m.espProposals,
 		}
-		// we call UnloadConn to remove old Connection in strongswan, but if it failed, we ignore it
-		// because the failure won't cause trouble for loadConn
-		_ = m.UnloadConn(cnf.Name)
+		return
+	}
 
-		return m.loadConn(cnf.Name, conn)
-	case err == errConnectionNotFound:
-		return m.loadConn(cnf.Name, conn)
-	default:
+	for _, local := range chunkSubnets(localTS, m.subnetsPerChildSA) {
+		for _, remote := range chunkSubnets(remoteTS, m.subnetsPerChildSA) {
+			name := fmt.Sprintf("%s-%s", prefix, hashTrafficSelectors(local, remote))
+			children[name] = childSAConf{
+				LocalTS:      local,
+				RemoteTS:     remote,
+				StartAction:  m.startAction,
+				ESPProposals: m.espProposals,
+			}
+		}
+	}
+}
+
+// updateConn replaces the loaded connection with conn without terminating the IKE SA,
+// only child SAs which no longer exist in conn are terminated
+func (m StrongSwanManager) updateConn(name string, conn connection, loadedConn loadedConnection) error {
+	if err := m.loadConn(name, conn); err != nil {
 		return err
 	}
+
+	for child := range loadedConn.Children {
+		if _, ok := conn.Children[child]; ok {
+			continue
+		}
+
+		if err := m.terminateChildSA(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (m StrongSwanManager) loadConn(name string, conn connection) error {
@@ -317,6 +399,20 @@ func (m StrongSwanManager) terminateSA(name string) error {
 	})
 }
 
+func (m StrongSwanManager) terminateChildSA(name string) error {
+	return m.do(func(session *vici.Session) error {
+		msg := vici.NewMessage()
+		_ = msg.Set("child", name)
+
+		_, err := session.CommandRequest("terminate", msg)
+		// terminate fails if there is no such child SA, which is fine here
+		if err != nil && strings.Contains(err.Error(), "no matching SAs") {
+			return nil
+		}
+		return err
+	})
+}
+
 func (m StrongSwanManager) UnloadConn(name string) error {
 	err := m.do(func(session *vici.Session) error {
 		msg := vici.NewMessage()
@@ -332,6 +428,95 @@ func (m StrongSwanManager) UnloadConn(name string) error {
 	return m.terminateSA(name)
 }
 
+func (m StrongSwanManager) LoadCRL(crlPEM []byte) error {
+	return m.do(func(session *vici.Session) error {
+		for {
+			var block *pem.Block
+			block, crlPEM = pem.Decode(crlPEM)
+			if block == nil {
+				return nil
+			}
+
+			msg := vici.NewMessage()
+			_ = msg.Set("type", "X509_CRL")
+			_ = msg.Set("flag", "NONE")
+			_ = msg.Set("data", string(pem.EncodeToMemory(block)))
+
+			if _, err := session.CommandRequest("load-cert", msg); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (m StrongSwanManager) LoadCredentials(keyPEM, caCertsPEM []byte) error {
+	return m.do(func(session *vici.Session) error {
+		msg := vici.NewMessage()
+		_ = msg.Set("type", "any")
+		_ = msg.Set("data", string(keyPEM))
+
+		if _, err := session.CommandRequest("load-key", msg); err != nil {
+			return err
+		}
+
+		for {
+			var block *pem.Block
+			block, caCertsPEM = pem.Decode(caCertsPEM)
+			if block == nil {
+				return nil
+			}
+
+			msg := vici.NewMessage()
+			_ = msg.Set("type", "X509")
+			_ = msg.Set("flag", "CA")
+			_ = msg.Set("data", string(pem.EncodeToMemory(block)))
+
+			if _, err := session.CommandRequest("load-cert", msg); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (m StrongSwanManager) WatchEvents(ctx context.Context, handler func(tunnel.Event)) error {
+	session, err := vici.NewSession(vici.WithSocketPath(m.socketPath))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err = session.Subscribe("ike-updown", "child-updown", "ike-rekey", "child-rekey"); err != nil {
+		return err
+	}
+
+	for {
+		event, err := session.NextEvent(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// the message has a section named after the IKE SA, which is the name of connection,
+		// and key "up" which is "yes" when SA goes up. Rekey events have no key "up"
+		eventType := tunnel.EventDown
+		switch {
+		case event.Name == "ike-rekey" || event.Name == "child-rekey":
+			eventType = tunnel.EventRekey
+		case event.Message.Get("up") == "yes":
+			eventType = tunnel.EventUp
+		}
+
+		for _, key := range event.Message.Keys() {
+			if key == "up" {
+				continue
+			}
+			handler(tunnel.Event{Name: key, Type: eventType, Time: event.Timestamp})
+		}
+	}
+}
+
 func (m StrongSwanManager) do(fn func(session *vici.Session) error) error {
 	session, err := vici.NewSession(vici.WithSocketPath(m.socketPath))
 	if err != nil {
@@ -375,11 +560,7 @@ func (m StrongSwanManager) getCert(filename string) (string, error) {
 // we take connection as identical to a loadedConnection if their LocalAddrs and RemoteAddrs are the same
 //  and there children's LocalTS and RemoteTS are the same
 func areConnectionsIdentical(c1 connection, c2 loadedConnection) bool {
-	if !reflect.DeepEqual(c1.LocalAddrs, c2.LocalAddrs) {
-		return false
-	}
-
-	if !reflect.DeepEqual(c1.RemoteAddrs, c2.RemoteAddrs) {
+	if !areAddressesIdentical(c1, c2) {
 		return false
 	}
 
@@ -397,7 +578,7 @@ func areConnectionsIdentical(c1 connection, c2 loadedConnection) bool {
 			return false
 		}
 
-		if !areSubnetIdentical(sc1.RemoteTS, sc1.RemoteTS) {
+		if !areSubnetIdentical(sc1.RemoteTS, sc2.RemoteTS) {
 			return false
 		}
 	}
@@ -405,6 +586,11 @@ func areConnectionsIdentical(c1 connection, c2 loadedConnection) bool {
 	return true
 }
 
+func areAddressesIdentical(c1 connection, c2 loadedConnection) bool {
+	return reflect.DeepEqual(c1.LocalAddrs, c2.LocalAddrs) &&
+		reflect.DeepEqual(c1.RemoteAddrs, c2.RemoteAddrs)
+}
+
 func areSubnetIdentical(cidrs1, cidrs2 []string) bool {
 	if len(cidrs1) != len(cidrs2) {
 		return false
@@ -434,3 +620,66 @@ func normalizeCIDR(value string) string {
 
 	return fmt.Sprintf("%s/%d", value, maskLen)
 }
+
+// chunkSubnets splits subnets into chunks which have at most size subnets, empty subnets
+// still make one chunk to keep the child SA. Subnets are put into buckets by their hashes
+// instead of their positions, so adding or removing a subnet only changes the chunk containing
+// it. The number of buckets is a power of two, which is doubled until no bucket has more than
+// size subnets, so chunks are cut again only when a bucket overflows
+func chunkSubnets(subnets []string, size int) [][]string {
+	subnets = sets.NewString(subnets...).List()
+	if len(subnets) <= size || size <= 0 {
+		return [][]string{subnets}
+	}
+
+	if size == 1 {
+		chunks := make([][]string, 0, len(subnets))
+		for _, subnet := range subnets {
+			chunks = append(chunks, []string{subnet})
+		}
+		return chunks
+	}
+
+	for bits := 1; ; bits++ {
+		chunks, fit := bucketSubnets(subnets, bits, size)
+		// give up if hashes of some subnets are too close to be separated
+		if fit || bits == maxSubnetBucketBits {
+			return chunks
+		}
+	}
+}
+
+// maxSubnetBucketBits limits the number of buckets of chunkSubnets to 1<<maxSubnetBucketBits
+const maxSubnetBucketBits = 16
+
+// bucketSubnets puts sorted subnets into 1<<bits buckets by the high bits of their hashes and
+// drops empty buckets, it also tells whether each bucket has at most size subnets
+func bucketSubnets(subnets []string, bits, size int) ([][]string, bool) {
+	index := make(map[uint64]int)
+	var chunks [][]string
+	fit := true
+	for _, subnet := range subnets {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(normalizeCIDR(subnet)))
+		bucket := h.Sum64() >> (64 - bits)
+
+		i, ok := index[bucket]
+		if !ok {
+			i, index[bucket] = len(chunks), len(chunks)
+			chunks = append(chunks, nil)
+		}
+		chunks[i] = append(chunks[i], subnet)
+		fit = fit && len(chunks[i]) <= size
+	}
+
+	return chunks, fit
+}
+
+func hashTrafficSelectors(localTS, remoteTS []string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(localTS, ",")))
+	_, _ = h.Write([]byte("|"))
+	_, _ = h.Write([]byte(strings.Join(remoteTS, ",")))
+
+	return fmt.Sprintf("%08x", h.Sum32())
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strongswan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func newSubnets(n int) []string {
+	subnets := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		subnets = append(subnets, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+	return subnets
+}
+
+func childNames(m StrongSwanManager, localTS, remoteTS []string) sets.String {
+	children := make(map[string]childSAConf)
+	m.addChildren(children, "conn-p2p", localTS, remoteTS)
+
+	names := sets.NewString()
+	for name := range children {
+		names.Insert(name)
+	}
+	return names
+}
+
+var _ = Describe("chunkSubnets", func() {
+	It("should put all subnets in one chunk if size is not positive or not exceeded", func() {
+		Expect(chunkSubnets([]string{"10.0.1.0/24", "10.0.0.0/24"}, 0)).To(Equal([][]string{{"10.0.0.0/24", "10.0.1.0/24"}}))
+		Expect(chunkSubnets([]string{"10.0.0.0/24"}, 2)).To(Equal([][]string{{"10.0.0.0/24"}}))
+		Expect(chunkSubnets(nil, 2)).To(HaveLen(1))
+	})
+
+	It("should make a chunk for each subnet if size is 1", func() {
+		Expect(chunkSubnets([]string{"10.0.1.0/24", "10.0.0.0/24"}, 1)).To(ConsistOf(
+			[]string{"10.0.0.0/24"},
+			[]string{"10.0.1.0/24"},
+		))
+	})
+
+	It("should split subnets into chunks of at most size subnets", func() {
+		subnets := newSubnets(200)
+
+		chunks := chunkSubnets(subnets, 16)
+		Expect(len(chunks)).To(BeNumerically(">=", 200/16))
+
+		all := sets.NewString()
+		for _, chunk := range chunks {
+			Expect(len(chunk)).To(BeNumerically("<=", 16))
+			all.Insert(chunk...)
+		}
+		Expect(all.List()).To(ConsistOf(subnets))
+	})
+
+	It("should make the same chunks no matter the order of subnets", func() {
+		subnets := newSubnets(100)
+		reversed := make([]string, 0, len(subnets))
+		for i := len(subnets) - 1; i >= 0; i-- {
+			reversed = append(reversed, subnets[i])
+		}
+
+		Expect(chunkSubnets(reversed, 8)).To(ConsistOf(chunkSubnets(subnets, 8)))
+	})
+
+	It("should only change the chunk containing the subnet which is added or removed", func() {
+		subnets := newSubnets(100)
+		before := chunkSubnets(subnets, 16)
+
+		for _, changed := range [][]string{
+			append(newSubnets(100), "10.1.0.0/24"),
+			append(newSubnets(50), newSubnets(100)[51:]...),
+		} {
+			after := chunkSubnets(changed, 16)
+			Expect(after).To(HaveLen(len(before)))
+
+			var kept int
+			for _, chunk := range after {
+				for _, old := range before {
+					if sets.NewString(old...).Equal(sets.NewString(chunk...)) {
+						kept++
+					}
+				}
+			}
+			Expect(kept).To(Equal(len(before) - 1))
+		}
+	})
+})
+
+var _ = Describe("addChildren", func() {
+	It("should make one child SA with all subnets if subnets are not split", func() {
+		m := StrongSwanManager{startAction: "start"}
+
+		children := make(map[string]childSAConf)
+		m.addChildren(children, "conn-p2p", []string{"10.0.0.0/24"}, []string{"10.1.0.0/24", "10.1.1.0/24"})
+		Expect(children).To(Equal(map[string]childSAConf{
+			"conn-p2p": {
+				LocalTS:     []string{"10.0.0.0/24"},
+				RemoteTS:    []string{"10.1.0.0/24", "10.1.1.0/24"},
+				StartAction: "start",
+			},
+		}))
+	})
+
+	It("should make a child SA for each pair of local chunk and remote chunk", func() {
+		m := StrongSwanManager{subnetsPerChildSA: 16}
+		localTS, remoteTS := newSubnets(20), newSubnets(100)
+
+		children := make(map[string]childSAConf)
+		m.addChildren(children, "conn-p2p", localTS, remoteTS)
+		Expect(children).To(HaveLen(len(chunkSubnets(localTS, 16)) * len(chunkSubnets(remoteTS, 16))))
+		for name, child := range children {
+			Expect(name).To(HavePrefix("conn-p2p-"))
+			Expect(len(child.LocalTS)).To(BeNumerically("<=", 16))
+			Expect(len(child.RemoteTS)).To(BeNumerically("<=", 16))
+		}
+	})
+
+	It("should keep names of child SAs whose subnets are not changed", func() {
+		m := StrongSwanManager{subnetsPerChildSA: 16}
+		localTS, remoteTS := newSubnets(20), newSubnets(100)
+		localChunks := len(chunkSubnets(localTS, 16))
+
+		before := childNames(m, localTS, remoteTS)
+		after := childNames(m, localTS, append(newSubnets(100), "10.1.0.0/24"))
+
+		// only the child SAs of the remote chunk which the new subnet is put into are changed
+		Expect(before.Difference(after).Len()).To(Equal(localChunks))
+		Expect(after.Difference(before).Len()).To(Equal(localChunks))
+	})
+})
+
+var _ = Describe("updateConn", func() {
+	var (
+		dir    string
+		server *fakeVICIServer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "vici")
+		Expect(err).NotTo(HaveOccurred())
+
+		server, err = newFakeVICIServer(filepath.Join(dir, "charon.vici"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should load connection and terminate only child SAs which are removed", func() {
+		m := StrongSwanManager{socketPath: server.path, subnetsPerChildSA: 16}
+		localTS := newSubnets(20)
+
+		loaded := loadedConnection{Children: make(map[string]loadedChildSAConf)}
+		for name := range childNames(m, localTS, newSubnets(100)) {
+			loaded.Children[name] = loadedChildSAConf{}
+		}
+
+		conn := connection{Children: make(map[string]childSAConf)}
+		m.addChildren(conn.Children, "conn-p2p", localTS, append(newSubnets(100), "10.1.0.0/24"))
+
+		Expect(m.updateConn("conn", conn, loaded)).To(Succeed())
+
+		var removed []string
+		for name := range loaded.Children {
+			if _, ok := conn.Children[name]; !ok {
+				removed = append(removed, "terminate "+name)
+			}
+		}
+		Expect(removed).To(HaveLen(len(chunkSubnets(localTS, 16))))
+
+		commands := server.Commands()
+		Expect(commands[0]).To(Equal("load-conn conn"))
+		Expect(commands[1:]).To(ConsistOf(removed))
+	})
+})
+
+// fakeVICIServer answers every command request successfully and records
+// the command with the name of its connection or child SA
+type fakeVICIServer struct {
+	path     string
+	listener net.Listener
+
+	mux      sync.Mutex
+	commands []string
+}
+
+func newFakeVICIServer(path string) (*fakeVICIServer, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &fakeVICIServer{path: path, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server, nil
+}
+
+func (s *fakeVICIServer) Close() {
+	_ = s.listener.Close()
+}
+
+func (s *fakeVICIServer) Commands() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return append([]string{}, s.commands...)
+}
+
+func (s *fakeVICIServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// packet types and message element types of VICI protocol
+	const (
+		cmdRequest   = 0
+		cmdResponse  = 1
+		sectionStart = 1
+		keyValue     = 3
+	)
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		if packet[0] != cmdRequest {
+			continue
+		}
+
+		command, msg := string(packet[2:2+packet[1]]), packet[2+packet[1]:]
+		// the first element names the connection loaded or the child SA terminated
+		if len(msg) > 1 && (msg[0] == sectionStart || msg[0] == keyValue) {
+			name := string(msg[2 : 2+msg[1]])
+			if msg[0] == keyValue {
+				value := msg[2+msg[1]:]
+				name = string(value[2 : 2+binary.BigEndian.Uint16(value)])
+			}
+			command = command + " " + name
+		}
+
+		s.mux.Lock()
+		s.commands = append(s.commands, command)
+		s.mux.Unlock()
+
+		// an empty message means the command succeeds
+		if _, err := conn.Write([]byte{0, 0, 0, 1, cmdResponse}); err != nil {
+			return
+		}
+	}
+}
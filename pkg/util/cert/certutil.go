@@ -15,6 +15,10 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -26,7 +30,9 @@ import (
 	"math"
 	"math/big"
 	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	certutil "k8s.io/client-go/util/cert"
@@ -37,6 +43,17 @@ const (
 	DefaultCountry      = "CN"
 	DefaultOrganization = "fabedge.io"
 	DefaultCAName       = "Fabedge CA"
+
+	CRLBlockType = "X509 CRL"
+)
+
+// KeyType is the algorithm of private keys of CA and certificates
+type KeyType string
+
+const (
+	KeyTypeRSA     KeyType = "rsa"
+	KeyTypeECDSA   KeyType = "ecdsa"
+	KeyTypeEd25519 KeyType = "ed25519"
 )
 
 var (
@@ -52,9 +69,15 @@ type Config struct {
 
 	DNSNames []string
 	IPs      []net.IP
+	// URIs are put into subject alternative names, e.g. SPIFFE IDs
+	URIs []*url.URL
 
 	ValidityPeriod time.Duration
 	IsCA           bool
+	// KeyType is the algorithm of the private key to generate, RSA is used if it's empty
+	KeyType KeyType
+	// KeySize is the size of the private key to generate, see NewPrivateKey
+	KeySize int
 }
 
 type Request struct {
@@ -62,11 +85,204 @@ type Request struct {
 	Organization []string
 	DNSNames     []string
 	IPs          []net.IP
+	URIs         []*url.URL
+	// KeyType is the algorithm of the private key to generate, RSA is used if it's empty
+	KeyType KeyType
+	// KeySize is the size of the private key to generate, see NewPrivateKey
+	KeySize int
+	// SignatureAlgorithm is the algorithm to sign the request, it's chosen by x509 package if it's unknown
+	SignatureAlgorithm x509.SignatureAlgorithm
+}
+
+// ParseKeyType parses name of key type, an empty name means RSA
+func ParseKeyType(name string) (KeyType, error) {
+	switch keyType := KeyType(name); keyType {
+	case "":
+		return KeyTypeRSA, nil
+	case KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519:
+		return keyType, nil
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", name)
+	}
+}
+
+// signatureAlgorithms are algorithms which can be used to sign certificate requests
+var signatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.SHA256WithRSA,
+	x509.SHA384WithRSA,
+	x509.SHA512WithRSA,
+	x509.SHA256WithRSAPSS,
+	x509.SHA384WithRSAPSS,
+	x509.SHA512WithRSAPSS,
+	x509.ECDSAWithSHA256,
+	x509.ECDSAWithSHA384,
+	x509.ECDSAWithSHA512,
+	x509.PureEd25519,
+}
+
+// ValidateKeySize checks if size can be used for keys of keyType. Sizes of RSA keys are bits
+// which are at least 2048, sizes of ECDSA keys are bits of curves: 256, 384 or 521, and Ed25519
+// keys have no size. 0 is always valid and means the default size
+func ValidateKeySize(keyType KeyType, size int) error {
+	if size == 0 {
+		return nil
+	}
+
+	switch keyType {
+	case "", KeyTypeRSA:
+		if size < 2048 {
+			return fmt.Errorf("size of RSA keys must be at least 2048: %d", size)
+		}
+	case KeyTypeECDSA:
+		if _, err := getCurve(size); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("size of %s keys can't be specified", keyType)
+	}
+
+	return nil
+}
+
+// ParseSignatureAlgorithm parses name of signature algorithm, e.g. SHA384-RSA, ECDSA-SHA384
+// and Ed25519, names are case insensitive. An empty name means the algorithm is chosen by
+// x509 package according to the key
+func ParseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	if name == "" {
+		return x509.UnknownSignatureAlgorithm, nil
+	}
+
+	for _, algorithm := range signatureAlgorithms {
+		if strings.EqualFold(algorithm.String(), name) {
+			return algorithm, nil
+		}
+	}
+
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm: %s", name)
+}
+
+// ValidateSignatureAlgorithm checks if keys of keyType can sign with algorithm
+func ValidateSignatureAlgorithm(keyType KeyType, algorithm x509.SignatureAlgorithm) error {
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return nil
+	}
+
+	var publicKeyAlgorithm x509.PublicKeyAlgorithm
+	switch keyType {
+	case "", KeyTypeRSA:
+		publicKeyAlgorithm = x509.RSA
+	case KeyTypeECDSA:
+		publicKeyAlgorithm = x509.ECDSA
+	case KeyTypeEd25519:
+		publicKeyAlgorithm = x509.Ed25519
+	default:
+		return fmt.Errorf("unsupported key type: %s", keyType)
+	}
+
+	if !isSignatureAlgorithmOf(algorithm, publicKeyAlgorithm) {
+		return fmt.Errorf("%s keys can't sign with %s", keyType, algorithm)
+	}
+
+	return nil
+}
+
+func isSignatureAlgorithmOf(algorithm x509.SignatureAlgorithm, publicKeyAlgorithm x509.PublicKeyAlgorithm) bool {
+	switch algorithm {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return publicKeyAlgorithm == x509.RSA
+	case x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return publicKeyAlgorithm == x509.ECDSA
+	case x509.PureEd25519:
+		return publicKeyAlgorithm == x509.Ed25519
+	default:
+		return false
+	}
 }
 
-// NewSelfSignedCA create a CA cert/key pair
+func getCurve(size int) (elliptic.Curve, error) {
+	switch size {
+	case 0, 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve size of ECDSA keys: %d, only 256, 384 and 521 are supported", size)
+	}
+}
+
+// NewPrivateKey generates a private key of keyType and size, size is bits of RSA keys
+// or bits of the curve of ECDSA keys, it's ignored by Ed25519 keys. If size is 0,
+// RSA keys have 2048 bits and ECDSA keys use curve P-256
+func NewPrivateKey(keyType KeyType, size int) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		if size == 0 {
+			size = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case KeyTypeECDSA:
+		curve, err := getCurve(size)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// MarshalPrivateKey converts a private key to DER form. RSA keys are in PKCS #1 form,
+// ECDSA keys are in SEC 1 form and Ed25519 keys are in PKCS #8 form
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(k), nil
+	case *ecdsa.PrivateKey:
+		return x509.MarshalECPrivateKey(k)
+	case ed25519.PrivateKey:
+		return x509.MarshalPKCS8PrivateKey(k)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+}
+
+// ParsePrivateKey parses a private key in DER form which is made by MarshalPrivateKey
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key in PKCS #1, SEC 1 or PKCS #8 form")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+
+	return signer, nil
+}
+
+// NewSelfSignedCA create a CA cert/key pair, RSA keys of CA have 4096 bits if KeySize of cfg is 0
 func NewSelfSignedCA(cfg Config) ([]byte, []byte, error) {
-	caKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	keySize := cfg.KeySize
+	if keySize == 0 && (cfg.KeyType == "" || cfg.KeyType == KeyTypeRSA) {
+		keySize = 4096
+	}
+
+	caKey, err := NewPrivateKey(cfg.KeyType, keySize)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -77,43 +293,67 @@ func NewSelfSignedCA(cfg Config) ([]byte, []byte, error) {
 	}
 
 	// creates a CA certificate
-	caDER, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	caDER, err := createCertificate(template, template, caKey.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caKeyDER, err := MarshalPrivateKey(caKey)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	caKeyDER := x509.MarshalPKCS1PrivateKey(caKey)
 	return caDER, caKeyDER, nil
 }
 
+// NewSelfSignedCAWithSigner creates a CA cert signed by caKey, which can be an external signer
+func NewSelfSignedCAWithSigner(cfg Config, caKey crypto.Signer) ([]byte, error) {
+	template, err := buildCertTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return createCertificate(template, template, caKey.Public(), caKey)
+}
+
+// IsKeyOfCert checks if key is the private key of cert
+func IsKeyOfCert(key crypto.Signer, cert *x509.Certificate) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
 // NewCertFromCA2 creates certificate and key from specified CA cert/key pair
 func NewCertFromCA2(ca, caKey []byte, cfg Config) ([]byte, []byte, error) {
 	caCert, err := x509.ParseCertificate(ca)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to parse a caCert from the given ASN.1 DER data, err: %v", err)
 	}
-	caKeyRSA, err := x509.ParsePKCS1PrivateKey(caKey)
+	caSigner, err := ParsePrivateKey(caKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parses an RSA private key in PKCS #1, ASN.1 DER form, err: %v", err)
+		return nil, nil, fmt.Errorf("failed to parse CA private key, err: %v", err)
 	}
 
-	return NewCertFromCA(caCert, caKeyRSA, cfg)
+	return NewCertFromCA(caCert, caSigner, cfg)
 }
 
 // NewCertFromCA creates certificate and key from specified CA cert/key pair
-func NewCertFromCA(caCert *x509.Certificate, caKey *rsa.PrivateKey, cfg Config) ([]byte, []byte, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+func NewCertFromCA(caCert *x509.Certificate, caKey crypto.Signer, cfg Config) ([]byte, []byte, error) {
+	privateKey, err := NewPrivateKey(cfg.KeyType, cfg.KeySize)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate a privateKey, err: %v", err)
 	}
-	privateKeyDER := x509.MarshalPKCS1PrivateKey(privateKey)
+
+	privateKeyDER, err := MarshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	certTemplate, err := buildCertTemplate(cfg)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, caCert, privateKey.Public(), caKey)
+	certDER, err := createCertificate(certTemplate, caCert, privateKey.Public(), caKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -122,27 +362,40 @@ func NewCertFromCA(caCert *x509.Certificate, caKey *rsa.PrivateKey, cfg Config)
 }
 
 func NewCertRequest(req Request) ([]byte, []byte, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, err := NewPrivateKey(req.KeyType, req.KeySize)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	csr, err := NewCertRequestFromKey(privateKey, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := MarshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keyDER, csr, nil
+}
+
+// NewCertRequestFromKey creates a certificate request of an existing key, it's used to renew
+// a certificate without changing its key, KeyType and KeySize of req are ignored
+func NewCertRequestFromKey(privateKey crypto.Signer, req Request) ([]byte, error) {
 	template := &x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName:   req.CommonName,
 			Country:      []string{DefaultCountry},
 			Organization: req.Organization,
 		},
-		IPAddresses: req.IPs,
-		DNSNames:    req.DNSNames,
-	}
-	csr, err := x509.CreateCertificateRequest(rand.Reader, template, privateKey)
-	if err != nil {
-		return nil, nil, err
+		IPAddresses:        req.IPs,
+		DNSNames:           req.DNSNames,
+		URIs:               req.URIs,
+		SignatureAlgorithm: req.SignatureAlgorithm,
 	}
 
-	keyDER := x509.MarshalPKCS1PrivateKey(privateKey)
-	return keyDER, csr, nil
+	return x509.CreateCertificateRequest(rand.Reader, template, privateKey)
 }
 
 func buildCertTemplate(cfg Config) (*x509.Certificate, error) {
@@ -168,6 +421,7 @@ func buildCertTemplate(cfg Config) (*x509.Certificate, error) {
 		},
 		DNSNames:     cfg.DNSNames,
 		IPAddresses:  cfg.IPs,
+		URIs:         cfg.URIs,
 		SerialNumber: serialNumber,
 		NotBefore:    time.Now().UTC(),
 		NotAfter:     time.Now().Add(cfg.ValidityPeriod),
@@ -176,13 +430,24 @@ func buildCertTemplate(cfg Config) (*x509.Certificate, error) {
 
 		BasicConstraintsValid: cfg.IsCA,
 		IsCA:                  cfg.IsCA,
-
-		PublicKeyAlgorithm: x509.RSA,
-		SignatureAlgorithm: x509.SHA384WithRSA,
 	}
 	return &template, nil
 }
 
+// createCertificate signs template with signer. Key encipherment only makes sense for RSA keys,
+// and signature algorithm is left to x509 package for other keys
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) ([]byte, error) {
+	if _, ok := pub.(*rsa.PublicKey); !ok {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+
+	if _, ok := signer.Public().(*rsa.PublicKey); ok {
+		template.SignatureAlgorithm = x509.SHA384WithRSA
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+}
+
 // VerifyCert verifies the certificate by CA certificate
 func VerifyCert(caDER, certDER []byte, usages []x509.ExtKeyUsage) error {
 	roots := x509.NewCertPool()
@@ -247,6 +512,28 @@ func DecodePEM(data []byte) ([]byte, error) {
 	return block.Bytes, nil
 }
 
+// DecodeCertsPEM decodes all certificates in data, e.g. a CA bundle
+func DecodeCertsPEM(data []byte) ([][]byte, error) {
+	var certs [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type == certutil.CertificateBlockType {
+			certs = append(certs, block.Bytes)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate is found in pem data")
+	}
+
+	return certs, nil
+}
+
 func SaveFile(content []byte, filename string) error {
 	return ioutil.WriteFile(filename, content, os.FileMode(0644))
 }
@@ -255,8 +542,56 @@ func EncodeCertPEM(certDER []byte) []byte {
 	return pem.EncodeToMemory(&pem.Block{Type: certutil.CertificateBlockType, Bytes: certDER})
 }
 
+// EncodePrivateKeyPEM encodes private key made by MarshalPrivateKey, the block type depends
+// on the form of the key
 func EncodePrivateKeyPEM(privateKeyDER []byte) []byte {
-	return pem.EncodeToMemory(&pem.Block{Type: keyutil.RSAPrivateKeyBlockType, Bytes: privateKeyDER})
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyBlockType(privateKeyDER), Bytes: privateKeyDER})
+}
+
+func privateKeyBlockType(der []byte) string {
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return keyutil.RSAPrivateKeyBlockType
+	}
+
+	if _, err := x509.ParseECPrivateKey(der); err == nil {
+		return keyutil.ECPrivateKeyBlockType
+	}
+
+	return keyutil.PrivateKeyBlockType
+}
+
+// NewCRL creates a CRL signed by CA which lists revoked certificates, it expires after validPeriod
+func NewCRL(caCert *x509.Certificate, caKey crypto.Signer, revoked []pkix.RevokedCertificate, validPeriod time.Duration) ([]byte, error) {
+	now := time.Now().UTC()
+	return caCert.CreateCRL(rand.Reader, caKey, revoked, now, now.Add(validPeriod))
+}
+
+// DecodeCRLsPEM decodes all CRLs in data
+func DecodeCRLsPEM(data []byte) ([]*pkix.CertificateList, error) {
+	var crls []*pkix.CertificateList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != CRLBlockType {
+			continue
+		}
+
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+
+	return crls, nil
+}
+
+func EncodeCRLPEM(crlDER []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: CRLBlockType, Bytes: crlDER})
 }
 
 func EncodeCertRequestPEM(crs []byte) []byte {
@@ -15,7 +15,10 @@
 package cert_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/x509"
+	"encoding/pem"
 	"net"
 	"time"
 
@@ -105,4 +108,103 @@ var _ = Describe("Certutil", func() {
 		Expect(cr.DNSNames).Should(Equal(req.DNSNames))
 		Expect(cr.PublicKey).Should(Equal(privateKey.Public()))
 	})
+
+	It("support ECDSA and Ed25519 keys", func() {
+		for _, keyType := range []certutil.KeyType{certutil.KeyTypeECDSA, certutil.KeyTypeEd25519} {
+			cfg := caCfg
+			cfg.KeyType = keyType
+			caDER, caKeyDER, err := certutil.NewSelfSignedCA(cfg)
+			Expect(err).Should(BeNil())
+
+			manager, err := certutil.NewManger(caDER, caKeyDER, 24*time.Hour)
+			Expect(err).Should(BeNil())
+
+			certDER, keyDER, err := manager.NewCertKey(certutil.Config{
+				CommonName:     "edge",
+				Usages:         certutil.ExtKeyUsagesServerAndClient,
+				ValidityPeriod: time.Hour,
+				KeyType:        keyType,
+			})
+			Expect(err).Should(BeNil())
+
+			privateKey, err := certutil.ParsePrivateKey(keyDER)
+			Expect(err).Should(BeNil())
+			switch keyType {
+			case certutil.KeyTypeECDSA:
+				Expect(privateKey).Should(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+			case certutil.KeyTypeEd25519:
+				Expect(privateKey).Should(BeAssignableToTypeOf(ed25519.PrivateKey{}))
+			}
+
+			cert, err := x509.ParseCertificate(certDER)
+			Expect(err).Should(BeNil())
+			Expect(cert.PublicKey).Should(Equal(privateKey.Public()))
+			Expect(cert.KeyUsage).Should(Equal(x509.KeyUsageDigitalSignature))
+			Expect(manager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+		}
+	})
+
+	It("should encode private key in PEM by its form", func() {
+		for keyType, blockType := range map[certutil.KeyType]string{
+			certutil.KeyTypeRSA:     "RSA PRIVATE KEY",
+			certutil.KeyTypeECDSA:   "EC PRIVATE KEY",
+			certutil.KeyTypeEd25519: "PRIVATE KEY",
+		} {
+			keyDER, _, err := certutil.NewCertRequest(certutil.Request{CommonName: "test", KeyType: keyType})
+			Expect(err).Should(BeNil())
+
+			block, _ := pem.Decode(certutil.EncodePrivateKeyPEM(keyDER))
+			Expect(block.Type).Should(Equal(blockType))
+		}
+	})
+
+	It("should reject unknown key types", func() {
+		keyType, err := certutil.ParseKeyType("")
+		Expect(err).Should(BeNil())
+		Expect(keyType).Should(Equal(certutil.KeyTypeRSA))
+
+		_, err = certutil.ParseKeyType("dsa")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("support key size and signature algorithm of certificate request", func() {
+		algorithm, err := certutil.ParseSignatureAlgorithm("ecdsa-sha384")
+		Expect(err).Should(BeNil())
+		Expect(algorithm).Should(Equal(x509.ECDSAWithSHA384))
+
+		keyDER, csr, err := certutil.NewCertRequest(certutil.Request{
+			CommonName:         "test",
+			KeyType:            certutil.KeyTypeECDSA,
+			KeySize:            384,
+			SignatureAlgorithm: algorithm,
+		})
+		Expect(err).Should(BeNil())
+
+		privateKey, err := certutil.ParsePrivateKey(keyDER)
+		Expect(err).Should(BeNil())
+		Expect(privateKey.(*ecdsa.PrivateKey).Curve.Params().BitSize).Should(Equal(384))
+
+		cr, err := x509.ParseCertificateRequest(csr)
+		Expect(err).Should(BeNil())
+		Expect(cr.SignatureAlgorithm).Should(Equal(x509.ECDSAWithSHA384))
+
+		keyDER, _, err = certutil.NewCertRequest(certutil.Request{CommonName: "test", KeySize: 3072})
+		Expect(err).Should(BeNil())
+		rsaKey, err := x509.ParsePKCS1PrivateKey(keyDER)
+		Expect(err).Should(BeNil())
+		Expect(rsaKey.N.BitLen()).Should(Equal(3072))
+	})
+
+	It("should reject invalid key sizes and signature algorithms", func() {
+		Expect(certutil.ValidateKeySize(certutil.KeyTypeRSA, 1024)).ShouldNot(Succeed())
+		Expect(certutil.ValidateKeySize(certutil.KeyTypeECDSA, 224)).ShouldNot(Succeed())
+		Expect(certutil.ValidateKeySize(certutil.KeyTypeEd25519, 256)).ShouldNot(Succeed())
+		Expect(certutil.ValidateKeySize(certutil.KeyTypeEd25519, 0)).Should(Succeed())
+
+		_, err := certutil.ParseSignatureAlgorithm("MD5-RSA")
+		Expect(err).ShouldNot(BeNil())
+
+		Expect(certutil.ValidateSignatureAlgorithm(certutil.KeyTypeRSA, x509.ECDSAWithSHA256)).ShouldNot(Succeed())
+		Expect(certutil.ValidateSignatureAlgorithm(certutil.KeyTypeRSA, x509.SHA384WithRSA)).Should(Succeed())
+	})
 })
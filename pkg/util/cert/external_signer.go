@@ -0,0 +1,125 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const externalSignerTimeout = 30 * time.Second
+
+// externalSigner is a signer whose private key is kept out of the process, e.g. in a cloud KMS
+// or a PKCS#11 HSM. It signs by running a command which talks to the key store:
+//
+//	<command> public-key
+//	  prints the public key in PEM, e.g. the output of "openssl pkey -pubout"
+//	<command> sign <hash>
+//	  reads the digest from stdin and prints the signature in binary, <hash> is one of
+//	  sha256, sha384, sha512 and none. It's none for Ed25519 keys, which sign the message
+//	  itself instead of its digest. RSA signatures are in PKCS #1 v1.5 form and ECDSA
+//	  signatures are in ASN.1 DER form
+type externalSigner struct {
+	command   []string
+	publicKey crypto.PublicKey
+}
+
+// NewExternalSigner creates a signer which signs by command, see externalSigner for
+// how command is called
+func NewExternalSigner(command []string) (crypto.Signer, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("signer command is empty")
+	}
+
+	s := &externalSigner{command: command}
+
+	out, err := s.run(nil, "public-key")
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(out)
+	if block == nil {
+		return nil, fmt.Errorf("no public key in PEM is printed by signer command")
+	}
+
+	s.publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key printed by signer command: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *externalSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *externalSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("RSA PSS is not supported by external signer")
+	}
+
+	var hash string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hash = "sha256"
+	case crypto.SHA384:
+		hash = "sha384"
+	case crypto.SHA512:
+		hash = "sha512"
+	case crypto.Hash(0):
+		hash = "none"
+	default:
+		return nil, fmt.Errorf("unsupported hash function: %s", opts.HashFunc())
+	}
+
+	signature, err := s.run(digest, "sign", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(signature) == 0 {
+		return nil, fmt.Errorf("no signature is printed by signer command")
+	}
+
+	return signature, nil
+}
+
+func (s *externalSigner) run(stdin []byte, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), externalSignerTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], append(s.command[1:], args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run signer command %q: %w, %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+const envSignerKeyFile = "FABEDGE_TEST_SIGNER_KEY_FILE"
+
+// TestExternalSignerHelper isn't a real test, it's run as the signer command by tests of external signer
+func TestExternalSignerHelper(t *testing.T) {
+	keyFile := os.Getenv(envSignerKeyFile)
+	if keyFile == "" {
+		return
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+
+	keyDER, err := certutil.ReadPEMFileAndDecode(keyFile)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	key, err := certutil.ParsePrivateKey(keyDER)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "public-key":
+		der, _ := x509.MarshalPKIXPublicKey(key.Public())
+		os.Stdout.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	case "sign":
+		hashes := map[string]crypto.Hash{"sha256": crypto.SHA256, "sha384": crypto.SHA384, "sha512": crypto.SHA512, "none": 0}
+		digest, _ := ioutil.ReadAll(os.Stdin)
+		signature, err := key.Sign(rand.Reader, digest, hashes[args[1]])
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Stdout.Write(signature)
+	default:
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+var _ = Describe("ExternalSigner", func() {
+	var (
+		command []string
+		dir     string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "signer")
+		Expect(err).Should(BeNil())
+
+		key, err := certutil.NewPrivateKey(certutil.KeyTypeECDSA, 0)
+		Expect(err).Should(BeNil())
+		keyDER, err := certutil.MarshalPrivateKey(key)
+		Expect(err).Should(BeNil())
+
+		keyFile := filepath.Join(dir, "ca.key")
+		Expect(ioutil.WriteFile(keyFile, certutil.EncodePrivateKeyPEM(keyDER), 0600)).Should(Succeed())
+
+		os.Setenv(envSignerKeyFile, keyFile)
+		command = []string{os.Args[0], "-test.run=TestExternalSignerHelper", "--"}
+	})
+
+	AfterEach(func() {
+		os.Unsetenv(envSignerKeyFile)
+		os.RemoveAll(dir)
+	})
+
+	It("should sign CA and certificates by external signer", func() {
+		caKey, err := certutil.NewExternalSigner(command)
+		Expect(err).Should(BeNil())
+
+		caDER, err := certutil.NewSelfSignedCAWithSigner(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: time.Hour,
+		}, caKey)
+		Expect(err).Should(BeNil())
+
+		manager, err := certutil.NewMangerWithSigner(caDER, caKey, time.Hour)
+		Expect(err).Should(BeNil())
+
+		certDER, _, err := manager.NewCertKey(certutil.Config{
+			CommonName:     "edge1",
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		})
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).Should(BeNil())
+		Expect(manager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
+	})
+
+	It("should reject CA cert which doesn't match the key of external signer", func() {
+		caKey, err := certutil.NewExternalSigner(command)
+		Expect(err).Should(BeNil())
+
+		caDER, _, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			IsCA:           true,
+			ValidityPeriod: time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		_, err = certutil.NewMangerWithSigner(caDER, caKey, time.Hour)
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should return error if signer command fails", func() {
+		_, err := certutil.NewExternalSigner([]string{"false"})
+		Expect(err).ShouldNot(BeNil())
+	})
+})
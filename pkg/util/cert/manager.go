@@ -15,8 +15,8 @@
 package cert
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"bytes"
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -29,34 +29,82 @@ type Manager interface {
 	SignCert(csr []byte) ([]byte, error)
 	VerifyCert(cert *x509.Certificate, usages []x509.ExtKeyUsage) error
 	VerifyCertInPEM(certPEM []byte, usages []x509.ExtKeyUsage) error
+	// GetCACert returns the CA which signs certificates
 	GetCACert() *x509.Certificate
+	// GetCACertPEM returns the CA which signs certificates in PEM, if it's an intermediate CA,
+	// its issuers follow it up to the root CA
 	GetCACertPEM() []byte
+	// GetCABundlePEM returns all trusted CAs in PEM, other trusted CAs come first and the
+	// signing CA comes last. During CA rotation, both the old and the new CA are trusted
+	GetCABundlePEM() []byte
+	// IsIssuedByCA checks if cert is issued by the signing CA instead of other trusted CAs
+	IsIssuedByCA(cert *x509.Certificate) bool
 }
 
 type manager struct {
 	caCertPEM   []byte
+	caBundlePEM []byte
 	caCert      *x509.Certificate
-	caKey       *rsa.PrivateKey
+	caKey       crypto.Signer
 	certPool    *x509.CertPool
 	validPeriod time.Duration
 }
 
-func NewManger(caDER, caKeyDER []byte, validPeriod time.Duration) (Manager, error) {
+// NewManger creates a manager which signs certificates by CA, certificates issued by
+// trustedCADERs are trusted too, which are used when CA is being rotated
+func NewManger(caDER, caKeyDER []byte, validPeriod time.Duration, trustedCADERs ...[]byte) (Manager, error) {
+	caKey, err := ParsePrivateKey(caKeyDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA private key, err: %v", err)
+	}
+
+	return NewMangerWithSigner(caDER, caKey, validPeriod, trustedCADERs...)
+}
+
+// NewMangerWithSigner is like NewManger, but certificates are signed by caKey, which can
+// be an external signer whose key is kept in KMS or HSM
+func NewMangerWithSigner(caDER []byte, caKey crypto.Signer, validPeriod time.Duration, trustedCADERs ...[]byte) (Manager, error) {
+	return NewMangerWithChain(caDER, caKey, nil, validPeriod, trustedCADERs...)
+}
+
+// NewMangerWithChain is like NewMangerWithSigner, but CA can be an intermediate CA, parentDERs are
+// its issuers from its own issuer up to the root CA, they are distributed together with CA
+func NewMangerWithChain(caDER []byte, caKey crypto.Signer, parentDERs [][]byte, validPeriod time.Duration, trustedCADERs ...[]byte) (Manager, error) {
 	caCert, err := x509.ParseCertificate(caDER)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse a caCert from the given ASN.1 DER data, err: %v", err)
 	}
 
-	caKey, err := x509.ParsePKCS1PrivateKey(caKeyDER)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parses an RSA private key in PKCS #1, ASN.1 DER form, err: %v", err)
+	if !IsKeyOfCert(caKey, caCert) {
+		return nil, fmt.Errorf("CA private key doesn't match CA cert")
 	}
 
-	pool := x509.NewCertPool()
-	pool.AddCert(caCert)
+	chainPEM := EncodeCertPEM(caDER)
+	child := caCert
+	for _, der := range parentDERs {
+		parent, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse issuer of CA cert, err: %v", err)
+		}
+
+		if err = child.CheckSignatureFrom(parent); err != nil {
+			return nil, fmt.Errorf("CA chain is broken, %s is not issued by %s: %w", child.Subject, parent.Subject, err)
+		}
+
+		chainPEM = append(chainPEM, EncodeCertPEM(der)...)
+		child = parent
+	}
+
+	// issuers of CA come right before CA in the bundle
+	trustedCADERs = append(append([][]byte{}, trustedCADERs...), parentDERs...)
+	pool, bundlePEM, err := newTrustedPool(caCert, caDER, trustedCADERs)
+	if err != nil {
+		return nil, err
+	}
 
 	return &manager{
-		caCertPEM:   EncodeCertPEM(caDER),
+		caCertPEM:   chainPEM,
+		caBundlePEM: bundlePEM,
 		caCert:      caCert,
 		caKey:       caKey,
 		certPool:    pool,
@@ -64,10 +112,75 @@ func NewManger(caDER, caKeyDER []byte, validPeriod time.Duration) (Manager, erro
 	}, nil
 }
 
+// WithValidPeriod returns a manager which signs certificates valid for validPeriod by the same CAs as m.
+// m is returned as it is if it's not made by NewManger, e.g. certificates of remote manager are signed by host cluster
+func WithValidPeriod(m Manager, validPeriod time.Duration) Manager {
+	mgr, ok := m.(*manager)
+	if !ok {
+		return m
+	}
+
+	newManager := *mgr
+	newManager.validPeriod = validPeriod
+	return &newManager
+}
+
+// newTrustedPool creates a pool of CA and trusted CAs, and the bundle of them, see CABundleOf
+func newTrustedPool(caCert *x509.Certificate, caDER []byte, trustedCADERs [][]byte) (*x509.CertPool, []byte, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	var bundlePEM []byte
+	for _, der := range CABundleOf(caDER, trustedCADERs) {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse trusted CA cert, err: %v", err)
+		}
+
+		pool.AddCert(cert)
+		bundlePEM = append(bundlePEM, EncodeCertPEM(der)...)
+	}
+
+	return pool, bundlePEM, nil
+}
+
+// CABundleOf returns CAs in the order of CA bundle: trusted CAs come first and CA comes last.
+// A trusted CA which is CA itself or appears more than once is only put into the bundle the last time
+func CABundleOf(caDER []byte, trustedCADERs [][]byte) [][]byte {
+	var bundle [][]byte
+	for i, der := range trustedCADERs {
+		if bytes.Equal(der, caDER) || containsDER(trustedCADERs[i+1:], der) {
+			continue
+		}
+
+		bundle = append(bundle, der)
+	}
+
+	return append(bundle, caDER)
+}
+
+func containsDER(ders [][]byte, der []byte) bool {
+	for _, d := range ders {
+		if bytes.Equal(d, der) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m manager) GetCACertPEM() []byte {
 	return m.caCertPEM
 }
 
+func (m manager) GetCABundlePEM() []byte {
+	return m.caBundlePEM
+}
+
+func (m manager) IsIssuedByCA(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(m.caCert) == nil
+}
+
 func (m manager) GetCACert() *x509.Certificate {
 	return m.caCert
 }
@@ -91,6 +204,7 @@ func (m manager) SignCert(csr []byte) ([]byte, error) {
 		Organization:   req.Subject.Organization,
 		DNSNames:       req.DNSNames,
 		IPs:            req.IPAddresses,
+		URIs:           req.URIs,
 		ValidityPeriod: m.validPeriod,
 		Usages:         ExtKeyUsagesServerAndClient,
 	})
@@ -98,7 +212,7 @@ func (m manager) SignCert(csr []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return x509.CreateCertificate(rand.Reader, template, m.caCert, req.PublicKey, m.caKey)
+	return createCertificate(template, m.caCert, req.PublicKey, m.caKey)
 }
 
 func (m manager) VerifyCert(cert *x509.Certificate, usages []x509.ExtKeyUsage) error {
@@ -12,10 +12,14 @@ import (
 )
 
 var _ = Describe("Manager", func() {
-	var manager certutil.Manager
+	var (
+		manager       certutil.Manager
+		caDER, keyDER []byte
+	)
 
 	BeforeEach(func() {
-		caDER, keyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+		var err error
+		caDER, keyDER, err = certutil.NewSelfSignedCA(certutil.Config{
 			CommonName:     certutil.DefaultCAName,
 			Organization:   []string{certutil.DefaultOrganization},
 			IsCA:           true,
@@ -100,4 +104,122 @@ var _ = Describe("Manager", func() {
 		Expect(manager.VerifyCert(cert, certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
 		Expect(manager.VerifyCertInPEM(certutil.EncodeCertPEM(certDER), certutil.ExtKeyUsagesServerAndClient)).Should(Succeed())
 	})
+
+	It("should be able to sign certificates with another valid period", func() {
+		privateKey, err := certutil.NewPrivateKey(certutil.KeyTypeECDSA, 0)
+		Expect(err).Should(BeNil())
+
+		csr, err := certutil.NewCertRequestFromKey(privateKey, certutil.Request{CommonName: "test"})
+		Expect(err).Should(BeNil())
+
+		certDER, err := certutil.WithValidPeriod(manager, time.Hour).SignCert(csr)
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cert.PublicKey).Should(Equal(privateKey.Public()))
+		Expect(cert.NotAfter.Sub(cert.NotBefore)).Should(BeNumerically("~", time.Hour, time.Second))
+
+		certDER, err = manager.SignCert(csr)
+		Expect(err).Should(BeNil())
+
+		cert, err = x509.ParseCertificate(certDER)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cert.NotAfter.Sub(cert.NotBefore)).Should(BeNumerically("~", 24*time.Hour, time.Second))
+	})
+
+	It("should trust certificates issued by other trusted CAs", func() {
+		newCADER, newKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     "new ca",
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: 24 * time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		newManager, err := certutil.NewManger(newCADER, newKeyDER, 24*time.Hour, caDER)
+		Expect(err).Should(BeNil())
+
+		cfg := certutil.Config{
+			CommonName:     "test",
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		}
+		oldCertDER, _, err := manager.NewCertKey(cfg)
+		Expect(err).Should(BeNil())
+		newCertDER, _, err := newManager.NewCertKey(cfg)
+		Expect(err).Should(BeNil())
+
+		oldCert, _ := x509.ParseCertificate(oldCertDER)
+		newCert, _ := x509.ParseCertificate(newCertDER)
+		Expect(newManager.VerifyCert(oldCert, cfg.Usages)).Should(Succeed())
+		Expect(newManager.VerifyCert(newCert, cfg.Usages)).Should(Succeed())
+		Expect(manager.VerifyCert(newCert, cfg.Usages)).ShouldNot(Succeed())
+
+		Expect(newManager.IsIssuedByCA(newCert)).Should(BeTrue())
+		Expect(newManager.IsIssuedByCA(oldCert)).Should(BeFalse())
+
+		bundle, err := certutil.DecodeCertsPEM(newManager.GetCABundlePEM())
+		Expect(err).Should(BeNil())
+		Expect(bundle).Should(Equal([][]byte{caDER, newCADER}))
+		Expect(manager.GetCABundlePEM()).Should(Equal(manager.GetCACertPEM()))
+	})
+
+	It("should sign certificates by intermediate CA", func() {
+		interDER, interKeyDER, err := manager.NewCertKey(certutil.Config{
+			CommonName:     "intermediate ca",
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: 24 * time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		interKey, err := certutil.ParsePrivateKey(interKeyDER)
+		Expect(err).Should(BeNil())
+
+		interManager, err := certutil.NewMangerWithChain(interDER, interKey, [][]byte{caDER}, 24*time.Hour)
+		Expect(err).Should(BeNil())
+
+		cfg := certutil.Config{
+			CommonName:     "test",
+			ValidityPeriod: time.Hour,
+			Usages:         certutil.ExtKeyUsagesServerAndClient,
+		}
+		certDER, _, err := interManager.NewCertKey(cfg)
+		Expect(err).Should(BeNil())
+
+		cert, _ := x509.ParseCertificate(certDER)
+		Expect(cert.CheckSignatureFrom(interManager.GetCACert())).Should(Succeed())
+		Expect(interManager.VerifyCert(cert, cfg.Usages)).Should(Succeed())
+
+		chain, err := certutil.DecodeCertsPEM(interManager.GetCACertPEM())
+		Expect(err).Should(BeNil())
+		Expect(chain).Should(Equal([][]byte{interDER, caDER}))
+
+		bundle, err := certutil.DecodeCertsPEM(interManager.GetCABundlePEM())
+		Expect(err).Should(BeNil())
+		Expect(bundle).Should(Equal([][]byte{caDER, interDER}))
+	})
+
+	It("should reject broken CA chain", func() {
+		otherCADER, _, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     "other ca",
+			IsCA:           true,
+			ValidityPeriod: 24 * time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		interDER, interKeyDER, err := manager.NewCertKey(certutil.Config{
+			CommonName:     "intermediate ca",
+			IsCA:           true,
+			ValidityPeriod: 24 * time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		interKey, err := certutil.ParsePrivateKey(interKeyDER)
+		Expect(err).Should(BeNil())
+
+		_, err = certutil.NewMangerWithChain(interDER, interKey, [][]byte{otherCADER}, 24*time.Hour)
+		Expect(err).ShouldNot(BeNil())
+	})
 })
@@ -13,11 +13,14 @@ type remoteManager struct {
 	caCert   *x509.Certificate
 	signCert SignCertFunc
 
-	caCertPEM []byte
-	certPool  *x509.CertPool
+	caCertPEM   []byte
+	caBundlePEM []byte
+	certPool    *x509.CertPool
 }
 
-func NewRemoteManager(caCertDER []byte, signCert SignCertFunc) (Manager, error) {
+// NewRemoteManager creates a manager which signs certificates by signCert, caCertDER is the CA used by signCert.
+// Certificates issued by trustedCADERs are trusted too, which are used when CA is being rotated
+func NewRemoteManager(caCertDER []byte, signCert SignCertFunc, trustedCADERs ...[]byte) (Manager, error) {
 	caCert, err := x509.ParseCertificate(caCertDER)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse a caCert. err: %v", err)
@@ -27,14 +30,17 @@ func NewRemoteManager(caCertDER []byte, signCert SignCertFunc) (Manager, error)
 		return nil, fmt.Errorf("a signCert function is required")
 	}
 
-	pool := x509.NewCertPool()
-	pool.AddCert(caCert)
+	pool, bundlePEM, err := newTrustedPool(caCert, caCertDER, trustedCADERs)
+	if err != nil {
+		return nil, err
+	}
 
 	return &remoteManager{
-		caCertPEM: EncodeCertPEM(caCertDER),
-		caCert:    caCert,
-		certPool:  pool,
-		signCert:  signCert,
+		caCertPEM:   EncodeCertPEM(caCertDER),
+		caBundlePEM: bundlePEM,
+		caCert:      caCert,
+		certPool:    pool,
+		signCert:    signCert,
 	}, nil
 }
 
@@ -42,6 +48,14 @@ func (m remoteManager) GetCACertPEM() []byte {
 	return m.caCertPEM
 }
 
+func (m remoteManager) GetCABundlePEM() []byte {
+	return m.caBundlePEM
+}
+
+func (m remoteManager) IsIssuedByCA(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(m.caCert) == nil
+}
+
 func (m remoteManager) GetCACert() *x509.Certificate {
 	return m.caCert
 }
@@ -52,6 +66,9 @@ func (m remoteManager) NewCertKey(cfg Config) ([]byte, []byte, error) {
 		Organization: cfg.Organization,
 		IPs:          cfg.IPs,
 		DNSNames:     cfg.DNSNames,
+		URIs:         cfg.URIs,
+		KeyType:      cfg.KeyType,
+		KeySize:      cfg.KeySize,
 	})
 
 	certDER, err := m.signCert(csr)
@@ -0,0 +1,132 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const SPIFFEScheme = "spiffe"
+
+var trustDomainReg = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// ValidateTrustDomain checks if name is a valid SPIFFE trust domain
+func ValidateTrustDomain(name string) error {
+	if !trustDomainReg.MatchString(name) {
+		return fmt.Errorf("invalid trust domain: %s, only lowercase letters, digits, dots, dashes and underscores are allowed", name)
+	}
+
+	return nil
+}
+
+// ParseSPIFFEID parses a SPIFFE ID, e.g. spiffe://example.org/fabedge/edge1
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != SPIFFEScheme {
+		return nil, fmt.Errorf("scheme of SPIFFE ID must be %s: %s", SPIFFEScheme, id)
+	}
+
+	if err = ValidateTrustDomain(u.Host); err != nil {
+		return nil, err
+	}
+
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("SPIFFE ID can't have user info, query or fragment: %s", id)
+	}
+
+	if u.Path == "" || u.Path == "/" || strings.HasSuffix(u.Path, "/") || strings.Contains(u.Path, "//") {
+		return nil, fmt.Errorf("invalid path of SPIFFE ID: %s", id)
+	}
+
+	return u, nil
+}
+
+// URIsOfID returns the URI to put into certificate if id is a SPIFFE ID, otherwise nil is returned
+func URIsOfID(id string) []*url.URL {
+	if !strings.HasPrefix(id, SPIFFEScheme+"://") {
+		return nil
+	}
+
+	u, err := ParseSPIFFEID(id)
+	if err != nil {
+		return nil
+	}
+
+	return []*url.URL{u}
+}
+
+// GetSPIFFEID returns the SPIFFE ID in subject alternative names of cert, empty string is
+// returned if it has no SPIFFE ID
+func GetSPIFFEID(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == SPIFFEScheme {
+			return u.String()
+		}
+	}
+
+	return ""
+}
+
+// IdentityOf returns the identity of the certificate made for an endpoint of name and id, it's
+// the SPIFFE ID if id is a SPIFFE ID, otherwise it's name which is the common name of the certificate
+func IdentityOf(name, id string) string {
+	if uris := URIsOfID(id); len(uris) > 0 {
+		return uris[0].String()
+	}
+
+	return name
+}
+
+// GetIdentity returns the SPIFFE ID of cert, or its common name if it has no SPIFFE ID
+func GetIdentity(cert *x509.Certificate) string {
+	if id := GetSPIFFEID(cert); id != "" {
+		return id
+	}
+
+	return cert.Subject.CommonName
+}
+
+// VerifySPIFFEIDInPEM checks if the certificate has the SPIFFE ID of id, a certificate
+// shouldn't have a SPIFFE ID if id is not a SPIFFE ID
+func VerifySPIFFEIDInPEM(certPEM []byte, id string) error {
+	certDER, err := DecodePEM(certPEM)
+	if err != nil {
+		return err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return err
+	}
+
+	var expected string
+	if uris := URIsOfID(id); len(uris) > 0 {
+		expected = uris[0].String()
+	}
+
+	if actual := GetSPIFFEID(cert); actual != expected {
+		return fmt.Errorf("SPIFFE ID of certificate is %q, but %q is expected", actual, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert_test
+
+import (
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+var _ = Describe("SPIFFE", func() {
+	It("should parse valid SPIFFE IDs only", func() {
+		u, err := certutil.ParseSPIFFEID("spiffe://example.org/fabedge/edge1")
+		Expect(err).Should(BeNil())
+		Expect(u.Host).Should(Equal("example.org"))
+		Expect(u.Path).Should(Equal("/fabedge/edge1"))
+
+		for _, id := range []string{
+			"https://example.org/fabedge/edge1",
+			"spiffe://Example.org/fabedge/edge1",
+			"spiffe://example.org",
+			"spiffe://example.org/",
+			"spiffe://example.org/fabedge/",
+			"spiffe://example.org/fabedge?edge1",
+			"spiffe://user@example.org/fabedge",
+		} {
+			_, err = certutil.ParseSPIFFEID(id)
+			Expect(err).ShouldNot(BeNil(), id)
+		}
+	})
+
+	It("should only make URIs of SPIFFE IDs", func() {
+		Expect(certutil.URIsOfID("C=CN, O=fabedge.io, CN=fabedge.edge1")).Should(BeNil())
+		Expect(certutil.URIsOfID("spiffe://example.org/fabedge/edge1")).Should(HaveLen(1))
+	})
+
+	It("should use SPIFFE ID as identity if id is a SPIFFE ID, otherwise common name", func() {
+		Expect(certutil.IdentityOf("fabedge.edge1", "C=CN, O=fabedge.io, CN=fabedge.edge1")).Should(Equal("fabedge.edge1"))
+		Expect(certutil.IdentityOf("fabedge.edge1", "spiffe://example.org/fabedge/edge1")).Should(Equal("spiffe://example.org/fabedge/edge1"))
+	})
+
+	It("should keep SPIFFE ID in CSR when signing certificate", func() {
+		caDER, caKeyDER, err := certutil.NewSelfSignedCA(certutil.Config{
+			CommonName:     certutil.DefaultCAName,
+			Organization:   []string{certutil.DefaultOrganization},
+			IsCA:           true,
+			ValidityPeriod: time.Hour,
+		})
+		Expect(err).Should(BeNil())
+
+		manager, err := certutil.NewManger(caDER, caKeyDER, time.Hour)
+		Expect(err).Should(BeNil())
+
+		id := "spiffe://example.org/fabedge/edge1"
+		_, csr, err := certutil.NewCertRequest(certutil.Request{
+			CommonName: "fabedge.edge1",
+			URIs:       certutil.URIsOfID(id),
+		})
+		Expect(err).Should(BeNil())
+
+		certDER, err := manager.SignCert(csr)
+		Expect(err).Should(BeNil())
+
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).Should(BeNil())
+		Expect(certutil.GetSPIFFEID(cert)).Should(Equal(id))
+		Expect(certutil.GetIdentity(cert)).Should(Equal(id))
+	})
+})
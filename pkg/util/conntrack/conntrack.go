@@ -0,0 +1,172 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// CIDRFilter matches flows which have an address in any of its CIDRs, either source or
+// destination, original or reply direction, so flows SNATed or DNATed are matched too
+type CIDRFilter struct {
+	nets []*net.IPNet
+}
+
+// NewCIDRFilter makes a filter of cidrs, an IP address is taken as a CIDR with a single address
+func NewCIDRFilter(cidrs []string) (*CIDRFilter, error) {
+	f := &CIDRFilter{}
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			f.nets = append(f.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", cidr, err)
+		}
+		f.nets = append(f.nets, ipNet)
+	}
+
+	return f, nil
+}
+
+func (f *CIDRFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	for _, ip := range []net.IP{flow.Forward.SrcIP, flow.Forward.DstIP, flow.Reverse.SrcIP, flow.Reverse.DstIP} {
+		if ip == nil {
+			continue
+		}
+		for _, ipNet := range f.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasFamily tells if any CIDR of filter is IPv4 or IPv6
+func (f *CIDRFilter) hasFamily(ipv4 bool) bool {
+	for _, ipNet := range f.nets {
+		if (ipNet.IP.To4() != nil) == ipv4 {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteFlows deletes flows which have an address in cidrs and returns how many flows are deleted
+func DeleteFlows(cidrs []string) (uint, error) {
+	if len(cidrs) == 0 {
+		return 0, nil
+	}
+
+	filter, err := NewCIDRFilter(cidrs)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted uint
+	for _, family := range []netlink.InetFamily{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if !filter.hasFamily(family == netlink.FAMILY_V4) {
+			continue
+		}
+
+		n, err := netlink.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// CountFlows returns how many flows have an address in cidrs
+func CountFlows(cidrs []string) (int, error) {
+	if len(cidrs) == 0 {
+		return 0, nil
+	}
+
+	filter, err := NewCIDRFilter(cidrs)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, family := range []netlink.InetFamily{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if !filter.hasFamily(family == netlink.FAMILY_V4) {
+			continue
+		}
+
+		flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, family)
+		if err != nil {
+			return count, err
+		}
+		for _, flow := range flows {
+			if filter.MatchConntrackFlow(flow) {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Cleaner deletes flows of subnets which are added or removed, e.g. subnets routed through
+// tunnels, because existing flows keep their NAT and state after routes or rules are changed.
+// It's safe for concurrent use
+type Cleaner struct {
+	mux sync.Mutex
+	// subnets is nil before the first update
+	subnets sets.String
+}
+
+// Update saves subnets and deletes flows of subnets changed since last update, nothing is
+// deleted at the first update, because flows are not stale when the program restarts.
+// Changed subnets are returned with the number of deleted flows
+func (c *Cleaner) Update(subnets sets.String) ([]string, uint, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.subnets == nil {
+		c.subnets = sets.NewString(subnets.UnsortedList()...)
+		return nil, 0, nil
+	}
+
+	changed := subnets.Difference(c.subnets).Union(c.subnets.Difference(subnets)).List()
+	if len(changed) == 0 {
+		return nil, 0, nil
+	}
+
+	deleted, err := DeleteFlows(changed)
+	if err != nil {
+		// subnets are not saved, so flows are deleted again next time
+		return changed, deleted, err
+	}
+	c.subnets = sets.NewString(subnets.UnsortedList()...)
+
+	return changed, deleted, nil
+}
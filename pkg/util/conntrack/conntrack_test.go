@@ -0,0 +1,53 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conntrack_test
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+
+	"github.com/fabedge/fabedge/pkg/util/conntrack"
+)
+
+var _ = Describe("CIDRFilter", func() {
+	newFlow := func(src, dst, replySrc, replyDst string) *netlink.ConntrackFlow {
+		flow := &netlink.ConntrackFlow{}
+		flow.Forward.SrcIP, flow.Forward.DstIP = net.ParseIP(src), net.ParseIP(dst)
+		flow.Reverse.SrcIP, flow.Reverse.DstIP = net.ParseIP(replySrc), net.ParseIP(replyDst)
+		return flow
+	}
+
+	It("should match flows which have an address in CIDRs in any direction", func() {
+		filter, err := conntrack.NewCIDRFilter([]string{"2.2.0.0/16", "10.20.8.4"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(filter.MatchConntrackFlow(newFlow("2.2.1.2", "10.233.0.5", "10.233.0.5", "2.2.1.2"))).Should(BeTrue())
+		Expect(filter.MatchConntrackFlow(newFlow("10.233.0.5", "2.2.1.2", "2.2.1.2", "10.233.0.5"))).Should(BeTrue())
+		// DNATed by a service
+		Expect(filter.MatchConntrackFlow(newFlow("10.233.0.5", "10.96.0.10", "2.2.1.2", "10.233.0.5"))).Should(BeTrue())
+		Expect(filter.MatchConntrackFlow(newFlow("10.20.8.4", "10.233.0.5", "10.233.0.5", "10.20.8.4"))).Should(BeTrue())
+
+		Expect(filter.MatchConntrackFlow(newFlow("10.233.0.5", "10.233.0.6", "10.233.0.6", "10.233.0.5"))).Should(BeFalse())
+		Expect(filter.MatchConntrackFlow(newFlow("10.20.8.5", "10.233.0.5", "10.233.0.5", "10.20.8.5"))).Should(BeFalse())
+	})
+
+	It("should reject invalid CIDRs", func() {
+		_, err := conntrack.NewCIDRFilter([]string{"2.2.0.0/33"})
+		Expect(err).Should(HaveOccurred())
+	})
+})
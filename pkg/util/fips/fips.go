@@ -0,0 +1,155 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fips restricts TLS, certificates and IPsec proposals to algorithms approved
+// by FIPS 140-2. The mode is turned on by Enable at startup or by building with tag fips.
+package fips
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
+
+var enabled = buildEnabled
+
+var (
+	// IKEProposals are proposals of IKE SAs in FIPS mode: AES-GCM, SHA2 PRF and NIST curves
+	IKEProposals = []string{"aes256gcm16-prfsha384-ecp384", "aes128gcm16-prfsha256-ecp256"}
+	// ESPProposals are proposals of child SAs in FIPS mode, DH groups are used for PFS
+	ESPProposals = []string{"aes256gcm16-ecp384", "aes128gcm16-ecp256"}
+)
+
+// Enable turns on FIPS mode, it should be called at startup before any TLS config is made
+func Enable() {
+	enabled = true
+}
+
+// Enabled tells if FIPS mode is on
+func Enabled() bool {
+	return enabled
+}
+
+// BuildEnabled tells if the binary is built with tag fips, FIPS mode can't be turned off then
+func BuildEnabled() bool {
+	return buildEnabled
+}
+
+// ValidateKey checks if keys of keyType and size are approved, size 0 means the default size
+func ValidateKey(keyType certutil.KeyType, size int) error {
+	switch keyType {
+	case "", certutil.KeyTypeRSA:
+		if size != 0 && size < 2048 {
+			return fmt.Errorf("size of RSA keys must be at least 2048 in FIPS mode: %d", size)
+		}
+	case certutil.KeyTypeECDSA:
+		switch size {
+		case 0, 256, 384, 521:
+		default:
+			return fmt.Errorf("curve of ECDSA keys must be P-256, P-384 or P-521 in FIPS mode: %d", size)
+		}
+	default:
+		return fmt.Errorf("key type %s is not allowed in FIPS mode", keyType)
+	}
+
+	return nil
+}
+
+// ValidateSignatureAlgorithm checks if algorithm is approved, an unknown algorithm is valid
+// because x509 package chooses an approved one for RSA and ECDSA keys
+func ValidateSignatureAlgorithm(algorithm x509.SignatureAlgorithm) error {
+	switch algorithm {
+	case x509.UnknownSignatureAlgorithm,
+		x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return nil
+	default:
+		return fmt.Errorf("signature algorithm %s is not allowed in FIPS mode", algorithm)
+	}
+}
+
+// ValidateCert checks if the public key and the signature algorithm of cert are approved
+func ValidateCert(cert *x509.Certificate) error {
+	if cert.SignatureAlgorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("signature algorithm of certificate %s is unknown", cert.Subject)
+	}
+
+	if err := ValidateSignatureAlgorithm(cert.SignatureAlgorithm); err != nil {
+		return fmt.Errorf("certificate %s: %w", cert.Subject, err)
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return fmt.Errorf("certificate %s has a RSA key of %d bits, at least 2048 bits are required in FIPS mode", cert.Subject, key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("curve of the key of certificate %s is not allowed in FIPS mode", cert.Subject)
+		}
+	default:
+		return fmt.Errorf("key algorithm %s of certificate %s is not allowed in FIPS mode", cert.PublicKeyAlgorithm, cert.Subject)
+	}
+
+	return nil
+}
+
+// ValidateCertsPEM checks all certificates in certsPEM by ValidateCert
+func ValidateCertsPEM(certsPEM []byte) error {
+	ders, err := certutil.DecodeCertsPEM(certsPEM)
+	if err != nil {
+		return err
+	}
+
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+
+		if err = ValidateCert(cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TLSConfig restricts cfg to TLS 1.2 with ECDHE and AES-GCM cipher suites on NIST curves if FIPS
+// mode is on, cipher suites of TLS 1.3 can't be configured, so TLS 1.3 is not used. cfg is returned
+func TLSConfig(cfg *tls.Config) *tls.Config {
+	if !enabled {
+		return cfg
+	}
+
+	cfg.MinVersion = tls.VersionTLS12
+	cfg.MaxVersion = tls.VersionTLS12
+	cfg.CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+	return cfg
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fips_test
+
+import (
+	"crypto/x509"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/fips"
+	timeutil "github.com/fabedge/fabedge/pkg/util/time"
+)
+
+var _ = Describe("FIPS", func() {
+	It("should only accept approved key types and sizes", func() {
+		Expect(fips.ValidateKey(certutil.KeyTypeRSA, 0)).Should(Succeed())
+		Expect(fips.ValidateKey(certutil.KeyTypeRSA, 3072)).Should(Succeed())
+		Expect(fips.ValidateKey(certutil.KeyTypeECDSA, 384)).Should(Succeed())
+
+		Expect(fips.ValidateKey(certutil.KeyTypeRSA, 1024)).ShouldNot(Succeed())
+		Expect(fips.ValidateKey(certutil.KeyTypeECDSA, 224)).ShouldNot(Succeed())
+		Expect(fips.ValidateKey(certutil.KeyTypeEd25519, 0)).ShouldNot(Succeed())
+	})
+
+	It("should only accept approved signature algorithms", func() {
+		Expect(fips.ValidateSignatureAlgorithm(x509.UnknownSignatureAlgorithm)).Should(Succeed())
+		Expect(fips.ValidateSignatureAlgorithm(x509.SHA384WithRSAPSS)).Should(Succeed())
+		Expect(fips.ValidateSignatureAlgorithm(x509.ECDSAWithSHA256)).Should(Succeed())
+
+		Expect(fips.ValidateSignatureAlgorithm(x509.SHA1WithRSA)).ShouldNot(Succeed())
+		Expect(fips.ValidateSignatureAlgorithm(x509.PureEd25519)).ShouldNot(Succeed())
+	})
+
+	It("should check key and signature algorithm of certificates", func() {
+		newCA := func(keyType certutil.KeyType) []byte {
+			certDER, _, err := certutil.NewSelfSignedCA(certutil.Config{
+				CommonName:     certutil.DefaultCAName,
+				Organization:   []string{certutil.DefaultOrganization},
+				ValidityPeriod: timeutil.Days(1),
+				IsCA:           true,
+				KeyType:        keyType,
+			})
+			Expect(err).Should(BeNil())
+
+			return certutil.EncodeCertPEM(certDER)
+		}
+
+		Expect(fips.ValidateCertsPEM(newCA(certutil.KeyTypeRSA))).Should(Succeed())
+		Expect(fips.ValidateCertsPEM(newCA(certutil.KeyTypeECDSA))).Should(Succeed())
+		Expect(fips.ValidateCertsPEM(newCA(certutil.KeyTypeEd25519))).ShouldNot(Succeed())
+	})
+})
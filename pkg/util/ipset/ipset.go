@@ -37,6 +37,8 @@ type Interface interface {
 	ListEntries(setName string, setType ipset.Type) (sets.String, error)
 	SyncIPSetEntries(ipsetObj *ipset.IPSet, allIPSetEntrySet, oldIPSetEntrySet sets.String, setType ipset.Type) error
 	ConvertIPToCIDR(ip string) string
+	// DestroyIPSet deletes the named set, it's ok if the set doesn't exist
+	DestroyIPSet(setName string) error
 }
 
 type execer struct {
@@ -142,3 +144,18 @@ func (e *execer) SyncIPSetEntries(ipsetObj *ipset.IPSet, allIPSetEntrySet, oldIP
 func (e *execer) ConvertIPToCIDR(ip string) string {
 	return strings.Join([]string{ip, "32"}, "/")
 }
+
+func (e *execer) DestroyIPSet(setName string) error {
+	names, err := e.ipset.ListSets()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if strings.TrimSpace(name) == setName {
+			return e.ipset.DestroySet(setName)
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,24 @@ package log
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
+	"github.com/go-logr/logr"
 	"github.com/spf13/pflag"
 	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/klogr"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	format = FormatText
+	// values are added to every JSON log
+	values []interface{}
 )
 
 func AddFlags(fs *pflag.FlagSet) {
@@ -13,3 +28,30 @@ func AddFlags(fs *pflag.FlagSet) {
 
 	fs.AddGoFlag(local.Lookup("v"))
 }
+
+// AddFormatFlag adds --log-format, components which support it should call Setup after flags are parsed
+func AddFormatFlag(fs *pflag.FlagSet) {
+	fs.StringVar(&format, "log-format", FormatText, "text or json. json writes a JSON object in a line for each log with keys ts, level, logger, msg, error, node and values of the log")
+}
+
+// Setup makes logs of klog written as JSON if format is json, values are added to every log, e.g. the node name
+func Setup(keysAndValues ...interface{}) error {
+	switch format {
+	case FormatText:
+		return nil
+	case FormatJSON:
+		values = keysAndValues
+		klog.SetLogger(NewJSONLogger(os.Stderr, values...))
+		return nil
+	default:
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+}
+
+// New returns a logger of name which writes logs in the format set by --log-format
+func New(name string) logr.Logger {
+	if format == FormatJSON {
+		return NewJSONLogger(os.Stderr, values...).WithName(name)
+	}
+	return klogr.New().WithName(name)
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+// Keys shared by logs of all components, so logs of a fleet can be queried by them
+const (
+	KeyTime      = "ts"
+	KeyLevel     = "level"
+	KeyLogger    = "logger"
+	KeyMessage   = "msg"
+	KeyError     = "error"
+	KeyNode      = "node"
+	KeyEndpoint  = "endpoint"
+	KeyCommunity = "community"
+)
+
+// jsonLogger writes a JSON object in a line for each log, verbosity is controlled by klog's -v
+type jsonLogger struct {
+	mux    *sync.Mutex
+	out    io.Writer
+	name   string
+	level  int
+	values []interface{}
+}
+
+// NewJSONLogger returns a logger which writes logs to out as JSON lines, values are added to each log
+func NewJSONLogger(out io.Writer, values ...interface{}) logr.Logger {
+	return &jsonLogger{
+		mux:    &sync.Mutex{},
+		out:    out,
+		values: values,
+	}
+}
+
+func (l *jsonLogger) Enabled() bool {
+	return klog.V(klog.Level(l.level)).Enabled()
+}
+
+func (l *jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		l.write("info", nil, msg, keysAndValues)
+	}
+}
+
+func (l *jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.write("error", err, msg, keysAndValues)
+}
+
+func (l *jsonLogger) V(level int) logr.Logger {
+	logger := l.clone()
+	logger.level += level
+	return logger
+}
+
+func (l *jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	logger := l.clone()
+	logger.values = append(logger.values, keysAndValues...)
+	return logger
+}
+
+func (l *jsonLogger) WithName(name string) logr.Logger {
+	logger := l.clone()
+	if logger.name == "" {
+		logger.name = name
+	} else {
+		logger.name = logger.name + "." + name
+	}
+	return logger
+}
+
+func (l *jsonLogger) clone() *jsonLogger {
+	logger := *l
+	logger.values = append([]interface{}{}, l.values...)
+	return &logger
+}
+
+func (l *jsonLogger) write(level string, err error, msg string, keysAndValues []interface{}) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	writePair(buf, KeyTime, time.Now().UTC().Format(time.RFC3339Nano))
+	writePair(buf, KeyLevel, level)
+	if l.name != "" {
+		writePair(buf, KeyLogger, l.name)
+	}
+	// logs from klog end with a newline
+	writePair(buf, KeyMessage, strings.TrimSuffix(msg, "\n"))
+	if err != nil {
+		writePair(buf, KeyError, err.Error())
+	}
+	writeValues(buf, l.values)
+	writeValues(buf, keysAndValues)
+	buf.WriteString("}\n")
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	_, _ = l.out.Write(buf.Bytes())
+}
+
+func writeValues(buf *bytes.Buffer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprintf("%v", keysAndValues[i])
+		}
+
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		writePair(buf, key, value)
+	}
+}
+
+func writePair(buf *bytes.Buffer, key string, value interface{}) {
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(marshalValue(value))
+}
+
+// marshalValue marshals errors and stringers as strings, values which can't be marshaled are formatted by fmt
+func marshalValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	return b
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	logutil "github.com/fabedge/fabedge/pkg/util/log"
+)
+
+var _ = Describe("JSONLogger", func() {
+	var (
+		buf *bytes.Buffer
+	)
+
+	decode := func() map[string]interface{} {
+		entry := make(map[string]interface{})
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).Should(Succeed())
+		return entry
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+	})
+
+	It("should write a log as a JSON line with name and values", func() {
+		log := logutil.NewJSONLogger(buf, logutil.KeyNode, "edge1").WithName("manager").WithValues(logutil.KeyEndpoint, "fabedge.edge2")
+		log.Info("tunnel is loaded\n", "subnets", []string{"2.2.0.0/26"}, "address", net.ParseIP("10.20.8.4"))
+
+		Expect(buf.String()).Should(HaveSuffix("}\n"))
+		entry := decode()
+		Expect(entry[logutil.KeyTime]).ShouldNot(BeEmpty())
+		Expect(entry[logutil.KeyLevel]).Should(Equal("info"))
+		Expect(entry[logutil.KeyLogger]).Should(Equal("manager"))
+		Expect(entry[logutil.KeyMessage]).Should(Equal("tunnel is loaded"))
+		Expect(entry[logutil.KeyNode]).Should(Equal("edge1"))
+		Expect(entry[logutil.KeyEndpoint]).Should(Equal("fabedge.edge2"))
+		Expect(entry["subnets"]).Should(Equal([]interface{}{"2.2.0.0/26"}))
+		Expect(entry["address"]).Should(Equal("10.20.8.4"))
+	})
+
+	It("should write errors as strings", func() {
+		log := logutil.NewJSONLogger(buf)
+		log.Error(fmt.Errorf("connection refused"), "failed to load tunnel", "cause", fmt.Errorf("timeout"), "dangling")
+
+		entry := decode()
+		Expect(entry[logutil.KeyLevel]).Should(Equal("error"))
+		Expect(entry[logutil.KeyError]).Should(Equal("connection refused"))
+		Expect(entry["cause"]).Should(Equal("timeout"))
+		Expect(entry["dangling"]).Should(Equal("(MISSING)"))
+	})
+
+	It("should not write logs of verbosity higher than -v", func() {
+		logutil.NewJSONLogger(buf).V(10).Info("hidden")
+		Expect(buf.Len()).Should(Equal(0))
+	})
+
+	It("should join names by dot", func() {
+		logutil.NewJSONLogger(buf).WithName("operator").WithName("agent").Info("hello")
+		Expect(decode()[logutil.KeyLogger]).Should(Equal("operator.agent"))
+	})
+})
@@ -0,0 +1,135 @@
+package memberlist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"k8s.io/klog/v2"
+)
+
+// loadKeys reads keys from keyring file, each line is a base64 encoded key of 16, 24 or 32 bytes
+// which selects AES-128, AES-192 or AES-256, the first key is the primary key used to encrypt
+// messages, all keys are used to decrypt messages. Empty lines and lines starting with # are ignored
+func loadKeys(file string) ([][]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key in %s: %w", file, err)
+		}
+
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key in %s must be 16, 24 or 32 bytes, got %d", file, len(key))
+		}
+
+		if !containsKey(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key is found in %s", file)
+	}
+
+	return keys, nil
+}
+
+func newKeyring(file string) (*memberlist.Keyring, error) {
+	keys, err := loadKeys(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return memberlist.NewKeyring(keys, keys[0])
+}
+
+// updateKeyring makes keyring have the same keys as keys, new keys are installed before the
+// primary key is switched, so no member is left without the key to decrypt messages
+func updateKeyring(keyring *memberlist.Keyring, keys [][]byte) error {
+	for _, key := range keys {
+		if err := keyring.AddKey(key); err != nil {
+			return err
+		}
+	}
+
+	if err := keyring.UseKey(keys[0]); err != nil {
+		return err
+	}
+
+	// GetKeys returns keys of keyring itself which are shifted by RemoveKey, so they are copied first
+	installed := append([][]byte(nil), keyring.GetKeys()...)
+	for _, key := range installed {
+		if !containsKey(keys, key) {
+			if err := keyring.RemoveKey(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchKeyring reloads keys from file periodically, it works with secret volumes which are
+// updated by kubelet through symbolic links
+func watchKeyring(keyring *memberlist.Keyring, file string, interval time.Duration) {
+	for range time.Tick(interval) {
+		keys, err := loadKeys(file)
+		if err != nil {
+			klog.Errorf("failed to load memberlist keys: %s", err)
+			continue
+		}
+
+		if sameKeys(keyring, keys) {
+			continue
+		}
+
+		if err = updateKeyring(keyring, keys); err != nil {
+			klog.Errorf("failed to update memberlist keyring: %s", err)
+			continue
+		}
+
+		klog.V(3).Infof("memberlist keyring is updated, %d keys are installed", len(keys))
+	}
+}
+
+func sameKeys(keyring *memberlist.Keyring, keys [][]byte) bool {
+	installed := keyring.GetKeys()
+	if len(installed) != len(keys) || !bytes.Equal(keyring.GetPrimaryKey(), keys[0]) {
+		return false
+	}
+
+	for _, key := range keys {
+		if !containsKey(installed, key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,154 @@
+package memberlist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keyring", func() {
+	var (
+		dir  string
+		file string
+
+		key1 = bytes.Repeat([]byte{1}, 16)
+		key2 = bytes.Repeat([]byte{2}, 24)
+		key3 = bytes.Repeat([]byte{3}, 32)
+	)
+
+	writeKeys := func(lines ...string) {
+		Expect(ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0600)).Should(Succeed())
+	}
+
+	encode := func(key []byte) string {
+		return base64.StdEncoding.EncodeToString(key)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "keyring")
+		Expect(err).Should(BeNil())
+		file = filepath.Join(dir, "keys")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).Should(Succeed())
+	})
+
+	Context("loadKeys", func() {
+		It("should load keys in order and skip comments, empty lines and duplicated keys", func() {
+			writeKeys("# primary key", encode(key1), "", "  "+encode(key2)+"  ", encode(key1), encode(key3))
+
+			keys, err := loadKeys(file)
+			Expect(err).Should(BeNil())
+			Expect(keys).Should(Equal([][]byte{key1, key2, key3}))
+		})
+
+		It("should reject keys which are not base64 encoded", func() {
+			writeKeys(encode(key1), "not-base64!")
+
+			_, err := loadKeys(file)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("should reject keys of wrong sizes", func() {
+			writeKeys(encode(bytes.Repeat([]byte{1}, 20)))
+
+			_, err := loadKeys(file)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("should reject file without keys", func() {
+			writeKeys("# no key", "")
+
+			_, err := loadKeys(file)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("should return error if file doesn't exist", func() {
+			_, err := loadKeys(filepath.Join(dir, "unknown"))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("newKeyring", func() {
+		It("should use the first key as primary key", func() {
+			writeKeys(encode(key2), encode(key1))
+
+			keyring, err := newKeyring(file)
+			Expect(err).Should(BeNil())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key2))
+			Expect(keyring.GetKeys()).Should(ConsistOf(key1, key2))
+		})
+	})
+
+	Context("updateKeyring", func() {
+		It("should rotate keys through adding, promoting and retiring", func() {
+			writeKeys(encode(key1))
+			keyring, err := newKeyring(file)
+			Expect(err).Should(BeNil())
+
+			By("adding a new key which is not primary yet")
+			keys := [][]byte{key1, key2}
+			Expect(sameKeys(keyring, keys)).Should(BeFalse())
+			Expect(updateKeyring(keyring, keys)).Should(Succeed())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key1))
+			Expect(keyring.GetKeys()).Should(ConsistOf(key1, key2))
+			Expect(sameKeys(keyring, keys)).Should(BeTrue())
+
+			By("promoting the new key to primary key")
+			keys = [][]byte{key2, key1}
+			Expect(sameKeys(keyring, keys)).Should(BeFalse())
+			Expect(updateKeyring(keyring, keys)).Should(Succeed())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key2))
+			Expect(keyring.GetKeys()).Should(ConsistOf(key1, key2))
+
+			By("retiring the old key")
+			keys = [][]byte{key2}
+			Expect(updateKeyring(keyring, keys)).Should(Succeed())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key2))
+			Expect(keyring.GetKeys()).Should(Equal([][]byte{key2}))
+			Expect(sameKeys(keyring, keys)).Should(BeTrue())
+		})
+
+		It("should switch primary key and remove old keys at once", func() {
+			writeKeys(encode(key1), encode(key2))
+			keyring, err := newKeyring(file)
+			Expect(err).Should(BeNil())
+
+			Expect(updateKeyring(keyring, [][]byte{key3})).Should(Succeed())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key3))
+			Expect(keyring.GetKeys()).Should(Equal([][]byte{key3}))
+		})
+
+		It("should remove all retired keys", func() {
+			key4 := bytes.Repeat([]byte{4}, 16)
+			writeKeys(encode(key1), encode(key2), encode(key3))
+			keyring, err := newKeyring(file)
+			Expect(err).Should(BeNil())
+
+			Expect(updateKeyring(keyring, [][]byte{key4, key3})).Should(Succeed())
+			Expect(keyring.GetPrimaryKey()).Should(Equal(key4))
+			Expect(keyring.GetKeys()).Should(ConsistOf(key4, key3))
+		})
+	})
+
+	Context("sameKeys", func() {
+		It("should compare primary key and all keys", func() {
+			writeKeys(encode(key1), encode(key2))
+			keyring, err := newKeyring(file)
+			Expect(err).Should(BeNil())
+
+			Expect(sameKeys(keyring, [][]byte{key1, key2})).Should(BeTrue())
+			Expect(sameKeys(keyring, [][]byte{key2, key1})).Should(BeFalse())
+			Expect(sameKeys(keyring, [][]byte{key1})).Should(BeFalse())
+			Expect(sameKeys(keyring, [][]byte{key1, key3})).Should(BeFalse())
+		})
+	})
+})
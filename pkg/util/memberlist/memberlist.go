@@ -1,14 +1,92 @@
 package memberlist
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/hashicorp/memberlist"
 	"os"
 	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/spf13/pflag"
+)
+
+const (
+	RoleConnector  = "connector"
+	RoleCloudAgent = "cloud-agent"
+)
+
+// EventReason tells why a member event happens
+type EventReason string
+
+const (
+	ReasonJoin   EventReason = "Join"
+	ReasonLeave  EventReason = "Leave"
+	ReasonUpdate EventReason = "Update"
 )
 
 type msgHandlerFun func(b []byte)
-type notifyLeaveFun func(name string)
+type eventHandlerFun func(event MemberEvent)
+
+// NodeMeta is the metadata of a member which is propagated to other members
+type NodeMeta struct {
+	Role string `json:"role,omitempty"`
+}
+
+type MemberEvent struct {
+	Name   string
+	Addr   string
+	Meta   NodeMeta
+	Reason EventReason
+}
+
+type Config struct {
+	InitMembers []string
+	// BindAddr and BindPort are the address and port to listen on for both UDP and TCP gossip
+	BindAddr string
+	BindPort int
+	// AdvertiseAddr is the address advertised to other members, if it's empty,
+	// the value of env MY_POD_IP is used
+	AdvertiseAddr string
+	AdvertisePort int
+	// TCPOnly makes all gossip go through TCP, this is useful when UDP is blocked
+	TCPOnly bool
+	// KeyringFile is the file of keys to encrypt and authenticate gossip, see loadKeys for its format.
+	// Members without a key in it can't join. Empty means gossip is plaintext
+	KeyringFile string
+	// KeyringReloadInterval is the interval to reload KeyringFile, so keys can be rotated
+	KeyringReloadInterval time.Duration
+	Role                  string
+
+	// TLSCertFile, TLSKeyFile and TLSCAFile enable mutual TLS of gossip, which makes all gossip go
+	// through TCP. Only members with certificates issued by the CA can join
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+	// AllowedPeersFile is the file of identities allowed to join, one per line. An identity is the
+	// SPIFFE ID of a certificate, or its common name if it has no SPIFFE ID. Empty means any
+	// member with a certificate issued by the CA is allowed
+	AllowedPeersFile string
+	// TLSReloadInterval is the interval to reload TLS files and AllowedPeersFile
+	TLSReloadInterval time.Duration
+
+	MsgHandler   msgHandlerFun
+	EventHandler eventHandlerFun
+}
+
+func (cfg *Config) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&cfg.BindAddr, "memberlist-bind-address", "0.0.0.0", "The address for memberlist to listen on")
+	fs.IntVar(&cfg.BindPort, "memberlist-bind-port", 7946, "The port for memberlist to listen on")
+	fs.StringVar(&cfg.AdvertiseAddr, "memberlist-advertise-address", "", "The address advertised to other members, the value of env MY_POD_IP is used if not provided")
+	fs.IntVar(&cfg.AdvertisePort, "memberlist-advertise-port", 0, "The port advertised to other members, the bind port is used if not provided")
+	fs.BoolVar(&cfg.TCPOnly, "memberlist-tcp-only", false, "Use TCP only for memberlist communication, use it when UDP is blocked")
+	fs.StringVar(&cfg.KeyringFile, "memberlist-keyring-file", "", "The file of base64 encoded keys to encrypt memberlist communication, one key per line, the first one is used to encrypt. Members must share a key to join each other. Leave it empty to disable encryption")
+	fs.DurationVar(&cfg.KeyringReloadInterval, "memberlist-keyring-reload-interval", time.Minute, "The interval to reload keys from memberlist-keyring-file")
+	fs.StringVar(&cfg.TLSCertFile, "memberlist-tls-cert-file", "", "The certificate file for mutual TLS of memberlist communication, which makes memberlist use TCP only. Leave it empty to disable TLS")
+	fs.StringVar(&cfg.TLSKeyFile, "memberlist-tls-key-file", "", "The key file for mutual TLS of memberlist communication")
+	fs.StringVar(&cfg.TLSCAFile, "memberlist-tls-ca-file", "", "The file of CA certificates to verify certificates of other members")
+	fs.StringVar(&cfg.AllowedPeersFile, "memberlist-allowed-peers-file", "", "The file of identities allowed to join memberlist, one per line. An identity is the SPIFFE ID of a certificate or its common name if it has no SPIFFE ID. Only works with memberlist TLS, leave it empty to allow any member with a certificate issued by the CA")
+	fs.DurationVar(&cfg.TLSReloadInterval, "memberlist-tls-reload-interval", time.Minute, "The interval to reload memberlist TLS files and memberlist-allowed-peers-file")
+}
 
 type broadcast struct {
 	msg []byte
@@ -26,16 +104,20 @@ func (b *broadcast) Finished() {
 }
 
 type delegate struct {
+	meta      []byte
 	notifyMsg msgHandlerFun
 	queue     *memberlist.TransmitLimitedQueue
 }
 
 func (d *delegate) NodeMeta(limit int) []byte {
-	return []byte{}
+	if len(d.meta) > limit {
+		return []byte{}
+	}
+	return d.meta
 }
 
 func (d *delegate) NotifyMsg(b []byte) {
-	if len(b) == 0 {
+	if len(b) == 0 || d.notifyMsg == nil {
 		return
 	}
 	d.notifyMsg(b)
@@ -57,17 +139,32 @@ func (d *delegate) MergeRemoteState(buf []byte, join bool) {
 }
 
 type eventDelegate struct {
-	notifyLeave notifyLeaveFun
+	handle eventHandlerFun
 }
 
 func (ed *eventDelegate) NotifyJoin(node *memberlist.Node) {
+	ed.notify(node, ReasonJoin)
 }
 
 func (ed *eventDelegate) NotifyLeave(node *memberlist.Node) {
-	ed.notifyLeave(node.String())
+	ed.notify(node, ReasonLeave)
 }
 
 func (ed *eventDelegate) NotifyUpdate(node *memberlist.Node) {
+	ed.notify(node, ReasonUpdate)
+}
+
+func (ed *eventDelegate) notify(node *memberlist.Node, reason EventReason) {
+	if ed.handle == nil {
+		return
+	}
+
+	ed.handle(MemberEvent{
+		Name:   node.String(),
+		Addr:   node.Addr.String(),
+		Meta:   GetNodeMeta(node),
+		Reason: reason,
+	})
 }
 
 type Client struct {
@@ -84,21 +181,81 @@ func getAdvertiseAddr() (string, error) {
 	}
 }
 
-func New(initMembers []string, msgHandler msgHandlerFun, leaveHandler notifyLeaveFun) (*Client, error) {
+// GetNodeMeta parses metadata of node, an empty NodeMeta is returned if
+// metadata is not provided or malformed
+func GetNodeMeta(node *memberlist.Node) NodeMeta {
+	var meta NodeMeta
+	if len(node.Meta) > 0 {
+		_ = json.Unmarshal(node.Meta, &meta)
+	}
+	return meta
+}
+
+func New(cfg Config) (*Client, error) {
+	if len(cfg.InitMembers) < 1 {
+		return nil, fmt.Errorf("at lease one known member is needed")
+	}
+
 	conf := memberlist.DefaultWANConfig()
+	conf.BindAddr = cfg.BindAddr
+	conf.BindPort = cfg.BindPort
+	conf.AdvertisePort = cfg.BindPort
+	if cfg.AdvertisePort > 0 {
+		conf.AdvertisePort = cfg.AdvertisePort
+	}
+
+	conf.AdvertiseAddr = cfg.AdvertiseAddr
+	if conf.AdvertiseAddr == "" {
+		if ip, err := getAdvertiseAddr(); err == nil {
+			conf.AdvertiseAddr = ip
+		}
+	}
+
+	var credentials *tlsCredentials
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.TLSCAFile != "" {
+		var err error
+		if credentials, err = newTLSCredentials(cfg); err != nil {
+			return nil, err
+		}
+	} else if cfg.AllowedPeersFile != "" {
+		return nil, fmt.Errorf("allowed peers only work with memberlist TLS")
+	}
+
+	// TLS can't protect UDP packets, so TCP transport is used then
+	if cfg.TCPOnly || credentials != nil {
+		transport, err := newTCPTransport(cfg.BindAddr, cfg.BindPort, credentials)
+		if err != nil {
+			return nil, err
+		}
+		conf.Transport = transport
+	}
+
+	if cfg.KeyringFile != "" {
+		keyring, err := newKeyring(cfg.KeyringFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// messages which are not encrypted by a known key are dropped
+		conf.Keyring = keyring
+		conf.GossipVerifyIncoming = true
+		conf.GossipVerifyOutgoing = true
+	}
 
-	if ip, err := getAdvertiseAddr(); err != nil {
-		conf.AdvertiseAddr = ip
+	meta, err := json.Marshal(NodeMeta{Role: cfg.Role})
+	if err != nil {
+		return nil, err
 	}
 
 	dg := &delegate{
-		notifyMsg: msgHandler,
+		meta:      meta,
+		notifyMsg: cfg.MsgHandler,
 		queue:     &memberlist.TransmitLimitedQueue{RetransmitMult: 2},
 	}
 	conf.Delegate = dg
 
 	conf.Events = &eventDelegate{
-		notifyLeave: leaveHandler,
+		handle: cfg.EventHandler,
 	}
 
 	list, err := memberlist.Create(conf)
@@ -106,11 +263,9 @@ func New(initMembers []string, msgHandler msgHandlerFun, leaveHandler notifyLeav
 		return nil, err
 	}
 
-	if len(initMembers) < 1 {
-		return nil, fmt.Errorf("at lease one known member is needed")
-	}
-	_, err = list.Join(initMembers)
+	_, err = list.Join(cfg.InitMembers)
 	if err != nil {
+		_ = list.Shutdown()
 		return nil, err
 	}
 
@@ -118,6 +273,14 @@ func New(initMembers []string, msgHandler msgHandlerFun, leaveHandler notifyLeav
 		return list.NumMembers()
 	}
 
+	if conf.Keyring != nil && cfg.KeyringReloadInterval > 0 {
+		go watchKeyring(conf.Keyring, cfg.KeyringFile, cfg.KeyringReloadInterval)
+	}
+
+	if credentials != nil && cfg.TLSReloadInterval > 0 {
+		go credentials.watch(cfg.TLSReloadInterval)
+	}
+
 	return &Client{
 		list:     list,
 		delegate: dg,
@@ -132,6 +295,22 @@ func (c *Client) UpdateNode() error {
 	return c.list.UpdateNode(time.Second * 3)
 }
 
+// SendReliable sends b to the member named name through TCP
+func (c *Client) SendReliable(name string, b []byte) error {
+	for _, node := range c.list.Members() {
+		if node.Name == name {
+			return c.list.SendReliable(node, b)
+		}
+	}
+
+	return fmt.Errorf("member %s is not found", name)
+}
+
+// Leave tells other members this one is leaving, it waits at most timeout for the message to be sent
+func (c *Client) Leave(timeout time.Duration) error {
+	return c.list.Leave(timeout)
+}
+
 func (c *Client) Broadcast(b []byte) {
 	c.delegate.queue.QueueBroadcast(&broadcast{
 		msg: b,
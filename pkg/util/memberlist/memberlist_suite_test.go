@@ -0,0 +1,13 @@
+package memberlist
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemberlist(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memberlist Suite")
+}
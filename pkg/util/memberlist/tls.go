@@ -0,0 +1,181 @@
+package memberlist
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+	"github.com/fabedge/fabedge/pkg/util/fips"
+)
+
+// tlsCredentials holds the certificate of this member, CA certificates and identities of
+// members which are allowed to talk with this member. An identity is the SPIFFE ID of a
+// certificate, or its common name if it has no SPIFFE ID, see certutil.GetIdentity
+type tlsCredentials struct {
+	certFile  string
+	keyFile   string
+	caFile    string
+	peersFile string
+
+	mux   sync.RWMutex
+	cert  *tls.Certificate
+	roots *x509.CertPool
+	// peers is nil if peersFile is not provided, then any certificate issued by CA is accepted
+	peers sets.String
+}
+
+func newTLSCredentials(cfg Config) (*tlsCredentials, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" || cfg.TLSCAFile == "" {
+		return nil, fmt.Errorf("certificate, key and CA files are all needed for memberlist TLS")
+	}
+
+	c := &tlsCredentials{
+		certFile:  cfg.TLSCertFile,
+		keyFile:   cfg.TLSKeyFile,
+		caFile:    cfg.TLSCAFile,
+		peersFile: cfg.AllowedPeersFile,
+	}
+
+	return c, c.load()
+}
+
+func (c *tlsCredentials) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	caPEM, err := ioutil.ReadFile(c.caFile)
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no CA certificate is found in %s", c.caFile)
+	}
+
+	var peers sets.String
+	if c.peersFile != "" {
+		if peers, err = loadPeers(c.peersFile); err != nil {
+			return err
+		}
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if peers != nil && !peers.Equal(c.peers) {
+		klog.V(3).Infof("memberlist peers are loaded: %v", peers.List())
+	}
+	c.cert, c.roots, c.peers = &cert, roots, peers
+
+	return nil
+}
+
+// loadPeers reads identities from file, one identity per line, empty lines and lines starting with # are ignored
+func loadPeers(file string) (sets.String, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := sets.NewString()
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers.Insert(line)
+	}
+
+	return peers, nil
+}
+
+// watch reloads credentials periodically, so renewed certificates and changed peers take effect
+// without restarts. Connections of memberlist are short-lived, a removed peer is rejected soon
+func (c *tlsCredentials) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.load(); err != nil {
+			klog.Errorf("failed to reload memberlist TLS credentials: %s", err)
+		}
+	}
+}
+
+func (c *tlsCredentials) getCertificate() *tls.Certificate {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return c.cert
+}
+
+// verifyPeer checks if the peer certificate is issued by CA and its identity is allowed
+func (c *tlsCredentials) verifyPeer(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no certificate is provided by peer")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	c.mux.RLock()
+	roots, peers := c.roots, c.peers
+	c.mux.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return err
+	}
+
+	identity := certutil.GetIdentity(certs[0])
+	if peers != nil && !peers.Has(identity) {
+		return fmt.Errorf("peer %s is not allowed", identity)
+	}
+
+	return nil
+}
+
+// serverConfig requires a client certificate which is verified by verifyPeer
+func (c *tlsCredentials) serverConfig() *tls.Config {
+	return fips.TLSConfig(&tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.getCertificate(), nil
+		},
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: c.verifyPeer,
+	})
+}
+
+// clientConfig skips hostname verification because members are dialed by addresses, peers are
+// verified by verifyPeer against CA and allowed identities instead
+func (c *tlsCredentials) clientConfig() *tls.Config {
+	return fips.TLSConfig(&tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.getCertificate(), nil
+		},
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: c.verifyPeer,
+	})
+}
@@ -0,0 +1,216 @@
+package memberlist
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// each connection begins with a byte to tell packets from streams
+	connTypePacket byte = 'p'
+	connTypeStream byte = 's'
+
+	maxPacketSize = 65536
+	readTimeout   = 10 * time.Second
+)
+
+var _ memberlist.Transport = &tcpTransport{}
+
+// tcpTransport is a memberlist.Transport which sends packets through TCP
+// connections too, so memberlist works where UDP is blocked. If credentials
+// are provided, connections are protected by mutual TLS.
+type tcpTransport struct {
+	listener    *net.TCPListener
+	packetCh    chan *memberlist.Packet
+	streamCh    chan net.Conn
+	credentials *tlsCredentials
+
+	shutdown bool
+	mux      sync.Mutex
+}
+
+func newTCPTransport(bindAddr string, bindPort int, credentials *tlsCredentials) (*tcpTransport, error) {
+	ip := net.ParseIP(bindAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid bind address: %s", bindAddr)
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: bindPort})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tcpTransport{
+		listener:    listener,
+		packetCh:    make(chan *memberlist.Packet),
+		streamCh:    make(chan net.Conn),
+		credentials: credentials,
+	}
+	go t.accept()
+
+	return t, nil
+}
+
+func (t *tcpTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	addr := t.listener.Addr().(*net.TCPAddr)
+
+	advertiseIP := addr.IP
+	if ip != "" {
+		advertiseIP = net.ParseIP(ip)
+		if advertiseIP == nil {
+			return nil, 0, fmt.Errorf("invalid advertise address: %s", ip)
+		}
+	}
+
+	if advertiseIP.IsUnspecified() {
+		return nil, 0, fmt.Errorf("advertise address is required when bind address is %s", addr.IP)
+	}
+
+	if port == 0 {
+		port = addr.Port
+	}
+
+	return advertiseIP, port, nil
+}
+
+func (t *tcpTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	conn, err := t.dial(addr, readTimeout, connTypePacket)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write(b); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Now(), nil
+}
+
+func (t *tcpTransport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetCh
+}
+
+func (t *tcpTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.dial(addr, timeout, connTypeStream)
+	if err != nil {
+		return nil, err
+	}
+
+	// clear deadline set in dial, memberlist will set its own deadline
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (t *tcpTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+func (t *tcpTransport) Shutdown() error {
+	t.mux.Lock()
+	t.shutdown = true
+	t.mux.Unlock()
+
+	return t.listener.Close()
+}
+
+func (t *tcpTransport) isShutdown() bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.shutdown
+}
+
+func (t *tcpTransport) dial(addr string, timeout time.Duration, connType byte) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if t.credentials != nil {
+		tlsConn := tls.Client(conn, t.credentials.clientConfig())
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if _, err = conn.Write([]byte{connType}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *tcpTransport) accept() {
+	for {
+		conn, err := t.listener.AcceptTCP()
+		if err != nil {
+			if t.isShutdown() {
+				return
+			}
+
+			klog.Errorf("failed to accept memberlist connection: %s", err)
+			continue
+		}
+
+		go t.handleConn(conn)
+	}
+}
+
+func (t *tcpTransport) handleConn(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+	if t.credentials != nil {
+		tlsConn := tls.Server(conn, t.credentials.serverConfig())
+		_ = tlsConn.SetWriteDeadline(time.Now().Add(readTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			klog.Errorf("memberlist TLS handshake with %s failed: %s", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		_ = tlsConn.SetWriteDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	connType := make([]byte, 1)
+	if _, err := io.ReadFull(conn, connType); err != nil {
+		klog.Errorf("failed to read memberlist connection type from %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	switch connType[0] {
+	case connTypePacket:
+		defer conn.Close()
+
+		buf, err := ioutil.ReadAll(io.LimitReader(conn, maxPacketSize))
+		if err != nil {
+			klog.Errorf("failed to read memberlist packet from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		t.packetCh <- &memberlist.Packet{
+			Buf:       buf,
+			From:      conn.RemoteAddr(),
+			Timestamp: time.Now(),
+		}
+	case connTypeStream:
+		_ = conn.SetReadDeadline(time.Time{})
+		t.streamCh <- conn
+	default:
+		klog.Errorf("unknown memberlist connection type %d from %s", connType[0], conn.RemoteAddr())
+		conn.Close()
+	}
+}
@@ -85,3 +85,87 @@ func IsEdgeNode(node corev1.Node) bool {
 
 	return true
 }
+
+// IsKubeEdgeNode checks if the node is managed by edgecore of KubeEdge, whose kubelet
+// version is like v1.19.3-kubeedge-v1.5.0
+func IsKubeEdgeNode(node corev1.Node) bool {
+	return strings.Contains(node.Status.NodeInfo.KubeletVersion, "-kubeedge-")
+}
+
+// IsSchedulableBy checks if pods of the specified pod spec could be scheduled
+// to the node according to its nodeSelector and required node affinity,
+// taints and resources are not considered
+func IsSchedulableBy(node corev1.Node, spec corev1.PodSpec) bool {
+	for key, value := range spec.NodeSelector {
+		if v, exist := node.Labels[key]; !exist || v != value {
+			return false
+		}
+	}
+
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil {
+		return true
+	}
+
+	required := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		return true
+	}
+
+	// terms are ORed
+	for _, term := range required.NodeSelectorTerms {
+		if matchNodeSelectorTerm(node, term) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchNodeSelectorTerm(node corev1.Node, term corev1.NodeSelectorTerm) bool {
+	// an empty term matches no objects
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, req := range term.MatchExpressions {
+		value, exist := node.Labels[req.Key]
+		if !matchRequirement(req, value, exist) {
+			return false
+		}
+	}
+
+	for _, req := range term.MatchFields {
+		// metadata.name is the only supported field
+		if req.Key != "metadata.name" || !matchRequirement(req, node.Name, true) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchRequirement(req corev1.NodeSelectorRequirement, value string, exist bool) bool {
+	switch req.Operator {
+	case corev1.NodeSelectorOpExists:
+		return exist
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !exist
+	case corev1.NodeSelectorOpIn:
+		return exist && containsString(req.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !exist || !containsString(req.Values, value)
+	default:
+		// Gt and Lt are rarely used for nodes, take them as unmatched
+		return false
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
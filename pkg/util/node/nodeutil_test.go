@@ -36,3 +36,74 @@ func TestIsEdgeNode(t *testing.T) {
 	node.Labels["managed"] = "false"
 	g.Expect(nodeutil.IsEdgeNode(node)).To(BeFalse())
 }
+
+func TestIsKubeEdgeNode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	node := corev1.Node{
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{
+				KubeletVersion: "v1.19.3-kubeedge-v1.5.0",
+			},
+		},
+	}
+	g.Expect(nodeutil.IsKubeEdgeNode(node)).To(BeTrue())
+
+	node.Status.NodeInfo.KubeletVersion = "v1.19.3"
+	g.Expect(nodeutil.IsKubeEdgeNode(node)).To(BeFalse())
+}
+
+func TestIsSchedulableBy(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "edge1",
+			Labels: map[string]string{
+				"edge": "",
+				"os":   "linux",
+			},
+		},
+	}
+
+	g.Expect(nodeutil.IsSchedulableBy(node, corev1.PodSpec{})).To(BeTrue())
+	g.Expect(nodeutil.IsSchedulableBy(node, corev1.PodSpec{
+		NodeSelector: map[string]string{"os": "linux"},
+	})).To(BeTrue())
+	g.Expect(nodeutil.IsSchedulableBy(node, corev1.PodSpec{
+		NodeSelector: map[string]string{"os": "windows"},
+	})).To(BeFalse())
+
+	newSpec := func(req corev1.NodeSelectorRequirement) corev1.PodSpec {
+		return corev1.PodSpec{
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{MatchExpressions: []corev1.NodeSelectorRequirement{req}},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	g.Expect(nodeutil.IsSchedulableBy(node, newSpec(corev1.NodeSelectorRequirement{
+		Key:      "edge",
+		Operator: corev1.NodeSelectorOpDoesNotExist,
+	}))).To(BeFalse())
+	g.Expect(nodeutil.IsSchedulableBy(node, newSpec(corev1.NodeSelectorRequirement{
+		Key:      "edge",
+		Operator: corev1.NodeSelectorOpExists,
+	}))).To(BeTrue())
+	g.Expect(nodeutil.IsSchedulableBy(node, newSpec(corev1.NodeSelectorRequirement{
+		Key:      "os",
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"linux", "darwin"},
+	}))).To(BeTrue())
+	g.Expect(nodeutil.IsSchedulableBy(node, newSpec(corev1.NodeSelectorRequirement{
+		Key:      "os",
+		Operator: corev1.NodeSelectorOpNotIn,
+		Values:   []string{"linux"},
+	}))).To(BeFalse())
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	// maxMessageLength keeps results of a probe pod within its termination message
+	maxMessageLength = 80
+	noRTT            = "-"
+)
+
+var echoPayload = []byte("fabedge-connectivity-check")
+
+// CheckResult is the result of testing a target with a protocol, the test fails if Err is not nil
+type CheckResult struct {
+	Target
+	Protocol apis.CheckProtocol
+	RTT      time.Duration
+	Err      error
+}
+
+// Serve echoes what TCP and UDP clients send on port until ctx is done, it's run by
+// probe pods of connectivity checks. ICMP is answered by kernel
+func Serve(ctx context.Context, port int) error {
+	addr := net.JoinHostPort("", strconv.Itoa(port))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			go echoTCP(c)
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], from)
+		}
+	}()
+
+	select {
+	case err = <-errCh:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func echoTCP(c net.Conn) {
+	defer c.Close()
+
+	buf := make([]byte, len(echoPayload))
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	n, err := c.Read(buf)
+	if err != nil {
+		return
+	}
+	_, _ = c.Write(buf[:n])
+}
+
+// CheckAll tests each target with each protocol concurrently, results are ordered by
+// targets, then protocols
+func CheckAll(targets []Target, protocols []apis.CheckProtocol, port int, timeout time.Duration) []CheckResult {
+	results := make([]CheckResult, len(targets)*len(protocols))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		for j, protocol := range protocols {
+			wg.Add(1)
+			go func(index int, target Target, protocol apis.CheckProtocol) {
+				defer wg.Done()
+				results[index] = Check(target, protocol, port, timeout)
+			}(i*len(protocols)+j, target, protocol)
+		}
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Check tests target with protocol. TCP and UDP tests send a message to port of target
+// and expect it to be echoed in timeout, ICMP test sends an echo request
+func Check(target Target, protocol apis.CheckProtocol, port int, timeout time.Duration) CheckResult {
+	result := CheckResult{Target: target, Protocol: protocol}
+
+	start := time.Now()
+	switch protocol {
+	case apis.CheckProtocolTCP, apis.CheckProtocolUDP:
+		result.Err = echo(strings.ToLower(string(protocol)), net.JoinHostPort(target.Address, strconv.Itoa(port)), timeout)
+	case apis.CheckProtocolICMP:
+		r := Ping(target, 1, timeout)
+		switch {
+		case r.Err != nil:
+			result.Err = r.Err
+		case r.Received == 0:
+			result.Err = fmt.Errorf("no echo reply from %s in %s", target.Address, timeout)
+		default:
+			result.RTT = r.RTT
+			return result
+		}
+	default:
+		result.Err = fmt.Errorf("unknown protocol: %s", protocol)
+	}
+
+	if result.Err == nil {
+		result.RTT = time.Since(start)
+	}
+
+	return result
+}
+
+func echo(network, address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err = conn.Write(echoPayload); err != nil {
+		return err
+	}
+
+	buf := make([]byte, len(echoPayload))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[:n], echoPayload) {
+		return fmt.Errorf("unexpected reply from %s", address)
+	}
+
+	return nil
+}
+
+// ParseTargets parses targets like edge1=10.233.64.5, empty values are skipped
+func ParseTargets(values []string) ([]Target, error) {
+	targets := make([]Target, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid check target: %s", value)
+		}
+		targets = append(targets, Target{Name: parts[0], Address: parts[1]})
+	}
+
+	return targets, nil
+}
+
+// FormatCheckResults formats results in lines like "edge2 TCP 1.2ms" or "edge2 UDP - i/o timeout",
+// it's compact enough to be put in termination message of a probe pod
+func FormatCheckResults(results []CheckResult) string {
+	lines := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Err == nil {
+			lines = append(lines, fmt.Sprintf("%s %s %s", r.Name, r.Protocol, r.RTT))
+			continue
+		}
+
+		message := r.Err.Error()
+		if len(message) > maxMessageLength {
+			message = message[:maxMessageLength]
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s %s", r.Name, r.Protocol, noRTT, message))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ParseCheckResults parses output of FormatCheckResults, from is the node where results are made
+func ParseCheckResults(from string, output string) ([]apis.CheckResult, error) {
+	var results []apis.CheckResult
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid check result: %s", line)
+		}
+
+		result := apis.CheckResult{
+			From:     from,
+			To:       fields[0],
+			Protocol: apis.CheckProtocol(fields[1]),
+		}
+
+		if fields[2] == noRTT {
+			if len(fields) == 4 {
+				result.Message = fields[3]
+			}
+		} else {
+			rtt, err := time.ParseDuration(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid check result: %s", line)
+			}
+			result.Succeeded = true
+			result.RTT = &metav1.Duration{Duration: rtt}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package probe measures round-trip time and loss to peers by ICMP echo requests,
+// the peers are pinged by their node addresses, so requests go through tunnels.
+// It also tests connectivity between probe pods by TCP, UDP and ICMP
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	// interval is the time between echo requests sent to a target
+	interval = 200 * time.Millisecond
+)
+
+// lastID makes echo requests of concurrent pings distinguishable, raw sockets receive all replies
+var lastID = uint32(os.Getpid())
+
+// Target is a peer to probe, Address is an IP, it's usually the first node subnet of the peer
+type Target struct {
+	Name    string
+	Address string
+}
+
+type Result struct {
+	Target
+	Sent     int
+	Received int
+	// RTT is the average round-trip time of replied requests
+	RTT time.Duration
+	// Err is the error which stops probing, e.g. the socket can't be opened
+	Err error
+}
+
+// Loss returns the percentage of requests which are not replied
+func (r Result) Loss() int32 {
+	if r.Sent == 0 {
+		return 100
+	}
+	return int32((r.Sent - r.Received) * 100 / r.Sent)
+}
+
+// TargetOf returns the target of a peer with the first address of its node subnets,
+// ok is false if the peer has no valid node subnets
+func TargetOf(name string, nodeSubnets []string) (target Target, ok bool) {
+	for _, subnet := range nodeSubnets {
+		ip := net.ParseIP(strings.Split(subnet, "/")[0])
+		if ip != nil {
+			return Target{Name: name, Address: ip.String()}, true
+		}
+	}
+
+	return Target{}, false
+}
+
+// ProbeAll pings targets concurrently with count echo requests each, a request which is not
+// replied in timeout is lost. Results are in the same order as targets
+func ProbeAll(targets []Target, count int, timeout time.Duration) []Result {
+	results := make([]Result, len(targets))
+
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = Ping(targets[i], count, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Ping sends count echo requests to target one by one, it needs CAP_NET_RAW
+func Ping(target Target, count int, timeout time.Duration) Result {
+	result := Result{Target: target}
+
+	ip := net.ParseIP(target.Address)
+	if ip == nil {
+		result.Err = fmt.Errorf("invalid address: %s", target.Address)
+		return result
+	}
+
+	network, requestType, replyType := "ip4:icmp", byte(icmpv4EchoRequest), byte(icmpv4EchoReply)
+	if ip.To4() == nil {
+		network, requestType, replyType = "ip6:ipv6-icmp", icmpv6EchoRequest, icmpv6EchoReply
+	}
+
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+
+	id := uint16(atomic.AddUint32(&lastID, 1))
+	addr := &net.IPAddr{IP: ip}
+	buf := make([]byte, 1500)
+
+	var total time.Duration
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			time.Sleep(interval)
+		}
+
+		start := time.Now()
+		if _, err = conn.WriteTo(marshalEcho(requestType, id, uint16(seq)), addr); err != nil {
+			result.Err = err
+			break
+		}
+		result.Sent++
+
+		if err = conn.SetReadDeadline(start.Add(timeout)); err != nil {
+			result.Err = err
+			break
+		}
+
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				// timeout, the request is lost
+				break
+			}
+
+			if fromIP, ok := from.(*net.IPAddr); !ok || !fromIP.IP.Equal(ip) {
+				continue
+			}
+
+			if isEchoReply(buf[:n], replyType, id, uint16(seq)) {
+				result.Received++
+				total += time.Since(start)
+				break
+			}
+		}
+	}
+
+	if result.Received > 0 {
+		result.RTT = total / time.Duration(result.Received)
+	}
+
+	return result
+}
+
+// ToPeerProbes converts results to be reported in status of AgentConfig or ConnectorConfig
+func ToPeerProbes(results []Result, probeTime time.Time) []apis.PeerProbe {
+	probes := make([]apis.PeerProbe, 0, len(results))
+	for _, r := range results {
+		probe := apis.PeerProbe{
+			Peer:      r.Name,
+			Address:   r.Address,
+			Loss:      r.Loss(),
+			ProbeTime: metav1.NewTime(probeTime),
+		}
+		if r.Received > 0 {
+			probe.RTT = &metav1.Duration{Duration: r.RTT}
+		}
+		probes = append(probes, probe)
+	}
+
+	return probes
+}
+
+// marshalEcho makes an echo request without payload. Checksum of ICMPv6 is computed by kernel
+func marshalEcho(typ byte, id, seq uint16) []byte {
+	msg := make([]byte, 8)
+	msg[0] = typ
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+
+	if typ == icmpv4EchoRequest {
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+
+	return msg
+}
+
+func isEchoReply(msg []byte, replyType byte, id, seq uint16) bool {
+	return len(msg) >= 8 &&
+		msg[0] == replyType &&
+		binary.BigEndian.Uint16(msg[4:]) == id &&
+		binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
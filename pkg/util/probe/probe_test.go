@@ -0,0 +1,129 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/util/probe"
+)
+
+var _ = Describe("Probe", func() {
+	It("should take the first valid node subnet as target", func() {
+		target, ok := probe.TargetOf("edge1", []string{"invalid", "10.20.8.12/32", "10.20.8.13"})
+		Expect(ok).To(BeTrue())
+		Expect(target).To(Equal(probe.Target{Name: "edge1", Address: "10.20.8.12"}))
+
+		_, ok = probe.TargetOf("edge2", nil)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should compute loss percentage", func() {
+		Expect(probe.Result{Sent: 4, Received: 3}.Loss()).To(Equal(int32(25)))
+		Expect(probe.Result{Sent: 4, Received: 4}.Loss()).To(Equal(int32(0)))
+		Expect(probe.Result{}.Loss()).To(Equal(int32(100)))
+	})
+
+	It("should convert results to peer probes", func() {
+		now := time.Now()
+		probes := probe.ToPeerProbes([]probe.Result{
+			{Target: probe.Target{Name: "edge1", Address: "10.20.8.12"}, Sent: 5, Received: 5, RTT: 20 * time.Millisecond},
+			{Target: probe.Target{Name: "edge2", Address: "10.20.8.13"}, Sent: 5},
+		}, now)
+
+		Expect(probes).To(HaveLen(2))
+		Expect(probes[0].Peer).To(Equal("edge1"))
+		Expect(probes[0].Address).To(Equal("10.20.8.12"))
+		Expect(probes[0].RTT.Duration).To(Equal(20 * time.Millisecond))
+		Expect(probes[0].Loss).To(Equal(int32(0)))
+		Expect(probes[0].ProbeTime.Time).To(Equal(now))
+
+		Expect(probes[1].RTT).To(BeNil())
+		Expect(probes[1].Loss).To(Equal(int32(100)))
+	})
+})
+
+var _ = Describe("Check", func() {
+	It("should pass TCP and UDP checks against a server and report failures", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		port := 18790
+		go func() {
+			defer GinkgoRecover()
+			Expect(probe.Serve(ctx, port)).To(Succeed())
+		}()
+
+		target := probe.Target{Name: "edge1", Address: "127.0.0.1"}
+		protocols := []apis.CheckProtocol{apis.CheckProtocolTCP, apis.CheckProtocolUDP}
+		Eventually(func() error {
+			return probe.Check(target, apis.CheckProtocolTCP, port, time.Second).Err
+		}).Should(Succeed())
+
+		results := probe.CheckAll([]probe.Target{target}, protocols, port, time.Second)
+		Expect(results).To(HaveLen(2))
+		for i, r := range results {
+			Expect(r.Err).To(BeNil())
+			Expect(r.Protocol).To(Equal(protocols[i]))
+		}
+
+		results = probe.CheckAll([]probe.Target{target}, protocols, port+1, 200*time.Millisecond)
+		for _, r := range results {
+			Expect(r.Err).NotTo(BeNil())
+		}
+	})
+
+	It("should parse targets", func() {
+		targets, err := probe.ParseTargets([]string{"edge2=10.233.65.8", "", " service/fabedge-prober=10.96.0.20 "})
+		Expect(err).To(BeNil())
+		Expect(targets).To(Equal([]probe.Target{
+			{Name: "edge2", Address: "10.233.65.8"},
+			{Name: "service/fabedge-prober", Address: "10.96.0.20"},
+		}))
+
+		_, err = probe.ParseTargets([]string{"edge2"})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should format and parse check results", func() {
+		output := probe.FormatCheckResults([]probe.CheckResult{
+			{Target: probe.Target{Name: "edge2"}, Protocol: apis.CheckProtocolTCP, RTT: 1500 * time.Microsecond},
+			{Target: probe.Target{Name: "edge2"}, Protocol: apis.CheckProtocolUDP, Err: fmt.Errorf("read udp: i/o timeout")},
+		})
+
+		results, err := probe.ParseCheckResults("edge1", output)
+		Expect(err).To(BeNil())
+		Expect(results).To(HaveLen(2))
+
+		Expect(results[0].From).To(Equal("edge1"))
+		Expect(results[0].To).To(Equal("edge2"))
+		Expect(results[0].Succeeded).To(BeTrue())
+		Expect(results[0].RTT.Duration).To(Equal(1500 * time.Microsecond))
+
+		Expect(results[1].Protocol).To(Equal(apis.CheckProtocolUDP))
+		Expect(results[1].Succeeded).To(BeFalse())
+		Expect(results[1].RTT).To(BeNil())
+		Expect(results[1].Message).To(Equal("read udp: i/o timeout"))
+
+		_, err = probe.ParseCheckResults("edge1", "edge2 TCP")
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -15,6 +15,8 @@
 package secret
 
 import (
+	"encoding/pem"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -22,9 +24,37 @@ import (
 )
 
 const (
-	KeyCACert           = "ca.crt"
-	KeyCAKey            = "ca.key"
+	KeyCACert = "ca.crt"
+	KeyCAKey  = "ca.key"
+	// KeyCABundle is the key of all trusted CA certs in TLS secrets, other trusted CAs come first
+	// and the signing CA comes last, see certutil.Manager.GetCABundlePEM
+	KeyCABundle         = "ca-bundle.crt"
 	KeyIPSecSecretsFile = "ipsec.secrets"
+
+	// KeyNewCACert and KeyNewCAKey keep the new CA in CA secret while CA is being rotated
+	KeyNewCACert = "new-ca.crt"
+	KeyNewCAKey  = "new-ca.key"
+
+	// KeyCRL is the key of CRLs in CRL secret, there is a CRL for each CA which has a key
+	KeyCRL = "crl.pem"
+)
+
+// CA is rotated in phases which are recorded in annotation of CA secret
+const (
+	AnnotationCARotationPhase = "fabedge.io/ca-rotation-phase"
+	// AnnotationCARotationTime is when the current phase of CA rotation starts
+	AnnotationCARotationTime = "fabedge.io/ca-rotation-time"
+	// AnnotationCARotationPending is how many certificates are not issued by the new CA yet
+	AnnotationCARotationPending = "fabedge.io/ca-rotation-pending"
+
+	// CARotationRequested is set by administrator to start rotation, a new CA will be generated
+	CARotationRequested = "Requested"
+	// CARotationDistributing means the old CA signs certificates and both CAs are distributed to everyone
+	CARotationDistributing = "Distributing"
+	// CARotationReissuing means the new CA signs certificates and those issued by the old CA are reissued gradually
+	CARotationReissuing = "Reissuing"
+	// CARotationRetiring is set by administrator when all certificates are reissued, the old CA will be removed
+	CARotationRetiring = "Retiring"
 )
 
 type TLSSecretBuilder struct {
@@ -33,6 +63,7 @@ type TLSSecretBuilder struct {
 	name        string
 	namespace   string
 	cacertPEM   []byte
+	caBundlePEM []byte
 	certPEM     []byte
 	keyPEM      []byte
 }
@@ -72,6 +103,12 @@ func (b *TLSSecretBuilder) CACertPEM(data []byte) *TLSSecretBuilder {
 	return b
 }
 
+// CABundlePEM sets all trusted CA certs, if it's not set, the CA cert is used
+func (b *TLSSecretBuilder) CABundlePEM(data []byte) *TLSSecretBuilder {
+	b.caBundlePEM = data
+	return b
+}
+
 func (b *TLSSecretBuilder) EncodeCACert(data []byte) *TLSSecretBuilder {
 	b.cacertPEM = certutil.EncodeCertPEM(data)
 	return b
@@ -98,7 +135,16 @@ func (b *TLSSecretBuilder) EncodeKey(data []byte) *TLSSecretBuilder {
 }
 
 func (b *TLSSecretBuilder) Build() corev1.Secret {
+	caBundlePEM := b.caBundlePEM
+	if len(caBundlePEM) == 0 {
+		caBundlePEM = b.cacertPEM
+	}
+
 	return corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        b.name,
 			Namespace:   b.namespace,
@@ -110,7 +156,24 @@ func (b *TLSSecretBuilder) Build() corev1.Secret {
 			corev1.TLSCertKey:       b.certPEM,
 			corev1.TLSPrivateKeyKey: b.keyPEM,
 			KeyCACert:               b.cacertPEM,
-			KeyIPSecSecretsFile:     []byte(": RSA tls.key\n"),
+			KeyCABundle:             caBundlePEM,
+			KeyIPSecSecretsFile:     ipsecSecretsFile(b.keyPEM),
 		},
 	}
 }
+
+// ipsecSecretsFile makes content of ipsec.secrets for strongswan, the type of private key
+// depends on its PEM block type
+func ipsecSecretsFile(keyPEM []byte) []byte {
+	keyType := "RSA"
+	if block, _ := pem.Decode(keyPEM); block != nil {
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			keyType = "ECDSA"
+		case "PRIVATE KEY":
+			keyType = "PKCS8"
+		}
+	}
+
+	return []byte(": " + keyType + " tls.key\n")
+}
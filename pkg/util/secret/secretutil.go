@@ -14,7 +14,14 @@
 
 package secret
 
-import corev1 "k8s.io/api/core/v1"
+import (
+	"bytes"
+	"crypto/x509"
+
+	corev1 "k8s.io/api/core/v1"
+
+	certutil "github.com/fabedge/fabedge/pkg/util/cert"
+)
 
 // GetCACert get the ca cert from the secret by the key ca.crt
 func GetCACert(secret corev1.Secret) []byte {
@@ -40,3 +47,70 @@ func GetCert(secret corev1.Secret) []byte {
 func GetCertAndKey(secret corev1.Secret) ([]byte, []byte) {
 	return secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
 }
+
+// GetCABundle get all trusted ca certs from the secret by the key ca-bundle.crt
+func GetCABundle(secret corev1.Secret) []byte {
+	return secret.Data[KeyCABundle]
+}
+
+// GetCRL get CRLs from the secret by the key crl.pem
+func GetCRL(secret corev1.Secret) []byte {
+	return secret.Data[KeyCRL]
+}
+
+// GetCARotationPhase returns the phase of CA rotation of CA secret, empty means CA is not being rotated
+func GetCARotationPhase(secret corev1.Secret) string {
+	return secret.Annotations[AnnotationCARotationPhase]
+}
+
+// GetSigningCA returns the cert/key of CA which signs certificates. The new CA signs
+// certificates once the rotation reaches Reissuing phase
+func GetSigningCA(secret corev1.Secret) ([]byte, []byte) {
+	switch GetCARotationPhase(secret) {
+	case CARotationReissuing, CARotationRetiring:
+		if len(secret.Data[KeyNewCACert]) > 0 && len(secret.Data[KeyNewCAKey]) > 0 {
+			return secret.Data[KeyNewCACert], secret.Data[KeyNewCAKey]
+		}
+	}
+
+	return GetCA(secret)
+}
+
+// GetTrustedCACerts returns the cert of CAs which are trusted besides the signing CA, it's
+// the new CA before Reissuing phase and the old CA after, nil if CA is not being rotated
+func GetTrustedCACerts(secret corev1.Secret) [][]byte {
+	newCACert := secret.Data[KeyNewCACert]
+	if len(newCACert) == 0 {
+		return nil
+	}
+
+	switch GetCARotationPhase(secret) {
+	case CARotationDistributing:
+		return [][]byte{newCACert}
+	case CARotationReissuing, CARotationRetiring:
+		return [][]byte{secret.Data[KeyCACert]}
+	default:
+		return nil
+	}
+}
+
+// IsCAUpToDate tells if the TLS secret has the same CA cert and CA bundle as manager and its cert
+// is issued by the signing CA of manager. If not, the secret has to be regenerated when CA is rotated
+func IsCAUpToDate(secret corev1.Secret, manager certutil.Manager) bool {
+	if !bytes.Equal(GetCACert(secret), manager.GetCACertPEM()) ||
+		!bytes.Equal(GetCABundle(secret), manager.GetCABundlePEM()) {
+		return false
+	}
+
+	certDER, err := certutil.DecodePEM(GetCert(secret))
+	if err != nil {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return false
+	}
+
+	return manager.IsIssuedByCA(cert)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/common/netconf"
+)
+
+const (
+	tunnelsConfigKey     = "tunnels.yaml"
+	agentConfigMapPrefix = "fabedge-agent-config-"
+)
+
+// Loader loads sources of topology from kubernetes: tunnels configs in configmaps, AgentConfigs
+// and ConnectorConfigs of Namespace, communities and clusters. It serves the topology by HTTP too,
+// in JSON by default or in DOT if query format is dot
+type Loader struct {
+	Namespace string
+	// AgentConfigs and ConnectorConfigs tell if AgentConfigs and ConnectorConfigs are loaded,
+	// they are preferred to configmaps since they carry status. Missing CRDs are not errors
+	AgentConfigs     bool
+	ConnectorConfigs bool
+	Client           client.Client
+}
+
+func (l Loader) Load(ctx context.Context) (Topology, error) {
+	configs := make(map[string]Config)
+
+	var configMaps corev1.ConfigMapList
+	if err := l.Client.List(ctx, &configMaps, client.InNamespace(l.Namespace)); err != nil {
+		return Topology{}, fmt.Errorf("failed to list configmaps: %s", err)
+	}
+	for _, cm := range configMaps.Items {
+		data, ok := cm.Data[tunnelsConfigKey]
+		if !ok {
+			continue
+		}
+
+		conf, err := parseNetworkConf(data)
+		if err != nil {
+			return Topology{}, fmt.Errorf("failed to parse tunnels configuration in configmap %s: %s", cm.Name, err)
+		}
+
+		cfg := Config{Endpoint: conf.Endpoint, Peers: conf.Peers}
+		if strings.HasPrefix(cm.Name, agentConfigMapPrefix) {
+			cfg.Node = strings.TrimPrefix(cm.Name, agentConfigMapPrefix)
+		}
+		configs[conf.Name] = cfg
+	}
+
+	if l.AgentConfigs {
+		var agentConfigs apis.AgentConfigList
+		err := l.Client.List(ctx, &agentConfigs, client.InNamespace(l.Namespace))
+		if err != nil && !meta.IsNoMatchError(err) {
+			return Topology{}, fmt.Errorf("failed to list agent configs: %s", err)
+		}
+		for _, ac := range agentConfigs.Items {
+			conf, err := parseNetworkConf(ac.Spec.Tunnels)
+			if err != nil {
+				return Topology{}, fmt.Errorf("failed to parse tunnels configuration in agent config %s: %s", ac.Name, err)
+			}
+
+			configs[conf.Name] = Config{
+				Endpoint:      conf.Endpoint,
+				Node:          ac.Name,
+				Status:        conditionStatus(ac.Status.Conditions, apis.AgentConfigConditionSynced),
+				Peers:         conf.Peers,
+				TunnelHistory: ac.Status.TunnelHistory,
+				Probes:        ac.Status.Probes,
+			}
+		}
+	}
+
+	if l.ConnectorConfigs {
+		var connectorConfigs apis.ConnectorConfigList
+		err := l.Client.List(ctx, &connectorConfigs, client.InNamespace(l.Namespace))
+		if err != nil && !meta.IsNoMatchError(err) {
+			return Topology{}, fmt.Errorf("failed to list connector configs: %s", err)
+		}
+		for _, cc := range connectorConfigs.Items {
+			configs[cc.Spec.Endpoint.Name] = Config{
+				Endpoint:      cc.Spec.Endpoint,
+				Status:        conditionStatus(cc.Status.Conditions, apis.ConnectorConfigConditionSynced),
+				Peers:         cc.Spec.Peers,
+				TunnelHistory: cc.Status.TunnelHistory,
+				Probes:        cc.Status.Probes,
+			}
+		}
+	}
+
+	var sources Sources
+	for _, cfg := range configs {
+		sources.Configs = append(sources.Configs, cfg)
+	}
+
+	var communities apis.CommunityList
+	if err := l.Client.List(ctx, &communities); err != nil {
+		return Topology{}, fmt.Errorf("failed to list communities: %s", err)
+	}
+	sources.Communities = communities.Items
+
+	var clusters apis.ClusterList
+	if err := l.Client.List(ctx, &clusters); err != nil {
+		return Topology{}, fmt.Errorf("failed to list clusters: %s", err)
+	}
+	sources.Clusters = clusters.Items
+
+	return Build(sources), nil
+}
+
+func (l Loader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatDOT {
+		http.Error(w, fmt.Sprintf("unknown format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	topology, err := l.Load(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = topology.Write(&buf, format); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == FormatDOT {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+func parseNetworkConf(data string) (netconf.NetworkConf, error) {
+	var conf netconf.NetworkConf
+	err := yaml.Unmarshal([]byte(data), &conf)
+	return conf, err
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	FormatJSON = "json"
+	FormatDOT  = "dot"
+)
+
+var tunnelColors = map[string]string{
+	StateUp:      "green",
+	StateDown:    "red",
+	StateUnknown: "gray",
+}
+
+// Write writes topology in format, which is json or dot
+func (t Topology) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return t.WriteJSON(w)
+	case FormatDOT:
+		return t.WriteDOT(w)
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+func (t Topology) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(t)
+}
+
+// WriteDOT writes topology as an undirected graph of Graphviz, endpoints of a cluster are put
+// in a subgraph of the cluster, tunnels are colored by their states and communities are linked
+// to their members by dotted lines
+func (t Topology) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "graph fabedge {")
+	fmt.Fprintln(bw, "  node [shape=box];")
+
+	// nodes are sorted by IDs, so endpoints of a cluster are written together
+	endpoints := make(map[string][]Node)
+	var others []Node
+	for _, node := range t.Nodes {
+		switch {
+		case node.Kind == KindCluster:
+		case node.Cluster != "":
+			endpoints[node.Cluster] = append(endpoints[node.Cluster], node)
+		default:
+			others = append(others, node)
+		}
+	}
+
+	for _, node := range t.Nodes {
+		if node.Kind != KindCluster {
+			continue
+		}
+
+		fmt.Fprintf(bw, "  subgraph %q {\n", "cluster_"+node.Title)
+		fmt.Fprintf(bw, "    label=%q;\n", withStatus(node.Title, node.Status))
+		for _, endpoint := range endpoints[node.Title] {
+			fmt.Fprintf(bw, "    %s\n", dotNode(endpoint))
+		}
+		fmt.Fprintln(bw, "  }")
+	}
+
+	for _, node := range others {
+		fmt.Fprintf(bw, "  %s\n", dotNode(node))
+	}
+
+	for _, edge := range t.Edges {
+		var attrs []string
+		switch edge.Kind {
+		case EdgeTunnel:
+			attrs = append(attrs, "color="+tunnelColors[edge.State])
+			if label := tunnelLabel(edge); label != "" {
+				attrs = append(attrs, fmt.Sprintf("label=%q", label))
+			}
+		case EdgeMember:
+			attrs = append(attrs, "style=dotted")
+		}
+
+		fmt.Fprintf(bw, "  %q -- %q [%s];\n", edge.Source, edge.Target, strings.Join(attrs, " "))
+	}
+
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+func dotNode(node Node) string {
+	label := node.Title
+	if node.Node != "" && node.Node != node.Title {
+		label += "\\n" + node.Node
+	}
+
+	attrs := []string{fmt.Sprintf("label=\"%s\"", withStatus(label, node.Status))}
+	switch node.Kind {
+	case KindConnector:
+		attrs = append(attrs, "shape=box3d")
+	case KindCommunity:
+		attrs = append(attrs, "shape=ellipse", "style=dashed")
+	case KindEndpoint:
+		attrs = append(attrs, "style=dashed")
+	}
+	if node.Status == string(metav1.ConditionFalse) {
+		attrs = append(attrs, "color=red")
+	}
+
+	return fmt.Sprintf("%q [%s];", node.ID, strings.Join(attrs, " "))
+}
+
+func withStatus(label, status string) string {
+	if status == "" {
+		return label
+	}
+	return fmt.Sprintf("%s (%s)", label, status)
+}
+
+func tunnelLabel(edge Edge) string {
+	var parts []string
+	if edge.RTT != "" {
+		parts = append(parts, edge.RTT)
+	}
+	if edge.Loss != nil && *edge.Loss > 0 {
+		parts = append(parts, fmt.Sprintf("loss %d%%", *edge.Loss))
+	}
+	return strings.Join(parts, " ")
+}
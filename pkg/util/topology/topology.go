@@ -0,0 +1,270 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package topology renders the fabric, i.e. clusters, connectors, edge nodes, communities and
+// tunnels between endpoints, as a graph of nodes and edges, which can be written in JSON or DOT
+package topology
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+)
+
+const (
+	KindCluster   = "cluster"
+	KindConnector = "connector"
+	KindEdge      = "edge"
+	KindCommunity = "community"
+	// KindEndpoint is the kind of endpoints which are only known by names, e.g. missing members of communities
+	KindEndpoint = "endpoint"
+
+	EdgeTunnel = "tunnel"
+	EdgeMember = "member"
+
+	StateUp      = "up"
+	StateDown    = "down"
+	StateUnknown = "unknown"
+
+	clusterPrefix   = "cluster/"
+	communityPrefix = "community/"
+)
+
+// Topology is a graph of the fabric, field names of nodes and edges follow the node graph
+// panel of Grafana, so it can be visualized without transformations
+type Topology struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+type Node struct {
+	// ID is the endpoint name for connectors and edge nodes, cluster/<name> for clusters
+	// and community/<name> for communities
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	// Cluster is the cluster which an endpoint belongs to
+	Cluster string `json:"cluster,omitempty"`
+	// Node is the kubernetes node of an agent
+	Node string `json:"node,omitempty"`
+	// Status is the status of Ready condition of clusters and communities,
+	// and of Synced condition of agents and connectors
+	Status string `json:"status,omitempty"`
+}
+
+type Edge struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Kind   string `json:"kind"`
+	// State tells whether a tunnel is up, it's down if any side of the tunnel reports it's down
+	State string `json:"state,omitempty"`
+	// RTT and Loss are the worst results of probes of both sides of a tunnel
+	RTT  string `json:"rtt,omitempty"`
+	Loss *int32 `json:"loss,omitempty"`
+}
+
+// Config is the tunnels config of an agent or a connector with the status it reports
+type Config struct {
+	Endpoint apis.Endpoint
+	// Node is the node of an agent, it's empty for connectors
+	Node          string
+	Status        string
+	Peers         []apis.Endpoint
+	TunnelHistory []apis.TunnelSummary
+	Probes        []apis.PeerProbe
+}
+
+// Sources are what a topology is built from
+type Sources struct {
+	Configs     []Config
+	Communities []apis.Community
+	Clusters    []apis.Cluster
+}
+
+type tunnelState struct {
+	edge     Edge
+	reported bool
+	down     bool
+	rtt      time.Duration
+}
+
+// Build builds a topology from sources, nodes and edges are sorted by IDs. Endpoints which are only
+// peers of configs, e.g. endpoints of other clusters, are included, and so are clusters which are
+// only known by names of endpoints
+func Build(sources Sources) Topology {
+	nodes := make(map[string]Node)
+	addNode := func(node Node, overwrite bool) {
+		if _, ok := nodes[node.ID]; ok && !overwrite {
+			return
+		}
+		nodes[node.ID] = node
+	}
+
+	for _, cluster := range sources.Clusters {
+		addNode(Node{
+			ID:     clusterPrefix + cluster.Name,
+			Kind:   KindCluster,
+			Title:  cluster.Name,
+			Status: conditionStatus(cluster.Status.Conditions, apis.ClusterConditionReady),
+		}, true)
+	}
+
+	tunnels := make(map[string]*tunnelState)
+	for _, cfg := range sources.Configs {
+		node := endpointNode(cfg.Endpoint)
+		node.Node, node.Status = cfg.Node, cfg.Status
+		addNode(node, true)
+
+		for _, peer := range cfg.Peers {
+			addNode(endpointNode(peer), false)
+
+			id, source, target := tunnelID(cfg.Endpoint.Name, peer.Name)
+			if _, ok := tunnels[id]; !ok {
+				tunnels[id] = &tunnelState{edge: Edge{ID: id, Source: source, Target: target, Kind: EdgeTunnel}}
+			}
+		}
+	}
+
+	// states are applied after all tunnels are found, peers which are removed from configs are still in history for a while
+	for _, cfg := range sources.Configs {
+		for _, summary := range cfg.TunnelHistory {
+			id, _, _ := tunnelID(cfg.Endpoint.Name, summary.Peer)
+			if tunnel, ok := tunnels[id]; ok {
+				tunnel.reported = true
+				tunnel.down = tunnel.down || !summary.Up
+			}
+		}
+
+		for _, probe := range cfg.Probes {
+			id, _, _ := tunnelID(cfg.Endpoint.Name, probe.Peer)
+			tunnel, ok := tunnels[id]
+			if !ok {
+				continue
+			}
+
+			if tunnel.edge.Loss == nil || probe.Loss > *tunnel.edge.Loss {
+				loss := probe.Loss
+				tunnel.edge.Loss = &loss
+			}
+			if probe.RTT != nil && probe.RTT.Duration > tunnel.rtt {
+				tunnel.rtt = probe.RTT.Duration
+			}
+		}
+	}
+
+	var edges []Edge
+	for _, tunnel := range tunnels {
+		edge := tunnel.edge
+		switch {
+		case tunnel.down:
+			edge.State = StateDown
+		case tunnel.reported:
+			edge.State = StateUp
+		default:
+			edge.State = StateUnknown
+		}
+		if tunnel.rtt > 0 {
+			edge.RTT = tunnel.rtt.String()
+		}
+		edges = append(edges, edge)
+	}
+
+	for _, community := range sources.Communities {
+		id := communityPrefix + community.Name
+		addNode(Node{
+			ID:     id,
+			Kind:   KindCommunity,
+			Title:  community.Name,
+			Status: conditionStatus(community.Status.Conditions, apis.ConditionReady),
+		}, true)
+
+		for _, member := range community.Spec.Members {
+			addNode(Node{ID: member, Kind: KindEndpoint, Title: member, Cluster: ClusterOf(member)}, false)
+			edges = append(edges, Edge{ID: id + "--" + member, Source: id, Target: member, Kind: EdgeMember})
+		}
+	}
+
+	var clusters []string
+	for _, node := range nodes {
+		if node.Cluster != "" {
+			clusters = append(clusters, node.Cluster)
+		}
+	}
+	for _, name := range clusters {
+		addNode(Node{ID: clusterPrefix + name, Kind: KindCluster, Title: name}, false)
+	}
+
+	var topology Topology
+	for _, node := range nodes {
+		topology.Nodes = append(topology.Nodes, node)
+	}
+	sort.Slice(topology.Nodes, func(i, j int) bool {
+		return topology.Nodes[i].ID < topology.Nodes[j].ID
+	})
+
+	topology.Edges = edges
+	sort.Slice(topology.Edges, func(i, j int) bool {
+		return topology.Edges[i].ID < topology.Edges[j].ID
+	})
+
+	return topology
+}
+
+// ClusterOf returns the cluster name of an endpoint, endpoint names are in format of "cluster.node"
+func ClusterOf(endpointName string) string {
+	i := strings.Index(endpointName, ".")
+	if i <= 0 {
+		return ""
+	}
+
+	return endpointName[:i]
+}
+
+func endpointNode(endpoint apis.Endpoint) Node {
+	kind := KindEdge
+	if endpoint.Type == apis.Connector {
+		kind = KindConnector
+	}
+
+	return Node{
+		ID:      endpoint.Name,
+		Kind:    kind,
+		Title:   endpoint.Name,
+		Cluster: ClusterOf(endpoint.Name),
+	}
+}
+
+// tunnelID returns the ID, source and target of the tunnel between two endpoints, tunnels are
+// undirected, so the source is always the smaller name
+func tunnelID(name1, name2 string) (string, string, string) {
+	if name2 < name1 {
+		name1, name2 = name2, name1
+	}
+
+	return name1 + "--" + name2, name1, name2
+}
+
+func conditionStatus(conditions []metav1.Condition, conditionType string) string {
+	condition := meta.FindStatusCondition(conditions, conditionType)
+	if condition == nil {
+		return ""
+	}
+	return string(condition.Status)
+}
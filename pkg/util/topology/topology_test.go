@@ -0,0 +1,148 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apis "github.com/fabedge/fabedge/pkg/apis/v1alpha1"
+	"github.com/fabedge/fabedge/pkg/util/topology"
+)
+
+var _ = Describe("Topology", func() {
+	connector := apis.Endpoint{Name: "fabedge.connector", Type: apis.Connector}
+	edge1 := apis.Endpoint{Name: "fabedge.edge1", Type: apis.EdgeNode}
+	edge2 := apis.Endpoint{Name: "fabedge.edge2", Type: apis.EdgeNode}
+	remote := apis.Endpoint{Name: "beijing.edge1", Type: apis.EdgeNode}
+
+	sources := topology.Sources{
+		Configs: []topology.Config{
+			{
+				Endpoint:      connector,
+				Status:        "True",
+				Peers:         []apis.Endpoint{edge1, edge2},
+				TunnelHistory: []apis.TunnelSummary{{Peer: "fabedge.edge1", Up: true}, {Peer: "fabedge.edge2", Up: false}},
+			},
+			{
+				Endpoint:      edge1,
+				Node:          "edge1",
+				Status:        "True",
+				Peers:         []apis.Endpoint{connector, edge2, remote},
+				TunnelHistory: []apis.TunnelSummary{{Peer: "fabedge.connector", Up: true}, {Peer: "fabedge.edge9", Up: false}},
+				Probes: []apis.PeerProbe{
+					{Peer: "fabedge.connector", RTT: &metav1.Duration{Duration: 30 * time.Millisecond}, Loss: 20},
+					{Peer: "fabedge.edge2", RTT: &metav1.Duration{Duration: 3 * time.Millisecond}, Loss: 0},
+				},
+			},
+			{Endpoint: edge2, Node: "edge2", Status: "False", Peers: []apis.Endpoint{connector, edge1}},
+		},
+		Communities: []apis.Community{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "edges"},
+				Spec:       apis.CommunitySpec{Members: []string{"fabedge.edge1", "fabedge.edge2", "fabedge.edge3"}},
+			},
+		},
+		Clusters: []apis.Cluster{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "fabedge"},
+				Status: apis.ClusterStatus{
+					Conditions: []metav1.Condition{{Type: apis.ClusterConditionReady, Status: metav1.ConditionTrue}},
+				},
+			},
+		},
+	}
+
+	loss := func(v int32) *int32 { return &v }
+
+	It("should build nodes of clusters, endpoints and communities", func() {
+		t := topology.Build(sources)
+
+		Expect(t.Nodes).To(Equal([]topology.Node{
+			{ID: "beijing.edge1", Kind: topology.KindEdge, Title: "beijing.edge1", Cluster: "beijing"},
+			{ID: "cluster/beijing", Kind: topology.KindCluster, Title: "beijing"},
+			{ID: "cluster/fabedge", Kind: topology.KindCluster, Title: "fabedge", Status: "True"},
+			{ID: "community/edges", Kind: topology.KindCommunity, Title: "edges"},
+			{ID: "fabedge.connector", Kind: topology.KindConnector, Title: "fabedge.connector", Cluster: "fabedge", Status: "True"},
+			{ID: "fabedge.edge1", Kind: topology.KindEdge, Title: "fabedge.edge1", Cluster: "fabedge", Node: "edge1", Status: "True"},
+			{ID: "fabedge.edge2", Kind: topology.KindEdge, Title: "fabedge.edge2", Cluster: "fabedge", Node: "edge2", Status: "False"},
+			{ID: "fabedge.edge3", Kind: topology.KindEndpoint, Title: "fabedge.edge3", Cluster: "fabedge"},
+		}))
+	})
+
+	It("should merge states of tunnels reported by both sides", func() {
+		t := topology.Build(sources)
+
+		Expect(t.Edges).To(Equal([]topology.Edge{
+			{ID: "beijing.edge1--fabedge.edge1", Source: "beijing.edge1", Target: "fabedge.edge1", Kind: topology.EdgeTunnel, State: topology.StateUnknown},
+			{ID: "community/edges--fabedge.edge1", Source: "community/edges", Target: "fabedge.edge1", Kind: topology.EdgeMember},
+			{ID: "community/edges--fabedge.edge2", Source: "community/edges", Target: "fabedge.edge2", Kind: topology.EdgeMember},
+			{ID: "community/edges--fabedge.edge3", Source: "community/edges", Target: "fabedge.edge3", Kind: topology.EdgeMember},
+			{ID: "fabedge.connector--fabedge.edge1", Source: "fabedge.connector", Target: "fabedge.edge1", Kind: topology.EdgeTunnel, State: topology.StateUp, RTT: "30ms", Loss: loss(20)},
+			{ID: "fabedge.connector--fabedge.edge2", Source: "fabedge.connector", Target: "fabedge.edge2", Kind: topology.EdgeTunnel, State: topology.StateDown},
+			{ID: "fabedge.edge1--fabedge.edge2", Source: "fabedge.edge1", Target: "fabedge.edge2", Kind: topology.EdgeTunnel, State: topology.StateUnknown, RTT: "3ms", Loss: loss(0)},
+		}))
+	})
+
+	It("should write topology in JSON", func() {
+		var buf bytes.Buffer
+		Expect(topology.Build(sources).Write(&buf, topology.FormatJSON)).To(Succeed())
+
+		var t topology.Topology
+		Expect(json.Unmarshal(buf.Bytes(), &t)).To(Succeed())
+		Expect(t).To(Equal(topology.Build(sources)))
+	})
+
+	It("should write topology in DOT", func() {
+		t := topology.Build(topology.Sources{
+			Configs: []topology.Config{
+				{Endpoint: connector, Peers: []apis.Endpoint{edge1}, TunnelHistory: []apis.TunnelSummary{{Peer: "fabedge.edge1", Up: true}}},
+				{Endpoint: edge1, Node: "edge1", Status: "False", Probes: []apis.PeerProbe{{Peer: "fabedge.connector", RTT: &metav1.Duration{Duration: 30 * time.Millisecond}, Loss: 20}}},
+			},
+			Communities: []apis.Community{
+				{ObjectMeta: metav1.ObjectMeta{Name: "edges"}, Spec: apis.CommunitySpec{Members: []string{"fabedge.edge1"}}},
+			},
+		})
+
+		var buf bytes.Buffer
+		Expect(t.Write(&buf, topology.FormatDOT)).To(Succeed())
+		Expect(buf.String()).To(Equal(`graph fabedge {
+  node [shape=box];
+  subgraph "cluster_fabedge" {
+    label="fabedge";
+    "fabedge.connector" [label="fabedge.connector" shape=box3d];
+    "fabedge.edge1" [label="fabedge.edge1\nedge1 (False)" color=red];
+  }
+  "community/edges" [label="edges" shape=ellipse style=dashed];
+  "community/edges" -- "fabedge.edge1" [style=dotted];
+  "fabedge.connector" -- "fabedge.edge1" [color=green label="30ms loss 20%"];
+}
+`))
+	})
+
+	It("should reject unknown formats", func() {
+		Expect(topology.Topology{}.Write(&bytes.Buffer{}, "yaml")).NotTo(Succeed())
+	})
+
+	It("should get cluster names from endpoint names", func() {
+		Expect(topology.ClusterOf("beijing.edge1")).To(Equal("beijing"))
+		Expect(topology.ClusterOf("edge1")).To(Equal(""))
+	})
+})
@@ -0,0 +1,140 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing exports OpenTelemetry spans by OTLP. Until Setup is called with an
+// endpoint, spans are not recorded, so instrumented code costs almost nothing
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const instrumentationName = "github.com/fabedge/fabedge"
+
+type Config struct {
+	// Endpoint is the address of OTLP gRPC receiver, e.g. otel-collector.observability:4317,
+	// tracing is disabled if it's empty
+	Endpoint string
+	// Insecure makes spans exported without TLS
+	Insecure bool
+	// SampleRatio is the ratio of traces started here to sample, traces started by remote
+	// parents follow the decisions of parents
+	SampleRatio float64
+	ServiceName string
+	// Attributes are put in the resource of spans, e.g. name of cluster
+	Attributes map[string]string
+}
+
+// Setup makes spans exported to cfg.Endpoint and trace context propagated by W3C headers.
+// The returned function flushes spans which are not exported yet, it should be called before exit
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(cfg.ServiceName)}
+	for key, value := range cfg.Attributes {
+		attrs = append(attrs, attribute.String(key, value))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span which is a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End marks span as failed if err is not nil, then ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject puts trace context of ctx in header of an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns a context with trace context in header of an incoming request
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// WrapReconciler makes each reconcile of a controller a span, spans made in the reconcile,
+// e.g. by requests to API server of host cluster, are its children
+func WrapReconciler(controllerName string, r reconcile.Reconciler) reconcile.Reconciler {
+	return tracedReconciler{
+		name: controllerName,
+		next: r,
+	}
+}
+
+type tracedReconciler struct {
+	name string
+	next reconcile.Reconciler
+}
+
+func (r tracedReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	ctx, span := Start(ctx, r.name+"/Reconcile", trace.WithAttributes(
+		attribute.String("controller", r.name),
+		attribute.String("request.namespace", request.Namespace),
+		attribute.String("request.name", request.Name),
+	))
+
+	result, err := r.next.Reconcile(ctx, request)
+	if result.Requeue || result.RequeueAfter > 0 {
+		span.SetAttributes(
+			attribute.Bool("requeue", true),
+			attribute.String("requeue_after", result.RequeueAfter.String()),
+		)
+	}
+	End(span, err)
+
+	return result, err
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 FabEdge Team
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/fabedge/fabedge/pkg/util/tracing"
+)
+
+var _ = Describe("Tracing", func() {
+	var recorder *tracetest.SpanRecorder
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	It("should do nothing if endpoint is not provided", func() {
+		shutdown, err := tracing.Setup(context.Background(), tracing.Config{})
+		Expect(err).Should(BeNil())
+		Expect(shutdown(context.Background())).Should(Succeed())
+	})
+
+	It("should make each reconcile a span", func() {
+		var childOf trace.SpanContext
+		r := tracing.WrapReconciler("test-controller", reconcile.Func(func(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+			childOf = trace.SpanContextFromContext(ctx)
+			return reconcile.Result{}, fmt.Errorf("node not found")
+		}))
+
+		_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKey{Name: "edge1"}})
+		Expect(err).Should(HaveOccurred())
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name()).To(Equal("test-controller/Reconcile"))
+		Expect(spans[0].SpanContext().SpanID()).To(Equal(childOf.SpanID()))
+		Expect(spans[0].Attributes()).To(ContainElement(attribute.String("request.name", "edge1")))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+		Expect(spans[0].Status().Description).To(Equal("node not found"))
+	})
+
+	It("should propagate trace context by headers", func() {
+		ctx, parent := tracing.Start(context.Background(), "client")
+		header := http.Header{}
+		tracing.Inject(ctx, header)
+		parent.End()
+		Expect(header.Get("traceparent")).NotTo(BeEmpty())
+
+		_, child := tracing.Start(tracing.Extract(context.Background(), header), "server")
+		tracing.End(child, nil)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[1].Parent().SpanID()).To(Equal(spans[0].SpanContext().SpanID()))
+		Expect(spans[1].SpanContext().TraceID()).To(Equal(spans[0].SpanContext().TraceID()))
+		Expect(spans[1].Status().Code).To(Equal(codes.Unset))
+	})
+})